@@ -1,12 +1,32 @@
 # Sandbox Runner for Go scripts
 # Secure, isolated environment for custom Go programs
 
-FROM golang:1.21-alpine
+# BASE_IMAGE can point at an internal mirror, pinned by digest
+# (name@sha256:...), as long as it provides the same toolchain and distribution.
+ARG BASE_IMAGE=golang:1.21-alpine
+# ENTRYPOINT_BUILD_IMAGE compiles the entrypoint that checks the environment
+# contract and records how the script ended (ExecConfig.Entrypoint); it can
+# point at a mirror too.
+ARG ENTRYPOINT_BUILD_IMAGE=golang:1.21-alpine
+FROM ${ENTRYPOINT_BUILD_IMAGE} AS entrypoint
+WORKDIR /src
+COPY go/go.mod go/go.sum ./
+RUN go mod download
+COPY go/sandbox ./sandbox
+COPY go/cmd/sandbox-entrypoint ./cmd/sandbox-entrypoint
+RUN CGO_ENABLED=0 go build -trimpath -o /sandbox-entrypoint ./cmd/sandbox-entrypoint
 
-# Install minimal system dependencies
+FROM ${BASE_IMAGE}
+
+# The exact toolchain version, set by the golang image, which the
+# orchestrator records in JobResult.GoVersion
+LABEL datamortem.go.version="${GOLANG_VERSION}"
+
+# Install minimal system dependencies (yara for sandbox.YaraScan)
 RUN apk add --no-cache \
     git \
-    ca-certificates
+    ca-certificates \
+    yara
 
 # Create non-root user for script execution
 RUN adduser -D -u 1000 -s /bin/sh sandbox && \
@@ -17,21 +37,45 @@ RUN adduser -D -u 1000 -s /bin/sh sandbox && \
 RUN mkdir -p /home/sandbox/go && \
     chown -R sandbox:sandbox /home/sandbox/go
 
+# Ship the datamortem Go SDK so scripts can import it offline
+COPY --chown=sandbox:sandbox go/go.mod go/go.sum /opt/datamortem-sdk/
+COPY --chown=sandbox:sandbox go/sandbox /opt/datamortem-sdk/sandbox
+
 # Set working directory
 WORKDIR /workspace
 
+# Install the entrypoint, static so that it runs on any distribution
+COPY --from=entrypoint /sandbox-entrypoint /usr/local/bin/sandbox-entrypoint
+
 # Switch to non-root user
 USER sandbox
 
-# Pre-download common modules (speeds up execution)
-RUN go install github.com/Velocidex/ordereddict@latest && \
-    go clean -cache -modcache
-
-# Environment variables
+# Environment variables, set before the downloads below so that they land
+# in the module cache the jobs use
 ENV GO111MODULE=on \
     GOPROXY=https://proxy.golang.org,direct \
     GOPATH=/home/sandbox/go \
+    GOMODCACHE=/home/sandbox/go/pkg/mod \
     PATH="/home/sandbox/go/bin:${PATH}"
 
+# Pre-download common modules (speeds up execution); team-specific module
+# sets belong in flavor images built by the orchestrator (Runner.Flavors).
+# govulncheck scans the dependencies of scripts (Runner.VulnCheck).
+RUN go install github.com/Velocidex/ordereddict@latest && \
+    go install golang.org/x/vuln/cmd/govulncheck@v1.0.4 && \
+    go clean -cache -modcache
+
+# Cache the SDK dependencies so scripts build with --network none
+RUN cd /opt/datamortem-sdk && go mod download
+
+# Fail the build unless a script importing the SDK builds from that cache
+# alone
+RUN mkdir /tmp/sdk-check && cd /tmp/sdk-check && \
+    printf 'module check\n\ngo 1.21\n\nrequire github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0\n\nreplace github.com/St0n14/datamortem/services/sandbox-runners/go => /opt/datamortem-sdk\n' > go.mod && \
+    printf 'package main\n\nimport _ "github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"\n\nfunc main() {}\n' > main.go && \
+    cp /opt/datamortem-sdk/go.sum . && \
+    GOPROXY=off GOFLAGS=-mod=mod go build -o /dev/null . && \
+    cd / && rm -rf /tmp/sdk-check && go clean -cache
+
 # Default command (overridden at runtime)
 CMD ["go", "version"]
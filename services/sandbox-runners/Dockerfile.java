@@ -0,0 +1,57 @@
+# Sandbox Runner for Java scripts
+# Secure, isolated environment for custom JVM parsers
+
+# BASE_IMAGE can point at an internal mirror, pinned by digest
+# (name@sha256:...), as long as it provides the same toolchain and distribution.
+ARG BASE_IMAGE=eclipse-temurin:21-jdk-jammy
+# ENTRYPOINT_BUILD_IMAGE compiles the entrypoint that checks the environment
+# contract and records how the script ended (ExecConfig.Entrypoint); it can
+# point at a mirror too.
+ARG ENTRYPOINT_BUILD_IMAGE=golang:1.21-alpine
+FROM ${ENTRYPOINT_BUILD_IMAGE} AS entrypoint
+WORKDIR /src
+COPY go/go.mod go/go.sum ./
+RUN go mod download
+COPY go/sandbox ./sandbox
+COPY go/cmd/sandbox-entrypoint ./cmd/sandbox-entrypoint
+RUN CGO_ENABLED=0 go build -trimpath -o /sandbox-entrypoint ./cmd/sandbox-entrypoint
+
+FROM ${BASE_IMAGE}
+
+# Create non-root user for script execution
+RUN useradd -m -u 1000 -s /bin/bash sandbox && \
+    mkdir -p /workspace /output /opt/datamortem-java/lib && \
+    chown -R sandbox:sandbox /workspace /output
+
+# Pre-fetch common Maven dependencies (jobs run without network); scripts
+# compile and run against them through CLASSPATH. Maven itself is removed
+# once they are copied to a read-only directory.
+RUN apt-get update && apt-get install -y --no-install-recommends maven && \
+    mkdir -p /tmp/deps && cd /tmp/deps && \
+    dep() { echo "<dependency><groupId>$1</groupId><artifactId>$2</artifactId><version>$3</version></dependency>"; } && \
+    { echo '<project><modelVersion>4.0.0</modelVersion>' && \
+      echo '<groupId>datamortem</groupId><artifactId>sandbox-deps</artifactId><version>1</version><dependencies>' && \
+      dep com.fasterxml.jackson.core jackson-databind 2.17.1 && \
+      dep org.apache.commons commons-csv 1.11.0 && \
+      dep org.apache.commons commons-compress 1.26.2 && \
+      dep commons-io commons-io 2.16.1 && \
+      echo '</dependencies></project>'; } > pom.xml && \
+    mvn -q dependency:copy-dependencies \
+        -DoutputDirectory=/opt/datamortem-java/lib && \
+    apt-get purge -y --auto-remove maven && \
+    cd / && rm -rf /var/lib/apt/lists/* /tmp/deps /root/.m2
+
+# Set working directory
+WORKDIR /workspace
+
+# Install the entrypoint, static so that it runs on any distribution
+COPY --from=entrypoint /sandbox-entrypoint /usr/local/bin/sandbox-entrypoint
+
+# Switch to non-root user
+USER sandbox
+
+# Environment variables
+ENV CLASSPATH=/opt/datamortem-java/lib/*
+
+# Default command (overridden at runtime)
+CMD ["java", "--version"]
@@ -0,0 +1,219 @@
+//go:build linux
+
+// Command sandbox-entrypoint runs the script of a sandbox container with
+// ExecConfig.Entrypoint. It checks the environment contract, runs the
+// command given as its arguments, and records how the command ended in
+// sandbox.JobStatusFile before exiting with its status:
+//
+//	sandbox-entrypoint go run .
+//
+// SIGTERM and SIGINT are passed on to every process of the command, and
+// the entrypoint then waits for all of them, e.g. the script orphaned by
+// `go run`, so that they can run their shutdown handlers before the
+// orchestrator's SIGKILL. It reaps the orphans of the container, whether
+// it runs as its init process or not. With SANDBOX_HEARTBEAT_PATH set, it
+// beats that file while the command runs, for the orchestrator to tell
+// that the worker is alive.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// contractEnv lists the variables every job container must be started
+// with; EVIDENCE_PATH is checked when it is set, as a job may fetch or
+// stream its evidence instead.
+var contractEnv = []string{sandbox.EnvCaseID, sandbox.EnvEvidenceUID, sandbox.EnvOutputDir}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("sandbox-entrypoint: ")
+	if len(os.Args) < 2 {
+		log.Print("usage: sandbox-entrypoint command [argument...]")
+		os.Exit(2)
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the script command args and returns its exit status.
+func run(args []string) int {
+	status := sandbox.JobStatus{Command: args, Started: time.Now().UTC()}
+	dir := os.Getenv(sandbox.EnvOutputDir)
+	if err := checkContract(); err != nil {
+		log.Print(err)
+		status.Finished = status.Started
+		status.ExitCode, status.ContractError = sandbox.ContractExitCode, err.Error()
+		if dir != "" {
+			// OUTPUT_DIR may be the problem: the exit code tells anyway.
+			sandbox.WriteJobStatus(dir, status)
+		}
+		return status.ExitCode
+	}
+	stop := heartbeat()
+	if err := execute(&status); err != nil {
+		log.Print(err)
+	}
+	stop()
+	status.Finished = time.Now().UTC()
+	if err := sandbox.WriteJobStatus(dir, status); err != nil {
+		log.Printf("record job status: %v", err)
+	}
+	return status.ExitCode
+}
+
+// checkContract checks that the required variables are set, that
+// OUTPUT_DIR is writable and that the evidence, or the socket it is
+// streamed on, is there.
+func checkContract() error {
+	if err := sandbox.RequireEnv(contractEnv...); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(os.Getenv(sandbox.EnvOutputDir), ".entrypoint-")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", sandbox.EnvOutputDir, err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("%s is not writable: %w", sandbox.EnvOutputDir, err)
+	}
+	for _, key := range []string{sandbox.EnvEvidencePath, sandbox.EnvEvidenceStreamSocket} {
+		if path := os.Getenv(key); path != "" {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultHeartbeatInterval is the interval of a heartbeat whose
+// SANDBOX_HEARTBEAT_INTERVAL is unset or malformed.
+const defaultHeartbeatInterval = 10 * time.Second
+
+// heartbeat beats SANDBOX_HEARTBEAT_PATH every SANDBOX_HEARTBEAT_INTERVAL,
+// at once and then until stop is called. It does nothing without a path;
+// only the first failure to beat is logged.
+func heartbeat() (stop func()) {
+	path := os.Getenv(sandbox.EnvHeartbeatPath)
+	if path == "" {
+		return func() {}
+	}
+	interval, err := time.ParseDuration(os.Getenv(sandbox.EnvHeartbeatInterval))
+	if err != nil || interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	failed := false
+	beat := func() {
+		if err := sandbox.WriteHeartbeat(path, time.Now()); err != nil && !failed {
+			failed = true
+			log.Printf("heartbeat: %v", err)
+		}
+	}
+	beat()
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				beat()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// execute runs status.Command in a process group of its own, passing
+// SIGTERM and SIGINT on to the group, and sets the exit status. A command
+// that cannot be run exits with 127 when it is not found and 126
+// otherwise, as in a shell.
+func execute(status *sandbox.JobStatus) error {
+	path, err := exec.LookPath(status.Command[0])
+	if err != nil {
+		status.ExitCode = 127
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		log.Printf("become subreaper: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	proc, err := os.StartProcess(path, status.Command, &os.ProcAttr{
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{Setpgid: true},
+	})
+	if err != nil {
+		status.ExitCode = 126
+		if errors.Is(err, fs.ErrNotExist) {
+			status.ExitCode = 127
+		}
+		return err
+	}
+	exited := make(chan syscall.WaitStatus, 1)
+	go func() { exited <- reap(proc.Pid) }()
+	for {
+		select {
+		case sig := <-signals:
+			status.Terminated = true
+			syscall.Kill(-proc.Pid, sig.(syscall.Signal))
+		case ws := <-exited:
+			status.ExitCode = ws.ExitStatus()
+			if ws.Signaled() {
+				status.Signal = unix.SignalName(ws.Signal())
+				status.ExitCode = 128 + int(ws.Signal())
+			}
+			if status.Terminated {
+				drain(proc.Pid)
+			}
+			return nil
+		}
+	}
+}
+
+// reap waits for the process pid, reaping the orphans reparented to the
+// entrypoint in the meantime.
+func reap(pid int) syscall.WaitStatus {
+	for {
+		var ws syscall.WaitStatus
+		got, err := syscall.Wait4(-1, &ws, 0, nil)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+		case err != nil || got == pid:
+			return ws
+		}
+	}
+}
+
+// drain waits until no process of the group pgid is left, reaping them as
+// they exit.
+func drain(pgid int) {
+	for syscall.Kill(-pgid, 0) == nil {
+		var ws syscall.WaitStatus
+		for {
+			if got, _ := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil); got <= 0 {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
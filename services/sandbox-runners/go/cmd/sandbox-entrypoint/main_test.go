@@ -0,0 +1,132 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func setupEnv(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(sandbox.EnvCaseID, "case-1")
+	t.Setenv(sandbox.EnvEvidenceUID, "ev-1")
+	t.Setenv(sandbox.EnvEvidencePath, "")
+	t.Setenv(sandbox.EnvOutputDir, dir)
+	return dir
+}
+
+func TestRunRecordsStatus(t *testing.T) {
+	dir := setupEnv(t)
+	if code := run([]string{"sh", "-c", "exit 3"}); code != 3 {
+		t.Errorf("run() = %d, want the command's status", code)
+	}
+	status, err := sandbox.ReadJobStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.ExitCode != 3 || status.Command[0] != "sh" || status.Finished.Before(status.Started) || status.Terminated {
+		t.Errorf("status = %+v", status)
+	}
+
+	if code := run([]string{"sh", "-c", "kill -KILL $$"}); code != 128+9 {
+		t.Errorf("run() of a killed command = %d", code)
+	}
+	if status, _ := sandbox.ReadJobStatus(dir); status.Signal != "SIGKILL" {
+		t.Errorf("status = %+v, want SIGKILL", status)
+	}
+	if code := run([]string{"no-such-command"}); code != 127 {
+		t.Errorf("run() of a missing command = %d, want 127", code)
+	}
+}
+
+func TestRunChecksContract(t *testing.T) {
+	dir := setupEnv(t)
+	t.Setenv(sandbox.EnvCaseID, "")
+	if code := run([]string{"sh", "-c", "touch $OUTPUT_DIR/ran"}); code != sandbox.ContractExitCode {
+		t.Errorf("run() without CASE_ID = %d, want ContractExitCode", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("the command ran despite the missing variable")
+	}
+	status, err := sandbox.ReadJobStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.ContractError == "" {
+		t.Errorf("status = %+v, want the contract error", status)
+	}
+
+	t.Setenv(sandbox.EnvCaseID, "case-1")
+	t.Setenv(sandbox.EnvEvidencePath, filepath.Join(dir, "missing.raw"))
+	if code := run([]string{"true"}); code != sandbox.ContractExitCode {
+		t.Errorf("run() with missing evidence = %d, want ContractExitCode", code)
+	}
+	t.Setenv(sandbox.EnvEvidencePath, "")
+	t.Setenv(sandbox.EnvOutputDir, filepath.Join(dir, "missing"))
+	if code := run([]string{"true"}); code != sandbox.ContractExitCode {
+		t.Errorf("run() with OUTPUT_DIR missing = %d, want ContractExitCode", code)
+	}
+}
+
+func TestRunForwardsSIGTERM(t *testing.T) {
+	dir := setupEnv(t)
+	// The command stops on SIGTERM, after a child it leaves behind, which
+	// the entrypoint waits for.
+	script := `trap 'exit 0' TERM
+(trap 'sleep 0.2; : > "$OUTPUT_DIR/child-done"; exit 0' TERM; : > "$OUTPUT_DIR/ready"; while :; do sleep 0.05; done) &
+while :; do sleep 0.05; done`
+	go func() {
+		for {
+			if _, err := os.Stat(filepath.Join(dir, "ready")); err == nil {
+				syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if code := run([]string{"sh", "-c", script}); code != 0 {
+		t.Errorf("run() = %d, want the command's own status", code)
+	}
+	status, err := sandbox.ReadJobStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || !status.Terminated {
+		t.Errorf("status = %+v, want Terminated", status)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "child-done")); err != nil {
+		t.Errorf("the entrypoint did not wait for the orphaned child: %v", err)
+	}
+}
+
+func TestRunBeats(t *testing.T) {
+	dir := setupEnv(t)
+	path := filepath.Join(t.TempDir(), "heartbeat")
+	t.Setenv(sandbox.EnvHeartbeatPath, path)
+	t.Setenv(sandbox.EnvHeartbeatInterval, "20ms")
+	before := time.Now()
+	// The command records the first beat, written before it starts.
+	if code := run([]string{"sh", "-c", "cp $SANDBOX_HEARTBEAT_PATH $OUTPUT_DIR/first; sleep 0.2"}); code != 0 {
+		t.Fatalf("run() = %d", code)
+	}
+	first, err := sandbox.ReadHeartbeat(filepath.Join(dir, "first"))
+	if err != nil || first.Before(before.Add(-time.Second)) {
+		t.Errorf("first heartbeat %v, %v", first, err)
+	}
+	last, err := sandbox.ReadHeartbeat(path)
+	if err != nil || !last.After(first) {
+		t.Errorf("last heartbeat %v, %v; want one after %v", last, err, first)
+	}
+	// No beat once the command has exited.
+	time.Sleep(60 * time.Millisecond)
+	if again, _ := sandbox.ReadHeartbeat(path); !again.Equal(last) {
+		t.Errorf("heartbeat %v after the command exited", again)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/St0n14/datamortem/services/sandbox-runners/go
+
+go 1.21
+
+require (
+	github.com/klauspost/compress v1.17.11
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/sys v0.28.0
+)
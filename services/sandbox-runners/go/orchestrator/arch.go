@@ -0,0 +1,65 @@
+package orchestrator
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+)
+
+// Architectures of the runner images, as Go and the OCI image spec name
+// them.
+const (
+	ArchAMD64 = "amd64"
+	ArchARM64 = "arm64"
+)
+
+// ErrImageArchitecture is returned when the runner image is not built for
+// the architecture of the worker, Runner.Architecture, e.g. an amd64 image
+// left on an arm64 host where it would run under emulation, if at all.
+var ErrImageArchitecture = errors.New("orchestrator: runner image does not match the worker architecture")
+
+// archAliases maps the names engines and kernels give architectures, e.g.
+// the uname of `docker info`, to the OCI ones.
+var archAliases = map[string]string{
+	"x86_64":   ArchAMD64,
+	"x86-64":   ArchAMD64,
+	"aarch64":  ArchARM64,
+	"arm64/v8": ArchARM64,
+}
+
+// normalizeArch returns the OCI name of the architecture arch.
+func normalizeArch(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if a, ok := archAliases[arch]; ok {
+		return a
+	}
+	return arch
+}
+
+// arch is the architecture the runner's containers run on.
+func (r *Runner) arch() string {
+	if r.Architecture == "" {
+		return runtime.GOARCH
+	}
+	return normalizeArch(r.Architecture)
+}
+
+// platform is the image platform the runner pulls, e.g. "linux/arm64".
+func (r *Runner) platform() string {
+	return "linux/" + r.arch()
+}
+
+// imageDigest returns the digest that pins the runner image of language
+// on the runner's architecture: the job configuration's, then that of the
+// language for the architecture, then that of the language for all of
+// them.
+func (r *Runner) imageDigest(language string, cfg ExecConfig) string {
+	if cfg.ImageDigest != "" {
+		return cfg.ImageDigest
+	}
+	lang := languageKey(language)
+	if d := r.ArchImageDigests[lang][r.arch()]; d != "" {
+		return d
+	}
+	return r.ImageDigests[lang]
+}
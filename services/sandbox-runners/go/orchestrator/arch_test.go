@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeArch(t *testing.T) {
+	for in, want := range map[string]string{
+		"amd64":   ArchAMD64,
+		"x86_64":  ArchAMD64,
+		"aarch64": ArchARM64,
+		"ARM64":   ArchARM64,
+		"riscv64": "riscv64",
+	} {
+		if got := normalizeArch(in); got != want {
+			t.Errorf("normalizeArch(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := (&Runner{}).arch(); got != runtime.GOARCH {
+		t.Errorf("default architecture %q, want %q", got, runtime.GOARCH)
+	}
+}
+
+func TestRunSelectsImageArchitecture(t *testing.T) {
+	const image = "registry.corp/sandbox-go:1.21"
+	ids := map[string]string{
+		ArchAMD64: "sha256:" + strings.Repeat("a", 64),
+		ArchARM64: "sha256:" + strings.Repeat("b", 64),
+	}
+	// The manifest list both variants were pulled from.
+	list := "registry.corp/sandbox-go@sha256:" + strings.Repeat("c", 64)
+	variants := map[string]ImageInfo{}
+	for arch, id := range ids {
+		variants["linux/"+arch] = ImageInfo{ID: id, RepoDigests: []string{list}, OS: "linux", Architecture: arch}
+	}
+	for _, arch := range []string{ArchAMD64, ArchARM64} {
+		t.Run(arch, func(t *testing.T) {
+			rt := &fakeRuntime{platformImages: map[string]map[string]ImageInfo{image: variants}}
+			r := NewRunner(rt)
+			r.Architecture = arch
+			r.Images = map[string]string{LanguageGo: image}
+			r.ArchImageDigests = map[string]map[string]string{LanguageGo: ids}
+			res, err := r.Run(context.Background(), testJob(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ImageDigest != ids[arch] || res.Architecture != arch || rt.lastSpec().Image != ids[arch] {
+				t.Errorf("ran %s (%s) on %s", res.ImageDigest, rt.lastSpec().Image, res.Architecture)
+			}
+			if got := rt.platforms[len(rt.platforms)-1]; got != "linux/"+arch {
+				t.Errorf("inspected for %q", got)
+			}
+
+			// The digest of the other variant does not pin this one.
+			other := ids[ArchAMD64]
+			if arch == ArchAMD64 {
+				other = ids[ArchARM64]
+			}
+			r.ArchImageDigests[LanguageGo] = map[string]string{arch: other}
+			if _, err := r.Run(context.Background(), testJob(t)); !errors.Is(err, ErrImageDigestMismatch) {
+				t.Errorf("err = %v, want ErrImageDigestMismatch", err)
+			}
+			// The manifest list does, on either architecture.
+			r.ArchImageDigests = nil
+			r.ImageDigests = map[string]string{LanguageGo: strings.TrimPrefix(list, "registry.corp/sandbox-go@")}
+			if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+				t.Errorf("manifest list digest: %v", err)
+			}
+		})
+	}
+}
+
+func TestRunRejectsForeignArchitecture(t *testing.T) {
+	const image = "registry.corp/sandbox-go:1.21"
+	rt := &fakeRuntime{images: map[string]ImageInfo{
+		image: {ID: "sha256:" + strings.Repeat("a", 64), OS: "linux", Architecture: "x86_64"},
+	}}
+	r := NewRunner(rt)
+	r.Architecture = "aarch64"
+	r.Images = map[string]string{LanguageGo: image}
+	_, err := r.Run(context.Background(), testJob(t))
+	if !errors.Is(err, ErrImageArchitecture) || Retryable(err) || !strings.Contains(err.Error(), "built for amd64, the worker is arm64") {
+		t.Errorf("err = %v, want ErrImageArchitecture", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers created from a foreign image", len(rt.specs))
+	}
+}
+
+func TestBuildCacheKeyedByArchitecture(t *testing.T) {
+	cache := &BuildCache{Dir: t.TempDir()}
+	builds := 0
+	// Both workers see the same image ID, as with a shared cache whose
+	// image the engines report without platform.
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		if len(spec.Cmd) > 1 && spec.Cmd[1] == "build" {
+			builds++
+		}
+		fakeBuild(spec)
+	}}
+	for _, arch := range []string{ArchAMD64, ArchARM64, ArchAMD64} {
+		r := NewRunner(rt)
+		r.Architecture = arch
+		r.BuildCache = cache
+		job := testJob(t)
+		os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if builds != 2 {
+		t.Errorf("%d builds, want one per architecture", builds)
+	}
+}
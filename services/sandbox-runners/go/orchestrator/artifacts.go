@@ -0,0 +1,161 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ArtifactUntracked is the kind given to output files the script did not
+// register in its manifest.
+const ArtifactUntracked = "untracked"
+
+// WarningArtifactMismatch is the code of the warning added to a job's
+// result for each file whose SHA256 or size in the manifest is not that of
+// the file.
+const WarningArtifactMismatch = "artifact.digest_mismatch"
+
+// contractFiles are the SDK's own files in OUTPUT_DIR; they are ingested
+// separately and are not artifacts.
+var contractFiles = map[string]bool{
+	sandbox.ResultsFile:   true,
+	sandbox.ProgressFile:  true,
+	sandbox.ManifestFile:  true,
+	sandbox.TimelineFile:  true,
+	sandbox.IOCsFile:      true,
+	sandbox.FactsFile:     true,
+	sandbox.WarningsFile:  true,
+	sandbox.GraphFile:     true,
+	sandbox.CoverageFile:  true,
+	sandbox.JobStatusFile: true,
+}
+
+// CollectedArtifact is an output file ready for ingestion.
+type CollectedArtifact struct {
+	sandbox.Artifact
+	// Tracked reports that the script registered the file in its manifest.
+	Tracked bool
+	// Mismatch reports that the SHA256 or size the manifest declared,
+	// DeclaredSHA256 and DeclaredSize, is not that of the file. SHA256 and
+	// Size are always computed at collection, whatever the manifest says.
+	Mismatch       bool
+	DeclaredSHA256 string
+	DeclaredSize   int64
+	// SourceEvidence is the UID of the evidence item the file was
+	// produced from: the one sandbox.OutputName put in its name, the
+	// parent of extracted evidence, or else the job's evidence.
+	SourceEvidence string
+	// StorageName names the file uniquely among the outputs of the case,
+	// for shared storage: the job ID, then Path namespaced by
+	// sandbox.OutputName with SourceEvidence, e.g.
+	// "job-7/reports/ev-42_summary.html".
+	StorageName string
+}
+
+// namespaceArtifacts sets the SourceEvidence and StorageName of the
+// artifacts of job.
+func namespaceArtifacts(job Job, artifacts []CollectedArtifact) {
+	for i := range artifacts {
+		a := &artifacts[i]
+		a.SourceEvidence = job.Evidence.UID
+		if a.Parent != "" {
+			a.SourceEvidence = a.Parent
+		}
+		// The longest UID wins, should one UID prefix another.
+		named := ""
+		for _, ev := range job.allEvidence() {
+			if sandbox.OutputName(ev.UID, a.Path) == a.Path && len(ev.UID) > len(named) {
+				named = ev.UID
+			}
+		}
+		if named != "" {
+			a.SourceEvidence = named
+		}
+		a.StorageName = path.Join(job.ID, filepath.ToSlash(sandbox.OutputName(a.SourceEvidence, a.Path)))
+	}
+}
+
+// collectArtifacts matches outputs against the manifest in dir. Every file
+// is hashed here: those the manifest omits are flagged untracked, and those
+// it declares with another SHA256 or size are flagged Mismatch. A manifest
+// that cannot be read leaves every file untracked and is reported as err. Only
+// the first limit files are collected when limit is positive; total
+// counts them all.
+func collectArtifacts(dir string, outputs []string, limit int) (artifacts []CollectedArtifact, total int, err error) {
+	manifest, err := sandbox.ReadManifest(dir)
+	if err != nil {
+		manifest = &sandbox.Manifest{}
+	}
+	declared := make(map[string]sandbox.Artifact, len(manifest.Artifacts))
+	for _, a := range manifest.Artifacts {
+		declared[a.Path] = a
+	}
+
+	for _, rel := range outputs {
+		if contractFiles[rel] {
+			continue
+		}
+		total++
+		if limit > 0 && len(artifacts) >= limit {
+			continue
+		}
+		sum, size, hashErr := fileSHA256(filepath.Join(dir, filepath.FromSlash(rel)))
+		if hashErr != nil {
+			continue
+		}
+		if a, ok := declared[rel]; ok {
+			c := CollectedArtifact{Artifact: a, Tracked: true}
+			if a.SHA256 != sum || a.Size != size {
+				c.Mismatch, c.DeclaredSHA256, c.DeclaredSize = true, a.SHA256, a.Size
+			}
+			c.SHA256, c.Size = sum, size
+			artifacts = append(artifacts, c)
+			continue
+		}
+		artifacts = append(artifacts, CollectedArtifact{Artifact: sandbox.Artifact{
+			Path:   rel,
+			Kind:   ArtifactUntracked,
+			SHA256: sum,
+			Size:   size,
+		}})
+	}
+	return artifacts, total, err
+}
+
+// checkArtifacts returns a warning for each of artifacts that does not
+// match the manifest.
+func checkArtifacts(artifacts []CollectedArtifact) []sandbox.Warning {
+	var warnings []sandbox.Warning
+	for _, a := range artifacts {
+		if !a.Mismatch {
+			continue
+		}
+		warnings = append(warnings, sandbox.Warning{
+			EvidenceUID: a.SourceEvidence,
+			Code:        WarningArtifactMismatch,
+			Message:     fmt.Sprintf("%s: the manifest declares SHA256 %s and %d bytes, the file has %s and %d bytes", a.Path, a.DeclaredSHA256, a.DeclaredSize, a.SHA256, a.Size),
+			Fields:      map[string]any{"path": a.Path, "declared_sha256": a.DeclaredSHA256, "sha256": a.SHA256},
+		})
+	}
+	return warnings
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsArtifacts(t *testing.T) {
+	job := testJob(t)
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		write := func(name, content string) {
+			os.WriteFile(filepath.Join(job.OutputDir, name), []byte(content), 0644)
+		}
+		write("timeline.csv", "t,msg\n")
+		write("report.md", "# ok\n")
+		write("scratch.bin", "junk")
+		write(sandbox.ResultsFile, "{}\n")
+		sum, _, _ := fileSHA256(filepath.Join(job.OutputDir, "report.md"))
+		write(sandbox.ManifestFile, `{"artifacts":[{"path":"timeline.csv","kind":"timeline","sha256":"abc","size":1},`+
+			`{"path":"report.md","kind":"report","sha256":"`+sum+`","size":5}]}`)
+	}}
+
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ManifestError != "" {
+		t.Fatalf("ManifestError = %s", res.ManifestError)
+	}
+	got := map[string]CollectedArtifact{}
+	for _, a := range res.Artifacts {
+		got[a.Path] = a
+	}
+	if len(got) != 3 {
+		t.Fatalf("artifacts = %+v", res.Artifacts)
+	}
+	// The manifest's digest is not trusted: the file's is recorded, and
+	// the mismatch flagged.
+	if a := got["timeline.csv"]; !a.Tracked || a.Kind != sandbox.ArtifactTimeline || !a.Mismatch ||
+		a.Size != 6 || len(a.SHA256) != 64 || a.DeclaredSHA256 != "abc" || a.DeclaredSize != 1 {
+		t.Errorf("timeline.csv = %+v", a)
+	}
+	if a := got["report.md"]; !a.Tracked || a.Mismatch || a.Size != 5 {
+		t.Errorf("report.md = %+v", a)
+	}
+	if len(res.Warnings) != 1 || res.Warnings[0].Code != WarningArtifactMismatch || res.Warnings[0].Fields["path"] != "timeline.csv" {
+		t.Errorf("warnings = %+v", res.Warnings)
+	}
+	if a := got["scratch.bin"]; a.Tracked || a.Kind != ArtifactUntracked || a.Size != 4 || len(a.SHA256) != 64 {
+		t.Errorf("scratch.bin = %+v", a)
+	}
+}
+
+func TestRunNamespacesArtifacts(t *testing.T) {
+	job := testJob(t)
+	job.ID = "job-7"
+	job.ExtraEvidence = []Evidence{{UID: "ev-10", Path: "/lake/case-1/ev-10/mem.lime"}}
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		os.MkdirAll(filepath.Join(job.OutputDir, "reports"), 0o755)
+		for _, name := range []string{"strings.txt", "ev-10_pslist.txt", "reports/ev-1_summary.html"} {
+			os.WriteFile(filepath.Join(job.OutputDir, name), []byte("x"), 0o644)
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"strings.txt":               {"ev-1", "job-7/ev-1_strings.txt"},
+		"ev-10_pslist.txt":          {"ev-10", "job-7/ev-10_pslist.txt"},
+		"reports/ev-1_summary.html": {"ev-1", "job-7/reports/ev-1_summary.html"},
+	}
+	if len(res.Artifacts) != len(want) {
+		t.Fatalf("artifacts = %+v", res.Artifacts)
+	}
+	for _, a := range res.Artifacts {
+		if w := want[a.Path]; a.SourceEvidence != w[0] || a.StorageName != w[1] {
+			t.Errorf("%s: evidence %s, storage name %s, want %s and %s", a.Path, a.SourceEvidence, a.StorageName, w[0], w[1])
+		}
+	}
+}
+
+func TestRunCorruptManifest(t *testing.T) {
+	job := testJob(t)
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		os.WriteFile(filepath.Join(job.OutputDir, "out.txt"), []byte("x"), 0644)
+		os.WriteFile(filepath.Join(job.OutputDir, sandbox.ManifestFile), []byte("{"), 0644)
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ManifestError == "" {
+		t.Error("expected ManifestError")
+	}
+	if len(res.Artifacts) != 1 || res.Artifacts[0].Kind != ArtifactUntracked {
+		t.Errorf("artifacts = %+v", res.Artifacts)
+	}
+}
@@ -0,0 +1,96 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// WarningInvalidTechnique is the code of the warning added to a job's
+// result for each technique of a finding that is not an ATT&CK technique.
+const WarningInvalidTechnique = "attack.invalid_technique"
+
+// checkTechniques removes from findings the techniques that
+// sandbox.ValidTechnique rejects, which scripts in other languages may
+// write, and returns a warning for each rather than dropping the finding.
+func checkTechniques(findings []sandbox.Result) []sandbox.Warning {
+	var warnings []sandbox.Warning
+	for i, r := range findings {
+		var valid []string
+		for _, t := range r.Techniques {
+			if sandbox.ValidTechnique(t) {
+				valid = append(valid, t)
+				continue
+			}
+			warnings = append(warnings, sandbox.Warning{
+				EvidenceUID: r.EvidenceUID,
+				Code:        WarningInvalidTechnique,
+				Message:     fmt.Sprintf("finding %q: %q is not an ATT&CK technique", r.Title, t),
+				Fields:      map[string]any{"technique": t, "finding_key": r.FindingKey},
+			})
+		}
+		if len(valid) != len(r.Techniques) {
+			findings[i].Techniques = valid
+		}
+	}
+	return warnings
+}
+
+// TechniqueCoverage is an ATT&CK technique the findings of a case were
+// tagged with.
+type TechniqueCoverage struct {
+	// Technique is the ID, e.g. "T1055.012", and Name that of the
+	// technique or of its parent, e.g. "Process Injection".
+	Technique string
+	Name      string
+	// Findings counts the merged findings tagged with the technique, and
+	// Severity is the highest of theirs.
+	Findings int
+	Severity sandbox.Severity
+	// FindingKeys, JobIDs and EvidenceUIDs list the keys of those
+	// findings, when they have one, and the jobs and evidence items that
+	// reported them, in order of first report.
+	FindingKeys  []string
+	JobIDs       []string
+	EvidenceUIDs []string
+}
+
+// Coverage returns the ATT&CK techniques of the case's findings, the
+// suppressed ones left out, sorted by ID, for a review of what the case's detections cover.
+func (c *CaseFindings) Coverage() []TechniqueCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := map[string]int{}
+	var coverage []TechniqueCoverage
+	for _, f := range c.findings {
+		if f.SuppressedBy != "" {
+			continue
+		}
+		for _, t := range f.Techniques {
+			i, ok := index[t]
+			if !ok {
+				i = len(coverage)
+				index[t] = i
+				name, _ := sandbox.TechniqueName(t)
+				coverage = append(coverage, TechniqueCoverage{Technique: t, Name: name, Severity: sandbox.SeverityInfo})
+			}
+			tc := &coverage[i]
+			tc.Findings++
+			if f.Severity.Rank() > tc.Severity.Rank() {
+				tc.Severity = f.Severity
+			}
+			if f.FindingKey != "" {
+				tc.FindingKeys = appendUnique(tc.FindingKeys, f.FindingKey)
+			}
+			for _, id := range f.JobIDs {
+				tc.JobIDs = appendUnique(tc.JobIDs, id)
+			}
+			for _, uid := range f.EvidenceUIDs {
+				tc.EvidenceUIDs = appendUnique(tc.EvidenceUIDs, uid)
+			}
+		}
+	}
+	sort.Slice(coverage, func(i, j int) bool { return coverage[i].Technique < coverage[j].Technique })
+	return coverage
+}
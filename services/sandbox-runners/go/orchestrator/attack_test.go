@@ -0,0 +1,79 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunChecksTechniques(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"evidence_uid":"ev-1","severity":"high","title":"Injected thread","techniques":["T1055.012","T9999","t1055"]}`+"\n"+
+						`{"evidence_uid":"ev-1","severity":"low","title":"Run key","techniques":["T1547.001"]}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 2 || !reflect.DeepEqual(res.Findings[0].Techniques, []string{"T1055.012"}) ||
+		!reflect.DeepEqual(res.Findings[1].Techniques, []string{"T1547.001"}) {
+		t.Errorf("findings = %+v", res.Findings)
+	}
+	if len(res.Warnings) != 2 {
+		t.Fatalf("warnings = %+v, want one per invalid technique", res.Warnings)
+	}
+	if w := res.Warnings[0]; w.Code != WarningInvalidTechnique || w.EvidenceUID != "ev-1" || w.Fields["technique"] != "T9999" {
+		t.Errorf("warning = %+v", w)
+	}
+}
+
+func TestCaseFindingsCoverage(t *testing.T) {
+	c := NewCaseFindings("case-1")
+	add := func(jobID string, results ...sandbox.Result) {
+		t.Helper()
+		job := testJob(t)
+		job.ID = jobID
+		if err := c.Add(job, &JobResult{JobID: jobID, Findings: results}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inject := sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityMedium, Title: "Injected thread", FindingKey: "mem/inject/1234"}
+	add("job-1",
+		inject.WithTechnique("T1055"),
+		sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityLow, Title: "Run key"}.WithTechnique("T1547.001").WithTechnique("T1112"),
+	)
+	add("job-2",
+		sandbox.Result{EvidenceUID: "ev-2", Severity: sandbox.SeverityHigh, Title: "Injected thread", FindingKey: "mem/inject/1234"}.WithTechnique("T1055.012"),
+		sandbox.Result{EvidenceUID: "ev-2", Severity: sandbox.SeverityCritical, Title: "Hollowed process"}.WithTechnique("T1055"),
+	)
+
+	findings := c.Findings()
+	if want := []string{"T1055", "T1055.012"}; !reflect.DeepEqual(findings[0].Techniques, want) {
+		t.Errorf("merged techniques %q, want %q", findings[0].Techniques, want)
+	}
+	got := c.Coverage()
+	var ids []string
+	for _, tc := range got {
+		ids = append(ids, tc.Technique)
+	}
+	if want := []string{"T1055", "T1055.012", "T1112", "T1547.001"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("coverage of %q, want %q", ids, want)
+	}
+	if tc := got[0]; tc.Name != "Process Injection" || tc.Findings != 2 || tc.Severity != sandbox.SeverityCritical ||
+		!reflect.DeepEqual(tc.FindingKeys, []string{"mem/inject/1234"}) || !reflect.DeepEqual(tc.JobIDs, []string{"job-1", "job-2"}) ||
+		!reflect.DeepEqual(tc.EvidenceUIDs, []string{"ev-1", "ev-2"}) {
+		t.Errorf("T1055 coverage %+v", tc)
+	}
+	if tc := got[3]; tc.Name != "Boot or Logon Autostart Execution" || tc.Findings != 1 || tc.Severity != sandbox.SeverityLow || tc.FindingKeys != nil {
+		t.Errorf("T1547.001 coverage %+v", tc)
+	}
+}
@@ -0,0 +1,343 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// auditFileSuffix ends the name of each case's audit log in AuditLog.Dir.
+const auditFileSuffix = ".audit.ndjson"
+
+// ErrAuditTampered is returned when an audit log's hash chain is broken:
+// an entry was modified, removed, reordered or inserted.
+var ErrAuditTampered = errors.New("orchestrator: audit log hash chain is broken")
+
+// AuditEvidence is an evidence item of an audited job, as recorded at
+// ingestion.
+type AuditEvidence struct {
+	UID      string `json:"uid"`
+	HashAlgo string `json:"hash_algo,omitempty"`
+	SHA256   string `json:"sha256"`
+	// Offset and Length are the byte range the script was given.
+	Offset int64 `json:"offset,omitempty"`
+	Length int64 `json:"length,omitempty"`
+}
+
+// AuditEntry records what ran against what: one per executed job.
+type AuditEntry struct {
+	// Seq numbers the entries of a case from 1.
+	Seq     int    `json:"seq"`
+	JobID   string `json:"job_id"`
+	Analyst string `json:"analyst"`
+	CaseID  string `json:"case_id"`
+	// ParentID is the fan-out the job belongs to.
+	ParentID string `json:"parent_id,omitempty"`
+	// Evidence lists the job's evidence items, fetched ones included.
+	Evidence []AuditEvidence `json:"evidence"`
+	// Fixture is the Job.EvidenceFixture the job ran against, e.g.
+	// "win10-memory-sample@2".
+	Fixture  string `json:"fixture,omitempty"`
+	Language string `json:"language"`
+	// ScriptSHA256 hashes the files of the workspace the job ran from, see
+	// JobResult.ScriptSHA256.
+	ScriptSHA256 string       `json:"script_sha256"`
+	Image        string       `json:"image"`
+	ImageDigest  string       `json:"image_digest"`
+	Architecture string       `json:"architecture,omitempty"`
+	GoVersion    string       `json:"go_version,omitempty"`
+	BinarySHA256 string       `json:"binary_sha256,omitempty"`
+	Build        *BuildConfig `json:"build,omitempty"`
+	SignerKeyID  string       `json:"signer_key_id,omitempty"`
+	// Session is the shell or REPL of an interactive session and
+	// everything typed in it.
+	Session *SessionRecord `json:"session,omitempty"`
+	// DecryptedEvidence lists the evidence decrypted for the run; the keys
+	// are never recorded.
+	DecryptedEvidence []string `json:"decrypted_evidence,omitempty"`
+	// StreamedEvidence lists the evidence streamed from object storage,
+	// which was not hashed for the run.
+	StreamedEvidence []string `json:"streamed_evidence,omitempty"`
+	// Vulnerabilities are those found in the script's dependencies.
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+	// TraceID is the trace of the run's spans, with Runner.Tracer.
+	TraceID string `json:"trace_id,omitempty"`
+	// Coverage is what the script reported examining of its evidence.
+	Coverage *sandbox.Coverage `json:"coverage,omitempty"`
+	Module   *ScriptModule     `json:"module,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	// Secrets names the job's secrets, whose values are never recorded.
+	Secrets []string          `json:"secrets,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// RunTime is the logical start time of the job, Job.RunTime.
+	RunTime *time.Time `json:"run_time,omitempty"`
+	// Seed is the SANDBOX_SEED of the job, Job.Seed.
+	Seed          int64         `json:"seed,omitempty"`
+	Started       time.Time     `json:"started"`
+	Finished      time.Time     `json:"finished"`
+	ExitCode      int           `json:"exit_code"`
+	Success       bool          `json:"success"`
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+	// LastHeartbeat is the latest heartbeat of the job's worker, with
+	// ExecConfig.HeartbeatTimeout.
+	LastHeartbeat *time.Time `json:"last_heartbeat,omitempty"`
+	// PrevHash is the Hash of the previous entry of the case, empty for
+	// the first one.
+	PrevHash string `json:"prev_hash"`
+	// Hash is the SHA256 of the entry's JSON with an empty Hash.
+	Hash string `json:"hash"`
+}
+
+// digest computes the entry's Hash.
+func (e AuditEntry) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditLog keeps an append-only, hash-chained log of the jobs of each
+// case: every entry includes the hash of the previous one, so that
+// modifying or removing an entry breaks the chain. Removing the latest
+// entries is only detected against a copy of the last hash, e.g. the one
+// returned by Head and kept outside the log. It is safe for concurrent
+// use by one orchestrator process.
+type AuditLog struct {
+	// Dir holds one audit file per case.
+	Dir string
+
+	mu    sync.Mutex
+	heads map[string]AuditEntry
+}
+
+// path returns the audit file of caseID.
+func (l *AuditLog) path(caseID string) (string, error) {
+	if caseID == "" || caseID == "." || caseID == ".." || strings.ContainsAny(caseID, `/\`) {
+		return "", fmt.Errorf("orchestrator: case ID %q cannot name an audit log", caseID)
+	}
+	return filepath.Join(l.Dir, caseID+auditFileSuffix), nil
+}
+
+// head returns the last entry of caseID's log, reading it on first use.
+// The caller holds l.mu.
+func (l *AuditLog) head(caseID string) (AuditEntry, error) {
+	if e, ok := l.heads[caseID]; ok {
+		return e, nil
+	}
+	entries, err := l.read(caseID)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	var last AuditEntry
+	if len(entries) > 0 {
+		last = entries[len(entries)-1]
+	}
+	if l.heads == nil {
+		l.heads = map[string]AuditEntry{}
+	}
+	l.heads[caseID] = last
+	return last, nil
+}
+
+// Head returns the hash of the latest entry of caseID, empty when the
+// case has none.
+func (l *AuditLog) Head(caseID string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, err := l.head(caseID)
+	return e.Hash, err
+}
+
+// Record appends the entry of job, which ended with res.
+func (l *AuditLog) Record(job Job, res *JobResult) error {
+	path, err := l.path(job.CaseID)
+	if err != nil {
+		return err
+	}
+	// A job that failed before its workspace was staged records its
+	// source tree.
+	script := res.ScriptSHA256
+	if script == "" {
+		if script, err = workspaceKey(job.Workspace, ""); err != nil {
+			return fmt.Errorf("orchestrator: audit: hash script: %w", err)
+		}
+	}
+	var evidence []AuditEvidence
+	for _, ev := range append(job.allEvidence(), res.FetchedEvidence...) {
+		evidence = append(evidence, AuditEvidence{UID: ev.UID, HashAlgo: ev.HashAlgo, SHA256: ev.SHA256, Offset: ev.Offset, Length: ev.Length})
+	}
+	started := res.Metrics.Started.UTC()
+	if res.Metrics.Started.IsZero() {
+		// Cancelled before its container started.
+		started = time.Now().UTC()
+	}
+	e := AuditEntry{
+		JobID:             job.ID,
+		Analyst:           job.Analyst,
+		CaseID:            job.CaseID,
+		ParentID:          job.ParentID,
+		Evidence:          evidence,
+		Fixture:           job.EvidenceFixture,
+		Language:          languageKey(job.Language),
+		ScriptSHA256:      script,
+		Image:             res.Image,
+		ImageDigest:       res.ImageDigest,
+		Architecture:      res.Architecture,
+		GoVersion:         res.GoVersion,
+		BinarySHA256:      res.BinarySHA256,
+		Build:             res.Build,
+		SignerKeyID:       res.SignerKeyID,
+		Session:           res.Session,
+		Coverage:          res.Coverage,
+		DecryptedEvidence: res.DecryptedEvidence,
+		StreamedEvidence:  res.StreamedEvidence,
+		Vulnerabilities:   res.Vulnerabilities,
+		TraceID:           res.TraceID,
+		Params:            newScrubber(job.Secrets).scrubMap(job.Params),
+		Secrets:           secretNames(job.Secrets),
+		Labels:            job.Labels,
+		Started:           started,
+		Finished:          started.Add(res.Metrics.Duration),
+		ExitCode:          res.ExitCode,
+		Success:           res.Success,
+		FailureReason:     res.FailureReason,
+	}
+	if res.Module != (ScriptModule{}) {
+		module := res.Module
+		e.Module = &module
+	}
+	if !job.RunTime.IsZero() {
+		runTime := job.RunTime.UTC()
+		e.RunTime = &runTime
+	}
+	if !res.LastHeartbeat.IsZero() {
+		beat := res.LastHeartbeat.UTC()
+		e.LastHeartbeat = &beat
+	}
+	e.Seed = job.Seed
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev, err := l.head(job.CaseID)
+	if err != nil {
+		return err
+	}
+	e.Seq, e.PrevHash = prev.Seq+1, prev.Hash
+	if e.Hash, err = e.digest(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("orchestrator: audit: %w", err)
+	}
+	l.heads[job.CaseID] = e
+	return nil
+}
+
+// read parses and verifies caseID's log; a missing log has no entries.
+func (l *AuditLog) read(caseID string) ([]AuditEntry, error) {
+	path, err := l.path(caseID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return VerifyAuditLog(f)
+}
+
+// Export writes caseID's audit log to w, as NDJSON, once its hash chain
+// has been verified.
+func (l *AuditLog) Export(caseID string, w io.Writer) error {
+	l.mu.Lock()
+	entries, err := l.read(caseID)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyAuditLog parses an audit log, e.g. one written by Export, and
+// checks its hash chain. It returns ErrAuditTampered, with the first
+// offending line, when the chain is broken. Entries are read whole, of
+// whatever size Record wrote them.
+func VerifyAuditLog(r io.Reader) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	br := bufio.NewReader(r)
+	prev := AuditEntry{}
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(line) == 0 && err != nil {
+			break
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrAuditTampered, n, err)
+		}
+		hash, err := e.digest()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case e.Hash != hash:
+			return nil, fmt.Errorf("%w: line %d: entry does not match its hash", ErrAuditTampered, n)
+		case e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash:
+			return nil, fmt.Errorf("%w: line %d: entry %d does not follow entry %d", ErrAuditTampered, n, e.Seq, prev.Seq)
+		case len(entries) > 0 && e.CaseID != prev.CaseID:
+			return nil, fmt.Errorf("%w: line %d: entry of case %s", ErrAuditTampered, n, e.CaseID)
+		}
+		entries = append(entries, e)
+		prev = e
+	}
+	return entries, nil
+}
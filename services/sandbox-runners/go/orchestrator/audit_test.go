@@ -0,0 +1,164 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogChainsJobs(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+
+	for i, id := range []string{"job-1", "job-2"} {
+		job := testJob(t)
+		job.ID = id
+		job.Analyst = "alice"
+		job.Params = map[string]string{"PARAM_DEPTH": "2"}
+		if i == 1 {
+			rt.state = ContainerState{ExitCode: 3}
+		}
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.AuditError != "" {
+			t.Fatalf("audit error: %s", res.AuditError)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if first.Seq != 1 || first.PrevHash != "" || second.Seq != 2 || second.PrevHash != first.Hash {
+		t.Errorf("chain = %d %q, %d %q", first.Seq, first.PrevHash, second.Seq, second.PrevHash)
+	}
+	if first.Analyst != "alice" || first.JobID != "job-1" || first.Params["PARAM_DEPTH"] != "2" {
+		t.Errorf("first entry = %+v", first)
+	}
+	if len(first.Evidence) != 1 || first.Evidence[0].UID != "ev-1" || first.Evidence[0].SHA256 != "abc123" {
+		t.Errorf("evidence = %+v", first.Evidence)
+	}
+	if first.ImageDigest != fakeImageID(first.Image) || first.ScriptSHA256 == "" {
+		t.Errorf("image %s (%s), script %q", first.Image, first.ImageDigest, first.ScriptSHA256)
+	}
+	if first.Started.IsZero() || first.Finished.Before(first.Started) {
+		t.Errorf("started %v, finished %v", first.Started, first.Finished)
+	}
+	if first.RunTime == nil || first.RunTime.After(first.Started) {
+		t.Errorf("run time %v, started %v", first.RunTime, first.Started)
+	}
+	if !first.Success || second.Success || second.ExitCode != 3 {
+		t.Errorf("outcomes = %v, %v (exit %d)", first.Success, second.Success, second.ExitCode)
+	}
+
+	head, err := r.Audit.Head("case-1")
+	if err != nil || head != second.Hash {
+		t.Errorf("head = %q, %v, want %q", head, err, second.Hash)
+	}
+	// A new process picks the chain up from the file.
+	reopened := &AuditLog{Dir: r.Audit.Dir}
+	if head, err := reopened.Head("case-1"); err != nil || head != second.Hash {
+		t.Errorf("reopened head = %q, %v, want %q", head, err, second.Hash)
+	}
+}
+
+func TestAuditRecordsStagedScript(t *testing.T) {
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main\n"), 0o644)
+	want, err := workspaceKey(job.Workspace, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The script writes to its workspace and the analyst edits the source
+	// tree while the job runs.
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerWorkspace {
+				os.WriteFile(filepath.Join(m.Source, "cache.bin"), []byte("x"), 0o644)
+			}
+		}
+		os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main // edited\n"), 0o644)
+	}
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ScriptSHA256 != want {
+		t.Errorf("result script = %s, want %s", res.ScriptSHA256, want)
+	}
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil || len(entries) != 1 || entries[0].ScriptSHA256 != want {
+		t.Errorf("audit = %+v, %v, want script %s", entries, err, want)
+	}
+}
+
+func TestVerifyAuditLogDetectsTampering(t *testing.T) {
+	l := &AuditLog{Dir: t.TempDir()}
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		job := testJob(t)
+		job.ID = id
+		if err := l.Record(job, &JobResult{JobID: id, Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := l.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(buf.String()), "\n")
+
+	tests := map[string]string{
+		"modified":  strings.Replace(buf.String(), `"success":true`, `"success":false`, 1),
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+	}
+	for name, log := range tests {
+		if _, err := VerifyAuditLog(strings.NewReader(log)); !errors.Is(err, ErrAuditTampered) {
+			t.Errorf("%s: err = %v, want ErrAuditTampered", name, err)
+		}
+	}
+	if err := l.Record(Job{ID: "x", CaseID: "../case-1"}, &JobResult{}); err == nil {
+		t.Error("recorded a job of case ../case-1")
+	}
+}
+
+func TestAuditLogReadsLargeEntries(t *testing.T) {
+	l := &AuditLog{Dir: t.TempDir()}
+	for _, id := range []string{"job-1", "job-2"} {
+		job := testJob(t)
+		job.ID = id
+		job.Params = map[string]string{"PARAM_IOCS": strings.Repeat("x", 2<<20)}
+		if err := l.Record(job, &JobResult{JobID: id, Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := l.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := VerifyAuditLog(&buf); err != nil || len(entries) != 2 {
+		t.Errorf("%d entries, %v, want 2", len(entries), err)
+	}
+}
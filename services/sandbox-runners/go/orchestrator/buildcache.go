@@ -0,0 +1,260 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths of the build cache inside sandbox containers.
+const (
+	containerBuildDir  = "/build"
+	containerScriptBin = "/opt/datamortem/script"
+)
+
+const (
+	cachedBinary   = "script"
+	buildDirPrefix = ".build-"
+)
+
+// BuildCache keeps compiled Go, Rust and Java scripts on a host volume, keyed by
+// a hash of the job's workspace, runner image and architecture, so that
+// re-running a parser does not recompile it.
+type BuildCache struct {
+	// Dir holds one directory per compiled script.
+	Dir string
+	// MaxBytes bounds the total size of the cached binaries; the least
+	// recently used are evicted first. Zero means unbounded.
+	MaxBytes int64
+
+	mu sync.Mutex
+}
+
+// workspaceKey hashes image and every regular file under dir, so that any
+// change to the sources, go.mod, go.sum, Cargo.lock or embedded files
+// yields a new key.
+func workspaceKey(dir, image string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	io.WriteString(h, image+"\x00")
+	for _, p := range files {
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		info, err := f.Stat()
+		if err == nil {
+			io.WriteString(h, filepath.ToSlash(rel)+"\x00"+strconv.FormatInt(info.Size(), 10)+"\x00")
+			_, err = io.Copy(h, f)
+		}
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup returns the binary cached under key and marks it as used.
+func (c *BuildCache) lookup(key string) (string, bool) {
+	bin := filepath.Join(c.Dir, key, cachedBinary)
+	if _, err := os.Stat(bin); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(bin, now, now)
+	return bin, true
+}
+
+// buildDir returns a fresh directory for a build, on the cache volume so
+// that store can rename it into place.
+func (c *BuildCache) buildDir() (string, error) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(c.Dir, buildDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	// The build runs as the sandbox user.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// store moves the binary built in dir under key and evicts old entries.
+func (c *BuildCache) store(key, dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, cachedBinary)); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	final := filepath.Join(c.Dir, key)
+	if err := os.Rename(dir, final); err != nil {
+		// A concurrent job built the same script first.
+		os.RemoveAll(dir)
+		if _, ok := c.lookup(key); !ok {
+			return "", err
+		}
+	}
+	c.evict(key)
+	return filepath.Join(final, cachedBinary), nil
+}
+
+// evict removes the least recently used entries other than keep until the
+// cache fits in MaxBytes.
+func (c *BuildCache) evict(keep string) {
+	if c.MaxBytes <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return
+	}
+	type entry struct {
+		key  string
+		size int64
+		used time.Time
+	}
+	var cached []entry
+	var total int64
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), buildDirPrefix) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(c.Dir, e.Name(), cachedBinary))
+		if err != nil {
+			continue
+		}
+		cached = append(cached, entry{e.Name(), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(cached, func(i, j int) bool { return cached[i].used.Before(cached[j].used) })
+	for _, e := range cached {
+		if total <= c.MaxBytes {
+			return
+		}
+		if e.key == keep {
+			continue
+		}
+		if os.RemoveAll(filepath.Join(c.Dir, e.key)) == nil {
+			total -= e.size
+		}
+	}
+}
+
+// cachedBuild returns the host path and SHA256 of the compiled script for
+// a Go, Rust or Java job, building it in a container derived from spec on
+// a cache miss. It reports false when the job is not cacheable or the
+// build fails; the job then runs with its language's run command, which
+// surfaces compile errors in its own logs. A build that ran and failed is
+// also described by failed, for JobResult.BuildLog.
+func (r *Runner) cachedBuild(ctx context.Context, job Job, spec ContainerSpec) (bin, sum string, failed *BuildLog, ok bool) {
+	c := r.BuildCache
+	if c == nil {
+		return "", "", nil, false
+	}
+	p, err := profile(job.Language)
+	if err != nil || p.Build == nil {
+		return "", "", nil, false
+	}
+	// An image ID may be shared by the cache of workers of several
+	// architectures; their binaries are not.
+	image := spec.Image + "\x00arch=" + r.arch()
+	if len(job.BuildTags) > 0 {
+		// Tags change the binary, not the sources.
+		image += "\x00tags=" + strings.Join(job.BuildTags, ",")
+	}
+	key, err := workspaceKey(job.Workspace, image)
+	if err != nil {
+		return "", "", nil, false
+	}
+	build := withBuildTags(p.Build, job.BuildTags)
+	bin, ok = c.lookup(key)
+	if !ok {
+		dir, err := c.buildDir()
+		if err != nil {
+			return "", "", nil, false
+		}
+		if out, err := r.build(ctx, spec, build, dir); err != nil {
+			os.RemoveAll(dir)
+			if out != "" {
+				failed = newBuildLog(build, spec.Env, out)
+			}
+			return "", "", failed, false
+		}
+		if bin, err = c.store(key, dir); err != nil {
+			return "", "", nil, false
+		}
+	}
+	// The binary is hashed on every run, as it is what the job executes.
+	if sum, _, err = fileSHA256(bin); err != nil {
+		return "", "", nil, false
+	}
+	return bin, sum, nil, true
+}
+
+// build runs cmd to compile the workspace of spec into dir. Only dir is
+// mounted from the cache, so a build cannot tamper with other entries.
+// When cmd exits non-zero, out is its combined output.
+func (r *Runner) build(ctx context.Context, spec ContainerSpec, cmd []string, dir string) (out string, err error) {
+	spec.Cmd = cmd
+	spec.Mounts = append(append([]Mount(nil), spec.Mounts...), Mount{Source: dir, Target: containerBuildDir})
+	id, err := r.createContainer(ctx, spec)
+	if err != nil {
+		return "", err
+	}
+	bg := context.WithoutCancel(ctx)
+	defer r.removeContainer(bg, id)
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		return "", err
+	}
+	state, err := r.Runtime.Wait(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if state.ExitCode != 0 {
+		var logs bytes.Buffer
+		r.Runtime.Logs(bg, id, &logs, &logs)
+		return logs.String(), fmt.Errorf("build: exit code %d", state.ExitCode)
+	}
+	return "", nil
+}
+
+// useCachedBuild makes spec run the cached binary bin, with the RunBuilt
+// command of p, instead of the language's run command.
+func useCachedBuild(spec *ContainerSpec, p runnerProfile, bin string) {
+	spec.Cmd = p.builtCmd(containerScriptBin)
+	spec.Mounts = append(spec.Mounts, Mount{Source: bin, Target: containerScriptBin, ReadOnly: true})
+}
@@ -0,0 +1,259 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// fakeBuild makes build containers produce a binary in their /build mount.
+func fakeBuild(spec ContainerSpec) {
+	if len(spec.Cmd) < 2 || spec.Cmd[1] != "build" {
+		return
+	}
+	for _, m := range spec.Mounts {
+		if m.Target == containerBuildDir {
+			os.WriteFile(filepath.Join(m.Source, cachedBinary), []byte("\x7fELF"), 0o755)
+		}
+	}
+}
+
+func TestWorkspaceKey(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0o644)
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module x"), 0o644)
+
+	key := func(image string) string {
+		k, err := workspaceKey(dir, image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	k1 := key("img")
+	if key("img") != k1 {
+		t.Error("key is not stable")
+	}
+	if key("other") == k1 {
+		t.Error("key ignores the image")
+	}
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module y"), 0o644)
+	if key("img") == k1 {
+		t.Error("key ignores go.mod")
+	}
+}
+
+func TestRunReusesCachedBuild(t *testing.T) {
+	rt := &fakeRuntime{onStart: fakeBuild}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+
+	for i := 0; i < 2; i++ {
+		job := testJob(t)
+		os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The SHA256 of fakeBuild's binary.
+		if res.BinarySHA256 != "3bdbb4fe8397cd2b842430b39ccff01a8663c751945ef5e9a09e267fb8b1d359" {
+			t.Errorf("binary SHA256 = %q", res.BinarySHA256)
+		}
+	}
+
+	if len(rt.specs) != 3 {
+		t.Fatalf("created %d containers, want a build and two runs", len(rt.specs))
+	}
+	build := rt.specs[0]
+	if want := []string{"go", "build", "-trimpath", "-buildvcs=false", "-ldflags=-buildid=", "-o", containerBuildDir + "/" + cachedBinary, "."}; !reflect.DeepEqual(build.Cmd, want) {
+		t.Errorf("build cmd = %v, want %v", build.Cmd, want)
+	}
+	if build.Env["CGO_ENABLED"] != "0" {
+		t.Errorf("build env = %v, want cgo disabled", build.Env)
+	}
+	for _, spec := range rt.specs[1:] {
+		if !reflect.DeepEqual(spec.Cmd, []string{containerScriptBin}) {
+			t.Errorf("cmd = %v, want the cached binary", spec.Cmd)
+		}
+		m := spec.Mounts[len(spec.Mounts)-1]
+		if m.Target != containerScriptBin || !m.ReadOnly || filepath.Dir(filepath.Dir(m.Source)) != r.BuildCache.Dir {
+			t.Errorf("binary mount = %+v", m)
+		}
+	}
+}
+
+func TestRunFallsBackWhenBuildFails(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Cmd; !reflect.DeepEqual(got, goRunCmd("go", "run", ".")) {
+		t.Errorf("cmd = %v, want go run", got)
+	}
+	entries, _ := os.ReadDir(r.BuildCache.Dir)
+	if len(entries) != 0 {
+		t.Errorf("cache left with %d entries", len(entries))
+	}
+}
+
+func TestBuildCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := &BuildCache{Dir: t.TempDir(), MaxBytes: 20}
+	base := time.Now().Add(-time.Hour)
+	for i, key := range []string{"old", "mid", "new"} {
+		dir := filepath.Join(c.Dir, key)
+		os.Mkdir(dir, 0o755)
+		bin := filepath.Join(dir, cachedBinary)
+		os.WriteFile(bin, make([]byte, 10), 0o755)
+		used := base.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(bin, used, used)
+	}
+	// A hit refreshes the entry.
+	if _, ok := c.lookup("old"); !ok {
+		t.Fatal("lookup missed")
+	}
+
+	c.evict("new")
+	var kept []string
+	entries, _ := os.ReadDir(c.Dir)
+	for _, e := range entries {
+		kept = append(kept, e.Name())
+	}
+	if want := []string{"new", "old"}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+}
+
+func TestRunCachesRustBuild(t *testing.T) {
+	builds := 0
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		if !reflect.DeepEqual(spec.Cmd, []string{"sh", "-c", rustBuildScript}) {
+			return
+		}
+		builds++
+		for _, m := range spec.Mounts {
+			if m.Target == containerBuildDir {
+				os.WriteFile(filepath.Join(m.Source, cachedBinary), []byte("\x7fELF"), 0o755)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+
+	run := func(lock string) ContainerSpec {
+		t.Helper()
+		job := testJob(t)
+		job.Language = LanguageRust
+		os.MkdirAll(filepath.Join(job.Workspace, "src"), 0o755)
+		os.WriteFile(filepath.Join(job.Workspace, "src", "main.rs"), []byte("fn main() {}"), 0o644)
+		os.WriteFile(filepath.Join(job.Workspace, "Cargo.lock"), []byte(lock), 0o644)
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		return rt.lastSpec()
+	}
+	run("version = 3")
+	spec := run("version = 3")
+	if builds != 1 {
+		t.Errorf("%d builds, want the second run served from the cache", builds)
+	}
+	if !reflect.DeepEqual(spec.Cmd, []string{containerScriptBin}) {
+		t.Errorf("cmd = %v, want the cached binary", spec.Cmd)
+	}
+	if spec.Env["CARGO_HOME"] != "/tmp/cargo-home" {
+		t.Errorf("CARGO_HOME = %q", spec.Env["CARGO_HOME"])
+	}
+	run("version = 3\n# serde bumped")
+	if builds != 2 {
+		t.Errorf("%d builds, want a rebuild after Cargo.lock changed", builds)
+	}
+}
+
+func TestRunCachesJavaBuild(t *testing.T) {
+	builds := 0
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		if !reflect.DeepEqual(spec.Cmd, []string{"sh", "-c", javaBuildScript}) {
+			return
+		}
+		builds++
+		for _, m := range spec.Mounts {
+			if m.Target == containerBuildDir {
+				os.WriteFile(filepath.Join(m.Source, cachedBinary), []byte("PK\x03\x04"), 0o644)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	job := testJob(t)
+	job.Language = LanguageJava
+	os.WriteFile(filepath.Join(job.Workspace, "Main.java"), []byte("class Main { public static void main(String[] a) {} }"), 0o644)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if builds != 1 {
+		t.Errorf("%d builds, want the second run served from the cache", builds)
+	}
+	spec := rt.lastSpec()
+	if want := []string{"sh", "-c", javaRunner, "java", containerScriptBin}; !reflect.DeepEqual(spec.Cmd, want) {
+		t.Errorf("cmd = %v, want the cached jar run by java", spec.Cmd)
+	}
+	if spec.Env["CLASSPATH"] != javaLibDir+"/*" {
+		t.Errorf("CLASSPATH = %q", spec.Env["CLASSPATH"])
+	}
+
+	// A pooled job runs the jar copied into its workspace.
+	p, err := NewPool(r, PoolConfig{Size: 1, Language: LanguageJava, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(context.Background())
+	if err := p.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	job = poolJob(t, "case-1")
+	job.Language = LanguageJava
+	os.WriteFile(filepath.Join(job.Workspace, "Main.java"), []byte("class Main { public static void main(String[] a) {} }"), 0o644)
+	if _, err := p.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	var ran []string
+	for _, e := range rt.execs {
+		if e.Cmd[0] == "sh" && len(e.Cmd) > 3 && e.Cmd[3] == "java" {
+			ran = e.Cmd
+		}
+	}
+	if want := []string{"sh", "-c", javaRunner, "java", path.Join(containerWorkspace, pooledBinary)}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("pooled cmd = %v, want %v", ran, want)
+	}
+}
+
+func TestJavaRunnerSizesHeap(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not in PATH")
+	}
+	// A fake java prints the arguments it is run with.
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "java"), []byte("#!/bin/sh\necho \"$@\"\n"), 0o755)
+	p, _ := profile(LanguageJava)
+	cmd := exec.Command("sh", append(p.RunBuilt[1:], "/build/script")...)
+	cmd.Env = []string{"PATH=" + bin + ":" + os.Getenv("PATH"), "CLASSPATH=/opt/lib/*", sandbox.EnvMemoryLimitBytes + "=1073741824"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "-Xmx768m -cp /build/script:/opt/lib/* Main"; got != want {
+		t.Errorf("java %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidBuild is returned for a job whose Job.BuildTags or Job.Replace
+// are malformed, point outside the workspace or outside
+// Runner.ReplaceAllowlist, or whose build settings, Job.Flavor and
+// Job.GoVersion included, are set for a language other than Go.
+var ErrInvalidBuild = errors.New("orchestrator: invalid build configuration")
+
+var (
+	buildTag = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+	// modulePath is the subset of module paths that go.mod accepts
+	// unquoted.
+	modulePath    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~+/-]*$`)
+	moduleVersion = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.+-]+)?$`)
+)
+
+// ModuleReplace is a go.mod replace directive of Job.Replace: module Old,
+// at OldVersion or at any version when empty, is built from New. New is
+// either a module path under Runner.ReplaceAllowlist, with NewVersion, or
+// a directory of the workspace holding the fork's go.mod, e.g.
+// "./third_party/fork", without.
+type ModuleReplace struct {
+	Old        string `json:"old"`
+	OldVersion string `json:"old_version,omitempty"`
+	New        string `json:"new"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+// String returns m in go.mod syntax, e.g. "example.org/lib => ./fork".
+func (m ModuleReplace) String() string {
+	s := m.Old
+	if m.OldVersion != "" {
+		s += " " + m.OldVersion
+	}
+	s += " => " + m.New
+	if m.NewVersion != "" {
+		s += " " + m.NewVersion
+	}
+	return s
+}
+
+// local reports whether m replaces its module by a directory.
+func (m ModuleReplace) local() bool {
+	return strings.HasPrefix(m.New, "./") || strings.HasPrefix(m.New, "../")
+}
+
+// BuildConfig is the effective build of a Go job given Job.BuildTags,
+// Job.Replace, Job.Flavor or Job.GoVersion, recorded in JobResult.Build and
+// the audit log to rebuild the same binary.
+type BuildConfig struct {
+	// Command compiles the script, e.g. `go build -trimpath ... -tags=debug
+	// -o /build/script .` through Runner.BuildCache, or `go run
+	// -tags=debug .`.
+	Command []string        `json:"command"`
+	Tags    []string        `json:"tags,omitempty"`
+	Replace []ModuleReplace `json:"replace,omitempty"`
+	// Flavor and FlavorModules are the flavor the job ran in and the
+	// modules it held.
+	Flavor        string   `json:"flavor,omitempty"`
+	FlavorModules []string `json:"flavor_modules,omitempty"`
+	// GoVersion is the Job.GoVersion the job selected.
+	GoVersion string `json:"go_version,omitempty"`
+}
+
+// customBuild reports whether job changes how its script is built.
+func (j Job) customBuild() bool {
+	return len(j.BuildTags) > 0 || len(j.Replace) > 0 || j.Flavor != "" || j.GoVersion != ""
+}
+
+// buildConfig returns the BuildConfig of job built with cmd, nil when job
+// does not customise its build.
+func buildConfig(job Job, cmd []string) *BuildConfig {
+	if !job.customBuild() {
+		return nil
+	}
+	return &BuildConfig{
+		Command:   append([]string(nil), cmd...),
+		Tags:      append([]string(nil), job.BuildTags...),
+		Replace:   append([]ModuleReplace(nil), job.Replace...),
+		Flavor:    job.Flavor,
+		GoVersion: job.GoVersion,
+	}
+}
+
+// validateBuild checks the build tags, module replacements and Go version
+// of job.
+func (r *Runner) validateBuild(job Job) error {
+	if !job.customBuild() {
+		return nil
+	}
+	if languageKey(job.Language) != LanguageGo {
+		return fmt.Errorf("%w: build tags, replacements, flavors and Go versions only apply to Go jobs", ErrInvalidBuild)
+	}
+	if err := r.validateGoVersion(job); err != nil {
+		return err
+	}
+	if job.Flavor != "" {
+		if _, err := r.flavor(job.Flavor); err != nil {
+			return err
+		}
+	}
+	for _, tag := range job.BuildTags {
+		if !buildTag.MatchString(tag) {
+			return fmt.Errorf("%w: build tag %q must be letters, digits, '_' or '.'", ErrInvalidBuild, tag)
+		}
+	}
+	seen := map[string]bool{}
+	for _, m := range job.Replace {
+		if err := r.validateReplace(m); err != nil {
+			return fmt.Errorf("%w: replace %s: %v", ErrInvalidBuild, m, err)
+		}
+		key := strings.TrimSpace(m.Old + " " + m.OldVersion)
+		if seen[key] {
+			return fmt.Errorf("%w: %s is replaced twice", ErrInvalidBuild, key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func (r *Runner) validateReplace(m ModuleReplace) error {
+	if !validModulePath(m.Old) {
+		return fmt.Errorf("invalid module path %q", m.Old)
+	}
+	if m.OldVersion != "" && !moduleVersion.MatchString(m.OldVersion) {
+		return fmt.Errorf("invalid version %q", m.OldVersion)
+	}
+	if m.local() {
+		dir := path.Clean(m.New)
+		switch {
+		case m.NewVersion != "":
+			return errors.New("a directory replacement takes no version")
+		case dir == "." || dir == ".." || strings.HasPrefix(dir, "../"):
+			return errors.New("directory outside the workspace")
+		case !validModulePath(dir):
+			return fmt.Errorf("invalid directory %q", m.New)
+		}
+		return nil
+	}
+	switch {
+	case !validModulePath(m.New):
+		return fmt.Errorf("invalid module path %q, or a directory not starting with ./", m.New)
+	case !moduleVersion.MatchString(m.NewVersion):
+		return fmt.Errorf("a module replacement needs a version, got %q", m.NewVersion)
+	case !hasModulePrefix(m.New, r.ReplaceAllowlist):
+		return fmt.Errorf("module %s is not under Runner.ReplaceAllowlist", m.New)
+	}
+	return nil
+}
+
+// validModulePath reports whether p is a slash-separated path without
+// empty, "." or ".." elements, which go.mod accepts unquoted.
+func validModulePath(p string) bool {
+	if !modulePath.MatchString(p) {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// hasModulePrefix reports whether module is one of prefixes or below one,
+// on a path element boundary.
+func hasModulePrefix(module string, prefixes []string) bool {
+	for _, p := range prefixes {
+		p = strings.TrimSuffix(p, "/")
+		if p != "" && (module == p || strings.HasPrefix(module, p+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// withBuildTags adds tags to the go command cmd, e.g. `go run .`.
+func withBuildTags(cmd, tags []string) []string {
+	if len(tags) == 0 || len(cmd) < 2 || cmd[0] != "go" {
+		return cmd
+	}
+	return append([]string{cmd[0], cmd[1], "-tags=" + strings.Join(tags, ",")}, cmd[2:]...)
+}
+
+// applyReplacements appends the replace directives of replace to the
+// go.mod of the staged workspace dir. A directory replacement must hold a
+// go.mod and resolve, symbolic links followed, inside dir.
+func applyReplacements(dir string, replace []ModuleReplace) error {
+	if len(replace) == 0 {
+		return nil
+	}
+	gomod := filepath.Join(dir, "go.mod")
+	data, err := os.ReadFile(gomod)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: replacements need a go.mod", ErrInvalidBuild)
+	}
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	b.Write(data)
+	b.WriteString("\n// Added by the orchestrator from Job.Replace.\nreplace (\n")
+	for _, m := range replace {
+		if m.local() {
+			target, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(m.New)))
+			if err == nil {
+				_, err = os.Stat(filepath.Join(target, "go.mod"))
+			}
+			if err != nil {
+				return fmt.Errorf("%w: replace %s: %v", ErrInvalidBuild, m, err)
+			}
+			if rel, err := filepath.Rel(root, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("%w: replace %s: directory outside the workspace", ErrInvalidBuild, m)
+			}
+		}
+		b.WriteString("\t" + m.String() + "\n")
+	}
+	b.WriteString(")\n")
+	return os.WriteFile(gomod, []byte(b.String()), 0o644)
+}
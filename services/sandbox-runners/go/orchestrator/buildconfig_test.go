@@ -0,0 +1,150 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBuild(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.ReplaceAllowlist = []string{"git.lab.example/forks/"}
+	fork := ModuleReplace{Old: "golang.org/x/sys", New: "./third_party/sys"}
+	for name, tc := range map[string]struct {
+		lang    string
+		tags    []string
+		replace []ModuleReplace
+		ok      bool
+	}{
+		"none":         {ok: true},
+		"tags":         {tags: []string{"debug", "go1.21", "with_yara"}, ok: true},
+		"workspace":    {replace: []ModuleReplace{fork}, ok: true},
+		"allowlisted":  {replace: []ModuleReplace{{Old: "golang.org/x/sys", OldVersion: "v0.15.0", New: "git.lab.example/forks/sys", NewVersion: "v0.15.1-lab.1"}}, ok: true},
+		"python":       {lang: "python", tags: []string{"debug"}},
+		"bad tag":      {tags: []string{"debug,trace"}},
+		"flag tag":     {tags: []string{"-toolexec=sh"}},
+		"escape":       {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "../../etc"}}},
+		"inner escape": {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "./a/../../b"}}},
+		"absolute":     {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "/tmp/sys"}}},
+		"dir version":  {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "./sys", NewVersion: "v1.0.0"}}},
+		"not allowed":  {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "git.lab.example/forksx/sys", NewVersion: "v1.0.0"}}},
+		"no version":   {replace: []ModuleReplace{{Old: "golang.org/x/sys", New: "git.lab.example/forks/sys"}}},
+		"newline":      {replace: []ModuleReplace{{Old: "golang.org/x/sys\nreplace x", New: "./sys"}}},
+		"twice":        {replace: []ModuleReplace{fork, fork}},
+	} {
+		job := testJob(t)
+		if tc.lang != "" {
+			job.Language = tc.lang
+		}
+		job.BuildTags, job.Replace = tc.tags, tc.replace
+		if err := r.validateBuild(job); (err == nil) != tc.ok || (err != nil && !errors.Is(err, ErrInvalidBuild)) {
+			t.Errorf("%s: validateBuild() = %v", name, err)
+		}
+	}
+}
+
+func TestRunWithBuildTags(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.BuildTags = []string{"debug", "trace"}
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := []string{"go", "run", "-tags=debug,trace", "."}
+	if got := rt.lastSpec().Cmd; !reflect.DeepEqual(got, goRunCmd(run...)) {
+		t.Errorf("cmd = %v", got)
+	}
+	if want := (&BuildConfig{Command: run, Tags: job.BuildTags}); !reflect.DeepEqual(res.Build, want) {
+		t.Errorf("Build = %+v, want %+v", res.Build, want)
+	}
+
+	// The tags are part of the cached binary.
+	rt = &fakeRuntime{onStart: fakeBuild}
+	r = NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	for _, tags := range [][]string{nil, {"debug"}} {
+		job := testJob(t)
+		job.BuildTags = tags
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rt.specs) != 4 {
+		t.Fatalf("created %d containers, want two builds and two runs", len(rt.specs))
+	}
+	if got := rt.specs[2].Cmd; len(got) < 3 || got[2] != "-tags=debug" {
+		t.Errorf("build cmd = %v", got)
+	}
+}
+
+func TestRunWithReplacements(t *testing.T) {
+	var gomod string
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		data, _ := os.ReadFile(filepath.Join(spec.Mounts[0].Source, "go.mod"))
+		gomod = string(data)
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.ReplaceAllowlist = []string{"git.lab.example/forks"}
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "go.mod"), []byte("module script\n"), 0o644)
+	os.MkdirAll(filepath.Join(job.Workspace, "third_party", "sys"), 0o755)
+	os.WriteFile(filepath.Join(job.Workspace, "third_party", "sys", "go.mod"), []byte("module golang.org/x/sys\n"), 0o644)
+	job.Replace = []ModuleReplace{
+		{Old: "golang.org/x/sys", New: "./third_party/sys"},
+		{Old: "github.com/Velocidex/go-ntfs", OldVersion: "v0.2.0", New: "git.lab.example/forks/go-ntfs", NewVersion: "v0.2.1"},
+	}
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "module script\n\n// Added by the orchestrator from Job.Replace.\nreplace (\n" +
+		"\tgolang.org/x/sys => ./third_party/sys\n" +
+		"\tgithub.com/Velocidex/go-ntfs v0.2.0 => git.lab.example/forks/go-ntfs v0.2.1\n)\n"
+	if gomod != want {
+		t.Errorf("staged go.mod %q, want %q", gomod, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(job.Workspace, "go.mod")); string(data) != "module script\n" {
+		t.Errorf("workspace go.mod changed to %q", data)
+	}
+	if res.Build == nil || !reflect.DeepEqual(res.Build.Replace, job.Replace) {
+		t.Errorf("Build = %+v", res.Build)
+	}
+
+	// A directory reached through a symlink is not staged, so cannot
+	// replace a module.
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "go.mod"), []byte("module golang.org/x/sys\n"), 0o644)
+	os.Symlink(outside, filepath.Join(job.Workspace, "link"))
+	job.Replace = []ModuleReplace{{Old: "golang.org/x/sys", New: "./link"}}
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrInvalidBuild) {
+		t.Errorf("Run() through a symlink = %v, want ErrInvalidBuild", err)
+	}
+	entries, _ := os.ReadDir(r.WorkDir)
+	if len(entries) != 0 {
+		t.Errorf("work dir left with %d entries", len(entries))
+	}
+}
+
+func TestJobFingerprintHasBuild(t *testing.T) {
+	job := testJob(t)
+	key := func() string {
+		k, ok := jobFingerprint(job, "img")
+		if !ok {
+			t.Fatal("job not cacheable")
+		}
+		return k
+	}
+	base := key()
+	job.BuildTags = []string{"debug"}
+	tagged := key()
+	job.Replace = []ModuleReplace{{Old: "golang.org/x/sys", New: "./sys"}}
+	if base == tagged || tagged == key() {
+		t.Error("fingerprint ignores the build configuration")
+	}
+}
@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"sort"
+	"strings"
+)
+
+// BuildLog is how the Go script of a job that failed to compile was
+// built, for an analyst to debug the build apart from the script's own
+// stderr.
+type BuildLog struct {
+	// Command is the compiler invocation with its flags, e.g. `go build
+	// -trimpath -buildvcs=false -ldflags=-buildid= -o /build/script .` for
+	// a job of a BuildCache, `go run .` otherwise.
+	Command []string
+	// Env is the toolchain environment of the build: the GO* and CGO_*
+	// variables, such as GOFLAGS=-mod=vendor for an offline job.
+	Env map[string]string
+	// Output is the complete output of the compiler.
+	Output string
+	// Errors are the diagnostics of Output, with the file of the
+	// workspace and the line each one is on.
+	Errors []Diagnostic
+}
+
+// String formats l as a shell session: the environment and command, then
+// the output.
+func (l *BuildLog) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	names := make([]string, 0, len(l.Env))
+	for name := range l.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(" " + name + "=" + shellQuote(l.Env[name]))
+	}
+	for _, arg := range l.Command {
+		b.WriteString(" " + shellQuote(arg))
+	}
+	b.WriteString("\n" + l.Output)
+	if l.Output != "" && !strings.HasSuffix(l.Output, "\n") {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// shellQuote quotes s for a POSIX shell when it holds anything but plain
+// characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,+@") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// toolchainEnv returns the Go toolchain variables of env.
+func toolchainEnv(env map[string]string) map[string]string {
+	out := map[string]string{}
+	for name, value := range env {
+		if strings.HasPrefix(name, "GO") || strings.HasPrefix(name, "CGO_") {
+			out[name] = value
+		}
+	}
+	return out
+}
+
+// newBuildLog returns the log of a Go build of cmd with env, which
+// printed output.
+func newBuildLog(cmd []string, env map[string]string, output string) *BuildLog {
+	diags, _ := parseBuildOutput(output)
+	return &BuildLog{
+		Command: append([]string(nil), cmd...),
+		Env:     toolchainEnv(env),
+		Output:  output,
+		Errors:  diags,
+	}
+}
+
+// attachBuildLog sets res.BuildLog for a Go job that failed to compile
+// with the environment env: failed is the log of the BuildCache build
+// that failed, if any. Without it, the script was built by its run
+// command, whose stderr holds the compiler output since the script never
+// started.
+func (res *JobResult) attachBuildLog(job Job, failed *BuildLog, env map[string]string) {
+	if res.FailureReason != FailureCompileError || languageKey(job.Language) != LanguageGo {
+		return
+	}
+	if failed != nil {
+		log := *failed
+		log.Output = newScrubber(job.Secrets).scrub(log.Output)
+		res.BuildLog = &log
+		return
+	}
+	p, _ := profile(job.Language)
+	res.BuildLog = newBuildLog(withBuildTags(p.Cmd, job.BuildTags), env, res.Stderr)
+}
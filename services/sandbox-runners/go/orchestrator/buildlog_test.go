@@ -0,0 +1,84 @@
+package orchestrator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const buildErrors = "# example.com/triage\n./main.go:12:2: undefined: parseMFT\n./mft/record.go:40:9: cannot use n (variable of type int) as string value in return statement\n"
+
+func TestRunRecordsBuildLog(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 1}, stderr: buildErrors}
+	r := NewRunner(rt)
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := res.BuildLog
+	if res.FailureReason != FailureCompileError || log == nil {
+		t.Fatalf("failure = %s, build log = %+v", res.FailureReason, log)
+	}
+	if !reflect.DeepEqual(log.Command, []string{"go", "run", "."}) || log.Env["CGO_ENABLED"] != "0" || log.Env["GOCACHE"] == "" || log.Output != buildErrors {
+		t.Errorf("build log = %+v", log)
+	}
+	if _, ok := log.Env["CASE_ID"]; ok {
+		t.Errorf("build env = %v, want the toolchain variables only", log.Env)
+	}
+	want := []Diagnostic{
+		{File: "main.go", Line: 12, Column: 2, Message: "undefined: parseMFT"},
+		{File: "mft/record.go", Line: 40, Column: 9, Message: "cannot use n (variable of type int) as string value in return statement"},
+	}
+	if !reflect.DeepEqual(log.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", log.Errors, want)
+	}
+	if s := log.String(); !strings.HasPrefix(s, "$ CGO_ENABLED=0 GOCACHE=/tmp/go-cache GOTMPDIR=/workspace go run .\n# example.com") {
+		t.Errorf("build log = %q", s)
+	}
+}
+
+func TestRunRecordsCachedBuildLog(t *testing.T) {
+	rt := &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Cmd[1] == "build" {
+			return ContainerState{ExitCode: 1}, buildErrors
+		}
+		return ContainerState{ExitCode: 1}, "# example.com/triage\n./main.go:12:2: undefined: parseMFT\n"
+	}}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	job := testJob(t)
+	job.Secrets = map[string]string{"VT_API_KEY": "parseMFT"}
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := res.BuildLog
+	if log == nil || log.Command[1] != "build" || !reflect.DeepEqual(log.Command[2:5], goBuildFlags) || len(log.Errors) != 2 {
+		t.Fatalf("build log = %+v, want that of the cached build", log)
+	}
+	if strings.Contains(log.Output, "parseMFT") {
+		t.Errorf("output = %q, want the secret masked", log.Output)
+	}
+}
+
+func TestRunWithoutBuildLog(t *testing.T) {
+	for name, tc := range map[string]struct {
+		language string
+		stderr   string
+	}{
+		"runtime error": {LanguageGo, "panic: runtime error: index out of range [3] with length 3\n\ngoroutine 1 [running]:\nmain.main()\n\t/workspace/main.go:9 +0x1d\n"},
+		"python":        {LanguagePython, "  File \"script.py\", line 3\n    def f(\n         ^\nSyntaxError: '(' was never closed\n"},
+	} {
+		rt := &fakeRuntime{state: ContainerState{ExitCode: 2}, stderr: tc.stderr}
+		job := testJob(t)
+		job.Language = tc.language
+		res, err := NewRunner(rt).Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.BuildLog != nil {
+			t.Errorf("%s: build log = %+v", name, res.BuildLog)
+		}
+	}
+}
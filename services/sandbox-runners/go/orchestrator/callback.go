@@ -0,0 +1,435 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers of a callback request, see Callbacks.
+const (
+	// CallbackSignatureHeader holds "sha256=" and the hex HMAC-SHA256,
+	// keyed with Callbacks.Secret, of the timestamp, a dot and the body.
+	CallbackSignatureHeader = "X-Datamortem-Signature"
+	// CallbackTimestampHeader holds the Unix time the request was signed,
+	// so that receivers can refuse replayed requests.
+	CallbackTimestampHeader = "X-Datamortem-Timestamp"
+	// CallbackDeliveryHeader identifies the callback, the same for every
+	// attempt, so that receivers can discard duplicates.
+	CallbackDeliveryHeader = "X-Datamortem-Delivery"
+)
+
+// Statuses of CallbackPayload.
+const (
+	CallbackSucceeded = "succeeded"
+	CallbackFailed    = "failed"
+	CallbackCancelled = "cancelled"
+	// CallbackSkipped jobs found their evidence not applicable, see
+	// JobResult.NotApplicable; FailureDetail holds the reason.
+	CallbackSkipped = "skipped"
+)
+
+// Defaults of Callbacks.
+const (
+	DefaultCallbackAttempts = 5
+	DefaultCallbackBackoff  = time.Second
+	DefaultCallbackTimeout  = 10 * time.Second
+	// DefaultCallbackDeadLetters is the number of dead letters
+	// Callbacks.DeadLetters keeps.
+	DefaultCallbackDeadLetters = 1000
+)
+
+// ErrInvalidCallback is returned for a Job.Callback that is not an http or
+// https URL, holds credentials, names a host Callbacks.AllowedHosts does
+// not list or a private address, or is set without Runner.Callbacks and
+// its Secret. A callback whose host resolves to a private address fails
+// with it too, and is dead-lettered.
+var ErrInvalidCallback = errors.New("orchestrator: invalid callback")
+
+// ErrCallbackSignature is returned by VerifyCallback for a request that is
+// unsigned, signed with another secret or too old.
+var ErrCallbackSignature = errors.New("orchestrator: invalid callback signature")
+
+// CallbackPayload is the JSON body POSTed to Job.Callback when the job
+// finishes: a summary of its JobResult, whose records the receiver fetches
+// from the platform.
+type CallbackPayload struct {
+	JobID    string `json:"job_id"`
+	CaseID   string `json:"case_id"`
+	ParentID string `json:"parent_id,omitempty"`
+	// Status is CallbackSucceeded, CallbackFailed, CallbackCancelled or
+	// CallbackSkipped.
+	Status        string            `json:"status"`
+	ExitCode      int               `json:"exit_code"`
+	FailureReason FailureReason     `json:"failure_reason,omitempty"`
+	FailureDetail string            `json:"failure_detail,omitempty"`
+	TimedOut      bool              `json:"timed_out,omitempty"`
+	Incomplete    bool              `json:"incomplete,omitempty"`
+	FromCache     bool              `json:"from_cache,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	// The counts of the records the job produced.
+	Findings       int       `json:"findings"`
+	IOCs           int       `json:"iocs"`
+	Artifacts      int       `json:"artifacts"`
+	TimelineEvents int       `json:"timeline_events"`
+	Warnings       int       `json:"warnings"`
+	Started        time.Time `json:"started"`
+	Finished       time.Time `json:"finished"`
+}
+
+// callbackPayload summarises res, the result of job.
+func callbackPayload(job Job, res *JobResult) CallbackPayload {
+	p := CallbackPayload{
+		JobID:          job.ID,
+		CaseID:         job.CaseID,
+		ParentID:       job.ParentID,
+		Status:         CallbackFailed,
+		ExitCode:       res.ExitCode,
+		FailureReason:  res.FailureReason,
+		FailureDetail:  res.FailureDetail,
+		TimedOut:       res.TimedOut,
+		Incomplete:     res.Incomplete,
+		FromCache:      res.FromCache,
+		Labels:         copyLabels(job.Labels),
+		Findings:       len(res.Findings),
+		IOCs:           len(res.IOCs),
+		Artifacts:      len(res.Artifacts),
+		TimelineEvents: len(res.Timeline),
+		Warnings:       len(res.Warnings),
+		Started:        res.Metrics.Started.UTC(),
+		Finished:       res.Metrics.Started.Add(res.Metrics.Duration).UTC(),
+	}
+	switch {
+	case res.Cancelled:
+		p.Status = CallbackCancelled
+	case res.NotApplicable:
+		p.Status = CallbackSkipped
+	case res.Success:
+		p.Status = CallbackSucceeded
+	}
+	if res.Metrics.Started.IsZero() {
+		// Cancelled before its container started, or from the cache.
+		p.Started = time.Now().UTC()
+		p.Finished = p.Started
+	}
+	return p
+}
+
+// CallbackDeadLetter is a callback that could not be delivered.
+type CallbackDeadLetter struct {
+	URL      string          `json:"url"`
+	Delivery string          `json:"delivery"`
+	Payload  CallbackPayload `json:"payload"`
+	Attempts int             `json:"attempts"`
+	// Error is the failure of the last attempt.
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// Callbacks POSTs a CallbackPayload to the Job.Callback of every job the
+// runner finishes, successful, failed or cancelled, cached results
+// included. Deliveries run in the background and never hold up the job:
+// a request that fails with a network error, a 5xx or a 429 status is
+// retried with exponential backoff, while other statuses, redirects
+// included, are not; a callback that still fails is dead-lettered. Set it
+// as Runner.Callbacks. It is safe for concurrent use.
+type Callbacks struct {
+	// Secret keys the HMAC signature of every request, see
+	// CallbackSignatureHeader and VerifyCallback.
+	Secret []byte
+	// AllowedHosts restricts the hosts of Job.Callback, as
+	// ExecConfig.AllowedHosts does for egress; any host is accepted when
+	// empty.
+	AllowedHosts []string
+	// AllowPrivateNetworks lets callbacks reach loopback, private (RFC
+	// 1918, fc00::/7), link-local (the 169.254.169.254 of cloud metadata
+	// included) and unspecified addresses, e.g. a receiver on the same
+	// host. They are refused otherwise: the address each request dials is
+	// checked, whatever name resolved to it, and requests go direct rather
+	// than through the proxy of the environment. A Client whose Transport
+	// is not an *http.Transport is trusted to make its own checks.
+	AllowPrivateNetworks bool
+	// Retry bounds the attempts of a delivery and the delay between them;
+	// DefaultCallbackAttempts and DefaultCallbackBackoff when zero.
+	Retry RetryPolicy
+	// Client sends the requests; one with a DefaultCallbackTimeout
+	// timeout when nil. Redirects are never followed.
+	Client *http.Client
+	// DeadLetterFile, when set, is appended a JSON line per dead letter,
+	// to replay them once the receiver is back.
+	DeadLetterFile string
+	// MaxDeadLetters bounds the dead letters DeadLetters keeps, the most
+	// recent ones; DefaultCallbackDeadLetters when zero. DeadLetterFile
+	// keeps them all.
+	MaxDeadLetters int
+
+	once      sync.Once
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	transport http.RoundTripper
+
+	mu   sync.Mutex
+	dead []CallbackDeadLetter
+}
+
+func (c *Callbacks) init() {
+	c.once.Do(func() {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+		if c.Client != nil {
+			c.transport = c.Client.Transport
+		}
+		c.transport = c.guard(c.transport)
+	})
+}
+
+// guard returns rt, nil for http.DefaultTransport, refusing to dial
+// private addresses unless c.AllowPrivateNetworks.
+func (c *Callbacks) guard(rt http.RoundTripper) http.RoundTripper {
+	if c.AllowPrivateNetworks {
+		return rt
+	}
+	var t *http.Transport
+	switch base := rt.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = base.Clone()
+	default:
+		return rt
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublic}
+	t.DialContext = dialer.DialContext
+	t.Proxy = nil
+	return t
+}
+
+// dialPublic refuses to connect to address, an IP and port, when the IP is
+// private.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := ap.Addr().Unmap(); privateAddr(ip) {
+		return fmt.Errorf("%w: %s is a private address", ErrInvalidCallback, ip)
+	}
+	return nil
+}
+
+// privateAddr reports whether ip is a loopback, private, link-local or
+// unspecified address, see Callbacks.AllowPrivateNetworks.
+func privateAddr(ip netip.Addr) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// validateCallback checks the Job.Callback u, if any.
+func (r *Runner) validateCallback(u string) error {
+	if u == "" {
+		return nil
+	}
+	if r.Callbacks == nil || len(r.Callbacks.Secret) == 0 {
+		return fmt.Errorf("%w: job callbacks require Runner.Callbacks and its Secret", ErrInvalidCallback)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("%w: %q is not an http or https URL", ErrInvalidCallback, u)
+	}
+	if parsed.User != nil {
+		return fmt.Errorf("%w: %s holds credentials", ErrInvalidCallback, parsed.Redacted())
+	}
+	if len(r.Callbacks.AllowedHosts) > 0 && !hostAllowed(parsed.Hostname(), r.Callbacks.AllowedHosts) {
+		return fmt.Errorf("%w: host %s is not in the allowlist", ErrInvalidCallback, parsed.Hostname())
+	}
+	if ip, err := netip.ParseAddr(parsed.Hostname()); err == nil && !r.Callbacks.AllowPrivateNetworks && privateAddr(ip.Unmap()) {
+		return fmt.Errorf("%w: %s is a private address", ErrInvalidCallback, ip)
+	}
+	return nil
+}
+
+// send delivers the callback of job, which ended with res, in the
+// background.
+func (c *Callbacks) send(job Job, res *JobResult) {
+	c.init()
+	payload := callbackPayload(job, res)
+	delivery := job.ID + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if c.ctx.Err() != nil {
+		c.deadLetter(CallbackDeadLetter{URL: job.Callback, Delivery: delivery, Payload: payload, Error: "callbacks closed", Time: time.Now().UTC()})
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.deliver(job.Callback, delivery, payload)
+	}()
+}
+
+// deliver POSTs payload to u until it is accepted, the attempts run out
+// or c is closed, then dead-letters it.
+func (c *Callbacks) deliver(u, delivery string, payload CallbackPayload) {
+	policy := c.Retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = DefaultCallbackAttempts
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = DefaultCallbackBackoff
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		c.deadLetter(CallbackDeadLetter{URL: u, Delivery: delivery, Payload: payload, Error: err.Error(), Time: time.Now().UTC()})
+		return
+	}
+	attempt := 1
+	for ; ; attempt++ {
+		var retry bool
+		if retry, err = c.post(u, delivery, body); err == nil {
+			return
+		}
+		if !retry || attempt >= policy.MaxAttempts || !c.sleep(policy.delay(attempt+1)) {
+			break
+		}
+	}
+	c.deadLetter(CallbackDeadLetter{URL: u, Delivery: delivery, Payload: payload, Attempts: attempt, Error: err.Error(), Time: time.Now().UTC()})
+}
+
+// sleep waits for d and reports whether c is still open.
+func (c *Callbacks) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// post makes one attempt at delivering body to u, and reports whether a
+// failure is worth retrying.
+func (c *Callbacks) post(u, delivery string, body []byte) (retry bool, err error) {
+	// Close lets the request in flight finish, within the client timeout.
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(CallbackTimestampHeader, ts)
+	req.Header.Set(CallbackSignatureHeader, "sha256="+signCallback(c.Secret, ts, body))
+	req.Header.Set(CallbackDeliveryHeader, delivery)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		// A private address is refused again on every attempt.
+		return !errors.Is(err, ErrInvalidCallback), err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("callback answered %s", resp.Status)
+	default:
+		return false, fmt.Errorf("callback answered %s", resp.Status)
+	}
+}
+
+func (c *Callbacks) client() *http.Client {
+	client := http.Client{Timeout: DefaultCallbackTimeout}
+	if c.Client != nil {
+		client = *c.Client
+	}
+	client.Transport = c.transport
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return &client
+}
+
+func (c *Callbacks) deadLetter(d CallbackDeadLetter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dead = append(c.dead, d)
+	keep := c.MaxDeadLetters
+	if keep <= 0 {
+		keep = DefaultCallbackDeadLetters
+	}
+	defer func() {
+		if len(c.dead) > keep {
+			c.dead = c.dead[len(c.dead)-keep:]
+		}
+	}()
+	if c.DeadLetterFile == "" {
+		return
+	}
+	line, err := json.Marshal(d)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(c.DeadLetterFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err == nil {
+			_, err = f.Write(append(line, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		c.dead[len(c.dead)-1].Error += fmt.Sprintf(" (not written to %s: %v)", c.DeadLetterFile, err)
+	}
+}
+
+// DeadLetters returns the callbacks that could not be delivered since c
+// was created, in order, up to MaxDeadLetters of the most recent.
+func (c *Callbacks) DeadLetters() []CallbackDeadLetter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CallbackDeadLetter(nil), c.dead...)
+}
+
+// Close waits for the requests in flight, then dead-letters the callbacks
+// that were waiting to be retried instead of retrying them. Callbacks of
+// jobs that finish later are dead-lettered at once.
+func (c *Callbacks) Close() {
+	c.init()
+	c.cancel()
+	c.wg.Wait()
+}
+
+// signCallback is the hex HMAC-SHA256 of ts and body with secret.
+func signCallback(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, ts+".")
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCallback checks, for a receiver written in Go, that body and
+// header were sent by Callbacks with secret less than maxAge ago; zero
+// does not check the age. It returns ErrCallbackSignature otherwise.
+func VerifyCallback(secret []byte, header http.Header, body []byte, maxAge time.Duration) error {
+	ts := header.Get(CallbackTimestampHeader)
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or malformed %s", ErrCallbackSignature, CallbackTimestampHeader)
+	}
+	if maxAge > 0 && time.Since(time.Unix(sent, 0)) > maxAge {
+		return fmt.Errorf("%w: signed %s ago", ErrCallbackSignature, time.Since(time.Unix(sent, 0)).Round(time.Second))
+	}
+	want := "sha256=" + signCallback(secret, ts, body)
+	if !hmac.Equal([]byte(header.Get(CallbackSignatureHeader)), []byte(want)) {
+		return fmt.Errorf("%w: signature mismatch", ErrCallbackSignature)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// callbackRequest is a request received by a callbackServer.
+type callbackRequest struct {
+	header  http.Header
+	body    []byte
+	payload CallbackPayload
+}
+
+// callbackServer answers the callbacks it receives with the statuses of
+// status, in order, then 200.
+type callbackServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   []int
+	requests []callbackRequest
+}
+
+func newCallbackServer(t *testing.T, status ...int) *callbackServer {
+	s := &callbackServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var p CallbackPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("callback body %q: %v", body, err)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, callbackRequest{req.Header, body, p})
+		code := http.StatusOK
+		if len(s.status) > 0 {
+			code, s.status = s.status[0], s.status[1:]
+		}
+		s.mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *callbackServer) received() []callbackRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]callbackRequest(nil), s.requests...)
+}
+
+var callbackSecret = []byte("s3cret")
+
+func TestCallbackOnCompletion(t *testing.T) {
+	srv := newCallbackServer(t)
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Callbacks = &Callbacks{Secret: callbackSecret, AllowPrivateNetworks: true}
+
+	job := testJob(t)
+	job.Callback = srv.URL + "/hooks/sandbox"
+	job.Labels = map[string]string{"pipeline": "triage"}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	rt.state = ContainerState{ExitCode: 2}
+	job.ID = "job-2"
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	// A job without a callback is not notified.
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	r.Callbacks.Close()
+
+	got := srv.received()
+	if len(got) != 2 {
+		t.Fatalf("%d callbacks, want 2", len(got))
+	}
+	byJob := map[string]CallbackPayload{}
+	for _, req := range got {
+		if err := VerifyCallback(callbackSecret, req.header, req.body, time.Minute); err != nil {
+			t.Errorf("VerifyCallback() = %v", err)
+		}
+		if req.header.Get("Content-Type") != "application/json" || req.header.Get(CallbackDeliveryHeader) == "" {
+			t.Errorf("headers %v", req.header)
+		}
+		byJob[req.payload.JobID] = req.payload
+	}
+	if p := byJob["job-1"]; p.Status != CallbackSucceeded || p.CaseID != "case-1" || p.Labels["pipeline"] != "triage" || p.Finished.Before(p.Started) {
+		t.Errorf("payload of job-1 %+v", p)
+	}
+	if p := byJob["job-2"]; p.Status != CallbackFailed || p.ExitCode != 2 || p.FailureReason == "" {
+		t.Errorf("payload of job-2 %+v", p)
+	}
+	if dead := r.Callbacks.DeadLetters(); len(dead) != 0 {
+		t.Errorf("dead letters %+v", dead)
+	}
+}
+
+func TestCallbackOnCancel(t *testing.T) {
+	srv := newCallbackServer(t)
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	r.Callbacks = &Callbacks{Secret: callbackSecret, AllowPrivateNetworks: true}
+	job := testJob(t)
+	job.Callback = srv.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exec, err := r.Start(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := exec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	r.Callbacks.Close()
+	if got := srv.received(); len(got) != 1 || got[0].payload.Status != CallbackCancelled || !got[0].payload.Incomplete {
+		t.Errorf("callbacks %+v", got)
+	}
+}
+
+func TestCallbackRetries(t *testing.T) {
+	deadFile := filepath.Join(t.TempDir(), "callbacks.dead.ndjson")
+	for _, tc := range []struct {
+		name     string
+		status   []int
+		requests int
+		// dead is the number of attempts of the dead letter, zero when
+		// none is expected.
+		dead int
+	}{
+		{"recovered", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, 0},
+		{"exhausted", []int{500, 502, 503, 504}, 3, 3},
+		{"refused", []int{http.StatusForbidden}, 1, 1},
+		{"redirected", []int{http.StatusFound}, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newCallbackServer(t, tc.status...)
+			c := &Callbacks{Secret: callbackSecret, AllowPrivateNetworks: true, Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}, DeadLetterFile: deadFile}
+			job := testJob(t)
+			job.Callback = srv.URL
+			c.send(job, &JobResult{JobID: job.ID, Success: true})
+			// Let the retries run, which Close would cut short.
+			c.wg.Wait()
+			c.Close()
+
+			got := srv.received()
+			if len(got) != tc.requests {
+				t.Fatalf("%d requests, want %d", len(got), tc.requests)
+			}
+			for _, req := range got {
+				if id := req.header.Get(CallbackDeliveryHeader); id != got[0].header.Get(CallbackDeliveryHeader) {
+					t.Errorf("delivery %q, then %q", got[0].header.Get(CallbackDeliveryHeader), id)
+				}
+			}
+			dead := c.DeadLetters()
+			if tc.dead == 0 {
+				if len(dead) != 0 {
+					t.Errorf("dead letters %+v", dead)
+				}
+				return
+			}
+			if len(dead) != 1 || dead[0].Attempts != tc.dead || dead[0].URL != srv.URL || dead[0].Payload.JobID != "job-1" || dead[0].Error == "" {
+				t.Errorf("dead letters %+v", dead)
+			}
+		})
+	}
+	f, err := os.Open(deadFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var d CallbackDeadLetter
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil || d.Payload.Status != CallbackSucceeded {
+			t.Errorf("dead letter line %q: %v", sc.Text(), err)
+		}
+	}
+	if lines != 3 {
+		t.Errorf("%d dead letter lines, want 3", lines)
+	}
+}
+
+func TestCallbackAfterClose(t *testing.T) {
+	srv := newCallbackServer(t)
+	c := &Callbacks{Secret: callbackSecret, AllowPrivateNetworks: true}
+	c.Close()
+	job := testJob(t)
+	job.Callback = srv.URL
+	c.send(job, &JobResult{JobID: job.ID})
+	c.Close()
+	if got, dead := srv.received(), c.DeadLetters(); len(got) != 0 || len(dead) != 1 || dead[0].Attempts != 0 {
+		t.Errorf("%d requests, dead letters %+v", len(got), dead)
+	}
+}
+
+func TestValidateCallback(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	job := testJob(t)
+	job.Callback = "https://hooks.example.org/sandbox"
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrInvalidCallback) {
+		t.Errorf("Run() without Runner.Callbacks = %v, want ErrInvalidCallback", err)
+	}
+
+	r.Callbacks = &Callbacks{Secret: callbackSecret, AllowedHosts: []string{"*.example.org"}}
+	for u, ok := range map[string]bool{
+		"":                                     true,
+		"https://hooks.example.org/sandbox":    true,
+		"http://hooks.example.org:8080/x?y=1":  true,
+		"ftp://hooks.example.org/sandbox":      false,
+		"/relative":                            false,
+		"https://user:pw@hooks.example.org/":   false,
+		"https://hooks.example.com/sandbox":    false,
+		"https://169.254.169.254/latest/meta/": false,
+	} {
+		if err := r.validateCallback(u); (err == nil) != ok || (err != nil && !errors.Is(err, ErrInvalidCallback)) {
+			t.Errorf("validateCallback(%q) = %v", u, err)
+		}
+	}
+
+	r.Callbacks = &Callbacks{Secret: callbackSecret}
+	for _, u := range []string{"http://127.0.0.1:8080/", "http://10.0.0.5/", "http://169.254.169.254/", "http://[::1]/", "http://[::ffff:192.168.1.1]/"} {
+		if err := r.validateCallback(u); !errors.Is(err, ErrInvalidCallback) {
+			t.Errorf("validateCallback(%q) = %v, want ErrInvalidCallback", u, err)
+		}
+	}
+	r.Callbacks.AllowPrivateNetworks = true
+	if err := r.validateCallback("http://127.0.0.1:8080/"); err != nil {
+		t.Errorf("validateCallback() with AllowPrivateNetworks = %v", err)
+	}
+}
+
+func TestCallbackRefusesPrivateAddress(t *testing.T) {
+	srv := newCallbackServer(t)
+	c := &Callbacks{Secret: callbackSecret, Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+	job := testJob(t)
+	// The name passes validateCallback, the address it resolves to does not.
+	job.Callback = strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	c.send(job, &JobResult{JobID: job.ID, Success: true})
+	c.wg.Wait()
+	c.Close()
+
+	dead := c.DeadLetters()
+	if got := srv.received(); len(got) != 0 || len(dead) != 1 || dead[0].Attempts != 1 || !strings.Contains(dead[0].Error, "is a private address") {
+		t.Errorf("%d requests, dead letters %+v", len(got), dead)
+	}
+}
+
+func TestCallbackDeadLettersBounded(t *testing.T) {
+	c := &Callbacks{Secret: callbackSecret, MaxDeadLetters: 2}
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		c.deadLetter(CallbackDeadLetter{Delivery: id})
+	}
+	if dead := c.DeadLetters(); len(dead) != 2 || dead[0].Delivery != "job-2" || dead[1].Delivery != "job-3" {
+		t.Errorf("dead letters %+v, want the last two", dead)
+	}
+}
+
+func TestVerifyCallback(t *testing.T) {
+	body := []byte(`{"job_id":"job-1"}`)
+	header := func(ts time.Time, secret []byte, body []byte) http.Header {
+		s := strconv.FormatInt(ts.Unix(), 10)
+		h := http.Header{}
+		h.Set(CallbackTimestampHeader, s)
+		h.Set(CallbackSignatureHeader, "sha256="+signCallback(secret, s, body))
+		return h
+	}
+	now := time.Now()
+	if err := VerifyCallback(callbackSecret, header(now, callbackSecret, body), body, time.Minute); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+	for name, h := range map[string]http.Header{
+		"other secret": header(now, []byte("other"), body),
+		"other body":   header(now, callbackSecret, []byte(`{"job_id":"job-2"}`)),
+		"too old":      header(now.Add(-time.Hour), callbackSecret, body),
+		"unsigned":     {},
+	} {
+		if err := VerifyCallback(callbackSecret, h, body, time.Minute); !errors.Is(err, ErrCallbackSignature) {
+			t.Errorf("%s: VerifyCallback() = %v, want ErrCallbackSignature", name, err)
+		}
+	}
+}
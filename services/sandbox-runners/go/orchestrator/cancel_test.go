@@ -0,0 +1,121 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writePartialOutput(spec ContainerSpec) {
+	for _, m := range spec.Mounts {
+		if m.Target == containerOutputDir {
+			os.WriteFile(filepath.Join(m.Source, "partial.csv"), []byte("a,b\n"), 0o644)
+		}
+	}
+}
+
+func checkCancelled(t *testing.T, res *JobResult, job Job) {
+	t.Helper()
+	if !res.Cancelled || !res.Incomplete || res.Success || res.TimedOut || res.FailureReason != FailureCancelled {
+		t.Errorf("result = %+v, want cancelled", res)
+	}
+	// Only the staged copy of the workspace goes with the job.
+	if _, err := os.Stat(filepath.Join(job.Workspace, "main.go")); err != nil {
+		t.Errorf("source workspace changed: %v", err)
+	}
+}
+
+func TestRunCancelledByContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &fakeRuntime{block: true}
+	rt.onStart = func(spec ContainerSpec) {
+		writePartialOutput(spec)
+		cancel()
+	}
+	r := NewRunner(rt)
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+
+	res, err := r.Run(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCancelled(t, res, job)
+	if res.ExitCode != 143 || !reflect.DeepEqual(rt.signals, []string{"SIGTERM"}) {
+		t.Errorf("exit = %d, signals = %v; want a graceful stop", res.ExitCode, rt.signals)
+	}
+	if !reflect.DeepEqual(res.Outputs, []string{"partial.csv"}) {
+		t.Errorf("outputs = %v", res.Outputs)
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("container not removed: %v", rt.removed)
+	}
+}
+
+func TestExecutionCancel(t *testing.T) {
+	r := NewRunner(&fakeRuntime{block: true})
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+	exec, err := r.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec.Cancel()
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCancelled(t, res, job)
+}
+
+func TestRunCancelledWhileCompiling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &fakeRuntime{block: true}
+	rt.onStart = func(ContainerSpec) { cancel() }
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+
+	res, err := r.Run(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCancelled(t, res, job)
+	if len(rt.specs) != 1 || rt.specs[0].Cmd[1] != "build" {
+		t.Errorf("specs = %+v, want only the build container", rt.specs)
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("build container not removed: %v", rt.removed)
+	}
+}
+
+func TestPoolRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := &fakeRuntime{execBlock: true}
+	rt.onExec = func(id string, spec ExecSpec) {
+		if jobExec(spec) {
+			os.WriteFile(filepath.Join(rt.hostPath(id, containerOutputDir), "partial.csv"), nil, 0o644)
+			cancel()
+		}
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	job := poolJob(t, "case-1")
+
+	res, err := p.Run(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCancelled(t, res, job)
+	if !reflect.DeepEqual(res.Outputs, []string{"partial.csv"}) {
+		t.Errorf("outputs = %v", res.Outputs)
+	}
+	if last := rt.execs[len(rt.execs)-1].Cmd; last[0] != "sh" {
+		t.Errorf("last exec = %v, want the SIGTERM script", last)
+	}
+	if m := p.Metrics(); m.Idle != 0 || len(rt.removed) == 0 {
+		t.Errorf("cancelled container reused: %+v, removed %v", m, rt.removed)
+	}
+}
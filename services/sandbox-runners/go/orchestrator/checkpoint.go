@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// checkpointDirPrefix names the staging directories of CheckpointStore.
+const checkpointDirPrefix = ".checkpoint-"
+
+// CheckpointStore keeps the sandbox.Checkpoint state of the jobs that did
+// not complete on a host volume, keyed by the fingerprint of the result
+// cache, so that a re-run of the same script on the same evidence with the
+// same parameters resumes where the previous run stopped.
+type CheckpointStore struct {
+	// Dir holds one directory per checkpoint.
+	Dir string
+}
+
+// checkpointKey is the fingerprint job's checkpoint is kept under, or ""
+// when an evidence item has no digest.
+func (r *Runner) checkpointKey(job Job) string {
+	p, err := profile(job.Language)
+	if err != nil {
+		return ""
+	}
+	key, _ := jobFingerprint(job, r.jobImage(job, p))
+	return key
+}
+
+// restoreCheckpoint places the checkpoint of job in the output directory
+// dst the script sees: the one left in job.OutputDir by an earlier attempt,
+// or else the one kept in r.Checkpoints.
+func (r *Runner) restoreCheckpoint(job Job, dst string) error {
+	dst = filepath.Join(dst, sandbox.CheckpointDir)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	src := filepath.Join(job.OutputDir, sandbox.CheckpointDir)
+	if _, err := os.Stat(src); err != nil {
+		if r.Checkpoints == nil {
+			return nil
+		}
+		key := r.checkpointKey(job)
+		if key == "" {
+			return nil
+		}
+		src = filepath.Join(r.Checkpoints.Dir, key)
+		if _, err := os.Stat(src); err != nil {
+			return nil
+		}
+	}
+	if err := copyTree(src, dst); err != nil {
+		return err
+	}
+	// The sandbox user replaces the state whatever uid the orchestrator
+	// runs as.
+	return filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			err = os.Chmod(p, 0o777)
+		}
+		return err
+	})
+}
+
+// keepCheckpoint saves the checkpoint job left in its OutputDir for the
+// next run when the job did not succeed, and discards it, with the one
+// kept for its fingerprint, when it did. The store is best effort: a
+// failure only costs the next run its progress.
+func (r *Runner) keepCheckpoint(job Job, res *JobResult) {
+	dir := filepath.Join(job.OutputDir, sandbox.CheckpointDir)
+	_, err := os.Stat(dir)
+	found := err == nil
+	if res.Success {
+		if found {
+			os.RemoveAll(dir)
+		}
+		if r.Checkpoints != nil {
+			if key := r.checkpointKey(job); key != "" {
+				os.RemoveAll(filepath.Join(r.Checkpoints.Dir, key))
+			}
+		}
+		return
+	}
+	if !found || r.Checkpoints == nil {
+		return
+	}
+	if key := r.checkpointKey(job); key != "" {
+		r.Checkpoints.store(key, dir)
+	}
+}
+
+// store saves the checkpoint directory dir under key, replacing the
+// earlier one.
+func (c *CheckpointStore) store(key, dir string) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(c.Dir, checkpointDirPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, tmp); err != nil {
+		return err
+	}
+	final := filepath.Join(c.Dir, key)
+	if err := os.RemoveAll(final); err != nil {
+		return err
+	}
+	return os.Rename(tmp, final)
+}
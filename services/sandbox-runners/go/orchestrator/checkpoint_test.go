@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// resumingScript stands for a script that loads its checkpoint, records
+// what it found in seen and checkpoints "next".
+func resumingScript(t *testing.T, output string, seen *[]string, next string) {
+	t.Helper()
+	dir := filepath.Join(output, sandbox.CheckpointDir)
+	state, _ := os.ReadFile(filepath.Join(dir, "state"))
+	*seen = append(*seen, string(state))
+	os.MkdirAll(dir, 0o755)
+	if err := os.WriteFile(filepath.Join(dir, "state"), []byte(next), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunResumesFromCheckpoint(t *testing.T) {
+	var seen []string
+	run := 0
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				run++
+				resumingScript(t, m.Source, &seen, "offset="+strconv.Itoa(run))
+			}
+		}
+	}
+	r := NewRunner(rt)
+	r.Checkpoints = &CheckpointStore{Dir: t.TempDir()}
+
+	newJob := func(id string) Job {
+		job := testJob(t)
+		job.ID = id
+		os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+		return job
+	}
+	// The first two runs time out; each re-run resumes from the last one.
+	rt.state = ContainerState{ExitCode: 137}
+	for _, id := range []string{"job-1", "job-2"} {
+		if _, err := r.Run(context.Background(), newJob(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt.state = ContainerState{}
+	last := newJob("job-3")
+	res, err := r.Run(context.Background(), last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "offset=1", "offset=2"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("checkpoints seen = %q, want %q", seen, want)
+	}
+	if len(res.Outputs) != 0 {
+		t.Errorf("outputs = %v, want the checkpoint left out", res.Outputs)
+	}
+	// Success discards the checkpoint.
+	if _, err := os.Stat(filepath.Join(last.OutputDir, sandbox.CheckpointDir)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left in the output directory: %v", err)
+	}
+	if entries, _ := os.ReadDir(r.Checkpoints.Dir); len(entries) != 0 {
+		t.Errorf("%d checkpoints kept after the job succeeded", len(entries))
+	}
+
+	// The checkpoint is only resumed by the same fingerprint.
+	rt.state = ContainerState{ExitCode: 1}
+	r.Run(context.Background(), newJob("job-4"))
+	other := newJob("job-5")
+	other.Params = map[string]string{"PARAM_YEAR": "2024"}
+	r.Run(context.Background(), other)
+	if seen[4] != "" {
+		t.Errorf("job with other parameters resumed from %q", seen[4])
+	}
+}
+
+func TestPoolResumesFromCheckpoint(t *testing.T) {
+	var seen []string
+	rt := &fakeRuntime{}
+	rt.onExec = func(id string, spec ExecSpec) {
+		// Jobs fail, resets succeed.
+		rt.execCode = 0
+		if spec.Env[sandbox.EnvCaseID] == "" {
+			return
+		}
+		rt.execCode = 1
+		resumingScript(t, rt.hostPath(id, containerOutputDir), &seen, "offset=1")
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	p.runner.Checkpoints = &CheckpointStore{Dir: t.TempDir()}
+
+	job := poolJob(t, "case-1")
+	if _, err := p.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	job.ID, job.OutputDir = "job-2", t.TempDir()
+	if _, err := p.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || seen[0] != "" || seen[1] != "offset=1" {
+		t.Errorf("checkpoints seen = %q", seen)
+	}
+}
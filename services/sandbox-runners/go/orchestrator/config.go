@@ -0,0 +1,283 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults applied by DefaultExecConfig.
+const (
+	DefaultRunTimeout        = 10 * time.Minute
+	DefaultBuildTimeout      = 5 * time.Minute
+	DefaultGracePeriod       = 10 * time.Second
+	DefaultMemoryLimitBytes  = 512 << 20
+	DefaultCPUQuota          = 1.0
+	DefaultMaxLogBytes       = 8 << 20
+	DefaultScratchQuotaBytes = 256 << 20
+)
+
+// DefaultTimeout is DefaultRunTimeout.
+//
+// Deprecated: use DefaultRunTimeout.
+const DefaultTimeout = DefaultRunTimeout
+
+// ExecConfig controls how a job's container is run. Start from
+// DefaultExecConfig and adjust the fields that differ.
+type ExecConfig struct {
+	// RunTimeout bounds the container's run time, from its start;
+	// DefaultRunTimeout when zero.
+	RunTimeout time.Duration
+	// BuildTimeout bounds the build phase before it: vendoring, the
+	// dependency scan and the compilation of BuildCache, e.g. to catch
+	// a module resolution that hangs early; DefaultBuildTimeout when
+	// zero. A `go run` without BuildCache compiles in the container, and
+	// its compilation is bounded by BuildTimeout as well as RunTimeout.
+	BuildTimeout time.Duration
+	// Timeout is the run timeout of configurations written before the
+	// build and run timeouts were told apart: when set, it overrides
+	// RunTimeout.
+	//
+	// Deprecated: use RunTimeout.
+	Timeout time.Duration
+	// GracePeriod is how long a timed-out container has to exit after
+	// SIGTERM before it is sent SIGKILL; DefaultGracePeriod when zero.
+	GracePeriod time.Duration
+	// EvidenceWritable mounts the evidence read-write instead of
+	// read-only. It should only be set deliberately: like the other
+	// security settings it is off in the zero value, so that a partial
+	// configuration keeps the evidence read-only.
+	EvidenceWritable bool
+	// MemoryLimitBytes caps the container's memory, swap included;
+	// DefaultMemoryLimitBytes when zero.
+	MemoryLimitBytes int64
+	// CPUQuota is the number of CPUs the container may use, e.g. 1.5;
+	// DefaultCPUQuota when zero.
+	CPUQuota float64
+	// NetworkMode is NetworkNone unless set to NetworkAllowlist.
+	NetworkMode NetworkMode
+	// AllowedHosts lists the egress hosts reachable in NetworkAllowlist
+	// mode, e.g. "api.threatintel.example" or "*.example.org".
+	AllowedHosts []string
+	// RatePerHost caps the requests per second the egress proxy forwards
+	// to each allowlisted host in NetworkAllowlist mode, e.g. 0.5 for one
+	// request every two seconds. Requests over the cap wait their turn
+	// rather than fail. HTTPS requests are counted per CONNECT tunnel, as
+	// sandbox.HTTPClient opens one per request. Zero means no cap.
+	RatePerHost float64
+	// Offline builds Go scripts from a vendor tree with GOFLAGS=-mod=vendor
+	// and GOPROXY=off, vendoring the dependencies first if the workspace
+	// has none.
+	Offline bool
+	// SeccompProfile is the path of a Docker seccomp profile on the
+	// orchestrator host. When empty a built-in profile allows file I/O,
+	// memory mapping and process management but not ptrace, mount or
+	// namespaces, nor sockets for jobs without network. SeccompUnconfined
+	// disables filtering.
+	SeccompProfile string
+	// KeepCapabilities keeps the default Linux capabilities of the
+	// container engine and allows new privileges; every capability is
+	// dropped and no-new-privileges set otherwise.
+	KeepCapabilities bool
+	// OutputQuotaBytes caps what the job may write to OUTPUT_DIR; writes
+	// beyond it fail with ENOSPC. Zero means no quota.
+	OutputQuotaBytes int64
+	// ScratchQuotaBytes sizes the tmpfs of SANDBOX_SCRATCH_DIR, where
+	// sandbox.TempDir and sandbox.TempFile create their files;
+	// DefaultScratchQuotaBytes when zero. Its pages count towards the
+	// container's memory limit.
+	ScratchQuotaBytes int64
+	// DecompressEvidence decompresses compressed evidence under
+	// Runner.WorkDir before the job runs, for scripts that read
+	// EVIDENCE_PATH without sandbox.OpenEvidence. The stored digest is
+	// checked on the way and the script gets the SHA256 of the raw file.
+	// It needs scratch space for the whole decompressed evidence; without
+	// it, OpenEvidence decompresses on the fly.
+	DecompressEvidence bool
+	// AllowEvidenceFetch lets the script fetch further evidence of its
+	// case with sandbox.FetchEvidence, as resolved by
+	// Runner.EvidenceCatalog, which must be set.
+	AllowEvidenceFetch bool
+	// AllowFindingQuery lets the script query the findings earlier jobs
+	// of its case reported with sandbox.QueryFindings, as held by
+	// Runner.FindingSource, which must be set.
+	AllowFindingQuery bool
+	// StreamEvidence serves the job's evidence, when at Evidence.URL, to
+	// sandbox.OpenEvidence by ranges as the script reads it, rather than
+	// downloading all of it first, so that a parser reading headers only
+	// starts at once. The blocks read are cached in the scratch area. The
+	// script gets no EVIDENCE_PATH, and the evidence is not hashed:
+	// the runner pins the version of the object it checked instead.
+	// Evidence the store cannot serve by ranges, encrypted evidence and
+	// that which DecompressEvidence, EvidenceBlockDevice or
+	// EvidenceOverlay need as a file is downloaded anyway.
+	StreamEvidence bool
+	// ImageDigest pins the runner image: the job fails with
+	// ErrImageDigestMismatch unless the image's ID or one of its registry
+	// digests is this "sha256:..." digest. Whether set or not, the job's
+	// containers are created from the image ID and JobResult.ImageDigest
+	// records it.
+	ImageDigest string
+	// EvidenceBlockDevice also exposes raw and EWF disk images as
+	// read-only block devices, named by EVIDENCE_BLOCK_DEV, for tools such
+	// as sleuthkit that prefer one. Images that cannot be attached, e.g.
+	// without losetup rights, stay files only: JobResult.EvidenceModes
+	// records the mode of each evidence item.
+	EvidenceBlockDevice bool
+	// EvidenceOverlay mounts each evidence file writable through a
+	// copy-on-write overlay of Runner.Overlays: every job sees the
+	// evidence as ingested, and what the script writes to it is
+	// discarded with the job, whatever EvidenceWritable says.
+	EvidenceOverlay bool
+	// PreflightEvidence checks, before any container is created, that
+	// every evidence item is a non-empty file the runner can read and
+	// matches its recorded digest. A job that fails the check is not run:
+	// its result has FailureEvidenceMissing or FailureEvidenceCorrupt.
+	PreflightEvidence bool
+	// ResourceMetrics samples the container's cgroup before and after the
+	// job to report its CPU time, peak memory and evidence reads in
+	// JobResult.Metrics. It needs cgroup v2 on the host.
+	ResourceMetrics bool
+	// Entrypoint runs the script through the entrypoint of the runner
+	// image, cmd/sandbox-entrypoint, which checks the environment contract
+	// before running it, passes SIGTERM on to all its processes and
+	// records how it ended in JobResult.JobStatus. The image must have
+	// been built with it.
+	Entrypoint bool
+	// MaxLogBytes caps the stdout and the stderr kept in JobResult and the
+	// job's log files, each; DefaultMaxLogBytes when zero. A longer stream
+	// keeps its first and last MaxLogBytes/2 bytes around a note of how
+	// much was dropped.
+	MaxLogBytes int64
+	// IdleTimeout flags a job as possibly hung, see
+	// JobResult.PossiblyHung, once it has gone that long without writing
+	// to stdout or stderr nor reporting progress. Unlike RunTimeout it does
+	// not bound the run time of a job that shows activity. Zero disables
+	// the watchdog.
+	IdleTimeout time.Duration
+	// KillIdle stops a job flagged by IdleTimeout, with its grace period,
+	// instead of only flagging it: its result has FailureHung.
+	KillIdle bool
+	// HeartbeatTimeout fails a job with FailureWorkerLost once the
+	// entrypoint has gone that long without a heartbeat, e.g. because
+	// the worker host died or froze: the container is given up and its
+	// slot freed, with the outputs collected so far, rather than the job
+	// hanging until its RunTimeout. The entrypoint beats HeartbeatTimeout/4
+	// apart. It requires Entrypoint; zero disables it.
+	HeartbeatTimeout time.Duration
+	// MaxFindings, MaxArtifacts and MaxTimelineEvents cap the findings,
+	// artifacts and timeline events ingested from the job, so that a
+	// runaway script cannot flood the case store; zero means no cap. The
+	// records past a cap are dropped and reported in
+	// JobResult.RecordsTruncated. The caps are passed to the script, whose
+	// SDK refuses to emit past them.
+	MaxFindings       int
+	MaxArtifacts      int
+	MaxTimelineEvents int
+	// PreserveFindingOrder keeps JobResult.Findings in the order the
+	// script emitted them instead of sorting them by location, finding
+	// key and title, which makes the findings of two runs comparable.
+	PreserveFindingOrder bool
+	// KillOverRecordLimit stops a job, with its grace period, as soon as
+	// it has written more records than a cap allows: its result has
+	// FailureRecordLimit.
+	KillOverRecordLimit bool
+	// Runtime is the OCI runtime of the job's containers, e.g. RuntimeGVisor
+	// for untrusted scripts; the container engine's default when empty.
+	// Jobs fail with ErrRuntimeUnavailable if the engine does not have it.
+	Runtime string
+	// SharedDir mounts the shared directory of the job's case from
+	// Runner.Shared at SHARED_DIR, read-write, for the data that the jobs
+	// of the case reuse, e.g. an index an early pipeline stage builds.
+	// Jobs fail with ErrNoSharedStore without Runner.Shared.
+	SharedDir bool
+	// OutputMode is the octal mode, e.g. "0640" for group-readable
+	// artifacts, of the files the SDK creates in OUTPUT_DIR, passed as
+	// SANDBOX_OUTPUT_MODE; sandbox.DefaultOutputMode when empty. Jobs
+	// fail with sandbox.ErrInvalidOutputMode for a world-writable mode.
+	OutputMode string
+	// Explain records the configuration the job actually ran with, and
+	// where each of its values came from, in JobResult.EffectiveConfig and
+	// its JobRecord, e.g. to tell whether its memory limit came from the
+	// case, the resource profile of its evidence or its script.
+	Explain bool
+}
+
+// DefaultExecConfig returns the configuration used when neither the job nor
+// its case specify one.
+func DefaultExecConfig() ExecConfig {
+	return ExecConfig{
+		RunTimeout:       DefaultRunTimeout,
+		BuildTimeout:     DefaultBuildTimeout,
+		GracePeriod:      DefaultGracePeriod,
+		MemoryLimitBytes: DefaultMemoryLimitBytes,
+		CPUQuota:         DefaultCPUQuota,
+		NetworkMode:      NetworkNone,
+	}
+}
+
+func (c ExecConfig) runTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	if c.RunTimeout <= 0 {
+		return DefaultRunTimeout
+	}
+	return c.RunTimeout
+}
+
+// migrate moves the deprecated Timeout of c to RunTimeout, so that the
+// limits raised from RunTimeout apply.
+func (c ExecConfig) migrate() ExecConfig {
+	if c.Timeout > 0 {
+		c.RunTimeout, c.Timeout = c.Timeout, 0
+	}
+	return c
+}
+
+func (c ExecConfig) buildTimeout() time.Duration {
+	if c.BuildTimeout <= 0 {
+		return DefaultBuildTimeout
+	}
+	return c.BuildTimeout
+}
+
+func (c ExecConfig) gracePeriod() time.Duration {
+	if c.GracePeriod <= 0 {
+		return DefaultGracePeriod
+	}
+	return c.GracePeriod
+}
+
+func (c ExecConfig) memoryLimit() int64 {
+	if c.MemoryLimitBytes <= 0 {
+		return DefaultMemoryLimitBytes
+	}
+	return c.MemoryLimitBytes
+}
+
+func (c ExecConfig) scratchQuota() int64 {
+	if c.ScratchQuotaBytes <= 0 {
+		return DefaultScratchQuotaBytes
+	}
+	return c.ScratchQuotaBytes
+}
+
+// scratchTmpfs is the tmpfs of SANDBOX_SCRATCH_DIR, writable by the
+// sandbox user.
+func (c ExecConfig) scratchTmpfs() string {
+	return fmt.Sprintf("%s:rw,size=%d,mode=1777", containerScratch, c.scratchQuota())
+}
+
+func (c ExecConfig) maxLogBytes() int64 {
+	if c.MaxLogBytes <= 0 {
+		return DefaultMaxLogBytes
+	}
+	return c.MaxLogBytes
+}
+
+func (c ExecConfig) cpuQuota() float64 {
+	if c.CPUQuota <= 0 {
+		return DefaultCPUQuota
+	}
+	return c.CPUQuota
+}
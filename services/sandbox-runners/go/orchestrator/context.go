@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// contextDirPrefix names the directories holding a job's case context
+// under Runner.WorkDir.
+const contextDirPrefix = "datamortem-context-"
+
+// contextPath is where the case context file appears inside the container.
+var contextPath = path.Join(containerContextDir, sandbox.ContextFile)
+
+// caseContext is the case context of job, as read by sandbox.Context. The
+// evidence is described as the script sees it, decompressed if the
+// runner decompressed it.
+func caseContext(job Job) sandbox.CaseContext {
+	timezone, locale, _ := caseLocale(job)
+	c := sandbox.CaseContext{
+		Version:    sandbox.ContextVersion,
+		CaseID:     job.CaseID,
+		CaseName:   job.CaseName,
+		CaseNumber: job.CaseNumber,
+		Timezone:   timezone,
+		Locale:     locale,
+		Examiner:   job.Analyst,
+		JobID:      job.ID,
+		Evidence:   []sandbox.ContextEvidence{},
+		Params:     job.Params,
+	}
+	for i, ev := range job.allEvidence() {
+		item := sandbox.ContextEvidence{
+			UID:      ev.UID,
+			Type:     ev.Type,
+			SHA256:   ev.SHA256,
+			HashAlgo: ev.HashAlgo,
+			Offset:   ev.Offset,
+			Length:   ev.Length,
+		}
+		if ev.Path != "" {
+			item.Path = evidenceTarget(i, ev)
+		}
+		if ev.compressed() {
+			item.Compression = strings.ToLower(ev.Compression)
+		}
+		c.Evidence = append(c.Evidence, item)
+	}
+	return c
+}
+
+// writeContext writes the case context of job to dir as sandbox.ContextFile,
+// with the metadata sidecars of its evidence.
+func writeContext(dir string, job Job) error {
+	data, err := json.MarshalIndent(caseContext(job), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, sandbox.ContextFile), append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	for i, ev := range job.allEvidence() {
+		if ev.MetaPath == "" {
+			continue
+		}
+		if err := writeEvidenceMeta(filepath.Join(dir, evidenceMetaFile(i)), ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEvidenceMeta validates the metadata sidecar of ev and copies it to
+// path, as the script reads it.
+func writeEvidenceMeta(path string, ev Evidence) error {
+	data, err := os.ReadFile(ev.MetaPath)
+	if err != nil {
+		return fmt.Errorf("evidence %s: %w", ev.UID, err)
+	}
+	if _, err := sandbox.ParseEvidenceMeta(data); err != nil {
+		return fmt.Errorf("evidence %s: %w", ev.UID, err)
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// evidenceMetaFile names the metadata sidecar of the i-th evidence item in
+// the case context directory.
+func evidenceMetaFile(i int) string {
+	if i == 0 {
+		return sandbox.EvidenceMetaFile
+	}
+	return strings.TrimSuffix(sandbox.EvidenceMetaFile, ".json") + "-" + strconv.Itoa(i) + ".json"
+}
+
+// evidenceMetaTarget is where the metadata sidecar of the i-th evidence
+// item appears inside the container.
+func evidenceMetaTarget(i int) string {
+	return path.Join(containerContextDir, evidenceMetaFile(i))
+}
+
+// stageContext writes the case context of job to a fresh directory under
+// Runner.WorkDir, mounted read-only by applyContext. The caller removes
+// the directory.
+func (r *Runner) stageContext(job Job) (string, error) {
+	dir, err := r.scratchDir(contextDirPrefix)
+	if err != nil {
+		return "", err
+	}
+	err = os.Chmod(dir, 0o755)
+	if err == nil {
+		err = writeContext(dir, job)
+	}
+	if err != nil {
+		r.removeDir(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// applyContext mounts the case context directory dir in spec.
+func applyContext(spec *ContainerSpec, dir string) {
+	spec.Mounts = append(spec.Mounts, Mount{Source: dir, Target: containerContextDir, ReadOnly: true})
+	spec.Env[sandbox.EnvContextPath] = contextPath
+}
@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func readContext(t *testing.T, dir string) sandbox.CaseContext {
+	t.Helper()
+	var c sandbox.CaseContext
+	data, err := os.ReadFile(filepath.Join(dir, sandbox.ContextFile))
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		t.Errorf("case context: %v", err)
+	}
+	return c
+}
+
+func TestRunMountsCaseContext(t *testing.T) {
+	var got sandbox.CaseContext
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerContextDir && m.ReadOnly {
+				got = readContext(t, m.Source)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	job := testJob(t)
+	job.CaseName = "Exfiltration ACME"
+	job.CaseNumber = "2026-0142"
+	job.Analyst = "alice"
+	job.Evidence.Type = "disk_image"
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/case-1/ev-2/mem.lime", Type: "memory_dump", Offset: 512}}
+	job.Params = map[string]string{"PARAM_DEPTH": "2"}
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if env := rt.lastSpec().Env; env[sandbox.EnvContextPath] != "/run/datamortem-context/context.json" {
+		t.Errorf("%s = %q", sandbox.EnvContextPath, env[sandbox.EnvContextPath])
+	}
+	if got.Version != sandbox.ContextVersion || got.CaseID != "case-1" || got.CaseName != "Exfiltration ACME" ||
+		got.CaseNumber != "2026-0142" || got.Examiner != "alice" || got.JobID != "job-1" || got.Params["PARAM_DEPTH"] != "2" {
+		t.Errorf("context = %+v", got)
+	}
+	want := []sandbox.ContextEvidence{
+		{UID: "ev-1", Path: "/evidence/disk.raw", Type: "disk_image", SHA256: "abc123"},
+		{UID: "ev-2", Path: "/evidence/1/mem.lime", Type: "memory_dump", Offset: 512},
+	}
+	if len(got.Evidence) != 2 || got.Evidence[0] != want[0] || got.Evidence[1] != want[1] {
+		t.Errorf("evidence = %+v, want %+v", got.Evidence, want)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(r.WorkDir, contextDirPrefix+"*")); len(entries) != 0 {
+		t.Errorf("context directories left: %v", entries)
+	}
+}
+
+func TestPoolWritesCaseContext(t *testing.T) {
+	rt := &fakeRuntime{}
+	var got []sandbox.CaseContext
+	rt.onExec = func(id string, spec ExecSpec) {
+		if spec.Env[sandbox.EnvCaseID] == "" {
+			return // reset
+		}
+		if spec.Env[sandbox.EnvContextPath] != contextPath {
+			t.Errorf("%s = %q", sandbox.EnvContextPath, spec.Env[sandbox.EnvContextPath])
+		}
+		got = append(got, readContext(t, rt.hostPath(id, containerContextDir)))
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+
+	for _, caseID := range []string{"case-1", "case-2"} {
+		if _, err := p.Run(context.Background(), poolJob(t, caseID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(got) != 2 || got[0].CaseID != "case-1" || got[1].CaseID != "case-2" {
+		t.Errorf("contexts = %+v", got)
+	}
+}
+
+func TestRunPassesCaseLocale(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.CaseTimezone, job.CaseLocale = "Europe/Paris", "fr-FR"
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := rt.lastSpec().Env
+	if env[sandbox.EnvCaseTimezone] != "Europe/Paris" || env[sandbox.EnvCaseLocale] != "fr-FR" || len(res.Warnings) != 0 {
+		t.Errorf("env = %v, warnings %+v", env, res.Warnings)
+	}
+
+	job.CaseTimezone, job.CaseLocale = "Paris", "fr_FR.UTF-8"
+	if res, err = r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	env = rt.lastSpec().Env
+	if env[sandbox.EnvCaseTimezone] != "UTC" || env[sandbox.EnvCaseLocale] != "" {
+		t.Errorf("env with an invalid timezone and locale = %v", env)
+	}
+	if len(res.Warnings) != 2 || res.Warnings[0].Code != WarningInvalidTimezone || res.Warnings[1].Code != WarningInvalidLocale {
+		t.Errorf("warnings = %+v", res.Warnings)
+	}
+	if c := caseContext(job); c.Timezone != "UTC" || c.Locale != "" {
+		t.Errorf("context timezone %q, locale %q", c.Timezone, c.Locale)
+	}
+}
+
+func TestRunPassesEvidenceMeta(t *testing.T) {
+	var meta *sandbox.EvidenceMetadata
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerContextDir && m.ReadOnly {
+				var err error
+				if meta, err = sandbox.ReadEvidenceMeta(filepath.Join(m.Source, evidenceMetaFile(0))); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	job := testJob(t)
+	job.Evidence.MetaPath = writeFixture(t, "disk.raw.json", `{"acquired_at": "2024-03-01T12:00:00Z", "tool": "FTK Imager"}`)
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/case-1/ev-2/mem.lime", MetaPath: job.Evidence.MetaPath}}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Tool != "FTK Imager" {
+		t.Errorf("sidecar %+v", meta)
+	}
+	env := rt.lastSpec().Env
+	if env[sandbox.EnvEvidenceMetaPath] != "/run/datamortem-context/evidence-meta.json" ||
+		env[sandbox.IndexedEnv(sandbox.EnvEvidenceMetaPath, 1)] != "/run/datamortem-context/evidence-meta-1.json" {
+		t.Errorf("env %v", env)
+	}
+
+	// An invalid sidecar fails the job before it runs.
+	job.Evidence.MetaPath = writeFixture(t, "bad.json", `{"tool": "FTK Imager"}`)
+	job.ExtraEvidence = nil
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, sandbox.ErrInvalidEvidenceMeta) {
+		t.Errorf("Run() = %v, want ErrInvalidEvidenceMeta", err)
+	}
+}
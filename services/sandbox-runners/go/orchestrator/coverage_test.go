@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsCoverage(t *testing.T) {
+	report := `{"evidence_uid":"ev-1","examined":950,"total":1000,"unit":"records","skipped":[{"offset":10,"length":50,"reason":"corrupt chunk"}],"skipped_count":1}`
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.CoverageFile), []byte(report), 0o644)
+			}
+		}
+	}
+	r := NewRunner(rt)
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Coverage == nil || res.CoverageError != "" {
+		t.Fatalf("coverage = %+v, error %q", res.Coverage, res.CoverageError)
+	}
+	if got, want := res.Coverage.String(), "examined 950 records of 1000 records (95%), 1 region skipped"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("artifacts = %+v, want coverage.json left out", res.Artifacts)
+	}
+
+	report = `{"examined":1001,"total":1000,"unit":"records"}`
+	res, err = r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Coverage != nil || res.CoverageError == "" {
+		t.Errorf("coverage = %+v, error %q; want examining more than the total rejected", res.Coverage, res.CoverageError)
+	}
+}
@@ -0,0 +1,165 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// evidenceDirPrefix names the directories holding decompressed evidence
+// under Runner.WorkDir.
+const evidenceDirPrefix = "datamortem-evidence-"
+
+// compressedExts are stripped from the name of decompressed evidence.
+var compressedExts = []string{".gz", ".gzip", ".zst", ".zstd"}
+
+// trimCompressedExt returns name without the first of compressedExts it
+// ends with, if that leaves a name.
+func trimCompressedExt(name string) string {
+	for _, ext := range compressedExts {
+		if trimmed := strings.TrimSuffix(name, ext); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// decompressEvidence writes the raw content of job's compressed evidence
+// to a fresh directory under Runner.WorkDir and returns job reading those
+// files instead, with their SHA256. The stored files are checked against
+// their recorded digest as they are read. The caller removes the
+// directory, which is "" when no evidence was compressed.
+//
+// With cache, evidence it can share is decompressed to a directory of its
+// own, or taken from an earlier job, and held in the returned entries,
+// which the caller releases instead.
+func (r *Runner) decompressEvidence(job Job, cache *EvidenceCache) (Job, string, []*evidenceEntry, error) {
+	all := job.allEvidence()
+	dir := ""
+	var held []*evidenceEntry
+	fail := func(err error) (Job, string, []*evidenceEntry, error) {
+		if dir != "" {
+			r.removeDir(dir)
+		}
+		for _, e := range held {
+			cache.release(e)
+		}
+		return Job{}, "", nil, err
+	}
+	for i, ev := range all {
+		if !ev.compressed() || ev.Path == "" {
+			continue
+		}
+		if cache.cacheable(ev) {
+			e, err := cache.acquire(evidenceKey(cacheDecompressed, ev), func(e *evidenceEntry) error {
+				own, err := r.scratchDir(evidenceDirPrefix)
+				if err != nil {
+					return err
+				}
+				if err := os.Chmod(own, 0o755); err != nil {
+					r.removeDir(own)
+					return err
+				}
+				if e.raw, err = decompressFile(ev, own); err != nil {
+					r.removeDir(own)
+					return err
+				}
+				e.teardown = func() { r.removeDir(own) }
+				return nil
+			})
+			if err != nil {
+				return fail(decompressError(ev, err))
+			}
+			held = append(held, e)
+			all[i] = e.raw
+			continue
+		}
+		if dir == "" {
+			var err error
+			if dir, err = r.scratchDir(evidenceDirPrefix); err != nil {
+				return fail(err)
+			}
+			if err := os.Chmod(dir, 0o755); err != nil {
+				return fail(err)
+			}
+		}
+		raw, err := decompressFile(ev, filepath.Join(dir, strconv.Itoa(i)))
+		if err != nil {
+			return fail(decompressError(ev, err))
+		}
+		all[i] = raw
+	}
+	job.Evidence = all[0]
+	if len(job.ExtraEvidence) > 0 {
+		job.ExtraEvidence = all[1:]
+	}
+	return job, dir, held, nil
+}
+
+// decompressError reports the failure to decompress ev, a corrupt file as
+// an EvidenceError.
+func decompressError(ev Evidence, err error) error {
+	if errors.Is(err, sandbox.ErrEvidenceHashMismatch) {
+		return &EvidenceError{UID: ev.UID, Path: ev.Path, Reason: FailureEvidenceCorrupt, Err: err}
+	}
+	return fmt.Errorf("decompress evidence %s: %w", ev.UID, err)
+}
+
+// decompressFile decompresses ev into dir and returns the raw evidence.
+func decompressFile(ev Evidence, dir string) (Evidence, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Evidence{}, err
+	}
+	src, err := os.Open(ev.Path)
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer src.Close()
+	stored, err := sandbox.NewHash(ev.HashAlgo)
+	if err != nil {
+		return Evidence{}, err
+	}
+	stream, err := sandbox.Decompress(io.TeeReader(src, stored), ev.Compression)
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer stream.Close()
+
+	path := filepath.Join(dir, trimCompressedExt(filepath.Base(ev.Path)))
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Evidence{}, err
+	}
+	raw := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, raw), stream)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Evidence{}, err
+	}
+	// Trailing bytes after the compressed stream are part of the stored
+	// file's digest.
+	if _, err := io.Copy(stored, src); err != nil {
+		return Evidence{}, err
+	}
+	if ev.SHA256 != "" {
+		if sum := hex.EncodeToString(stored.Sum(nil)); !strings.EqualFold(sum, ev.SHA256) {
+			return Evidence{}, fmt.Errorf("%w: %s is %s, want %s", sandbox.ErrEvidenceHashMismatch, ev.Path, sum, ev.SHA256)
+		}
+	}
+	return Evidence{
+		UID:      ev.UID,
+		Path:     path,
+		SHA256:   hex.EncodeToString(raw.Sum(nil)),
+		HashAlgo: sandbox.HashSHA256,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// gzipEvidence writes data gzip-compressed and returns it as evidence.
+func gzipEvidence(t *testing.T, data string) Evidence {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte(data))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "disk.raw.gz")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Evidence{UID: "ev-1", Path: path, SHA256: sum, Compression: sandbox.CompressionGzip}
+}
+
+func TestCompressedEvidenceEnv(t *testing.T) {
+	job := testJob(t)
+	job.Evidence.Compression = "GZIP"
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/ev-2.zst", Compression: sandbox.CompressionZstd}}
+
+	env := containerEnv(job)
+	want := map[string]string{
+		sandbox.EnvEvidenceCompression:                        "gzip",
+		sandbox.IndexedEnv(sandbox.EnvEvidenceCompression, 0): "gzip",
+		sandbox.IndexedEnv(sandbox.EnvEvidenceCompression, 1): "zstd",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("%s = %q, want %q", k, env[k], v)
+		}
+	}
+
+	job.Evidence.Compression = "lz4"
+	if _, err := NewRunner(&fakeRuntime{}).Run(context.Background(), job); err == nil {
+		t.Error("job with an unsupported compression was accepted")
+	}
+}
+
+func TestRunDecompressesEvidence(t *testing.T) {
+	var raw string
+	var env map[string]string
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		env = spec.Env
+		for _, m := range spec.Mounts {
+			if m.Target == "/evidence/disk.raw" {
+				data, _ := os.ReadFile(m.Source)
+				raw = string(data)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	cfg := DefaultExecConfig()
+	cfg.DecompressEvidence = true
+	job := testJob(t)
+	job.Config = &cfg
+	job.Evidence = gzipEvidence(t, "MBR")
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if raw != "MBR" {
+		t.Errorf("mounted evidence = %q, want the decompressed content", raw)
+	}
+	if _, ok := env[sandbox.EnvEvidenceCompression]; ok {
+		t.Error("EVIDENCE_COMPRESSION set for decompressed evidence")
+	}
+	rawSum := "746eeb5497be32c0bf589ae19c0428f4988b2fe7598d7838d5a5f9718d0b0d9a"
+	if env[sandbox.EnvEvidenceSHA256] != rawSum || env[sandbox.EnvEvidenceHashAlgo] != sandbox.HashSHA256 {
+		t.Errorf("digest = %s %s, want the raw file's", env[sandbox.EnvEvidenceHashAlgo], env[sandbox.EnvEvidenceSHA256])
+	}
+	if entries, _ := os.ReadDir(r.WorkDir); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir", len(entries))
+	}
+
+	job.Evidence.SHA256 = "abc123"
+	if _, err := r.Start(context.Background(), job); !errors.Is(err, sandbox.ErrEvidenceHashMismatch) {
+		t.Errorf("Start() = %v, want ErrEvidenceHashMismatch", err)
+	}
+	if res, err := r.Run(context.Background(), job); err != nil || res.FailureReason != FailureEvidenceCorrupt {
+		t.Errorf("Run() = %+v, %v, want an evidence_corrupt result", res, err)
+	}
+	if entries, _ := os.ReadDir(r.WorkDir); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir after a failed start", len(entries))
+	}
+}
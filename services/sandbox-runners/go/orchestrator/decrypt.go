@@ -0,0 +1,361 @@
+package orchestrator
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Schemes of Evidence.Encryption.
+const (
+	// EncryptionAES256GCM: the stored file is a 12-byte nonce followed by
+	// the AES-256-GCM ciphertext of the whole evidence and its tag. It is
+	// decrypted in memory, up to MaxSealedEvidenceBytes.
+	EncryptionAES256GCM = "aes-256-gcm"
+	// EncryptionAES256GCMStream: the stored file is a 7-byte nonce prefix
+	// followed by segments of StreamSegmentSize bytes of evidence, the
+	// last one shorter, each sealed with AES-256-GCM under the prefix, the
+	// segment number as a 4-byte big-endian integer and a byte set to 1
+	// for the last segment and 0 otherwise. Segments that are truncated,
+	// reordered or dropped fail to decrypt.
+	EncryptionAES256GCMStream = "aes-256-gcm-stream"
+)
+
+// StreamSegmentSize is the number of evidence bytes in each segment of
+// EncryptionAES256GCMStream.
+const StreamSegmentSize = 64 << 10
+
+// MaxSealedEvidenceBytes is the size of the largest EncryptionAES256GCM
+// file the runner decrypts; larger evidence is encrypted with
+// EncryptionAES256GCMStream.
+const MaxSealedEvidenceBytes = 1 << 30
+
+// evidenceKeySize is the size of the AES-256 key of encrypted evidence.
+const evidenceKeySize = 32
+
+// streamNoncePrefixSize is the size of the random nonce prefix heading an
+// EncryptionAES256GCMStream file.
+const streamNoncePrefixSize = 7
+
+// ErrEvidenceDecryption is wrapped in the EvidenceError of evidence that
+// does not decrypt: its key is not the one it was encrypted with, or the
+// file was altered.
+var ErrEvidenceDecryption = errors.New("orchestrator: evidence decryption failed")
+
+// decryptedExts are stripped from the name of decrypted evidence.
+var decryptedExts = []string{".enc", ".aes"}
+
+// encrypted reports whether the evidence must be decrypted to be read.
+func (ev Evidence) encrypted() bool {
+	return ev.Encryption != ""
+}
+
+// validEncryption reports whether scheme is a scheme of Evidence.Encryption.
+func validEncryption(scheme string) bool {
+	return scheme == EncryptionAES256GCM || scheme == EncryptionAES256GCMStream
+}
+
+// validateEncryption checks the scheme and key of the encrypted evidence
+// of job, without ever quoting a key.
+func validateEncryption(job Job) error {
+	for _, ev := range job.allEvidence() {
+		switch {
+		case !ev.encrypted() && ev.KeySecret == "":
+			continue
+		case !validEncryption(ev.Encryption):
+			return fmt.Errorf("orchestrator: evidence %s: unsupported encryption %q", ev.UID, ev.Encryption)
+		case ev.KeySecret == "":
+			return fmt.Errorf("orchestrator: evidence %s: encrypted evidence needs a key secret", ev.UID)
+		}
+		value, ok := job.Secrets[ev.KeySecret]
+		if !ok {
+			return fmt.Errorf("orchestrator: evidence %s: no secret %s holds its key", ev.UID, ev.KeySecret)
+		}
+		if _, err := decodeEvidenceKey(value); err != nil {
+			return fmt.Errorf("orchestrator: evidence %s: secret %s: %w", ev.UID, ev.KeySecret, err)
+		}
+	}
+	return nil
+}
+
+// decodeEvidenceKey decodes a 32-byte key from hex or standard base64. Its
+// errors do not quote the value.
+func decodeEvidenceKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	key, err := hex.DecodeString(value)
+	if err != nil || len(key) != evidenceKeySize {
+		key, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil || len(key) != evidenceKeySize {
+		return nil, errors.New("not a 32-byte key in hex or base64")
+	}
+	return key, nil
+}
+
+// withoutKeySecrets returns job without the secrets holding evidence keys,
+// which are never passed to the script.
+func withoutKeySecrets(job Job) Job {
+	keys := map[string]bool{}
+	for _, ev := range job.allEvidence() {
+		if ev.KeySecret != "" {
+			keys[ev.KeySecret] = true
+		}
+	}
+	if len(keys) == 0 {
+		return job
+	}
+	secrets := map[string]string{}
+	for name, value := range job.Secrets {
+		if !keys[name] {
+			secrets[name] = value
+		}
+	}
+	job.Secrets = secrets
+	return job
+}
+
+// decryptedEvidence returns the UIDs of the encrypted evidence of job.
+func decryptedEvidence(job Job) []string {
+	var uids []string
+	for _, ev := range job.allEvidence() {
+		if ev.encrypted() {
+			uids = append(uids, ev.UID)
+		}
+	}
+	return uids
+}
+
+// decryptEvidence writes the plaintext of job's encrypted evidence to a
+// fresh directory under Runner.WorkDir and returns job reading those files
+// instead, with their SHA256 and without the secrets holding the keys. The
+// stored files are checked against their recorded digest as they are
+// read. The caller removes the directory, which is "" when no evidence was
+// encrypted. Decrypted evidence is never shared through the EvidenceCache.
+func (r *Runner) decryptEvidence(job Job) (Job, string, error) {
+	secrets := job.Secrets
+	job = withoutKeySecrets(job)
+	all := job.allEvidence()
+	dir := ""
+	for i, ev := range all {
+		if !ev.encrypted() || ev.Path == "" {
+			continue
+		}
+		if dir == "" {
+			var err error
+			if dir, err = r.scratchDir(evidenceDirPrefix); err != nil {
+				return Job{}, "", err
+			}
+			if err := os.Chmod(dir, 0o755); err != nil {
+				r.removeDir(dir)
+				return Job{}, "", err
+			}
+		}
+		key, err := decodeEvidenceKey(secrets[ev.KeySecret])
+		if err == nil {
+			all[i], err = decryptFile(ev, key, filepath.Join(dir, strconv.Itoa(i)))
+		}
+		if err != nil {
+			r.removeDir(dir)
+			return Job{}, "", decryptError(ev, err)
+		}
+	}
+	job.Evidence = all[0]
+	if len(job.ExtraEvidence) > 0 {
+		job.ExtraEvidence = all[1:]
+	}
+	return job, dir, nil
+}
+
+// decryptError reports the failure to decrypt ev, a corrupt file as an
+// EvidenceError.
+func decryptError(ev Evidence, err error) error {
+	if errors.Is(err, sandbox.ErrEvidenceHashMismatch) || errors.Is(err, ErrEvidenceDecryption) {
+		return &EvidenceError{UID: ev.UID, Path: ev.Path, Reason: FailureEvidenceCorrupt, Err: err}
+	}
+	return fmt.Errorf("decrypt evidence %s: %w", ev.UID, err)
+}
+
+// decryptFile decrypts ev with key into dir and returns the plaintext
+// evidence, still compressed if ev is.
+func decryptFile(ev Evidence, key []byte, dir string) (Evidence, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Evidence{}, err
+	}
+	src, err := os.Open(ev.Path)
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer src.Close()
+	stored, err := sandbox.NewHash(ev.HashAlgo)
+	if err != nil {
+		return Evidence{}, err
+	}
+	name := filepath.Base(ev.Path)
+	for _, ext := range decryptedExts {
+		if trimmed := strings.TrimSuffix(name, ext); trimmed != name && trimmed != "" {
+			name = trimmed
+			break
+		}
+	}
+	path := filepath.Join(dir, name)
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Evidence{}, err
+	}
+	plain := sha256.New()
+	err = decrypt(io.MultiWriter(dst, plain), io.TeeReader(src, stored), ev.Encryption, key)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Evidence{}, err
+	}
+	if ev.SHA256 != "" {
+		if sum := hex.EncodeToString(stored.Sum(nil)); !strings.EqualFold(sum, ev.SHA256) {
+			return Evidence{}, fmt.Errorf("%w: %s is %s, want %s", sandbox.ErrEvidenceHashMismatch, ev.Path, sum, ev.SHA256)
+		}
+	}
+	ev.Path, ev.SHA256, ev.HashAlgo = path, hex.EncodeToString(plain.Sum(nil)), sandbox.HashSHA256
+	ev.Encryption, ev.KeySecret, ev.decrypted = "", "", true
+	return ev, nil
+}
+
+// decrypt writes to dst the plaintext of src, encrypted with scheme.
+func decrypt(dst io.Writer, src io.Reader, scheme string, key []byte) error {
+	aead, err := newEvidenceAEAD(key)
+	if err != nil {
+		return err
+	}
+	if scheme == EncryptionAES256GCM {
+		sealed, err := io.ReadAll(io.LimitReader(src, MaxSealedEvidenceBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(sealed) > MaxSealedEvidenceBytes {
+			return fmt.Errorf("%s evidence is larger than %d bytes, use %s", scheme, MaxSealedEvidenceBytes, EncryptionAES256GCMStream)
+		}
+		if len(sealed) < aead.NonceSize()+aead.Overhead() {
+			return fmt.Errorf("%w: the file is truncated", ErrEvidenceDecryption)
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return fmt.Errorf("%w: wrong key or altered file", ErrEvidenceDecryption)
+		}
+		_, err = dst.Write(plain)
+		return err
+	}
+
+	in := bufio.NewReaderSize(src, StreamSegmentSize+aead.Overhead())
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(in, nonce[:streamNoncePrefixSize]); err != nil {
+		return fmt.Errorf("%w: the file is truncated", ErrEvidenceDecryption)
+	}
+	segment := make([]byte, StreamSegmentSize+aead.Overhead())
+	for n := uint32(0); ; n++ {
+		size, err := io.ReadFull(in, segment)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		_, peekErr := in.Peek(1)
+		last := peekErr != nil
+		binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], n)
+		nonce[len(nonce)-1] = 0
+		if last {
+			nonce[len(nonce)-1] = 1
+		}
+		plain, err := aead.Open(segment[:0], nonce, segment[:size], nil)
+		if err != nil {
+			return fmt.Errorf("%w: segment %d: wrong key or altered file", ErrEvidenceDecryption, n)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if n == ^uint32(0) {
+			return fmt.Errorf("%w: too many segments", ErrEvidenceDecryption)
+		}
+	}
+}
+
+func newEvidenceAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptEvidence writes to dst the content of src encrypted with key, a
+// 32-byte AES-256 key, in scheme, for the platform to store evidence
+// encrypted at rest. EncryptionAES256GCM reads the whole of src in memory.
+func EncryptEvidence(dst io.Writer, src io.Reader, scheme string, key []byte) error {
+	if !validEncryption(scheme) {
+		return fmt.Errorf("orchestrator: unsupported encryption %q", scheme)
+	}
+	aead, err := newEvidenceAEAD(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if scheme == EncryptionAES256GCM {
+		plain, err := io.ReadAll(io.LimitReader(src, MaxSealedEvidenceBytes-int64(aead.NonceSize()+aead.Overhead())+1))
+		if err != nil {
+			return err
+		}
+		if len(plain)+aead.NonceSize()+aead.Overhead() > MaxSealedEvidenceBytes {
+			return fmt.Errorf("orchestrator: %s evidence is limited to %d bytes, use %s", scheme, MaxSealedEvidenceBytes, EncryptionAES256GCMStream)
+		}
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		_, err = dst.Write(aead.Seal(nonce, nonce, plain, nil))
+		return err
+	}
+
+	if _, err := rand.Read(nonce[:streamNoncePrefixSize]); err != nil {
+		return err
+	}
+	if _, err := dst.Write(nonce[:streamNoncePrefixSize]); err != nil {
+		return err
+	}
+	in := bufio.NewReaderSize(src, StreamSegmentSize)
+	segment := make([]byte, StreamSegmentSize, StreamSegmentSize+aead.Overhead())
+	for n := uint32(0); ; n++ {
+		size, err := io.ReadFull(in, segment)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+		_, peekErr := in.Peek(1)
+		last := peekErr != nil
+		binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], n)
+		nonce[len(nonce)-1] = 0
+		if last {
+			nonce[len(nonce)-1] = 1
+		}
+		if _, err := dst.Write(aead.Seal(segment[:0], nonce, segment[:size], nil)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if n == ^uint32(0) {
+			return errors.New("orchestrator: evidence too large to encrypt")
+		}
+	}
+}
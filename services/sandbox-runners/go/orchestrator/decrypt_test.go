@@ -0,0 +1,144 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+var testEvidenceKey = bytes.Repeat([]byte{0x42}, evidenceKeySize)
+
+// encryptedEvidence writes data encrypted with testEvidenceKey in scheme
+// and returns it as evidence.
+func encryptedEvidence(t *testing.T, data []byte, scheme string) Evidence {
+	t.Helper()
+	var b bytes.Buffer
+	if err := EncryptEvidence(&b, bytes.NewReader(data), scheme, testEvidenceKey); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "disk.raw.enc")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Evidence{UID: "ev-1", Path: path, SHA256: sum, Encryption: scheme, KeySecret: "ev_key"}
+}
+
+func TestEvidenceEncryptionRoundTrip(t *testing.T) {
+	for _, scheme := range []string{EncryptionAES256GCM, EncryptionAES256GCMStream} {
+		for _, size := range []int{0, 1, StreamSegmentSize, 3*StreamSegmentSize + 7} {
+			data := bytes.Repeat([]byte("evidence"), size/8+1)[:size]
+			var sealed, plain bytes.Buffer
+			if err := EncryptEvidence(&sealed, bytes.NewReader(data), scheme, testEvidenceKey); err != nil {
+				t.Fatal(err)
+			}
+			if err := decrypt(&plain, bytes.NewReader(sealed.Bytes()), scheme, testEvidenceKey); err != nil {
+				t.Fatalf("%s of %d bytes: %v", scheme, size, err)
+			}
+			if !bytes.Equal(plain.Bytes(), data) {
+				t.Errorf("%s of %d bytes: decrypted %d bytes", scheme, size, plain.Len())
+			}
+		}
+	}
+
+	var sealed bytes.Buffer
+	EncryptEvidence(&sealed, bytes.NewReader(make([]byte, 2*StreamSegmentSize+1)), EncryptionAES256GCMStream, testEvidenceKey)
+	segment := StreamSegmentSize + 16
+	for name, tampered := range map[string][]byte{
+		"last segment dropped": sealed.Bytes()[:streamNoncePrefixSize+2*segment],
+		"segments reordered": append(append(append([]byte{}, sealed.Bytes()[:streamNoncePrefixSize]...),
+			sealed.Bytes()[streamNoncePrefixSize+segment:streamNoncePrefixSize+2*segment]...),
+			sealed.Bytes()[streamNoncePrefixSize:streamNoncePrefixSize+segment]...),
+		"truncated": sealed.Bytes()[:4],
+	} {
+		if err := decrypt(&bytes.Buffer{}, bytes.NewReader(tampered), EncryptionAES256GCMStream, testEvidenceKey); !errors.Is(err, ErrEvidenceDecryption) {
+			t.Errorf("%s: decrypt() = %v, want ErrEvidenceDecryption", name, err)
+		}
+	}
+	wrong := bytes.Repeat([]byte{0x24}, evidenceKeySize)
+	if err := decrypt(&bytes.Buffer{}, bytes.NewReader(sealed.Bytes()), EncryptionAES256GCMStream, wrong); !errors.Is(err, ErrEvidenceDecryption) {
+		t.Errorf("decrypt() with the wrong key = %v", err)
+	}
+}
+
+func TestRunDecryptsEvidence(t *testing.T) {
+	var plain, keyFile string
+	var env map[string]string
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		env = spec.Env
+		for _, m := range spec.Mounts {
+			switch m.Target {
+			case "/evidence/disk.raw":
+				data, _ := os.ReadFile(m.Source)
+				plain = string(data)
+			case containerSecretsDir:
+				if _, err := os.Stat(filepath.Join(m.Source, "ev_key")); err == nil {
+					keyFile = "ev_key"
+				}
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	key := hex.EncodeToString(testEvidenceKey)
+	job := testJob(t)
+	job.Evidence = encryptedEvidence(t, []byte("MBR"), EncryptionAES256GCMStream)
+	job.Secrets = map[string]string{"ev_key": key, "vt_api_key": testSecret}
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain != "MBR" {
+		t.Errorf("mounted evidence = %q, want the plaintext", plain)
+	}
+	if keyFile != "" || env[sandbox.EnvSecretsDir] == "" {
+		t.Errorf("secrets staged for the script: key %q, dir %q; want the other secret only", keyFile, env[sandbox.EnvSecretsDir])
+	}
+	for name, value := range env {
+		if strings.Contains(value, key) {
+			t.Errorf("%s holds the key", name)
+		}
+	}
+	if len(res.DecryptedEvidence) != 1 || res.DecryptedEvidence[0] != "ev-1" {
+		t.Errorf("decrypted evidence = %q", res.DecryptedEvidence)
+	}
+	data, err := os.ReadFile(filepath.Join(r.Audit.Dir, "case-1"+auditFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"decrypted_evidence":["ev-1"]`) || strings.Contains(string(data), key) {
+		t.Errorf("audit entry = %s", data)
+	}
+	if entries, _ := os.ReadDir(r.WorkDir); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir", len(entries))
+	}
+
+	job.Secrets["ev_key"] = hex.EncodeToString(bytes.Repeat([]byte{0x24}, evidenceKeySize))
+	if res, err := r.Run(context.Background(), job); err != nil || res.FailureReason != FailureEvidenceCorrupt {
+		t.Errorf("Run() with the wrong key = %+v, %v, want an evidence_corrupt result", res, err)
+	}
+	if entries, _ := os.ReadDir(r.WorkDir); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir after a failed decryption", len(entries))
+	}
+
+	job.Secrets["ev_key"] = "not-a-key"
+	if _, err := r.Run(context.Background(), job); err == nil || strings.Contains(err.Error(), "not-a-key") {
+		t.Errorf("Run() with an invalid key = %v", err)
+	}
+	delete(job.Secrets, "ev_key")
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("Run() without the key secret succeeded")
+	}
+}
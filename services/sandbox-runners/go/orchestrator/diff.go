@@ -0,0 +1,240 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// JobDiff compares the records of two jobs, e.g. two versions of a parser
+// run against the same evidence, by Runner.DiffJobs. Its JSON is the
+// structured diff, for display.
+type JobDiff struct {
+	JobA string `json:"job_a"`
+	JobB string `json:"job_b"`
+	// SameEvidence reports that both jobs ran against the same evidence
+	// items, with the same digests; a diff over other evidence also shows
+	// what differs between the evidence items.
+	SameEvidence bool       `json:"same_evidence"`
+	Findings     RecordDiff `json:"findings"`
+	IOCs         RecordDiff `json:"iocs"`
+	Timeline     RecordDiff `json:"timeline"`
+}
+
+// Empty reports whether both jobs produced the same records.
+func (d JobDiff) Empty() bool {
+	return d.Findings.Empty() && d.IOCs.Empty() && d.Timeline.Empty()
+}
+
+// RecordDiff compares the records of one kind of two jobs by their keys:
+// Added are only in the second job, Removed only in the first, in the
+// order of the job that has them.
+type RecordDiff struct {
+	Added     []DiffRecord   `json:"added,omitempty"`
+	Removed   []DiffRecord   `json:"removed,omitempty"`
+	Changed   []RecordChange `json:"changed,omitempty"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// Empty reports whether the records of both jobs are the same.
+func (d RecordDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffRecord is a record found in one job only. Key is its stable key:
+// the FindingKey of a finding, or "finding/<evidence UID>/<title>"
+// without one; IOC.Key for an indicator, and
+// "timeline/<time>/<source>/<evidence UID>" for a timeline event. The
+// second record of a job with a key gets "#2" appended, and so on.
+type DiffRecord struct {
+	Key string `json:"key"`
+	// Record is the record as the job collected it.
+	Record json.RawMessage `json:"record"`
+}
+
+// RecordChange is a record whose key both jobs have, but not the same
+// values.
+type RecordChange struct {
+	Key    string          `json:"key"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+	// Fields names the fields that differ, as in the JSON of the record,
+	// those of data and fields by their own name, e.g. "severity" or
+	// "data.pid".
+	Fields []string `json:"fields"`
+}
+
+// DiffJobs compares the findings, IOCs and timeline events of the jobs
+// jobA and jobB, as Runner.Replays recorded them: the jobs must have run
+// with Replays set, and not been cancelled. Records are matched by their
+// stable keys, see DiffRecord, so that the diff holds as long as the jobs
+// produce their records in a deterministic order.
+func (r *Runner) DiffJobs(jobA, jobB string) (JobDiff, error) {
+	if r.Replays == nil {
+		return JobDiff{}, errors.New("orchestrator: diffing jobs requires Runner.Replays")
+	}
+	a, err := r.Replays.Load(jobA)
+	if err != nil {
+		return JobDiff{}, err
+	}
+	b, err := r.Replays.Load(jobB)
+	if err != nil {
+		return JobDiff{}, err
+	}
+	d := JobDiff{JobA: jobA, JobB: jobB, SameEvidence: sameEvidence(a.Job, b.Job)}
+	if d.Findings, err = diffRecords(a.Findings, b.Findings, findingDiffKey); err != nil {
+		return JobDiff{}, fmt.Errorf("orchestrator: diff findings: %w", err)
+	}
+	if d.IOCs, err = diffRecords(a.IOCs, b.IOCs, sandbox.IOC.Key); err != nil {
+		return JobDiff{}, fmt.Errorf("orchestrator: diff IOCs: %w", err)
+	}
+	if d.Timeline, err = diffRecords(a.Timeline, b.Timeline, timelineDiffKey); err != nil {
+		return JobDiff{}, fmt.Errorf("orchestrator: diff timeline: %w", err)
+	}
+	return d, nil
+}
+
+func findingDiffKey(f sandbox.Result) string {
+	if f.FindingKey != "" {
+		return f.FindingKey
+	}
+	return "finding/" + f.EvidenceUID + "/" + f.Title
+}
+
+func timelineDiffKey(e sandbox.TimelineEvent) string {
+	return "timeline/" + e.Time.UTC().Format(time.RFC3339Nano) + "/" + e.Source + "/" + e.EvidenceUID
+}
+
+// sameEvidence reports whether a and b ran against the same evidence.
+func sameEvidence(a, b Job) bool {
+	ids := func(job Job) []string {
+		var ids []string
+		for _, ev := range append([]Evidence{job.Evidence}, job.ExtraEvidence...) {
+			ids = append(ids, ev.UID+"@"+ev.SHA256)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	return reflect.DeepEqual(ids(a), ids(b))
+}
+
+// keyedRecord is a record of a job with its key and normalized JSON.
+type keyedRecord struct {
+	key    string
+	json   json.RawMessage
+	fields map[string]any
+}
+
+// keyRecords keys and normalizes records, numbering the repeated keys.
+func keyRecords[T any](records []T, key func(T) string) ([]keyedRecord, error) {
+	seen := map[string]int{}
+	keyed := make([]keyedRecord, 0, len(records))
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+		// Marshalling the map sorts its keys, and decoding it gives the
+		// numbers of both jobs the same type.
+		if data, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+		k := key(rec)
+		if seen[k]++; seen[k] > 1 {
+			k = fmt.Sprintf("%s#%d", k, seen[k])
+		}
+		keyed = append(keyed, keyedRecord{key: k, json: data, fields: fields})
+	}
+	return keyed, nil
+}
+
+// diffRecords compares the records a and b of two jobs by key.
+func diffRecords[T any](a, b []T, key func(T) string) (RecordDiff, error) {
+	ka, err := keyRecords(a, key)
+	if err != nil {
+		return RecordDiff{}, err
+	}
+	kb, err := keyRecords(b, key)
+	if err != nil {
+		return RecordDiff{}, err
+	}
+	inB := make(map[string]keyedRecord, len(kb))
+	for _, rec := range kb {
+		inB[rec.key] = rec
+	}
+	var d RecordDiff
+	inA := make(map[string]bool, len(ka))
+	for _, before := range ka {
+		inA[before.key] = true
+		after, ok := inB[before.key]
+		switch {
+		case !ok:
+			d.Removed = append(d.Removed, DiffRecord{Key: before.key, Record: before.json})
+		case string(before.json) == string(after.json):
+			d.Unchanged++
+		default:
+			d.Changed = append(d.Changed, RecordChange{
+				Key:    before.key,
+				Before: before.json,
+				After:  after.json,
+				Fields: changedFields(before.fields, after.fields),
+			})
+		}
+	}
+	for _, rec := range kb {
+		if !inA[rec.key] {
+			d.Added = append(d.Added, DiffRecord{Key: rec.key, Record: rec.json})
+		}
+	}
+	return d, nil
+}
+
+// nestedFields are the fields of records whose own fields changedFields
+// names, the free-form values of findings and timeline events.
+var nestedFields = map[string]bool{"data": true, "fields": true}
+
+// changedFields returns the sorted names of the fields that differ
+// between a and b.
+func changedFields(a, b map[string]any) []string {
+	var names []string
+	for name := range recordFields(a, b) {
+		va, vb := a[name], b[name]
+		if reflect.DeepEqual(va, vb) {
+			continue
+		}
+		ma, okA := va.(map[string]any)
+		mb, okB := vb.(map[string]any)
+		if !nestedFields[name] || (va != nil && !okA) || (vb != nil && !okB) {
+			names = append(names, name)
+			continue
+		}
+		for field := range recordFields(ma, mb) {
+			if !reflect.DeepEqual(ma[field], mb[field]) {
+				names = append(names, name+"."+field)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordFields returns the names of the fields of a and b.
+func recordFields(a, b map[string]any) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
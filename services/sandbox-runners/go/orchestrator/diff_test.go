@@ -0,0 +1,94 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffJobs(t *testing.T) {
+	// Each version of the parser writes its own records.
+	outputs := map[string]map[string]string{
+		"v1": {
+			"results.ndjson": `{"evidence_uid":"ev-1","severity":"high","title":"Injected thread","finding_key":"inject/1234","data":{"pid":1234,"target":"lsass.exe"}}
+{"evidence_uid":"ev-1","severity":"low","title":"Unsigned driver"}
+{"evidence_uid":"ev-1","severity":"low","title":"Unsigned driver"}
+`,
+			"iocs.ndjson":     `{"evidence_uid":"ev-1","kind":"domain","value":"Evil.Example"}` + "\n",
+			"timeline.ndjson": `{"timestamp":"2024-03-01T12:00:00Z","evidence_uid":"ev-1","source":"prefetch","message":"RUN.EXE executed"}` + "\n",
+		},
+		"v2": {
+			"results.ndjson": `{"evidence_uid":"ev-1","severity":"critical","title":"Injected thread","finding_key":"inject/1234","data":{"pid":1234,"target":"lsass.exe","tid":8}}
+{"evidence_uid":"ev-1","severity":"low","title":"Unsigned driver"}
+`,
+			"iocs.ndjson": `{"evidence_uid":"ev-1","kind":"domain","value":"evil.example"}
+{"evidence_uid":"ev-1","kind":"ipv4","value":"203.0.113.7"}
+`,
+			"timeline.ndjson": `{"timestamp":"2024-03-01T12:00:00Z","evidence_uid":"ev-1","source":"prefetch","message":"RUN.EXE executed"}` + "\n",
+		},
+	}
+	version := "v1"
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				for name, data := range outputs[version] {
+					os.WriteFile(filepath.Join(m.Source, name), []byte(data), 0o644)
+				}
+			}
+		}
+	}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.Replays = &ReplayStore{Dir: t.TempDir()}
+	for _, id := range []string{"job-1", "job-2"} {
+		job := testJob(t)
+		job.ID = id
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		version = "v2"
+	}
+
+	d, err := r.DiffJobs("job-1", "job-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.SameEvidence || d.Empty() {
+		t.Errorf("diff = %+v", d)
+	}
+	f := d.Findings
+	if len(f.Changed) != 1 || f.Changed[0].Key != "inject/1234" || f.Unchanged != 1 || len(f.Added) != 0 {
+		t.Fatalf("findings diff = %+v", f)
+	}
+	if want := []string{"data.tid", "severity"}; !reflect.DeepEqual(f.Changed[0].Fields, want) {
+		t.Errorf("changed fields %q, want %q", f.Changed[0].Fields, want)
+	}
+	// The repeated finding without a key is numbered.
+	if len(f.Removed) != 1 || f.Removed[0].Key != "finding/ev-1/Unsigned driver#2" {
+		t.Errorf("removed findings %+v", f.Removed)
+	}
+	// Indicators compare by IOC.Key, the case of domains aside.
+	if i := d.IOCs; len(i.Added) != 1 || i.Added[0].Key != "ioc/ipv4/203.0.113.7" || len(i.Changed) != 1 || i.Changed[0].Fields[0] != "value" {
+		t.Errorf("IOCs diff = %+v", i)
+	}
+	if !d.Timeline.Empty() || d.Timeline.Unchanged != 1 {
+		t.Errorf("timeline diff = %+v", d.Timeline)
+	}
+	var rendered map[string]any
+	data, err := json.Marshal(d)
+	if err != nil || json.Unmarshal(data, &rendered) != nil || rendered["job_b"] != "job-2" {
+		t.Errorf("rendered diff %s, %v", data, err)
+	}
+
+	if d, err := r.DiffJobs("job-2", "job-2"); err != nil || !d.Empty() {
+		t.Errorf("DiffJobs() of a job with itself = %+v, %v", d, err)
+	}
+	if _, err := r.DiffJobs("job-1", "job-3"); !errors.Is(err, ErrNotReplayable) {
+		t.Errorf("DiffJobs() of an unknown job: %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DockerRuntime drives containers through the docker CLI, honouring
+// DOCKER_HOST like the rest of the stack.
+type DockerRuntime struct {
+	// Binary is the docker executable; "docker" when empty.
+	Binary string
+}
+
+func (d *DockerRuntime) binary() string {
+	if d.Binary == "" {
+		return "docker"
+	}
+	return d.Binary
+}
+
+func (d *DockerRuntime) run(ctx context.Context, stdout io.Writer, args ...string) error {
+	return d.runInput(ctx, nil, stdout, args...)
+}
+
+func (d *DockerRuntime) runInput(ctx context.Context, stdin io.Reader, stdout io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, d.binary(), args...)
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (d *DockerRuntime) output(ctx context.Context, args ...string) (string, error) {
+	var out bytes.Buffer
+	if err := d.run(ctx, &out, args...); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// createArgs renders spec as `docker create` arguments. seccompFile holds
+// spec.SeccompProfile when it is a JSON profile.
+func createArgs(spec ContainerSpec, seccompFile string) []string {
+	network := spec.Network
+	if network == "" {
+		network = "none"
+	}
+	args := []string{"create", "--network", network}
+	if spec.Runtime != "" {
+		args = append(args, "--runtime", spec.Runtime)
+	}
+	if spec.User != "" {
+		args = append(args, "--user", spec.User)
+	}
+	for _, g := range spec.GroupAdd {
+		args = append(args, "--group-add", g)
+	}
+	if spec.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+	if spec.Interactive {
+		args = append(args, "--interactive")
+	}
+	for _, c := range spec.CapDrop {
+		args = append(args, "--cap-drop", c)
+	}
+	if spec.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	switch {
+	case spec.SeccompProfile == SeccompUnconfined:
+		args = append(args, "--security-opt", "seccomp=unconfined")
+	case seccompFile != "":
+		args = append(args, "--security-opt", "seccomp="+seccompFile)
+	}
+	for _, t := range spec.Tmpfs {
+		args = append(args, "--tmpfs", t)
+	}
+	if spec.MemoryBytes > 0 {
+		mem := strconv.FormatInt(spec.MemoryBytes, 10)
+		args = append(args, "--memory", mem, "--memory-swap", mem)
+	}
+	if spec.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(spec.CPUs, 'f', -1, 64))
+	}
+	if spec.WorkDir != "" {
+		args = append(args, "--workdir", spec.WorkDir)
+	}
+	for _, m := range spec.Mounts {
+		mount := "type=bind,source=" + m.Source + ",target=" + m.Target
+		if m.ReadOnly {
+			mount += ",readonly"
+		}
+		args = append(args, "--mount", mount)
+	}
+	keys := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+spec.Labels[k])
+	}
+	for _, d := range spec.Devices {
+		perms := "rwm"
+		if d.ReadOnly {
+			perms = "r"
+		}
+		args = append(args, "--device", d.Source+":"+d.Target+":"+perms)
+	}
+	args = appendEnv(args, spec.Env)
+	args = append(args, spec.Image)
+	return append(args, spec.Cmd...)
+}
+
+// execArgs renders spec as `docker exec` arguments for container id.
+func execArgs(id string, spec ExecSpec) []string {
+	args := []string{"exec"}
+	if spec.User != "" {
+		args = append(args, "--user", spec.User)
+	}
+	if spec.WorkDir != "" {
+		args = append(args, "--workdir", spec.WorkDir)
+	}
+	args = appendEnv(args, spec.Env)
+	args = append(args, id)
+	return append(args, spec.Cmd...)
+}
+
+// appendEnv adds env as --env flags in key order.
+func appendEnv(args []string, env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--env", k+"="+env[k])
+	}
+	return args
+}
+
+// Create passes a JSON seccomp profile through a temporary file, which the
+// docker CLI reads before creating the container.
+func (d *DockerRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	var seccompFile string
+	if spec.SeccompProfile != "" && spec.SeccompProfile != SeccompUnconfined {
+		f, err := os.CreateTemp("", "datamortem-seccomp-*.json")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(spec.SeccompProfile)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		seccompFile = f.Name()
+	}
+	return d.output(ctx, createArgs(spec, seccompFile)...)
+}
+
+func (d *DockerRuntime) Start(ctx context.Context, id string) error {
+	return d.run(ctx, io.Discard, "start", id)
+}
+
+func (d *DockerRuntime) Wait(ctx context.Context, id string) (ContainerState, error) {
+	out, err := d.output(ctx, "wait", id)
+	if err != nil {
+		return ContainerState{}, err
+	}
+	code, err := strconv.Atoi(out)
+	if err != nil {
+		return ContainerState{}, fmt.Errorf("docker wait: unexpected output %q", out)
+	}
+	oom, err := d.output(ctx, "inspect", "--format", "{{.State.OOMKilled}}", id)
+	if err != nil {
+		return ContainerState{}, err
+	}
+	return ContainerState{ExitCode: code, OOMKilled: oom == "true"}, nil
+}
+
+// Exec returns the exit code of the command; the error only reports
+// failures to run docker itself.
+func (d *DockerRuntime) Exec(ctx context.Context, id string, spec ExecSpec, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, d.binary(), execArgs(id, spec)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("docker exec: %w", err)
+	}
+	return 0, nil
+}
+
+// Attach discards the output `docker attach` relays, which Follow and Logs
+// collect, and does not proxy signals: stopping the session is Kill's.
+func (d *DockerRuntime) Attach(ctx context.Context, id string, stdin io.Reader) error {
+	cmd := exec.CommandContext(ctx, d.binary(), "attach", "--sig-proxy=false", id)
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	// The copy of stdin blocks on a read once the container has exited.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) || ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("docker attach: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (d *DockerRuntime) Kill(ctx context.Context, id, signal string) error {
+	return d.run(ctx, io.Discard, "kill", "--signal", signal, id)
+}
+
+func (d *DockerRuntime) Logs(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	return d.logs(ctx, stdout, stderr, "logs", id)
+}
+
+func (d *DockerRuntime) Follow(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	return d.logs(ctx, stdout, stderr, "logs", "--follow", id)
+}
+
+// logs runs `docker logs`, which replays the container's stdout and stderr
+// on its own stdout and stderr.
+func (d *DockerRuntime) logs(ctx context.Context, stdout, stderr io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, d.binary(), args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker logs: %w", err)
+	}
+	return nil
+}
+
+// imageInspectFormat prints an image's ID and platform followed by its
+// registry digests, then its labels as JSON on a line of their own.
+const imageInspectFormat = "{{.Id}} {{.Os}}/{{.Architecture}}{{range .RepoDigests}} {{.}}{{end}}\n{{json .Config.Labels}}"
+
+func (d *DockerRuntime) InspectImage(ctx context.Context, image, platform string) (ImageInfo, error) {
+	out, err := d.output(ctx, "image", "inspect", "--format", imageInspectFormat, image)
+	if err != nil {
+		// Pull the image as docker create would have.
+		args := []string{"pull", "--quiet"}
+		if platform != "" {
+			args = append(args, "--platform", platform)
+		}
+		if err := d.run(ctx, io.Discard, append(args, image)...); err != nil {
+			return ImageInfo{}, err
+		}
+		if out, err = d.output(ctx, "image", "inspect", "--format", imageInspectFormat, image); err != nil {
+			return ImageInfo{}, err
+		}
+	}
+	return parseImageInspect(out)
+}
+
+func parseImageInspect(out string) (ImageInfo, error) {
+	out, labels, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return ImageInfo{}, fmt.Errorf("docker image inspect: unexpected output %q", out)
+	}
+	info := ImageInfo{ID: fields[0], RepoDigests: fields[1:]}
+	// Registry digests name a repository; the platform does not.
+	if len(fields) > 1 && !strings.Contains(fields[1], "@") {
+		info.OS, info.Architecture, _ = strings.Cut(fields[1], "/")
+		info.RepoDigests = fields[2:]
+	}
+	if labels = strings.TrimSpace(labels); labels != "" {
+		if err := json.Unmarshal([]byte(labels), &info.Labels); err != nil {
+			return ImageInfo{}, fmt.Errorf("docker image inspect: labels: %w", err)
+		}
+	}
+	return info, nil
+}
+
+// BuildImage passes the Dockerfile of spec on the standard input of
+// `docker build`, which then has no build context.
+func (d *DockerRuntime) BuildImage(ctx context.Context, spec ImageBuildSpec) error {
+	return d.runInput(ctx, strings.NewReader(spec.Dockerfile), io.Discard, buildArgs(spec)...)
+}
+
+// buildArgs renders spec as `docker build` arguments.
+func buildArgs(spec ImageBuildSpec) []string {
+	args := []string{"build", "--quiet", "--tag", spec.Tag}
+	if spec.Network != "" {
+		args = append(args, "--network", spec.Network)
+	}
+	labels := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	for _, k := range labels {
+		args = append(args, "--label", k+"="+spec.Labels[k])
+	}
+	return append(args, "-")
+}
+
+// Runtimes lists the runtimes configured in the docker daemon.
+func (d *DockerRuntime) Runtimes(ctx context.Context) ([]string, error) {
+	out, err := d.output(ctx, "info", "--format", "{{json .Runtimes}}")
+	if err != nil {
+		return nil, err
+	}
+	return parseRuntimes(out)
+}
+
+func parseRuntimes(out string) ([]string, error) {
+	var runtimes map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out), &runtimes); err != nil {
+		return nil, fmt.Errorf("docker info: unexpected runtimes %q", out)
+	}
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (d *DockerRuntime) Remove(ctx context.Context, id string) error {
+	return d.run(ctx, io.Discard, "rm", "--force", "--volumes", id)
+}
+
+// Containers lists the containers with label through `docker ps`.
+func (d *DockerRuntime) Containers(ctx context.Context, label string) (map[string]string, error) {
+	out, err := d.output(ctx, "ps", "--all", "--no-trunc", "--filter", "label="+label, "--format", "{{.ID}}\t{{.Label \""+label+"\"}}")
+	if err != nil {
+		return nil, err
+	}
+	return parseContainers(out), nil
+}
+
+// parseContainers parses the `docker ps` lines of Containers.
+func parseContainers(out string) map[string]string {
+	containers := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		id, value, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		if id != "" {
+			containers[id] = value
+		}
+	}
+	return containers
+}
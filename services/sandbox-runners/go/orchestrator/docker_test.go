@@ -0,0 +1,104 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateArgs(t *testing.T) {
+	args := createArgs(ContainerSpec{
+		Image:          "img",
+		Cmd:            []string{"go", "run", "."},
+		Env:            map[string]string{"B": "2", "A": "1"},
+		Mounts:         []Mount{{Source: "/host/ev", Target: "/evidence/ev", ReadOnly: true}},
+		User:           "sandbox",
+		ReadOnlyRootfs: true,
+		Interactive:    true,
+		Tmpfs:          []string{"/tmp"},
+		MemoryBytes:    1024,
+		CPUs:           1.5,
+		Devices:        []Device{{Source: "/dev/loop3", Target: "/dev/evidence", ReadOnly: true}},
+		GroupAdd:       []string{"6"},
+		Runtime:        RuntimeGVisor,
+		Labels:         map[string]string{LabelJob: "job-1"},
+	}, "")
+	got := strings.Join(args, " ")
+	want := "create --network none --runtime runsc --user sandbox --group-add 6 --read-only --interactive --tmpfs /tmp " +
+		"--memory 1024 --memory-swap 1024 --cpus 1.5 " +
+		"--mount type=bind,source=/host/ev,target=/evidence/ev,readonly " +
+		"--label datamortem.job=job-1 --device /dev/loop3:/dev/evidence:r " +
+		"--env A=1 --env B=2 img go run ."
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseContainers(t *testing.T) {
+	got := parseContainers("aaa\tjob-1\nbbb\tprobe\n\nccc\t\n")
+	if len(got) != 3 || got["aaa"] != "job-1" || got["bbb"] != "probe" || got["ccc"] != "" {
+		t.Errorf("containers = %v", got)
+	}
+}
+
+func TestParseImageInspect(t *testing.T) {
+	info, err := parseImageInspect("sha256:aaa registry.example/go@sha256:bbb mirror/go@sha256:ccc\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "sha256:aaa" || len(info.RepoDigests) != 2 || !info.matches("sha256:ccc") || info.matches("sha256:ddd") {
+		t.Errorf("info = %+v", info)
+	}
+	info, err = parseImageInspect("sha256:aaa linux/arm64 registry.example/go@sha256:bbb\n")
+	if err != nil || info.OS != "linux" || info.Architecture != ArchARM64 || len(info.RepoDigests) != 1 || !info.matches("sha256:bbb") {
+		t.Errorf("info = %+v, %v", info, err)
+	}
+	info, err = parseImageInspect("sha256:aaa linux/amd64\n{\"datamortem.go.version\":\"1.22.5\"}\n")
+	if err != nil || info.Labels[LabelGoVersion] != "1.22.5" || len(info.RepoDigests) != 0 {
+		t.Errorf("info = %+v, %v", info, err)
+	}
+	if info, err = parseImageInspect("sha256:aaa linux/amd64\nnull\n"); err != nil || info.Labels != nil {
+		t.Errorf("info = %+v, %v", info, err)
+	}
+	if _, err := parseImageInspect(""); err == nil {
+		t.Error("empty output parsed")
+	}
+}
+
+func TestParseRuntimes(t *testing.T) {
+	got, err := parseRuntimes(`{"runc":{"path":"runc"},"io.containerd.runc.v2":{"path":"runc"},"runsc":{"path":"/usr/local/bin/runsc"}}` + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "io.containerd.runc.v2 runc runsc"; strings.Join(got, " ") != want {
+		t.Errorf("runtimes = %v, want %s", got, want)
+	}
+	if _, err := parseRuntimes("<no value>"); err == nil {
+		t.Error("parseRuntimes accepted a non-JSON output")
+	}
+}
+
+func TestExecArgs(t *testing.T) {
+	args := execArgs("c1", ExecSpec{
+		Cmd:     []string{"go", "run", "."},
+		Env:     map[string]string{"CASE_ID": "case-1"},
+		WorkDir: "/workspace",
+		User:    "sandbox",
+	})
+	got := strings.Join(args, " ")
+	want := "exec --user sandbox --workdir /workspace --env CASE_ID=case-1 c1 go run ."
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	got := strings.Join(buildArgs(ImageBuildSpec{
+		Tag:     "flavor:abc",
+		Labels:  map[string]string{LabelFlavorHash: "abc", LabelFlavor: "triage"},
+		Network: "lab-mirror",
+	}), " ")
+	want := "build --quiet --tag flavor:abc --network lab-mirror --label datamortem.flavor=triage --label datamortem.flavor.hash=abc -"
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,290 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrEngineUnavailable is returned by EngineMonitor.Run for jobs
+// submitted while the container engine is down, once its queue is full,
+// and for those still waiting when it is closed.
+var ErrEngineUnavailable = errors.New("orchestrator: container engine is unavailable")
+
+// Reconnection defaults of EngineMonitorConfig.
+const (
+	defaultEngineBackoff      = time.Second
+	defaultEngineMaxBackoff   = time.Minute
+	defaultEngineProbeTimeout = 10 * time.Second
+)
+
+// EngineMonitorConfig configures a EngineMonitor.
+type EngineMonitorConfig struct {
+	// QueueLimit is the number of jobs that may wait for the engine to
+	// recover; zero fails them at once with ErrEngineUnavailable.
+	QueueLimit int
+	// Backoff is the delay before the first reconnection attempt, doubled
+	// for each further one up to MaxBackoff; one second and one minute
+	// when zero.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// ProbeTimeout bounds each check of the engine; ten seconds when
+	// zero.
+	ProbeTimeout time.Duration
+}
+
+// EngineHealth is the status of the container engine as a
+// EngineMonitor last saw it.
+type EngineHealth struct {
+	Available bool `json:"available"`
+	// Since is when the engine was found down and Error why; they are
+	// unset while it is available.
+	Since *time.Time `json:"since,omitempty"`
+	Error string     `json:"error,omitempty"`
+	// Attempts counts the failed reconnection attempts of the outage, and
+	// NextAttempt is when the next one is due.
+	Attempts    int        `json:"reconnect_attempts,omitempty"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	// Queued is the number of jobs waiting for the engine, Rejected
+	// counts those failed with ErrEngineUnavailable.
+	Queued   int    `json:"queued"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// EngineMonitor runs jobs through a JobRunner, e.g. a WorkerPool over a
+// Runner, while watching the container engine they run on. A job whose
+// run fails with an InfraError while the engine does not answer, or
+// that is submitted during such an outage, waits for the engine to
+// recover, up to QueueLimit jobs, and is run once it has: the script
+// never started, so running it is safe. Beyond QueueLimit, jobs fail
+// with ErrEngineUnavailable rather than with the error of whichever
+// docker command noticed the outage first.
+//
+// During an outage the monitor checks the engine again and again, the
+// delay doubling from Backoff up to MaxBackoff. Mount it on the health
+// endpoint of the worker, e.g. /healthz, to report its status.
+type EngineMonitor struct {
+	runner  JobRunner
+	runtime Runtime
+	cfg     EngineMonitorConfig
+
+	mu       sync.Mutex
+	down     bool
+	since    time.Time
+	lastErr  error
+	attempts int
+	next     time.Time
+	// recovered is closed when the engine is found up again.
+	recovered chan struct{}
+	queued    int
+	rejected  uint64
+	closed    bool
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewEngineMonitor returns a EngineMonitor running jobs through r,
+// whose containers rt runs. The engine is taken to be available until a
+// job or Check finds otherwise.
+func NewEngineMonitor(r JobRunner, rt Runtime, cfg EngineMonitorConfig) (*EngineMonitor, error) {
+	if cfg.QueueLimit < 0 {
+		return nil, errors.New("orchestrator: QueueLimit must not be negative")
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultEngineBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultEngineMaxBackoff
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = defaultEngineProbeTimeout
+	}
+	return &EngineMonitor{runner: r, runtime: rt, cfg: cfg, stop: make(chan struct{})}, nil
+}
+
+// Run runs job once the engine is available, waiting for it to recover
+// from an outage in the queue, and returns its result.
+func (m *EngineMonitor) Run(ctx context.Context, job Job) (*JobResult, error) {
+	for {
+		if err := m.await(ctx); err != nil {
+			return nil, err
+		}
+		res, err := m.runner.Run(ctx, job)
+		if err == nil || !Retryable(err) || ctx.Err() != nil {
+			return res, err
+		}
+		// The engine answers: the failure is the job's own.
+		if m.Check(ctx) == nil {
+			return res, err
+		}
+	}
+}
+
+// await returns once the engine is available, or fails with
+// ErrEngineUnavailable when the queue is full.
+func (m *EngineMonitor) await(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return ErrEngineUnavailable
+		}
+		if !m.down {
+			m.mu.Unlock()
+			return nil
+		}
+		if m.queued >= m.cfg.QueueLimit {
+			m.rejected++
+			err := m.lastErr
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %v", ErrEngineUnavailable, err)
+		}
+		m.queued++
+		recovered := m.recovered
+		m.mu.Unlock()
+
+		var err error
+		select {
+		case <-recovered:
+		case <-m.stop:
+			err = ErrEngineUnavailable
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		m.mu.Lock()
+		m.queued--
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Check checks that the engine answers, listing its OCI runtimes, and
+// records the outcome: a failure starts the reconnection attempts, and a
+// success during an outage ends it. A check cut short by ctx records
+// nothing.
+func (m *EngineMonitor) Check(ctx context.Context) error {
+	err := m.probe(ctx)
+	switch {
+	case err == nil:
+		m.markUp()
+	case ctx.Err() == nil:
+		m.markDown(err)
+	}
+	return err
+}
+
+func (m *EngineMonitor) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.ProbeTimeout)
+	defer cancel()
+	if _, err := m.runtime.Runtimes(ctx); err != nil {
+		return &InfraError{Op: "check engine", Err: err}
+	}
+	return nil
+}
+
+// markDown records the outage err, starting the reconnection attempts
+// unless they are under way.
+func (m *EngineMonitor) markDown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastErr = err
+	if m.down || m.closed {
+		return
+	}
+	m.down, m.since, m.attempts = true, time.Now(), 0
+	m.next = m.since.Add(m.cfg.Backoff)
+	m.recovered = make(chan struct{})
+	m.wg.Add(1)
+	go m.reconnect()
+}
+
+// markUp ends the outage, if any, releasing the queued jobs.
+func (m *EngineMonitor) markUp() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.down {
+		return
+	}
+	m.down, m.lastErr = false, nil
+	close(m.recovered)
+}
+
+// reconnect checks the engine with an exponential backoff until it is
+// up again or the monitor is closed.
+func (m *EngineMonitor) reconnect() {
+	defer m.wg.Done()
+	delay := m.cfg.Backoff
+	for {
+		m.mu.Lock()
+		m.next = time.Now().Add(delay)
+		m.mu.Unlock()
+		t := time.NewTimer(delay)
+		select {
+		case <-m.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		err := m.probe(context.Background())
+		if err == nil {
+			m.markUp()
+			return
+		}
+		m.mu.Lock()
+		m.attempts++
+		m.lastErr = err
+		m.mu.Unlock()
+		delay = min(2*delay, m.cfg.MaxBackoff)
+	}
+}
+
+// Health returns the status of the engine.
+func (m *EngineMonitor) Health() EngineHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := EngineHealth{Available: !m.down, Queued: m.queued, Rejected: m.rejected}
+	if m.down {
+		since, next := m.since, m.next
+		h.Since, h.NextAttempt = &since, &next
+		h.Attempts = m.attempts
+		if m.lastErr != nil {
+			h.Error = m.lastErr.Error()
+		}
+	}
+	return h
+}
+
+// ServeHTTP writes the EngineHealth as JSON, with status 503 while the
+// engine is down. A request made while the engine is taken to be
+// available checks it first, so that an outage without jobs shows too.
+func (m *EngineMonitor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if m.Health().Available {
+		m.Check(req.Context())
+	}
+	h := m.Health()
+	w.Header().Set("Content-Type", "application/json")
+	if !h.Available {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// Close stops the reconnection attempts and ends the queued jobs with
+// ErrEngineUnavailable; jobs submitted afterwards fail with it too.
+func (m *EngineMonitor) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.stop)
+	m.mu.Unlock()
+	m.wg.Wait()
+}
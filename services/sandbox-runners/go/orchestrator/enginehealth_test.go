@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitHealth polls m until cond holds of its health.
+func waitHealth(t *testing.T, m *EngineMonitor, cond func(EngineHealth) bool) EngineHealth {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h := m.Health()
+		if cond(h) {
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("health = %+v", h)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEngineMonitorQueuesDuringOutage(t *testing.T) {
+	rt := &fakeRuntime{down: true}
+	m, err := NewEngineMonitor(NewRunner(rt), rt, EngineMonitorConfig{QueueLimit: 1, Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	type outcome struct {
+		res *JobResult
+		err error
+	}
+	done := make(chan outcome, 1)
+	job := testJob(t)
+	go func() {
+		res, err := m.Run(context.Background(), job)
+		done <- outcome{res, err}
+	}()
+	h := waitHealth(t, m, func(h EngineHealth) bool { return h.Queued == 1 })
+	if h.Available || h.Since == nil || h.Error == "" {
+		t.Errorf("health during the outage = %+v", h)
+	}
+
+	// The queue is full: the next job fails clearly.
+	if _, err := m.Run(context.Background(), testJob(t)); !errors.Is(err, ErrEngineUnavailable) {
+		t.Errorf("Run() with a full queue = %v, want ErrEngineUnavailable", err)
+	}
+	waitHealth(t, m, func(h EngineHealth) bool { return h.Attempts > 0 })
+
+	rt.setDown(false)
+	out := <-done
+	if out.err != nil || !out.res.Success {
+		t.Fatalf("queued job = %+v, %v", out.res, out.err)
+	}
+	if h := m.Health(); !h.Available || h.Queued != 0 || h.Rejected != 1 || h.Since != nil {
+		t.Errorf("health after the outage = %+v", h)
+	}
+}
+
+func TestEngineMonitorWithoutQueue(t *testing.T) {
+	rt := &fakeRuntime{}
+	m, err := NewEngineMonitor(NewRunner(rt), rt, EngineMonitorConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// A daemon that answers leaves the failure to the job.
+	rt.createErrs = []error{errors.New("no space left on device")}
+	if _, err := m.Run(context.Background(), testJob(t)); !Retryable(err) || errors.Is(err, ErrEngineUnavailable) {
+		t.Errorf("Run() = %v, want the create error", err)
+	}
+	if !m.Health().Available {
+		t.Errorf("engine taken to be down")
+	}
+
+	rt.setDown(true)
+	if _, err := m.Run(context.Background(), testJob(t)); !errors.Is(err, ErrEngineUnavailable) {
+		t.Errorf("Run() during an outage = %v, want ErrEngineUnavailable", err)
+	}
+}
+
+func TestEngineMonitorClose(t *testing.T) {
+	rt := &fakeRuntime{down: true}
+	m, err := NewEngineMonitor(NewRunner(rt), rt, EngineMonitorConfig{QueueLimit: 1, Backoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	job := testJob(t)
+	go func() {
+		_, err := m.Run(context.Background(), job)
+		done <- err
+	}()
+	waitHealth(t, m, func(h EngineHealth) bool { return h.Queued == 1 })
+	m.Close()
+	if err := <-done; !errors.Is(err, ErrEngineUnavailable) {
+		t.Errorf("queued job after Close() = %v", err)
+	}
+}
+
+func TestEngineMonitorServeHTTP(t *testing.T) {
+	rt := &fakeRuntime{}
+	m, err := NewEngineMonitor(NewRunner(rt), rt, EngineMonitorConfig{Backoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	get := func() (int, EngineHealth) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var h EngineHealth
+		if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, h
+	}
+	if code, h := get(); code != http.StatusOK || !h.Available {
+		t.Errorf("health = %d %+v", code, h)
+	}
+	// The endpoint notices an outage without any job.
+	rt.setDown(true)
+	if code, h := get(); code != http.StatusServiceUnavailable || h.Available || h.NextAttempt == nil {
+		t.Errorf("health during the outage = %d %+v", code, h)
+	}
+}
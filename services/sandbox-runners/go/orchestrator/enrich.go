@@ -0,0 +1,355 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+var (
+	// ErrInvalidEnricher is returned for an enricher registered without a
+	// name, or under the name of another.
+	ErrInvalidEnricher = errors.New("orchestrator: invalid enricher")
+	// ErrUnknownEnricher is returned when enabling or disabling an
+	// enricher that EnrichmentPipeline does not hold.
+	ErrUnknownEnricher = errors.New("orchestrator: unknown enricher")
+)
+
+// Codes of the warnings added to a job's result when its enrichment
+// fails.
+const (
+	// WarningEnrichmentFailed is added for each record an enricher failed
+	// on; the record is kept without the enricher's data.
+	WarningEnrichmentFailed = "enrichment.failed"
+	// WarningEnricherSkipped is added when an enricher failed
+	// maxEnricherFailures times for a job, and was not run over its other
+	// records.
+	WarningEnricherSkipped = "enrichment.skipped"
+)
+
+// DefaultEnrichTimeout bounds each call of an enricher whose
+// EnricherConfig sets no Timeout.
+const DefaultEnrichTimeout = 10 * time.Second
+
+// maxEnricherFailures is the number of failures after which an enricher
+// is given up for the rest of a job, e.g. when its service is down.
+const maxEnricherFailures = 3
+
+// Enrichments holds the data enrichers attached to a record, by enricher
+// name.
+type Enrichments map[string]json.RawMessage
+
+// merge adds the data of e to d, that of a later report replacing an
+// earlier one, allocating d if needed.
+func (d Enrichments) merge(e Enrichments) Enrichments {
+	if len(e) == 0 {
+		return d
+	}
+	if d == nil {
+		d = Enrichments{}
+	}
+	maps.Copy(d, e)
+	return d
+}
+
+// EnrichmentTarget is the record of a job an enricher is run over: one of
+// Finding and IOC is set.
+type EnrichmentTarget struct {
+	CaseID  string
+	JobID   string
+	Finding *sandbox.Result
+	IOC     *sandbox.IOC
+}
+
+// Enricher adds context to the findings and indicators of the jobs, e.g.
+// the GeoIP of an IP address, the reputation of a file hash or the WHOIS
+// of a domain, before they are merged into the case.
+type Enricher interface {
+	// Enrich returns the data to attach to target, marshalled to JSON, or
+	// nil when the enricher has nothing to say about it, e.g. a GeoIP
+	// enricher for a hash. Its network requests go through client, which
+	// only reaches the EnricherConfig.AllowedHosts of the enricher.
+	Enrich(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error)
+}
+
+// EnricherFunc adapts a function to an Enricher.
+type EnricherFunc func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error)
+
+// Enrich calls f.
+func (f EnricherFunc) Enrich(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+	return f(ctx, client, target)
+}
+
+// EnricherConfig describes how an enricher is run.
+type EnricherConfig struct {
+	// Timeout bounds each call of the enricher; DefaultEnrichTimeout when
+	// zero.
+	Timeout time.Duration
+	// AllowedHosts and RatePerHost are the egress controls of the
+	// enricher's client, as ExecConfig.AllowedHosts and RatePerHost are
+	// those of a script: host names, "*." for subdomains, and requests
+	// per second to each. The client reaches nothing when AllowedHosts is
+	// empty.
+	AllowedHosts []string
+	RatePerHost  float64
+	// OptIn leaves the enricher off until Enable turns it on for a case,
+	// e.g. a lookup that sends the hashes of the case to a third party.
+	OptIn bool
+}
+
+type registeredEnricher struct {
+	name     string
+	enricher Enricher
+	cfg      EnricherConfig
+	client   *http.Client
+}
+
+// EnrichmentPipeline runs its enrichers over the findings and indicators
+// of every job of Runner.Enrichment, the data of each attached to the
+// record under its name: JobResult.FindingEnrichments and IOCEnrichments,
+// then Finding.Enrichments and CaseIOC.Enrichments once merged into the
+// case. An enricher that fails or times out on a record only costs that
+// record its data, and a warning. Its zero value is empty and ready to
+// use, and it is safe for concurrent use.
+type EnrichmentPipeline struct {
+	mu        sync.Mutex
+	enrichers []*registeredEnricher
+	// cases holds the enrichers turned on or off for each case, against
+	// their OptIn default.
+	cases map[string]map[string]bool
+}
+
+// Register adds enricher e under name, which names its data, e.g.
+// "geoip". Enrichers run in the order they are registered.
+func (p *EnrichmentPipeline) Register(name string, e Enricher, cfg EnricherConfig) error {
+	if name == "" || e == nil {
+		return fmt.Errorf("%w: an enricher needs a name and an implementation", ErrInvalidEnricher)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lookup(name) != nil {
+		return fmt.Errorf("%w: %q is already registered", ErrInvalidEnricher, name)
+	}
+	client := &http.Client{Transport: &egressTransport{allowed: cfg.AllowedHosts, limiter: newHostLimiter(cfg.RatePerHost)}}
+	p.enrichers = append(p.enrichers, &registeredEnricher{name: name, enricher: e, cfg: cfg, client: client})
+	return nil
+}
+
+// lookup returns the enricher name, under p.mu.
+func (p *EnrichmentPipeline) lookup(name string) *registeredEnricher {
+	for _, e := range p.enrichers {
+		if e.name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// Enable turns the enricher name on for the jobs of caseID.
+func (p *EnrichmentPipeline) Enable(caseID, name string) error {
+	return p.set(caseID, name, true)
+}
+
+// Disable turns the enricher name off for the jobs of caseID.
+func (p *EnrichmentPipeline) Disable(caseID, name string) error {
+	return p.set(caseID, name, false)
+}
+
+func (p *EnrichmentPipeline) set(caseID, name string, on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lookup(name) == nil {
+		return fmt.Errorf("%w: %q", ErrUnknownEnricher, name)
+	}
+	if p.cases == nil {
+		p.cases = map[string]map[string]bool{}
+	}
+	if p.cases[caseID] == nil {
+		p.cases[caseID] = map[string]bool{}
+	}
+	p.cases[caseID][name] = on
+	return nil
+}
+
+// Enabled returns the names of the enrichers run for the jobs of caseID,
+// in order.
+func (p *EnrichmentPipeline) Enabled(caseID string) []string {
+	var names []string
+	for _, e := range p.enabled(caseID) {
+		names = append(names, e.name)
+	}
+	return names
+}
+
+func (p *EnrichmentPipeline) enabled(caseID string) []*registeredEnricher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []*registeredEnricher
+	for _, e := range p.enrichers {
+		on, ok := p.cases[caseID][e.name]
+		if !ok {
+			on = !e.cfg.OptIn
+		}
+		if on {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// enrich runs the enrichers of job's case over res.Findings and res.IOCs,
+// concurrently across enrichers, and adds the warnings of their failures
+// to res. It is a no-op on a nil pipeline and for a cancelled job.
+func (p *EnrichmentPipeline) enrich(ctx context.Context, job Job, res *JobResult) {
+	if p == nil || res.Cancelled || len(res.Findings)+len(res.IOCs) == 0 {
+		return
+	}
+	enrichers := p.enabled(job.CaseID)
+	if len(enrichers) == 0 {
+		return
+	}
+	type outcome struct {
+		findings, iocs map[int]json.RawMessage
+		warnings       []sandbox.Warning
+	}
+	outcomes := make([]outcome, len(enrichers))
+	var wg sync.WaitGroup
+	for i, e := range enrichers {
+		wg.Add(1)
+		go func(i int, e *registeredEnricher) {
+			defer wg.Done()
+			o := &outcomes[i]
+			o.findings, o.iocs = map[int]json.RawMessage{}, map[int]json.RawMessage{}
+			failures := 0
+			run := func(target EnrichmentTarget, uid, record string, into map[int]json.RawMessage, index int) {
+				if failures >= maxEnricherFailures {
+					return
+				}
+				data, err := e.run(ctx, target)
+				if err == nil {
+					if data != nil {
+						into[index] = data
+					}
+					return
+				}
+				failures++
+				o.warnings = append(o.warnings, enrichmentWarning(uid, WarningEnrichmentFailed,
+					fmt.Sprintf("enricher %s failed on %s: %v", e.name, record, err), e.name, record))
+				if failures == maxEnricherFailures {
+					o.warnings = append(o.warnings, enrichmentWarning(job.Evidence.UID, WarningEnricherSkipped,
+						fmt.Sprintf("enricher %s skipped for the rest of the job after %d failures", e.name, failures), e.name, ""))
+				}
+			}
+			// Each enricher gets its own copy of the records.
+			for j, f := range res.Findings {
+				f := f
+				run(EnrichmentTarget{CaseID: job.CaseID, JobID: job.ID, Finding: &f}, f.EvidenceUID, fmt.Sprintf("finding %q", f.Title), o.findings, j)
+			}
+			for j, ioc := range res.IOCs {
+				ioc := ioc
+				run(EnrichmentTarget{CaseID: job.CaseID, JobID: job.ID, IOC: &ioc}, ioc.EvidenceUID, ioc.Key(), o.iocs, j)
+			}
+		}(i, e)
+	}
+	wg.Wait()
+	for i, e := range enrichers {
+		o := outcomes[i]
+		res.FindingEnrichments = attachEnrichments(res.FindingEnrichments, e.name, o.findings)
+		res.IOCEnrichments = attachEnrichments(res.IOCEnrichments, e.name, o.iocs)
+		res.Warnings = append(res.Warnings, o.warnings...)
+	}
+}
+
+// attachEnrichments adds the data of enricher name, by record index, to
+// into.
+func attachEnrichments(into map[int]Enrichments, name string, data map[int]json.RawMessage) map[int]Enrichments {
+	for i, d := range data {
+		if into == nil {
+			into = map[int]Enrichments{}
+		}
+		into[i] = into[i].merge(Enrichments{name: d})
+	}
+	return into
+}
+
+func enrichmentWarning(uid, code, msg, enricher, record string) sandbox.Warning {
+	fields := map[string]any{"enricher": enricher}
+	if record != "" {
+		fields["record"] = record
+	}
+	return sandbox.Warning{EvidenceUID: uid, Code: code, Message: msg, Fields: fields}
+}
+
+// run calls the enricher over target within its timeout, turning a panic
+// into an error so that it cannot take the job down.
+func (e *registeredEnricher) run(ctx context.Context, target EnrichmentTarget) (data json.RawMessage, err error) {
+	timeout := e.cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultEnrichTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type result struct {
+		v   any
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- result{err: fmt.Errorf("panic: %v", p)}
+			}
+		}()
+		v, err := e.enricher.Enrich(ctx, e.client, target)
+		done <- result{v, err}
+	}()
+	// An enricher that ignores its context is abandoned at the timeout.
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil || r.v == nil {
+		return nil, r.err
+	}
+	if data, err = json.Marshal(r.v); err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// egressTransport applies the egress controls of an enricher to its
+// requests: only the allowlisted hosts are reached, at most at the rate
+// of limiter.
+type egressTransport struct {
+	allowed []string
+	limiter *hostLimiter
+}
+
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if !hostAllowed(host, t.allowed) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("egress to %s is not allowed", host)
+	}
+	if err := t.limiter.wait(req.Context(), host, nil); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
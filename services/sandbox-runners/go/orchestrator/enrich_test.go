@@ -0,0 +1,184 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// writeEnrichable makes the job report a finding and two indicators.
+func writeEnrichable(spec ContainerSpec) {
+	for _, m := range spec.Mounts {
+		if m.Target != containerOutputDir {
+			continue
+		}
+		os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+			`{"evidence_uid":"ev-1","severity":"high","title":"Beacon","finding_key":"beacon/1"}`+"\n"), 0o644)
+		os.WriteFile(filepath.Join(m.Source, sandbox.IOCsFile), []byte(
+			`{"evidence_uid":"ev-1","kind":"ipv4","value":"198.51.100.4"}`+"\n"+
+				`{"evidence_uid":"ev-1","kind":"sha256","value":"`+strings.Repeat("ab", 32)+`"}`+"\n"), 0o644)
+	}
+}
+
+func TestRunEnrichesRecords(t *testing.T) {
+	geo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"ip":%q,"country":"NL"}`, req.URL.Query().Get("ip"))
+	}))
+	defer geo.Close()
+	geoURL, _ := url.Parse(geo.URL)
+
+	var p EnrichmentPipeline
+	// geoip looks IP addresses up over the network.
+	err := p.Register("geoip", EnricherFunc(func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+		if target.IOC == nil || target.IOC.Kind != sandbox.IOCIPv4 {
+			return nil, nil
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, geo.URL+"?ip="+target.IOC.Value, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		return json.RawMessage(data), err
+	}), EnricherConfig{AllowedHosts: []string{geoURL.Hostname()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// broken fails on findings and panics on indicators.
+	p.Register("broken", EnricherFunc(func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+		if target.Finding != nil {
+			return nil, errors.New("quota exceeded")
+		}
+		panic("nil map")
+	}), EnricherConfig{})
+	// reputation sends hashes to a third party: off unless the case opts in.
+	p.Register("reputation", EnricherFunc(func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+		if target.IOC == nil || target.IOC.Kind != sandbox.IOCSHA256 {
+			return nil, nil
+		}
+		return map[string]any{"malicious": 12}, nil
+	}), EnricherConfig{OptIn: true})
+
+	rt := &fakeRuntime{onStart: writeEnrichable}
+	r := NewRunner(rt)
+	r.Enrichment = &p
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.IOCEnrichments[0]["geoip"]; string(got) != `{"ip":"198.51.100.4","country":"NL"}` {
+		t.Errorf("geoip of the IP = %s", got)
+	}
+	if len(res.FindingEnrichments) != 0 || len(res.IOCEnrichments) != 1 {
+		t.Errorf("enrichments %v and %v, want the IP's only", res.FindingEnrichments, res.IOCEnrichments)
+	}
+	// The failures of broken cost the records nothing but its data.
+	failed := 0
+	for _, w := range res.Warnings {
+		if w.Code == WarningEnrichmentFailed && w.Fields["enricher"] == "broken" {
+			failed++
+		}
+	}
+	if failed != 3 || len(res.Findings) != 1 || len(res.IOCs) != 2 {
+		t.Errorf("%d failures of broken, %d findings and %d IOCs: %+v", failed, len(res.Findings), len(res.IOCs), res.Warnings)
+	}
+
+	iocs := NewCaseIOCs("case-1")
+	if err := iocs.Add(testJob(t), res); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := iocs.Lookup(sandbox.IOCIPv4, "198.51.100.4"); e.Enrichments["geoip"] == nil {
+		t.Errorf("case IOC %+v", e)
+	}
+
+	// The case opts in to reputation, and out of geoip and broken.
+	if err := p.Enable("case-1", "reputation"); err != nil {
+		t.Fatal(err)
+	}
+	p.Disable("case-1", "geoip")
+	p.Disable("case-1", "broken")
+	if got, want := p.Enabled("case-1"), []string{"reputation"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("enabled for case-1 %q, want %q", got, want)
+	}
+	if got, want := p.Enabled("case-2"), []string{"geoip", "broken"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("enabled for case-2 %q, want %q", got, want)
+	}
+	if res, err = r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := res.IOCEnrichments[1]["reputation"]; string(got) != `{"malicious":12}` || res.IOCEnrichments[0] != nil || len(res.Warnings) != 0 {
+		t.Errorf("enrichments %v, warnings %+v", res.IOCEnrichments, res.Warnings)
+	}
+	findings := NewCaseFindings("case-1")
+	res.FindingEnrichments = map[int]Enrichments{0: {"note": json.RawMessage(`"seen before"`)}}
+	findings.Add(testJob(t), res)
+	if f := findings.Findings(); len(f) != 1 || string(f[0].Enrichments["note"]) != `"seen before"` {
+		t.Errorf("case findings %+v", f)
+	}
+
+	if err := p.Enable("case-1", "whois"); !errors.Is(err, ErrUnknownEnricher) {
+		t.Errorf("Enable() of an unknown enricher: %v", err)
+	}
+	if err := p.Register("geoip", EnricherFunc(nil), EnricherConfig{}); !errors.Is(err, ErrInvalidEnricher) {
+		t.Errorf("Register() of a duplicate: %v", err)
+	}
+}
+
+func TestEnricherEgressAndTimeout(t *testing.T) {
+	var calls atomic.Int32
+	var p EnrichmentPipeline
+	// whois reaches for a host it is not allowed.
+	p.Register("whois", EnricherFunc(func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+		_, err := client.Get("http://whois.example/198.51.100.4")
+		return nil, err
+	}), EnricherConfig{AllowedHosts: []string{"rdap.example"}})
+	// slow ignores its context: it is abandoned at the timeout, and given
+	// up after maxEnricherFailures records.
+	p.Register("slow", EnricherFunc(func(ctx context.Context, client *http.Client, target EnrichmentTarget) (any, error) {
+		calls.Add(1)
+		time.Sleep(time.Second)
+		return "late", nil
+	}), EnricherConfig{Timeout: 20 * time.Millisecond})
+
+	res := &JobResult{}
+	for i := 0; i < 5; i++ {
+		res.IOCs = append(res.IOCs, sandbox.IOC{EvidenceUID: "ev-1", Kind: sandbox.IOCIPv4, Value: fmt.Sprintf("198.51.100.%d", i)})
+	}
+	started := time.Now()
+	p.enrich(context.Background(), testJob(t), res)
+	if elapsed := time.Since(started); elapsed > 900*time.Millisecond {
+		t.Errorf("enrichment took %s", elapsed)
+	}
+	codes := map[string]int{}
+	for _, w := range res.Warnings {
+		codes[w.Fields["enricher"].(string)+"/"+w.Code]++
+		if w.Fields["enricher"] == "whois" && w.Code == WarningEnrichmentFailed && !strings.Contains(w.Message, "egress to whois.example is not allowed") {
+			t.Errorf("whois warning %q", w.Message)
+		}
+	}
+	want := map[string]int{
+		"whois/" + WarningEnrichmentFailed: 3, "whois/" + WarningEnricherSkipped: 1,
+		"slow/" + WarningEnrichmentFailed: 3, "slow/" + WarningEnricherSkipped: 1,
+	}
+	if !reflect.DeepEqual(codes, want) || calls.Load() != maxEnricherFailures {
+		t.Errorf("warnings %v after %d calls of slow, want %v", codes, calls.Load(), want)
+	}
+	if len(res.IOCEnrichments) != 0 || len(res.IOCs) != 5 {
+		t.Errorf("enrichments %v", res.IOCEnrichments)
+	}
+}
@@ -0,0 +1,12 @@
+package orchestrator
+
+// containerEntrypoint is where the runner images install
+// cmd/sandbox-entrypoint.
+const containerEntrypoint = "/usr/local/bin/sandbox-entrypoint"
+
+// wrapEntrypoint runs cmd through the entrypoint, for ExecConfig.Entrypoint.
+// It goes inside the metrics and quota wrappers, so that the quota wrapper
+// copies the status file it writes to /output.
+func wrapEntrypoint(cmd []string) []string {
+	return append([]string{containerEntrypoint}, cmd...)
+}
@@ -0,0 +1,49 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunEntrypoint(t *testing.T) {
+	status := `{"command":["go","run","."],"exit_code":0}`
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.JobStatusFile), []byte(status), 0o644)
+			}
+		}
+	}
+	r := NewRunner(rt)
+	cfg := DefaultExecConfig()
+	cfg.Entrypoint, cfg.ResourceMetrics = true, true
+	job := testJob(t)
+	job.Config = &cfg
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entrypoint replaces the `go run` wrapper.
+	if want := wrapMetrics(append([]string{containerEntrypoint}, boundGoCompile([]string{"go", "run", "."}, DefaultBuildTimeout)...)); !reflect.DeepEqual(rt.lastSpec().Cmd, want) {
+		t.Errorf("cmd = %q", rt.lastSpec().Cmd)
+	}
+	if !res.Success || res.JobStatus == nil || res.JobStatus.Command[0] != "go" || len(res.Artifacts) != 0 {
+		t.Errorf("result = %+v, status %+v", res, res.JobStatus)
+	}
+
+	status = `{"command":["go","run","."],"exit_code":125,"contract_error":"OUTPUT_DIR is not writable"}`
+	rt.state = ContainerState{ExitCode: sandbox.ContractExitCode}
+	res, err = r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FailureReason != FailureInternalError || res.FailureDetail != "the container does not meet the environment contract: OUTPUT_DIR is not writable" {
+		t.Errorf("failure = %s: %s", res.FailureReason, res.FailureDetail)
+	}
+}
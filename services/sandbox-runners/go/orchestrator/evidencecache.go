@@ -0,0 +1,232 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEvidenceCacheIdle is how long EvidenceCache keeps unused evidence
+// when EvidenceCache.Idle is zero.
+const DefaultEvidenceCacheIdle = 30 * time.Second
+
+// Kinds of prepared evidence in an EvidenceCache.
+const (
+	cacheDecompressed = "decompressed"
+	cacheBlockDevice  = "block"
+)
+
+// EvidenceCache keeps the evidence a Runner prepared for a job, the
+// decompressed copy of ExecConfig.DecompressEvidence and the block device
+// of ExecConfig.EvidenceBlockDevice, for the next jobs on the same
+// evidence, as in a fan-out or a pipeline of scripts. Entries are keyed by
+// evidence UID and digest; jobs holding one share it, and it is torn down
+// once no job has used it for Idle. Only read-only evidence with a
+// recorded digest is cached, so that no job can alter what the next one
+// reads. It is safe for concurrent use.
+type EvidenceCache struct {
+	// Idle is how long an unused entry is kept for the next job;
+	// DefaultEvidenceCacheIdle when zero. A negative Idle tears entries
+	// down as soon as their last job ends, sharing them only between jobs
+	// that overlap.
+	Idle time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*evidenceEntry
+	closed  bool
+	hits    uint64
+	misses  uint64
+}
+
+// evidenceEntry is evidence prepared once for the jobs of an
+// EvidenceCache.
+type evidenceEntry struct {
+	cache *EvidenceCache
+	key   string
+	// refs counts the jobs holding the entry; idle tears it down once it
+	// has had none for EvidenceCache.Idle.
+	refs int
+	idle *time.Timer
+	// ready is closed once prepare returned err.
+	ready chan struct{}
+	err   error
+	// raw is the decompressed evidence; dev the block device.
+	raw      Evidence
+	dev      BlockDevice
+	teardown func()
+}
+
+// EvidenceCacheMetrics describes the use of an EvidenceCache.
+type EvidenceCacheMetrics struct {
+	// Entries counts the prepared evidence kept, InUse those held by a
+	// running job.
+	Entries int
+	InUse   int
+	// Hits counts the jobs that reused prepared evidence, Misses those
+	// that prepared it.
+	Hits   uint64
+	Misses uint64
+}
+
+// NewEvidenceCache returns an empty cache.
+func NewEvidenceCache() *EvidenceCache {
+	return &EvidenceCache{entries: map[string]*evidenceEntry{}}
+}
+
+// evidenceCache returns the cache of jobs run with cfg, nil when their
+// evidence is not to be cached.
+func (r *Runner) evidenceCache(cfg ExecConfig) *EvidenceCache {
+	if r.EvidenceCache == nil || (cfg.EvidenceWritable && !cfg.EvidenceOverlay) {
+		return nil
+	}
+	return r.EvidenceCache
+}
+
+// cacheable reports whether ev is identified well enough to be shared.
+func (c *EvidenceCache) cacheable(ev Evidence) bool {
+	return c != nil && ev.UID != "" && ev.SHA256 != "" && !ev.decrypted
+}
+
+// evidenceKey identifies the evidence prepared as kind from ev.
+func evidenceKey(kind string, ev Evidence) string {
+	algo := ev.HashAlgo
+	if algo == "" {
+		algo = "sha256"
+	}
+	return kind + "\x00" + ev.UID + "\x00" + strings.ToLower(algo) + ":" + strings.ToLower(ev.SHA256)
+}
+
+// acquire returns the entry of key, calling prepare to fill it in when
+// there is none. Jobs asking for an entry being prepared wait for it. A
+// failed preparation is not cached. The caller releases the entry.
+func (c *EvidenceCache) acquire(key string, prepare func(e *evidenceEntry) error) (*evidenceEntry, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		e.refs++
+		if e.idle != nil {
+			e.idle.Stop()
+			e.idle = nil
+		}
+		c.hits++
+		c.mu.Unlock()
+		<-e.ready
+		if e.err != nil {
+			c.release(e)
+			return nil, e.err
+		}
+		return e, nil
+	}
+	e := &evidenceEntry{cache: c, key: key, refs: 1, ready: make(chan struct{})}
+	c.entries[key] = e
+	c.misses++
+	c.mu.Unlock()
+
+	e.err = prepare(e)
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		e.teardown = nil
+	}
+	close(e.ready)
+	if e.err != nil {
+		c.release(e)
+		return nil, e.err
+	}
+	return e, nil
+}
+
+// release drops a job's hold on e, starting its idle timer when it was
+// the last one.
+func (c *EvidenceCache) release(e *evidenceEntry) {
+	c.mu.Lock()
+	e.refs--
+	if e.refs > 0 {
+		c.mu.Unlock()
+		return
+	}
+	idle := c.Idle
+	if idle == 0 {
+		idle = DefaultEvidenceCacheIdle
+	}
+	if c.closed || idle < 0 || c.entries[e.key] != e {
+		if c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+		c.mu.Unlock()
+		e.close()
+		return
+	}
+	e.idle = time.AfterFunc(idle, func() { c.evict(e) })
+	c.mu.Unlock()
+}
+
+// evict tears e down if no job took it up again.
+func (c *EvidenceCache) evict(e *evidenceEntry) {
+	c.mu.Lock()
+	if e.refs > 0 || c.entries[e.key] != e {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.entries, e.key)
+	c.mu.Unlock()
+	e.close()
+}
+
+func (e *evidenceEntry) close() {
+	if e.teardown != nil {
+		e.teardown()
+	}
+}
+
+// Metrics returns the use of the cache.
+func (c *EvidenceCache) Metrics() EvidenceCacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := EvidenceCacheMetrics{Entries: len(c.entries), Hits: c.hits, Misses: c.misses}
+	for _, e := range c.entries {
+		if e.refs > 0 {
+			m.InUse++
+		}
+	}
+	return m
+}
+
+// Close tears down the unused entries at once, and the others when their
+// last job ends.
+func (c *EvidenceCache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	var unused []*evidenceEntry
+	for key, e := range c.entries {
+		if e.refs == 0 {
+			if e.idle != nil {
+				e.idle.Stop()
+			}
+			delete(c.entries, key)
+			unused = append(unused, e)
+		}
+	}
+	c.mu.Unlock()
+	for _, e := range unused {
+		e.close()
+	}
+}
+
+// attachCached returns the block device of ev from the cache, attaching
+// it on first use.
+func (c *EvidenceCache) attachCached(ctx context.Context, devices BlockDeviceAttacher, ev Evidence) (*evidenceEntry, error) {
+	return c.acquire(evidenceKey(cacheBlockDevice, ev), func(e *evidenceEntry) error {
+		dev, err := devices.Attach(ctx, ev)
+		if err != nil {
+			return err
+		}
+		e.dev = dev
+		// The device outlives the job that attached it.
+		e.teardown = func() { devices.Detach(context.Background(), dev) }
+		return nil
+	})
+}
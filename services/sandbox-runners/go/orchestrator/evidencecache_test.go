@@ -0,0 +1,125 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// evidenceSource returns the host file mounted as the job's evidence.
+func evidenceSource(spec ContainerSpec) string {
+	for _, m := range spec.Mounts {
+		if strings.HasPrefix(m.Target, containerEvidence+"/") {
+			return m.Source
+		}
+	}
+	return ""
+}
+
+func TestEvidenceCacheSharesDecompressedEvidence(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.EvidenceCache = NewEvidenceCache()
+	cfg := DefaultExecConfig()
+	cfg.DecompressEvidence = true
+	ev := gzipEvidence(t, "MBR")
+
+	var sources []string
+	for _, id := range []string{"job-1", "job-2"} {
+		job := testJob(t)
+		job.ID = id
+		job.Config = &cfg
+		job.Evidence = ev
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, evidenceSource(rt.lastSpec()))
+	}
+	if sources[0] == "" || sources[0] != sources[1] {
+		t.Errorf("evidence sources = %q, want one decompressed copy", sources)
+	}
+	if data, err := os.ReadFile(sources[1]); err != nil || string(data) != "MBR" {
+		t.Errorf("cached evidence = %q, %v", data, err)
+	}
+	if m := r.EvidenceCache.Metrics(); m != (EvidenceCacheMetrics{Entries: 1, Hits: 1, Misses: 1}) {
+		t.Errorf("metrics = %+v", m)
+	}
+
+	r.EvidenceCache.Close()
+	if _, err := os.Stat(sources[0]); !os.IsNotExist(err) {
+		t.Errorf("decompressed copy still there after Close: %v", err)
+	}
+
+	// Writable evidence is never shared.
+	cfg.EvidenceWritable = true
+	job := testJob(t)
+	job.Config = &cfg
+	job.Evidence = ev
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if m := r.EvidenceCache.Metrics(); m.Misses != 1 || m.Entries != 0 {
+		t.Errorf("metrics = %+v, want writable evidence left out", m)
+	}
+}
+
+func TestEvidenceCacheTearsDownUnusedEntries(t *testing.T) {
+	rt := &fakeRuntime{}
+	devs := &fakeDevices{}
+	r := NewRunner(rt)
+	r.BlockDevices = devs
+	r.EvidenceCache = NewEvidenceCache()
+	r.EvidenceCache.Idle = -1
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.Run(context.Background(), blockDeviceJob(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ev-1 has a digest and is cached, ev-2 is not; without an idle time
+	// neither outlives its job.
+	if want := []string{"ev-1", "ev-2", "ev-1", "ev-2"}; !reflect.DeepEqual(devs.attached, want) {
+		t.Errorf("attached = %v, want %v", devs.attached, want)
+	}
+	if len(devs.detached) != 4 {
+		t.Errorf("detached = %v, want every device", devs.detached)
+	}
+	if m := r.EvidenceCache.Metrics(); m.Entries != 0 || m.Misses != 2 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestEvidenceCacheRefCounts(t *testing.T) {
+	c := NewEvidenceCache()
+	prepared, torn := 0, 0
+	prepare := func(e *evidenceEntry) error {
+		prepared++
+		e.teardown = func() { torn++ }
+		return nil
+	}
+	ev := Evidence{UID: "ev-1", SHA256: "ABC"}
+	a, _ := c.acquire(evidenceKey(cacheDecompressed, ev), prepare)
+	ev.SHA256 = "abc"
+	b, _ := c.acquire(evidenceKey(cacheDecompressed, ev), prepare)
+	if a != b || prepared != 1 {
+		t.Fatalf("prepared %d times, want one shared entry", prepared)
+	}
+	c.release(a)
+	if m := c.Metrics(); m.InUse != 1 {
+		t.Errorf("metrics = %+v, want the entry still in use", m)
+	}
+	c.release(b)
+	if m := c.Metrics(); m.InUse != 0 || m.Entries != 1 || torn != 0 {
+		t.Errorf("metrics = %+v, torn down %d; want the entry kept while idle", m, torn)
+	}
+	// Taken up again before its idle time, the entry is not prepared again.
+	a, _ = c.acquire(evidenceKey(cacheDecompressed, ev), prepare)
+	c.release(a)
+	c.Close()
+	if prepared != 1 || torn != 1 {
+		t.Errorf("prepared %d, torn down %d times; want once each", prepared, torn)
+	}
+}
@@ -0,0 +1,181 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// EvidenceMode is how an evidence item is exposed to the script.
+type EvidenceMode string
+
+// Modes of JobResult.EvidenceModes.
+const (
+	EvidenceModeFile  EvidenceMode = "file"
+	EvidenceModeBlock EvidenceMode = "block"
+)
+
+// containerBlockDev is the block device of the first evidence item in the
+// container; the others get containerBlockDev<n>.
+const containerBlockDev = "/dev/evidence"
+
+// BlockDevice is a host block device exposing an evidence item.
+type BlockDevice struct {
+	// Path is the device node, e.g. /dev/loop3.
+	Path string
+	// GID is the group owning the node, which the sandbox user joins to
+	// read it.
+	GID int
+}
+
+// BlockDeviceAttacher exposes disk images as read-only block devices on
+// the host, for ExecConfig.EvidenceBlockDevice.
+type BlockDeviceAttacher interface {
+	// Attach returns a read-only block device with the raw content of ev,
+	// a raw or EWF image.
+	Attach(ctx context.Context, ev Evidence) (BlockDevice, error)
+	// Detach releases a device returned by Attach.
+	Detach(ctx context.Context, dev BlockDevice) error
+}
+
+// LoopDevices attaches raw images with `losetup --read-only` and EWF
+// images through `ewfmount` (libewf). Both need root, or the rights to
+// set up loop devices and FUSE mounts, on the orchestrator host. It is
+// safe for concurrent use.
+type LoopDevices struct {
+	// TempDir holds the ewfmount mount points; os.TempDir() when empty.
+	TempDir string
+
+	mu sync.Mutex
+	// ewf maps a loop device to the ewfmount mount point it reads.
+	ewf map[string]string
+}
+
+// attachedDevice is a block device of a job's evidence item, held in entry
+// when it comes from Runner.EvidenceCache.
+type attachedDevice struct {
+	index int
+	dev   BlockDevice
+	entry *evidenceEntry
+}
+
+// blockEvidence reports whether ev can be exposed as a block device: an
+// uncompressed raw or EWF image, as a whole.
+func blockEvidence(ev Evidence) bool {
+	return ev.Path != "" && !ev.compressed() && ev.Offset == 0 && ev.Length == 0 &&
+		(ev.Type == sandbox.EvidenceTypeDiskImage || ev.Type == sandbox.EvidenceTypeEWF)
+}
+
+func (r *Runner) blockDevices() BlockDeviceAttacher {
+	if r.BlockDevices != nil {
+		return r.BlockDevices
+	}
+	return defaultLoopDevices
+}
+
+var defaultLoopDevices = &LoopDevices{}
+
+// attachEvidence attaches the disk images of job as block devices and
+// returns the mode of every evidence item. A device that cannot be set up
+// leaves its evidence in file mode, with the reason in err; the job runs
+// all the same. With cache, the devices it can share are taken from it.
+func (r *Runner) attachEvidence(ctx context.Context, job Job, cache *EvidenceCache) (devices []attachedDevice, modes map[string]EvidenceMode, err error) {
+	modes = map[string]EvidenceMode{}
+	var errs []error
+	for i, ev := range job.allEvidence() {
+		modes[ev.UID] = EvidenceModeFile
+		if !blockEvidence(ev) {
+			continue
+		}
+		if cache.cacheable(ev) {
+			e, err := cache.attachCached(ctx, r.blockDevices(), ev)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("evidence %s: %w", ev.UID, err))
+				continue
+			}
+			devices = append(devices, attachedDevice{index: i, dev: e.dev, entry: e})
+			modes[ev.UID] = EvidenceModeBlock
+			continue
+		}
+		dev, err := r.blockDevices().Attach(ctx, ev)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("evidence %s: %w", ev.UID, err))
+			continue
+		}
+		devices = append(devices, attachedDevice{index: i, dev: dev})
+		modes[ev.UID] = EvidenceModeBlock
+	}
+	return devices, modes, errors.Join(errs...)
+}
+
+// detachEvidence releases the devices of attachEvidence.
+func (r *Runner) detachEvidence(ctx context.Context, devices []attachedDevice) {
+	for _, d := range devices {
+		if d.entry != nil {
+			d.entry.cache.release(d.entry)
+			continue
+		}
+		r.blockDevices().Detach(ctx, d.dev)
+	}
+}
+
+// blockDevTarget is the path of the block device of the i-th evidence
+// item in the container.
+func blockDevTarget(i int) string {
+	if i == 0 {
+		return containerBlockDev
+	}
+	return containerBlockDev + strconv.Itoa(i)
+}
+
+// applyBlockDevices exposes devices read-only in spec, named by
+// EVIDENCE_BLOCK_DEV, indexed as the other evidence variables for jobs
+// with several evidence items.
+func applyBlockDevices(spec *ContainerSpec, job Job, devices []attachedDevice) {
+	indexed := len(job.ExtraEvidence) > 0
+	for _, d := range devices {
+		target := blockDevTarget(d.index)
+		spec.Devices = append(spec.Devices, Device{Source: d.dev.Path, Target: target, ReadOnly: true})
+		gid := strconv.Itoa(d.dev.GID)
+		if !containsString(spec.GroupAdd, gid) {
+			spec.GroupAdd = append(spec.GroupAdd, gid)
+		}
+		if d.index == 0 {
+			spec.Env[sandbox.EnvEvidenceBlockDev] = target
+		}
+		if indexed {
+			spec.Env[sandbox.IndexedEnv(sandbox.EnvEvidenceBlockDev, d.index)] = target
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDevices verifies that spec only exposes evidence block devices,
+// read-only.
+func checkDevices(spec ContainerSpec) error {
+	for _, d := range spec.Devices {
+		if !strings.HasPrefix(d.Target, containerBlockDev) {
+			return fmt.Errorf("%w: device %s is not an evidence device", ErrForbiddenMount, d.Target)
+		}
+		if !d.ReadOnly {
+			return fmt.Errorf("%w: device %s must be read-only", ErrForbiddenMount, d.Target)
+		}
+		if !strings.HasPrefix(d.Source, "/dev/loop") && !strings.HasPrefix(d.Source, "/dev/nbd") {
+			return fmt.Errorf("%w: %s is not a loop or network block device", ErrForbiddenMount, d.Source)
+		}
+	}
+	return nil
+}
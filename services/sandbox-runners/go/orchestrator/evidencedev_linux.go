@@ -0,0 +1,93 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+	"golang.org/x/sys/unix"
+)
+
+// Attach implements BlockDeviceAttacher.
+func (l *LoopDevices) Attach(ctx context.Context, ev Evidence) (BlockDevice, error) {
+	raw, mnt := ev.Path, ""
+	if ev.Type == sandbox.EvidenceTypeEWF {
+		var err error
+		if mnt, err = os.MkdirTemp(l.TempDir, "datamortem-ewf-"); err != nil {
+			return BlockDevice{}, err
+		}
+		if err := runTool(ctx, "ewfmount", ev.Path, mnt); err != nil {
+			os.Remove(mnt)
+			return BlockDevice{}, err
+		}
+		raw = filepath.Join(mnt, "ewf1")
+	}
+	out, err := exec.CommandContext(ctx, "losetup", "--find", "--show", "--read-only", raw).Output()
+	if err != nil {
+		l.unmount(mnt)
+		return BlockDevice{}, fmt.Errorf("losetup: %w", toolError(err))
+	}
+	dev := BlockDevice{Path: strings.TrimSpace(string(out))}
+	var st unix.Stat_t
+	if err := unix.Stat(dev.Path, &st); err != nil {
+		exec.Command("losetup", "--detach", dev.Path).Run()
+		l.unmount(mnt)
+		return BlockDevice{}, err
+	}
+	dev.GID = int(st.Gid)
+	if mnt != "" {
+		l.mu.Lock()
+		if l.ewf == nil {
+			l.ewf = map[string]string{}
+		}
+		l.ewf[dev.Path] = mnt
+		l.mu.Unlock()
+	}
+	return dev, nil
+}
+
+// Detach implements BlockDeviceAttacher.
+func (l *LoopDevices) Detach(ctx context.Context, dev BlockDevice) error {
+	err := runTool(ctx, "losetup", "--detach", dev.Path)
+	l.mu.Lock()
+	mnt := l.ewf[dev.Path]
+	delete(l.ewf, dev.Path)
+	l.mu.Unlock()
+	l.unmount(mnt)
+	return err
+}
+
+// unmount releases an ewfmount mount point, if any.
+func (l *LoopDevices) unmount(mnt string) {
+	if mnt == "" {
+		return
+	}
+	if exec.Command("fusermount", "-u", mnt).Run() != nil {
+		exec.Command("umount", mnt).Run()
+	}
+	os.Remove(mnt)
+}
+
+// runTool runs a host tool, with its stderr in the error.
+func runTool(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// toolError adds the stderr of a failed exec.Cmd.Output to err.
+func toolError(err error) error {
+	if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exit.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package orchestrator
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoLoopDevices is returned where loop devices do not exist.
+var errNoLoopDevices = errors.New("orchestrator: block devices need a Linux host")
+
+// Attach implements BlockDeviceAttacher.
+func (l *LoopDevices) Attach(ctx context.Context, ev Evidence) (BlockDevice, error) {
+	return BlockDevice{}, errNoLoopDevices
+}
+
+// Detach implements BlockDeviceAttacher.
+func (l *LoopDevices) Detach(ctx context.Context, dev BlockDevice) error {
+	return errNoLoopDevices
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// fakeDevices attaches every disk image as the next /dev/loopN.
+type fakeDevices struct {
+	attached, detached []string
+	err                error
+}
+
+func (f *fakeDevices) Attach(ctx context.Context, ev Evidence) (BlockDevice, error) {
+	if f.err != nil {
+		return BlockDevice{}, f.err
+	}
+	f.attached = append(f.attached, ev.UID)
+	return BlockDevice{Path: "/dev/loop" + string(rune('0'+len(f.attached))), GID: 6}, nil
+}
+
+func (f *fakeDevices) Detach(ctx context.Context, dev BlockDevice) error {
+	f.detached = append(f.detached, dev.Path)
+	return nil
+}
+
+func blockDeviceJob(t *testing.T) Job {
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.EvidenceBlockDevice = true
+	job.Config = &cfg
+	job.Evidence.Type = sandbox.EvidenceTypeDiskImage
+	job.ExtraEvidence = []Evidence{
+		{UID: "ev-2", Path: "/lake/case-1/ev-2/disk.E01", Type: sandbox.EvidenceTypeEWF},
+		{UID: "ev-3", Path: "/lake/case-1/ev-3/capture.pcap", Type: sandbox.EvidenceTypePCAP},
+	}
+	return job
+}
+
+func TestRunExposesBlockDevices(t *testing.T) {
+	rt := &fakeRuntime{}
+	devs := &fakeDevices{}
+	r := NewRunner(rt)
+	r.BlockDevices = devs
+
+	res, err := r.Run(context.Background(), blockDeviceJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	want := []Device{
+		{Source: "/dev/loop1", Target: "/dev/evidence", ReadOnly: true},
+		{Source: "/dev/loop2", Target: "/dev/evidence1", ReadOnly: true},
+	}
+	if len(spec.Devices) != 2 || spec.Devices[0] != want[0] || spec.Devices[1] != want[1] {
+		t.Errorf("devices = %+v, want %+v", spec.Devices, want)
+	}
+	if len(spec.GroupAdd) != 1 || spec.GroupAdd[0] != "6" {
+		t.Errorf("group-add = %v, want the devices' group", spec.GroupAdd)
+	}
+	env := map[string]string{
+		sandbox.EnvEvidenceBlockDev:                        "/dev/evidence",
+		sandbox.IndexedEnv(sandbox.EnvEvidenceBlockDev, 0): "/dev/evidence",
+		sandbox.IndexedEnv(sandbox.EnvEvidenceBlockDev, 1): "/dev/evidence1",
+		sandbox.IndexedEnv(sandbox.EnvEvidenceBlockDev, 2): "",
+	}
+	for k, v := range env {
+		if spec.Env[k] != v {
+			t.Errorf("%s = %q, want %q", k, spec.Env[k], v)
+		}
+	}
+	modes := map[string]EvidenceMode{"ev-1": EvidenceModeBlock, "ev-2": EvidenceModeBlock, "ev-3": EvidenceModeFile}
+	for uid, m := range modes {
+		if res.EvidenceModes[uid] != m {
+			t.Errorf("mode of %s = %q, want %q", uid, res.EvidenceModes[uid], m)
+		}
+	}
+	if len(devs.detached) != 2 {
+		t.Errorf("detached %v, want both devices", devs.detached)
+	}
+}
+
+func TestRunFallsBackToEvidenceFiles(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.BlockDevices = &fakeDevices{err: errors.New("losetup: permission denied")}
+
+	res, err := r.Run(context.Background(), blockDeviceJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	if len(spec.Devices) != 0 || spec.Env[sandbox.EnvEvidenceBlockDev] != "" {
+		t.Errorf("devices = %+v, env = %q", spec.Devices, spec.Env[sandbox.EnvEvidenceBlockDev])
+	}
+	if res.EvidenceModes["ev-1"] != EvidenceModeFile || !strings.Contains(res.BlockDeviceError, "permission denied") {
+		t.Errorf("modes = %v, error = %q", res.EvidenceModes, res.BlockDeviceError)
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	for _, d := range []Device{
+		{Source: "/dev/sda", Target: "/dev/evidence", ReadOnly: true},
+		{Source: "/dev/loop0", Target: "/dev/evidence"},
+		{Source: "/dev/loop0", Target: "/dev/mem", ReadOnly: true},
+	} {
+		if err := checkMounts(ContainerSpec{Devices: []Device{d}}); !errors.Is(err, ErrForbiddenMount) {
+			t.Errorf("checkMounts(%+v) = %v, want ErrForbiddenMount", d, err)
+		}
+	}
+	if err := checkMounts(ContainerSpec{Devices: []Device{{Source: "/dev/loop0", Target: "/dev/evidence", ReadOnly: true}}}); err != nil {
+		t.Error(err)
+	}
+}
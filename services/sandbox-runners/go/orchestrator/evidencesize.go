@@ -0,0 +1,84 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Errors returned for a job whose evidence is outside the size bounds its
+// script declares; the job is skipped, not run.
+var (
+	ErrEvidenceTooLarge = errors.New("orchestrator: evidence too large for this module")
+	ErrEvidenceTooSmall = errors.New("orchestrator: evidence too small for this module")
+)
+
+// EvidenceSizeLimits are the evidence sizes a script accepts, declared by
+// "min_evidence_bytes" and "max_evidence_bytes" in RequirementsFile. A
+// zero bound is not checked.
+type EvidenceSizeLimits struct {
+	Min, Max int64
+}
+
+// ReadEvidenceSizeLimits returns the evidence size bounds declared in the
+// RequirementsFile of workspace, if any.
+func ReadEvidenceSizeLimits(workspace string) (EvidenceSizeLimits, error) {
+	m, err := readManifest(filepath.Join(workspace, RequirementsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return EvidenceSizeLimits{}, nil
+	}
+	if err != nil {
+		return EvidenceSizeLimits{}, fmt.Errorf("orchestrator: %s: %w", RequirementsFile, err)
+	}
+	l := EvidenceSizeLimits{Min: m.MinEvidenceBytes, Max: m.MaxEvidenceBytes}
+	if l.Min < 0 || l.Max < 0 || l.Max > 0 && l.Min > l.Max {
+		return EvidenceSizeLimits{}, fmt.Errorf("orchestrator: %s: invalid evidence size bounds %d..%d", RequirementsFile, l.Min, l.Max)
+	}
+	return l, nil
+}
+
+// evidenceSize returns the size of ev as the script sees it: its byte
+// range, or the file at Path as stored, compressed or not. It reports
+// false for an item whose size cannot be told, which is not checked.
+func evidenceSize(ev Evidence) (int64, bool) {
+	if ev.Length > 0 {
+		return ev.Length, true
+	}
+	if ev.Path == "" {
+		return 0, false
+	}
+	info, err := os.Stat(ev.Path)
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	size := info.Size()
+	if !ev.compressed() {
+		size = max(size-ev.Offset, 0)
+	}
+	return size, true
+}
+
+// check fails with ErrEvidenceTooLarge or ErrEvidenceTooSmall, naming the
+// bounds, unless ev is within l.
+func (l EvidenceSizeLimits) check(ev Evidence) error {
+	if l.Min == 0 && l.Max == 0 {
+		return nil
+	}
+	size, ok := evidenceSize(ev)
+	switch {
+	case !ok:
+		return nil
+	case l.Max > 0 && size > l.Max:
+		return fmt.Errorf("%w: evidence %s is %d bytes, the module accepts at most %d (max_evidence_bytes)", ErrEvidenceTooLarge, ev.UID, size, l.Max)
+	case size < l.Min:
+		return fmt.Errorf("%w: evidence %s is %d bytes, the module needs at least %d (min_evidence_bytes)", ErrEvidenceTooSmall, ev.UID, size, l.Min)
+	}
+	return nil
+}
+
+// evidenceSkipped reports whether err skips a job for its evidence, whose
+// type or size the script does not accept.
+func evidenceSkipped(err error) bool {
+	return errors.Is(err, ErrEvidenceTypeNotAccepted) || errors.Is(err, ErrEvidenceTooLarge) || errors.Is(err, ErrEvidenceTooSmall)
+}
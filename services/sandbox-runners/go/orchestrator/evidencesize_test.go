@@ -0,0 +1,79 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSkipsEvidenceOutsideSizeLimits(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, RequirementsFile), []byte(`{"min_evidence_bytes": 16, "max_evidence_bytes": 1024}`), 0o644)
+	job.Evidence.Path = filepath.Join(t.TempDir(), "mem.raw")
+	job.Evidence.SHA256 = ""
+
+	os.WriteFile(job.Evidence.Path, make([]byte, 2048), 0o644)
+	_, err := r.Run(context.Background(), job)
+	if !errors.Is(err, ErrEvidenceTooLarge) || !strings.Contains(err.Error(), "2048 bytes") || !strings.Contains(err.Error(), "at most 1024") {
+		t.Errorf("err = %v, want ErrEvidenceTooLarge with the size and the bound", err)
+	}
+	os.WriteFile(job.Evidence.Path, make([]byte, 8), 0o644)
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrEvidenceTooSmall) || !strings.Contains(err.Error(), "at least 16") {
+		t.Errorf("err = %v, want ErrEvidenceTooSmall with the bound", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Fatalf("%d containers created", len(rt.specs))
+	}
+
+	// The byte range is the evidence the script sees.
+	os.WriteFile(job.Evidence.Path, make([]byte, 4096), 0o644)
+	job.Evidence.Offset, job.Evidence.Length = 512, 512
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatalf("range within bounds: %v", err)
+	}
+}
+
+func TestReadEvidenceSizeLimits(t *testing.T) {
+	if l, err := ReadEvidenceSizeLimits(t.TempDir()); err != nil || l != (EvidenceSizeLimits{}) {
+		t.Errorf("no manifest: %+v, %v", l, err)
+	}
+	ws := writeWorkspace(t, map[string]string{RequirementsFile: `{"min_evidence_bytes": 2048, "max_evidence_bytes": 1024}`})
+	if _, err := ReadEvidenceSizeLimits(ws); err == nil {
+		t.Error("minimum above maximum accepted")
+	}
+}
+
+func TestFanOutSkipsEvidenceOutsideSizeLimits(t *testing.T) {
+	runner := &findingRunner{}
+	w, _ := NewWorkerPool(runner, WorkerPoolConfig{MaxConcurrent: 1})
+	defer w.Close()
+	dir := t.TempDir()
+	small, large := filepath.Join(dir, "small.reg"), filepath.Join(dir, "large.raw")
+	os.WriteFile(small, make([]byte, 100), 0o644)
+	os.WriteFile(large, make([]byte, 5000), 0o644)
+	req := fanOutRequest(t, Evidence{UID: "ev-1", Path: small}, Evidence{UID: "ev-2", Path: large})
+	os.WriteFile(filepath.Join(req.Job.Workspace, RequirementsFile), []byte(`{"max_evidence_bytes": 4096}`), 0o644)
+
+	f, err := StartFanOut(context.Background(), w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := f.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Children[0].Status != FanOutSucceeded || res.Children[1].Status != FanOutSkipped {
+		t.Errorf("children = %+v", res.Children)
+	}
+	if err := res.Children[1].Err; !errors.Is(err, ErrEvidenceTooLarge) || !strings.Contains(err.Error(), "at most 4096") {
+		t.Errorf("skipped child err = %v", err)
+	}
+	if len(runner.jobs) != 1 {
+		t.Errorf("ran %d jobs, want 1", len(runner.jobs))
+	}
+}
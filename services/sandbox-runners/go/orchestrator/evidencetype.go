@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// acceptsDirective starts the accepted types line of a script's header
+// comment, e.g. `// sandbox:accepts memory_dump ewf`.
+const acceptsDirective = "sandbox:accepts"
+
+// ErrEvidenceTypeNotAccepted is returned for a job whose evidence is of a
+// type its script does not accept; the job is skipped, not run.
+var ErrEvidenceTypeNotAccepted = errors.New("orchestrator: the script does not accept the evidence type")
+
+// DetectEvidenceType returns the type of ev, one of the sandbox
+// EvidenceType constants, from the decompressed content at the start of
+// its byte range, or "" when the format is not recognized. The platform
+// calls it at ingestion to record Evidence.Type.
+func DetectEvidenceType(ev Evidence) (string, error) {
+	f, err := os.Open(ev.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r, err := sandbox.Decompress(f, ev.Compression)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.CopyN(io.Discard, r, ev.Offset); err != nil {
+		return "", fmt.Errorf("evidence %s: range offset: %w", ev.UID, err)
+	}
+	header := make([]byte, sandbox.EvidenceTypeHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	// The extension of the stored file, without that of its compression:
+	// a compressed disk.E01, stored without a .gz suffix, keeps its own.
+	name := ev.Path
+	if ev.compressed() {
+		name = trimCompressedExt(name)
+	}
+	return sandbox.DetectEvidenceType(header[:n], name), nil
+}
+
+// ReadAcceptedTypes returns the evidence types the script in workspace
+// accepts, declared by the "accepts" list of RequirementsFile or a
+// `sandbox:accepts` line in the comments heading a file at the workspace
+// root. A script that declares none accepts any evidence.
+func ReadAcceptedTypes(workspace string) ([]string, error) {
+	entries, err := os.ReadDir(workspace)
+	if err != nil {
+		return nil, err
+	}
+	var types []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(workspace, e.Name())
+		var declared []string
+		if e.Name() == RequirementsFile {
+			m, merr := readManifest(path)
+			declared, err = m.Accepts, merr
+		} else {
+			declared, err = headerDirective(path, acceptsDirective)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("orchestrator: %s: %w", e.Name(), err)
+		}
+		for _, t := range declared {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" && !slices.Contains(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+	return types, nil
+}
+
+// typeEvidence returns job with the types of its evidence items, detected
+// when ingestion did not record them, and checks that its script accepts
+// the type and size of the primary item. An item that cannot be read is
+// left untyped, for the script to report; so is one of unknown format,
+// which any script runs on, as it cannot be told apart.
+func typeEvidence(job Job) (Job, error) {
+	all := job.allEvidence()
+	for i, ev := range all {
+		if ev.Type == "" && ev.Path != "" && !ev.encrypted() {
+			all[i].Type, _ = DetectEvidenceType(ev)
+		}
+	}
+	job.Evidence = all[0]
+	if len(job.ExtraEvidence) > 0 {
+		job.ExtraEvidence = all[1:]
+	}
+	accepted, err := ReadAcceptedTypes(job.Workspace)
+	if err != nil {
+		return Job{}, err
+	}
+	if typ := strings.ToLower(job.Evidence.Type); typ != "" && len(accepted) > 0 && !slices.Contains(accepted, typ) {
+		return Job{}, fmt.Errorf("%w: evidence %s is %s, the script accepts %s", ErrEvidenceTypeNotAccepted, job.Evidence.UID, typ, strings.Join(accepted, ", "))
+	}
+	limits, err := ReadEvidenceSizeLimits(job.Workspace)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := limits.check(job.Evidence); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+const pcapHeader = "\xd4\xc3\xb2\xa1\x02\x00\x04\x00"
+
+func TestDetectEvidenceType(t *testing.T) {
+	ev := gzipEvidence(t, pcapHeader)
+	if typ, err := DetectEvidenceType(ev); err != nil || typ != sandbox.EvidenceTypePCAP {
+		t.Errorf("compressed pcap: type = %q, %v", typ, err)
+	}
+
+	// A hive carried at an offset of a larger file.
+	ev = gzipEvidence(t, "padding!regf\x00\x00\x00\x00")
+	ev.Offset = 8
+	if typ, err := DetectEvidenceType(ev); err != nil || typ != sandbox.EvidenceTypeRegistryHive {
+		t.Errorf("ranged hive: type = %q, %v", typ, err)
+	}
+
+	// Only the stored name's compression extension is ignored.
+	path := filepath.Join(t.TempDir(), "host.vmem.gz")
+	os.Rename(ev.Path, path)
+	ev.Path, ev.Offset = path, 0
+	if typ, err := DetectEvidenceType(ev); err != nil || typ != sandbox.EvidenceTypeMemory {
+		t.Errorf("compressed raw memory: type = %q, %v", typ, err)
+	}
+	// A compressed file stored without a compression extension keeps its own.
+	path = filepath.Join(t.TempDir(), "host.vmem")
+	os.Rename(ev.Path, path)
+	ev.Path = path
+	if typ, err := DetectEvidenceType(ev); err != nil || typ != sandbox.EvidenceTypeMemory {
+		t.Errorf("compressed raw memory without .gz: type = %q, %v", typ, err)
+	}
+}
+
+func TestReadAcceptedTypes(t *testing.T) {
+	ws := writeWorkspace(t, map[string]string{
+		RequirementsFile: `{"accepts": ["memory_dump"]}`,
+		"main.go":        "// sandbox:accepts EWF memory_dump\npackage main\n",
+	})
+	got, err := ReadAcceptedTypes(ws)
+	if want := []string{"ewf", "memory_dump"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("accepted = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestRunSkipsUnacceptedEvidenceType(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.Evidence.Path = filepath.Join(t.TempDir(), "capture.bin")
+	os.WriteFile(job.Evidence.Path, []byte(pcapHeader), 0o644)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:accepts memory_dump\npackage main\n"), 0o644)
+
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrEvidenceTypeNotAccepted) {
+		t.Errorf("err = %v, want ErrEvidenceTypeNotAccepted", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers created", len(rt.specs))
+	}
+
+	// The type recorded at ingestion is trusted; an unknown one runs.
+	for _, typ := range []string{sandbox.EvidenceTypeMemory, ""} {
+		job.Evidence.Type = typ
+		job.Evidence.Path = filepath.Join(t.TempDir(), "unknown.bin")
+		os.WriteFile(job.Evidence.Path, []byte("??"), 0o644)
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatalf("type %q: %v", typ, err)
+		}
+		if got := rt.lastSpec().Env[sandbox.EnvEvidenceType]; got != typ {
+			t.Errorf("%s = %q, want %q", sandbox.EnvEvidenceType, got, typ)
+		}
+	}
+}
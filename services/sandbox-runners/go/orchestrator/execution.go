@@ -0,0 +1,862 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Execution is a job whose container has been started. Wait must be called
+// exactly once to collect the result and release the container.
+type Execution struct {
+	runner *Runner
+	job    Job
+	cfg    ExecConfig
+	id     string
+	// ctx is the caller's context; abort cancels it to cancel the job.
+	ctx   context.Context
+	abort context.CancelFunc
+	// runCtx carries ExecConfig.RunTimeout, counted from the start of
+	// the container.
+	runCtx context.Context
+	cancel context.CancelFunc
+	// started is when the container was started.
+	started time.Time
+	// exited is closed once the container has stopped.
+	exited   chan struct{}
+	exitOnce sync.Once
+	// proxy is the egress proxy of a NetworkAllowlist job.
+	proxy *egressProxy
+	// workDir is the job's working directory, mounted as /workspace.
+	workDir string
+	// evidenceDir holds the evidence decompressed for the job, if any,
+	// and cachedEvidence the entries of Runner.EvidenceCache it holds.
+	// decryptedDir holds the evidence decrypted for the job, downloadDir
+	// that downloaded from object storage.
+	evidenceDir    string
+	cachedEvidence []*evidenceEntry
+	decryptedDir   string
+	downloadDir    string
+	// contextDir holds the job's case context file, secretsDir its
+	// secret files.
+	contextDir string
+	secretsDir string
+	// devices are the evidence block devices of the job, evidenceModes
+	// how each evidence item is exposed and deviceErr why some fell back
+	// to file mode.
+	devices       []attachedDevice
+	evidenceModes map[string]EvidenceMode
+	deviceErr     string
+	// overlays are the copy-on-write views of its evidence, with
+	// ExecConfig.EvidenceOverlay.
+	overlays []EvidenceOverlay
+	// attempts is the number of Start calls it took, when retried.
+	attempts int
+	// fetch serves the job's sandbox.FetchEvidence requests, if allowed.
+	fetch *fetchServer
+	// query serves the job's sandbox.QueryFindings requests, if allowed.
+	query *queryServer
+	// stream serves the job's evidence streamed from object storage, if
+	// any.
+	stream *streamServer
+	// shared is the shared directory of the job's case when it started,
+	// if it has one.
+	shared *SharedUsage
+	// image is the runner image as configured, imageDigest its ID and
+	// goVersion its Go toolchain, for a Go job.
+	image, imageDigest, goVersion string
+	// binarySHA256 is the digest of the compiled script the job runs, if
+	// any.
+	binarySHA256 string
+	// build is the build configuration of a Go job that customises it.
+	build *BuildConfig
+	// effective is the EffectiveConfig of the job, under
+	// ExecConfig.Explain.
+	effective *EffectiveConfig
+	// failedBuild is the log of the BuildCache build that failed, if any,
+	// and buildEnv the toolchain environment of the job, for
+	// JobResult.BuildLog.
+	failedBuild *BuildLog
+	buildEnv    map[string]string
+	// signer is the ID of the key that signed the script, if verified.
+	signer string
+	// scriptSHA256 hashes the staged workspace, for JobResult.ScriptSHA256.
+	scriptSHA256 string
+	// vulns are the vulnerabilities Runner.VulnCheck found, if any.
+	vulns []Vulnerability
+	// watchdog tracks the job's activity for ExecConfig.IdleTimeout.
+	watchdog *watchdog
+	// records stops the job over a record cap, with
+	// ExecConfig.KillOverRecordLimit.
+	records *recordWatch
+	// heartbeat gives the job up once its worker stops beating, with
+	// ExecConfig.HeartbeatTimeout.
+	heartbeat *heartbeatMonitor
+	// session is the input of an interactive session, nil for a batch
+	// job.
+	session *sessionInput
+	// span is the job span the job runs under, with Runner.Tracer, and
+	// execSpan that of its container running.
+	span, execSpan *traceSpan
+
+	mu         sync.Mutex
+	streamDone chan struct{}
+}
+
+// Start creates and starts the container for job. Cancelling ctx cancels
+// the job: see Execution.Cancel. The job runs in a copy of its workspace,
+// removed by Wait.
+func (r *Runner) Start(ctx context.Context, job Job) (*Execution, error) {
+	return r.start(ctx, job, nil)
+}
+
+// start starts job, or the interactive session sess against its evidence
+// when not nil.
+func (r *Runner) start(ctx context.Context, job Job, sess *sessionInput) (exec *Execution, err error) {
+	if job, err = r.resolveFixture(job); err != nil {
+		return nil, err
+	}
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+	if job, err = resolveParams(job); err != nil {
+		return nil, err
+	}
+	if err := r.validateParams(job.Params); err != nil {
+		return nil, err
+	}
+	if err := r.validateCallback(job.Callback); err != nil {
+		return nil, err
+	}
+	if err := r.validateBuild(job); err != nil {
+		return nil, err
+	}
+	if err := r.checkModuleAllowlist(job); err != nil {
+		return nil, err
+	}
+	if job, err = typeEvidence(job); err != nil {
+		return nil, err
+	}
+	job.RunTime = runTime(job)
+	job.Seed = jobSeed(job)
+	cfg, err := r.jobConfig(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkRuntime(ctx, cfg.Runtime); err != nil {
+		return nil, err
+	}
+
+	workDir, signer, err := r.stageWorkspace(job)
+	if err != nil {
+		return nil, err
+	}
+	// The job's own writes to the workspace, or the source tree changing
+	// during the run, do not change what the audit log records.
+	scriptSHA256, err := workspaceKey(workDir, "")
+	if err != nil {
+		r.removeDir(workDir)
+		return nil, fmt.Errorf("stage workspace: %w", err)
+	}
+	staged := job
+	staged.Workspace = workDir
+	evidenceDir, decryptedDir, downloadDir, contextDir, secretsDir := "", "", "", "", ""
+	var devices []attachedDevice
+	var cached []*evidenceEntry
+	var overlays []EvidenceOverlay
+	cache := r.evidenceCache(cfg)
+	// phase is the span of the step of the job being prepared.
+	span := spanFrom(ctx)
+	var phase *traceSpan
+	enter := func(name string) {
+		phase.end(nil)
+		phase = span.child(name, time.Now())
+	}
+	defer func() {
+		if exec == nil {
+			phase.end(err)
+			r.unmountOverlays(context.WithoutCancel(ctx), overlays)
+			r.detachEvidence(context.WithoutCancel(ctx), devices)
+			for _, e := range cached {
+				cache.release(e)
+			}
+			r.removeDir(workDir)
+			if evidenceDir != "" {
+				r.removeDir(evidenceDir)
+			}
+			if decryptedDir != "" {
+				r.removeDir(decryptedDir)
+			}
+			if downloadDir != "" {
+				r.removeDir(downloadDir)
+			}
+			if contextDir != "" {
+				r.removeDir(contextDir)
+			}
+			if secretsDir != "" {
+				r.removeDir(secretsDir)
+			}
+		}
+	}()
+	enter(spanEvidence)
+	if cfg.PreflightEvidence {
+		if err := preflightEvidence(staged, cfg); err != nil {
+			return nil, err
+		}
+	}
+	var object *ObjectInfo
+	if staged, downloadDir, object, err = r.resolveObjects(ctx, staged, cfg); err != nil {
+		return nil, err
+	}
+	if staged, decryptedDir, err = r.decryptEvidence(staged); err != nil {
+		return nil, err
+	}
+	if cfg.DecompressEvidence {
+		if staged, evidenceDir, cached, err = r.decompressEvidence(staged, cache); err != nil {
+			return nil, err
+		}
+	}
+	var modes map[string]EvidenceMode
+	deviceErr := ""
+	if cfg.EvidenceBlockDevice {
+		var err error
+		if devices, modes, err = r.attachEvidence(ctx, staged, cache); err != nil {
+			deviceErr = err.Error()
+		}
+	}
+	if cfg.EvidenceOverlay {
+		if staged, overlays, err = r.overlayEvidence(ctx, staged); err != nil {
+			return nil, err
+		}
+	}
+	phase.end(nil)
+	phase = nil
+	if contextDir, err = r.stageContext(staged); err != nil {
+		return nil, fmt.Errorf("stage case context: %w", err)
+	}
+	if secretsDir, err = r.stageSecrets(staged); err != nil {
+		return nil, fmt.Errorf("stage secrets: %w", err)
+	}
+	if err := r.restoreCheckpoint(job, job.OutputDir); err != nil {
+		return nil, fmt.Errorf("restore checkpoint: %w", err)
+	}
+	sharedDir, shared, err := r.prepareShared(job, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := r.containerSpec(staged, cfg)
+	if err != nil {
+		return nil, err
+	}
+	applyContext(&spec, contextDir)
+	applyBlockDevices(&spec, staged, devices)
+	applyShared(&spec, sharedDir, shared)
+	image := spec.Image
+	enter(spanImage)
+	info, err := r.resolveImage(ctx, image, r.jobImageDigest(job, cfg))
+	if err != nil {
+		return nil, err
+	}
+	spec.Image = info.ID
+	var flavor Flavor
+	if job.Flavor != "" {
+		if flavor, err = r.flavor(job.Flavor); err != nil {
+			return nil, err
+		}
+		if image, spec.Image, err = r.flavorImage(ctx, flavor, image, spec.Image); err != nil {
+			return nil, err
+		}
+	}
+	phase.end(nil)
+	phase = nil
+	ctx, abort := context.WithCancel(ctx)
+	// Vendoring, scanning and compiling a script count against the
+	// build timeout, not the run one.
+	buildCtx, cancelBuild := context.WithTimeout(ctx, cfg.buildTimeout())
+	cancel := func() {
+		cancelBuild()
+		abort()
+	}
+	if sess == nil {
+		enter(spanBuild)
+	}
+	if cfg.Offline && languageKey(job.Language) == LanguageGo && sess == nil {
+		if err := r.prepareOffline(buildCtx, staged, spec); err != nil {
+			cancel()
+			return nil, buildTimeout(ctx, buildCtx, cfg, err)
+		}
+	}
+	var vulns []Vulnerability
+	if sess == nil {
+		if vulns, err = r.scanVulnerabilities(buildCtx, staged, cfg, spec); err != nil {
+			cancel()
+			return nil, buildTimeout(ctx, buildCtx, cfg, err)
+		}
+	}
+	var fetch *fetchServer
+	if cfg.AllowEvidenceFetch {
+		if r.EvidenceCatalog == nil {
+			cancel()
+			return nil, errors.New("orchestrator: evidence fetching requires Runner.EvidenceCatalog")
+		}
+		if fetch, err = r.startFetchServer(ctx, staged); err != nil {
+			cancel()
+			return nil, err
+		}
+		defer func() {
+			if exec == nil {
+				fetch.Close()
+			}
+		}()
+		fetch.apply(&spec)
+	}
+	var query *queryServer
+	if cfg.AllowFindingQuery {
+		if r.FindingSource == nil {
+			cancel()
+			return nil, errors.New("orchestrator: finding queries require Runner.FindingSource")
+		}
+		if query, err = r.startQueryServer(ctx, staged); err != nil {
+			cancel()
+			return nil, err
+		}
+		defer func() {
+			if exec == nil {
+				query.Close()
+			}
+		}()
+		query.apply(&spec)
+	}
+	var stream *streamServer
+	if object != nil {
+		if stream, err = r.startStreamServer(ctx, staged.Evidence, *object); err != nil {
+			cancel()
+			return nil, err
+		}
+		defer func() {
+			if exec == nil {
+				stream.Close()
+			}
+		}()
+		stream.apply(&spec)
+	}
+	proxy, err := r.setupNetwork(&spec, cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	buildEnv := toolchainEnv(spec.Env)
+	var binarySHA256 string
+	var failedBuild *BuildLog
+	var build *BuildConfig
+	var buildCmd []string
+	if sess != nil {
+		// A session runs what the analyst types, not the script.
+		spec.Cmd, spec.Interactive = sess.cmd, true
+	} else {
+		var bin string
+		var ok bool
+		bin, binarySHA256, failedBuild, ok = r.cachedBuild(buildCtx, staged, spec)
+		buildCmd = spec.Cmd
+		if ok {
+			// cachedBuild only succeeds for a known language.
+			p, _ := profile(job.Language)
+			buildCmd = withBuildTags(p.Build, job.BuildTags)
+			useCachedBuild(&spec, p, bin)
+		} else if languageKey(job.Language) == LanguageGo {
+			spec.Cmd = boundGoCompile(spec.Cmd, cfg.buildTimeout())
+			// The entrypoint waits for the script orphaned by `go run`.
+			if !cfg.Entrypoint {
+				spec.Cmd = wrapGoRun(spec.Cmd)
+			}
+		}
+		build = buildConfig(job, buildCmd)
+		if build != nil {
+			build.FlavorModules = flavor.Modules
+		}
+	}
+	// A build that timed out falls back to the run command like one
+	// that failed; the job is failed instead.
+	if err := buildTimeout(ctx, buildCtx, cfg, nil); err != nil {
+		cancel()
+		proxy.Close()
+		return nil, err
+	}
+	phase.end(nil)
+	phase = nil
+	if err := ctx.Err(); err != nil {
+		cancel()
+		proxy.Close()
+		return nil, err
+	}
+	var heartbeat *heartbeatMonitor
+	if cfg.Entrypoint && sess == nil {
+		spec.Cmd = wrapEntrypoint(spec.Cmd)
+		if heartbeat, err = r.stageHeartbeat(&spec, cfg); err != nil {
+			cancel()
+			proxy.Close()
+			return nil, err
+		}
+		defer func() {
+			if exec == nil && heartbeat != nil {
+				r.removeDir(heartbeat.dir)
+			}
+		}()
+	}
+	if cfg.ResourceMetrics {
+		spec.Cmd = wrapMetrics(spec.Cmd)
+	}
+	// Only the job's own container gets the secrets, not the vendoring
+	// and build ones.
+	applySecrets(&spec, secretsDir)
+	if cfg.OutputQuotaBytes > 0 {
+		applyOutputQuota(&spec, cfg.OutputQuotaBytes)
+	}
+	effective := r.explainConfig(job, cfg, image, spec.Image, spec.Env, buildCmd)
+	id, err := r.createContainer(ctx, spec)
+	if err != nil {
+		cancel()
+		proxy.Close()
+		if errors.Is(err, ErrForbiddenMount) {
+			return nil, err
+		}
+		return nil, &InfraError{Op: "create container", Err: err}
+	}
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		cancel()
+		r.removeContainer(context.WithoutCancel(ctx), id)
+		proxy.Close()
+		return nil, &InfraError{Op: "start container", Err: err}
+	}
+	started := time.Now()
+	runCtx, cancelRun := context.WithTimeout(ctx, cfg.runTimeout())
+	e := &Execution{
+		started:        started,
+		runner:         r,
+		job:            job,
+		cfg:            cfg,
+		id:             id,
+		ctx:            ctx,
+		abort:          abort,
+		runCtx:         runCtx,
+		cancel:         func() { cancelRun(); cancel() },
+		exited:         make(chan struct{}),
+		proxy:          proxy,
+		workDir:        workDir,
+		evidenceDir:    evidenceDir,
+		cachedEvidence: cached,
+		decryptedDir:   decryptedDir,
+		downloadDir:    downloadDir,
+		contextDir:     contextDir,
+		secretsDir:     secretsDir,
+		devices:        devices,
+		evidenceModes:  modes,
+		deviceErr:      deviceErr,
+		overlays:       overlays,
+		fetch:          fetch,
+		query:          query,
+		stream:         stream,
+		shared:         shared,
+		image:          image,
+		imageDigest:    spec.Image,
+		goVersion:      goVersion(job, info),
+		binarySHA256:   binarySHA256,
+		build:          build,
+		effective:      effective,
+		failedBuild:    failedBuild,
+		buildEnv:       buildEnv,
+		signer:         signer,
+		scriptSHA256:   scriptSHA256,
+		vulns:          vulns,
+		watchdog:       newWatchdog(cfg, job.OutputDir),
+		records:        newRecordWatch(cfg, job.OutputDir),
+		heartbeat:      heartbeat,
+		session:        sess,
+		span:           span,
+		execSpan:       span.child(spanExecution, started),
+	}
+	e.watch(cancelRun)
+	e.records.start(e.runCtx, e.exited, cancelRun)
+	e.heartbeat.start(e.runCtx, e.exited, started, cancelRun)
+	if sess != nil {
+		sess.attach(runCtx, r.Runtime, id)
+	}
+	return e, nil
+}
+
+// setupNetwork applies cfg's network mode to spec. In NetworkAllowlist
+// mode it starts the job's egress proxy, which the caller must close.
+func (r *Runner) setupNetwork(spec *ContainerSpec, cfg ExecConfig) (*egressProxy, error) {
+	switch cfg.networkMode() {
+	case NetworkNone:
+		spec.Network = "none"
+		return nil, nil
+	case NetworkAllowlist:
+		if r.Egress == nil {
+			return nil, errors.New("orchestrator: host-allowlist networking requires Runner.Egress")
+		}
+		if len(cfg.AllowedHosts) == 0 {
+			return nil, errors.New("orchestrator: host-allowlist networking requires AllowedHosts")
+		}
+		if cfg.RatePerHost < 0 {
+			return nil, fmt.Errorf("orchestrator: negative RatePerHost %g", cfg.RatePerHost)
+		}
+		proxy, err := startEgressProxy(r.Egress.ListenHost, cfg.AllowedHosts, cfg.RatePerHost)
+		if err != nil {
+			return nil, err
+		}
+		host := r.Egress.AdvertiseHost
+		if host == "" {
+			host = r.Egress.ListenHost
+		}
+		url := "http://" + net.JoinHostPort(host, proxy.port())
+		spec.Network = r.Egress.Network
+		for _, k := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			spec.Env[k] = url
+		}
+		return proxy, nil
+	default:
+		return nil, fmt.Errorf("orchestrator: unknown network mode %q", cfg.NetworkMode)
+	}
+}
+
+// Stream follows the container's stdout and stderr line by line. The
+// channel is closed once the container exits or ctx is done; callers must
+// keep draining it until then. Stream may be called at most once.
+func (e *Execution) Stream(ctx context.Context) (<-chan LogLine, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.streamDone != nil {
+		return nil, errors.New("orchestrator: execution is already streamed")
+	}
+	ch := make(chan LogLine, 64)
+	done := make(chan struct{})
+	e.streamDone = done
+
+	go func() {
+		defer close(done)
+		defer close(ch)
+		limit := e.cfg.maxLogBytes()
+		scrub := newScrubber(e.job.Secrets)
+		stdout := newLineWriter(ctx, ch, StreamStdout, limit, scrub)
+		stderr := newLineWriter(ctx, ch, StreamStderr, limit, scrub)
+		e.runner.Runtime.Follow(ctx, e.id, stdout, stderr)
+		stdout.Flush()
+		stderr.Flush()
+	}()
+	return ch, nil
+}
+
+// releaseEvidence returns the job's evidence to Runner.EvidenceCache.
+func (e *Execution) releaseEvidence() {
+	for _, entry := range e.cachedEvidence {
+		entry.cache.release(entry)
+	}
+}
+
+// Cancel stops the job as if its context had been cancelled. The next
+// Wait stops the container gracefully and reports the job as cancelled.
+func (e *Execution) Cancel() {
+	e.abort()
+}
+
+// Wait blocks until the container exits, stopping it if the job times out
+// or is cancelled, then collects its logs and outputs and removes it.
+func (e *Execution) Wait() (*JobResult, error) {
+	r := e.runner
+	defer r.removeDir(e.workDir)
+	if e.contextDir != "" {
+		defer r.removeDir(e.contextDir)
+	}
+	if e.secretsDir != "" {
+		defer r.removeDir(e.secretsDir)
+	}
+	if e.heartbeat != nil {
+		defer r.removeDir(e.heartbeat.dir)
+	}
+	defer e.fetch.Close()
+	defer e.query.Close()
+	defer e.stream.Close()
+	if e.evidenceDir != "" {
+		defer r.removeDir(e.evidenceDir)
+	}
+	if e.decryptedDir != "" {
+		defer r.removeDir(e.decryptedDir)
+	}
+	if e.downloadDir != "" {
+		defer r.removeDir(e.downloadDir)
+	}
+	defer e.runner.detachEvidence(context.WithoutCancel(e.ctx), e.devices)
+	defer e.runner.unmountOverlays(context.WithoutCancel(e.ctx), e.overlays)
+	defer e.releaseEvidence()
+	defer e.cancel()
+	defer e.markExited()
+	defer e.proxy.Close()
+	defer e.session.end()
+	// Cleanup and collection must survive the job context being done.
+	bg := context.WithoutCancel(e.ctx)
+	// cleanup is bg, bounded once the worker is lost: its engine may
+	// never answer.
+	cleanup, endCleanup := bg, context.CancelFunc(func() {})
+	defer func() {
+		r.removeContainer(cleanup, e.id)
+		endCleanup()
+	}()
+
+	state, err := r.Runtime.Wait(e.runCtx, e.id)
+	timedOut, cancelled, idle, overLimit, lost := false, false, false, false, false
+	if err != nil {
+		switch {
+		case e.ctx.Err() != nil:
+			cancelled = true
+		case errors.Is(e.runCtx.Err(), context.DeadlineExceeded):
+			timedOut = true
+		case e.heartbeat.isLost():
+			lost = true
+			cleanup, endCleanup = e.heartbeat.cleanupContext(bg, e.cfg.gracePeriod())
+		case e.watchdog != nil && e.watchdog.killed.Load():
+			idle = true
+		case e.records != nil && e.records.killed.Load():
+			overLimit = true
+		default:
+			err = fmt.Errorf("wait container: %w", err)
+			e.execSpan.end(err)
+			return nil, err
+		}
+		if state, err = r.stop(cleanup, e.id, e.cfg.gracePeriod()); err != nil && lost {
+			// The job fails as lost whatever became of its container.
+			state, err = ContainerState{}, nil
+		}
+		if err != nil {
+			err = fmt.Errorf("stop container: %w", err)
+			e.execSpan.end(err)
+			return nil, err
+		}
+	}
+	e.markExited()
+	duration := time.Since(e.started)
+	e.execSpan.end(nil)
+	ingestion := e.span.child(spanIngestion, time.Now())
+
+	e.mu.Lock()
+	streamDone := e.streamDone
+	e.mu.Unlock()
+	if streamDone != nil {
+		<-streamDone
+	}
+
+	stdout, stderr := newCappedLog(e.cfg.maxLogBytes()), newCappedLog(e.cfg.maxLogBytes())
+	if err := r.Runtime.Logs(cleanup, e.id, stdout, stderr); err != nil && !lost {
+		err = fmt.Errorf("collect logs: %w", err)
+		ingestion.end(err)
+		return nil, err
+	}
+	// Fetched evidence counts as the job's own, e.g. as the parent of
+	// extracted files.
+	job := e.job
+	fetched := e.fetch.Close()
+	if len(fetched) > 0 {
+		job.ExtraEvidence = append(append([]Evidence(nil), job.ExtraEvidence...), fetched...)
+	}
+	res, err := r.result(job, e.cfg, state, timedOut, duration, stdout.String(), stderr.String())
+	if err == nil && cancelled {
+		res.markCancelled()
+	}
+	if err == nil {
+		e.watchdog.apply(res, e.cfg, idle)
+		e.records.apply(res, overLimit)
+		e.heartbeat.apply(res, lost)
+		res.FetchedEvidence = fetched
+		res.FindingQueries = e.query.Close()
+		res.DecryptedEvidence = decryptedEvidence(e.job)
+		if e.stream != nil {
+			res.StreamedEvidence, res.StreamedBytes = []string{e.job.Evidence.UID}, e.stream.Close()
+		}
+		res.Shared = r.sharedUsage(e.job, e.shared)
+		res.Image, res.ImageDigest = e.image, e.imageDigest
+		res.Architecture = r.arch()
+		res.GoVersion = e.goVersion
+		res.BinarySHA256 = e.binarySHA256
+		res.Build = e.build
+		res.EffectiveConfig = e.effective
+		res.attachBuildLog(e.job, e.failedBuild, e.buildEnv)
+		res.SignerKeyID = e.signer
+		res.ScriptSHA256 = e.scriptSHA256
+		res.Vulnerabilities = e.vulns
+		res.EvidenceModes, res.BlockDeviceError = e.evidenceModes, e.deviceErr
+		res.StdoutDropped, res.StderrDropped = stdout.Dropped(), stderr.Dropped()
+		res.Attempts = max(e.attempts, 1)
+		res.Metrics.Started = e.started
+		res.Session = e.session.record(newScrubber(e.job.Secrets))
+		res.TraceID = e.span.traceIDHex()
+		r.Enrichment.enrich(bg, e.job, res)
+		r.record(e.job, res)
+	}
+	ingestion.end(err)
+	return res, err
+}
+
+// result builds the outcome of job from the container's final state and
+// output, collecting what it wrote to OutputDir.
+func (r *Runner) result(job Job, cfg ExecConfig, state ContainerState, timedOut bool, duration time.Duration, stdout, stderr string) (*JobResult, error) {
+	// Nothing the runner keeps holds a secret: the logs are scrubbed
+	// before anything is derived from them.
+	scrub := newScrubber(job.Secrets)
+	stdout, stdoutBinary := binarySafe(scrub.scrub(stdout))
+	stderr, stderrBinary := binarySafe(scrub.scrub(stderr))
+	truncated, err := takeQuotaMarker(job.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("collect outputs: %w", err)
+	}
+	compileTimedOut, err := takeCompileTimeout(job.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("collect outputs: %w", err)
+	}
+	recovered := takePanic(job.OutputDir)
+	skipped := takeNotApplicable(job.OutputDir)
+	metrics := JobMetrics{Duration: duration}
+	if err := takeMetrics(job.OutputDir, job, &metrics); err != nil {
+		return nil, fmt.Errorf("collect metrics: %w", err)
+	}
+	outputs, err := collectOutputs(job.OutputDir)
+	if err != nil {
+		return nil, fmt.Errorf("collect outputs: %w", err)
+	}
+	artifacts, artifactsTotal, manifestErr := collectArtifacts(job.OutputDir, outputs, cfg.MaxArtifacts)
+	namespaceArtifacts(job, artifacts)
+	extracted, extractedErr := collectExtracted(job, artifacts)
+	timeline, timelineTotal, timelineErr := collectTimeline(job.OutputDir, cfg.MaxTimelineEvents)
+	findings, findingsTotal, findingsErr := collectFindings(job, cfg)
+	iocs, iocsErr := collectIOCs(job.OutputDir)
+	facts, factsErr := collectFacts(job.OutputDir)
+	warnings, warningsErr := collectWarnings(job.OutputDir)
+	warnings = append(warnings, checkTechniques(findings)...)
+	_, _, localeWarnings := caseLocale(job)
+	warnings = append(warnings, localeWarnings...)
+	warnings = append(warnings, checkArtifacts(artifacts)...)
+	graph, graphErr := collectGraph(job.OutputDir)
+	coverage, coverageErr := sandbox.ReadCoverage(job.OutputDir)
+	status, statusErr := sandbox.ReadJobStatus(job.OutputDir)
+	metrics.OutputBytes = outputBytes(job.OutputDir, outputs)
+	kept := map[string]int{RecordFindings: len(findings), RecordArtifacts: len(artifacts), RecordTimelineEvents: len(timeline)}
+	total := map[string]int{RecordFindings: findingsTotal, RecordArtifacts: artifactsTotal, RecordTimelineEvents: timelineTotal}
+	res := &JobResult{
+		JobID:             job.ID,
+		ExitCode:          state.ExitCode,
+		Success:           state.ExitCode == 0 && !timedOut && !compileTimedOut && !state.OOMKilled,
+		Signal:            exitSignal(state.ExitCode),
+		Stdout:            stdout,
+		Stderr:            stderr,
+		StdoutBinaryBytes: stdoutBinary,
+		StderrBinaryBytes: stderrBinary,
+		TimedOut:          timedOut,
+		BuildTimedOut:     compileTimedOut,
+		Incomplete:        timedOut,
+		OutputTruncated:   truncated,
+		RecordsTruncated:  truncatedRecords(cfg, kept, total),
+		OOMKilled:         state.OOMKilled,
+		Network:           networkAudit(cfg),
+		Outputs:           outputs,
+		Artifacts:         artifacts,
+		Extracted:         extracted,
+		Timeline:          timeline,
+		Findings:          findings,
+		IOCs:              iocs,
+		Facts:             facts,
+		Warnings:          warnings,
+		Graph:             graph,
+		Coverage:          coverage,
+		JobStatus:         status,
+		InvalidRecords:    invalidRecords(findingsErr, timelineErr, iocsErr, factsErr, warningsErr, graphErr),
+		Metrics:           metrics,
+		Seed:              job.Seed,
+	}
+	for i := range res.InvalidRecords {
+		res.InvalidRecords[i].Record = scrub.scrub(res.InvalidRecords[i].Record)
+	}
+	res.Report = primaryReport(res.Artifacts)
+	// An invalid manifest fails the job at Start.
+	res.Module, _ = ReadScriptModule(job.Workspace, job.Language)
+	if manifestErr != nil {
+		res.ManifestError = manifestErr.Error()
+	}
+	if timelineErr != nil {
+		res.TimelineError = timelineErr.Error()
+	}
+	if findingsErr != nil {
+		res.FindingsError = findingsErr.Error()
+	}
+	if iocsErr != nil {
+		res.IOCsError = iocsErr.Error()
+	}
+	if factsErr != nil {
+		res.FactsError = factsErr.Error()
+	}
+	if warningsErr != nil {
+		res.WarningsError = warningsErr.Error()
+	}
+	if graphErr != nil {
+		res.GraphError = graphErr.Error()
+	}
+	if extractedErr != nil {
+		res.ExtractedError = extractedErr.Error()
+	}
+	if coverageErr != nil {
+		res.CoverageError = coverageErr.Error()
+	}
+	if statusErr != nil {
+		res.JobStatusError = statusErr.Error()
+	}
+	if skipped != nil && !timedOut && !state.OOMKilled {
+		// `go run` exits with 1 whatever the script's status.
+		res.Success, res.NotApplicable, res.NotApplicableReason = false, true, skipped.Reason
+	}
+	if !res.Success && !res.NotApplicable {
+		res.StderrTail = stderrTail(stderr, r.stderrTailLines())
+		res.Panic = extractPanic(stderr)
+		if recovered != nil {
+			// Recover stopped the script short of the end of its parse.
+			res.Panic, res.Incomplete = recovered, true
+		}
+		res.EvidenceRead = extractEvidenceRead(stderr)
+		if languageKey(job.Language) == LanguageBash {
+			res.ShellFailure = extractShellFailure(stderr)
+		}
+	}
+	res.FailureReason, res.FailureDetail = failure(job, cfg, res)
+	if job.LogDir != "" {
+		if err := writeJobLogs(job.LogDir, stdout, stderr); err != nil {
+			return nil, fmt.Errorf("store logs: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// markCancelled flags res as a cancelled job with partial outputs. The
+// staged copy of the workspace goes with the job's container or pool slot;
+// Job.Workspace, the analyst's source tree, is never touched.
+func (res *JobResult) markCancelled() {
+	res.Cancelled = true
+	res.Incomplete = true
+	res.Success = false
+	res.FailureReason, res.FailureDetail = FailureCancelled, "cancelled by the caller"
+}
+
+func (e *Execution) markExited() {
+	e.exitOnce.Do(func() { close(e.exited) })
+}
+
+func networkAudit(cfg ExecConfig) NetworkAudit {
+	audit := NetworkAudit{Mode: cfg.networkMode()}
+	if audit.Mode == NetworkAllowlist {
+		audit.AllowedHosts = append([]string(nil), cfg.AllowedHosts...)
+		audit.RatePerHost = cfg.RatePerHost
+	}
+	return audit
+}
@@ -0,0 +1,32 @@
+package orchestrator
+
+import "strconv"
+
+// signalNames maps the signals a sandbox process is typically killed by.
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+}
+
+// exitSignal returns the signal encoded in a container exit code, which
+// the runtime reports as 128+n for a process killed by signal n.
+func exitSignal(code int) string {
+	n := code - 128
+	if n <= 0 || n > 64 {
+		return ""
+	}
+	if name, ok := signalNames[n]; ok {
+		return name
+	}
+	return "SIG" + strconv.Itoa(n)
+}
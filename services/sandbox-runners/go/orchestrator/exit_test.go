@@ -0,0 +1,42 @@
+package orchestrator
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunExitStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		exit     int
+		success  bool
+		signal   string
+		oom      bool
+		wantCode int
+	}{
+		{name: "exit 0", exit: 0, success: true, wantCode: 0},
+		{name: "exit 1", exit: 1, success: false, wantCode: 1},
+		{name: "SIGKILL", exit: 137, success: false, signal: "SIGKILL", wantCode: 137},
+		{name: "OOM", exit: 0, oom: true, success: false, wantCode: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rt := &fakeRuntime{state: ContainerState{ExitCode: tc.exit, OOMKilled: tc.oom}}
+			res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ExitCode != tc.wantCode || res.Success != tc.success || res.Signal != tc.signal {
+				t.Errorf("result code=%d success=%v signal=%q, want %d %v %q",
+					res.ExitCode, res.Success, res.Signal, tc.wantCode, tc.success, tc.signal)
+			}
+		})
+	}
+}
+
+func TestExitSignal(t *testing.T) {
+	for code, want := range map[int]string{0: "", 1: "", 128: "", 143: "SIGTERM", 139: "SIGSEGV", 138: "SIG10", 255: ""} {
+		if got := exitSignal(code); got != want {
+			t.Errorf("exitSignal(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
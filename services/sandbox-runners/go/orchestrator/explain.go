@@ -0,0 +1,183 @@
+package orchestrator
+
+import (
+	"strings"
+	"time"
+)
+
+// ConfigSource names where a value of the effective configuration of a
+// job came from, in EffectiveConfig.Sources.
+type ConfigSource string
+
+const (
+	// ConfigSourceBuiltin is the package default of a value no
+	// configuration sets, e.g. DefaultMemoryLimitBytes.
+	ConfigSourceBuiltin ConfigSource = "builtin"
+	// ConfigSourceRunner is Runner.Defaults, or for the image
+	// Runner.Images, Runner.ArchImageDigests and Runner.ImageDigests.
+	ConfigSourceRunner ConfigSource = "runner"
+	// ConfigSourceCase is the job's case in Runner.CaseConfigs.
+	ConfigSourceCase ConfigSource = "case"
+	// ConfigSourceJob is Job.Config.
+	ConfigSourceJob ConfigSource = "job"
+	// ConfigSourceRequirements is the requirements of the script, which
+	// raised the limit, see ReadRequirements.
+	ConfigSourceRequirements ConfigSource = "requirements"
+	// ConfigSourceFlavor is the image of Job.Flavor.
+	ConfigSourceFlavor ConfigSource = "flavor"
+	// ConfigSourceGoToolchain is the image of Job.GoVersion in
+	// Runner.GoToolchains.
+	ConfigSourceGoToolchain ConfigSource = "go_toolchain"
+)
+
+// profileSource is the source of the limits of the resource profile of
+// evidence type t, e.g. "profile:memory_dump".
+func profileSource(t string) ConfigSource {
+	return ConfigSource("profile:" + t)
+}
+
+// EffectiveConfig is the configuration a job ran with under
+// ExecConfig.Explain, once Runner.Defaults, its case's, its own, the
+// resource profile of its evidence type and the requirements of its script
+// are combined, and the package defaults filled in.
+type EffectiveConfig struct {
+	// Image is the runner image as configured, ImageDigest the ID it was
+	// resolved to and PinnedDigest the digest it was checked against, if
+	// any.
+	Image        string
+	ImageDigest  string
+	PinnedDigest string
+	Runtime      string
+	// MemoryLimitBytes, CPUQuota, RunTimeout, BuildTimeout and GracePeriod
+	// are the limits of the job, the package defaults included.
+	MemoryLimitBytes int64
+	CPUQuota         float64
+	RunTimeout       time.Duration
+	BuildTimeout     time.Duration
+	GracePeriod      time.Duration
+	OutputQuotaBytes int64
+	NetworkMode      NetworkMode
+	AllowedHosts     []string
+	Offline          bool
+	// Env is the environment of the job's container, with the values of
+	// its secrets masked as in its logs.
+	Env map[string]string
+	// BuildCommand compiles the script, e.g. `go build -trimpath ...
+	// -tags=debug -o /build/script .`, or compiles and runs it, e.g. `go
+	// run .`; BuildTags are the Job.BuildTags it was given.
+	BuildCommand []string
+	BuildTags    []string
+	// Sources names where each field above came from, by field name, e.g.
+	// "MemoryLimitBytes": "profile:memory_dump". ImageDigest, Env,
+	// BuildCommand and BuildTags have none.
+	Sources map[string]ConfigSource
+}
+
+// configSource returns the source of execConfig(job).
+func (r *Runner) configSource(job Job) ConfigSource {
+	if job.Config != nil {
+		return ConfigSourceJob
+	}
+	if _, ok := r.CaseConfigs[job.CaseID]; ok {
+		return ConfigSourceCase
+	}
+	return ConfigSourceRunner
+}
+
+// explainConfig returns the EffectiveConfig of job run with cfg, its
+// jobConfig, in a container with env from image, resolved to imageID, the
+// script built by buildCmd; nil without cfg.Explain.
+func (r *Runner) explainConfig(job Job, cfg ExecConfig, image, imageID string, env map[string]string, buildCmd []string) *EffectiveConfig {
+	if !cfg.Explain {
+		return nil
+	}
+	ec := &EffectiveConfig{
+		Image:            image,
+		ImageDigest:      imageID,
+		PinnedDigest:     r.jobImageDigest(job, cfg),
+		Runtime:          cfg.Runtime,
+		MemoryLimitBytes: cfg.memoryLimit(),
+		CPUQuota:         cfg.cpuQuota(),
+		RunTimeout:       cfg.runTimeout(),
+		BuildTimeout:     cfg.buildTimeout(),
+		GracePeriod:      cfg.gracePeriod(),
+		OutputQuotaBytes: cfg.OutputQuotaBytes,
+		NetworkMode:      cfg.networkMode(),
+		AllowedHosts:     append([]string(nil), cfg.AllowedHosts...),
+		Offline:          cfg.Offline,
+		BuildCommand:     append([]string(nil), buildCmd...),
+		BuildTags:        append([]string(nil), job.BuildTags...),
+		Sources:          map[string]ConfigSource{},
+	}
+	ec.Env = make(map[string]string, len(env))
+	for k, v := range env {
+		ec.Env[k] = v
+	}
+	ec.Env = newScrubber(job.Secrets).scrubMap(ec.Env)
+
+	base, from := r.execConfig(job), r.configSource(job)
+	set := func(field string, ok bool) {
+		if ok {
+			ec.Sources[field] = from
+		} else {
+			ec.Sources[field] = ConfigSourceBuiltin
+		}
+	}
+	set("Runtime", base.Runtime != "")
+	set("MemoryLimitBytes", base.MemoryLimitBytes > 0)
+	set("CPUQuota", base.CPUQuota > 0)
+	set("RunTimeout", base.RunTimeout > 0)
+	set("BuildTimeout", base.BuildTimeout > 0)
+	set("GracePeriod", base.GracePeriod > 0)
+	set("OutputQuotaBytes", base.OutputQuotaBytes > 0)
+	set("NetworkMode", base.NetworkMode != "")
+	set("AllowedHosts", len(base.AllowedHosts) > 0)
+	set("Offline", base.Offline)
+
+	// profiledConfig only applies the profile to a job without a
+	// configuration of its own.
+	if job.Config == nil {
+		t := strings.ToLower(job.Evidence.Type)
+		p := r.ResourceProfiles[t]
+		if p.MemoryLimitBytes > 0 {
+			ec.Sources["MemoryLimitBytes"] = profileSource(t)
+		}
+		if p.CPUQuota > 0 {
+			ec.Sources["CPUQuota"] = profileSource(t)
+		}
+		if p.Timeout > 0 {
+			ec.Sources["RunTimeout"] = profileSource(t)
+		}
+	}
+	profiled := r.profiledConfig(job)
+	if cfg.memoryLimit() != profiled.memoryLimit() {
+		ec.Sources["MemoryLimitBytes"] = ConfigSourceRequirements
+	}
+	if cfg.cpuQuota() != profiled.cpuQuota() {
+		ec.Sources["CPUQuota"] = ConfigSourceRequirements
+	}
+	if cfg.runTimeout() != profiled.runTimeout() {
+		ec.Sources["RunTimeout"] = ConfigSourceRequirements
+	}
+
+	tc, toolchain := r.goToolchain(job)
+	switch {
+	case job.Flavor != "":
+		ec.Sources["Image"] = ConfigSourceFlavor
+	case toolchain:
+		ec.Sources["Image"] = ConfigSourceGoToolchain
+	case r.Images[languageKey(job.Language)] != "":
+		ec.Sources["Image"] = ConfigSourceRunner
+	default:
+		ec.Sources["Image"] = ConfigSourceBuiltin
+	}
+	switch {
+	case base.ImageDigest != "":
+		ec.Sources["PinnedDigest"] = from
+	case toolchain && tc.Digest != "":
+		ec.Sources["PinnedDigest"] = ConfigSourceGoToolchain
+	case ec.PinnedDigest != "":
+		ec.Sources["PinnedDigest"] = ConfigSourceRunner
+	}
+	return ec
+}
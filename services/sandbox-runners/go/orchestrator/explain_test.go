@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunExplainsEffectiveConfig(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.Jobs = NewJobIndex()
+	r.CaseConfigs = map[string]ExecConfig{
+		"case-1": {MemoryLimitBytes: 1 << 30, NetworkMode: NetworkNone, Explain: true},
+	}
+	r.ResourceProfiles = map[string]ResourceProfile{
+		sandbox.EvidenceTypeRegistryHive: {CPUQuota: 1},
+	}
+	job := testJob(t)
+	job.Evidence.Type = sandbox.EvidenceTypeRegistryHive
+	job.Params = map[string]string{"PARAM_NOTE": "key=" + testSecret}
+	job.Secrets = map[string]string{"vt_api_key": testSecret}
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires memory=2GB\npackage main\n"), 0o644)
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := res.EffectiveConfig
+	if ec == nil {
+		t.Fatal("no effective configuration recorded")
+	}
+	if ec.MemoryLimitBytes != 2<<30 || ec.CPUQuota != 1 || ec.RunTimeout != DefaultRunTimeout || ec.NetworkMode != NetworkNone {
+		t.Errorf("limits = %d bytes, %v CPUs, %s, network %s", ec.MemoryLimitBytes, ec.CPUQuota, ec.RunTimeout, ec.NetworkMode)
+	}
+	if ec.Image == "" || ec.ImageDigest != res.ImageDigest {
+		t.Errorf("image %q resolved to %q, result has %q", ec.Image, ec.ImageDigest, res.ImageDigest)
+	}
+	if len(ec.BuildCommand) == 0 {
+		t.Error("no build command")
+	}
+	want := map[string]ConfigSource{
+		"MemoryLimitBytes": ConfigSourceRequirements,
+		"CPUQuota":         profileSource(sandbox.EvidenceTypeRegistryHive),
+		"RunTimeout":       ConfigSourceBuiltin,
+		"NetworkMode":      ConfigSourceCase,
+		"Image":            ConfigSourceBuiltin,
+	}
+	for field, src := range want {
+		if ec.Sources[field] != src {
+			t.Errorf("%s came from %q, want %q", field, ec.Sources[field], src)
+		}
+	}
+	if _, ok := ec.Sources["PinnedDigest"]; ok {
+		t.Error("unpinned image has a digest source")
+	}
+	if note := ec.Env["PARAM_NOTE"]; note != "key=[REDACTED:vt_api_key]" {
+		t.Errorf("PARAM_NOTE = %q", note)
+	}
+	if ec.Env[sandbox.EnvCaseID] != "case-1" {
+		t.Errorf("env = %v", ec.Env)
+	}
+
+	recs := r.Jobs.Query(JobFilter{CaseID: "case-1"})
+	if len(recs) != 1 || recs[0].EffectiveConfig != ec {
+		t.Errorf("records = %+v", recs)
+	}
+}
+
+func TestEffectiveConfigIsOptIn(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.EffectiveConfig != nil {
+		t.Errorf("effective configuration recorded without Explain: %+v", res.EffectiveConfig)
+	}
+}
+
+func TestPoolExplainsEffectiveConfig(t *testing.T) {
+	rt := &fakeRuntime{}
+	var ran []string
+	rt.onExec = func(id string, spec ExecSpec) {
+		if spec.Env[sandbox.EnvCaseID] != "" {
+			ran = spec.Cmd
+		}
+	}
+	r := NewRunner(rt)
+	r.Defaults.Explain = true
+	p, err := NewPool(r, PoolConfig{Size: 1, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(context.Background())
+	if err := p.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Run(context.Background(), poolJob(t, "case-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.specs) != 1 {
+		t.Fatalf("%d containers created, want the pooled one", len(rt.specs))
+	}
+	ec := res.EffectiveConfig
+	if ec == nil {
+		t.Fatal("no effective configuration recorded")
+	}
+	if ec.ImageDigest != res.ImageDigest || ec.MemoryLimitBytes != r.Defaults.memoryLimit() || ec.Sources["MemoryLimitBytes"] != ConfigSourceRunner {
+		t.Errorf("effective configuration = %+v", ec)
+	}
+	if !reflect.DeepEqual(boundGoCompile(ec.BuildCommand, DefaultBuildTimeout), ran) {
+		t.Errorf("build command %q, ran %q", ec.BuildCommand, ran)
+	}
+}
@@ -0,0 +1,199 @@
+package orchestrator
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Formats of ExportResults.
+const (
+	ExportJSONL = "jsonl"
+	ExportCSV   = "csv"
+)
+
+// ErrUnsupportedExportFormat is returned by ExportResults for a format
+// other than ExportJSONL and ExportCSV.
+var ErrUnsupportedExportFormat = errors.New("orchestrator: unsupported export format")
+
+// Record types of an exported record.
+const (
+	exportFinding = "finding"
+	exportIOC     = "ioc"
+)
+
+// exportRecord is a finding or an indicator of a case, flattened for
+// analytics tools. Every field is written for both types, empty when it
+// does not apply, so that the field set of the export is stable.
+type exportRecord struct {
+	Type   string `json:"record_type"`
+	CaseID string `json:"case_id"`
+	// Key is the FindingKey of a finding, the Key of an indicator.
+	Key         string `json:"key"`
+	Severity    string `json:"severity"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Value       string `json:"value"`
+	Module      string `json:"module"`
+	Count       int    `json:"count"`
+	// The fields below are lists or objects, written as compact JSON in
+	// CSV.
+	Contexts     []string           `json:"contexts"`
+	EvidenceUIDs []string           `json:"evidence_uids"`
+	JobIDs       []string           `json:"job_ids"`
+	Data         map[string]any     `json:"data"`
+	Locations    []sandbox.Location `json:"locations"`
+}
+
+// exportColumns is the CSV header, in the order of exportRecord.
+var exportColumns = []string{
+	"record_type", "case_id", "key", "severity", "title", "description", "kind", "value", "module", "count",
+	"contexts", "evidence_uids", "job_ids", "data", "locations",
+}
+
+// ExportResults returns the findings in findings and the indicators in
+// iocs, both of case caseID and either one nil, as one flat stream for
+// analytics tools, in format:
+//   - ExportJSONL writes one JSON object per line, with every field of
+//     exportColumns present, empty when it does not apply;
+//   - ExportCSV writes a header line then one row per record, quoted as
+//     RFC 4180 requires for commas, quotes and newlines; lists and
+//     objects (contexts, evidence_uids, job_ids, data, locations) are
+//     written as compact JSON, with the keys of data sorted.
+//
+// Findings come first, in order of first report, then indicators.
+func ExportResults(caseID, format string, iocs *CaseIOCs, findings *CaseFindings) (io.Reader, error) {
+	if format != ExportJSONL && format != ExportCSV {
+		return nil, fmt.Errorf("%w %q, want %s or %s", ErrUnsupportedExportFormat, format, ExportJSONL, ExportCSV)
+	}
+	if iocs != nil && iocs.CaseID != caseID {
+		return nil, fmt.Errorf("orchestrator: IOC index of case %s, not %s", iocs.CaseID, caseID)
+	}
+	if findings != nil && findings.CaseID != caseID {
+		return nil, fmt.Errorf("orchestrator: findings of case %s, not %s", findings.CaseID, caseID)
+	}
+	var records []exportRecord
+	if findings != nil {
+		for _, f := range findings.Findings() {
+			records = append(records, findingRecord(caseID, f))
+		}
+	}
+	if iocs != nil {
+		for _, ioc := range iocs.IOCs() {
+			records = append(records, iocRecord(caseID, ioc))
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == ExportJSONL {
+		err = writeJSONL(&buf, records)
+	} else {
+		err = writeCSV(&buf, records)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func findingRecord(caseID string, f Finding) exportRecord {
+	return exportRecord{
+		Type:         exportFinding,
+		CaseID:       caseID,
+		Key:          f.FindingKey,
+		Severity:     string(f.Severity),
+		Title:        f.Title,
+		Description:  f.Description,
+		Module:       f.Module.String(),
+		Count:        f.Count,
+		EvidenceUIDs: f.EvidenceUIDs,
+		JobIDs:       f.JobIDs,
+		Data:         f.Data,
+		Locations:    f.Locations,
+	}
+}
+
+func iocRecord(caseID string, ioc CaseIOC) exportRecord {
+	return exportRecord{
+		Type:         exportIOC,
+		CaseID:       caseID,
+		Key:          ioc.Key,
+		Kind:         string(ioc.Kind),
+		Value:        ioc.Value,
+		Count:        ioc.Count,
+		Contexts:     ioc.Contexts,
+		EvidenceUIDs: ioc.EvidenceUIDs,
+		JobIDs:       ioc.JobIDs,
+	}
+}
+
+// normalize replaces the nil lists and objects of rec by empty ones, so
+// that they are written the same whatever record they come from.
+func (rec *exportRecord) normalize() {
+	for _, list := range []*[]string{&rec.Contexts, &rec.EvidenceUIDs, &rec.JobIDs} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	if rec.Data == nil {
+		rec.Data = map[string]any{}
+	}
+	if rec.Locations == nil {
+		rec.Locations = []sandbox.Location{}
+	}
+}
+
+func writeJSONL(w io.Writer, records []exportRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, rec := range records {
+		rec.normalize()
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, records []exportRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		rec.normalize()
+		row := []string{
+			rec.Type, rec.CaseID, rec.Key, rec.Severity, rec.Title, rec.Description, rec.Kind, rec.Value, rec.Module, strconv.Itoa(rec.Count),
+		}
+		for _, v := range []any{rec.Contexts, rec.EvidenceUIDs, rec.JobIDs, rec.Data, rec.Locations} {
+			cell, err := compactJSON(v)
+			if err != nil {
+				return err
+			}
+			row = append(row, cell)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// compactJSON encodes v on one line, without escaping HTML characters.
+func compactJSON(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
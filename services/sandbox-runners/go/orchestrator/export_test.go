@@ -0,0 +1,114 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// exportCase returns the IOCs and findings of a case with one job.
+func exportCase(t *testing.T) (*CaseIOCs, *CaseFindings) {
+	t.Helper()
+	iocs, findings := NewCaseIOCs("case-1"), NewCaseFindings("case-1")
+	job := Job{ID: "job-1", CaseID: "case-1"}
+	res := &JobResult{
+		Module: ScriptModule{Name: "yara-triage", Version: "1.2.0"},
+		IOCs: []sandbox.IOC{
+			{EvidenceUID: "ev-1", Kind: sandbox.IOCDomain, Value: "evil.example", Context: "C2, in config"},
+		},
+		Findings: []sandbox.Result{{
+			EvidenceUID: "ev-1", Severity: sandbox.SeverityHigh, Title: `Beacon "svc", hourly`,
+			Description: "first line\nsecond line", FindingKey: "ioc/domain/evil.example",
+			Data:      map[string]any{"pid": 4, "image": "svchost.exe"},
+			Locations: []sandbox.Location{{EvidenceUID: "ev-1", Offset: 512, Length: 64}},
+		}},
+	}
+	if err := iocs.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := findings.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	return iocs, findings
+}
+
+func TestExportResultsJSONL(t *testing.T) {
+	iocs, findings := exportCase(t)
+	r, err := ExportResults("case-1", ExportJSONL, iocs, findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var rec map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want the finding and the IOC", len(records))
+	}
+	for _, rec := range records {
+		if len(rec) != len(exportColumns) {
+			t.Errorf("record %v, want the %d fields of every record", rec, len(exportColumns))
+		}
+	}
+	f, ioc := records[0], records[1]
+	if f["record_type"] != "finding" || f["case_id"] != "case-1" || f["module"] != "yara-triage v1.2.0" || f["data"].(map[string]any)["image"] != "svchost.exe" {
+		t.Errorf("finding = %v", f)
+	}
+	if ioc["record_type"] != "ioc" || ioc["kind"] != "domain" || ioc["value"] != "evil.example" ||
+		!reflect.DeepEqual(ioc["data"], map[string]any{}) || !reflect.DeepEqual(ioc["locations"], []any{}) {
+		t.Errorf("ioc = %v", ioc)
+	}
+}
+
+func TestExportResultsCSV(t *testing.T) {
+	iocs, findings := exportCase(t)
+	r, err := ExportResults("case-1", ExportCSV, iocs, findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], exportColumns) {
+		t.Fatalf("rows = %q, want the header, the finding and the IOC", rows)
+	}
+	want := []string{
+		"finding", "case-1", "ioc/domain/evil.example", "high", `Beacon "svc", hourly`, "first line\nsecond line", "", "", "yara-triage v1.2.0", "1",
+		`[]`, `["ev-1"]`, `["job-1"]`, `{"image":"svchost.exe","pid":4}`, `[{"evidence_uid":"ev-1","offset":512,"length":64}]`,
+	}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("finding row = %q, want %q", rows[1], want)
+	}
+	if rows[2][0] != "ioc" || rows[2][7] != "evil.example" || rows[2][10] != `["C2, in config"]` || rows[2][13] != "{}" {
+		t.Errorf("IOC row = %q", rows[2])
+	}
+}
+
+func TestExportResultsRejects(t *testing.T) {
+	iocs, findings := exportCase(t)
+	if _, err := ExportResults("case-1", "xlsx", iocs, findings); !errors.Is(err, ErrUnsupportedExportFormat) {
+		t.Errorf("err = %v, want ErrUnsupportedExportFormat", err)
+	}
+	if _, err := ExportResults("case-2", ExportCSV, iocs, nil); err == nil {
+		t.Error("IOCs of another case exported")
+	}
+	r, err := ExportResults("case-2", ExportJSONL, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); len(data) != 0 {
+		t.Errorf("empty export = %q", data)
+	}
+}
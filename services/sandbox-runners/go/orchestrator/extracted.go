@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ExtractedEvidence is a file carved by the script with sandbox.ExtractFile,
+// to be ingested as a child of the evidence it came from.
+type ExtractedEvidence struct {
+	// Evidence is the new item: its UID, host path in OutputDir and the
+	// SHA256 verified at collection.
+	Evidence
+	// Output is the file's path relative to OutputDir.
+	Output string
+	// ParentUID is the UID of the job's evidence the file was carved from.
+	ParentUID  string
+	Offset     *int64
+	Provenance string
+}
+
+// collectExtracted returns the artifacts of kind extracted-evidence as
+// child evidence of job, each under the UID derived from its parent and
+// the SHA256 collectArtifacts computed, see sandbox.ExtractedEvidenceUID.
+// Files flagged Mismatch by collectArtifacts, whose parent is not one of
+// the job's evidence items or whose manifest entry claims another UID,
+// e.g. that of evidence already in the case, are left out and reported in
+// err.
+func collectExtracted(job Job, artifacts []CollectedArtifact) (extracted []ExtractedEvidence, err error) {
+	parents := map[string]bool{}
+	for _, ev := range job.allEvidence() {
+		parents[ev.UID] = true
+	}
+	var errs []error
+	for _, a := range artifacts {
+		if !a.Tracked || a.Kind != sandbox.ArtifactExtractedEvidence {
+			continue
+		}
+		if !parents[a.Parent] {
+			errs = append(errs, fmt.Errorf("%s: unknown parent evidence %q", a.Path, a.Parent))
+			continue
+		}
+		if a.Mismatch {
+			errs = append(errs, fmt.Errorf("%s: SHA256 %s does not match the manifest", a.Path, a.SHA256))
+			continue
+		}
+		path := filepath.Join(job.OutputDir, filepath.FromSlash(a.Path))
+		sum := a.SHA256
+		uid := sandbox.ExtractedEvidenceUID(a.Parent, sum)
+		if a.EvidenceUID != "" && a.EvidenceUID != uid {
+			errs = append(errs, fmt.Errorf("%s: evidence UID %q is not %q, derived from its parent and SHA256", a.Path, a.EvidenceUID, uid))
+			continue
+		}
+		extracted = append(extracted, ExtractedEvidence{
+			Evidence:   Evidence{UID: uid, Path: path, SHA256: sum, HashAlgo: sandbox.HashSHA256},
+			Output:     a.Path,
+			ParentUID:  a.Parent,
+			Offset:     a.Offset,
+			Provenance: a.Provenance,
+		})
+	}
+	return extracted, errors.Join(errs...)
+}
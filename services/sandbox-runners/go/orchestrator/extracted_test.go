@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsExtractedEvidence(t *testing.T) {
+	job := testJob(t)
+	t.Setenv(sandbox.EnvOutputDir, job.OutputDir)
+	t.Setenv(sandbox.EnvEvidenceUID, job.Evidence.UID)
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		extract := func(name, content string, opts ...sandbox.ExtractOption) {
+			if _, err := sandbox.ExtractFile(name, strings.NewReader(content), opts...); err != nil {
+				t.Error(err)
+			}
+		}
+		extract("payload.exe", "MZ", sandbox.AtOffset(4096), sandbox.WithProvenance("VAD"))
+		extract("tampered.bin", "original")
+		extract("orphan.bin", "x", sandbox.FromEvidence("ev-other"))
+		os.WriteFile(filepath.Join(job.OutputDir, sandbox.ExtractedDir, "tampered.bin"), []byte("changed"), 0o644)
+		// A manifest entry claiming the UID of the job's own evidence.
+		ref, err := sandbox.ExtractFile("forged.bin", strings.NewReader("forged"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		manifest := filepath.Join(job.OutputDir, sandbox.ManifestFile)
+		data, _ := os.ReadFile(manifest)
+		os.WriteFile(manifest, bytes.Replace(data, []byte(`"`+ref.UID+`"`), []byte(`"`+job.Evidence.UID+`"`), 1), 0o644)
+	}}
+
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Extracted) != 1 {
+		t.Fatalf("extracted = %+v", res.Extracted)
+	}
+	x := res.Extracted[0]
+	if x.UID != "ev-1-9b8db510ef42b8ed" || x.ParentUID != "ev-1" || x.Output != "extracted/payload.exe" ||
+		x.Path != filepath.Join(job.OutputDir, "extracted", "payload.exe") || x.HashAlgo != sandbox.HashSHA256 {
+		t.Errorf("extracted = %+v", x)
+	}
+	if x.Offset == nil || *x.Offset != 4096 || x.Provenance != "VAD" {
+		t.Errorf("provenance = %v, %q", x.Offset, x.Provenance)
+	}
+	for _, name := range []string{"tampered.bin", "orphan.bin", "forged.bin"} {
+		if !strings.Contains(res.ExtractedError, name) {
+			t.Errorf("ExtractedError = %q, want %s reported", res.ExtractedError, name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectFacts reads the facts the script wrote with sandbox.EmitFact.
+// Invalid lines are dropped and reported as err.
+func collectFacts(dir string) ([]sandbox.Fact, error) {
+	return sandbox.ReadFacts(dir)
+}
+
+// FactValue is one value reported for a fact by one source.
+type FactValue struct {
+	Value string
+	// Confidence is the one the source last reported.
+	Confidence float64
+	// Source is the analysis module that reported the value, and JobIDs
+	// its jobs, in order of report.
+	Source ScriptModule
+	JobIDs []string
+}
+
+// EvidenceFact is an attribute of an evidence item with every value
+// reported for it, most confident first. Values that disagree are all
+// kept: the analyst, not the last script to run, settles the conflict.
+type EvidenceFact struct {
+	Key    string
+	Values []FactValue
+}
+
+// Conflicting reports that the sources disagree on the value.
+func (f EvidenceFact) Conflicting() bool {
+	for _, v := range f.Values[1:] {
+		if v.Value != f.Values[0].Value {
+			return true
+		}
+	}
+	return false
+}
+
+// EvidenceFacts is the attribute store of the evidence items of one case:
+// the facts of its jobs by evidence UID and key, building a profile of
+// each item over successive analyses. A source reporting the same value
+// again updates its confidence; another value, or the same value from
+// another source, is added beside it. It is safe for concurrent use.
+type EvidenceFacts struct {
+	CaseID string
+
+	mu sync.Mutex
+	// facts maps an evidence UID and a key to the values reported.
+	facts map[string]map[string][]*FactValue
+}
+
+// NewEvidenceFacts returns an empty attribute store for caseID.
+func NewEvidenceFacts(caseID string) *EvidenceFacts {
+	return &EvidenceFacts{CaseID: caseID, facts: map[string]map[string][]*FactValue{}}
+}
+
+// Add merges res.Facts, the outcome of job, with res.Module as their
+// source. A job of another case is rejected.
+func (s *EvidenceFacts) Add(job Job, res *JobResult) error {
+	if job.CaseID != s.CaseID {
+		return fmt.Errorf("orchestrator: job %s belongs to case %s, not %s", job.ID, job.CaseID, s.CaseID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range res.Facts {
+		keys := s.facts[f.EvidenceUID]
+		if keys == nil {
+			keys = map[string][]*FactValue{}
+			s.facts[f.EvidenceUID] = keys
+		}
+		var v *FactValue
+		for _, e := range keys[f.Key] {
+			if e.Value == f.Value && e.Source.Name == res.Module.Name {
+				v = e
+				break
+			}
+		}
+		if v == nil {
+			v = &FactValue{Value: f.Value}
+			keys[f.Key] = append(keys[f.Key], v)
+		}
+		v.Confidence, v.Source = f.Confidence, res.Module
+		v.JobIDs = appendUnique(v.JobIDs, job.ID)
+	}
+	return nil
+}
+
+// Profile returns the facts of the evidence item uid, by key.
+func (s *EvidenceFacts) Profile(uid string) []EvidenceFact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.facts[uid]))
+	for k := range s.facts[uid] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]EvidenceFact, len(keys))
+	for i, k := range keys {
+		out[i] = s.fact(uid, k)
+	}
+	return out
+}
+
+// Lookup returns the fact key of the evidence item uid.
+func (s *EvidenceFacts) Lookup(uid, key string) (EvidenceFact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.facts[uid][key]; !ok {
+		return EvidenceFact{}, false
+	}
+	return s.fact(uid, key), true
+}
+
+// fact copies the values of key, most confident first, under s.mu.
+func (s *EvidenceFacts) fact(uid, key string) EvidenceFact {
+	values := s.facts[uid][key]
+	f := EvidenceFact{Key: key, Values: make([]FactValue, len(values))}
+	for i, v := range values {
+		f.Values[i] = *v
+		f.Values[i].JobIDs = append([]string(nil), v.JobIDs...)
+	}
+	sort.SliceStable(f.Values, func(i, j int) bool { return f.Values[i].Confidence > f.Values[j].Confidence })
+	return f
+}
@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsFacts(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.FactsFile), []byte(
+					`{"evidence_uid":"ev-1","key":"os.version","value":"Windows 10 22H2","confidence":0.9}`+"\n"+
+						`{"evidence_uid":"ev-1","key":"os.version","value":"x","confidence":2}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Facts) != 1 || res.Facts[0].Value != "Windows 10 22H2" || res.Facts[0].Confidence != 0.9 {
+		t.Errorf("facts = %+v", res.Facts)
+	}
+	if res.FactsError == "" || len(res.InvalidRecords) != 1 || res.InvalidRecords[0].File != sandbox.FactsFile || res.InvalidRecords[0].Line != 2 {
+		t.Errorf("invalid records = %+v, want the confidence of 2 quarantined", res.InvalidRecords)
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("artifacts = %+v, want facts.ndjson left out", res.Artifacts)
+	}
+}
+
+func TestEvidenceFactsKeepsConflicts(t *testing.T) {
+	s := NewEvidenceFacts("case-1")
+	add := func(jobID, module string, facts ...sandbox.Fact) {
+		t.Helper()
+		res := &JobResult{Facts: facts, Module: ScriptModule{Name: module, Version: "1.0"}}
+		if err := s.Add(Job{ID: jobID, CaseID: "case-1"}, res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("job-1", "registry",
+		sandbox.Fact{EvidenceUID: "ev-1", Key: "os.version", Value: "Windows 10 22H2", Confidence: 0.7},
+		sandbox.Fact{EvidenceUID: "ev-1", Key: "host.name", Value: "WS-042", Confidence: 1},
+	)
+	add("job-2", "eventlogs", sandbox.Fact{EvidenceUID: "ev-1", Key: "os.version", Value: "Windows 11 23H2", Confidence: 0.8})
+	add("job-3", "registry", sandbox.Fact{EvidenceUID: "ev-1", Key: "os.version", Value: "Windows 10 22H2", Confidence: 0.95})
+	add("job-4", "registry", sandbox.Fact{EvidenceUID: "ev-2", Key: "host.name", Value: "SRV-01", Confidence: 1})
+
+	profile := s.Profile("ev-1")
+	if len(profile) != 2 || profile[0].Key != "host.name" || profile[1].Key != "os.version" {
+		t.Fatalf("profile = %+v", profile)
+	}
+	if profile[0].Conflicting() {
+		t.Error("host.name reported as conflicting")
+	}
+	version := profile[1]
+	if len(version.Values) != 2 || !version.Conflicting() {
+		t.Fatalf("os.version = %+v, want both values kept", version)
+	}
+	first, second := version.Values[0], version.Values[1]
+	if first.Value != "Windows 10 22H2" || first.Confidence != 0.95 || first.Source.Name != "registry" || len(first.JobIDs) != 2 {
+		t.Errorf("first value = %+v, want the registry's, updated by job-3", first)
+	}
+	if second.Value != "Windows 11 23H2" || second.Source.Name != "eventlogs" || second.JobIDs[0] != "job-2" {
+		t.Errorf("second value = %+v", second)
+	}
+	if f, ok := s.Lookup("ev-2", "host.name"); !ok || f.Values[0].Value != "SRV-01" {
+		t.Errorf("Lookup() = %+v, %v", f, ok)
+	}
+	if _, ok := s.Lookup("ev-2", "os.version"); ok {
+		t.Error("Lookup() found a fact of another evidence item")
+	}
+	if err := s.Add(Job{ID: "job-5", CaseID: "case-2"}, &JobResult{}); err == nil {
+		t.Error("job of another case merged")
+	}
+}
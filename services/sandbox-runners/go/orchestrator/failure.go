@@ -0,0 +1,375 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// DefaultStderrTailLines is the number of stderr lines kept in
+// JobResult.StderrTail when Runner.StderrTailLines is zero.
+const DefaultStderrTailLines = 50
+
+// Names of the log files written to Job.LogDir.
+const (
+	StdoutLogFile = "stdout.log"
+	StderrLogFile = "stderr.log"
+)
+
+// PanicInfo is a Go panic found in a job's stderr.
+type PanicInfo struct {
+	// Message is the panic value, e.g. "runtime error: index out of range
+	// [5] with length 3".
+	Message string
+	// Stack is the panic and goroutine trace as printed by the runtime, or
+	// the trace of the goroutine that panicked as sandbox.Recover records
+	// it.
+	Stack string
+	// Recovered reports that sandbox.Recover caught the panic, after which
+	// the script flushed its findings and exited.
+	Recovered bool
+}
+
+// ShellFailure is the command that made a bash script fail, as reported by
+// the shell runner.
+type ShellFailure struct {
+	// File and Line locate the command, e.g. "script.sh" and 12.
+	File string
+	Line int
+	// Command is the command as bash ran it, variables expanded; for a
+	// failed pipeline, bash only reports its last command.
+	Command  string
+	ExitCode int
+}
+
+// EvidenceReadFailure is a read of the evidence file that failed in the
+// script, as sandbox.EvidenceReadError reports it: the storage failed, not
+// the parser.
+type EvidenceReadFailure struct {
+	// Path is the file as the container sees it, e.g. "/evidence/disk.raw".
+	Path string
+	// Offset is the offset of the failed read in the file as stored.
+	Offset   int64
+	Attempts int
+	// Err is the error of the last attempt, e.g. "read /evidence/disk.raw:
+	// input/output error".
+	Err string
+}
+
+// String returns f as sandbox.EvidenceReadError words it, without the
+// package prefix.
+func (f EvidenceReadFailure) String() string {
+	attempts := "1 attempt"
+	if f.Attempts != 1 {
+		attempts = fmt.Sprintf("%d attempts", f.Attempts)
+	}
+	return fmt.Sprintf("evidence read failed at offset %d of %s after %s: %s", f.Offset, f.Path, attempts, f.Err)
+}
+
+// FailureReason classifies why a job did not succeed, so that callers can
+// tell a broken script from a resource limit or the runner itself.
+type FailureReason string
+
+const (
+	// FailureCompileError: the script did not compile or its imports did
+	// not resolve.
+	FailureCompileError FailureReason = "compile_error"
+	// FailureCompileTimeout: vendoring, scanning or compiling the script
+	// outlasted ExecConfig.BuildTimeout, and the script was not run.
+	FailureCompileTimeout FailureReason = "compile_timeout"
+	// FailureRunTimeout: the job was stopped by ExecConfig.RunTimeout.
+	FailureRunTimeout FailureReason = "run_timeout"
+	// FailureTimeout is what audit logs, replay records and callbacks
+	// written before the build and run timeouts were told apart hold in
+	// place of FailureRunTimeout. No job fails with it any longer; see
+	// FailureReason.Canonical.
+	//
+	// Deprecated: use FailureRunTimeout.
+	FailureTimeout FailureReason = "timeout"
+	// FailureOOMKilled: the script exceeded its memory limit.
+	FailureOOMKilled FailureReason = "oom_killed"
+	// FailureNonZeroExit: the script ran and exited non-zero on its own.
+	FailureNonZeroExit FailureReason = "non_zero_exit"
+	// FailureCancelled: the caller cancelled the job.
+	FailureCancelled FailureReason = "cancelled"
+	// FailureInternalError: the container could not run the script
+	// command at all; the runner image is at fault, not the script.
+	FailureInternalError FailureReason = "internal_error"
+	// FailureEvidenceMissing: the script could not find its evidence, or
+	// the preflight check found it missing, empty or unreadable.
+	FailureEvidenceMissing FailureReason = "evidence_missing"
+	// FailureEvidenceCorrupt: the preflight check found that the evidence
+	// does not match its recorded digest.
+	FailureEvidenceCorrupt FailureReason = "evidence_corrupt"
+	// FailureHung: the job went ExecConfig.IdleTimeout without output nor
+	// progress and was stopped, with ExecConfig.KillIdle.
+	FailureHung FailureReason = "hung"
+	// FailureRecordLimit: the job wrote more records than a cap of its
+	// ExecConfig allows and was stopped, with KillOverRecordLimit.
+	FailureRecordLimit FailureReason = "record_limit"
+	// FailureEvidenceReadFailed: a read of the evidence failed in the
+	// script, after its SDK retried it; the job may succeed once the
+	// storage recovers.
+	FailureEvidenceReadFailed FailureReason = "evidence_read_failed"
+	// FailureCompletedWithPanic: the script panicked and sandbox.Recover
+	// caught it; the findings emitted before the panic are kept.
+	FailureCompletedWithPanic FailureReason = "completed_with_panic"
+	// FailureNotApplicable: the script skipped its evidence with
+	// sandbox.SkipNotApplicable, which is not a failure either.
+	FailureNotApplicable FailureReason = "not_applicable"
+	// FailureVulnerableDependency: Runner.VulnCheck found a vulnerability
+	// at or above its BlockAt severity in the script's dependencies, and
+	// the script was not run.
+	FailureVulnerableDependency FailureReason = "vulnerable_dependency"
+	// FailureWorkerLost: the entrypoint went ExecConfig.HeartbeatTimeout
+	// without a heartbeat and the job was given up; the script may not
+	// be at fault, see RetryPolicy.RetryWorkerLost.
+	FailureWorkerLost FailureReason = "worker_lost"
+)
+
+// Canonical returns the reason f is recorded as today: FailureRunTimeout
+// for the FailureTimeout of earlier records, f otherwise.
+func (f FailureReason) Canonical() FailureReason {
+	if f == FailureTimeout {
+		return FailureRunTimeout
+	}
+	return f
+}
+
+// exitStatusLine is printed by `go run` after the program fails.
+var exitStatusLine = regexp.MustCompile(`^exit status \d+$`)
+
+// Exit codes with which the container runtime reports that the command
+// itself could not be run.
+var internalExitCodes = map[int]string{
+	125: "the container runtime failed to run the script",
+	126: "the script command cannot be invoked",
+	127: "the script command was not found in the runner image",
+}
+
+// evidenceNotFound is the error of sandbox.OpenEvidence for a missing file.
+const evidenceNotFound = "evidence not found: "
+
+// evidenceReadFailed is the error of sandbox.OpenEvidence for a read of
+// the file that failed, e.g. "evidence read failed at offset 4096 of
+// /evidence/disk.raw after 3 attempts: read /evidence/disk.raw:
+// input/output error".
+var evidenceReadFailed = regexp.MustCompile(`evidence read failed at offset (\d+) of (.+?) after (\d+) attempts?: (.*)$`)
+
+// pythonSyntaxError is the last line Python prints when the script itself
+// does not parse; without a traceback, unlike a runtime SyntaxError.
+var pythonSyntaxError = regexp.MustCompile(`^(?:SyntaxError|IndentationError|TabError): `)
+
+// javacError is a compile error of javac, e.g.
+// "./Main.java:12: error: ';' expected".
+var javacError = regexp.MustCompile(`^\S+\.java:\d+: error: `)
+
+// failure returns the reason res failed and a detail for display, or ""
+// for a successful job.
+func failure(job Job, cfg ExecConfig, res *JobResult) (FailureReason, string) {
+	switch {
+	case res.Success:
+		return "", ""
+	case res.NotApplicable:
+		return FailureNotApplicable, "skipped: not applicable: " + res.NotApplicableReason
+	case res.BuildTimedOut:
+		return FailureCompileTimeout, fmt.Sprintf("build stopped after the %s build timeout", cfg.buildTimeout())
+	case res.TimedOut:
+		return FailureRunTimeout, fmt.Sprintf("stopped after the %s run timeout", cfg.runTimeout())
+	case res.OOMKilled:
+		return FailureOOMKilled, fmt.Sprintf("exceeded the %d MiB memory limit", cfg.memoryLimit()>>20)
+	}
+	if s := res.JobStatus; s != nil && s.ContractError != "" {
+		return FailureInternalError, "the container does not meet the environment contract: " + s.ContractError
+	}
+	// A tool missing from the shell image also exits with code 127, but
+	// the script is at fault.
+	if f := res.ShellFailure; f != nil {
+		return FailureNonZeroExit, fmt.Sprintf("%s:%d: %s exited with code %d", f.File, f.Line, f.Command, f.ExitCode)
+	}
+	if msg, ok := internalExitCodes[res.ExitCode]; ok {
+		return FailureInternalError, fmt.Sprintf("%s (exit code %d)", msg, res.ExitCode)
+	}
+	if detail := compileFailure(job.Language, res.Stderr); detail != "" {
+		return FailureCompileError, detail
+	}
+	for _, line := range strings.Split(res.Stderr, "\n") {
+		if i := strings.Index(line, evidenceNotFound); i >= 0 {
+			return FailureEvidenceMissing, strings.TrimSpace(line[i:])
+		}
+	}
+	// A parser may panic on the error; the storage is still at fault.
+	if f := res.EvidenceRead; f != nil {
+		return FailureEvidenceReadFailed, f.String()
+	}
+	switch {
+	case res.Panic != nil && res.Panic.Recovered:
+		return FailureCompletedWithPanic, "completed with panic: " + res.Panic.Message
+	case res.Panic != nil:
+		return FailureNonZeroExit, "panic: " + res.Panic.Message
+	case res.Signal != "":
+		return FailureNonZeroExit, fmt.Sprintf("killed by %s (exit code %d)", res.Signal, res.ExitCode)
+	}
+	return FailureNonZeroExit, fmt.Sprintf("exited with code %d", res.ExitCode)
+}
+
+// compileFailure returns the first compile error in the stderr of a job
+// run with `go run`, `cargo run`, python or javac, or "" if it compiled.
+func compileFailure(language, stderr string) string {
+	switch languageKey(language) {
+	case LanguageGo:
+		diags, unresolved := parseBuildOutput(stderr)
+		if len(unresolved) > 0 {
+			return "unresolved import " + unresolved[0]
+		}
+		// The toolchain prints "# package" before type errors; a program
+		// logging with log.Lshortfile prints lines that look like
+		// diagnostics without it.
+		if len(diags) > 0 && strings.Contains("\n"+stderr, "\n# ") {
+			return diags[0].String()
+		}
+	case LanguageRust:
+		if !strings.Contains(stderr, "error: could not compile") {
+			return ""
+		}
+		for _, line := range strings.Split(stderr, "\n") {
+			if strings.HasPrefix(line, "error") {
+				return strings.TrimSpace(line)
+			}
+		}
+	case LanguagePython:
+		if strings.Contains(stderr, "Traceback (most recent call last):") {
+			return ""
+		}
+		for _, line := range strings.Split(stderr, "\n") {
+			if pythonSyntaxError.MatchString(line) {
+				return strings.TrimSpace(line)
+			}
+		}
+	case LanguageJava:
+		for _, line := range strings.Split(stderr, "\n") {
+			if javacError.MatchString(line) {
+				return strings.TrimSpace(strings.TrimPrefix(line, "./"))
+			}
+		}
+	}
+	return ""
+}
+
+// stderrTail returns the last n lines of stderr.
+func stderrTail(stderr string, n int) []string {
+	lines := strings.Split(strings.TrimRight(stderr, "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// extractPanic finds the first Go panic in stderr and its stack trace.
+func extractPanic(stderr string) *PanicInfo {
+	lines := strings.Split(stderr, "\n")
+	for i, line := range lines {
+		msg, ok := strings.CutPrefix(line, "panic: ")
+		if !ok {
+			continue
+		}
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			if exitStatusLine.MatchString(lines[j]) {
+				end = j
+				break
+			}
+		}
+		return &PanicInfo{
+			Message: strings.TrimSuffix(msg, " [recovered]"),
+			Stack:   strings.TrimRight(strings.Join(lines[i:end], "\n"), "\n"),
+		}
+	}
+	return nil
+}
+
+// takePanic removes the sandbox.PanicFile that sandbox.Recover left in dir
+// and returns the panic it records, nil when there is none or it cannot
+// be read.
+func takePanic(dir string) *PanicInfo {
+	rec, err := sandbox.ReadPanic(dir)
+	os.Remove(filepath.Join(dir, sandbox.PanicFile))
+	if err != nil || rec == nil {
+		return nil
+	}
+	return &PanicInfo{Message: rec.Message, Stack: strings.TrimRight(rec.Stack, "\n"), Recovered: true}
+}
+
+// takeNotApplicable returns the record sandbox.SkipNotApplicable left in
+// dir, which is not an output of the job, and removes it.
+func takeNotApplicable(dir string) *sandbox.NotApplicable {
+	rec, err := sandbox.ReadNotApplicable(dir)
+	os.Remove(filepath.Join(dir, sandbox.NotApplicableFile))
+	if err != nil {
+		return nil
+	}
+	return rec
+}
+
+// shellFailureLine is the line bashRunner prints for a failed command.
+var shellFailureLine = regexp.MustCompile(`^` + shellFailureMarker + ` \(exit (\d+)\) at (.+?):(\d+): (.*)$`)
+
+// extractShellFailure finds the first command reported failed in the
+// stderr of a bash job: the innermost one, when a command substitution
+// or a function fails.
+func extractShellFailure(stderr string) *ShellFailure {
+	for _, line := range strings.Split(stderr, "\n") {
+		m := shellFailureLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		code, _ := strconv.Atoi(m[1])
+		n, _ := strconv.Atoi(m[3])
+		return &ShellFailure{File: strings.TrimPrefix(m[2], "./"), Line: n, Command: m[4], ExitCode: code}
+	}
+	return nil
+}
+
+// extractEvidenceRead finds the first failed read of the evidence reported
+// in stderr.
+func extractEvidenceRead(stderr string) *EvidenceReadFailure {
+	for _, line := range strings.Split(stderr, "\n") {
+		m := evidenceReadFailed.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		off, _ := strconv.ParseInt(m[1], 10, 64)
+		attempts, _ := strconv.Atoi(m[3])
+		return &EvidenceReadFailure{Path: m[2], Offset: off, Attempts: attempts, Err: m[4]}
+	}
+	return nil
+}
+
+// writeJobLogs stores the job's output, as capped by ExecConfig.MaxLogBytes,
+// in dir.
+func writeJobLogs(dir, stdout, stderr string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, data := range map[string]string{StdoutLogFile: stdout, StderrLogFile: stderr} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) stderrTailLines() int {
+	if r.StderrTailLines <= 0 {
+		return DefaultStderrTailLines
+	}
+	return r.StderrTailLines
+}
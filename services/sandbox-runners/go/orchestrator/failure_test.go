@@ -0,0 +1,246 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+const panicStderr = `go: downloading github.com/Velocidex/ordereddict v0.0.0
+panic: runtime error: index out of range [5] with length 0
+
+goroutine 1 [running]:
+main.main()
+	/workspace/main.go:8 +0x4a
+exit status 2
+`
+
+func TestRunExtractsPanic(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 1}, stdout: "parsing\n", stderr: panicStderr}
+	job := testJob(t)
+	job.LogDir = filepath.Join(t.TempDir(), "logs", job.ID)
+
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PanicInfo{
+		Message: "runtime error: index out of range [5] with length 0",
+		Stack: "panic: runtime error: index out of range [5] with length 0\n\n" +
+			"goroutine 1 [running]:\nmain.main()\n\t/workspace/main.go:8 +0x4a",
+	}
+	if !reflect.DeepEqual(res.Panic, want) {
+		t.Errorf("panic = %+v, want %+v", res.Panic, want)
+	}
+	if last := res.StderrTail[len(res.StderrTail)-1]; len(res.StderrTail) != 7 || last != "exit status 2" {
+		t.Errorf("stderr tail = %q", res.StderrTail)
+	}
+	for name, want := range map[string]string{StdoutLogFile: "parsing\n", StderrLogFile: panicStderr} {
+		if data, err := os.ReadFile(filepath.Join(job.LogDir, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestRunKeepsFindingsOfRecoveredPanic(t *testing.T) {
+	stack := "goroutine 1 [running]:\nmain.parse()\n\t/workspace/main.go:21 +0x4a"
+	rt := &fakeRuntime{
+		// go run exits 1 whatever the exit code of the script.
+		state:  ContainerState{ExitCode: 1},
+		stderr: "panic: runtime error: index out of range [5] with length 0 [recovered]\n\n" + stack + "\nexit status 70\n",
+		onStart: func(spec ContainerSpec) {
+			for _, m := range spec.Mounts {
+				if m.Target != containerOutputDir {
+					continue
+				}
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"evidence_uid":"ev-1","severity":"high","title":"before the panic"}`+"\n"), 0o644)
+				rec, _ := json.Marshal(sandbox.PanicRecord{EvidenceUID: "ev-1", Message: "runtime error: index out of range [5] with length 0", Stack: stack + "\n"})
+				os.WriteFile(filepath.Join(m.Source, sandbox.PanicFile), rec, 0o644)
+			}
+		},
+	}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &PanicInfo{Message: "runtime error: index out of range [5] with length 0", Stack: stack, Recovered: true}
+	if !reflect.DeepEqual(res.Panic, want) {
+		t.Errorf("panic = %+v, want %+v", res.Panic, want)
+	}
+	if res.FailureReason != FailureCompletedWithPanic || res.FailureDetail != "completed with panic: "+want.Message || !res.Incomplete {
+		t.Errorf("failure %q: %q, incomplete %v", res.FailureReason, res.FailureDetail, res.Incomplete)
+	}
+	if len(res.Findings) != 1 || res.Findings[0].Title != "before the panic" {
+		t.Errorf("findings = %+v, want the one emitted before the panic", res.Findings)
+	}
+	for _, o := range res.Outputs {
+		if o == sandbox.PanicFile {
+			t.Errorf("%s listed in the outputs", sandbox.PanicFile)
+		}
+	}
+}
+
+func TestRunRecordsNotApplicable(t *testing.T) {
+	rt := &fakeRuntime{
+		state:  ContainerState{ExitCode: 1},
+		stderr: "sandbox: not applicable: not a registry hive\nexit status 3\n",
+		onStart: func(spec ContainerSpec) {
+			for _, m := range spec.Mounts {
+				if m.Target == containerOutputDir {
+					rec, _ := json.Marshal(sandbox.NotApplicable{EvidenceUID: "ev-1", Reason: "not a registry hive"})
+					os.WriteFile(filepath.Join(m.Source, sandbox.NotApplicableFile), rec, 0o644)
+				}
+			}
+		},
+	}
+	r := NewRunner(rt)
+	r.Jobs = NewJobIndex()
+	job := testJob(t)
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || !res.NotApplicable || res.NotApplicableReason != "not a registry hive" {
+		t.Errorf("success %v, not applicable %v: %q", res.Success, res.NotApplicable, res.NotApplicableReason)
+	}
+	if res.FailureReason != FailureNotApplicable || res.FailureDetail != "skipped: not applicable: not a registry hive" {
+		t.Errorf("failure %q: %q", res.FailureReason, res.FailureDetail)
+	}
+	if len(res.Outputs) != 0 {
+		t.Errorf("outputs %v", res.Outputs)
+	}
+	if p := callbackPayload(job, res); p.Status != CallbackSkipped {
+		t.Errorf("callback status %q", p.Status)
+	}
+	recs := r.Jobs.Query(JobFilter{})
+	if len(recs) != 1 || !recs[0].NotApplicable || recs[0].NotApplicableReason != "not a registry hive" {
+		t.Errorf("job records %+v", recs)
+	}
+	if failed := r.Jobs.Query(JobFilter{Failed: true}); len(failed) != 0 {
+		t.Errorf("not applicable job listed as failed: %+v", failed)
+	}
+
+	// A job killed over its memory limit did not skip its evidence,
+	// whatever it wrote.
+	rt.state = ContainerState{ExitCode: 137, OOMKilled: true}
+	job.ID = "job-2"
+	if res, err := r.Run(context.Background(), job); err != nil || res.NotApplicable || res.FailureReason != FailureOOMKilled {
+		t.Errorf("OOM-killed job: %+v, %v", res, err)
+	}
+}
+
+func TestRunStderrTailOnlyOnFailure(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	rt := &fakeRuntime{stderr: b.String()}
+	r := NewRunner(rt)
+	r.StderrTailLines = 3
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StderrTail != nil || res.Panic != nil {
+		t.Errorf("successful job has tail %q, panic %v", res.StderrTail, res.Panic)
+	}
+
+	rt.state = ContainerState{ExitCode: 3}
+	res, err = r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"line 98", "line 99", "line 100"}; !reflect.DeepEqual(res.StderrTail, want) {
+		t.Errorf("stderr tail = %q, want %q", res.StderrTail, want)
+	}
+	if res.Panic != nil {
+		t.Errorf("panic = %+v without a panic line", res.Panic)
+	}
+}
+
+func TestRunFailureReason(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string
+		exitCode   int
+		stderr     string
+		wantReason FailureReason
+		wantDetail string
+	}{
+		{"success", "", 0, "", "", ""},
+		{"exit code", "", 3, "", FailureNonZeroExit, "exited with code 3"},
+		{"signal", "", 139, "", FailureNonZeroExit, "killed by SIGSEGV (exit code 139)"},
+		{"panic", "", 2, panicStderr, FailureNonZeroExit, "panic: runtime error: index out of range [5] with length 0"},
+		{"go compile error", "", 1, "# datamortem/parser\n./main.go:3:2: undefined: x\n", FailureCompileError, "main.go:3:2: undefined: x"},
+		{"go log line", "", 1, "main.go:12: bad record\n", FailureNonZeroExit, "exited with code 1"},
+		{"go unresolved import", "go", 1, "main.go:4:2: no required module provides package github.com/x/y; to add it:\n", FailureCompileError, "unresolved import github.com/x/y"},
+		{"rust compile error", "rust", 101, "error[E0425]: cannot find value `x` in this scope\nerror: could not compile `parser`\n", FailureCompileError, "error[E0425]: cannot find value `x` in this scope"},
+		{"python syntax error", "python", 1, "  File \"/workspace/script.py\", line 3\n    def\n       ^\nSyntaxError: invalid syntax\n", FailureCompileError, "SyntaxError: invalid syntax"},
+		{"java compile error", "java", 1, "./Main.java:12: error: ';' expected\n        int n = 0\n                 ^\n1 error\n", FailureCompileError, "Main.java:12: error: ';' expected"},
+		{"python runtime syntax error", "python", 1, "Traceback (most recent call last):\n  File \"/workspace/script.py\", line 9, in <module>\nSyntaxError: bad input\n", FailureNonZeroExit, "exited with code 1"},
+		{"evidence missing", "", 1, "open: sandbox: evidence not found: /evidence/disk.raw\nexit status 1\n", FailureEvidenceMissing, "evidence not found: /evidence/disk.raw"},
+		{"evidence read failed", "", 2, "panic: sandbox: evidence read failed at offset 8192 of /evidence/disk.raw after 3 attempts: read /evidence/disk.raw: input/output error\n", FailureEvidenceReadFailed,
+			"evidence read failed at offset 8192 of /evidence/disk.raw after 3 attempts: read /evidence/disk.raw: input/output error"},
+		{"command not found", "", 127, "exec: \"go\": not found\n", FailureInternalError, "the script command was not found in the runner image (exit code 127)"},
+		{"bash command", "bash", 1, "datamortem: command failed (exit 1) at ./script.sh:7: grep -q MZ /evidence/disk.raw\n", FailureNonZeroExit, "script.sh:7: grep -q MZ /evidence/disk.raw exited with code 1"},
+		{"bash tool missing", "bash", 127, "script.sh: line 3: tshark: not found\ndatamortem: command failed (exit 127) at ./script.sh:3: tshark -r /evidence/c.pcap\n", FailureNonZeroExit, "script.sh:3: tshark -r /evidence/c.pcap exited with code 127"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &fakeRuntime{state: ContainerState{ExitCode: tt.exitCode}, stderr: tt.stderr}
+			job := testJob(t)
+			job.Language = tt.language
+
+			res, err := NewRunner(rt).Run(context.Background(), job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.FailureReason != tt.wantReason || res.FailureDetail != tt.wantDetail {
+				t.Errorf("failure = %q: %q, want %q: %q", res.FailureReason, res.FailureDetail, tt.wantReason, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestBashRunnerReportsFailingCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not in PATH")
+	}
+	for _, tc := range []struct {
+		name, script string
+		want         *ShellFailure
+	}{
+		{"function", "echo start\nparse() {\n\tgrep -q MZ \"$1\"\n}\nparse /dev/null\necho unreachable\n",
+			&ShellFailure{File: "script.sh", Line: 3, Command: `grep -q MZ "$1"`, ExitCode: 1}},
+		{"pipeline", "cat /nonexistent | wc -l\n",
+			&ShellFailure{File: "script.sh", Line: 1, Command: "wc -l", ExitCode: 1}},
+		{"explicit exit", "echo $0\nexit 4\n", nil},
+	} {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "script.sh"), []byte(tc.script), 0o644)
+		p, _ := profile(LanguageBash)
+		cmd := exec.Command(p.Cmd[0], p.Cmd[1:]...)
+		cmd.Dir = dir
+		var stdout, stderr strings.Builder
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err == nil {
+			t.Errorf("%s: script succeeded", tc.name)
+		}
+		if strings.Contains(stdout.String(), "unreachable") {
+			t.Errorf("%s: the script went on after a failure", tc.name)
+		}
+		if got := extractShellFailure(stderr.String()); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: failure = %+v, want %+v (stderr %q)", tc.name, got, tc.want, stderr.String())
+		}
+	}
+}
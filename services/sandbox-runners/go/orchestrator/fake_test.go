@@ -0,0 +1,331 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// fakeRuntime records container specs and plays back a scripted outcome.
+type fakeRuntime struct {
+	mu         sync.Mutex
+	specs      []ContainerSpec
+	containers map[string]*fakeContainer
+	removed    []string
+	signals    []string
+
+	state  ContainerState
+	stdout string
+	stderr string
+	// block keeps containers running until they are killed.
+	block bool
+	// ignoreTerm makes blocked containers survive SIGTERM.
+	ignoreTerm bool
+	// unreachable makes Kill and Logs block until their context is done,
+	// as an engine on a dead host would.
+	unreachable bool
+	// onStart runs when a container starts, e.g. to write outputs.
+	onStart func(spec ContainerSpec)
+	// onTerm runs when a running container is sent SIGTERM, as a
+	// script's shutdown handlers would.
+	onTerm func(spec ContainerSpec)
+	// outcome, when set, scripts the final state and stderr of each
+	// container instead of state and stderr.
+	outcome func(spec ContainerSpec) (ContainerState, string)
+
+	// down fails InspectImage, Create and Runtimes, as an engine whose
+	// daemon is stopped would.
+	down bool
+
+	// createErrs are returned by the first Create calls, in order.
+	createErrs []error
+
+	execs []ExecSpec
+	// execCode is the exit code of exec'd commands.
+	execCode int
+	// execBlock keeps exec'd jobs running until their context is done,
+	// but not the pool's own scripts.
+	execBlock bool
+	// onExec runs when a command is exec'd in container id.
+	onExec func(id string, spec ExecSpec)
+	// attached is the input Attach read, by container.
+	attached map[string]string
+
+	// images describes the images InspectImage knows of; others get
+	// fakeImageID. platformImages describes the variants of
+	// multi-architecture images, by image and platform.
+	images         map[string]ImageInfo
+	platformImages map[string]map[string]ImageInfo
+	// platforms are the platforms InspectImage was asked for.
+	platforms []string
+	// runtimes lists the OCI runtimes of the engine; "runc" when nil.
+	runtimes []string
+	// runtimeCalls counts the calls to Runtimes.
+	runtimeCalls int
+
+	// builds records the images built; buildErr fails the builds.
+	builds   []ImageBuildSpec
+	buildErr error
+}
+
+// fakeImageID is the ID fakeRuntime gives image.
+func fakeImageID(image string) string {
+	sum := sha256.Sum256([]byte(image))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// errEngineDown is the error of a fakeRuntime that is down.
+var errEngineDown = errors.New("Cannot connect to the Docker daemon at unix:///var/run/docker.sock")
+
+// setDown stops or restarts the engine.
+func (f *fakeRuntime) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeRuntime) InspectImage(ctx context.Context, image, platform string) (ImageInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return ImageInfo{}, errEngineDown
+	}
+	f.platforms = append(f.platforms, platform)
+	if info, ok := f.platformImages[image][platform]; ok {
+		return info, nil
+	}
+	if info, ok := f.images[image]; ok {
+		return info, nil
+	}
+	return ImageInfo{ID: fakeImageID(image)}, nil
+}
+
+func (f *fakeRuntime) BuildImage(ctx context.Context, spec ImageBuildSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.builds = append(f.builds, spec)
+	return f.buildErr
+}
+
+func (f *fakeRuntime) Runtimes(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runtimeCalls++
+	if f.down {
+		return nil, errEngineDown
+	}
+	if f.runtimes == nil {
+		return []string{"runc"}, nil
+	}
+	return f.runtimes, nil
+}
+
+type fakeContainer struct {
+	spec   ContainerSpec
+	done   chan struct{}
+	state  ContainerState
+	stderr string
+}
+
+func (f *fakeRuntime) Create(ctx context.Context, spec ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return "", errEngineDown
+	}
+	if len(f.createErrs) > 0 {
+		err := f.createErrs[0]
+		f.createErrs = f.createErrs[1:]
+		return "", err
+	}
+	if f.containers == nil {
+		f.containers = map[string]*fakeContainer{}
+	}
+	f.specs = append(f.specs, spec)
+	id := fmt.Sprintf("c%d", len(f.specs))
+	c := &fakeContainer{spec: spec, done: make(chan struct{}), state: f.state, stderr: f.stderr}
+	if f.outcome != nil {
+		c.state, c.stderr = f.outcome(spec)
+	}
+	f.containers[id] = c
+	return id, nil
+}
+
+func (f *fakeRuntime) container(id string) *fakeContainer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.containers[id]
+}
+
+func (f *fakeRuntime) Start(ctx context.Context, id string) error {
+	c := f.container(id)
+	if f.onStart != nil {
+		f.onStart(c.spec)
+	}
+	if !f.block {
+		close(c.done)
+	}
+	return nil
+}
+
+func (f *fakeRuntime) Wait(ctx context.Context, id string) (ContainerState, error) {
+	c := f.container(id)
+	select {
+	case <-c.done:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return c.state, nil
+	case <-ctx.Done():
+		return ContainerState{}, ctx.Err()
+	}
+}
+
+func (f *fakeRuntime) Kill(ctx context.Context, id, signal string) error {
+	if f.unreachable {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c := f.container(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signals = append(f.signals, signal)
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if signal == "SIGTERM" && f.onTerm != nil {
+		f.onTerm(c.spec)
+	}
+	switch {
+	case signal == "SIGKILL":
+		c.state = ContainerState{ExitCode: 137}
+	case signal == "SIGTERM" && !f.ignoreTerm:
+		c.state = ContainerState{ExitCode: 143}
+	default:
+		return nil
+	}
+	close(c.done)
+	return nil
+}
+
+func (f *fakeRuntime) Logs(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	if f.unreachable {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	io.WriteString(stdout, f.stdout)
+	io.WriteString(stderr, f.container(id).stderr)
+	return nil
+}
+
+// Follow replays the scripted output in small chunks so that lines straddle
+// write boundaries, then waits for the container to exit.
+func (f *fakeRuntime) Follow(ctx context.Context, id string, stdout, stderr io.Writer) error {
+	c := f.container(id)
+	for _, out := range []struct {
+		w    io.Writer
+		text string
+	}{{stdout, f.stdout}, {stderr, f.stderr}} {
+		for i := 0; i < len(out.text); i += 3 {
+			end := i + 3
+			if end > len(out.text) {
+				end = len(out.text)
+			}
+			if _, err := io.WriteString(out.w, out.text[i:end]); err != nil {
+				return err
+			}
+		}
+	}
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *fakeRuntime) Exec(ctx context.Context, id string, spec ExecSpec, stdout, stderr io.Writer) (int, error) {
+	f.mu.Lock()
+	f.execs = append(f.execs, spec)
+	f.mu.Unlock()
+	if f.onExec != nil {
+		f.onExec(id, spec)
+	}
+	if f.execBlock && jobExec(spec) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	io.WriteString(stdout, f.stdout)
+	io.WriteString(stderr, f.stderr)
+	return f.execCode, nil
+}
+
+// jobExec reports whether spec runs a job in a pooled container, rather
+// than one of the pool's reset or terminate scripts, which get no
+// environment.
+func jobExec(spec ExecSpec) bool {
+	return len(spec.Env) > 0
+}
+
+// Attach reads stdin to its end, then exits the container as a shell would
+// at the end of its input.
+func (f *fakeRuntime) Attach(ctx context.Context, id string, stdin io.Reader) error {
+	data, err := io.ReadAll(stdin)
+	c := f.container(id)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.attached == nil {
+		f.attached = map[string]string{}
+	}
+	f.attached[id] = string(data)
+	if !c.spec.Interactive {
+		return fmt.Errorf("container %s is not interactive", id)
+	}
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	return err
+}
+
+// hostPath returns the host directory mounted at target in container id.
+func (f *fakeRuntime) hostPath(id, target string) string {
+	for _, m := range f.container(id).spec.Mounts {
+		if m.Target == target {
+			return m.Source
+		}
+	}
+	return ""
+}
+
+func (f *fakeRuntime) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, id)
+	return nil
+}
+
+func (f *fakeRuntime) Containers(ctx context.Context, label string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := map[string]string{}
+	for id, c := range f.containers {
+		if v, ok := c.spec.Labels[label]; ok && !slices.Contains(f.removed, id) {
+			out[id] = v
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeRuntime) lastSpec() ContainerSpec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.specs[len(f.specs)-1]
+}
@@ -0,0 +1,315 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fanOutRetry is how long a fan-out waits before submitting again to a
+// full queue when none of its own jobs is in flight to free a place.
+const fanOutRetry = 100 * time.Millisecond
+
+// JobSubmitter queues jobs; WorkerPool and Scheduler implement it.
+type JobSubmitter interface {
+	Submit(ctx context.Context, job Job) (*QueuedJob, error)
+}
+
+var (
+	_ JobSubmitter = (*WorkerPool)(nil)
+	_ JobSubmitter = (*Scheduler)(nil)
+)
+
+// FanOutRequest runs one script against every evidence item of a case.
+type FanOutRequest struct {
+	// Job is the template of the child jobs, its Evidence and
+	// ExtraEvidence ignored. Each child gets one item of Evidence, the ID
+	// "<Job.ID>-<evidence UID>" with Job.ID as its ParentID, and, as
+	// OutputDir and LogDir, subdirectories of Job's named after the
+	// evidence UID.
+	Job Job
+	// Evidence lists the evidence items of the case. Those of a type or a
+	// size the script does not accept, see ReadAcceptedTypes and
+	// ReadEvidenceSizeLimits, are skipped.
+	Evidence []Evidence
+	// MaxInFlight bounds the children queued or running at once, so that
+	// a large case leaves room for other jobs; zero is only bounded by
+	// the queue of the submitter.
+	MaxInFlight int
+}
+
+// FanOutStatus is the state of a child of a fan-out.
+type FanOutStatus string
+
+// Statuses of FanOutChild.
+const (
+	// FanOutWaiting children are not submitted yet, for MaxInFlight or a
+	// full queue; FanOutSubmitted ones are queued or running.
+	FanOutWaiting   FanOutStatus = "waiting"
+	FanOutSubmitted FanOutStatus = "submitted"
+	FanOutSucceeded FanOutStatus = "succeeded"
+	FanOutFailed    FanOutStatus = "failed"
+	FanOutCancelled FanOutStatus = "cancelled"
+	// FanOutSkipped children are not run, as the script does not accept
+	// the type or the size of their evidence, or their script found the
+	// evidence not applicable: see JobResult.NotApplicable.
+	FanOutSkipped FanOutStatus = "skipped"
+)
+
+// finished reports whether s is final.
+func (s FanOutStatus) finished() bool {
+	return s != FanOutWaiting && s != FanOutSubmitted
+}
+
+// FanOutChild is the job of a fan-out for one evidence item.
+type FanOutChild struct {
+	EvidenceUID string
+	JobID       string
+	Status      FanOutStatus
+	// Result is the job's, once it ran.
+	Result *JobResult
+	// Err explains a child skipped, or failed without a result.
+	Err error
+	job Job
+}
+
+// FanOut is a fan-out started by StartFanOut, tracked as a single parent
+// job.
+type FanOut struct {
+	ID     string
+	CaseID string
+	cancel context.CancelFunc
+	done   chan struct{}
+	// freed is signalled when a child in flight finishes.
+	freed chan struct{}
+
+	mu       sync.Mutex
+	children []FanOutChild
+}
+
+// StartFanOut submits a child job to s for each evidence item of req that
+// the script accepts, as places free up in its queue, and returns at once.
+// Cancelling ctx, or calling Cancel, cancels the children still queued or
+// running and those not yet submitted.
+func StartFanOut(ctx context.Context, s JobSubmitter, req FanOutRequest) (*FanOut, error) {
+	tmpl := req.Job
+	if tmpl.ID == "" || tmpl.CaseID == "" {
+		return nil, errors.New("orchestrator: fan-out needs a job ID and a case ID")
+	}
+	if tmpl.Workspace == "" || tmpl.OutputDir == "" {
+		return nil, errors.New("orchestrator: workspace and output directory are required")
+	}
+	accepted, err := ReadAcceptedTypes(tmpl.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := ReadEvidenceSizeLimits(tmpl.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.RunTime = runTime(tmpl)
+	f := &FanOut{ID: tmpl.ID, CaseID: tmpl.CaseID, done: make(chan struct{}), freed: make(chan struct{}, len(req.Evidence))}
+	seen := map[string]bool{}
+	for _, ev := range req.Evidence {
+		if ev.UID == "" || ev.UID == "." || ev.UID == ".." || strings.ContainsAny(ev.UID, `/\`) {
+			return nil, fmt.Errorf("orchestrator: fan-out %s: invalid evidence UID %q", tmpl.ID, ev.UID)
+		}
+		if seen[ev.UID] {
+			return nil, fmt.Errorf("orchestrator: fan-out %s: evidence %s is listed twice", tmpl.ID, ev.UID)
+		}
+		seen[ev.UID] = true
+		job := tmpl
+		job.ID = tmpl.ID + "-" + ev.UID
+		job.ParentID = tmpl.ID
+		job.ExtraEvidence = nil
+		job.OutputDir = filepath.Join(tmpl.OutputDir, ev.UID)
+		if tmpl.LogDir != "" {
+			job.LogDir = filepath.Join(tmpl.LogDir, ev.UID)
+		}
+		if ev.Type == "" && ev.Path != "" {
+			ev.Type, _ = DetectEvidenceType(ev)
+		}
+		job.Evidence = ev
+		child := FanOutChild{EvidenceUID: ev.UID, JobID: job.ID, Status: FanOutWaiting, job: job}
+		if typ := strings.ToLower(ev.Type); typ != "" && len(accepted) > 0 && !slices.Contains(accepted, typ) {
+			child.Status = FanOutSkipped
+			child.Err = fmt.Errorf("%w: evidence %s is %s, the script accepts %s", ErrEvidenceTypeNotAccepted, ev.UID, typ, strings.Join(accepted, ", "))
+		} else if err := limits.check(ev); err != nil {
+			child.Status, child.Err = FanOutSkipped, err
+		} else if err := os.MkdirAll(job.OutputDir, 0o755); err != nil {
+			return nil, fmt.Errorf("orchestrator: fan-out %s: %w", tmpl.ID, err)
+		}
+		f.children = append(f.children, child)
+	}
+	ctx, f.cancel = context.WithCancel(ctx)
+	go f.dispatch(ctx, s, req.MaxInFlight)
+	return f, nil
+}
+
+// dispatch submits the waiting children in order, then waits for those in
+// flight.
+func (f *FanOut) dispatch(ctx context.Context, s JobSubmitter, maxInFlight int) {
+	defer close(f.done)
+	defer f.cancel()
+	var wg sync.WaitGroup
+	inFlight := 0
+	for i := range f.children {
+		if f.children[i].Status != FanOutWaiting {
+			continue
+		}
+		for {
+			if maxInFlight > 0 && inFlight >= maxInFlight {
+				if !f.awaitFreed(ctx, nil) {
+					break
+				}
+				inFlight--
+				continue
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			q, err := s.Submit(ctx, f.children[i].job)
+			if errors.Is(err, ErrQueueFull) {
+				// Wait for one of ours to finish, or for others' to.
+				var retry <-chan time.Time
+				if inFlight == 0 {
+					retry = time.After(fanOutRetry)
+				}
+				if f.awaitFreed(ctx, retry) {
+					inFlight--
+				}
+				continue
+			}
+			if err != nil {
+				f.finish(i, nil, err)
+				break
+			}
+			f.setStatus(i, FanOutSubmitted)
+			inFlight++
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				res, err := q.Wait()
+				f.finish(i, res, err)
+				f.freed <- struct{}{}
+			}(i)
+			break
+		}
+	}
+	wg.Wait()
+	// Children left waiting were cancelled before their submission.
+	f.mu.Lock()
+	for i := range f.children {
+		if f.children[i].Status == FanOutWaiting {
+			f.children[i].Status, f.children[i].Err = FanOutCancelled, ctx.Err()
+		}
+	}
+	f.mu.Unlock()
+}
+
+// awaitFreed waits for a child in flight to finish, reporting true, or for
+// retry or ctx, reporting false.
+func (f *FanOut) awaitFreed(ctx context.Context, retry <-chan time.Time) bool {
+	select {
+	case <-f.freed:
+		return true
+	case <-retry:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (f *FanOut) setStatus(i int, s FanOutStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.children[i].Status = s
+}
+
+// finish records the outcome of child i.
+func (f *FanOut) finish(i int, res *JobResult, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := &f.children[i]
+	c.Result, c.Err = res, err
+	switch {
+	case res == nil && evidenceSkipped(err):
+		c.Status = FanOutSkipped
+	case res == nil && (errors.Is(err, context.Canceled) || errors.Is(err, ErrWorkerPoolClosed)):
+		c.Status = FanOutCancelled
+	case res == nil:
+		c.Status = FanOutFailed
+	case res.Cancelled:
+		c.Status = FanOutCancelled
+	case res.NotApplicable:
+		c.Status = FanOutSkipped
+	case res.Success:
+		c.Status = FanOutSucceeded
+	default:
+		c.Status = FanOutFailed
+	}
+}
+
+// Children returns the state of every child, in the order of the
+// request's evidence.
+func (f *FanOut) Children() []FanOutChild {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.children)
+}
+
+// Cancel cancels the children that have not finished.
+func (f *FanOut) Cancel() {
+	f.cancel()
+}
+
+// Done is closed once every child has finished.
+func (f *FanOut) Done() <-chan struct{} { return f.done }
+
+// Wait blocks until every child has finished and returns the aggregated
+// result.
+func (f *FanOut) Wait() (*FanOutResult, error) {
+	<-f.done
+	return f.Result()
+}
+
+// FanOutResult aggregates the children of a fan-out.
+type FanOutResult struct {
+	ID       string
+	Children []FanOutChild
+	// Counts tallies the children by status.
+	Counts map[FanOutStatus]int
+	// Findings and IOCs merge those of the children that ran.
+	Findings *CaseFindings
+	IOCs     *CaseIOCs
+}
+
+// Result returns the aggregate of the children finished so far.
+func (f *FanOut) Result() (*FanOutResult, error) {
+	res := &FanOutResult{
+		ID:       f.ID,
+		Children: f.Children(),
+		Counts:   map[FanOutStatus]int{},
+		Findings: NewCaseFindings(f.CaseID),
+		IOCs:     NewCaseIOCs(f.CaseID),
+	}
+	for _, c := range res.Children {
+		res.Counts[c.Status]++
+		if c.Result == nil || !c.Status.finished() {
+			continue
+		}
+		if err := res.Findings.Add(c.job, c.Result); err != nil {
+			return nil, err
+		}
+		if err := res.IOCs.Add(c.job, c.Result); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// findingRunner reports the same finding for every evidence item and fails
+// on ev-bad.
+type findingRunner struct {
+	mu   sync.Mutex
+	jobs []Job
+}
+
+func (f *findingRunner) Run(ctx context.Context, job Job) (*JobResult, error) {
+	f.mu.Lock()
+	f.jobs = append(f.jobs, job)
+	f.mu.Unlock()
+	uid := job.Evidence.UID
+	return &JobResult{
+		JobID:    job.ID,
+		Success:  uid != "ev-bad",
+		Findings: []sandbox.Result{{EvidenceUID: uid, Severity: sandbox.SeverityHigh, Title: "mimikatz", FindingKey: "tool/mimikatz"}},
+	}, nil
+}
+
+func fanOutRequest(t *testing.T, evidence ...Evidence) FanOutRequest {
+	t.Helper()
+	workspace := t.TempDir()
+	manifest := `{"accepts": ["memory_dump"]}`
+	if err := os.WriteFile(filepath.Join(workspace, RequirementsFile), []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return FanOutRequest{
+		Job:      Job{ID: "fan-1", CaseID: "case-1", Workspace: workspace, OutputDir: t.TempDir()},
+		Evidence: evidence,
+	}
+}
+
+func TestFanOutRunsAcceptedEvidence(t *testing.T) {
+	runner := &findingRunner{}
+	// A single worker without a queue: the fan-out must wait for it.
+	w, _ := NewWorkerPool(runner, WorkerPoolConfig{MaxConcurrent: 1})
+	defer w.Close()
+	req := fanOutRequest(t,
+		Evidence{UID: "ev-1", Type: sandbox.EvidenceTypeMemory},
+		Evidence{UID: "ev-2", Type: sandbox.EvidenceTypePCAP},
+		Evidence{UID: "ev-bad", Type: sandbox.EvidenceTypeMemory},
+		Evidence{UID: "ev-3"},
+	)
+
+	f, err := StartFanOut(context.Background(), w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := f.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []FanOutStatus
+	for _, c := range res.Children {
+		statuses = append(statuses, c.Status)
+	}
+	want := []FanOutStatus{FanOutSucceeded, FanOutSkipped, FanOutFailed, FanOutSucceeded}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if !errors.Is(res.Children[1].Err, ErrEvidenceTypeNotAccepted) {
+		t.Errorf("skipped child err = %v", res.Children[1].Err)
+	}
+	if len(runner.jobs) != 3 {
+		t.Fatalf("ran %d jobs, want 3", len(runner.jobs))
+	}
+	for _, job := range runner.jobs {
+		if job.ParentID != "fan-1" || job.ID != "fan-1-"+job.Evidence.UID || job.OutputDir != filepath.Join(req.Job.OutputDir, job.Evidence.UID) {
+			t.Errorf("child job = %s of %s, output %s", job.ID, job.ParentID, job.OutputDir)
+		}
+		if info, err := os.Stat(job.OutputDir); err != nil || !info.IsDir() {
+			t.Errorf("output dir of %s: %v", job.ID, err)
+		}
+		if job.RunTime.IsZero() || !job.RunTime.Equal(runner.jobs[0].RunTime) {
+			t.Errorf("run time of %s = %v, want that of the fan-out, %v", job.ID, job.RunTime, runner.jobs[0].RunTime)
+		}
+	}
+	if res.Counts[FanOutSucceeded] != 2 || res.Counts[FanOutFailed] != 1 || res.Counts[FanOutSkipped] != 1 {
+		t.Errorf("counts = %v", res.Counts)
+	}
+	findings := res.Findings.Findings()
+	if len(findings) != 1 || findings[0].Count != 3 || !reflect.DeepEqual(findings[0].EvidenceUIDs, []string{"ev-1", "ev-bad", "ev-3"}) {
+		t.Errorf("findings = %+v, want one merged across the evidence", findings)
+	}
+}
+
+func TestFanOutCancel(t *testing.T) {
+	c := &cancellableRunner{*newGatedRunner()}
+	w, _ := NewWorkerPool(c, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 8})
+	defer w.Close()
+	req := fanOutRequest(t,
+		Evidence{UID: "ev-1"}, Evidence{UID: "ev-2"}, Evidence{UID: "ev-3"}, Evidence{UID: "ev-4"},
+	)
+	req.MaxInFlight = 2
+
+	f, err := StartFanOut(context.Background(), w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-c.running
+	// The second child queues behind the first; the others wait for them.
+	deadline := time.Now().Add(5 * time.Second)
+	for w.Metrics().Queued != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if m := w.Metrics(); m.Active != 1 || m.Queued != 1 {
+		t.Errorf("pool has %d active and %d queued jobs, want MaxInFlight 2", m.Active, m.Queued)
+	}
+	var statuses []FanOutStatus
+	for _, child := range f.Children() {
+		statuses = append(statuses, child.Status)
+	}
+	if want := []FanOutStatus{FanOutSubmitted, FanOutSubmitted, FanOutWaiting, FanOutWaiting}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+
+	f.Cancel()
+	res, err := f.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Counts[FanOutCancelled] != 4 {
+		t.Errorf("counts = %v, want every child cancelled", res.Counts)
+	}
+	if r := res.Children[0].Result; r == nil || !r.Cancelled {
+		t.Errorf("running child result = %+v, want cancelled", r)
+	}
+}
+
+func TestStartFanOutRejectsUnsafeUID(t *testing.T) {
+	w, _ := NewWorkerPool(&findingRunner{}, WorkerPoolConfig{MaxConcurrent: 1})
+	defer w.Close()
+	for _, uid := range []string{"", "..", "a/b"} {
+		if _, err := StartFanOut(context.Background(), w, fanOutRequest(t, Evidence{UID: uid})); err == nil {
+			t.Errorf("StartFanOut(%q) succeeded, want an error", uid)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ErrFetchDenied is returned by an EvidenceCatalog to refuse an evidence
+// item to a job; the script gets sandbox.ErrEvidenceFetchDenied.
+var ErrFetchDenied = errors.New("orchestrator: evidence fetch denied")
+
+// EvidenceCatalog resolves the evidence items jobs fetch at run time with
+// sandbox.FetchEvidence.
+type EvidenceCatalog interface {
+	// LookupEvidence returns the evidence item uid, as recorded at
+	// ingestion, and the case it belongs to. It may return ErrFetchDenied
+	// to refuse the item to job.
+	LookupEvidence(ctx context.Context, job Job, uid string) (ev Evidence, caseID string, err error)
+}
+
+// Files of a job's fetch directory on the host.
+const (
+	fetchDirPrefix = "datamortem-fetch-"
+	fetchSocket    = "fetch.sock"
+	fetchRunDir    = "run"
+	fetchEvidence  = "evidence"
+)
+
+// fetchServer answers the sandbox.FetchEvidence requests of one job on a
+// unix socket mounted in its container. Granted items are linked, or
+// copied, into a directory mounted read-only, which the running container
+// sees without a new mount.
+type fetchServer struct {
+	runner  *Runner
+	catalog EvidenceCatalog
+	job     Job
+	dir     string
+	ln      net.Listener
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	fetched []Evidence
+	// granted holds the answer for each item the job can read, its own
+	// evidence included.
+	granted map[string]sandbox.FetchResponse
+}
+
+// startFetchServer listens for the requests of job in a fresh directory
+// under Runner.WorkDir. Lookups run under ctx.
+func (r *Runner) startFetchServer(ctx context.Context, job Job) (*fetchServer, error) {
+	dir, err := r.scratchDir(fetchDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s := &fetchServer{runner: r, catalog: r.EvidenceCatalog, job: job, dir: dir, granted: map[string]sandbox.FetchResponse{}}
+	for i, ev := range job.allEvidence() {
+		if ev.Path != "" {
+			s.granted[ev.UID] = fetchResponse(evidenceTarget(i, ev), ev)
+		}
+	}
+	err = os.Chmod(dir, 0o755)
+	for _, sub := range []string{fetchRunDir, fetchEvidence} {
+		if err == nil {
+			err = os.Mkdir(filepath.Join(dir, sub), 0o755)
+		}
+	}
+	if err == nil {
+		s.ln, err = net.Listen("unix", filepath.Join(dir, fetchRunDir, fetchSocket))
+	}
+	if err == nil {
+		// The script connects as the sandbox user.
+		err = os.Chmod(filepath.Join(dir, fetchRunDir, fetchSocket), 0o777)
+	}
+	if err != nil {
+		if s.ln != nil {
+			s.ln.Close()
+		}
+		r.removeDir(dir)
+		return nil, fmt.Errorf("evidence fetch: %w", err)
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// apply mounts the socket and the fetched evidence into spec.
+func (s *fetchServer) apply(spec *ContainerSpec) {
+	spec.Mounts = append(spec.Mounts,
+		Mount{Source: filepath.Join(s.dir, fetchRunDir), Target: containerFetchDir, ReadOnly: true},
+		Mount{Source: filepath.Join(s.dir, fetchEvidence), Target: containerFetchedDir, ReadOnly: true},
+	)
+	spec.Env[sandbox.EnvEvidenceFetchSocket] = path.Join(containerFetchDir, fetchSocket)
+	if spec.SeccompProfile == defaultSeccompProfile(false) {
+		// The built-in profile of a job without network blocks sockets.
+		spec.SeccompProfile = unixSocketSeccompProfile()
+	}
+}
+
+func (s *fetchServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle answers the requests of one connection, a line each.
+func (s *fetchServer) handle(conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-s.ctx.Done()
+		conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		var req sandbox.FetchRequest
+		var resp sandbox.FetchResponse
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			resp.Error = "malformed request: " + err.Error()
+		} else {
+			resp = s.fetch(req.UID)
+		}
+		if enc.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+// fetch grants the evidence item uid to the job if it belongs to the job's
+// case and the catalog allows it.
+func (s *fetchServer) fetch(uid string) sandbox.FetchResponse {
+	if uid == "" {
+		return sandbox.FetchResponse{Error: "evidence UID is required"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp, ok := s.granted[uid]; ok {
+		return resp
+	}
+	ev, caseID, err := s.catalog.LookupEvidence(s.ctx, s.job, uid)
+	switch {
+	case errors.Is(err, ErrFetchDenied):
+		return sandbox.FetchResponse{Error: err.Error(), Denied: true}
+	case err != nil:
+		return sandbox.FetchResponse{Error: err.Error()}
+	case caseID != s.job.CaseID:
+		return sandbox.FetchResponse{Error: "evidence belongs to another case", Denied: true}
+	case ev.Path == "":
+		return sandbox.FetchResponse{Error: "evidence has no path"}
+	}
+	// Items are numbered rather than named after their UID, which the
+	// script chose.
+	n := strconv.Itoa(len(s.fetched) + 1)
+	if err := os.Mkdir(filepath.Join(s.dir, fetchEvidence, n), 0o755); err != nil {
+		return sandbox.FetchResponse{Error: err.Error()}
+	}
+	dst := filepath.Join(s.dir, fetchEvidence, n, filepath.Base(ev.Path))
+	if err := os.Link(ev.Path, dst); err != nil {
+		if err := copyFile(ev.Path, dst); err != nil {
+			return sandbox.FetchResponse{Error: err.Error()}
+		}
+	}
+	s.fetched = append(s.fetched, ev)
+	resp := fetchResponse(path.Join(containerFetchedDir, n, filepath.Base(ev.Path)), ev)
+	s.granted[uid] = resp
+	return resp
+}
+
+func fetchResponse(target string, ev Evidence) sandbox.FetchResponse {
+	return sandbox.FetchResponse{
+		Path:        target,
+		SHA256:      ev.SHA256,
+		HashAlgo:    ev.HashAlgo,
+		Compression: ev.Compression,
+		Offset:      ev.Offset,
+		Length:      ev.Length,
+	}
+}
+
+// Close stops the server, waits for pending requests and removes the
+// fetched evidence. It returns the items granted to the job and may be
+// called again.
+func (s *fetchServer) Close() []Evidence {
+	if s == nil {
+		return nil
+	}
+	s.closeOnce.Do(func() {
+		s.ln.Close()
+		s.cancel()
+		s.wg.Wait()
+		s.runner.removeDir(s.dir)
+	})
+	return s.fetched
+}
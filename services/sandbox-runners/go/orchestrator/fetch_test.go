@@ -0,0 +1,142 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+type fakeCatalog map[string]struct {
+	ev     Evidence
+	caseID string
+}
+
+func (c fakeCatalog) LookupEvidence(ctx context.Context, job Job, uid string) (Evidence, string, error) {
+	if uid == "ev-secret" {
+		return Evidence{}, "", fmt.Errorf("%w: restricted item", ErrFetchDenied)
+	}
+	e, ok := c[uid]
+	if !ok {
+		return Evidence{}, "", fmt.Errorf("evidence %s not found", uid)
+	}
+	return e.ev, e.caseID, nil
+}
+
+// fetchFrom sends a sandbox.FetchEvidence request on the socket under dir.
+func fetchFrom(t *testing.T, dir, uid string) sandbox.FetchResponse {
+	t.Helper()
+	conn, err := net.Dial("unix", filepath.Join(dir, fetchSocket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	json.NewEncoder(conn).Encode(sandbox.FetchRequest{UID: uid})
+	var resp sandbox.FetchResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRunServesEvidenceFetches(t *testing.T) {
+	mem := filepath.Join(t.TempDir(), "mem.raw")
+	os.WriteFile(mem, []byte("memory"), 0o644)
+	other := Evidence{UID: "ev-9", Path: mem}
+	catalog := fakeCatalog{
+		"ev-2": {Evidence{UID: "ev-2", Path: mem, SHA256: "def456", Compression: sandbox.CompressionZstd}, "case-1"},
+		"ev-9": {other, "case-2"},
+	}
+	responses := map[string]sandbox.FetchResponse{}
+	var fetched []byte
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		if spec.Env[sandbox.EnvEvidenceFetchSocket] != "/run/datamortem/fetch.sock" {
+			t.Errorf("%s = %q", sandbox.EnvEvidenceFetchSocket, spec.Env[sandbox.EnvEvidenceFetchSocket])
+		}
+		if spec.SeccompProfile != unixSocketSeccompProfile() {
+			t.Error("seccomp profile does not allow unix sockets")
+		}
+		var runDir, fetchedDir string
+		for _, m := range spec.Mounts {
+			switch m.Target {
+			case containerFetchDir:
+				runDir = m.Source
+			case containerFetchedDir:
+				fetchedDir = m.Source
+			default:
+				continue
+			}
+			if !m.ReadOnly {
+				t.Errorf("mount %s is writable", m.Target)
+			}
+		}
+		for _, uid := range []string{"ev-1", "ev-2", "ev-2", "ev-9", "ev-secret", "ev-404", ""} {
+			responses[uid] = fetchFrom(t, runDir, uid)
+		}
+		fetched, _ = os.ReadFile(filepath.Join(fetchedDir, "1", "mem.raw"))
+	}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.EvidenceCatalog = catalog
+	cfg := DefaultExecConfig()
+	cfg.AllowEvidenceFetch = true
+	job := testJob(t)
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := responses["ev-1"]; resp.Path != "/evidence/disk.raw" || resp.SHA256 != "abc123" {
+		t.Errorf("own evidence = %+v", resp)
+	}
+	want := sandbox.FetchResponse{Path: "/evidence/fetched/1/mem.raw", SHA256: "def456", Compression: sandbox.CompressionZstd}
+	if resp := responses["ev-2"]; resp != want {
+		t.Errorf("ev-2 = %+v, want %+v", resp, want)
+	}
+	if string(fetched) != "memory" {
+		t.Errorf("fetched file = %q", fetched)
+	}
+	for _, uid := range []string{"ev-9", "ev-secret"} {
+		if resp := responses[uid]; !resp.Denied || resp.Path != "" {
+			t.Errorf("%s = %+v, want denied", uid, resp)
+		}
+	}
+	for _, uid := range []string{"ev-404", ""} {
+		if resp := responses[uid]; resp.Denied || resp.Error == "" {
+			t.Errorf("%q = %+v, want an error", uid, resp)
+		}
+	}
+	if len(res.FetchedEvidence) != 1 || res.FetchedEvidence[0].UID != "ev-2" {
+		t.Errorf("fetched evidence = %+v, want ev-2 once", res.FetchedEvidence)
+	}
+	if entries, _ := os.ReadDir(r.WorkDir); len(entries) != 0 {
+		t.Errorf("%d entries left in WorkDir", len(entries))
+	}
+}
+
+func TestEvidenceFetchRequiresCatalog(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rt.lastSpec().Env[sandbox.EnvEvidenceFetchSocket]; ok {
+		t.Error("fetch socket offered without AllowEvidenceFetch")
+	}
+
+	cfg := DefaultExecConfig()
+	cfg.AllowEvidenceFetch = true
+	job.Config = &cfg
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("AllowEvidenceFetch accepted without an EvidenceCatalog")
+	}
+}
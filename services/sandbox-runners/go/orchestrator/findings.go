@@ -0,0 +1,220 @@
+package orchestrator
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectFindings reads the results the script of job wrote with
+// sandbox.EmitResult, in the order of sortFindings unless
+// cfg.PreserveFindingOrder. Lines that fail validation, or whose locations
+// fall outside the evidence items of job, are dropped and reported as err.
+// It reads up to cfg.MaxFindings results when that is positive, the
+// first ones the script wrote; total counts those the script wrote.
+func collectFindings(job Job, cfg ExecConfig) ([]sandbox.Result, int, error) {
+	findings, total, err := sandbox.ReadResultsUpTo(job.OutputDir, evidenceSizes(job), cfg.MaxFindings)
+	if !cfg.PreserveFindingOrder {
+		sortFindings(findings)
+	}
+	return findings, total, err
+}
+
+// sortFindings orders findings by where they were made, so that two runs
+// of a script that emits them in a different order, e.g. from parallel
+// workers, compare line by line. Results carry no time: they are sorted by
+// the evidence UID, offset and length of
+// their first location, those without a location last, then by finding
+// key, title and severity. Findings that tie keep the order in which they
+// were emitted.
+func sortFindings(findings []sandbox.Result) {
+	slices.SortStableFunc(findings, func(a, b sandbox.Result) int {
+		if c := compareLocations(a.Locations, b.Locations); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.FindingKey, b.FindingKey); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(a.Title, b.Title); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Severity, b.Severity)
+	})
+}
+
+// compareLocations compares the first locations of a and b, an empty list
+// after any location.
+func compareLocations(a, b []sandbox.Location) int {
+	if len(a) == 0 || len(b) == 0 {
+		return cmp.Compare(len(b), len(a))
+	}
+	la, lb := a[0], b[0]
+	if c := cmp.Compare(la.EvidenceUID, lb.EvidenceUID); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(la.Offset, lb.Offset); c != 0 {
+		return c
+	}
+	return cmp.Compare(la.Length, lb.Length)
+}
+
+// evidenceSizes returns the size of the evidence items of job as the
+// script reads them, by UID, for locations to be checked against. A
+// compressed or encrypted item without a Length is left out: its size is
+// not known until it is read.
+func evidenceSizes(job Job) map[string]int64 {
+	sizes := map[string]int64{}
+	for _, ev := range job.allEvidence() {
+		if (ev.compressed() || ev.encrypted()) && ev.Length == 0 {
+			continue
+		}
+		if size, ok := evidenceSize(ev); ok {
+			sizes[ev.UID] = size
+		}
+	}
+	return sizes
+}
+
+// Finding is a finding of a case, merged across the jobs that reported it.
+type Finding struct {
+	// Result is the most severe report of the finding; the first one among
+	// equally severe reports.
+	sandbox.Result
+	// JobIDs and EvidenceUIDs list the jobs and evidence items that
+	// reported the finding, in order of first report.
+	JobIDs       []string
+	EvidenceUIDs []string
+	// Count is the number of times the finding was reported.
+	Count int
+	// Locations gathers the locations of every report of the finding, in
+	// order of first report and without duplicates, where
+	// Result.Locations only holds those of Result.
+	Locations []sandbox.Location
+	// Techniques gathers the ATT&CK techniques of every report of the
+	// finding, in order of first report, where Result.Techniques only
+	// holds those of Result.
+	Techniques []string
+	// Module is the analysis module of the job that reported Result.
+	Module ScriptModule
+	// SuppressedBy is the ID of the rule of CaseFindings.Suppressions that
+	// suppressed the finding, which every report of it matched: the
+	// finding is kept, but Findings leaves it out.
+	SuppressedBy string
+	// Enrichments gathers the data the enrichers of Runner.Enrichment
+	// attached to the reports of the finding, that of the latest report
+	// of each enricher.
+	Enrichments Enrichments
+}
+
+// ProducedBy is the provenance line of f in the case view, e.g.
+// "produced by yara-triage v1.2.0", or "" when its module is unknown.
+func (f Finding) ProducedBy() string {
+	if f.Module.Name == "" {
+		return ""
+	}
+	return "produced by " + f.Module.String()
+}
+
+// CaseFindings merges the findings of the jobs of one case. Results that
+// share a FindingKey become one Finding with the highest severity
+// reported; results without a key are kept as they are. It is safe for
+// concurrent use.
+type CaseFindings struct {
+	CaseID string
+	// Suppressions, when set, suppresses the findings its rules for the
+	// case match as they are added.
+	Suppressions *Suppressions
+
+	mu       sync.Mutex
+	findings []*Finding
+	byKey    map[string]*Finding
+}
+
+// NewCaseFindings returns an empty set of findings for caseID.
+func NewCaseFindings(caseID string) *CaseFindings {
+	return &CaseFindings{CaseID: caseID, byKey: map[string]*Finding{}}
+}
+
+// Add merges res.Findings, the outcome of job. A job of another case is
+// rejected: findings are never deduplicated across cases.
+func (c *CaseFindings) Add(job Job, res *JobResult) error {
+	if job.CaseID != c.CaseID {
+		return fmt.Errorf("orchestrator: job %s belongs to case %s, not %s", job.ID, job.CaseID, c.CaseID)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, r := range res.Findings {
+		suppressedBy := c.Suppressions.matchFinding(c.CaseID, r)
+		f := c.byKey[r.FindingKey]
+		if r.FindingKey == "" || f == nil {
+			f = &Finding{Result: r, Module: res.Module, SuppressedBy: suppressedBy}
+			c.findings = append(c.findings, f)
+			if r.FindingKey != "" {
+				c.byKey[r.FindingKey] = f
+			}
+		} else {
+			if r.Severity.Rank() > f.Severity.Rank() {
+				f.Result, f.Module = r, res.Module
+			}
+			if suppressedBy == "" {
+				f.SuppressedBy = ""
+			}
+		}
+		f.Count++
+		f.Enrichments = f.Enrichments.merge(res.FindingEnrichments[i])
+		for _, l := range r.Locations {
+			if !slices.Contains(f.Locations, l) {
+				f.Locations = append(f.Locations, l)
+			}
+		}
+		for _, t := range r.Techniques {
+			f.Techniques = appendUnique(f.Techniques, t)
+		}
+		f.JobIDs = appendUnique(f.JobIDs, job.ID)
+		f.EvidenceUIDs = appendUnique(f.EvidenceUIDs, r.EvidenceUID)
+	}
+	return nil
+}
+
+// Findings returns the merged findings in order of first report, the
+// suppressed ones left out.
+func (c *CaseFindings) Findings() []Finding {
+	return c.list(false)
+}
+
+// Suppressed returns the suppressed findings in order of first report.
+func (c *CaseFindings) Suppressed() []Finding {
+	return c.list(true)
+}
+
+func (c *CaseFindings) list(suppressed bool) []Finding {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []Finding
+	for _, f := range c.findings {
+		if (f.SuppressedBy != "") != suppressed {
+			continue
+		}
+		g := *f
+		g.JobIDs = append([]string(nil), f.JobIDs...)
+		g.EvidenceUIDs = append([]string(nil), f.EvidenceUIDs...)
+		g.Locations = slices.Clone(f.Locations)
+		g.Techniques = slices.Clone(f.Techniques)
+		g.Enrichments = maps.Clone(f.Enrichments)
+		out = append(out, g)
+	}
+	return out
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,193 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsFindings(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"evidence_uid":"ev-1","severity":"high","title":"Mimikatz","finding_key":"ioc/hash/abc"}`+"\n"+
+						`{"evidence_uid":"ev-1","severity":"bad","title":"x"}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 1 || res.Findings[0].FindingKey != "ioc/hash/abc" || res.Findings[0].Severity != sandbox.SeverityHigh {
+		t.Errorf("findings = %+v", res.Findings)
+	}
+	if res.FindingsError == "" {
+		t.Error("invalid severity not reported")
+	}
+	if len(res.InvalidRecords) != 1 || res.InvalidRecords[0].Line != 2 || res.InvalidRecords[0].File != sandbox.ResultsFile ||
+		res.InvalidRecords[0].Record != `{"evidence_uid":"ev-1","severity":"bad","title":"x"}` {
+		t.Errorf("invalid records = %+v, want line 2 quarantined", res.InvalidRecords)
+	}
+}
+
+func TestRunRejectsNewerOutputVersion(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"sdk_version":"99.0.0"}`+"\n"+
+						`{"evidence_uid":"ev-1","severity":"high","title":"Mimikatz"}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 0 || len(res.InvalidRecords) != 0 {
+		t.Errorf("findings = %+v, invalid records = %+v, want nothing read", res.Findings, res.InvalidRecords)
+	}
+	if !strings.Contains(res.FindingsError, "SDK 99.0.0") {
+		t.Errorf("findings error = %q, want the SDK version named", res.FindingsError)
+	}
+}
+
+func TestRunDropsRecordCutShortByKill(t *testing.T) {
+	rt := &fakeRuntime{
+		state: ContainerState{ExitCode: 137},
+		onStart: func(spec ContainerSpec) {
+			for _, m := range spec.Mounts {
+				if m.Target == containerOutputDir {
+					// Killed while writing the second record.
+					os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+						`{"evidence_uid":"ev-1","severity":"high","title":"Mimikatz"}`+"\n"+
+							`{"evidence_uid":"ev-1","severity":"hi`), 0o644)
+				}
+			}
+		},
+	}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 1 || res.Findings[0].Title != "Mimikatz" {
+		t.Errorf("findings = %+v, want only the complete record", res.Findings)
+	}
+	if len(res.InvalidRecords) != 1 || res.InvalidRecords[0].Line != 2 || !strings.Contains(res.InvalidRecords[0].Error, "cut short") {
+		t.Errorf("invalid records = %+v, want the partial line quarantined", res.InvalidRecords)
+	}
+}
+
+func TestRunChecksFindingLocations(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	os.WriteFile(evidence, make([]byte, 4096), 0o644)
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"evidence_uid":"ev-1","severity":"high","title":"Boot sector","locations":[{"evidence_uid":"ev-1","offset":0,"length":512}]}`+"\n"+
+						`{"evidence_uid":"ev-1","severity":"high","title":"Past the end","locations":[{"evidence_uid":"ev-1","offset":4000,"length":512}]}`+"\n"), 0o644)
+			}
+		}
+	}}
+	job := testJob(t)
+	job.Evidence.Path = evidence
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Findings) != 1 || res.Findings[0].Title != "Boot sector" {
+		t.Errorf("findings = %+v, want the location within the evidence kept", res.Findings)
+	}
+	if len(res.InvalidRecords) != 1 || res.InvalidRecords[0].Line != 2 || !strings.Contains(res.InvalidRecords[0].Error, "of evidence ev-1, which has 4096") {
+		t.Errorf("invalid records = %+v, want the out-of-range location quarantined", res.InvalidRecords)
+	}
+}
+
+func TestRunSortsFindings(t *testing.T) {
+	// Parallel workers emitted the findings as they finished.
+	lines := []string{
+		`{"evidence_uid":"ev-1","severity":"low","title":"No location"}`,
+		`{"evidence_uid":"ev-1","severity":"high","title":"Run key","finding_key":"b","locations":[{"evidence_uid":"ev-1","offset":4096}]}`,
+		`{"evidence_uid":"ev-1","severity":"high","title":"Service","finding_key":"a","locations":[{"evidence_uid":"ev-1","offset":4096}]}`,
+		`{"evidence_uid":"ev-1","severity":"medium","title":"Boot sector","locations":[{"evidence_uid":"ev-1","offset":0,"length":512}]}`,
+	}
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	titles := func() []string {
+		t.Helper()
+		res, err := r.Run(context.Background(), testJob(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, f := range res.Findings {
+			out = append(out, f.Title)
+		}
+		return out
+	}
+	if got, want := titles(), []string{"Boot sector", "Service", "Run key", "No location"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findings %q, want %q", got, want)
+	}
+	r.Defaults.PreserveFindingOrder = true
+	if got, want := titles(), []string{"No location", "Run key", "Service", "Boot sector"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("findings in emission order %q, want %q", got, want)
+	}
+}
+
+func TestCaseFindingsDeduplicates(t *testing.T) {
+	c := NewCaseFindings("case-1")
+	add := func(jobID string, results ...sandbox.Result) {
+		t.Helper()
+		if err := c.Add(Job{ID: jobID, CaseID: "case-1"}, &JobResult{Findings: results}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("job-1",
+		sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityLow, Title: "evil.example contacted", FindingKey: "ioc/domain/evil.example"},
+		sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityInfo, Title: "unkeyed"},
+	)
+	add("job-2",
+		sandbox.Result{EvidenceUID: "ev-2", Severity: sandbox.SeverityCritical, Title: "C2 beacon to evil.example", FindingKey: "ioc/domain/evil.example"},
+		sandbox.Result{EvidenceUID: "ev-2", Severity: sandbox.SeverityInfo, Title: "unkeyed"},
+	)
+	add("job-3", sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityMedium, Title: "evil.example in DNS cache", FindingKey: "ioc/domain/evil.example",
+		Locations: []sandbox.Location{{EvidenceUID: "ev-1", Offset: 4096, Length: 64}}})
+	add("job-4", sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityMedium, Title: "evil.example in DNS cache", FindingKey: "ioc/domain/evil.example",
+		Locations: []sandbox.Location{{EvidenceUID: "ev-1", Offset: 4096, Length: 64}, {EvidenceUID: "ev-1", Offset: 8192}}})
+
+	findings := c.Findings()
+	if len(findings) != 3 {
+		t.Fatalf("%d findings, want the keyed one once and both unkeyed ones", len(findings))
+	}
+	f := findings[0]
+	if f.Severity != sandbox.SeverityCritical || f.Title != "C2 beacon to evil.example" || f.Count != 4 {
+		t.Errorf("merged finding = %+v, want the critical report counted 4 times", f)
+	}
+	if want := []string{"job-1", "job-2", "job-3", "job-4"}; !reflect.DeepEqual(f.JobIDs, want) {
+		t.Errorf("job IDs = %v, want %v", f.JobIDs, want)
+	}
+	if want := []string{"ev-1", "ev-2"}; !reflect.DeepEqual(f.EvidenceUIDs, want) {
+		t.Errorf("evidence UIDs = %v, want %v", f.EvidenceUIDs, want)
+	}
+	if want := []sandbox.Location{{EvidenceUID: "ev-1", Offset: 4096, Length: 64}, {EvidenceUID: "ev-1", Offset: 8192}}; !reflect.DeepEqual(f.Locations, want) {
+		t.Errorf("locations = %+v, want those of every report once", f.Locations)
+	}
+
+	if err := c.Add(Job{ID: "job-5", CaseID: "case-2"}, &JobResult{}); err == nil {
+		t.Error("findings of another case were merged")
+	}
+}
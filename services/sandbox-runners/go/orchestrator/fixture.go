@@ -0,0 +1,194 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+var (
+	// ErrUnknownFixture is returned for a job whose Job.EvidenceFixture is
+	// not in Runner.Fixtures.
+	ErrUnknownFixture = errors.New("orchestrator: unknown evidence fixture")
+	// ErrFixtureChanged is returned when the file of a fixture is not the
+	// one registered, e.g. rewritten in place.
+	ErrFixtureChanged = errors.New("orchestrator: evidence fixture changed since it was registered")
+)
+
+// fixtureName is a lower-case name such as "win10-memory-sample", and
+// fixtureVersion a version such as "2" or "2024.03".
+var (
+	fixtureName    = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+	fixtureVersion = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+)
+
+// EvidenceFixture is a sample evidence file, e.g. the memory image of a
+// lab VM, that module authors run jobs against instead of case data.
+type EvidenceFixture struct {
+	Name    string
+	Version string
+	// Path is the file on the host, stored as Compression says.
+	Path        string
+	Compression string
+	// Type is the evidence type passed as EVIDENCE_TYPE; the runner
+	// detects it when empty.
+	Type        string
+	Description string
+	// SHA256 is the digest of the file. Register computes it, or checks
+	// it when set.
+	SHA256 string
+	// Size and ModTime are those of the file when it was registered.
+	Size    int64
+	ModTime time.Time
+}
+
+// Ref returns the reference of f for Job.EvidenceFixture, e.g.
+// "win10-memory-sample@2".
+func (f EvidenceFixture) Ref() string {
+	return f.Name + "@" + f.Version
+}
+
+// evidence returns the evidence item a job selecting f runs against.
+func (f EvidenceFixture) evidence() Evidence {
+	return Evidence{
+		UID:         "fixture-" + f.Name + "-" + f.Version,
+		Type:        f.Type,
+		Path:        f.Path,
+		SHA256:      f.SHA256,
+		HashAlgo:    sandbox.HashSHA256,
+		Compression: f.Compression,
+	}
+}
+
+// FixtureRegistry holds the versions of each evidence fixture. Its zero
+// value is empty and ready to use.
+type FixtureRegistry struct {
+	mu sync.Mutex
+	// fixtures holds the versions of each fixture in the order they were
+	// registered.
+	fixtures map[string][]EvidenceFixture
+}
+
+// Register hashes the file of f and adds it to the registry, which then
+// returns it with SHA256, Size and ModTime set. Registering a version
+// again is a no-op when the file is unchanged, and an error otherwise:
+// a version always names the same bytes.
+func (reg *FixtureRegistry) Register(f EvidenceFixture) (EvidenceFixture, error) {
+	switch {
+	case !fixtureName.MatchString(f.Name):
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: invalid fixture name %q: must be lower-case letters, digits, '.', '_' or '-'", f.Name)
+	case !fixtureVersion.MatchString(f.Version):
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s: invalid version %q", f.Name, f.Version)
+	case !sandbox.ValidCompression(f.Compression):
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s: unsupported compression %q", f.Ref(), f.Compression)
+	}
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s: %w", f.Ref(), err)
+	}
+	if !info.Mode().IsRegular() {
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s: %s is not a regular file", f.Ref(), f.Path)
+	}
+	sum, _, err := fileSHA256(f.Path)
+	if err != nil {
+		return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s: %w", f.Ref(), err)
+	}
+	if f.SHA256 != "" && !strings.EqualFold(f.SHA256, sum) {
+		return EvidenceFixture{}, fmt.Errorf("%w: fixture %s has SHA256 %s, want %s", sandbox.ErrEvidenceHashMismatch, f.Ref(), sum, f.SHA256)
+	}
+	f.SHA256, f.Size, f.ModTime = sum, info.Size(), info.ModTime()
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, v := range reg.fixtures[f.Name] {
+		if v.Version != f.Version {
+			continue
+		}
+		if v.SHA256 != f.SHA256 {
+			return EvidenceFixture{}, fmt.Errorf("orchestrator: fixture %s is already registered with SHA256 %s", f.Ref(), v.SHA256)
+		}
+		return v, nil
+	}
+	if reg.fixtures == nil {
+		reg.fixtures = map[string][]EvidenceFixture{}
+	}
+	reg.fixtures[f.Name] = append(reg.fixtures[f.Name], f)
+	return f, nil
+}
+
+// Lookup returns the fixture ref names, "name@version", or the version
+// of name registered last for a bare "name".
+func (reg *FixtureRegistry) Lookup(ref string) (EvidenceFixture, error) {
+	name, version, pinned := strings.Cut(ref, "@")
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	versions := reg.fixtures[name]
+	if !pinned && len(versions) > 0 {
+		return versions[len(versions)-1], nil
+	}
+	for _, f := range versions {
+		if f.Version == version {
+			return f, nil
+		}
+	}
+	return EvidenceFixture{}, fmt.Errorf("%w: %q", ErrUnknownFixture, ref)
+}
+
+// Fixtures returns every version of every fixture, sorted by name, then
+// in the order they were registered.
+func (reg *FixtureRegistry) Fixtures() []EvidenceFixture {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	names := make([]string, 0, len(reg.fixtures))
+	for name := range reg.fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var out []EvidenceFixture
+	for _, name := range names {
+		out = append(out, reg.fixtures[name]...)
+	}
+	return out
+}
+
+// resolveFixture sets the evidence of a job selecting a fixture, and pins
+// Job.EvidenceFixture to its version. A job resolved already is returned
+// as is.
+func (r *Runner) resolveFixture(job Job) (Job, error) {
+	if job.EvidenceFixture == "" {
+		return job, nil
+	}
+	if r.Fixtures == nil {
+		return Job{}, fmt.Errorf("%w: %q, no Runner.Fixtures", ErrUnknownFixture, job.EvidenceFixture)
+	}
+	f, err := r.Fixtures.Lookup(job.EvidenceFixture)
+	if err != nil {
+		return Job{}, err
+	}
+	ev := f.evidence()
+	switch {
+	case job.Evidence.UID == ev.UID && job.Evidence.SHA256 == ev.SHA256:
+		// Resolved by Run before Start, its type detected maybe.
+		return job, nil
+	case job.Evidence != (Evidence{}):
+		return Job{}, fmt.Errorf("orchestrator: job %s sets both Evidence and EvidenceFixture", job.ID)
+	}
+	// Checking the digest would read the whole file for each job; the
+	// size and modification time catch a file replaced or rewritten.
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return Job{}, fmt.Errorf("%w: %s: %v", ErrFixtureChanged, f.Ref(), err)
+	}
+	if info.Size() != f.Size || !info.ModTime().Equal(f.ModTime) {
+		return Job{}, fmt.Errorf("%w: %s", ErrFixtureChanged, f.Ref())
+	}
+	job.Evidence, job.EvidenceFixture = ev, f.Ref()
+	return job, nil
+}
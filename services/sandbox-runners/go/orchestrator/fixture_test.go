@@ -0,0 +1,151 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// writeFixture writes content to a file named name in a temp directory.
+func writeFixture(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFixtureRegistry(t *testing.T) {
+	reg := &FixtureRegistry{}
+	v1 := writeFixture(t, "mem.raw", "v1")
+	f, err := reg.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "1", Path: v1, Type: sandbox.EvidenceTypeMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The SHA256 of "v1".
+	if f.SHA256 != "3bfc269594ef649228e9a74bab00f042efc91d5acc6fbee31a382e80d42388fe" || f.Size != 2 || f.Ref() != "win10-memory-sample@1" {
+		t.Errorf("registered %+v", f)
+	}
+	if again, err := reg.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "1", Path: v1}); err != nil || again != f {
+		t.Errorf("registering again = %+v, %v", again, err)
+	}
+	if _, err := reg.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "1", Path: writeFixture(t, "mem.raw", "other")}); err == nil {
+		t.Error("registered other bytes under the same version")
+	}
+	if _, err := reg.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "2", Path: v1, SHA256: "abc123"}); !errors.Is(err, sandbox.ErrEvidenceHashMismatch) {
+		t.Errorf("wrong SHA256: %v, want ErrEvidenceHashMismatch", err)
+	}
+	for _, bad := range []EvidenceFixture{
+		{Name: "Win10", Version: "1", Path: v1},
+		{Name: "win10", Version: "../1", Path: v1},
+		{Name: "win10", Version: "1", Path: filepath.Dir(v1)},
+		{Name: "win10", Version: "1", Path: v1, Compression: "lzma"},
+	} {
+		if _, err := reg.Register(bad); err == nil {
+			t.Errorf("registered %+v", bad)
+		}
+	}
+	if _, err := reg.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "2", Path: writeFixture(t, "mem.raw", "v2")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Register(EvidenceFixture{Name: "ntfs-image", Version: "2024.03", Path: v1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, want := range map[string]string{
+		"win10-memory-sample":   "win10-memory-sample@2",
+		"win10-memory-sample@1": "win10-memory-sample@1",
+	} {
+		if f, err := reg.Lookup(ref); err != nil || f.Ref() != want {
+			t.Errorf("Lookup(%q) = %s, %v, want %s", ref, f.Ref(), err, want)
+		}
+	}
+	for _, ref := range []string{"win10-memory-sample@3", "missing", ""} {
+		if _, err := reg.Lookup(ref); !errors.Is(err, ErrUnknownFixture) {
+			t.Errorf("Lookup(%q) = %v, want ErrUnknownFixture", ref, err)
+		}
+	}
+	var refs []string
+	for _, f := range reg.Fixtures() {
+		refs = append(refs, f.Ref())
+	}
+	if want := "[ntfs-image@2024.03 win10-memory-sample@1 win10-memory-sample@2]"; fmt.Sprint(refs) != want {
+		t.Errorf("Fixtures() = %s, want %s", fmt.Sprint(refs), want)
+	}
+}
+
+func TestRunWithFixture(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Fixtures = &FixtureRegistry{}
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	path := writeFixture(t, "mem.raw", "v1")
+	f, err := r.Fixtures.Register(EvidenceFixture{Name: "win10-memory-sample", Version: "1", Path: path, Type: sandbox.EvidenceTypeMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := testJob(t)
+	job.Evidence = Evidence{}
+	job.EvidenceFixture = "win10-memory-sample"
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.AuditError != "" {
+		t.Fatalf("audit error: %s", res.AuditError)
+	}
+	spec := rt.lastSpec()
+	for name, want := range map[string]string{
+		sandbox.EnvEvidenceUID:    "fixture-win10-memory-sample-1",
+		sandbox.EnvEvidencePath:   evidenceTarget(0, Evidence{Path: path}),
+		sandbox.EnvEvidenceSHA256: f.SHA256,
+		sandbox.EnvEvidenceType:   sandbox.EvidenceTypeMemory,
+	} {
+		if got := spec.Env[name]; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	mounted := false
+	for _, m := range spec.Mounts {
+		mounted = mounted || (m.Source == path && m.ReadOnly)
+	}
+	if !mounted {
+		t.Errorf("fixture not mounted read-only: %+v", spec.Mounts)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.Fixture != "win10-memory-sample@1" || e.Evidence[0].SHA256 != f.SHA256 {
+		t.Errorf("audit entry fixture %q, evidence %+v", e.Fixture, e.Evidence)
+	}
+
+	job.Evidence = Evidence{UID: "ev-1", Path: path}
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("ran a job with both Evidence and EvidenceFixture")
+	}
+	job.Evidence = Evidence{}
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrFixtureChanged) {
+		t.Errorf("Run() after the fixture changed = %v, want ErrFixtureChanged", err)
+	}
+	job.EvidenceFixture = "missing"
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrUnknownFixture) {
+		t.Errorf("Run() with an unknown fixture = %v, want ErrUnknownFixture", err)
+	}
+}
@@ -0,0 +1,217 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownFlavor is returned for a job whose Job.Flavor is not defined
+// in Runner.Flavors.
+var ErrUnknownFlavor = errors.New("orchestrator: unknown image flavor")
+
+// FlavorRepository is the repository of flavor images, tagged with the
+// hash of their base image and dependency set.
+const FlavorRepository = "datamortem-sandbox-go-flavor"
+
+// Labels of flavor images.
+const (
+	LabelFlavor     = "datamortem.flavor"
+	LabelFlavorHash = "datamortem.flavor.hash"
+)
+
+var flavorName = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+
+// Flavor is a team's set of third-party Go modules, pinned, that an image
+// extending the Go runner image holds in its module cache, so that the
+// jobs selecting it with Job.Flavor do not download them.
+type Flavor struct {
+	Name string `json:"name"`
+	// Modules are "path@version", e.g.
+	// "github.com/Velocidex/ordereddict@v0.0.0-20230909174157-2aa49cc5d11d";
+	// their dependencies are downloaded too.
+	Modules []string `json:"modules"`
+}
+
+// Flavors holds the flavors jobs can select and builds their images on
+// first use, through Runtime.BuildImage. An image is tagged with the hash
+// of the runner image it extends and of its modules, so that flavors
+// sharing a dependency set share an image, and a flavor is rebuilt when
+// the runner image or its modules change. Its zero value is empty and
+// ready to use.
+type Flavors struct {
+	// Proxy is the GOPROXY of the builds, e.g. a mirror inside the lab;
+	// the runner image's when empty.
+	Proxy string
+	// Network is the docker network of the builds, needed to reach Proxy;
+	// the engine's default when empty.
+	Network string
+
+	mu      sync.Mutex
+	flavors map[string]Flavor
+	// images holds the build of each image by tag, done or in progress.
+	images map[string]*flavorBuild
+}
+
+// flavorBuild is the build of a flavor image; done is closed once id or
+// err is set.
+type flavorBuild struct {
+	done chan struct{}
+	id   string
+	err  error
+}
+
+// Define adds f, or replaces the flavor of the same name.
+func (fl *Flavors) Define(f Flavor) error {
+	if !flavorName.MatchString(f.Name) {
+		return fmt.Errorf("orchestrator: invalid flavor name %q: must be lower-case letters, digits, '.', '_' or '-'", f.Name)
+	}
+	if len(f.Modules) == 0 {
+		return fmt.Errorf("orchestrator: flavor %s has no modules", f.Name)
+	}
+	modules := append([]string(nil), f.Modules...)
+	sort.Strings(modules)
+	seen := map[string]bool{}
+	for _, m := range modules {
+		path, version, _ := strings.Cut(m, "@")
+		if !validModulePath(path) || !moduleVersion.MatchString(version) {
+			return fmt.Errorf("orchestrator: flavor %s: invalid module %q, want path@version", f.Name, m)
+		}
+		if seen[path] {
+			return fmt.Errorf("orchestrator: flavor %s: module %s is pinned twice", f.Name, path)
+		}
+		seen[path] = true
+	}
+	f.Modules = modules
+
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.flavors == nil {
+		fl.flavors = map[string]Flavor{}
+	}
+	fl.flavors[f.Name] = f
+	return nil
+}
+
+// Flavors returns the defined flavors, sorted by name.
+func (fl *Flavors) Flavors() []Flavor {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	out := make([]Flavor, 0, len(fl.flavors))
+	for _, f := range fl.flavors {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// flavor returns the flavor called name.
+func (r *Runner) flavor(name string) (Flavor, error) {
+	if r.Flavors == nil {
+		return Flavor{}, fmt.Errorf("%w: %q, no Runner.Flavors", ErrUnknownFlavor, name)
+	}
+	r.Flavors.mu.Lock()
+	defer r.Flavors.mu.Unlock()
+	f, ok := r.Flavors.flavors[name]
+	if !ok {
+		return Flavor{}, fmt.Errorf("%w: %q", ErrUnknownFlavor, name)
+	}
+	return f, nil
+}
+
+// dependencyKey hashes the modules of f and the proxy they are downloaded
+// from.
+func (fl *Flavors) dependencyKey(f Flavor) string {
+	h := sha256.New()
+	io.WriteString(h, "proxy\x00"+fl.Proxy+"\x00")
+	for _, m := range f.Modules {
+		io.WriteString(h, m+"\x00")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// flavorDockerfile extends base with the modules of f, resolved in a
+// throwaway module so that their dependencies land in the module cache
+// too.
+func flavorDockerfile(base string, f Flavor, proxy string) string {
+	env := ""
+	if proxy != "" {
+		env = "GOPROXY=" + proxy + " "
+	}
+	return "FROM " + base + "\n" +
+		"RUN mkdir /tmp/flavor && cd /tmp/flavor && go mod init flavor && \\\n" +
+		"    " + env + "go get " + strings.Join(f.Modules, " ") + " && \\\n" +
+		"    " + env + "go mod download all && \\\n" +
+		"    cd / && rm -rf /tmp/flavor && go clean -cache\n"
+}
+
+// flavorImage returns the tag and ID of the image of flavor f extending
+// base, the runner image whose ID is baseID, building it unless an earlier
+// job did.
+func (r *Runner) flavorImage(ctx context.Context, f Flavor, base, baseID string) (string, string, error) {
+	fl := r.Flavors
+	sum := sha256.Sum256([]byte(baseID + "\x00" + fl.dependencyKey(f)))
+	hash := hex.EncodeToString(sum[:])
+	tag := FlavorRepository + ":" + hash[:16]
+
+	fl.mu.Lock()
+	b, ok := fl.images[tag]
+	if !ok {
+		b = &flavorBuild{done: make(chan struct{})}
+		if fl.images == nil {
+			fl.images = map[string]*flavorBuild{}
+		}
+		fl.images[tag] = b
+	}
+	fl.mu.Unlock()
+	if ok {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		}
+		if b.err == nil {
+			return tag, b.id, nil
+		}
+		// The job that failed the build reported why; this one retries.
+		return r.flavorImage(ctx, f, base, baseID)
+	}
+
+	b.id, b.err = r.buildFlavor(ctx, f, base, tag, hash)
+	fl.mu.Lock()
+	if b.err != nil {
+		delete(fl.images, tag)
+	}
+	fl.mu.Unlock()
+	close(b.done)
+	return tag, b.id, b.err
+}
+
+// buildFlavor builds the image tag of flavor f. The engine's layer cache
+// makes it quick when an earlier orchestrator process built it already.
+func (r *Runner) buildFlavor(ctx context.Context, f Flavor, base, tag, hash string) (string, error) {
+	err := r.Runtime.BuildImage(ctx, ImageBuildSpec{
+		Tag:        tag,
+		Dockerfile: flavorDockerfile(base, f, r.Flavors.Proxy),
+		Labels:     map[string]string{LabelFlavor: f.Name, LabelFlavorHash: hash},
+		Network:    r.Flavors.Network,
+	})
+	if err != nil {
+		return "", fmt.Errorf("orchestrator: build flavor %s: %w", f.Name, err)
+	}
+	info, err := r.Runtime.InspectImage(ctx, tag, r.platform())
+	if err != nil {
+		return "", &InfraError{Op: "inspect image", Err: err}
+	}
+	if info.ID == "" {
+		return "", fmt.Errorf("orchestrator: image %s has no ID", tag)
+	}
+	return info.ID, nil
+}
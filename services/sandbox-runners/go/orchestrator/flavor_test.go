@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var flavorModules = []string{
+	"github.com/Velocidex/ordereddict@v0.0.0-20230909174157-2aa49cc5d11d",
+	"github.com/Velocidex/go-ntfs@v0.2.0",
+}
+
+func TestDefineFlavor(t *testing.T) {
+	fl := &Flavors{}
+	if err := fl.Define(Flavor{Name: "triage", Modules: flavorModules}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fl.Flavors(); len(got) != 1 || got[0].Modules[0] != flavorModules[1] {
+		t.Errorf("Flavors() = %+v, want the modules sorted", got)
+	}
+	for _, bad := range []Flavor{
+		{Name: "Triage", Modules: flavorModules},
+		{Name: "empty"},
+		{Name: "latest", Modules: []string{"github.com/Velocidex/go-ntfs@latest"}},
+		{Name: "bare", Modules: []string{"github.com/Velocidex/go-ntfs"}},
+		{Name: "shell", Modules: []string{"github.com/Velocidex/go-ntfs@v0.2.0;sh"}},
+		{Name: "twice", Modules: []string{"github.com/Velocidex/go-ntfs@v0.2.0", "github.com/Velocidex/go-ntfs@v0.2.1"}},
+	} {
+		if err := fl.Define(bad); err == nil {
+			t.Errorf("defined %+v", bad)
+		}
+	}
+}
+
+func TestRunWithFlavor(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Flavors = &Flavors{Proxy: "http://goproxy.lab", Network: "lab-mirror"}
+	r.Flavors.Define(Flavor{Name: "triage", Modules: flavorModules})
+	r.Flavors.Define(Flavor{Name: "triage-copy", Modules: flavorModules})
+
+	var images []string
+	for _, flavor := range []string{"triage", "triage", "triage-copy"} {
+		job := testJob(t)
+		job.Flavor = flavor
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Build == nil || res.Build.Flavor != flavor || !reflect.DeepEqual(res.Build.FlavorModules, []string{flavorModules[1], flavorModules[0]}) {
+			t.Errorf("Build = %+v", res.Build)
+		}
+		if spec := rt.lastSpec(); spec.Image != fakeImageID(res.Image) || res.ImageDigest != spec.Image {
+			t.Errorf("ran %s, result image %s (%s)", spec.Image, res.Image, res.ImageDigest)
+		}
+		images = append(images, res.Image)
+	}
+	if len(rt.builds) != 1 {
+		t.Fatalf("built %d images, want one for the dependency set", len(rt.builds))
+	}
+	if images[0] != images[2] || !strings.HasPrefix(images[0], FlavorRepository+":") {
+		t.Errorf("images %v", images)
+	}
+	b := rt.builds[0]
+	for _, want := range []string{
+		"FROM datamortem-sandbox-go:1.21\n",
+		"GOPROXY=http://goproxy.lab go get " + flavorModules[1] + " " + flavorModules[0],
+		"go mod download all",
+	} {
+		if !strings.Contains(b.Dockerfile, want) {
+			t.Errorf("Dockerfile lacks %q:\n%s", want, b.Dockerfile)
+		}
+	}
+	if b.Tag != images[0] || b.Network != "lab-mirror" || b.Labels[LabelFlavor] != "triage" {
+		t.Errorf("build %+v", b)
+	}
+
+	// Other modules make another image.
+	r.Flavors.Define(Flavor{Name: "triage", Modules: flavorModules[:1]})
+	job := testJob(t)
+	job.Flavor = "triage"
+	if res, err := r.Run(context.Background(), job); err != nil || res.Image == images[0] || len(rt.builds) != 2 {
+		t.Errorf("Run() after redefining = %v, image %s, %d builds", err, res.Image, len(rt.builds))
+	}
+}
+
+func TestRunWithFlavorErrors(t *testing.T) {
+	rt := &fakeRuntime{buildErr: errors.New("go get: unknown revision")}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.Flavor = "triage"
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrUnknownFlavor) {
+		t.Errorf("Run() without Runner.Flavors = %v, want ErrUnknownFlavor", err)
+	}
+	r.Flavors = &Flavors{}
+	r.Flavors.Define(Flavor{Name: "triage", Modules: flavorModules})
+	python := testJob(t)
+	python.Language, python.Flavor = LanguagePython, "triage"
+	if _, err := r.Run(context.Background(), python); !errors.Is(err, ErrInvalidBuild) {
+		t.Errorf("Run() of a Python job with a flavor = %v, want ErrInvalidBuild", err)
+	}
+
+	// A failed build is not cached.
+	for i := 1; i <= 2; i++ {
+		if _, err := r.Run(context.Background(), job); err == nil || !strings.Contains(err.Error(), "unknown revision") {
+			t.Errorf("Run() with a failing build = %v", err)
+		}
+		if len(rt.builds) != i {
+			t.Errorf("%d builds after %d runs", len(rt.builds), i)
+		}
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("created %d containers", len(rt.specs))
+	}
+}
@@ -0,0 +1,417 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrModuleNotAllowed is returned for a Go job whose go.mod requires a
+// module outside Runner.ModuleAllowlist.
+var ErrModuleNotAllowed = errors.New("orchestrator: module not allowed")
+
+// majorSuffix is the major version suffix of a module path, e.g. "/v2".
+var majorSuffix = regexp.MustCompile(`/v([0-9]+)$`)
+
+// notAllowedMessage is the message of the problem of a module outside
+// Runner.ModuleAllowlist.
+const notAllowedMessage = "not under Runner.ModuleAllowlist"
+
+// ModuleProblem is a problem with the go.mod or go.sum of a Go script,
+// reported by Runner.Validate before the script is built.
+type ModuleProblem struct {
+	// File is "go.mod" or "go.sum", and Line the line of the problem,
+	// zero when it has none, e.g. for a missing go.sum entry.
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ModuleProblem) String() string {
+	s := p.File
+	if p.Line > 0 {
+		s += ":" + strconv.Itoa(p.Line)
+	}
+	if p.Module != "" {
+		s += ": " + strings.TrimSpace(p.Module+" "+p.Version)
+	}
+	return s + ": " + p.Message
+}
+
+// goModRequire is a require directive of a go.mod file.
+type goModRequire struct {
+	Path, Version string
+	Line          int
+}
+
+// goModFile is what the orchestrator checks of a go.mod file.
+type goModFile struct {
+	Module   string
+	Requires []goModRequire
+	Replaces []ModuleReplace
+	problems []ModuleProblem
+}
+
+// replaces reports whether m applies to req.
+func (m ModuleReplace) replaces(req goModRequire) bool {
+	return m.Old == req.Path && (m.OldVersion == "" || m.OldVersion == req.Version)
+}
+
+// fetched returns the module and version the toolchain downloads for req,
+// ok false when a directory replaces it.
+func (f *goModFile) fetched(req goModRequire) (mod, version string, ok bool) {
+	mod, version = req.Path, req.Version
+	for _, m := range f.Replaces {
+		if !m.replaces(req) {
+			continue
+		}
+		if m.local() || strings.HasPrefix(m.New, "/") {
+			return "", "", false
+		}
+		mod, version = m.New, m.NewVersion
+	}
+	return mod, version, true
+}
+
+// parseGoMod reads the module, require and replace directives of a go.mod
+// file, recording the malformed ones as problems. Other directives, such
+// as go or exclude, are left to the toolchain.
+func parseGoMod(data []byte) *goModFile {
+	f := &goModFile{}
+	problem := func(line int, mod, version, format string, args ...any) {
+		f.problems = append(f.problems, ModuleProblem{File: "go.mod", Line: line, Module: mod, Version: version, Message: fmt.Sprintf(format, args...)})
+	}
+	block := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		fields, err := goModFields(sc.Text())
+		if err != nil {
+			problem(n, "", "", "%v", err)
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		verb := block
+		switch {
+		case block != "" && fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block == "":
+			verb, fields = fields[0], fields[1:]
+		}
+		switch verb {
+		case "module":
+			if len(fields) != 1 {
+				problem(n, "", "", "usage: module module/path")
+				continue
+			}
+			f.Module = fields[0]
+		case "require":
+			if len(fields) != 2 {
+				problem(n, "", "", "usage: require module/path v1.2.3")
+				continue
+			}
+			req := goModRequire{Path: fields[0], Version: fields[1], Line: n}
+			for _, prev := range f.Requires {
+				if prev.Path == req.Path {
+					problem(n, req.Path, req.Version, "required again, first at line %d", prev.Line)
+				}
+			}
+			f.Requires = append(f.Requires, req)
+		case "replace":
+			m, err := goModReplace(fields)
+			if err != nil {
+				problem(n, "", "", "%v", err)
+				continue
+			}
+			f.Replaces = append(f.Replaces, m)
+		}
+	}
+	if f.Module == "" {
+		problem(0, "", "", "no module directive")
+	}
+	return f
+}
+
+// goModFields splits a go.mod line into its tokens, without the comment,
+// unquoting quoted ones.
+func goModFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		switch {
+		case line == "" || strings.HasPrefix(line, "//"):
+			return fields, nil
+		case line[0] == '"' || line[0] == '`':
+			end := strings.IndexByte(line[1:], line[0])
+			if end < 0 {
+				return nil, errors.New("unterminated quoted string")
+			}
+			s, err := strconv.Unquote(line[:end+2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string %s", line[:end+2])
+			}
+			fields, line = append(fields, s), line[end+2:]
+		default:
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			if i := strings.Index(line[:end], "//"); i >= 0 {
+				end = i
+			}
+			fields, line = append(fields, line[:end]), line[end:]
+		}
+	}
+}
+
+// goModReplace parses the fields of a replace directive.
+func goModReplace(fields []string) (ModuleReplace, error) {
+	var m ModuleReplace
+	arrow := -1
+	for i, f := range fields {
+		if f == "=>" {
+			arrow = i
+		}
+	}
+	switch arrow {
+	case 1:
+		m.Old = fields[0]
+	case 2:
+		m.Old, m.OldVersion = fields[0], fields[1]
+	default:
+		return m, errors.New("usage: replace module/path [v1.2.3] => other/module v1.4.5 or ./dir")
+	}
+	switch rest := fields[arrow+1:]; len(rest) {
+	case 1:
+		m.New = rest[0]
+	case 2:
+		m.New, m.NewVersion = rest[0], rest[1]
+	default:
+		return m, errors.New("usage: replace module/path [v1.2.3] => other/module v1.4.5 or ./dir")
+	}
+	return m, nil
+}
+
+// checkModuleVersion checks that version is a semantic version whose major
+// version matches the suffix of mod, e.g. v2.1.0 for example.org/lib/v2.
+func checkModuleVersion(mod, version string) error {
+	if !moduleVersion.MatchString(version) {
+		return errors.New("invalid version: want a semantic version such as v1.2.3")
+	}
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	if strings.HasPrefix(mod, "gopkg.in/") {
+		// gopkg.in paths carry their major version as ".v2".
+		return nil
+	}
+	if m := majorSuffix.FindStringSubmatch(mod); m != nil {
+		if major != m[1] {
+			return fmt.Errorf("invalid version: module path ends in /v%s", m[1])
+		}
+		return nil
+	}
+	if major != "0" && major != "1" && !strings.HasSuffix(version, "+incompatible") {
+		return fmt.Errorf("invalid version: a major version %s needs a /v%s module path", major, major)
+	}
+	return nil
+}
+
+// checkModulePath checks the path of a module the toolchain downloads.
+func checkModulePath(mod string) error {
+	if !validModulePath(mod) {
+		return errors.New("invalid module path")
+	}
+	if first, _, _ := strings.Cut(mod, "/"); !strings.Contains(first, ".") {
+		return errors.New("invalid module path: missing dot in its first element")
+	}
+	return nil
+}
+
+// goSumEntries reads the "path version" and "path version/go.mod" entries
+// of a go.sum file, recording the malformed lines as problems.
+func goSumEntries(data []byte) (map[string]bool, []ModuleProblem) {
+	entries := map[string]bool{}
+	var problems []ModuleProblem
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		switch {
+		case len(fields) == 0:
+		case len(fields) != 3 || !strings.HasPrefix(fields[2], "h1:"):
+			problems = append(problems, ModuleProblem{File: "go.sum", Line: n, Message: "malformed line: want module/path version h1:hash"})
+		default:
+			entries[fields[0]+" "+fields[1]] = true
+		}
+	}
+	return entries, problems
+}
+
+// moduleProblems checks the go.mod and go.sum of the Go script in
+// workspace: the syntax of its directives, module paths and versions,
+// that go.sum has an entry for every module downloaded when sums is set,
+// and that those modules are under allowlist, when not empty. The modules
+// replaced by replace, the Job.Replace directives, are left to
+// validateBuild. A workspace without go.mod has no problems.
+func moduleProblems(workspace string, replace []ModuleReplace, allowlist []string, sums bool) ([]ModuleProblem, error) {
+	data, err := os.ReadFile(filepath.Join(workspace, "go.mod"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := parseGoMod(data)
+	problems := f.problems
+	problem := func(file string, line int, mod, version string, err error) {
+		problems = append(problems, ModuleProblem{File: file, Line: line, Module: mod, Version: version, Message: err.Error()})
+	}
+	for _, m := range f.Replaces {
+		if !validModulePath(m.Old) {
+			problem("go.mod", 0, m.Old, m.OldVersion, errors.New("invalid module path in replace"))
+		}
+	}
+
+	var sumEntries map[string]bool
+	if sums {
+		data, err := os.ReadFile(filepath.Join(workspace, "go.sum"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		var sumProblems []ModuleProblem
+		sumEntries, sumProblems = goSumEntries(data)
+		problems = append(problems, sumProblems...)
+	}
+	for _, req := range f.Requires {
+		if !validModulePath(req.Path) {
+			problem("go.mod", req.Line, req.Path, req.Version, errors.New("invalid module path"))
+			continue
+		}
+		if err := checkModuleVersion(req.Path, req.Version); err != nil {
+			problem("go.mod", req.Line, req.Path, req.Version, err)
+			continue
+		}
+		mod, version, ok := f.fetched(req)
+		for _, m := range replace {
+			ok = ok && !m.replaces(req)
+		}
+		if !ok {
+			continue
+		}
+		if err := checkModulePath(mod); err != nil {
+			problem("go.mod", req.Line, mod, version, err)
+			continue
+		}
+		if err := checkModuleVersion(mod, version); err != nil {
+			problem("go.mod", req.Line, mod, version, err)
+			continue
+		}
+		if len(allowlist) > 0 && !hasModulePrefix(mod, allowlist) {
+			problem("go.mod", req.Line, mod, version, errors.New(notAllowedMessage))
+		}
+		if sums && !sumEntries[mod+" "+version+"/go.mod"] {
+			problem("go.sum", 0, mod, version, errors.New("missing go.sum entry: run go mod tidy"))
+		}
+	}
+	return problems, nil
+}
+
+// checkModuleAllowlist rejects a Go job whose go.mod requires a module
+// outside r.ModuleAllowlist, which any module passes when it is empty.
+func (r *Runner) checkModuleAllowlist(job Job) error {
+	if len(r.ModuleAllowlist) == 0 || languageKey(job.Language) != LanguageGo {
+		return nil
+	}
+	problems, err := moduleProblems(job.Workspace, job.Replace, r.ModuleAllowlist, false)
+	if err != nil {
+		return fmt.Errorf("orchestrator: read go.mod: %w", err)
+	}
+	var denied []string
+	for _, p := range problems {
+		if p.Message == notAllowedMessage {
+			denied = append(denied, strings.TrimSpace(p.Module+" "+p.Version))
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%w: %s", ErrModuleNotAllowed, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// moduleDownload is an object of the output of `go mod download -json`.
+type moduleDownload struct {
+	Path, Version, Error string
+}
+
+// resolveModules downloads, in a container derived from spec, the modules
+// the Go script in workspace requires through the Runner.Vendor proxy,
+// and returns those that do not resolve, e.g. a version that does not
+// exist or was retracted from the proxy.
+func (r *Runner) resolveModules(ctx context.Context, workspace string, spec ContainerSpec) ([]ModuleProblem, error) {
+	data, err := os.ReadFile(filepath.Join(workspace, "go.mod"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := parseGoMod(data)
+	lines := map[string]int{}
+	var args []string
+	for _, req := range f.Requires {
+		if mod, version, ok := f.fetched(req); ok {
+			args = append(args, mod+"@"+version)
+			lines[mod+"@"+version] = req.Line
+		}
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	spec = r.moduleFetchSpec(spec)
+	spec.Mounts = append([]Mount(nil), spec.Mounts...)
+	spec.Mounts[0].ReadOnly = true
+	// Outside the workspace, the download leaves its go.mod and go.sum
+	// alone, and a fresh module cache asks the proxy for every module.
+	spec.WorkDir = containerTmp
+	spec.Env["GOMODCACHE"] = path.Join(containerTmp, "go-mod")
+	spec.Cmd = append([]string{"go", "mod", "download", "-json"}, args...)
+	code, out, err := r.runToCompletion(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("go mod download: %w", err)
+	}
+	var problems []ModuleProblem
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var d moduleDownload
+		err := dec.Decode(&d)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// Not JSON: the toolchain failed before downloading.
+			return []ModuleProblem{{File: "go.mod", Message: "go mod download failed: " + strings.TrimSpace(out)}}, nil
+		}
+		if d.Error != "" {
+			problems = append(problems, ModuleProblem{File: "go.mod", Line: lines[d.Path+"@"+d.Version], Module: d.Path, Version: d.Version, Message: d.Error})
+		}
+	}
+	if code != 0 && len(problems) == 0 {
+		problems = append(problems, ModuleProblem{File: "go.mod", Message: fmt.Sprintf("go mod download failed with exit code %d", code)})
+	}
+	return problems, nil
+}
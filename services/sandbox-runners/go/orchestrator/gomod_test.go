@@ -0,0 +1,171 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const problemGoMod = `module example.com/parser
+
+go 1.21
+
+require (
+	github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d
+	github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0
+	golang.org/x/sys v0.28
+	github.com/google/go-github/v56 v55.0.0
+	github.com/spf13/cobra v2.0.0
+	gopkg.in/yaml.v3 v3.0.1
+	"github.com/pkg/errors" v0.9.1 // quoted
+	localhelpers v1.0.0
+	github.com/pkg/errors v0.9.0
+)
+
+replace github.com/St0n14/datamortem/services/sandbox-runners/go => /opt/datamortem-sdk
+
+replace localhelpers => ./helpers
+`
+
+const problemGoSum = `github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d h1:abc=
+github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d/go.mod h1:def=
+gopkg.in/yaml.v3 v3.0.1/go.mod h1:ghi=
+github.com/pkg/errors v0.9.1 sha256:xyz
+`
+
+func moduleWorkspace(t *testing.T, gomod, gosum string) string {
+	t.Helper()
+	ws := t.TempDir()
+	os.WriteFile(filepath.Join(ws, "go.mod"), []byte(gomod), 0o644)
+	if gosum != "" {
+		os.WriteFile(filepath.Join(ws, "go.sum"), []byte(gosum), 0o644)
+	}
+	return ws
+}
+
+func TestModuleProblems(t *testing.T) {
+	ws := moduleWorkspace(t, problemGoMod, problemGoSum)
+	problems, err := moduleProblems(ws, nil, []string{"github.com/Velocidex", "github.com/pkg", "gopkg.in"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		"go.mod:14: github.com/pkg/errors v0.9.0: required again, first at line 12",
+		"go.sum:4: malformed line: want module/path version h1:hash",
+		"go.mod:8: golang.org/x/sys v0.28: invalid version: want a semantic version such as v1.2.3",
+		"go.mod:9: github.com/google/go-github/v56 v55.0.0: invalid version: module path ends in /v56",
+		"go.mod:10: github.com/spf13/cobra v2.0.0: invalid version: a major version 2 needs a /v2 module path",
+		"go.sum: github.com/pkg/errors v0.9.1: missing go.sum entry: run go mod tidy",
+		"go.sum: github.com/pkg/errors v0.9.0: missing go.sum entry: run go mod tidy",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without go.sum checks, only the allowlist matters.
+	ws = moduleWorkspace(t, "module script\n\nrequire github.com/spf13/cobra v1.8.0\n", "")
+	problems, _ = moduleProblems(ws, nil, []string{"github.com/Velocidex"}, false)
+	if len(problems) != 1 || problems[0].Message != notAllowedMessage || problems[0].Line != 3 {
+		t.Errorf("problems = %+v", problems)
+	}
+	if problems, err := moduleProblems(t.TempDir(), nil, nil, true); err != nil || len(problems) != 0 {
+		t.Errorf("workspace without go.mod: %+v, %v", problems, err)
+	}
+	if problems, _ := moduleProblems(moduleWorkspace(t, "go 1.21\nrequire (\n\t\"unterminated v1.0.0\n)\n", ""), nil, nil, false); len(problems) != 2 {
+		t.Errorf("malformed go.mod: %+v", problems)
+	}
+}
+
+func TestRunRejectsModulesOutsideAllowlist(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.ModuleAllowlist = []string{"github.com/Velocidex"}
+	r.ReplaceAllowlist = []string{"git.lab.example/forks"}
+	job := testJob(t)
+	job.Workspace = moduleWorkspace(t, testGoMod+"\nreplace github.com/St0n14/datamortem/services/sandbox-runners/go => /opt/datamortem-sdk\n", "")
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrModuleNotAllowed) || !strings.Contains(err.Error(), "golang.org/x/sys v0.28.0") {
+		t.Errorf("Run() = %v, want ErrModuleNotAllowed for golang.org/x/sys", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers created", len(rt.specs))
+	}
+
+	// A module replaced by Job.Replace is checked against
+	// Runner.ReplaceAllowlist instead.
+	job.Replace = []ModuleReplace{{Old: "golang.org/x/sys", New: "git.lab.example/forks/sys", NewVersion: "v0.28.1"}}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("Run() with golang.org/x/sys replaced = %v", err)
+	}
+	job.Replace = nil
+	job.Language = "python"
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("Run() of a Python job = %v", err)
+	}
+}
+
+func TestValidateReportsModuleProblems(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	res, err := r.Validate(context.Background(), moduleWorkspace(t, "module script\n\nrequire golang.org/x/sys latest\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || len(res.ModuleProblems) != 1 || res.ModuleProblems[0].Line != 3 {
+		t.Errorf("result = %+v", res)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers, want the build skipped", len(rt.specs))
+	}
+}
+
+func TestValidateResolvesModules(t *testing.T) {
+	rt := &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Cmd[1] != "mod" {
+			return ContainerState{}, ""
+		}
+		return ContainerState{ExitCode: 1}, `{"Path": "github.com/Velocidex/ordereddict", "Version": "v0.0.0-20230909174157-2aa49cc5d11d"}
+{
+	"Path": "golang.org/x/sys",
+	"Version": "v0.99.0",
+	"Error": "golang.org/x/sys@v0.99.0: invalid version: unknown revision v0.99.0"
+}
+`
+	}}
+	r := NewRunner(rt)
+	r.Vendor = &VendorConfig{Proxy: "https://goproxy.lab.example", Network: "lab-mirror"}
+	gomod := "module script\n\nrequire (\n\tgithub.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d\n\tgolang.org/x/sys v0.99.0\n)\n"
+	gosum := "github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d/go.mod h1:a=\ngolang.org/x/sys v0.99.0/go.mod h1:b=\n"
+	res, err := r.Validate(context.Background(), moduleWorkspace(t, gomod, gosum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ModuleProblem{{File: "go.mod", Line: 5, Module: "golang.org/x/sys", Version: "v0.99.0", Message: "golang.org/x/sys@v0.99.0: invalid version: unknown revision v0.99.0"}}
+	if res.Valid || !reflect.DeepEqual(res.ModuleProblems, want) {
+		t.Errorf("problems = %+v", res.ModuleProblems)
+	}
+	if len(rt.specs) != 1 {
+		t.Fatalf("%d containers, want the download only", len(rt.specs))
+	}
+	spec := rt.specs[0]
+	if got := strings.Join(spec.Cmd, " "); got != "go mod download -json github.com/Velocidex/ordereddict@v0.0.0-20230909174157-2aa49cc5d11d golang.org/x/sys@v0.99.0" {
+		t.Errorf("cmd = %s", got)
+	}
+	if spec.Env["GOPROXY"] != r.Vendor.Proxy || spec.Network != "lab-mirror" || spec.WorkDir != containerTmp || !spec.Mounts[0].ReadOnly {
+		t.Errorf("download spec %+v", spec)
+	}
+
+	// Modules that resolve let the build go ahead.
+	rt.outcome = nil
+	res, err = r.Validate(context.Background(), moduleWorkspace(t, gomod, gosum))
+	if err != nil || !res.Valid {
+		t.Errorf("Validate() = %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,122 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultGoVersion is the Go toolchain of the default Go runner image,
+// built from Dockerfile.go, which the jobs without Job.GoVersion run with.
+const DefaultGoVersion = "1.21"
+
+// LabelGoVersion labels a Go runner image with the exact version of its
+// toolchain, e.g. "1.22.5", recorded in JobResult.GoVersion.
+const LabelGoVersion = "datamortem.go.version"
+
+// ErrGoVersionUnavailable is returned for a job whose Job.GoVersion is
+// neither DefaultGoVersion nor a version of Runner.GoToolchains.
+var ErrGoVersionUnavailable = errors.New("orchestrator: Go version unavailable")
+
+var goVersionPattern = regexp.MustCompile(`^1\.[0-9]+(\.[0-9]+)?$`)
+
+// GoToolchain is a Go runner image of Runner.GoToolchains, built with
+// another toolchain than the default image's, e.g. by `make build-go
+// GO_VERSION=1.22`.
+type GoToolchain struct {
+	// Image is the runner image, e.g. "datamortem-sandbox-go:1.22".
+	Image string
+	// Digest pins Image as Runner.ImageDigests does the default Go image:
+	// its ID, or the digest of its manifest list. ExecConfig.ImageDigest
+	// still takes precedence.
+	Digest string
+}
+
+// GoVersions returns the Go versions a job can select with
+// Job.GoVersion, DefaultGoVersion included, oldest first.
+func (r *Runner) GoVersions() []string {
+	versions := []string{DefaultGoVersion}
+	for v := range r.GoToolchains {
+		if v != DefaultGoVersion {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return goVersionLess(versions[i], versions[j]) })
+	return versions
+}
+
+// goVersionLess orders Go versions by their numeric components, so that
+// 1.9 comes before 1.10.
+func goVersionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// validateGoVersion checks that the Go version job selects is available.
+func (r *Runner) validateGoVersion(job Job) error {
+	if job.GoVersion == "" {
+		return nil
+	}
+	if !goVersionPattern.MatchString(job.GoVersion) {
+		return fmt.Errorf("%w: Go version %q, want e.g. 1.22", ErrInvalidBuild, job.GoVersion)
+	}
+	if _, ok := r.GoToolchains[job.GoVersion]; !ok && job.GoVersion != DefaultGoVersion {
+		return fmt.Errorf("%w: %s, available: %s", ErrGoVersionUnavailable, job.GoVersion, strings.Join(r.GoVersions(), ", "))
+	}
+	return nil
+}
+
+// goToolchain returns the toolchain of Runner.GoToolchains that job
+// selects, ok false when it runs in the default Go image or is not a Go
+// job.
+func (r *Runner) goToolchain(job Job) (tc GoToolchain, ok bool) {
+	if job.GoVersion == "" || languageKey(job.Language) != LanguageGo {
+		return GoToolchain{}, false
+	}
+	tc, ok = r.GoToolchains[job.GoVersion]
+	return tc, ok
+}
+
+// jobImage returns the runner image of job, run with profile p: that of
+// its Go toolchain, if it selects one.
+func (r *Runner) jobImage(job Job, p runnerProfile) string {
+	if tc, ok := r.goToolchain(job); ok {
+		return tc.Image
+	}
+	return r.image(job.Language, p)
+}
+
+// jobImageDigest returns the digest that pins the runner image of job run
+// with cfg: that of imageDigest, or of its Go toolchain if it selects one.
+func (r *Runner) jobImageDigest(job Job, cfg ExecConfig) string {
+	if tc, ok := r.goToolchain(job); ok && cfg.ImageDigest == "" {
+		return tc.Digest
+	}
+	return r.imageDigest(job.Language, cfg)
+}
+
+// goVersion returns the exact Go version of info, the image of job: its
+// LabelGoVersion, or else the version job selected. It is empty for jobs
+// in another language.
+func goVersion(job Job, info ImageInfo) string {
+	if languageKey(job.Language) != LanguageGo {
+		return ""
+	}
+	if v := info.Labels[LabelGoVersion]; v != "" {
+		return v
+	}
+	if job.GoVersion != "" {
+		return job.GoVersion
+	}
+	return DefaultGoVersion
+}
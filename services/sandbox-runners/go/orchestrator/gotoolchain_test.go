@@ -0,0 +1,90 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunSelectsGoToolchain(t *testing.T) {
+	const image = "datamortem-sandbox-go:1.22"
+	rt := &fakeRuntime{images: map[string]ImageInfo{
+		image: {ID: fakeImageID(image), Labels: map[string]string{LabelGoVersion: "1.22.5"}},
+	}}
+	r := NewRunner(rt)
+	r.GoToolchains = map[string]GoToolchain{"1.22": {Image: image, Digest: fakeImageID(image)}}
+	// The pin of the default image does not apply to another toolchain.
+	r.ImageDigests = map[string]string{LanguageGo: fakeImageID("datamortem-sandbox-go:1.21")}
+	job := testJob(t)
+	job.GoVersion = "1.22"
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Image; got != fakeImageID(image) {
+		t.Errorf("container image = %s, want %s", got, fakeImageID(image))
+	}
+	if res.Image != image || res.GoVersion != "1.22.5" || res.Build == nil || res.Build.GoVersion != "1.22" {
+		t.Errorf("image %s, Go %s, build %+v", res.Image, res.GoVersion, res.Build)
+	}
+
+	// The default image, without a version label, records the default.
+	res, err = r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GoVersion != DefaultGoVersion || res.Image == image || res.Build != nil {
+		t.Errorf("image %s, Go %s, build %+v", res.Image, res.GoVersion, res.Build)
+	}
+}
+
+func TestGoVersionUnavailable(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.GoToolchains = map[string]GoToolchain{"1.22": {Image: "datamortem-sandbox-go:1.22"}}
+	for _, tc := range []struct {
+		version, language string
+		want              error
+	}{
+		{"1.19", "", ErrGoVersionUnavailable},
+		{"latest", "", ErrInvalidBuild},
+		{"1.22", LanguagePython, ErrInvalidBuild},
+	} {
+		job := testJob(t)
+		job.GoVersion, job.Language = tc.version, tc.language
+		_, err := r.Start(context.Background(), job)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s %s: err = %v, want %v", tc.language, tc.version, err, tc.want)
+		}
+		if tc.want == ErrGoVersionUnavailable && !strings.Contains(err.Error(), "available: 1.21, 1.22") {
+			t.Errorf("err = %v, want the available versions", err)
+		}
+	}
+
+	// The default version needs no toolchain of its own.
+	job := testJob(t)
+	job.GoVersion = DefaultGoVersion
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("default version: %v", err)
+	}
+}
+
+func TestGoToolchainDigestMismatch(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.GoToolchains = map[string]GoToolchain{"1.22": {Image: "datamortem-sandbox-go:1.22", Digest: fakeImageID("other")}}
+	job := testJob(t)
+	job.GoVersion = "1.22"
+	if _, err := r.Start(context.Background(), job); !errors.Is(err, ErrImageDigestMismatch) {
+		t.Errorf("err = %v, want ErrImageDigestMismatch", err)
+	}
+}
+
+func TestGoVersions(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.GoToolchains = map[string]GoToolchain{"1.9": {}, "1.22": {}, "1.10": {}, DefaultGoVersion: {}}
+	if got, want := r.GoVersions(), []string{"1.9", "1.10", DefaultGoVersion, "1.22"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GoVersions() = %v, want %v", got, want)
+	}
+}
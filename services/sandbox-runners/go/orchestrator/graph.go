@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectGraph reads the edges the script wrote with sandbox.EmitEdge.
+// Invalid lines are dropped and reported as err.
+func collectGraph(dir string) ([]sandbox.Edge, error) {
+	return sandbox.ReadGraph(dir)
+}
+
+// GraphNode is a node of a case graph, merged across the edges and jobs
+// that reported it.
+type GraphNode struct {
+	sandbox.Node
+	// JobIDs and EvidenceUIDs list the jobs and evidence items whose edges
+	// reported the node, in order of first report.
+	JobIDs       []string
+	EvidenceUIDs []string
+}
+
+// GraphEdge is a relationship of a case graph, merged across the jobs
+// that reported it.
+type GraphEdge struct {
+	Source   sandbox.Node
+	Relation string
+	Target   sandbox.Node
+	// Fields merges the fields of the reports, a later report replacing
+	// the value of an earlier one.
+	Fields map[string]any
+	// JobIDs and EvidenceUIDs list the jobs and evidence items that
+	// reported the edge, in order of first report.
+	JobIDs       []string
+	EvidenceUIDs []string
+	// Count is the number of times the edge was reported.
+	Count int
+}
+
+// edgeKey identifies an edge by its nodes and relation.
+type edgeKey struct {
+	source   sandbox.Node
+	relation string
+	target   sandbox.Node
+}
+
+// CaseGraph is the investigation graph of one case: the edges of its
+// jobs, with their nodes deduplicated by type and ID, so that the
+// process one script saw spawn a shell is the one another saw open a
+// connection. It is safe for concurrent use.
+type CaseGraph struct {
+	CaseID string
+
+	mu     sync.Mutex
+	nodes  []*GraphNode
+	edges  []*GraphEdge
+	byNode map[sandbox.Node]*GraphNode
+	byEdge map[edgeKey]*GraphEdge
+}
+
+// NewCaseGraph returns an empty graph for caseID.
+func NewCaseGraph(caseID string) *CaseGraph {
+	return &CaseGraph{CaseID: caseID, byNode: map[sandbox.Node]*GraphNode{}, byEdge: map[edgeKey]*GraphEdge{}}
+}
+
+// Add merges res.Graph, the outcome of job. A job of another case is
+// rejected: nodes are never merged across cases.
+func (g *CaseGraph) Add(job Job, res *JobResult) error {
+	if job.CaseID != g.CaseID {
+		return fmt.Errorf("orchestrator: job %s belongs to case %s, not %s", job.ID, job.CaseID, g.CaseID)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, edge := range res.Graph {
+		for _, n := range []sandbox.Node{edge.Source, edge.Target} {
+			node := g.byNode[n]
+			if node == nil {
+				node = &GraphNode{Node: n}
+				g.nodes = append(g.nodes, node)
+				g.byNode[n] = node
+			}
+			node.JobIDs = appendUnique(node.JobIDs, job.ID)
+			node.EvidenceUIDs = appendUnique(node.EvidenceUIDs, edge.EvidenceUID)
+		}
+		key := edgeKey{edge.Source, edge.Relation, edge.Target}
+		e := g.byEdge[key]
+		if e == nil {
+			e = &GraphEdge{Source: edge.Source, Relation: edge.Relation, Target: edge.Target}
+			g.edges = append(g.edges, e)
+			g.byEdge[key] = e
+		}
+		if len(edge.Fields) > 0 {
+			if e.Fields == nil {
+				e.Fields = map[string]any{}
+			}
+			maps.Copy(e.Fields, edge.Fields)
+		}
+		e.Count++
+		e.JobIDs = appendUnique(e.JobIDs, job.ID)
+		e.EvidenceUIDs = appendUnique(e.EvidenceUIDs, edge.EvidenceUID)
+	}
+	return nil
+}
+
+// Nodes returns the nodes of the graph in order of first report.
+func (g *CaseGraph) Nodes() []GraphNode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]GraphNode, len(g.nodes))
+	for i, n := range g.nodes {
+		out[i] = n.clone()
+	}
+	return out
+}
+
+// Edges returns the edges of the graph in order of first report.
+func (g *CaseGraph) Edges() []GraphEdge {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]GraphEdge, len(g.edges))
+	for i, e := range g.edges {
+		out[i] = e.clone()
+	}
+	return out
+}
+
+// Neighbors returns the edges from or to the node of type typ and ID id,
+// in order of first report, and false for a node not in the graph.
+func (g *CaseGraph) Neighbors(typ, id string) ([]GraphEdge, bool) {
+	n := sandbox.Node{Type: typ, ID: id}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.byNode[n] == nil {
+		return nil, false
+	}
+	var out []GraphEdge
+	for _, e := range g.edges {
+		if e.Source == n || e.Target == n {
+			out = append(out, e.clone())
+		}
+	}
+	return out, true
+}
+
+func (n *GraphNode) clone() GraphNode {
+	out := *n
+	out.JobIDs = append([]string(nil), n.JobIDs...)
+	out.EvidenceUIDs = append([]string(nil), n.EvidenceUIDs...)
+	return out
+}
+
+func (e *GraphEdge) clone() GraphEdge {
+	out := *e
+	out.Fields = maps.Clone(e.Fields)
+	out.JobIDs = append([]string(nil), e.JobIDs...)
+	out.EvidenceUIDs = append([]string(nil), e.EvidenceUIDs...)
+	return out
+}
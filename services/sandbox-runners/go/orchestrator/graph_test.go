@@ -0,0 +1,74 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsGraph(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.GraphFile), []byte(
+					`{"evidence_uid":"ev-1","source":{"type":"process","id":"4242"},"relation":"spawned","target":{"type":"process","id":"4300"}}`+"\n"+
+						`{"evidence_uid":"ev-1","source":{"type":"process","id":"4242"},"relation":"pwned","target":{"type":"host","id":"dc01"}}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Graph) != 1 || res.Graph[0].Relation != sandbox.RelationSpawned || res.Graph[0].Target.ID != "4300" {
+		t.Errorf("graph = %+v", res.Graph)
+	}
+	if res.GraphError == "" || len(res.InvalidRecords) != 1 || res.InvalidRecords[0].File != sandbox.GraphFile || res.InvalidRecords[0].Line != 2 {
+		t.Errorf("invalid records = %+v, want the unknown relation quarantined", res.InvalidRecords)
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("artifacts = %+v, want graph.ndjson left out", res.Artifacts)
+	}
+}
+
+func TestCaseGraphMerges(t *testing.T) {
+	g := NewCaseGraph("case-1")
+	add := func(jobID string, edges ...sandbox.Edge) {
+		t.Helper()
+		if err := g.Add(Job{ID: jobID, CaseID: "case-1"}, &JobResult{Graph: edges}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parent, child := sandbox.Node{Type: "process", ID: "4242"}, sandbox.Node{Type: "process", ID: "4300"}
+	dropped := sandbox.Node{Type: "file", ID: `C:\Temp\x.exe`}
+	add("job-1", sandbox.Edge{EvidenceUID: "ev-1", Source: parent, Relation: sandbox.RelationSpawned, Target: child, Fields: map[string]any{"cmdline": "cmd.exe"}})
+	add("job-2",
+		sandbox.Edge{EvidenceUID: "ev-2", Source: parent, Relation: sandbox.RelationSpawned, Target: child, Fields: map[string]any{"cmdline": "cmd.exe /c whoami", "user": "SYSTEM"}},
+		sandbox.Edge{EvidenceUID: "ev-2", Source: child, Relation: sandbox.RelationDropped, Target: dropped},
+	)
+
+	nodes := g.Nodes()
+	if len(nodes) != 3 || nodes[0].Node != parent || nodes[1].Node != child || nodes[2].Node != dropped {
+		t.Fatalf("nodes = %+v, want each process once", nodes)
+	}
+	if want := []string{"job-1", "job-2"}; !reflect.DeepEqual(nodes[1].JobIDs, want) || !reflect.DeepEqual(nodes[1].EvidenceUIDs, []string{"ev-1", "ev-2"}) {
+		t.Errorf("child node = %+v", nodes[1])
+	}
+	edges := g.Edges()
+	if len(edges) != 2 || edges[0].Count != 2 || !reflect.DeepEqual(edges[0].Fields, map[string]any{"cmdline": "cmd.exe /c whoami", "user": "SYSTEM"}) {
+		t.Errorf("edges = %+v, want the spawn merged with the latest fields", edges)
+	}
+	if around, ok := g.Neighbors("process", "4300"); !ok || len(around) != 2 {
+		t.Errorf("neighbors = %+v, %v, want both edges of the child", around, ok)
+	}
+	if _, ok := g.Neighbors("process", "1"); ok {
+		t.Error("unknown node found")
+	}
+	if err := g.Add(Job{ID: "job-3", CaseID: "case-2"}, &JobResult{}); err == nil {
+		t.Error("edges of another case were merged")
+	}
+}
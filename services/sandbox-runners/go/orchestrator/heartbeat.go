@@ -0,0 +1,163 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Files of a job's heartbeat directory on the host.
+const (
+	heartbeatDirPrefix = "datamortem-heartbeat-"
+	heartbeatFile      = "heartbeat"
+)
+
+// heartbeatBeats is the number of heartbeats the entrypoint is asked for
+// per ExecConfig.HeartbeatTimeout, so that a late one or two do not count
+// the worker as lost.
+const heartbeatBeats = 4
+
+// heartbeatMonitor watches the heartbeat the entrypoint of a job writes,
+// for ExecConfig.HeartbeatTimeout. Staleness is measured on the
+// orchestrator's clock, from the last time the heartbeat changed, so that
+// the clock of the worker does not matter.
+type heartbeatMonitor struct {
+	timeout time.Duration
+	dir     string
+	// last is the latest heartbeat, as the worker recorded it, and
+	// changed when the monitor saw it change, both in Unix nanoseconds.
+	last, changed atomic.Int64
+	// lost is set once the worker went the timeout without a heartbeat.
+	lost atomic.Bool
+}
+
+// stageHeartbeat creates the heartbeat directory of a job with cfg and
+// mounts it in spec, with the env of the entrypoint. It returns nil
+// without a HeartbeatTimeout or the entrypoint, which writes the
+// heartbeat.
+func (r *Runner) stageHeartbeat(spec *ContainerSpec, cfg ExecConfig) (*heartbeatMonitor, error) {
+	if cfg.HeartbeatTimeout <= 0 || !cfg.Entrypoint {
+		return nil, nil
+	}
+	dir, err := r.scratchDir(heartbeatDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	// The entrypoint writes as the sandbox user.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		r.removeDir(dir)
+		return nil, fmt.Errorf("heartbeat: %w", err)
+	}
+	spec.Mounts = append(spec.Mounts, Mount{Source: dir, Target: containerHeartbeatDir})
+	spec.Env[sandbox.EnvHeartbeatPath] = path.Join(containerHeartbeatDir, heartbeatFile)
+	spec.Env[sandbox.EnvHeartbeatInterval] = heartbeatInterval(cfg.HeartbeatTimeout).String()
+	return &heartbeatMonitor{timeout: cfg.HeartbeatTimeout, dir: dir}, nil
+}
+
+// heartbeatInterval is the interval of the heartbeat for timeout, at
+// least a second.
+func heartbeatInterval(timeout time.Duration) time.Duration {
+	return max(timeout/heartbeatBeats, time.Second)
+}
+
+// run polls the heartbeat from started until ctx or done is. It calls
+// onLost once the heartbeat has not changed for the timeout, and stops.
+func (m *heartbeatMonitor) run(ctx context.Context, done <-chan struct{}, started time.Time, onLost func()) {
+	m.changed.Store(started.UnixNano())
+	interval := min(max(m.timeout/10, 10*time.Millisecond), progressPollInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		m.poll()
+		if time.Since(time.Unix(0, m.changed.Load())) >= m.timeout {
+			m.lost.Store(true)
+			onLost()
+			return
+		}
+	}
+}
+
+// poll reads the heartbeat, noting when it changed. A malformed one, as
+// a partial write could only leave it, does not count as a beat.
+func (m *heartbeatMonitor) poll() {
+	t, err := sandbox.ReadHeartbeat(filepath.Join(m.dir, heartbeatFile))
+	if err != nil || t.IsZero() || t.UnixNano() == m.last.Load() {
+		return
+	}
+	m.last.Store(t.UnixNano())
+	m.changed.Store(time.Now().UnixNano())
+}
+
+// lastHeartbeat returns the latest heartbeat seen, the zero time before
+// the first one.
+func (m *heartbeatMonitor) lastHeartbeat() time.Time {
+	if m == nil || m.last.Load() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.last.Load()).UTC()
+}
+
+// start runs m in the background until ctx or done is, calling cancel to
+// stop the job once its worker is lost.
+func (m *heartbeatMonitor) start(ctx context.Context, done <-chan struct{}, started time.Time, cancel context.CancelFunc) {
+	if m == nil {
+		return
+	}
+	go m.run(ctx, done, started, cancel)
+}
+
+// isLost reports whether m gave the worker up.
+func (m *heartbeatMonitor) isLost() bool {
+	return m != nil && m.lost.Load()
+}
+
+// cleanupContext bounds ctx, for the cleanup of a job whose worker is
+// lost, to its grace period and the timeout.
+func (m *heartbeatMonitor) cleanupContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, grace+m.timeout)
+}
+
+// apply records in res the latest heartbeat of the job and, when lost is
+// set for the job given up for it, fails it with FailureWorkerLost.
+func (m *heartbeatMonitor) apply(res *JobResult, lost bool) {
+	if m == nil {
+		return
+	}
+	m.poll()
+	res.LastHeartbeat = m.lastHeartbeat()
+	if !lost || res.Cancelled {
+		return
+	}
+	res.WorkerLost = true
+	res.Incomplete = true
+	res.Success = false
+	res.FailureReason = FailureWorkerLost
+	if res.LastHeartbeat.IsZero() {
+		res.FailureDetail = fmt.Sprintf("no heartbeat from the worker within %s", m.timeout)
+	} else {
+		res.FailureDetail = fmt.Sprintf("no heartbeat from the worker for %s, the last at %s", m.timeout, res.LastHeartbeat.Format(time.RFC3339))
+	}
+}
+
+// LastHeartbeat returns the latest heartbeat of the job's worker, the
+// zero time before the first one or without ExecConfig.HeartbeatTimeout.
+func (e *Execution) LastHeartbeat() time.Time {
+	if e.heartbeat == nil {
+		return time.Time{}
+	}
+	e.heartbeat.poll()
+	return e.heartbeat.lastHeartbeat()
+}
@@ -0,0 +1,112 @@
+package orchestrator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func heartbeatJob(t *testing.T, timeout time.Duration) Job {
+	t.Helper()
+	cfg := DefaultExecConfig()
+	cfg.Entrypoint, cfg.HeartbeatTimeout = true, timeout
+	cfg.GracePeriod = 10 * time.Millisecond
+	job := testJob(t)
+	job.Config = &cfg
+	return job
+}
+
+// heartbeatPath returns the host path of the heartbeat of spec.
+func heartbeatPath(spec ContainerSpec) string {
+	for _, m := range spec.Mounts {
+		if m.Target == containerHeartbeatDir {
+			return filepath.Join(m.Source, heartbeatFile)
+		}
+	}
+	return ""
+}
+
+func TestHeartbeatLostWorker(t *testing.T) {
+	rt := &fakeRuntime{block: true, unreachable: true}
+	r := NewRunner(rt)
+	beat := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	// The worker beats once, then its host dies.
+	rt.onStart = func(spec ContainerSpec) {
+		if err := sandbox.WriteHeartbeat(heartbeatPath(spec), beat); err != nil {
+			t.Error(err)
+		}
+	}
+	job := heartbeatJob(t, 50*time.Millisecond)
+	job.Config.RunTimeout = time.Minute
+	started := time.Now()
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("lost worker given up after %s", elapsed)
+	}
+	if !res.WorkerLost || res.Success || !res.Incomplete || res.TimedOut || res.FailureReason != FailureWorkerLost {
+		t.Errorf("result = %+v, want the worker lost", res)
+	}
+	if !res.LastHeartbeat.Equal(beat) {
+		t.Errorf("last heartbeat %v, want %v", res.LastHeartbeat, beat)
+	}
+	spec := rt.lastSpec()
+	if spec.Env[sandbox.EnvHeartbeatPath] != "/run/datamortem-heartbeat/heartbeat" || spec.Env[sandbox.EnvHeartbeatInterval] != "1s" {
+		t.Errorf("env %v", spec.Env)
+	}
+}
+
+func TestHeartbeatLiveWorker(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Jobs = NewJobIndex()
+	beat := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rt.onStart = func(spec ContainerSpec) {
+		sandbox.WriteHeartbeat(heartbeatPath(spec), beat)
+	}
+	res, err := r.Run(context.Background(), heartbeatJob(t, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.WorkerLost || !res.LastHeartbeat.Equal(beat) {
+		t.Errorf("result = %+v", res)
+	}
+	if recs := r.Jobs.Query(JobFilter{}); len(recs) != 1 || !recs[0].LastHeartbeat.Equal(beat) {
+		t.Errorf("job records %+v", recs)
+	}
+}
+
+func TestHeartbeatRetryWorkerLost(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	r.Retry = RetryPolicy{MaxAttempts: 2, RetryWorkerLost: true}
+	starts := 0
+	// The first worker never beats; the second run completes.
+	rt.onStart = func(spec ContainerSpec) {
+		if starts++; starts == 2 {
+			rt.block = false
+		}
+	}
+	res, err := r.Run(context.Background(), heartbeatJob(t, 30*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.WorkerLost || res.Attempts != 2 || starts != 2 {
+		t.Errorf("result = %+v after %d starts, want the retry to succeed", res, starts)
+	}
+
+	// Without RetryWorkerLost the lost job is not run again.
+	rt.block, starts = true, 0
+	r.Retry.RetryWorkerLost = false
+	if res, err = r.Run(context.Background(), heartbeatJob(t, 30*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if !res.WorkerLost || starts != 1 {
+		t.Errorf("result = %+v after %d starts", res, starts)
+	}
+}
@@ -0,0 +1,140 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// watchdog flags a job that has been inactive for ExecConfig.IdleTimeout:
+// it wrote nothing to stdout or stderr, which it receives as an io.Writer,
+// and did not add to progress.ndjson.
+type watchdog struct {
+	timeout  time.Duration
+	progress string
+	// last is the time of the latest activity, in Unix nanoseconds.
+	last atomic.Int64
+	// idle is set while the job is inactive, flagged once it has been.
+	idle, flagged atomic.Bool
+	// killed is set once the job was stopped for its inactivity.
+	killed atomic.Bool
+	size   int64
+}
+
+// newWatchdog returns the watchdog of a job with cfg whose OUTPUT_DIR is
+// outputDir on the host, nil without an IdleTimeout.
+func newWatchdog(cfg ExecConfig, outputDir string) *watchdog {
+	if cfg.IdleTimeout <= 0 {
+		return nil
+	}
+	w := &watchdog{timeout: cfg.IdleTimeout, progress: filepath.Join(outputDir, sandbox.ProgressFile)}
+	w.touch()
+	return w
+}
+
+func (w *watchdog) touch() {
+	w.last.Store(time.Now().UnixNano())
+	w.idle.Store(false)
+}
+
+func (w *watchdog) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.touch()
+	}
+	return len(p), nil
+}
+
+// output returns out, also feeding w when it is set.
+func (w *watchdog) output(out io.Writer) io.Writer {
+	if w == nil {
+		return out
+	}
+	return io.MultiWriter(out, w)
+}
+
+// run checks the job's activity until ctx or done is. It calls onIdle
+// once, the first time the job has been inactive for the timeout.
+func (w *watchdog) run(ctx context.Context, done <-chan struct{}, onIdle func()) {
+	interval := min(max(w.timeout/10, 10*time.Millisecond), progressPollInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if info, err := os.Stat(w.progress); err == nil && info.Size() != w.size {
+			w.size = info.Size()
+			w.touch()
+		}
+		if time.Since(time.Unix(0, w.last.Load())) < w.timeout {
+			continue
+		}
+		w.idle.Store(true)
+		if !w.flagged.Swap(true) {
+			onIdle()
+		}
+	}
+}
+
+// possiblyHung reports whether the job is inactive at the moment.
+func (w *watchdog) possiblyHung() bool {
+	return w != nil && w.idle.Load()
+}
+
+// apply records in res what w saw of the job, cfg its configuration;
+// killed is set when the job was stopped for its inactivity.
+func (w *watchdog) apply(res *JobResult, cfg ExecConfig, killed bool) {
+	if w == nil {
+		return
+	}
+	res.PossiblyHung = w.flagged.Load()
+	if killed && !res.Cancelled {
+		res.IdleKilled = true
+		res.Incomplete = true
+		res.Success = false
+		res.FailureReason = FailureHung
+		res.FailureDetail = fmt.Sprintf("stopped after %s without output or progress", cfg.IdleTimeout)
+	}
+}
+
+// start runs w in the background until ctx or done is. With kill, it
+// calls cancel to stop the job once it is flagged.
+func (w *watchdog) start(ctx context.Context, done <-chan struct{}, kill bool, cancel context.CancelFunc) {
+	if w == nil {
+		return
+	}
+	go w.run(ctx, done, func() {
+		if kill {
+			w.killed.Store(true)
+			cancel()
+		}
+	})
+}
+
+// watch starts the watchdog of e, if any, on the container's output;
+// cancelRun stops the job.
+func (e *Execution) watch(cancelRun context.CancelFunc) {
+	if e.watchdog == nil {
+		return
+	}
+	go e.runner.Runtime.Follow(e.runCtx, e.id, e.watchdog, e.watchdog)
+	e.watchdog.start(e.runCtx, e.exited, e.cfg.KillIdle, cancelRun)
+}
+
+// PossiblyHung reports whether the job has been inactive, without output
+// on stdout or stderr nor progress, for ExecConfig.IdleTimeout; it is false
+// again once the job shows activity. It is always false without an
+// IdleTimeout.
+func (e *Execution) PossiblyHung() bool {
+	return e.watchdog.possiblyHung()
+}
@@ -0,0 +1,120 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func idleJob(t *testing.T, idle time.Duration, kill bool) Job {
+	t.Helper()
+	cfg := DefaultExecConfig()
+	cfg.IdleTimeout, cfg.KillIdle = idle, kill
+	cfg.GracePeriod = 10 * time.Millisecond
+	job := testJob(t)
+	job.Config = &cfg
+	return job
+}
+
+func TestIdleTimeoutFlagsSilentJob(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	job := idleJob(t, 30*time.Millisecond, false)
+	job.Config.RunTimeout = 300 * time.Millisecond
+
+	exec, err := r.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !exec.PossiblyHung() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !exec.PossiblyHung() {
+		t.Fatal("silent job not flagged as possibly hung")
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without KillIdle the job runs on to its timeout.
+	if !res.PossiblyHung || res.IdleKilled || !res.TimedOut || res.FailureReason != FailureRunTimeout {
+		t.Errorf("result = %+v, want flagged and timed out", res)
+	}
+}
+
+func TestKillIdleStopsSilentJob(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	var progress string
+	// The job reports progress for a while, then goes silent.
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				progress = filepath.Join(m.Source, sandbox.ProgressFile)
+			}
+		}
+		go func() {
+			for i := 0; i < 15; i++ {
+				f, err := os.OpenFile(progress, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+				if err != nil {
+					return
+				}
+				f.WriteString(`{"done":1,"total":15}` + "\n")
+				f.Close()
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	started := time.Now()
+	res, err := r.Run(context.Background(), idleJob(t, 60*time.Millisecond, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("job killed after %s, while it still reported progress", elapsed)
+	}
+	if !res.IdleKilled || !res.PossiblyHung || res.TimedOut || res.Success || !res.Incomplete || res.FailureReason != FailureHung {
+		t.Errorf("result = %+v, want killed for inactivity", res)
+	}
+	if len(rt.signals) == 0 || rt.signals[0] != "SIGTERM" {
+		t.Errorf("signals = %v, want a graceful stop", rt.signals)
+	}
+}
+
+func TestIdleTimeoutIgnoresActiveJob(t *testing.T) {
+	rt := &fakeRuntime{stdout: "parsing\n"}
+	r := NewRunner(rt)
+	res, err := r.Run(context.Background(), idleJob(t, time.Minute, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.PossiblyHung || res.IdleKilled {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestPoolKillIdleRetiresContainer(t *testing.T) {
+	rt := &fakeRuntime{execBlock: true}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	job := poolJob(t, "case-1")
+	cfg := DefaultExecConfig()
+	cfg.IdleTimeout, cfg.KillIdle = 20*time.Millisecond, true
+	cfg.GracePeriod = 10 * time.Millisecond
+	job.Config = &cfg
+
+	res, err := p.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.IdleKilled || res.FailureReason != FailureHung || res.TimedOut {
+		t.Errorf("result = %+v, want killed for inactivity", res)
+	}
+	if m := p.Metrics(); m.Hits != 1 || m.Idle != 0 {
+		t.Errorf("metrics = %+v, want the job pooled and its container retired", m)
+	}
+}
@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ErrImageDigestMismatch is returned when the runner image does not have
+// the digest set in ExecConfig.ImageDigest or Runner.ImageDigests.
+var ErrImageDigestMismatch = errors.New("orchestrator: runner image does not match its pinned digest")
+
+// ErrImageContract is returned by Probe for a runner image that cannot run
+// scripts: it lacks the sandbox user, a writable /workspace or /output, or
+// the toolchain of its language.
+var ErrImageContract = errors.New("orchestrator: runner image does not satisfy the runner contract")
+
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ImageInfo identifies a local image.
+type ImageInfo struct {
+	// ID is the digest of the image configuration, "sha256:...".
+	ID string
+	// RepoDigests are the registry manifest digests of the image, as
+	// "repository@sha256:...".
+	RepoDigests []string
+	// OS and Architecture are the platform the image is built for, e.g.
+	// "linux" and "arm64"; empty when the engine does not tell.
+	OS           string
+	Architecture string
+	// Labels are the labels of the image, e.g. LabelGoVersion.
+	Labels map[string]string
+}
+
+// matches reports whether digest is the image's ID or one of its registry
+// digests.
+func (i ImageInfo) matches(digest string) bool {
+	if i.ID == digest {
+		return true
+	}
+	for _, d := range i.RepoDigests {
+		if strings.HasSuffix(d, "@"+digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinImage resolves image, the runner image of language, for the runner's
+// architecture to its ID and checks it against cfg.ImageDigest, or
+// Runner.ArchImageDigests and Runner.ImageDigests for the language when
+// the configuration pins none. The job's containers are created from the
+// ID, so that moving the tag while a job starts cannot change what it
+// runs.
+func (r *Runner) pinImage(ctx context.Context, language, image string, cfg ExecConfig) (string, error) {
+	info, err := r.resolveImage(ctx, image, r.imageDigest(language, cfg))
+	return info.ID, err
+}
+
+// resolveImage inspects image for the runner's architecture and checks it
+// against digest, unless empty.
+func (r *Runner) resolveImage(ctx context.Context, image, digest string) (ImageInfo, error) {
+	if digest != "" && !imageDigestPattern.MatchString(digest) {
+		return ImageInfo{}, fmt.Errorf("orchestrator: invalid image digest %q, want sha256:<hex>", digest)
+	}
+	info, err := r.Runtime.InspectImage(ctx, image, r.platform())
+	if err != nil {
+		return ImageInfo{}, &InfraError{Op: "inspect image", Err: err}
+	}
+	if info.ID == "" {
+		return ImageInfo{}, fmt.Errorf("orchestrator: image %s has no ID", image)
+	}
+	if arch := normalizeArch(info.Architecture); arch != "" && arch != r.arch() {
+		return ImageInfo{}, fmt.Errorf("%w: %s is built for %s, the worker is %s", ErrImageArchitecture, image, arch, r.arch())
+	}
+	if digest != "" && !info.matches(digest) {
+		return ImageInfo{}, fmt.Errorf("%w: %s is %s, want %s", ErrImageDigestMismatch, image, info.ID, digest)
+	}
+	return info, nil
+}
+
+// CheckImages probes the runner image configured in Images for each
+// language with Probe, before jobs are scheduled. Call it at startup; the
+// error joins those of every image that is not ready.
+func (r *Runner) CheckImages(ctx context.Context) error {
+	languages := make([]string, 0, len(r.Images))
+	for lang := range r.Images {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	var errs []error
+	for _, lang := range languages {
+		if _, err := r.Probe(ctx, lang); err != nil {
+			errs = append(errs, fmt.Errorf("%s image %s: %w", lang, r.Images[lang], err))
+		}
+	}
+	return errors.Join(errs...)
+}
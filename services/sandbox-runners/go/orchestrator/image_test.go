@@ -0,0 +1,106 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunPinsImageDigest(t *testing.T) {
+	const image = "datamortem-sandbox-go:1.21"
+	id := "sha256:" + strings.Repeat("a", 64)
+	manifest := "sha256:" + strings.Repeat("b", 64)
+	rt := &fakeRuntime{images: map[string]ImageInfo{
+		image: {ID: id, RepoDigests: []string{"registry.example/sandbox-go@" + manifest}},
+	}}
+	r := NewRunner(rt)
+
+	run := func(digest string) (*JobResult, error) {
+		t.Helper()
+		cfg := DefaultExecConfig()
+		cfg.ImageDigest = digest
+		job := testJob(t)
+		job.Config = &cfg
+		return r.Run(context.Background(), job)
+	}
+	for _, digest := range []string{"", id, manifest} {
+		res, err := run(digest)
+		if err != nil {
+			t.Fatalf("digest %q: %v", digest, err)
+		}
+		if res.Image != image || res.ImageDigest != id || rt.lastSpec().Image != id {
+			t.Errorf("digest %q: image %s (%s), container image %s", digest, res.Image, res.ImageDigest, rt.lastSpec().Image)
+		}
+	}
+
+	created := len(rt.specs)
+	_, err := run("sha256:" + strings.Repeat("c", 64))
+	if !errors.Is(err, ErrImageDigestMismatch) || Retryable(err) {
+		t.Errorf("err = %v, want a mismatch that is not retried", err)
+	}
+	if _, err := run("latest"); err == nil || !strings.Contains(err.Error(), "invalid image digest") {
+		t.Errorf("err = %v, want an invalid digest", err)
+	}
+	if len(rt.specs) != created {
+		t.Errorf("%d containers created for mismatching images", len(rt.specs)-created)
+	}
+}
+
+func TestRunPinsLanguageDigest(t *testing.T) {
+	const image = "registry.corp/sandbox-go:1.22"
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Images = map[string]string{LanguageGo: image}
+	r.ImageDigests = map[string]string{LanguageGo: fakeImageID(image)}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Image != image || rt.lastSpec().Image != fakeImageID(image) {
+		t.Errorf("image %s, container image %s", res.Image, rt.lastSpec().Image)
+	}
+
+	r.ImageDigests[LanguageGo] = "sha256:" + strings.Repeat("c", 64)
+	if _, err := r.Run(context.Background(), testJob(t)); !errors.Is(err, ErrImageDigestMismatch) {
+		t.Errorf("err = %v, want ErrImageDigestMismatch", err)
+	}
+	// A job's own digest takes precedence over the language's.
+	cfg := DefaultExecConfig()
+	cfg.ImageDigest = fakeImageID(image)
+	job := testJob(t)
+	job.Config = &cfg
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("job digest: %v", err)
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Images = map[string]string{LanguageGo: "registry.corp/sandbox-go:1.22", LanguagePython: "registry.corp/sandbox-python:3.12"}
+	if err := r.CheckImages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.specs) != 2 {
+		t.Fatalf("%d probes", len(rt.specs))
+	}
+	spec := rt.specs[0]
+	if spec.Image != fakeImageID(r.Images[LanguageGo]) || strings.Join(spec.Cmd[4:], " ") != "go version" || spec.User != "sandbox" || spec.Network != string(NetworkNone) || len(spec.Mounts) != 0 {
+		t.Errorf("probe = %+v", spec)
+	}
+
+	// Outcomes are cached per image: a fresh runner probes again.
+	r = &Runner{Runtime: rt, Images: r.Images, Defaults: r.Defaults}
+	rt.outcome = func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Cmd[4] == "python" {
+			return ContainerState{ExitCode: 127}, "python: not found\n"
+		}
+		return ContainerState{}, ""
+	}
+	err := r.CheckImages(context.Background())
+	if !errors.Is(err, ErrImageContract) || !strings.Contains(err.Error(), "python image registry.corp/sandbox-python:3.12") || strings.Contains(err.Error(), "go image") {
+		t.Errorf("err = %v, want the python image only", err)
+	}
+}
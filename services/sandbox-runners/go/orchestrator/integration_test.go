@@ -0,0 +1,233 @@
+//go:build integration
+
+// Integration tests need a docker daemon and the images from `make build-all`.
+// Run them with: go test -tags integration ./orchestrator/
+
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// copyScript copies a testdata script into a fresh workspace.
+func copyScript(t *testing.T, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join("testdata", name)
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Chmod(dir, 0777)
+	return dir
+}
+
+func TestIntegrationEvidenceIsReadOnly(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, []byte("original"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	os.Chmod(output, 0777)
+
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Run(context.Background(), Job{
+		ID:        "it-readonly",
+		CaseID:    "it",
+		Evidence:  Evidence{UID: "ev", Path: evidence},
+		Workspace: copyScript(t, "readonly-evidence"),
+		OutputDir: output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("exit %d: %s%s", res.ExitCode, res.Stdout, res.Stderr)
+	}
+	if data, _ := os.ReadFile(evidence); string(data) != "original" {
+		t.Errorf("evidence modified: %q", data)
+	}
+}
+
+func TestIntegrationSeccompProfile(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	os.Chmod(output, 0777)
+
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Run(context.Background(), Job{
+		ID:        "it-seccomp",
+		CaseID:    "it",
+		Evidence:  Evidence{UID: "ev", Path: evidence},
+		Workspace: copyScript(t, "seccomp"),
+		OutputDir: output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("exit %d: %s%s", res.ExitCode, res.Stdout, res.Stderr)
+	}
+}
+
+func TestIntegrationOutputQuota(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	os.Chmod(output, 0777)
+	cfg := DefaultExecConfig()
+	cfg.OutputQuotaBytes = 1 << 20
+
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Run(context.Background(), Job{
+		ID:        "it-quota",
+		CaseID:    "it",
+		Evidence:  Evidence{UID: "ev", Path: evidence},
+		Workspace: copyScript(t, "output-quota"),
+		OutputDir: output,
+		Config:    &cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("exit %d: %s%s", res.ExitCode, res.Stdout, res.Stderr)
+	}
+	if !res.OutputTruncated {
+		t.Error("OutputTruncated not set")
+	}
+	if info, err := os.Stat(filepath.Join(output, "dump.bin")); err != nil || info.Size() > cfg.OutputQuotaBytes {
+		t.Errorf("collected dump: %v, %v", info, err)
+	}
+}
+
+func TestIntegrationResourceMetrics(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, make([]byte, 4<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	os.Chmod(output, 0777)
+	cfg := DefaultExecConfig()
+	cfg.ResourceMetrics = true
+
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Run(context.Background(), Job{
+		ID:        "it-metrics",
+		CaseID:    "it",
+		Evidence:  Evidence{UID: "ev", Path: evidence},
+		Workspace: copyScript(t, "resource-metrics"),
+		OutputDir: output,
+		Config:    &cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("exit %d: %s%s", res.ExitCode, res.Stdout, res.Stderr)
+	}
+	// The evidence was just written and may be read from the page cache,
+	// so EvidenceBytesRead is not checked.
+	m := res.Metrics
+	if m.CPUTime <= 0 || m.PeakMemoryBytes < 64<<20 || m.OutputBytes <= 0 || m.Duration <= 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if len(res.Outputs) != 1 || res.Outputs[0] != "report.txt" {
+		t.Errorf("outputs = %v", res.Outputs)
+	}
+}
+
+func TestIntegrationValidate(t *testing.T) {
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Validate(context.Background(), copyScript(t, "invalid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || len(res.CompileErrors) != 1 || res.CompileErrors[0].File != "main.go" || res.CompileErrors[0].Line != 10 {
+		t.Errorf("result = %+v\n%s", res, res.Output)
+	}
+}
+
+func TestIntegrationReproducibleBuild(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, []byte("original"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var sums []string
+	for i := 0; i < 2; i++ {
+		output := t.TempDir()
+		os.Chmod(output, 0777)
+		// A fresh cache for each run, so that the script is built twice.
+		cache := t.TempDir()
+		os.Chmod(cache, 0777)
+		r := NewRunner(&DockerRuntime{})
+		r.BuildCache = &BuildCache{Dir: cache}
+		res, err := r.Run(context.Background(), Job{
+			ID:        "it-reproducible",
+			CaseID:    "it",
+			Evidence:  Evidence{UID: "ev", Path: evidence},
+			Workspace: copyScript(t, "readonly-evidence"),
+			OutputDir: output,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.BinarySHA256 == "" {
+			t.Fatalf("no binary SHA256: %s%s", res.Stdout, res.Stderr)
+		}
+		sums = append(sums, res.BinarySHA256)
+	}
+	if sums[0] != sums[1] {
+		t.Errorf("binary SHA256 = %s, then %s", sums[0], sums[1])
+	}
+}
+
+func TestIntegrationSDKBuildsOffline(t *testing.T) {
+	evidence := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(evidence, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := t.TempDir()
+	os.Chmod(output, 0777)
+	workspace := copyScript(t, "sdk-offline")
+	// The sums of the SDK dependencies, as a script importing it records.
+	sums, err := os.ReadFile(filepath.Join("..", "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace, "go.sum"), sums, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(&DockerRuntime{})
+	res, err := r.Run(context.Background(), Job{
+		ID:        "it-sdk-offline",
+		CaseID:    "it",
+		Evidence:  Evidence{UID: "ev", Path: evidence},
+		Workspace: workspace,
+		OutputDir: output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ExitCode != 0 || len(res.Findings) != 1 {
+		t.Fatalf("exit %d, %d findings: %s%s", res.ExitCode, len(res.Findings), res.Stdout, res.Stderr)
+	}
+}
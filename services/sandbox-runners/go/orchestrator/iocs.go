@@ -0,0 +1,133 @@
+package orchestrator
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectIOCs reads the indicators the script wrote with sandbox.EmitIOC.
+// Lines whose value is invalid for their kind are dropped and reported as
+// err.
+func collectIOCs(dir string) ([]sandbox.IOC, error) {
+	return sandbox.ReadIOCs(dir)
+}
+
+// CaseIOC is an indicator of a case, merged across the jobs that reported
+// it.
+type CaseIOC struct {
+	// Key is the sandbox.IOC Key shared by the reports.
+	Key   string
+	Kind  sandbox.IOCKind
+	Value string
+	// Contexts lists the distinct contexts reported, in order of first
+	// report.
+	Contexts []string
+	// JobIDs and EvidenceUIDs list the jobs and evidence items that
+	// reported the indicator, in order of first report.
+	JobIDs       []string
+	EvidenceUIDs []string
+	// Count is the number of times the indicator was reported.
+	Count int
+	// SuppressedBy is the ID of the rule of CaseIOCs.Suppressions that
+	// suppressed the indicator: it is kept, but IOCs leaves it out.
+	SuppressedBy string
+	// Enrichments gathers the data the enrichers of Runner.Enrichment
+	// attached to the reports of the indicator, that of the latest report
+	// of each enricher.
+	Enrichments Enrichments
+}
+
+// CaseIOCs is the IOC index of one case: the indicators of its jobs, one
+// per sandbox.IOC Key, for enrichment and hunting integrations. It is
+// safe for concurrent use.
+type CaseIOCs struct {
+	CaseID string
+	// Suppressions, when set, suppresses the indicators its rules for the
+	// case match as they are added.
+	Suppressions *Suppressions
+
+	mu    sync.Mutex
+	iocs  []*CaseIOC
+	byKey map[string]*CaseIOC
+}
+
+// NewCaseIOCs returns an empty IOC index for caseID.
+func NewCaseIOCs(caseID string) *CaseIOCs {
+	return &CaseIOCs{CaseID: caseID, byKey: map[string]*CaseIOC{}}
+}
+
+// Add merges res.IOCs, the outcome of job. A job of another case is
+// rejected: indicators are never merged across cases.
+func (c *CaseIOCs) Add(job Job, res *JobResult) error {
+	if job.CaseID != c.CaseID {
+		return fmt.Errorf("orchestrator: job %s belongs to case %s, not %s", job.ID, job.CaseID, c.CaseID)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, ioc := range res.IOCs {
+		key := ioc.Key()
+		e := c.byKey[key]
+		if e == nil {
+			// The first report's value is kept as written.
+			e = &CaseIOC{Key: key, Kind: ioc.Kind, Value: ioc.Value, SuppressedBy: c.Suppressions.matchIOC(c.CaseID, ioc)}
+			c.iocs = append(c.iocs, e)
+			c.byKey[key] = e
+		}
+		e.Count++
+		e.Enrichments = e.Enrichments.merge(res.IOCEnrichments[i])
+		if ioc.Context != "" {
+			e.Contexts = appendUnique(e.Contexts, ioc.Context)
+		}
+		e.JobIDs = appendUnique(e.JobIDs, job.ID)
+		e.EvidenceUIDs = appendUnique(e.EvidenceUIDs, ioc.EvidenceUID)
+	}
+	return nil
+}
+
+// IOCs returns the indexed indicators in order of first report, the
+// suppressed ones left out.
+func (c *CaseIOCs) IOCs() []CaseIOC {
+	return c.list(false)
+}
+
+// Suppressed returns the suppressed indicators in order of first report.
+func (c *CaseIOCs) Suppressed() []CaseIOC {
+	return c.list(true)
+}
+
+func (c *CaseIOCs) list(suppressed bool) []CaseIOC {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []CaseIOC
+	for _, e := range c.iocs {
+		if (e.SuppressedBy != "") == suppressed {
+			out = append(out, e.clone())
+		}
+	}
+	return out
+}
+
+// Lookup returns the indicator of kind with value, compared as by
+// sandbox.IOC Key, suppressed or not.
+func (c *CaseIOCs) Lookup(kind sandbox.IOCKind, value string) (CaseIOC, bool) {
+	key := sandbox.IOC{Kind: kind, Value: value}.Key()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byKey[key]
+	if !ok {
+		return CaseIOC{}, false
+	}
+	return e.clone(), true
+}
+
+func (e *CaseIOC) clone() CaseIOC {
+	out := *e
+	out.Contexts = append([]string(nil), e.Contexts...)
+	out.JobIDs = append([]string(nil), e.JobIDs...)
+	out.EvidenceUIDs = append([]string(nil), e.EvidenceUIDs...)
+	out.Enrichments = maps.Clone(e.Enrichments)
+	return out
+}
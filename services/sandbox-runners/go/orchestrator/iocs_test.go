@@ -0,0 +1,74 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsIOCs(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.IOCsFile), []byte(
+					`{"evidence_uid":"ev-1","kind":"ipv4","value":"198.51.100.4","context":"beacon"}`+"\n"+
+						`{"evidence_uid":"ev-1","kind":"ipv4","value":"198.51.100.400"}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.IOCs) != 1 || res.IOCs[0].Value != "198.51.100.4" || res.IOCs[0].Context != "beacon" {
+		t.Errorf("iocs = %+v", res.IOCs)
+	}
+	if res.IOCsError == "" || len(res.InvalidRecords) != 1 || res.InvalidRecords[0].File != sandbox.IOCsFile || res.InvalidRecords[0].Line != 2 {
+		t.Errorf("invalid records = %+v, want the malformed IP quarantined", res.InvalidRecords)
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("artifacts = %+v, want iocs.ndjson left out", res.Artifacts)
+	}
+}
+
+func TestCaseIOCsMerges(t *testing.T) {
+	c := NewCaseIOCs("case-1")
+	add := func(jobID string, iocs ...sandbox.IOC) {
+		t.Helper()
+		if err := c.Add(Job{ID: jobID, CaseID: "case-1"}, &JobResult{IOCs: iocs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("job-1",
+		sandbox.IOC{EvidenceUID: "ev-1", Kind: sandbox.IOCDomain, Value: "Evil.example", Context: "DNS cache"},
+		sandbox.IOC{EvidenceUID: "ev-1", Kind: sandbox.IOCMutex, Value: "Global\\Mtx"},
+	)
+	add("job-2", sandbox.IOC{EvidenceUID: "ev-2", Kind: sandbox.IOCDomain, Value: "evil.example.", Context: "browser history"})
+	add("job-3", sandbox.IOC{EvidenceUID: "ev-1", Kind: sandbox.IOCDomain, Value: "evil.example", Context: "DNS cache"})
+
+	iocs := c.IOCs()
+	if len(iocs) != 2 {
+		t.Fatalf("%d IOCs, want the domain once and the mutex", len(iocs))
+	}
+	d := iocs[0]
+	if d.Key != "ioc/domain/evil.example" || d.Value != "Evil.example" || d.Count != 3 {
+		t.Errorf("merged IOC = %+v", d)
+	}
+	if want := []string{"DNS cache", "browser history"}; !reflect.DeepEqual(d.Contexts, want) {
+		t.Errorf("contexts = %v, want %v", d.Contexts, want)
+	}
+	if want := []string{"ev-1", "ev-2"}; !reflect.DeepEqual(d.EvidenceUIDs, want) {
+		t.Errorf("evidence UIDs = %v, want %v", d.EvidenceUIDs, want)
+	}
+	if got, ok := c.Lookup(sandbox.IOCDomain, "EVIL.EXAMPLE"); !ok || got.Count != 3 {
+		t.Errorf("lookup = %+v, %v", got, ok)
+	}
+
+	if err := c.Add(Job{ID: "job-4", CaseID: "case-2"}, &JobResult{}); err == nil {
+		t.Error("IOCs of another case were merged")
+	}
+}
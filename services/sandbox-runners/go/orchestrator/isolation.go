@@ -0,0 +1,67 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RuntimeGVisor is the OCI runtime of gVisor, which runs the container
+// against a user-space kernel instead of the host's, for scripts that are
+// not trusted with the host kernel's attack surface.
+const RuntimeGVisor = "runsc"
+
+// ErrRuntimeUnavailable is returned for a job whose ExecConfig.Runtime the
+// container engine does not have. The job is not run.
+var ErrRuntimeUnavailable = errors.New("orchestrator: container runtime not available")
+
+// checkRuntime fails with ErrRuntimeUnavailable unless the engine has the
+// OCI runtime name; the engine's default, "", always passes. Runtimes found
+// are remembered, a missing one is looked up again on the next job.
+func (r *Runner) checkRuntime(ctx context.Context, name string) error {
+	if name == "" {
+		return nil
+	}
+	r.probeMu.Lock()
+	ok := r.runtimes[name]
+	r.probeMu.Unlock()
+	if ok {
+		return nil
+	}
+	available, err := r.Runtime.Runtimes(ctx)
+	if err != nil {
+		return &InfraError{Op: "list runtimes", Err: err}
+	}
+	if !slices.Contains(available, name) {
+		return fmt.Errorf("%w: %s is not configured in the container engine, which has %s", ErrRuntimeUnavailable, name, strings.Join(available, ", "))
+	}
+	r.probeMu.Lock()
+	if r.runtimes == nil {
+		r.runtimes = map[string]bool{}
+	}
+	r.runtimes[name] = true
+	r.probeMu.Unlock()
+	return nil
+}
+
+// CheckRuntimes verifies that the container engine has the OCI runtime of
+// the runner defaults and of every case configuration. Call it at startup,
+// like CheckImages; the error joins one ErrRuntimeUnavailable per missing
+// runtime. Runtimes set per job are checked when the job starts.
+func (r *Runner) CheckRuntimes(ctx context.Context) error {
+	names := []string{r.Defaults.Runtime}
+	for _, cfg := range r.CaseConfigs {
+		names = append(names, cfg.Runtime)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range slices.Compact(names) {
+		if err := r.checkRuntime(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
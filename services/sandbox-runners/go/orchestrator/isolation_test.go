@@ -0,0 +1,58 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunUnderGVisor(t *testing.T) {
+	rt := &fakeRuntime{runtimes: []string{"runc", RuntimeGVisor}}
+	r := NewRunner(rt)
+	r.Defaults.Runtime = RuntimeGVisor
+	if err := r.CheckRuntimes(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+			t.Fatal(err)
+		}
+		if spec := rt.lastSpec(); spec.Runtime != RuntimeGVisor {
+			t.Errorf("runtime = %q, want %s", spec.Runtime, RuntimeGVisor)
+		}
+	}
+	// The runtime is looked up once.
+	if rt.runtimeCalls != 1 {
+		t.Errorf("listed runtimes %d times", rt.runtimeCalls)
+	}
+
+	// A job may still opt for the engine's default.
+	cfg := DefaultExecConfig()
+	job := testJob(t)
+	job.Config = &cfg
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if spec := rt.lastSpec(); spec.Runtime != "" {
+		t.Errorf("runtime = %q, want the default", spec.Runtime)
+	}
+}
+
+func TestMissingRuntime(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.CaseConfigs = map[string]ExecConfig{"case-1": {Runtime: RuntimeGVisor}}
+	err := r.CheckRuntimes(context.Background())
+	if !errors.Is(err, ErrRuntimeUnavailable) || !strings.Contains(err.Error(), "runsc is not configured") {
+		t.Errorf("CheckRuntimes err = %v, want runsc reported missing", err)
+	}
+
+	_, err = r.Run(context.Background(), testJob(t))
+	if !errors.Is(err, ErrRuntimeUnavailable) || Retryable(err) {
+		t.Errorf("Run err = %v, want ErrRuntimeUnavailable", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("created %d containers, want none", len(rt.specs))
+	}
+}
@@ -0,0 +1,409 @@
+package orchestrator
+
+import (
+	"strings"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Evidence is an evidence item as recorded at ingestion into the case.
+type Evidence struct {
+	UID string
+	// Type is the evidence type recorded at ingestion with
+	// DetectEvidenceType, e.g. sandbox.EvidenceTypeMemory, passed as
+	// EVIDENCE_TYPE and in the case context. The runner detects it when
+	// empty.
+	Type string
+	// Path is the evidence location on the host.
+	Path string
+	// URL, instead of Path, locates evidence kept in object storage, read
+	// with Runner.ObjectStore, e.g. a presigned S3 or MinIO URL. It is
+	// downloaded under Runner.WorkDir before the job runs, or streamed to
+	// sandbox.OpenEvidence with ExecConfig.StreamEvidence. It is never
+	// recorded with its query string, where credentials go.
+	URL string
+	// SHA256 is the digest recorded at ingestion, computed with HashAlgo.
+	SHA256 string
+	// HashAlgo is "sha256" (the default), "sha512" or "blake2b".
+	HashAlgo string
+	// Compression is how the file at Path is stored: sandbox.CompressionGzip,
+	// sandbox.CompressionZstd, or raw when empty. SHA256 is the digest of
+	// the stored file.
+	Compression string
+	// Encryption is how the file at Path is encrypted at rest:
+	// EncryptionAES256GCM, EncryptionAES256GCMStream, or not when empty.
+	// KeySecret names the entry of Job.Secrets that holds its 32-byte key,
+	// in hex or base64: the runner decrypts the evidence for the job and
+	// does not pass that secret to the script. SHA256 is the digest of the
+	// stored file, and Compression applies to the decrypted content.
+	Encryption string
+	KeySecret  string
+	// decrypted marks the plaintext copy of encrypted evidence, which the
+	// EvidenceCache does not keep.
+	decrypted bool
+	// Offset and Length limit the script to a byte range of the evidence,
+	// e.g. a partition of a disk image, passed as EVIDENCE_OFFSET and
+	// EVIDENCE_LENGTH; a zero Length runs to the end. The range applies
+	// to the decompressed content, while SHA256 remains the digest of the
+	// whole file.
+	Offset int64
+	Length int64
+	// MetaPath is the acquisition metadata sidecar of the evidence on the
+	// host, a JSON document of sandbox.EvidenceMetadata, e.g. written by
+	// the ingestion with the acquisition time and tool. It is validated
+	// before the job runs and passed read-only to the script as
+	// EVIDENCE_META_PATH.
+	MetaPath string
+}
+
+// ranged reports whether the script is limited to a byte range of the
+// evidence.
+func (ev Evidence) ranged() bool {
+	return ev.Offset != 0 || ev.Length != 0
+}
+
+// compressed reports whether the evidence must be decompressed to be read.
+func (ev Evidence) compressed() bool {
+	return ev.Compression != "" && !strings.EqualFold(ev.Compression, sandbox.CompressionRaw)
+}
+
+// Job is one script execution against an evidence item.
+type Job struct {
+	ID     string
+	CaseID string
+	// ParentID is the ID of the fan-out that spawned the job, if any.
+	ParentID string
+	// CaseName is the case's display name and CaseNumber its reference in
+	// the lab's case register, e.g. "2026-0142", passed in the case
+	// context.
+	CaseName   string
+	CaseNumber string
+	// CaseTimezone is the IANA time zone of the case, e.g. "Europe/Paris",
+	// and CaseLocale its BCP 47 locale, e.g. "fr-FR", in which scripts
+	// display times, passed as CASE_TIMEZONE and CASE_LOCALE; the times
+	// they store remain UTC. An invalid timezone falls back to UTC and an
+	// invalid locale is dropped, each with a warning on the result.
+	CaseTimezone string
+	CaseLocale   string
+	// Analyst identifies the user who requested the job, for the audit
+	// log and as the examiner of the case context.
+	Analyst  string
+	Evidence Evidence
+	// EvidenceFixture selects a sample evidence file of Runner.Fixtures
+	// instead of Evidence, e.g. "win10-memory-sample" for its latest
+	// version or "win10-memory-sample@2", to test a module without case
+	// data.
+	EvidenceFixture string
+	// ExtraEvidence lists further evidence items to correlate with
+	// Evidence, which remains index 0 of the EVIDENCE_*_<n> variables.
+	ExtraEvidence []Evidence
+	// Language selects the runner image: LanguageGo (the default),
+	// LanguagePython, LanguageNode, LanguageRust, LanguagePowerShell,
+	// LanguageBash or LanguageJava.
+	Language string
+	// Workspace is the host directory holding the script sources.
+	Workspace string
+	// OutputDir is the host directory collected after the run.
+	OutputDir string
+	// LogDir, when set, is the host directory where the job's complete
+	// stdout and stderr are stored as StdoutLogFile and StderrLogFile.
+	LogDir string
+	// Config overrides the case and runner configuration when set.
+	Config *ExecConfig
+	// YaraRules is the host path of a YARA ruleset, in source or compiled
+	// form, mounted read-only for sandbox.YaraScan.
+	YaraRules string
+	// Params are extra environment variables for the script, read with
+	// sandbox.GetParam. Names must match Runner.ParamPatterns. Values may
+	// reference case fields, e.g. "{{.Case.Examiner}}", resolved before the
+	// job runs: ID, Name, Number and Examiner (Analyst).
+	Params map[string]string
+	// Secrets are named values the script should not disclose, e.g. the
+	// API key of an enrichment service, read with sandbox.Secret. They are
+	// passed as read-only files rather than environment variables, are
+	// recorded by name only in the audit log, and their values are masked
+	// in the logs, results and job records the runner keeps.
+	Secrets map[string]string
+	// Labels tag the job for filtering, e.g. pipeline=triage, in
+	// Runner.Jobs and the audit log. They are not passed to the script.
+	Labels map[string]string
+	// Priority orders the job in a WorkerPool queue; higher runs first.
+	Priority int
+	// Preemptible lets a WorkerPool cancel the job while it runs, to free
+	// a worker for a job of higher priority.
+	Preemptible bool
+	// ForceRerun runs the job even when Runner.ResultCache holds the
+	// result of an identical one, which the new result replaces.
+	ForceRerun bool
+	// RunTime is the logical start time of the job, passed as
+	// SANDBOX_RUN_TIME for sandbox.Now; the time the runner is handed the
+	// job when zero. A fan-out or pipeline gives its children the time it
+	// started. Set it to the RunTime of the audit entry to replay a job.
+	RunTime time.Time
+	// Seed is passed as SANDBOX_SEED for sandbox.Rand; derived from the
+	// script, evidence and parameters of the job when zero. Set it to the
+	// Seed of the audit entry to replay a job whose script has changed.
+	Seed int64
+	// BuildTags are the build tags of a Go job, e.g. "debug", passed to
+	// its go build or go run command.
+	BuildTags []string
+	// Replace adds replace directives to the go.mod of a Go job, e.g. to
+	// build against an internal fork of a dependency: forks must be
+	// directories of the workspace or modules under
+	// Runner.ReplaceAllowlist.
+	Replace []ModuleReplace
+	// Flavor runs a Go job in the image of a flavor of Runner.Flavors,
+	// whose module cache holds a pinned set of third-party modules.
+	Flavor string
+	// GoVersion runs a Go job with another toolchain than
+	// DefaultGoVersion, e.g. "1.22" for a script using newer language
+	// features, in its image of Runner.GoToolchains. Jobs fail with
+	// ErrGoVersionUnavailable for a version the runner does not have.
+	GoVersion string
+	// Callback is an http or https URL that Runner.Callbacks POSTs a
+	// CallbackPayload to once the job finishes, however it ends.
+	Callback string
+	// TraceParent is the W3C traceparent header of the span the job's
+	// spans belong under, e.g. that of the request that submitted it, for
+	// Runner.Tracer. A pipeline sets it to its own span.
+	TraceParent string
+}
+
+// allEvidence returns the primary evidence followed by the extra items.
+func (j Job) allEvidence() []Evidence {
+	return append([]Evidence{j.Evidence}, j.ExtraEvidence...)
+}
+
+// JobResult is the outcome of a job.
+type JobResult struct {
+	JobID string
+	// ExitCode is the script's exit status. Scripts may use non-zero codes
+	// to signal findings, so it is reported as is.
+	ExitCode int
+	// Success reports a zero exit that was neither timed out nor OOM killed.
+	Success bool
+	// Signal names the signal that killed the process, e.g. "SIGKILL".
+	Signal string
+	Stdout string
+	Stderr string
+	// StdoutDropped and StderrDropped count the bytes left out of the
+	// middle of Stdout and Stderr by ExecConfig.MaxLogBytes.
+	StdoutDropped int64
+	StderrDropped int64
+	// StdoutBinaryBytes and StderrBinaryBytes count the bytes of binary
+	// output, not UTF-8 text, in Stdout and Stderr. Each run of them is
+	// base64-encoded there, in the files of Job.LogDir and in the
+	// streamed LogLines, as "[<n> binary bytes, base64 <data>]", so that
+	// the logs stay text wherever they are kept.
+	StdoutBinaryBytes int64
+	StderrBinaryBytes int64
+	// StderrTail holds the last lines of Stderr when the job failed.
+	StderrTail []string
+	// Panic is the Go panic that made the job fail, if any; a panic caught
+	// by sandbox.Recover fails it with FailureCompletedWithPanic.
+	Panic *PanicInfo
+	// ShellFailure is the command that made a bash job fail, if any.
+	ShellFailure *ShellFailure
+	// EvidenceRead is the read of the evidence that failed in the script,
+	// if any, making the job fail with FailureEvidenceReadFailed.
+	EvidenceRead *EvidenceReadFailure
+	// FailureReason classifies why the job did not succeed; empty on
+	// success. FailureDetail describes it for display, e.g. "main.go:3:2:
+	// undefined: x" or "stopped after the 10m0s timeout".
+	FailureReason FailureReason
+	FailureDetail string
+	// BuildLog describes the build of a Go script that failed with
+	// FailureCompileError: the compiler invocation, its environment and
+	// complete output, and the file and line of each error.
+	BuildLog *BuildLog
+	// TimedOut reports that the container was stopped by
+	// ExecConfig.RunTimeout.
+	TimedOut bool
+	// BuildTimedOut reports that the build phase outlasted
+	// ExecConfig.BuildTimeout, and that the script was not run.
+	BuildTimedOut bool
+	// PossiblyHung reports that the job went ExecConfig.IdleTimeout
+	// without output nor progress at some point, and IdleKilled that it
+	// was stopped for it, with ExecConfig.KillIdle.
+	PossiblyHung bool
+	IdleKilled   bool
+	// WorkerLost reports that the job was given up for going
+	// ExecConfig.HeartbeatTimeout without a heartbeat, and LastHeartbeat
+	// is the latest heartbeat of its worker, zero without one.
+	WorkerLost    bool
+	LastHeartbeat time.Time
+	// Cancelled reports that the job was cancelled by the caller, which
+	// is not a failure of the script.
+	Cancelled bool
+	// NotApplicable reports that the script stopped early with
+	// sandbox.SkipNotApplicable, for NotApplicableReason: its evidence is
+	// not the kind it analyses. The job neither failed nor examined the
+	// evidence and found nothing.
+	NotApplicable       bool
+	NotApplicableReason string
+	// PreemptedBy is the ID of the job of higher priority for which a
+	// WorkerPool cancelled this Preemptible one.
+	PreemptedBy string
+	// Incomplete reports that the job was stopped before it finished, so
+	// Outputs may be partial.
+	Incomplete bool
+	// OOMKilled reports that the script exceeded its memory limit, as
+	// opposed to exiting non-zero on its own.
+	OOMKilled bool
+	// Network records the network access the job was given.
+	Network NetworkAudit
+	// OutputTruncated reports that the job filled its output quota, so
+	// some of its writes failed.
+	OutputTruncated bool
+	// RecordsTruncated lists the kinds of record the job wrote more of
+	// than ExecConfig caps: Findings, Artifacts or Timeline only hold the
+	// first ones.
+	RecordsTruncated []TruncatedRecords
+	// Outputs lists the files found in OutputDir, relative to it.
+	Outputs []string
+	// Artifacts describes the output files to ingest, with the kind
+	// declared in the script's manifest or ArtifactUntracked.
+	Artifacts []CollectedArtifact
+	// Report is the artifact of Artifacts to show in the case view, the
+	// first report of sandbox.EmitReport; RenderReport displays it.
+	Report *CollectedArtifact
+	// Module is the analysis module that produced the results, as the
+	// script declares it in RequirementsFile.
+	Module ScriptModule
+	// ManifestError explains why artifacts.json could not be read.
+	ManifestError string
+	// Extracted lists the files carved with sandbox.ExtractFile, to ingest
+	// as child evidence of the job's evidence.
+	Extracted []ExtractedEvidence
+	// ExtractedError explains why extracted files were left out.
+	ExtractedError string
+	// Timeline holds the events of timeline.ndjson in time order, for the
+	// case's super-timeline.
+	Timeline []sandbox.TimelineEvent
+	// TimelineError explains why lines of timeline.ndjson were dropped.
+	TimelineError string
+	// Findings holds the results of results.ndjson, to merge into the
+	// case's findings with CaseFindings.
+	Findings []sandbox.Result
+	// FindingsError explains why lines of results.ndjson were dropped.
+	FindingsError string
+	// IOCs holds the indicators of iocs.ndjson, to merge into the case's
+	// IOC index with CaseIOCs.
+	IOCs []sandbox.IOC
+	// IOCsError explains why lines of iocs.ndjson were dropped.
+	IOCsError string
+	// FindingEnrichments and IOCEnrichments hold the data the enrichers
+	// of Runner.Enrichment attached to Findings and IOCs, by index; the
+	// failures of the enrichers are among Warnings.
+	FindingEnrichments map[int]Enrichments
+	IOCEnrichments     map[int]Enrichments
+	// Facts holds the facts of facts.ndjson, to merge into the profiles
+	// of the evidence items with EvidenceFacts.
+	Facts []sandbox.Fact
+	// FactsError explains why lines of facts.ndjson were dropped.
+	FactsError string
+	// Warnings holds the warnings of warnings.ndjson: the recoverable
+	// issues the script hit, to show with the result so that the analyst
+	// can judge how complete the parse is. They do not affect Success.
+	Warnings []sandbox.Warning
+	// WarningsError explains why lines of warnings.ndjson were dropped.
+	WarningsError string
+	// Graph holds the edges of graph.ndjson, to merge into the case's
+	// investigation graph with CaseGraph.
+	Graph []sandbox.Edge
+	// GraphError explains why lines of graph.ndjson were dropped.
+	GraphError string
+	// Coverage is what the script reported examining of its evidence with
+	// sandbox.ReportCoverage, nil when it did not; its String describes it
+	// for display. CoverageError explains why coverage.json was dropped.
+	Coverage      *sandbox.Coverage
+	CoverageError string
+	// JobStatus is how the script ended as the entrypoint of the runner
+	// image recorded it, with ExecConfig.Entrypoint; nil without, or when
+	// the container was killed first. JobStatusError explains why
+	// job-status.json was dropped.
+	JobStatus      *sandbox.JobStatus
+	JobStatusError string
+	// DecryptedEvidence lists the UIDs of the evidence items the runner
+	// decrypted for the job.
+	DecryptedEvidence []string
+	// StreamedEvidence lists the UIDs of the evidence items streamed from
+	// object storage with ExecConfig.StreamEvidence, and StreamedBytes
+	// how much of them the script read.
+	StreamedEvidence []string
+	StreamedBytes    int64
+	// InvalidRecords quarantines the lines of results.ndjson,
+	// timeline.ndjson, iocs.ndjson, facts.ndjson, warnings.ndjson and
+	// graph.ndjson that failed validation, with their line number and
+	// error.
+	InvalidRecords []InvalidRecord
+	// Metrics is the job's resource usage.
+	Metrics JobMetrics
+	// EvidenceModes records, for jobs run with
+	// ExecConfig.EvidenceBlockDevice, whether each evidence item, by UID,
+	// was exposed as a block device or as a file only; BlockDeviceError
+	// explains why disk images fell back to files.
+	EvidenceModes    map[string]EvidenceMode
+	BlockDeviceError string
+	// FetchedEvidence lists the evidence items the script fetched with
+	// sandbox.FetchEvidence.
+	FetchedEvidence []Evidence
+	// FindingQueries is the number of sandbox.QueryFindings requests the
+	// script made, for jobs run with ExecConfig.AllowFindingQuery.
+	FindingQueries int
+	// Shared is the shared directory of the job's case, for jobs run with
+	// ExecConfig.SharedDir.
+	Shared *SharedUsage
+	// Image is the runner image as configured, e.g. a tag, and
+	// ImageDigest the ID of the image the job ran in, to rerun the
+	// analysis in the same environment.
+	Image       string
+	ImageDigest string
+	// Architecture is that of the worker the job ran on, e.g. ArchARM64.
+	Architecture string
+	// GoVersion is the exact version of the Go toolchain a Go job ran
+	// with, e.g. "1.22.5", from the LabelGoVersion of its image, or the
+	// version it selected when its image has none.
+	GoVersion string
+	// ScriptSHA256 hashes the files of the workspace the job ran from, the
+	// copy staged for it, as recorded in the audit log.
+	ScriptSHA256 string
+	// BinarySHA256 is the digest of the compiled script the job ran, for
+	// Go and Rust jobs built through Runner.BuildCache. Go builds are
+	// reproducible: the same sources and image yield the same digest.
+	BinarySHA256 string
+	// Build is the effective build configuration of a Go job with
+	// Job.BuildTags or Job.Replace.
+	Build *BuildConfig
+	// EffectiveConfig is the configuration the job ran with and where each
+	// value came from, with ExecConfig.Explain.
+	EffectiveConfig *EffectiveConfig
+	// Session is what was run in a session started by
+	// Runner.StartSession.
+	Session *SessionRecord
+	// Seed is the SANDBOX_SEED the job ran with.
+	Seed int64
+	// SignerKeyID is the ID of the trusted key whose signature of the
+	// script was verified under Runner.Signatures.
+	SignerKeyID string
+	// Attempts is the number of times the job's container was started,
+	// more than one when Runner.Retry recovered from engine failures.
+	Attempts int
+	// AuditError explains why the job could not be recorded in
+	// Runner.Audit.
+	AuditError string
+	// ReplayError explains why the job could not be stored in
+	// Runner.Replays.
+	ReplayError string
+	// FromCache reports that the job was not run: this is the result of
+	// an earlier job with the same script, evidence and parameters, whose
+	// outputs were copied to OutputDir.
+	FromCache bool
+	// Vulnerabilities are the known vulnerabilities Runner.VulnCheck found
+	// in the dependencies of a Go job.
+	Vulnerabilities []Vulnerability
+	// TraceID is the ID of the trace of the job's spans, with
+	// Runner.Tracer.
+	TraceID string
+}
@@ -0,0 +1,216 @@
+package orchestrator
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limits of Job.Labels.
+const (
+	MaxLabels        = 32
+	MaxLabelKeyLen   = 63
+	MaxLabelValueLen = 63
+)
+
+var (
+	// labelKey is a lower-case name such as "pipeline" or "team.dfir".
+	labelKey = regexp.MustCompile(`^[a-z0-9]([a-z0-9._-]*[a-z0-9])?$`)
+	// labelValue may be empty.
+	labelValue = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+)
+
+// InvalidLabelError reports a job label that was rejected.
+type InvalidLabelError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidLabelError) Error() string {
+	return fmt.Sprintf("orchestrator: invalid label %q: %s", e.Key, e.Reason)
+}
+
+// validateLabels checks job labels against the length and charset limits.
+// Keys are checked in order so that the error is deterministic.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("orchestrator: %d labels, at most %d", len(labels), MaxLabels)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := labels[k]
+		switch {
+		case len(k) > MaxLabelKeyLen:
+			return &InvalidLabelError{k, fmt.Sprintf("key longer than %d bytes", MaxLabelKeyLen)}
+		case !labelKey.MatchString(k):
+			return &InvalidLabelError{k, "key must be lower-case letters, digits, '.', '_' or '-'"}
+		case len(v) > MaxLabelValueLen:
+			return &InvalidLabelError{k, fmt.Sprintf("value longer than %d bytes", MaxLabelValueLen)}
+		case !labelValue.MatchString(v):
+			return &InvalidLabelError{k, "value must be letters, digits, '.', '_' or '-'"}
+		}
+	}
+	return nil
+}
+
+// JobRecord is a finished job as kept by a JobIndex.
+type JobRecord struct {
+	JobID    string
+	CaseID   string
+	ParentID string
+	Analyst  string
+	Labels   map[string]string
+	Language string
+	Started  time.Time
+	Finished time.Time
+	Success  bool
+	// Cancelled jobs are neither successful nor failed, nor are jobs
+	// whose script found their evidence NotApplicable, for
+	// NotApplicableReason.
+	Cancelled           bool
+	NotApplicable       bool
+	NotApplicableReason string
+	FailureReason       FailureReason
+	// LastHeartbeat is the latest heartbeat of the job's worker, with
+	// ExecConfig.HeartbeatTimeout.
+	LastHeartbeat time.Time
+	// EffectiveConfig is JobResult.EffectiveConfig, with
+	// ExecConfig.Explain.
+	EffectiveConfig *EffectiveConfig
+}
+
+// JobFilter selects jobs in JobIndex.Query. Zero fields match every job.
+type JobFilter struct {
+	CaseID string
+	// ParentID matches the children of a fan-out.
+	ParentID string
+	// Labels must all be set on the job with these values.
+	Labels map[string]string
+	// Failed only matches jobs that ran and did not succeed, those whose
+	// script found their evidence not applicable excepted.
+	Failed bool
+	// Since and Until bound the start time of the jobs, Until excluded.
+	Since, Until time.Time
+}
+
+// JobIndex keeps the jobs a runner finished, cached results excepted, and
+// finds them by label without scanning the others. It holds every job in
+// memory for the life of the process. It is safe for concurrent use.
+type JobIndex struct {
+	mu      sync.Mutex
+	records []JobRecord
+	// byLabel lists the positions in records of the jobs with each
+	// "key=value" label, in order.
+	byLabel map[string][]int
+}
+
+// NewJobIndex returns an empty index.
+func NewJobIndex() *JobIndex {
+	return &JobIndex{byLabel: map[string][]int{}}
+}
+
+// add records job, which ended with res.
+func (x *JobIndex) add(job Job, res *JobResult) {
+	rec := JobRecord{
+		JobID:               job.ID,
+		CaseID:              job.CaseID,
+		ParentID:            job.ParentID,
+		Analyst:             job.Analyst,
+		Labels:              copyLabels(job.Labels),
+		Language:            languageKey(job.Language),
+		Started:             res.Metrics.Started.UTC(),
+		Finished:            res.Metrics.Started.Add(res.Metrics.Duration).UTC(),
+		Success:             res.Success,
+		Cancelled:           res.Cancelled,
+		FailureReason:       res.FailureReason,
+		NotApplicable:       res.NotApplicable,
+		NotApplicableReason: res.NotApplicableReason,
+		LastHeartbeat:       res.LastHeartbeat,
+		EffectiveConfig:     res.EffectiveConfig,
+	}
+	if res.Metrics.Started.IsZero() {
+		// Cancelled before its container started.
+		rec.Started = time.Now().UTC()
+		rec.Finished = rec.Started
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	i := len(x.records)
+	x.records = append(x.records, rec)
+	for k, v := range rec.Labels {
+		x.byLabel[k+"="+v] = append(x.byLabel[k+"="+v], i)
+	}
+}
+
+// Query returns the jobs matching f, in the order they finished.
+func (x *JobIndex) Query(f JobFilter) []JobRecord {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	// Only the jobs of the rarest label are considered.
+	var candidates []int
+	scan := true
+	for k, v := range f.Labels {
+		list := x.byLabel[k+"="+v]
+		if scan || len(list) < len(candidates) {
+			candidates, scan = list, false
+		}
+	}
+	var out []JobRecord
+	match := func(rec JobRecord) {
+		if f.matches(rec) {
+			rec.Labels = copyLabels(rec.Labels)
+			out = append(out, rec)
+		}
+	}
+	if scan {
+		for _, rec := range x.records {
+			match(rec)
+		}
+	} else {
+		for _, i := range candidates {
+			match(x.records[i])
+		}
+	}
+	return out
+}
+
+func (f JobFilter) matches(rec JobRecord) bool {
+	if f.CaseID != "" && rec.CaseID != f.CaseID {
+		return false
+	}
+	if f.ParentID != "" && rec.ParentID != f.ParentID {
+		return false
+	}
+	if f.Failed && (rec.Success || rec.Cancelled || rec.NotApplicable) {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !rec.Started.Before(f.Until) {
+		return false
+	}
+	for k, v := range f.Labels {
+		if got, ok := rec.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
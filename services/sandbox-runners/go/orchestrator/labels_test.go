@@ -0,0 +1,104 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateLabels(t *testing.T) {
+	valid := map[string]string{"pipeline": "malware", "team.dfir": "", "tier": "fast-2"}
+	if err := validateLabels(valid); err != nil {
+		t.Errorf("valid labels: %v", err)
+	}
+	for _, labels := range []map[string]string{
+		{"Pipeline": "triage"},
+		{"pipeline-": "triage"},
+		{"": "triage"},
+		{strings.Repeat("k", MaxLabelKeyLen+1): "x"},
+		{"pipeline": strings.Repeat("v", MaxLabelValueLen+1)},
+		{"pipeline": "tri age"},
+		{"pipeline": "triage\n"},
+	} {
+		var lerr *InvalidLabelError
+		if err := validateLabels(labels); !errors.As(err, &lerr) {
+			t.Errorf("%q: err = %v, want an InvalidLabelError", labels, err)
+		}
+	}
+	many := map[string]string{}
+	for i := 0; i <= MaxLabels; i++ {
+		many[strings.Repeat("k", i+1)] = ""
+	}
+	if err := validateLabels(many); err == nil {
+		t.Errorf("%d labels accepted", len(many))
+	}
+}
+
+func TestJobIndexQuery(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Jobs = NewJobIndex()
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+
+	for _, j := range []struct {
+		id, pipeline string
+		exit         int
+	}{
+		{"job-1", "malware", 0},
+		{"job-2", "malware", 2},
+		{"job-3", "triage", 2},
+	} {
+		job := testJob(t)
+		job.ID = j.id
+		job.Labels = map[string]string{"pipeline": j.pipeline, "tier": "fast"}
+		rt.state = ContainerState{ExitCode: j.exit}
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func(recs []JobRecord) []string {
+		var out []string
+		for _, rec := range recs {
+			out = append(out, rec.JobID)
+		}
+		return out
+	}
+
+	failed := r.Jobs.Query(JobFilter{Labels: map[string]string{"pipeline": "malware"}, Failed: true, Since: time.Now().Add(-time.Hour)})
+	if got := ids(failed); len(got) != 1 || got[0] != "job-2" {
+		t.Errorf("failed malware jobs = %v, want [job-2]", got)
+	}
+	if got := ids(r.Jobs.Query(JobFilter{Labels: map[string]string{"tier": "fast"}})); len(got) != 3 {
+		t.Errorf("fast jobs = %v, want all three", got)
+	}
+	if got := r.Jobs.Query(JobFilter{Labels: map[string]string{"pipeline": "malware", "tier": "slow"}}); len(got) != 0 {
+		t.Errorf("slow malware jobs = %v, want none", ids(got))
+	}
+	if got := r.Jobs.Query(JobFilter{Until: time.Now().Add(-time.Hour)}); len(got) != 0 {
+		t.Errorf("jobs started an hour ago = %v, want none", ids(got))
+	}
+	// Records are copies.
+	failed[0].Labels["pipeline"] = "changed"
+	if got := r.Jobs.Query(JobFilter{Labels: map[string]string{"pipeline": "malware"}}); len(got) != 2 {
+		t.Errorf("malware jobs = %v after modifying a record", ids(got))
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil || len(entries) != 3 || entries[2].Labels["pipeline"] != "triage" {
+		t.Errorf("audit = %+v, %v", entries, err)
+	}
+
+	job := testJob(t)
+	job.Labels = map[string]string{"Pipeline": "x"}
+	var lerr *InvalidLabelError
+	if _, err := r.Run(context.Background(), job); !errors.As(err, &lerr) || lerr.Key != "Pipeline" {
+		t.Errorf("err = %v, want the invalid label", err)
+	}
+}
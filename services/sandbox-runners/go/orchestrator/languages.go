@@ -0,0 +1,230 @@
+package orchestrator
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Languages accepted in Job.Language.
+const (
+	LanguageGo     = "go"
+	LanguagePython = "python"
+	LanguageNode   = "node"
+	LanguageRust   = "rust"
+	// LanguagePowerShell runs PowerShell Core scripts.
+	LanguagePowerShell = "powershell"
+	// LanguageBash runs bash scripts chaining the CLI tools of the shell
+	// image.
+	LanguageBash = "bash"
+	// LanguageJava compiles the .java files of the workspace and runs
+	// their Main class.
+	LanguageJava = "java"
+)
+
+// shellFailureMarker starts the line bashRunner prints to stderr for the
+// command that made the script fail, parsed into JobResult.ShellFailure.
+const shellFailureMarker = "datamortem: command failed"
+
+// bashRunner runs the script named by $0 with errexit, nounset and
+// pipefail, which shell scripts would otherwise rarely set, and reports
+// the first failing command. The script is sourced so that the ERR trap
+// applies to it, errtrace extending it to its functions and subshells;
+// $0 still names the script.
+const bashRunner = `set -Eeuo pipefail
+trap 'printf "` + shellFailureMarker + ` (exit %d) at %s:%d: %s\n" "$?" "${BASH_SOURCE[0]:-$0}" "$LINENO" "$BASH_COMMAND" >&2' ERR
+source "./$0"`
+
+// rustBuildScript builds a cargo project into the build cache. build.rs
+// scripts are executed, so the target directory cannot be on the noexec
+// /tmp; the package must have exactly one binary.
+const rustBuildScript = `set -e
+export CARGO_TARGET_DIR=` + containerBuildDir + `/target
+cargo build --release --quiet
+set -- $(find "$CARGO_TARGET_DIR/release" -maxdepth 1 -type f -perm -u+x)
+if [ $# -ne 1 ]; then
+	echo "expected one binary, found $#" >&2
+	exit 1
+fi
+cp "$1" ` + containerBuildDir + `/` + cachedBinary + `
+rm -rf "$CARGO_TARGET_DIR"`
+
+// javaLibDir holds the Maven dependencies pre-fetched in the Java image,
+// on the CLASSPATH of every Java job.
+const javaLibDir = "/opt/datamortem-java/lib"
+
+// javaCompile compiles the .java files of the workspace into $classes.
+// Class files are not executed, so they can live on the noexec /tmp.
+const javaCompile = `find . -name '*.java' > ` + containerTmp + `/java-sources
+javac -encoding UTF-8 -d "$classes" @` + containerTmp + `/java-sources`
+
+// javaRunner runs the Main class of the jar or class directory named by
+// $1, compiling the workspace first when there is none. The heap is three
+// quarters of SANDBOX_MEMORY_LIMIT_BYTES, where the JVM's own default is a
+// quarter of the container's memory; the rest covers metaspace, thread
+// stacks and native buffers. java replaces the shell, so that it
+// receives SIGTERM and runs its shutdown hooks.
+const javaRunner = `set -e
+if [ $# -eq 0 ]; then
+	classes=` + containerTmp + `/classes
+	` + javaCompile + `
+	set -- "$classes"
+fi
+heap=$((${` + sandbox.EnvMemoryLimitBytes + `:-0} * 3 / 4 / 1048576))
+if [ "$heap" -gt 0 ]; then
+	exec java -Xmx${heap}m -cp "$1:$CLASSPATH" Main
+fi
+exec java -cp "$1:$CLASSPATH" Main`
+
+// javaBuildScript compiles the workspace into a jar in the build cache,
+// with fixed entry dates so that the same sources give the same jar.
+const javaBuildScript = `set -e
+classes=` + containerTmp + `/classes
+` + javaCompile + `
+jar --create --date=1980-01-01T00:00:02Z --file ` + containerBuildDir + `/` + cachedBinary + ` -C "$classes" .`
+
+// goBuildFlags make Go builds reproducible: compiling the same sources
+// with the same image yields the same binary, whatever the workspace path.
+var goBuildFlags = []string{"-trimpath", "-buildvcs=false", "-ldflags=-buildid="}
+
+// goRunWrapper runs `go run` ("$@") so that its script can be stopped
+// gracefully: `go run` exits on SIGTERM without passing the signal on, and
+// the container ended with it would kill the script before its shutdown
+// handlers ran. The wrapper starts `go run` in a process group of its own,
+// which the script inherits, signals that group instead, once, and waits
+// for the script, orphaned by `go run`, until the orchestrator's SIGKILL.
+// Only the group is waited for: the metrics and output quota wrappers
+// around this one still have to flush once it exits. Pooled jobs need no
+// wrapper: Pool.terminate already signals every process.
+const goRunWrapper = `setsid "$@" &
+pid=$!
+term=
+trap '[ -n "$term" ] || { term=1; kill -TERM -$pid 2>/dev/null; }' TERM INT
+while :; do
+	wait $pid
+	code=$?
+	kill -0 $pid 2>/dev/null || break
+done
+if [ -n "$term" ]; then
+	while kill -0 -$pid 2>/dev/null; do sleep 0.1; done
+fi
+exit $code`
+
+// wrapGoRun wraps cmd with goRunWrapper.
+func wrapGoRun(cmd []string) []string {
+	return append([]string{"sh", "-c", goRunWrapper, "sh"}, cmd...)
+}
+
+// runnerProfile describes how to run scripts of one language.
+type runnerProfile struct {
+	// Image is the tag produced by the sandbox-runners Makefile.
+	Image string
+	Cmd   []string
+	// Env holds toolchain settings added to the contract variables.
+	Env map[string]string
+	// Build compiles the workspace to the BuildCache binary; nil when the
+	// language is not cached.
+	Build []string
+	// RunBuilt runs the BuildCache artifact, whose path is appended to it;
+	// nil when the artifact is executed directly.
+	RunBuilt []string
+	// Probe prints the toolchain version, for Runner.CheckImages.
+	Probe []string
+	// Features lists what the language supports besides running scripts,
+	// reported by Runner.Runners; FeatureBuildCache follows from Build.
+	Features []string
+}
+
+var runnerProfiles = map[string]runnerProfile{
+	LanguageGo: {
+		Image: "datamortem-sandbox-go:1.21",
+		Cmd:   []string{"go", "run", "."},
+		// The root filesystem is read-only and /tmp is noexec, so the Go
+		// build cache lives in /tmp and binaries are linked into the
+		// workspace.
+		// Scripts are built without cgo, so that binaries only depend on
+		// the Go toolchain of the image.
+		Env: map[string]string{
+			"GOCACHE":     path.Join(containerTmp, "go-cache"),
+			"GOTMPDIR":    containerWorkspace,
+			"CGO_ENABLED": "0",
+		},
+		Build:    append(append([]string{"go", "build"}, goBuildFlags...), "-o", path.Join(containerBuildDir, cachedBinary), "."),
+		Probe:    []string{"go", "version"},
+		Features: []string{FeatureSDK, FeatureOffline, FeatureBuildConfig, FeatureFlavors, FeatureGoVersions},
+	},
+	LanguagePython: {
+		Image: "datamortem-sandbox-python:3.11",
+		Cmd:   []string{"python", "script.py"},
+		Probe: []string{"python", "--version"},
+	},
+	LanguageNode: {
+		Image: "datamortem-sandbox-node:20",
+		Cmd:   []string{"node", "script.js"},
+		Probe: []string{"node", "--version"},
+	},
+	LanguageRust: {
+		Image: "datamortem-sandbox-rust:1.75",
+		Cmd:   []string{"cargo", "run", "--release", "--quiet"},
+		// Crates resolve from the vendored set of the image; cargo only
+		// needs a writable home for its locks.
+		Env: map[string]string{
+			"CARGO_HOME": path.Join(containerTmp, "cargo-home"),
+		},
+		Build: []string{"sh", "-c", rustBuildScript},
+		Probe: []string{"cargo", "--version"},
+	},
+	LanguagePowerShell: {
+		Image: "datamortem-sandbox-powershell:7.4",
+		Cmd:   []string{"pwsh", "-NoLogo", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", "script.ps1"},
+		// PowerShell keeps its module analysis cache and history under the
+		// XDG directories, which must be writable.
+		Env: map[string]string{
+			"XDG_CACHE_HOME":  path.Join(containerTmp, "cache"),
+			"XDG_CONFIG_HOME": path.Join(containerTmp, "config"),
+			"XDG_DATA_HOME":   path.Join(containerTmp, "data"),
+		},
+		Probe: []string{"pwsh", "-NoLogo", "-NoProfile", "-Version"},
+	},
+	LanguageBash: {
+		Image:    "datamortem-sandbox-shell:3.20",
+		Cmd:      []string{"bash", "-c", bashRunner, "script.sh"},
+		Probe:    []string{"bash", "--version"},
+		Features: []string{FeatureShellFailure},
+	},
+	LanguageJava: {
+		Image: "datamortem-sandbox-java:21",
+		Cmd:   []string{"sh", "-c", javaRunner, "java"},
+		// javac and java both read the pre-fetched jars from CLASSPATH.
+		Env: map[string]string{
+			"CLASSPATH": javaLibDir + "/*",
+		},
+		Build:    []string{"sh", "-c", javaBuildScript},
+		RunBuilt: []string{"sh", "-c", javaRunner, "java"},
+		Probe:    []string{"java", "--version"},
+	},
+}
+
+// builtCmd returns the command running the BuildCache artifact at bin.
+func (p runnerProfile) builtCmd(bin string) []string {
+	return append(append([]string(nil), p.RunBuilt...), bin)
+}
+
+// languageKey normalizes a Job.Language value; empty means Go.
+func languageKey(language string) string {
+	if language == "" {
+		return LanguageGo
+	}
+	return strings.ToLower(language)
+}
+
+// profile returns the runner profile for language, Go when empty.
+func profile(language string) (runnerProfile, error) {
+	p, ok := runnerProfiles[languageKey(language)]
+	if !ok {
+		return runnerProfile{}, fmt.Errorf("orchestrator: unsupported language %q", language)
+	}
+	return p, nil
+}
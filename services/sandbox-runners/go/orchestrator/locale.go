@@ -0,0 +1,40 @@
+package orchestrator
+
+import (
+	"fmt"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Codes of the warnings added to the result of a job whose case timezone
+// or locale is invalid.
+const (
+	WarningInvalidTimezone = "case.invalid_timezone"
+	WarningInvalidLocale   = "case.invalid_locale"
+)
+
+// caseLocale returns the timezone and locale of job as passed to its
+// script: an invalid timezone is replaced by "UTC" and an invalid locale
+// dropped, with a warning for each.
+func caseLocale(job Job) (timezone, locale string, warnings []sandbox.Warning) {
+	timezone, locale = job.CaseTimezone, job.CaseLocale
+	if timezone != "" {
+		if _, err := sandbox.LoadTimezone(timezone); err != nil {
+			warnings = append(warnings, sandbox.Warning{
+				Code:    WarningInvalidTimezone,
+				Message: fmt.Sprintf("case timezone %q is not an IANA time zone; times are displayed in UTC", timezone),
+				Fields:  map[string]any{"timezone": timezone},
+			})
+			timezone = "UTC"
+		}
+	}
+	if locale != "" && !sandbox.ValidLocale(locale) {
+		warnings = append(warnings, sandbox.Warning{
+			Code:    WarningInvalidLocale,
+			Message: fmt.Sprintf("case locale %q is not a BCP 47 language tag", locale),
+			Fields:  map[string]any{"locale": locale},
+		})
+		locale = ""
+	}
+	return timezone, locale, warnings
+}
@@ -0,0 +1,245 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Streams a LogLine can come from.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// LogLine is one line of container output.
+type LogLine struct {
+	Time   time.Time
+	Stream string
+	Text   string
+	// Capped is set on the first line of its stream past
+	// ExecConfig.MaxLogBytes: every line is still streamed, but the
+	// persisted log keeps only the head and the tail of the stream.
+	Capped bool
+	// Binary reports that Text encodes binary output: see
+	// JobResult.StdoutBinaryBytes.
+	Binary bool
+}
+
+// MaxLogLineBytes is the longest LogLine streamed: longer lines, e.g. a
+// binary dump without newlines, are split.
+const MaxLogLineBytes = 64 << 10
+
+// binaryTextRun is how many text bytes end a run of binary output: shorter
+// stretches of printable bytes between binary ones, common in random
+// data, are encoded with them.
+const binaryTextRun = 8
+
+// binaryRune reports whether the rune r of size bytes is binary output:
+// a byte that is not UTF-8, or a control character other than tab,
+// newlines, backspace, form feed and the escape of terminal colours.
+func binaryRune(r rune, size int) bool {
+	switch {
+	case r == utf8.RuneError && size == 1:
+		return true
+	case r == 0x7f:
+		return true
+	case r < 0x20:
+		return !strings.ContainsRune("\t\n\r\b\f\v\x1b", r)
+	}
+	return false
+}
+
+// binarySafe returns text with each run of binary output replaced by
+// "[<n> binary bytes, base64 <data>]", so that logs stay valid UTF-8 in
+// every record they are kept in, and the number of bytes so encoded.
+func binarySafe(text string) (string, int64) {
+	if utf8.ValidString(text) && strings.IndexFunc(text, func(r rune) bool { return binaryRune(r, utf8.RuneLen(r)) }) < 0 {
+		return text, 0
+	}
+	var b strings.Builder
+	var encoded int64
+	// start is the start of the binary run, -1 outside one, and run the
+	// number of text bytes since its last binary byte.
+	start, run := -1, 0
+	flush := func(end int) {
+		encoded += int64(end - start)
+		fmt.Fprintf(&b, "[%d binary bytes, base64 %s]", end-start, base64.StdEncoding.EncodeToString([]byte(text[start:end])))
+		start = -1
+	}
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case binaryRune(r, size):
+			if start < 0 {
+				start = i
+			}
+			run = 0
+		case start < 0:
+			b.WriteString(text[i : i+size])
+		default:
+			run += size
+			if run >= binaryTextRun || r == '\n' {
+				end := i + size - run
+				flush(end)
+				b.WriteString(text[end : i+size])
+			}
+		}
+		i += size
+	}
+	if start >= 0 {
+		end := len(text) - run
+		flush(end)
+		b.WriteString(text[end:])
+	}
+	return b.String(), encoded
+}
+
+// lineWriter turns a byte stream into LogLines, holding back a trailing
+// partial line until its newline arrives or Flush is called.
+type lineWriter struct {
+	ctx    context.Context
+	ch     chan<- LogLine
+	stream string
+	buf    []byte
+	// limit is the size past which the next line is marked Capped.
+	limit, written int64
+	capped         bool
+	// scrub masks the job's secrets in each line.
+	scrub *scrubber
+}
+
+func newLineWriter(ctx context.Context, ch chan<- LogLine, stream string, limit int64, scrub *scrubber) *lineWriter {
+	return &lineWriter{ctx: ctx, ch: ch, stream: stream, limit: limit, scrub: scrub}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.buf[:i], []byte("\r"))
+		if err := w.emit(string(line)); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) > MaxLogLineBytes {
+		// Split at a rune boundary where there is one.
+		n := MaxLogLineBytes
+		for n > MaxLogLineBytes-utf8.UTFMax && !utf8.RuneStart(w.buf[n]) {
+			n--
+		}
+		if !utf8.RuneStart(w.buf[n]) {
+			n = MaxLogLineBytes
+		}
+		if err := w.emit(string(w.buf[:n])); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[n:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any buffered partial line.
+func (w *lineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	text := string(w.buf)
+	w.buf = nil
+	return w.emit(text)
+}
+
+func (w *lineWriter) emit(text string) error {
+	// Secrets are masked before binary output is encoded, which would
+	// hide them from the scrubber.
+	text, binary := binarySafe(w.scrub.scrub(text))
+	line := LogLine{Time: time.Now().UTC(), Stream: w.stream, Text: text, Binary: binary > 0}
+	if w.limit > 0 && w.written > w.limit && !w.capped {
+		line.Capped, w.capped = true, true
+	}
+	select {
+	case w.ch <- line:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// cappedLog keeps the first and last limit/2 bytes of a stream written to
+// it and counts the bytes dropped in between.
+type cappedLog struct {
+	half    int
+	head    []byte
+	tail    []byte
+	dropped int64
+}
+
+func newCappedLog(limit int64) *cappedLog {
+	return &cappedLog{half: int(limit / 2)}
+}
+
+func (c *cappedLog) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := c.half - len(c.head); room > 0 {
+		k := min(room, len(p))
+		c.head = append(c.head, p[:k]...)
+		p = p[k:]
+	}
+	c.tail = append(c.tail, p...)
+	// Compact once the tail holds twice what it keeps, so that each byte
+	// is copied a bounded number of times.
+	if extra := len(c.tail) - c.half; extra > c.half {
+		c.dropped += int64(extra)
+		c.tail = append(c.tail[:0], c.tail[extra:]...)
+	}
+	return n, nil
+}
+
+// kept returns the head and the tail String keeps of a stream longer
+// than the cap, cut at rune boundaries so as not to leave halves of runes
+// that would read as binary output.
+func (c *cappedLog) kept() (head, tail []byte) {
+	head, tail = c.head, c.tail[len(c.tail)-c.half:]
+	for i := 1; i < utf8.UTFMax && i <= len(head); i++ {
+		if utf8.RuneStart(head[len(head)-i]) {
+			if !utf8.FullRune(head[len(head)-i:]) {
+				head = head[:len(head)-i]
+			}
+			break
+		}
+	}
+	for i := 0; i < utf8.UTFMax-1 && len(tail) > 0 && !utf8.RuneStart(tail[0]); i++ {
+		tail = tail[1:]
+	}
+	return head, tail
+}
+
+// Dropped returns the number of bytes left out of String.
+func (c *cappedLog) Dropped() int64 {
+	extra := len(c.tail) - c.half
+	if c.dropped == 0 && extra <= 0 {
+		return 0
+	}
+	head, tail := c.kept()
+	return c.dropped + int64(extra) + int64(len(c.head)-len(head)+c.half-len(tail))
+}
+
+// String returns the stream, its middle replaced by a note of the bytes
+// dropped when it was longer than the cap.
+func (c *cappedLog) String() string {
+	dropped := c.Dropped()
+	if dropped == 0 {
+		return string(c.head) + string(c.tail)
+	}
+	head, tail := c.kept()
+	return string(head) + fmt.Sprintf("\n[... %d bytes dropped ...]\n", dropped) + string(tail)
+}
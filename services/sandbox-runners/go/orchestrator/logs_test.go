@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStreamSplitsLines(t *testing.T) {
+	rt := &fakeRuntime{
+		stdout: "parsing MFT\r\nrecord 1\nrecord 2\npartial",
+		stderr: "warning: truncated record\n",
+	}
+	r := NewRunner(rt)
+	ctx := context.Background()
+
+	exec, err := r.Start(ctx, testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := exec.Stream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Stream(ctx); err == nil {
+		t.Error("second Stream call should fail")
+	}
+
+	var got []LogLine
+	for line := range lines {
+		got = append(got, line)
+	}
+	if _, err := exec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LogLine{
+		{Stream: StreamStdout, Text: "parsing MFT"},
+		{Stream: StreamStdout, Text: "record 1"},
+		{Stream: StreamStdout, Text: "record 2"},
+		{Stream: StreamStderr, Text: "warning: truncated record"},
+		{Stream: StreamStdout, Text: "partial"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines: %+v", len(got), got)
+	}
+	for i, line := range got {
+		if line.Stream != want[i].Stream || line.Text != want[i].Text {
+			t.Errorf("line %d = %s %q, want %s %q", i, line.Stream, line.Text, want[i].Stream, want[i].Text)
+		}
+		if line.Time.IsZero() {
+			t.Errorf("line %d has no timestamp", i)
+		}
+	}
+}
+
+func TestMaxLogBytesKeepsHeadAndTail(t *testing.T) {
+	var out strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&out, "record %06d\n", i)
+	}
+	rt := &fakeRuntime{stdout: out.String(), stderr: "done\n"}
+	r := NewRunner(rt)
+	ctx := context.Background()
+	cfg := DefaultExecConfig()
+	cfg.MaxLogBytes = 1024
+	job := testJob(t)
+	job.Config = &cfg
+
+	exec, err := r.Start(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := exec.Stream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, capped := 0, 0
+	for line := range lines {
+		if line.Stream != StreamStdout {
+			continue
+		}
+		if line.Capped {
+			capped++
+			if want := fmt.Sprintf("record %06d", 1024/len("record 000000\n")); line.Text != want {
+				t.Errorf("capped line = %q, want %q", line.Text, want)
+			}
+		}
+		streamed++
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if streamed != 100000 || capped != 1 {
+		t.Errorf("streamed %d lines, %d capped; want every line and one capped", streamed, capped)
+	}
+	if want := int64(out.Len() - 1024); res.StdoutDropped != want {
+		t.Errorf("StdoutDropped = %d, want %d", res.StdoutDropped, want)
+	}
+	if !strings.HasPrefix(res.Stdout, "record 000000\n") || !strings.HasSuffix(res.Stdout, "record 099999\n") {
+		t.Errorf("stdout does not keep its head and tail: %.40q…", res.Stdout)
+	}
+	if note := fmt.Sprintf("[... %d bytes dropped ...]", res.StdoutDropped); !strings.Contains(res.Stdout, note) || len(res.Stdout) > 1024+len(note)+2 {
+		t.Errorf("stdout is %d bytes, want 1024 around %q", len(res.Stdout), note)
+	}
+	if res.Stderr != "done\n" || res.StderrDropped != 0 {
+		t.Errorf("stderr = %q, %d dropped; want it whole", res.Stderr, res.StderrDropped)
+	}
+}
+
+func TestCappedLog(t *testing.T) {
+	c := newCappedLog(8)
+	for _, s := range []string{"ab", "cdefgh", "ijklmnopqr", "stuvwxyz"} {
+		c.Write([]byte(s))
+	}
+	if got, want := c.String(), "abcd\n[... 18 bytes dropped ...]\nwxyz"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	c = newCappedLog(8)
+	c.Write([]byte("abcdefgh"))
+	if c.String() != "abcdefgh" || c.Dropped() != 0 {
+		t.Errorf("String() = %q, want the stream whole at the cap", c.String())
+	}
+}
+
+func TestBinarySafe(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		encoded  int64
+	}{
+		{"plain text\twith \x1b[1mcolour\x1b[0m, é\n", "plain text\twith \x1b[1mcolour\x1b[0m, é\n", 0},
+		{"ok\x00\x01\xffdone", "ok[3 binary bytes, base64 AAH/]done", 3},
+		// Short printable stretches belong to the run.
+		{"MZ\x90\x00\x03ab\x00\xffPE header", "MZ[7 binary bytes, base64 kAADYWIA/w==]PE header", 7},
+		{"\xff\xfe\n\xfd", "[2 binary bytes, base64 //4=]\n[1 binary bytes, base64 /Q==]", 3},
+	} {
+		got, encoded := binarySafe(tc.in)
+		if got != tc.want || encoded != tc.encoded {
+			t.Errorf("binarySafe(%q) = %q, %d; want %q, %d", tc.in, got, encoded, tc.want, tc.encoded)
+		}
+	}
+}
+
+func TestBinaryStdout(t *testing.T) {
+	random := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	stdout := "parsing hiberfil.sys\n" + string(random) + "\ndone\n"
+	rt := &fakeRuntime{stdout: stdout}
+	r := NewRunner(rt)
+	ctx := context.Background()
+	job := testJob(t)
+	job.LogDir = t.TempDir()
+
+	exec, err := r.Start(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := exec.Stream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []LogLine
+	for line := range lines {
+		streamed = append(streamed, line)
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := 0
+	for _, line := range streamed {
+		if !utf8.ValidString(line.Text) || len(line.Text) > 2*MaxLogLineBytes {
+			t.Fatalf("streamed line of %d bytes is not bounded UTF-8", len(line.Text))
+		}
+		if line.Binary {
+			binary++
+		}
+	}
+	if binary == 0 || streamed[0].Text != "parsing hiberfil.sys" || streamed[len(streamed)-1].Text != "done" || streamed[0].Binary {
+		t.Errorf("%d streamed lines, %d binary; first %+v", len(streamed), binary, streamed[0])
+	}
+	if !utf8.ValidString(res.Stdout) || !strings.HasPrefix(res.Stdout, "parsing hiberfil.sys\n") || !strings.HasSuffix(res.Stdout, "\ndone\n") {
+		t.Errorf("stdout is not text keeping its lines: %.60q", res.Stdout)
+	}
+	if res.StdoutBinaryBytes < int64(len(random))/2 || res.StdoutBinaryBytes > int64(len(random)) {
+		t.Errorf("StdoutBinaryBytes = %d of %d random bytes", res.StdoutBinaryBytes, len(random))
+	}
+	// The runs decode back to the bytes written.
+	var decoded bytes.Buffer
+	for _, m := range regexp.MustCompile(`\[(\d+) binary bytes, base64 ([A-Za-z0-9+/=]+)\]`).FindAllStringSubmatch(res.Stdout, -1) {
+		b, err := base64.StdEncoding.DecodeString(m[2])
+		if err != nil || fmt.Sprint(len(b)) != m[1] {
+			t.Fatalf("run %q: %v", m[0], err)
+		}
+		decoded.Write(b)
+	}
+	if int64(decoded.Len()) != res.StdoutBinaryBytes {
+		t.Errorf("decoded %d bytes, want %d", decoded.Len(), res.StdoutBinaryBytes)
+	}
+	record, err := json.Marshal(res)
+	if err != nil || bytes.Contains(record, []byte("\\ufffd")) {
+		t.Errorf("job record does not keep stdout as is: %v", err)
+	}
+}
+
+func TestLineWriterSplitsLongLines(t *testing.T) {
+	ch := make(chan LogLine, 10)
+	w := newLineWriter(context.Background(), ch, StreamStdout, 0, nil)
+	long := strings.Repeat("é", MaxLogLineBytes)
+	w.Write([]byte(long))
+	w.Flush()
+	close(ch)
+	var joined strings.Builder
+	for line := range ch {
+		if len(line.Text) > MaxLogLineBytes || line.Binary {
+			t.Errorf("line of %d bytes, binary %v", len(line.Text), line.Binary)
+		}
+		joined.WriteString(line.Text)
+	}
+	if joined.String() != long {
+		t.Error("split lines do not join back into the line written")
+	}
+}
@@ -0,0 +1,160 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobMetrics is the resource usage of a job. The cgroup figures are only
+// sampled with ExecConfig.ResourceMetrics and are zero when the job was
+// killed before it could report them or the host does not use cgroup v2.
+type JobMetrics struct {
+	// Started is when the container started, or the job was started in a
+	// pooled container.
+	Started time.Time
+	// Duration is the wall-clock time from the container's start to its
+	// exit.
+	Duration time.Duration
+	// CPUTime is the user and system CPU time of the job.
+	CPUTime time.Duration
+	// PeakMemoryBytes is the container's peak memory usage. Pooled
+	// containers report their peak since they were created.
+	PeakMemoryBytes int64
+	// EvidenceBytesRead counts the bytes read from the block devices that
+	// back the evidence, page cache hits excluded.
+	EvidenceBytesRead int64
+	// OutputBytes is the total size of the collected outputs.
+	OutputBytes int64
+}
+
+// MetricsRecorder receives the result of every job the runner finishes,
+// e.g. a PrometheusMetrics.
+type MetricsRecorder interface {
+	RecordJob(job Job, res *JobResult)
+}
+
+// metricsDir is where the metrics wrapper writes its cgroup snapshots,
+// relative to the output directory.
+const metricsDir = ".datamortem-metrics"
+
+// metricsWrapper runs the job's command ("$@") between two snapshots of
+// the container's cgroup, so that only the job's usage is counted.
+const metricsWrapper = `m=` + containerOutputDir + `/` + metricsDir + `
+cg=/sys/fs/cgroup
+mkdir -p $m
+cat $cg/cpu.stat > $m/cpu.stat.start 2>/dev/null
+cat $cg/io.stat > $m/io.stat.start 2>/dev/null
+"$@" &
+pid=$!
+trap 'kill -TERM $pid 2>/dev/null' TERM INT
+while :; do
+	wait $pid
+	code=$?
+	kill -0 $pid 2>/dev/null || break
+done
+cat $cg/cpu.stat > $m/cpu.stat 2>/dev/null
+cat $cg/io.stat > $m/io.stat 2>/dev/null
+cat $cg/memory.peak > $m/memory.peak 2>/dev/null
+exit $code`
+
+// wrapMetrics wraps cmd with metricsWrapper.
+func wrapMetrics(cmd []string) []string {
+	return append([]string{"sh", "-c", metricsWrapper, "sh"}, cmd...)
+}
+
+// takeMetrics reads the cgroup snapshots left in dir by metricsWrapper
+// into m and removes them so that they are not collected as outputs.
+func takeMetrics(dir string, job Job, m *JobMetrics) error {
+	snap := filepath.Join(dir, metricsDir)
+	if _, err := os.Stat(snap); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	read := func(name string) []byte {
+		data, _ := os.ReadFile(filepath.Join(snap, name))
+		return data
+	}
+	start, end := cgroupStat(read("cpu.stat.start"))["usage_usec"], cgroupStat(read("cpu.stat"))["usage_usec"]
+	if end > start {
+		m.CPUTime = time.Duration(end-start) * time.Microsecond
+	}
+	if peak, err := strconv.ParseInt(strings.TrimSpace(string(read("memory.peak"))), 10, 64); err == nil {
+		m.PeakMemoryBytes = peak
+	}
+	before, after := ioReadBytes(read("io.stat.start")), ioReadBytes(read("io.stat"))
+	for _, dev := range evidenceDevices(job) {
+		if n := after[dev] - before[dev]; n > 0 {
+			m.EvidenceBytesRead += n
+		}
+	}
+	return os.RemoveAll(snap)
+}
+
+// evidenceDevices returns the distinct block devices holding job's
+// evidence, as "major:minor".
+func evidenceDevices(job Job) []string {
+	var devs []string
+	seen := map[string]bool{}
+	for _, ev := range job.allEvidence() {
+		dev := blockDevice(ev.Path)
+		if dev != "" && !seen[dev] {
+			seen[dev] = true
+			devs = append(devs, dev)
+		}
+	}
+	return devs
+}
+
+// cgroupStat parses a flat-keyed cgroup file such as cpu.stat.
+func cgroupStat(data []byte) map[string]int64 {
+	stat := map[string]int64{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			stat[fields[0]] = v
+		}
+	}
+	return stat
+}
+
+// ioReadBytes parses the rbytes of each device in a cgroup io.stat file.
+func ioReadBytes(data []byte) map[string]int64 {
+	read := map[string]int64{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if v, ok := strings.CutPrefix(f, "rbytes="); ok {
+				n, err := strconv.ParseInt(v, 10, 64)
+				if err == nil {
+					read[fields[0]] = n
+				}
+			}
+		}
+	}
+	return read
+}
+
+// outputBytes sums the sizes of the outputs in dir.
+func outputBytes(dir string, outputs []string) int64 {
+	var total int64
+	for _, rel := range outputs {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rel))); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
@@ -0,0 +1,40 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// blockDevice returns the whole disk holding path as "major:minor", the
+// key of cgroup io.stat, or "" when path is not on a block device.
+func blockDevice(path string) string {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return ""
+	}
+	major, minor := unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev))
+	if major == 0 {
+		// Virtual filesystems such as tmpfs and overlay.
+		return ""
+	}
+	dev := fmt.Sprintf("%d:%d", major, minor)
+	sys := filepath.Join("/sys/dev/block", dev)
+	if _, err := os.Stat(filepath.Join(sys, "partition")); err != nil {
+		return dev
+	}
+	// I/O on a partition is accounted to its disk, the parent of the
+	// partition in sysfs.
+	real, err := filepath.EvalSymlinks(sys)
+	if err != nil {
+		return dev
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(real), "dev"))
+	if err != nil {
+		return dev
+	}
+	return strings.TrimSpace(string(data))
+}
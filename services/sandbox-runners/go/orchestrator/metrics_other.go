@@ -0,0 +1,6 @@
+//go:build !linux
+
+package orchestrator
+
+// blockDevice is only implemented on Linux, where cgroups exist.
+func blockDevice(path string) string { return "" }
@@ -0,0 +1,172 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeSnapshots leaves cgroup snapshots in spec's output mount as
+// metricsWrapper would, with rbytes reported on dev.
+func writeSnapshots(spec ContainerSpec, dev string) {
+	for _, m := range spec.Mounts {
+		if m.Target != containerOutputDir {
+			continue
+		}
+		dir := filepath.Join(m.Source, metricsDir)
+		os.MkdirAll(dir, 0o755)
+		files := map[string]string{
+			"cpu.stat.start": "usage_usec 1000\nuser_usec 800\n",
+			"cpu.stat":       "usage_usec 2501000\nuser_usec 2000000\n",
+			"io.stat.start":  dev + " rbytes=4096 wbytes=0 rios=1 wios=0\n",
+			"io.stat":        dev + " rbytes=1052672 wbytes=0 rios=9 wios=0\n9:9 rbytes=777\n",
+			"memory.peak":    "268435456\n",
+		}
+		for name, data := range files {
+			os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644)
+		}
+		os.WriteFile(filepath.Join(m.Source, "report.csv"), make([]byte, 300), 0o644)
+	}
+}
+
+func TestRunResourceMetrics(t *testing.T) {
+	job := testJob(t)
+	job.Evidence.Path = filepath.Join(t.TempDir(), "disk.raw")
+	os.WriteFile(job.Evidence.Path, nil, 0o644)
+	dev := blockDevice(job.Evidence.Path)
+
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) { writeSnapshots(spec, dev) }
+	r := NewRunner(rt)
+	rec := NewPrometheusMetrics()
+	r.MetricsRecorder = rec
+	cfg := DefaultExecConfig()
+	cfg.ResourceMetrics = true
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := wrapMetrics(goRunCmd("go", "run", ".")); !reflect.DeepEqual(rt.lastSpec().Cmd, want) {
+		t.Errorf("cmd = %q", rt.lastSpec().Cmd)
+	}
+	m := res.Metrics
+	if m.CPUTime != 2500*time.Millisecond || m.PeakMemoryBytes != 256<<20 || m.OutputBytes != 300 || m.Duration <= 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if dev != "" && m.EvidenceBytesRead != 1<<20 {
+		t.Errorf("evidence bytes read = %d, want %d", m.EvidenceBytesRead, 1<<20)
+	}
+	if !reflect.DeepEqual(res.Outputs, []string{"report.csv"}) {
+		t.Errorf("outputs = %v, want the snapshots removed", res.Outputs)
+	}
+
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`datamortem_sandbox_jobs_total{language="go",outcome="success"} 1`,
+		`datamortem_sandbox_job_cpu_seconds_total{language="go"} 2.5`,
+		`datamortem_sandbox_job_peak_memory_bytes_bucket{language="go",le="2.68435456e+08"} 1`,
+		`datamortem_sandbox_job_peak_memory_bytes_bucket{language="go",le="1.34217728e+08"} 0`,
+		`datamortem_sandbox_output_bytes_total{language="go"} 300`,
+		`datamortem_sandbox_job_duration_seconds_count{language="go"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestRunMetricsWithoutSampling(t *testing.T) {
+	rt := &fakeRuntime{}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Cmd; !reflect.DeepEqual(got, goRunCmd("go", "run", ".")) {
+		t.Errorf("cmd = %q, want no wrapper", got)
+	}
+	if m := res.Metrics; m.CPUTime != 0 || m.PeakMemoryBytes != 0 || m.Duration <= 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestPoolRecordsMetrics(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	rec := NewPrometheusMetrics()
+	p.runner.MetricsRecorder = rec
+
+	if _, err := p.Run(context.Background(), poolJob(t, "case-1")); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	rec.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `datamortem_sandbox_jobs_total{language="go",outcome="success"} 1`) {
+		t.Errorf("pooled job not recorded:\n%s", w.Body.String())
+	}
+}
+
+func TestGoRunWrapperLetsOuterWrapperFlush(t *testing.T) {
+	for _, name := range []string{"sh", "setsid"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not in PATH", name)
+		}
+	}
+	dir := t.TempDir()
+	ready, flushed, done := filepath.Join(dir, "ready"), filepath.Join(dir, "flushed"), filepath.Join(dir, "done")
+	// A fake `go run` exits on SIGTERM and leaves its script, which
+	// flushes on SIGTERM, orphaned.
+	goRun := `sh -c 'trap "echo > ` + flushed + `; exit 143" TERM; echo > ` + ready + `; while :; do sleep 0.1; done' &
+trap 'exit 1' TERM
+wait`
+	// The outer wrapper forwards SIGTERM and flushes after the job, as
+	// metricsWrapper and quotaWrapper do.
+	outer := `"$@" &
+pid=$!
+trap 'kill -TERM $pid 2>/dev/null' TERM
+while :; do
+	wait $pid
+	code=$?
+	kill -0 $pid 2>/dev/null || break
+done
+echo > ` + done + `
+exit $code`
+	cmd := exec.Command("sh", append([]string{"-c", outer, "sh"}, wrapGoRun([]string{"sh", "-c", goRun})...)...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(ready); err == nil {
+			break
+		}
+		if i == 100 {
+			cmd.Process.Kill()
+			t.Fatal("script not started")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("wrappers still running after the script exited")
+	}
+	for _, f := range []string{flushed, done} {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s: %v", filepath.Base(f), err)
+		}
+	}
+}
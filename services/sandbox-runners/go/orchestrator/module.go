@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScriptModule identifies the analysis module a job ran, for the
+// provenance of its results: the "name", "version", "author" and
+// "description" of RequirementsFile.
+type ScriptModule struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// sourceExts are the extensions of the script files of each language.
+var sourceExts = map[string]string{
+	LanguageGo:         ".go",
+	LanguagePython:     ".py",
+	LanguageNode:       ".js",
+	LanguageRust:       ".rs",
+	LanguagePowerShell: ".ps1",
+	LanguageBash:       ".sh",
+	LanguageJava:       ".java",
+}
+
+// ReadScriptModule returns the module the script in workspace declares in
+// RequirementsFile. Without a declared name, the name is that of the
+// script's file without its extension, e.g. "mft_parser" for
+// mft_parser.py: the first source file of language at the workspace root,
+// or in src for Rust.
+func ReadScriptModule(workspace, language string) (ScriptModule, error) {
+	var m ScriptModule
+	manifest, err := readManifest(filepath.Join(workspace, RequirementsFile))
+	switch {
+	case err == nil:
+		m = manifest.ScriptModule
+	case !os.IsNotExist(err):
+		return ScriptModule{}, err
+	}
+	m.Name, m.Version = strings.TrimSpace(m.Name), strings.TrimSpace(m.Version)
+	if m.Name == "" {
+		m.Name = scriptName(workspace, language)
+	}
+	return m, nil
+}
+
+// scriptName derives a module name from the script files in workspace, ""
+// when there are none.
+func scriptName(workspace, language string) string {
+	ext := sourceExts[languageKey(language)]
+	for _, dir := range []string{workspace, filepath.Join(workspace, "src")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		var names []string
+		for _, e := range entries {
+			if e.Type().IsRegular() && strings.EqualFold(filepath.Ext(e.Name()), ext) && !strings.HasSuffix(e.Name(), "_test.go") {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			return strings.TrimSuffix(names[0], filepath.Ext(names[0]))
+		}
+	}
+	return ""
+}
+
+// String describes m for display, e.g. "yara-triage v1.2.0".
+func (m ScriptModule) String() string {
+	if m.Version == "" {
+		return m.Name
+	}
+	return m.Name + " v" + strings.TrimPrefix(m.Version, "v")
+}
@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestReadScriptModule(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		language string
+		files    map[string]string
+		want     ScriptModule
+	}{
+		{"declared", LanguagePython, map[string]string{
+			RequirementsFile: `{"name":"yara-triage","version":"1.2.0","author":"DFIR team","description":"YARA triage of disk images"}`,
+			"script.py":      "",
+		}, ScriptModule{Name: "yara-triage", Version: "1.2.0", Author: "DFIR team", Description: "YARA triage of disk images"}},
+		{"requirements only", LanguageGo, map[string]string{
+			RequirementsFile: `{"requires":{"memory":"1GB"}}`,
+			"mft_parser.go":  "package main\n",
+			"util.go":        "package main\n",
+		}, ScriptModule{Name: "mft_parser"}},
+		{"no manifest", LanguageBash, map[string]string{"collect.sh": "", "README.md": ""}, ScriptModule{Name: "collect"}},
+		{"no script", LanguageNode, map[string]string{"data.txt": ""}, ScriptModule{}},
+	} {
+		got, err := ReadScriptModule(writeWorkspace(t, tc.files), tc.language)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: ReadScriptModule() = %+v, %v, want %+v", tc.name, got, err, tc.want)
+		}
+	}
+
+	ws := writeWorkspace(t, map[string]string{RequirementsFile: `{"name":42}`})
+	if _, err := ReadScriptModule(ws, LanguageGo); err == nil {
+		t.Error("invalid manifest accepted")
+	}
+}
+
+func TestScriptModuleString(t *testing.T) {
+	for m, want := range map[ScriptModule]string{
+		{Name: "yara-triage", Version: "1.2.0"}:  "yara-triage v1.2.0",
+		{Name: "yara-triage", Version: "v1.2.0"}: "yara-triage v1.2.0",
+		{Name: "collect"}:                        "collect",
+	} {
+		if got := m.String(); got != want {
+			t.Errorf("%+v: String() = %q, want %q", m, got, want)
+		}
+	}
+}
+
+func TestRunRecordsScriptModule(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.ResultsFile), []byte(
+					`{"evidence_uid":"ev-1","severity":"high","title":"Mimikatz"}`+"\n"), 0o644)
+			}
+		}
+	}}
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	job := testJob(t)
+	job.Workspace = writeWorkspace(t, map[string]string{
+		RequirementsFile: `{"name":"yara-triage","version":"1.2.0","author":"DFIR team"}`,
+		"main.go":        "package main\n",
+	})
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ScriptModule{Name: "yara-triage", Version: "1.2.0", Author: "DFIR team"}
+	if res.Module != want {
+		t.Errorf("module = %+v, want %+v", res.Module, want)
+	}
+
+	findings := NewCaseFindings("case-1")
+	if err := findings.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	if got := findings.Findings()[0].ProducedBy(); got != "produced by yara-triage v1.2.0" {
+		t.Errorf("ProducedBy() = %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Module == nil || *entries[0].Module != want {
+		t.Errorf("audit entries = %+v", entries)
+	}
+}
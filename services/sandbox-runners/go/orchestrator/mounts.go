@@ -0,0 +1,75 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrForbiddenMount is returned for a container whose mounts go beyond the
+// minimal set of the runner contract; the container is not created.
+var ErrForbiddenMount = errors.New("orchestrator: forbidden mount")
+
+// writableTargets are the container paths that may be mounted read-write:
+// the workspace, OUTPUT_DIR, its quota staging directory, the build output,
+// the shared directory of the case, the heartbeat directory and the
+// evidence, with ExecConfig.EvidenceWritable.
+var writableTargets = []string{containerWorkspace, containerOutputDir, containerOutputSync, containerBuildDir, containerSharedDir, containerHeartbeatDir, containerEvidence}
+
+// readOnlyTargets are the other container paths that may be mounted.
+var readOnlyTargets = []string{containerContextDir, containerSecretsDir, containerFetchDir, containerQueryDir, containerStreamDir, containerVulnDB, containerYaraDir, containerScriptBin}
+
+// forbiddenSources are host paths never mounted into a sandbox, with what
+// lies under them: the host configuration, kernel interfaces and the
+// container engine.
+var forbiddenSources = []string{
+	"/etc", "/proc", "/sys", "/dev", "/boot",
+	"/run/docker.sock", "/var/run/docker.sock", "/var/lib/docker",
+	"/run/containerd", "/var/run/containerd",
+}
+
+// under reports whether p is dir or lies under it.
+func under(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// checkMounts verifies that spec only mounts the paths of the runner
+// contract: each target is a known container path or lies under one, only
+// writableTargets are writable, and no source is the host root or under
+// forbiddenSources, symlinks resolved.
+func checkMounts(spec ContainerSpec) error {
+	for _, m := range spec.Mounts {
+		target := path.Clean(m.Target)
+		allowed := false
+		for _, t := range writableTargets {
+			allowed = allowed || under(target, t)
+		}
+		if !allowed && !m.ReadOnly {
+			return fmt.Errorf("%w: %s must be read-only", ErrForbiddenMount, m.Target)
+		}
+		for _, t := range readOnlyTargets {
+			allowed = allowed || under(target, t)
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s is not a sandbox path", ErrForbiddenMount, m.Target)
+		}
+		if !filepath.IsAbs(m.Source) {
+			return fmt.Errorf("%w: relative source %s", ErrForbiddenMount, m.Source)
+		}
+		source := filepath.Clean(m.Source)
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			source = resolved
+		}
+		if source == "/" {
+			return fmt.Errorf("%w: %s is the host root", ErrForbiddenMount, m.Source)
+		}
+		for _, f := range forbiddenSources {
+			if under(source, f) {
+				return fmt.Errorf("%w: %s is under %s", ErrForbiddenMount, m.Source, f)
+			}
+		}
+	}
+	return checkDevices(spec)
+}
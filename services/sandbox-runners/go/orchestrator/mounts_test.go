@@ -0,0 +1,47 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheckMounts(t *testing.T) {
+	ok := []Mount{
+		{Source: "/lake/case-1/ws", Target: containerWorkspace},
+		{Source: "/lake/case-1/out", Target: containerOutputDir},
+		{Source: "/lake/case-1/ev-1/disk.raw", Target: "/evidence/disk.raw", ReadOnly: true},
+		{Source: "/lake/case-1/ev-2/mem.raw", Target: "/evidence/1/mem.raw"},
+		{Source: "/rules/all.yar", Target: "/yara/all.yar", ReadOnly: true},
+	}
+	if err := checkMounts(ContainerSpec{Mounts: ok}); err != nil {
+		t.Errorf("contract mounts: %v", err)
+	}
+	for _, bad := range []Mount{
+		{Source: "/", Target: "/evidence/root", ReadOnly: true},
+		{Source: "/etc/passwd", Target: "/evidence/passwd", ReadOnly: true},
+		{Source: "/var/run/docker.sock", Target: "/evidence/docker.sock", ReadOnly: true},
+		{Source: "/proc/1/root", Target: "/evidence/1/root", ReadOnly: true},
+		{Source: "/lake/case-1/ws", Target: "/home/sandbox", ReadOnly: true},
+		{Source: "/rules/all.yar", Target: "/yara/all.yar"},
+		{Source: "relative", Target: containerWorkspace},
+	} {
+		if err := checkMounts(ContainerSpec{Mounts: []Mount{bad}}); !errors.Is(err, ErrForbiddenMount) {
+			t.Errorf("%+v: err = %v, want ErrForbiddenMount", bad, err)
+		}
+	}
+}
+
+func TestRunRejectsForbiddenMount(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Retry = RetryPolicy{MaxAttempts: 3}
+	job := testJob(t)
+	job.Evidence.Path = "/etc/passwd"
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrForbiddenMount) {
+		t.Fatalf("err = %v, want ErrForbiddenMount", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers created", len(rt.specs))
+	}
+}
@@ -0,0 +1,233 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkMode selects the network access granted to a sandbox container.
+type NetworkMode string
+
+const (
+	// NetworkNone disconnects the container entirely. It is the default.
+	NetworkNone NetworkMode = "none"
+	// NetworkAllowlist lets the container reach ExecConfig.AllowedHosts,
+	// and nothing else, through an egress proxy run by the orchestrator.
+	NetworkAllowlist NetworkMode = "host-allowlist"
+)
+
+// EgressConfig describes how allowlisted containers reach the egress proxy.
+// The network should be created with `docker network create --internal`
+// so that the proxy is the only way out.
+type EgressConfig struct {
+	// Network is the docker network allowlisted containers join.
+	Network string
+	// ListenHost is the host address the per-job proxy binds to.
+	ListenHost string
+	// AdvertiseHost is the address containers use to reach ListenHost;
+	// ListenHost when empty.
+	AdvertiseHost string
+}
+
+// NetworkAudit records the network access a job was given.
+type NetworkAudit struct {
+	Mode         NetworkMode
+	AllowedHosts []string
+	// RatePerHost is ExecConfig.RatePerHost, zero when not capped.
+	RatePerHost float64
+}
+
+func (c ExecConfig) networkMode() NetworkMode {
+	if c.NetworkMode == "" {
+		return NetworkNone
+	}
+	return c.NetworkMode
+}
+
+// hostAllowed reports whether host matches an entry of allowed. Entries are
+// host names, optionally with a leading "*." to match subdomains.
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// hostLimiter spaces the requests to each host by a fixed interval,
+// making later requests wait for their slot rather than dropping them.
+type hostLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     map[string]time.Time
+}
+
+// newHostLimiter returns a limiter of rate requests per second and host,
+// or nil, which does not limit, for a rate of zero.
+func newHostLimiter(rate float64) *hostLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &hostLimiter{interval: time.Duration(float64(time.Second) / rate), next: map[string]time.Time{}}
+}
+
+// wait blocks until host may be sent a request, ctx is done or closed is
+// closed.
+func (l *hostLimiter) wait(ctx context.Context, host string, closed <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mu.Unlock()
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return errors.New("egress proxy closed")
+	}
+}
+
+// egressProxy is an HTTP proxy, CONNECT included, that only forwards to
+// allowlisted hosts, at most at the rate of its limiter.
+type egressProxy struct {
+	allowed   []string
+	limiter   *hostLimiter
+	listener  net.Listener
+	server    *http.Server
+	transport *http.Transport
+	// closed is closed by Close, failing the requests still throttled.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func startEgressProxy(listenHost string, allowed []string, ratePerHost float64) (*egressProxy, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(listenHost, "0"))
+	if err != nil {
+		return nil, fmt.Errorf("egress proxy: %w", err)
+	}
+	p := &egressProxy{
+		allowed:   allowed,
+		limiter:   newHostLimiter(ratePerHost),
+		listener:  ln,
+		transport: &http.Transport{Proxy: nil},
+		closed:    make(chan struct{}),
+	}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}
+	go p.server.Serve(ln)
+	return p, nil
+}
+
+// port is the port the proxy listens on.
+func (p *egressProxy) port() string {
+	_, port, _ := net.SplitHostPort(p.listener.Addr().String())
+	return port
+}
+
+// Close stops the proxy; it is a no-op on a nil proxy.
+func (p *egressProxy) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.closeOnce.Do(func() { close(p.closed) })
+	p.transport.CloseIdleConnections()
+	err := p.server.Close()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := req.URL.Hostname()
+	if req.Method == http.MethodConnect {
+		host, _, _ = net.SplitHostPort(req.Host)
+	}
+	if !hostAllowed(host, p.allowed) {
+		http.Error(w, "egress to "+host+" is not allowed", http.StatusForbidden)
+		return
+	}
+	if err := p.limiter.wait(req.Context(), host, p.closed); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if req.Method == http.MethodConnect {
+		p.tunnel(w, req)
+		return
+	}
+	p.forward(w, req)
+}
+
+func (p *egressProxy) tunnel(w http.ResponseWriter, req *http.Request) {
+	upstream, err := net.DialTimeout("tcp", req.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunnelling unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	client, _, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+func (p *egressProxy) forward(w http.ResponseWriter, req *http.Request) {
+	out := req.Clone(context.WithoutCancel(req.Context()))
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,155 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"api.example.com", "*.intel.example"}
+	for host, want := range map[string]bool{
+		"api.example.com":      true,
+		"API.example.com.":     true,
+		"evil.example.com":     false,
+		"vt.intel.example":     true,
+		"a.b.intel.example":    true,
+		"intel.example":        false,
+		"api.example.com.evil": false,
+	} {
+		if got := hostAllowed(host, allowed); got != want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestEgressProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "intel")
+	}))
+	defer upstream.Close()
+
+	proxy, err := startEgressProxy("127.0.0.1", []string{"127.0.0.1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	proxyURL, _ := url.Parse("http://127.0.0.1:" + proxy.port())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "intel" {
+		t.Errorf("allowed request: %d %q", resp.StatusCode, body)
+	}
+
+	denied := strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1)
+	resp, err = client.Get(denied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("denied request status = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestEgressProxyRatePerHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	proxy, err := startEgressProxy("127.0.0.1", []string{"127.0.0.1"}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	proxyURL, _ := url.Parse("http://127.0.0.1:" + proxy.port())
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, resp.StatusCode)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests at 20/s took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	l := newHostLimiter(0.001)
+	closed := make(chan struct{})
+	if err := l.wait(context.Background(), "api.example.com", closed); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.wait(context.Background(), "other.example.com", closed); err != nil {
+		t.Errorf("other host throttled: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, "API.example.com.", closed); err == nil {
+		t.Error("second request to the same host not throttled")
+	}
+	close(closed)
+	if err := l.wait(context.Background(), "api.example.com", closed); err == nil {
+		t.Error("throttled request not released by close")
+	}
+	if err := newHostLimiter(0).wait(context.Background(), "api.example.com", closed); err != nil {
+		t.Errorf("unlimited: %v", err)
+	}
+}
+
+func TestRunNetworkModes(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := rt.lastSpec(); spec.Network != "none" || spec.Env["HTTPS_PROXY"] != "" {
+		t.Errorf("default network = %q, proxy %q", spec.Network, spec.Env["HTTPS_PROXY"])
+	}
+	if res.Network.Mode != NetworkNone {
+		t.Errorf("audit mode = %q, want none", res.Network.Mode)
+	}
+
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.NetworkMode = NetworkAllowlist
+	cfg.AllowedHosts = []string{"api.example.com"}
+	job.Config = &cfg
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Fatal("allowlist mode without Runner.Egress should fail")
+	}
+
+	r.Egress = &EgressConfig{Network: "dm-egress", ListenHost: "127.0.0.1", AdvertiseHost: "10.0.0.1"}
+	res, err = r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	if spec.Network != "dm-egress" || !strings.HasPrefix(spec.Env["HTTPS_PROXY"], "http://10.0.0.1:") {
+		t.Errorf("allowlist network = %q, proxy %q", spec.Network, spec.Env["HTTPS_PROXY"])
+	}
+	want := NetworkAudit{Mode: NetworkAllowlist, AllowedHosts: []string{"api.example.com"}}
+	if !reflect.DeepEqual(res.Network, want) {
+		t.Errorf("audit = %+v, want %+v", res.Network, want)
+	}
+}
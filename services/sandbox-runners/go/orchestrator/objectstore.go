@@ -0,0 +1,176 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrObjectNotFound is returned by an ObjectStore for a missing object; the
+// job fails with FailureEvidenceMissing.
+var ErrObjectNotFound = errors.New("orchestrator: object not found")
+
+// ErrObjectChanged is returned by an ObjectStore when the object is no
+// longer the version its ObjectInfo describes.
+var ErrObjectChanged = errors.New("orchestrator: object changed")
+
+// ObjectInfo describes a version of an object.
+type ObjectInfo struct {
+	Size int64
+	// ETag identifies the version, empty if the store has none.
+	ETag string
+	// Ranges reports that the store serves byte ranges of the object.
+	Ranges bool
+}
+
+// ObjectStore reads the evidence kept in object storage, at Evidence.URL.
+// Its errors must not quote the URL, which may carry credentials: see
+// objectName.
+type ObjectStore interface {
+	// Stat describes the current version of the object at rawURL.
+	Stat(ctx context.Context, rawURL string) (ObjectInfo, error)
+	// Open reads the whole object, of the version of info.
+	Open(ctx context.Context, rawURL string, info ObjectInfo) (io.ReadCloser, error)
+	// ReadRange reads length bytes of the object from off, of the
+	// version of info, when info.Ranges is set.
+	ReadRange(ctx context.Context, rawURL string, info ObjectInfo, off, length int64) (io.ReadCloser, error)
+}
+
+// HTTPObjectStore reads objects over HTTP or HTTPS, e.g. S3 or MinIO with
+// presigned URLs. It only sends GET requests, which presigned URLs allow,
+// pinning the version with If-Match, and tells that the store serves
+// ranges from the answer to a one-byte range request.
+type HTTPObjectStore struct {
+	// Client is http.DefaultClient when nil.
+	Client *http.Client
+	// Authorize, when set, signs each request, e.g. with AWS Signature
+	// Version 4 for URLs that are not presigned.
+	Authorize func(*http.Request) error
+}
+
+// Stat implements ObjectStore.
+func (s *HTTPObjectStore) Stat(ctx context.Context, rawURL string) (ObjectInfo, error) {
+	resp, err := s.get(ctx, rawURL, ObjectInfo{}, "bytes=0-0")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	info := ObjectInfo{ETag: resp.Header.Get("ETag"), Size: resp.ContentLength}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		info.Ranges = true
+		info.Size = contentRangeSize(resp.Header.Get("Content-Range"))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty object has no first byte.
+		info.Ranges = true
+		if info.Size = contentRangeSize(resp.Header.Get("Content-Range")); info.Size != 0 {
+			return ObjectInfo{}, fmt.Errorf("orchestrator: object %s: %s", objectName(rawURL), resp.Status)
+		}
+	}
+	if info.Size < 0 {
+		return ObjectInfo{}, fmt.Errorf("orchestrator: object %s: unknown size", objectName(rawURL))
+	}
+	return info, nil
+}
+
+// Open implements ObjectStore.
+func (s *HTTPObjectStore) Open(ctx context.Context, rawURL string, info ObjectInfo) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, rawURL, info, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("orchestrator: object %s: %s", objectName(rawURL), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ReadRange implements ObjectStore.
+func (s *HTTPObjectStore) ReadRange(ctx context.Context, rawURL string, info ObjectInfo, off, length int64) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, rawURL, info, fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("orchestrator: object %s: %s to a range request", objectName(rawURL), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// get sends a GET request for rawURL, of the range rng unless empty, and
+// returns the response unless it reports an error other than 416.
+func (s *HTTPObjectStore) get(ctx context.Context, rawURL string, info ObjectInfo, rng string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("orchestrator: object %s: invalid URL", objectName(rawURL))
+	}
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+	if info.ETag != "" {
+		req.Header.Set("If-Match", info.ETag)
+	}
+	if s.Authorize != nil {
+		if err := s.Authorize(req); err != nil {
+			return nil, fmt.Errorf("orchestrator: object %s: authorize: %w", objectName(rawURL), err)
+		}
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error of Do quotes the URL.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("orchestrator: object %s: %w", objectName(rawURL), err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		err = fmt.Errorf("%w: %s", ErrObjectNotFound, objectName(rawURL))
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		err = fmt.Errorf("%w: %s", ErrObjectChanged, objectName(rawURL))
+	case resp.StatusCode >= 400 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable:
+		err = fmt.Errorf("orchestrator: object %s: %s", objectName(rawURL), resp.Status)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// contentRangeSize returns the complete length of a Content-Range header,
+// -1 if it has none.
+func contentRangeSize(header string) int64 {
+	_, size, ok := strings.Cut(header, "/")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// objectName is how errors and logs refer to an object: its URL without
+// the query string and user information, where credentials go.
+func objectName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.User, u.RawQuery, u.Fragment = nil, "", ""
+	return u.String()
+}
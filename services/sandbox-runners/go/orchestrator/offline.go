@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// offlineEnv makes the Go toolchain resolve modules from the vendor tree
+// only.
+var offlineEnv = map[string]string{
+	"GOFLAGS": "-mod=vendor",
+	"GOPROXY": "off",
+}
+
+// VendorConfig controls how the orchestrator vendors the dependencies of
+// offline jobs whose workspace has no vendor tree.
+type VendorConfig struct {
+	// Proxy is the GOPROXY of `go mod vendor`, e.g. a mirror inside the
+	// lab. When empty it is "off" and only the modules cached in the
+	// runner image, such as the SDK's, resolve.
+	Proxy string
+	// Network is the docker network of the vendoring container, needed to
+	// reach Proxy; "none" when empty.
+	Network string
+}
+
+// MissingVendorError reports modules required by go.mod that are absent
+// from vendor/modules.txt.
+type MissingVendorError struct {
+	Modules []string
+}
+
+func (e *MissingVendorError) Error() string {
+	return "orchestrator: modules missing from the vendor tree: " + strings.Join(e.Modules, ", ")
+}
+
+// prepareOffline makes sure an offline Go job can build without network:
+// its dependencies are vendored if needed and checked against go.mod, so
+// that a missing module fails the job before it starts.
+func (r *Runner) prepareOffline(ctx context.Context, job Job, spec ContainerSpec) error {
+	ws := job.Workspace
+	reqs, err := goModRequires(filepath.Join(ws, "go.mod"))
+	if err != nil {
+		return fmt.Errorf("orchestrator: offline jobs need a go.mod: %w", err)
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(ws, "go.sum")); err != nil {
+		return errors.New("orchestrator: offline jobs with dependencies need a go.sum next to go.mod")
+	}
+	modules := filepath.Join(ws, "vendor", "modules.txt")
+	if _, err := os.Stat(modules); errors.Is(err, fs.ErrNotExist) {
+		if err := r.vendor(ctx, spec); err != nil {
+			return err
+		}
+	}
+	vendored, err := vendoredModules(modules)
+	if err != nil {
+		return fmt.Errorf("orchestrator: read vendor tree: %w", err)
+	}
+	var missing []string
+	for _, req := range reqs {
+		if !vendored[req] {
+			missing = append(missing, req)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingVendorError{Modules: missing}
+	}
+	return nil
+}
+
+// moduleFetchSpec derives from spec a container that downloads modules
+// through the Runner.Vendor proxy and network.
+func (r *Runner) moduleFetchSpec(spec ContainerSpec) ContainerSpec {
+	var vc VendorConfig
+	if r.Vendor != nil {
+		vc = *r.Vendor
+	}
+	env := make(map[string]string, len(spec.Env))
+	for k, v := range spec.Env {
+		env[k] = v
+	}
+	env["GOFLAGS"] = "-mod=mod"
+	env["GOPROXY"] = "off"
+	if vc.Proxy != "" {
+		env["GOPROXY"] = vc.Proxy
+	}
+	spec.Env = env
+	spec.Network = vc.Network
+	if vc.Network != "" && vc.Network != "none" && spec.SeccompProfile == defaultSeccompProfile(false) {
+		// The built-in profile of a job without network blocks sockets.
+		spec.SeccompProfile = defaultSeccompProfile(true)
+	}
+	return spec
+}
+
+// vendor runs `go mod vendor` in a container derived from spec, which
+// writes the vendor tree into the workspace.
+func (r *Runner) vendor(ctx context.Context, spec ContainerSpec) error {
+	spec = r.moduleFetchSpec(spec)
+	spec.Cmd = []string{"go", "mod", "vendor"}
+
+	id, err := r.createContainer(ctx, spec)
+	if err != nil {
+		return fmt.Errorf("create vendor container: %w", err)
+	}
+	bg := context.WithoutCancel(ctx)
+	defer r.removeContainer(bg, id)
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		return fmt.Errorf("start vendor container: %w", err)
+	}
+	state, err := r.Runtime.Wait(ctx, id)
+	if err != nil {
+		return fmt.Errorf("wait vendor container: %w", err)
+	}
+	if state.ExitCode != 0 {
+		var stdout, stderr bytes.Buffer
+		r.Runtime.Logs(bg, id, &stdout, &stderr)
+		return fmt.Errorf("orchestrator: go mod vendor failed with exit code %d: %s",
+			state.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// goModRequires lists the "path version" requirements of a go.mod file.
+func goModRequires(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []string
+	inBlock := false
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inBlock && fields[0] == ")":
+			inBlock = false
+		case inBlock && len(fields) >= 2:
+			reqs = append(reqs, fields[0]+" "+fields[1])
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+		case fields[0] == "require" && len(fields) >= 3:
+			reqs = append(reqs, fields[1]+" "+fields[2])
+		}
+	}
+	return reqs, sc.Err()
+}
+
+// vendoredModules returns the "path version" modules recorded in a
+// vendor/modules.txt file.
+func vendoredModules(name string) (map[string]bool, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	mods := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 3 && fields[0] == "#" {
+			mods[fields[1]+" "+fields[2]] = true
+		}
+	}
+	return mods, sc.Err()
+}
@@ -0,0 +1,141 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testGoMod = `module example.com/parser
+
+go 1.21
+
+require github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d // indirect
+
+require (
+	// the SDK
+	github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0
+	golang.org/x/sys v0.28.0 // indirect
+)
+`
+
+const testModulesTxt = `# github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d
+## explicit
+github.com/Velocidex/ordereddict
+# github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0 => /opt/datamortem-sdk
+## explicit; go 1.21
+github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox
+# golang.org/x/sys v0.28.0
+## explicit; go 1.18
+golang.org/x/sys/cpu
+`
+
+func offlineJob(t *testing.T, modulesTxt string) Job {
+	t.Helper()
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.Offline = true
+	job.Config = &cfg
+	os.WriteFile(filepath.Join(job.Workspace, "go.mod"), []byte(testGoMod), 0o644)
+	os.WriteFile(filepath.Join(job.Workspace, "go.sum"), nil, 0o644)
+	if modulesTxt != "" {
+		os.Mkdir(filepath.Join(job.Workspace, "vendor"), 0o755)
+		os.WriteFile(filepath.Join(job.Workspace, "vendor", "modules.txt"), []byte(modulesTxt), 0o644)
+	}
+	return job
+}
+
+func TestGoModRequires(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "go.mod")
+	os.WriteFile(name, []byte(testGoMod), 0o644)
+	reqs, err := goModRequires(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"github.com/Velocidex/ordereddict v0.0.0-20230909174157-2aa49cc5d11d",
+		"github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0",
+		"golang.org/x/sys v0.28.0",
+	}
+	if !reflect.DeepEqual(reqs, want) {
+		t.Errorf("requires = %q, want %q", reqs, want)
+	}
+}
+
+func TestRunOfflineUsesVendorTree(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	if _, err := r.Run(context.Background(), offlineJob(t, testModulesTxt)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.specs) != 1 {
+		t.Fatalf("created %d containers, want no vendoring", len(rt.specs))
+	}
+	env := rt.lastSpec().Env
+	if env["GOFLAGS"] != "-mod=vendor" || env["GOPROXY"] != "off" {
+		t.Errorf("env = %v", env)
+	}
+}
+
+func TestRunOfflineVendorsMissingTree(t *testing.T) {
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		if strings.Join(spec.Cmd, " ") != "go mod vendor" {
+			return
+		}
+		ws := spec.Mounts[0].Source
+		os.Mkdir(filepath.Join(ws, "vendor"), 0o755)
+		os.WriteFile(filepath.Join(ws, "vendor", "modules.txt"), []byte(testModulesTxt), 0o644)
+	}
+	r := NewRunner(rt)
+	r.Vendor = &VendorConfig{Proxy: "http://goproxy.lab", Network: "lab-mirror"}
+	if _, err := r.Run(context.Background(), offlineJob(t, "")); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.specs) != 2 {
+		t.Fatalf("created %d containers, want a vendoring and a run", len(rt.specs))
+	}
+	v := rt.specs[0]
+	if v.Env["GOPROXY"] != "http://goproxy.lab" || v.Env["GOFLAGS"] != "-mod=mod" || v.Network != "lab-mirror" {
+		t.Errorf("vendor container = %+v", v)
+	}
+	if rt.specs[1].Network != "none" {
+		t.Errorf("job network = %q", rt.specs[1].Network)
+	}
+}
+
+func TestRunOfflineRejectsIncompleteVendorTree(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	modules := strings.Replace(testModulesTxt, "# golang.org/x/sys v0.28.0", "# golang.org/x/sys v0.27.0", 1)
+	_, err := r.Run(context.Background(), offlineJob(t, modules))
+	var missing *MissingVendorError
+	if !errors.As(err, &missing) || !reflect.DeepEqual(missing.Modules, []string{"golang.org/x/sys v0.28.0"}) {
+		t.Fatalf("err = %v, want a MissingVendorError for x/sys", err)
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("created %d containers", len(rt.specs))
+	}
+}
+
+func TestRunOfflineRequiresGoSum(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	job := offlineJob(t, testModulesTxt)
+	os.Remove(filepath.Join(job.Workspace, "go.sum"))
+	if _, err := r.Run(context.Background(), job); err == nil || !strings.Contains(err.Error(), "go.sum") {
+		t.Errorf("err = %v, want a go.sum error", err)
+	}
+}
+
+func TestRunOfflineReportsVendorFailure(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 1}, stderr: "missing go.sum entry for module providing package x\n"}
+	r := NewRunner(rt)
+	_, err := r.Run(context.Background(), offlineJob(t, ""))
+	if err == nil || !strings.Contains(err.Error(), "missing go.sum entry") {
+		t.Errorf("err = %v, want the go mod vendor output", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package orchestrator launches sandbox runner containers on behalf of the
+// platform and translates their outcome into a job result. It owns the host
+// side of the contract implemented by the sandbox SDK.
+package orchestrator
+
+// Paths inside the sandbox container.
+const (
+	containerWorkspace = "/workspace"
+	containerOutputDir = "/output"
+	containerEvidence  = "/evidence"
+	containerTmp       = "/tmp"
+	containerYaraDir   = "/yara"
+	// containerScratch is the scratch area of sandbox.TempDir, a tmpfs of
+	// ExecConfig.ScratchQuotaBytes.
+	containerScratch = "/scratch"
+	// containerFetchDir holds the socket of sandbox.FetchEvidence and
+	// containerFetchedDir the evidence it fetched.
+	containerFetchDir   = "/run/datamortem"
+	containerFetchedDir = "/evidence/fetched"
+	// containerQueryDir holds the socket of sandbox.QueryFindings.
+	containerQueryDir = "/run/datamortem-query"
+	// containerContextDir holds the case context file of sandbox.Context.
+	containerContextDir = "/run/datamortem-context"
+	// containerSecretsDir holds the secret files of sandbox.Secret.
+	containerSecretsDir = "/run/datamortem-secrets"
+	// containerStreamDir holds the socket streaming the evidence of
+	// ExecConfig.StreamEvidence.
+	containerStreamDir = "/run/datamortem-stream"
+	// containerHeartbeatDir holds the heartbeat the entrypoint writes for
+	// ExecConfig.HeartbeatTimeout.
+	containerHeartbeatDir = "/run/datamortem-heartbeat"
+	// containerVulnDB holds the vulnerability database of
+	// VulnCheckConfig.DatabaseDir, in the scanning container.
+	containerVulnDB = "/run/datamortem-vulndb"
+)
+
+// Mount is a bind mount from the host into the sandbox container.
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// Device is a host device node exposed in the sandbox container.
+type Device struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ContainerSpec is the runtime-agnostic description of a sandbox container.
+type ContainerSpec struct {
+	Image   string
+	Cmd     []string
+	Env     map[string]string
+	Mounts  []Mount
+	Devices []Device
+	WorkDir string
+	User    string
+	// GroupAdd lists supplementary groups of User, by ID, e.g. the group
+	// owning a device of Devices.
+	GroupAdd []string
+	// ReadOnlyRootfs makes everything outside writable mounts and Tmpfs
+	// read-only.
+	ReadOnlyRootfs bool
+	// Tmpfs lists container paths backed by an in-memory filesystem.
+	Tmpfs []string
+	// Network is the docker network to join; "none" when empty.
+	Network string
+	// MemoryBytes and CPUs are the cgroup limits; zero means unlimited.
+	MemoryBytes int64
+	CPUs        float64
+	// SeccompProfile is a Docker seccomp profile in JSON, or
+	// SeccompUnconfined; the runtime default applies when empty.
+	SeccompProfile string
+	// CapDrop lists the capabilities to drop, e.g. "ALL".
+	CapDrop []string
+	// NoNewPrivileges stops setuid binaries from gaining privileges.
+	NoNewPrivileges bool
+	// Runtime is the OCI runtime to run the container with, e.g. "runsc";
+	// the engine's default when empty.
+	Runtime string
+	// Labels are the container labels, e.g. LabelJob.
+	Labels map[string]string
+	// Interactive keeps the stdin of the main process open for
+	// Runtime.Attach.
+	Interactive bool
+}
+
+// ImageBuildSpec describes an image built from a Dockerfile without a
+// build context.
+type ImageBuildSpec struct {
+	Tag        string
+	Dockerfile string
+	// Labels are the image labels, e.g. LabelFlavor.
+	Labels map[string]string
+	// Network is the network of the RUN instructions; the engine's
+	// default when empty.
+	Network string
+}
+
+// ExecSpec describes a command run inside an already running container.
+type ExecSpec struct {
+	Cmd     []string
+	Env     map[string]string
+	WorkDir string
+	User    string
+}
+
+// ContainerState is the terminal state of an exited container.
+type ContainerState struct {
+	ExitCode int
+	// OOMKilled reports that the kernel killed the container for
+	// exceeding its memory limit.
+	OOMKilled bool
+}
@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EvidenceOverlay is a copy-on-write view of an evidence file.
+type EvidenceOverlay struct {
+	// Path reads as the evidence file; what is written to it goes to the
+	// overlay's upper layer and never reaches the file.
+	Path string
+	// Dir holds the layers and the mount point of the overlay.
+	Dir string
+}
+
+// EvidenceOverlayer mounts evidence files through copy-on-write overlays,
+// for ExecConfig.EvidenceOverlay.
+type EvidenceOverlayer interface {
+	// Mount returns a fresh overlay of file, the lower layer.
+	Mount(ctx context.Context, file string) (EvidenceOverlay, error)
+	// Unmount discards an overlay returned by Mount, its upper layer
+	// included.
+	Unmount(ctx context.Context, o EvidenceOverlay) error
+}
+
+// OverlayFS mounts the directory of each evidence file as the lower layer
+// of an overlayfs mount, whose upper layer is a new directory; only the
+// file is mounted into the container. It needs root, or CAP_SYS_ADMIN, on
+// the orchestrator host.
+type OverlayFS struct {
+	// TempDir holds the layers and mount points; os.TempDir() when empty.
+	// The docker daemon must see the mounts at the same path.
+	TempDir string
+}
+
+// errOverlayPath is returned for an evidence file overlayfs options cannot
+// name.
+var errOverlayPath = errors.New("orchestrator: overlayfs cannot mount a path containing ',', ':' or '\\'")
+
+func (r *Runner) overlayer() EvidenceOverlayer {
+	if r.Overlays != nil {
+		return r.Overlays
+	}
+	return defaultOverlayFS
+}
+
+var defaultOverlayFS = &OverlayFS{}
+
+// overlayEvidence replaces the evidence files of job by overlays of them.
+// On error the overlays mounted so far are unmounted.
+func (r *Runner) overlayEvidence(ctx context.Context, job Job) (Job, []EvidenceOverlay, error) {
+	var overlays []EvidenceOverlay
+	overlay := func(ev *Evidence) error {
+		if ev.Path == "" {
+			return nil
+		}
+		o, err := r.overlayer().Mount(ctx, ev.Path)
+		if err != nil {
+			return fmt.Errorf("orchestrator: overlay evidence %s: %w", ev.UID, err)
+		}
+		overlays = append(overlays, o)
+		ev.Path = o.Path
+		return nil
+	}
+	if err := overlay(&job.Evidence); err != nil {
+		return Job{}, nil, err
+	}
+	extra := append([]Evidence(nil), job.ExtraEvidence...)
+	for i := range extra {
+		if err := overlay(&extra[i]); err != nil {
+			r.unmountOverlays(ctx, overlays)
+			return Job{}, nil, err
+		}
+	}
+	job.ExtraEvidence = extra
+	return job, overlays, nil
+}
+
+// unmountOverlays discards the overlays of overlayEvidence.
+func (r *Runner) unmountOverlays(ctx context.Context, overlays []EvidenceOverlay) {
+	for _, o := range overlays {
+		r.overlayer().Unmount(ctx, o)
+	}
+}
+
+// overlayOptions returns the overlayfs mount options of the layers.
+func overlayOptions(lower, upper, work string) (string, error) {
+	for _, dir := range []string{lower, upper, work} {
+		if strings.ContainsAny(dir, `,:\`) {
+			return "", errOverlayPath
+		}
+	}
+	return "lowerdir=" + lower + ",upperdir=" + upper + ",workdir=" + work, nil
+}
@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mount implements EvidenceOverlayer.
+func (o *OverlayFS) Mount(ctx context.Context, file string) (EvidenceOverlay, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return EvidenceOverlay{}, err
+	}
+	dir, err := os.MkdirTemp(o.TempDir, "datamortem-overlay-")
+	if err != nil {
+		return EvidenceOverlay{}, err
+	}
+	upper, work, merged := filepath.Join(dir, "upper"), filepath.Join(dir, "work"), filepath.Join(dir, "merged")
+	for _, d := range []string{upper, work, merged} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			os.RemoveAll(dir)
+			return EvidenceOverlay{}, err
+		}
+	}
+	opts, err := overlayOptions(filepath.Dir(file), upper, work)
+	if err == nil {
+		err = unix.Mount("overlay", merged, "overlay", 0, opts)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return EvidenceOverlay{}, err
+	}
+	return EvidenceOverlay{Path: filepath.Join(merged, filepath.Base(file)), Dir: dir}, nil
+}
+
+// Unmount implements EvidenceOverlayer.
+func (o *OverlayFS) Unmount(ctx context.Context, ov EvidenceOverlay) error {
+	if err := unix.Unmount(filepath.Join(ov.Dir, "merged"), 0); err != nil {
+		return err
+	}
+	return os.RemoveAll(ov.Dir)
+}
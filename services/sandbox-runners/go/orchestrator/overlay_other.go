@@ -0,0 +1,21 @@
+//go:build !linux
+
+package orchestrator
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoOverlayFS is returned where overlayfs does not exist.
+var errNoOverlayFS = errors.New("orchestrator: evidence overlays need a Linux host")
+
+// Mount implements EvidenceOverlayer.
+func (o *OverlayFS) Mount(ctx context.Context, file string) (EvidenceOverlay, error) {
+	return EvidenceOverlay{}, errNoOverlayFS
+}
+
+// Unmount implements EvidenceOverlayer.
+func (o *OverlayFS) Unmount(ctx context.Context, ov EvidenceOverlay) error {
+	return errNoOverlayFS
+}
@@ -0,0 +1,121 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeOverlays copies each evidence file as its overlay, failing from the
+// failAt-th mount on when set.
+type fakeOverlays struct {
+	failAt           int
+	mounted, removed []string
+}
+
+func (f *fakeOverlays) Mount(ctx context.Context, file string) (EvidenceOverlay, error) {
+	if f.failAt > 0 && len(f.mounted)+1 >= f.failAt {
+		return EvidenceOverlay{}, errors.New("no overlay")
+	}
+	f.mounted = append(f.mounted, file)
+	return EvidenceOverlay{Path: "/overlays/" + filepath.Base(file), Dir: "/overlays"}, nil
+}
+
+func (f *fakeOverlays) Unmount(ctx context.Context, o EvidenceOverlay) error {
+	f.removed = append(f.removed, o.Path)
+	return nil
+}
+
+func overlayJob(t *testing.T, path string) Job {
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.EvidenceOverlay = true
+	job.Config = &cfg
+	job.Evidence.Path, job.Evidence.SHA256 = path, ""
+	return job
+}
+
+func TestRunWithEvidenceOverlay(t *testing.T) {
+	path := writeFixture(t, "disk.raw", "pristine")
+	overlays := &OverlayFS{TempDir: t.TempDir()}
+	if o, err := overlays.Mount(context.Background(), path); err != nil {
+		t.Skipf("overlayfs unavailable: %v", err)
+	} else if err := overlays.Unmount(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := 0
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		runs++
+		for _, m := range spec.Mounts {
+			if m.Target != evidenceTarget(0, Evidence{Path: path}) {
+				continue
+			}
+			if m.ReadOnly || m.Source == path {
+				t.Errorf("evidence mount %+v", m)
+			}
+			if data, err := os.ReadFile(m.Source); err != nil || string(data) != "pristine" {
+				t.Errorf("run %d read %q, %v", runs, data, err)
+			}
+			// The script rewrites the evidence and leaves a file next
+			// to it.
+			if err := os.WriteFile(m.Source, []byte("tampered"), 0o644); err != nil {
+				t.Error(err)
+			}
+			os.WriteFile(filepath.Join(filepath.Dir(m.Source), "carved.bin"), []byte("x"), 0o644)
+		}
+	}}
+	r := NewRunner(rt)
+	r.Overlays = overlays
+	for i := 0; i < 2; i++ {
+		if _, err := r.Run(context.Background(), overlayJob(t, path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "pristine" {
+		t.Errorf("evidence changed to %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "carved.bin")); !os.IsNotExist(err) {
+		t.Errorf("file written next to the evidence reached the backing store: %v", err)
+	}
+	if entries, _ := os.ReadDir(overlays.TempDir); len(entries) != 0 {
+		t.Errorf("%d overlays left", len(entries))
+	}
+}
+
+func TestOverlayEvidenceFailure(t *testing.T) {
+	rt := &fakeRuntime{}
+	overlays := &fakeOverlays{failAt: 2}
+	r := NewRunner(rt)
+	r.Overlays = overlays
+	job := overlayJob(t, "/lake/case-1/ev-1/disk.raw")
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/case-1/ev-2/mem.raw"}}
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Fatal("ran a job whose evidence could not be overlaid")
+	}
+	if len(rt.specs) != 0 || len(overlays.removed) != 1 {
+		t.Errorf("%d containers, unmounted %v", len(rt.specs), overlays.removed)
+	}
+
+	overlays.failAt = 0
+	overlays.removed = nil
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	for i, ev := range job.allEvidence() {
+		want := Mount{Source: "/overlays/" + filepath.Base(ev.Path), Target: evidenceTarget(i, ev)}
+		found := false
+		for _, m := range spec.Mounts {
+			found = found || m == want
+		}
+		if !found {
+			t.Errorf("no mount %+v in %+v", want, spec.Mounts)
+		}
+	}
+	if len(overlays.removed) != 2 {
+		t.Errorf("unmounted %v", overlays.removed)
+	}
+}
@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// MaxParamValueLen bounds the length of a job parameter value in bytes.
+const MaxParamValueLen = 4096
+
+// DefaultParamPatterns is the parameter name allowlist used when
+// Runner.ParamPatterns is nil.
+var DefaultParamPatterns = []string{"PARAM_*"}
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// InvalidParamError reports a job parameter that was rejected.
+type InvalidParamError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidParamError) Error() string {
+	return fmt.Sprintf("orchestrator: invalid parameter %q: %s", e.Name, e.Reason)
+}
+
+// validateParams checks job parameters against the runner's allowlist.
+// Names are checked in order so that the error is deterministic.
+func (r *Runner) validateParams(params map[string]string) error {
+	patterns := r.ParamPatterns
+	if patterns == nil {
+		patterns = DefaultParamPatterns
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := params[name]
+		switch {
+		case !envName.MatchString(name):
+			return &InvalidParamError{name, "not a valid environment variable name"}
+		case sandbox.IsReservedParam(name):
+			return &InvalidParamError{name, "reserved prefix"}
+		case !matchAny(patterns, name):
+			return &InvalidParamError{name, "not in the parameter allowlist"}
+		case len(value) > MaxParamValueLen:
+			return &InvalidParamError{name, fmt.Sprintf("value longer than %d bytes", MaxParamValueLen)}
+		case strings.ContainsRune(value, 0):
+			return &InvalidParamError{name, "value contains a NUL byte"}
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunPassesParams(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.Params = map[string]string{"PARAM_YARA_RULES": "/workspace/rules.yar", "PARAM_FROM": "2024-01-01"}
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	env := rt.lastSpec().Env
+	if env["PARAM_YARA_RULES"] != "/workspace/rules.yar" || env["PARAM_FROM"] != "2024-01-01" {
+		t.Errorf("env = %v", env)
+	}
+}
+
+func TestRunRejectsInvalidParams(t *testing.T) {
+	for _, tc := range []struct {
+		name, value, reason string
+	}{
+		{"PARAM-FROM", "x", "valid environment variable"},
+		{"1PARAM", "x", "valid environment variable"},
+		{"CASE_ID", "other", "reserved"},
+		{"EVIDENCE_PATH", "/etc/shadow", "reserved"},
+		{"OUTPUT_DIR", "/", "reserved"},
+		{"GOFLAGS", "-toolexec=sh", "reserved"},
+		{"LD_PRELOAD", "/tmp/x.so", "allowlist"},
+		{"PARAM_BIG", strings.Repeat("a", MaxParamValueLen+1), "longer"},
+		{"PARAM_NUL", "a\x00b", "NUL"},
+	} {
+		rt := &fakeRuntime{}
+		r := NewRunner(rt)
+		job := testJob(t)
+		job.Params = map[string]string{tc.name: tc.value}
+		_, err := r.Run(context.Background(), job)
+		var invalid *InvalidParamError
+		if !errors.As(err, &invalid) || invalid.Name != tc.name || !strings.Contains(invalid.Reason, tc.reason) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.reason)
+		}
+		if len(rt.specs) != 0 {
+			t.Errorf("%s: container created", tc.name)
+		}
+	}
+}
+
+func TestRunParamPatterns(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.ParamPatterns = []string{"YARA_*"}
+	job := testJob(t)
+	job.Params = map[string]string{"YARA_RULES": "rules.yar"}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("allowed parameter rejected: %v", err)
+	}
+	job.Params = map[string]string{"PARAM_FROM": "x"}
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("parameter outside the custom allowlist accepted")
+	}
+}
@@ -0,0 +1,555 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Pipeline is a workflow of jobs on a case run as a DAG: each stage runs
+// once the stages it depends on have finished, if its conditions on their
+// outcome hold.
+type Pipeline struct {
+	ID     string
+	CaseID string
+	// Evidence is the item the stages without an Input run on, unless
+	// their Job has its own.
+	Evidence Evidence
+	// OutputDir and LogDir hold a subdirectory per stage, named after it,
+	// for its job's OutputDir and LogDir.
+	OutputDir string
+	LogDir    string
+	Stages    []PipelineStage
+	// Tracer, when set, records the pipeline as a span whose trace the
+	// jobs of its stages join, through their Job.TraceParent, so that the
+	// whole pipeline is one trace; TraceParent is the W3C traceparent
+	// header of the span the pipeline belongs under, if any. Give the
+	// Runner the stages run on the same Tracer.
+	Tracer      *Tracer
+	TraceParent string
+}
+
+// PipelineStage is a job of a Pipeline.
+type PipelineStage struct {
+	// Name identifies the stage in the pipeline, e.g. "carve".
+	Name string
+	// Job is the template of the stage's job. It gets the ID
+	// "<Pipeline.ID>-<Name>" with the pipeline ID as ParentID, the case of
+	// the pipeline and its subdirectories of OutputDir and LogDir.
+	Job Job
+	// After lists the stages that must finish first, besides those named
+	// by When and Input.
+	After []string
+	// When lists conditions on the outcome of earlier stages, which must
+	// all hold for the stage to run.
+	When []StageCondition
+	// Input, when set, runs the stage on the evidence an earlier stage
+	// extracted instead of Job.Evidence.
+	Input *StageInput
+}
+
+// ConditionKind is the test of a StageCondition.
+type ConditionKind string
+
+// Kinds of StageCondition.
+const (
+	// ConditionExitCode holds when the stage exited with the code in
+	// Value, e.g. "0" or "2". It is the only condition that lets a stage
+	// run after a stage that failed.
+	ConditionExitCode ConditionKind = "exit_code"
+	// ConditionFindings holds when the stage reported a finding, of at
+	// least the severity in Value when set, e.g. "high".
+	ConditionFindings ConditionKind = "findings"
+	// ConditionArtifact holds when the stage produced an artifact of the
+	// kind in Value, e.g. sandbox.ArtifactExtractedEvidence.
+	ConditionArtifact ConditionKind = "artifact"
+	// ConditionExtracted holds when the stage extracted evidence whose
+	// file name matches the path.Match pattern in Value, e.g. "*.exe",
+	// or any evidence when Value is empty.
+	ConditionExtracted ConditionKind = "extracted"
+)
+
+// StageCondition is a test on the outcome of the stage Stage.
+type StageCondition struct {
+	Stage string
+	Kind  ConditionKind
+	Value string
+}
+
+func (c StageCondition) String() string {
+	if c.Value == "" {
+		return fmt.Sprintf("%s %s", c.Stage, c.Kind)
+	}
+	return fmt.Sprintf("%s %s %s", c.Stage, c.Kind, c.Value)
+}
+
+// StageInput selects the evidence extracted by the stage Stage that a
+// stage runs on: the first item as its Evidence, the others as its
+// ExtraEvidence.
+type StageInput struct {
+	Stage string
+	// Match is a path.Match pattern on the file name of the extracted
+	// evidence, e.g. "*.exe"; every item when empty.
+	Match string
+}
+
+// StageStatus is the state of a stage of a pipeline.
+type StageStatus string
+
+// Statuses of PipelineStageState.
+const (
+	// StageWaiting stages wait for the stages they depend on;
+	// StageSubmitted ones are queued or running.
+	StageWaiting   StageStatus = "waiting"
+	StageSubmitted StageStatus = "submitted"
+	StageSucceeded StageStatus = "succeeded"
+	StageFailed    StageStatus = "failed"
+	StageCancelled StageStatus = "cancelled"
+	// StageSkipped stages are not run, for the Reason recorded.
+	StageSkipped StageStatus = "skipped"
+)
+
+// finished reports whether s is final.
+func (s StageStatus) finished() bool {
+	return s != StageWaiting && s != StageSubmitted
+}
+
+// PipelineStageState is the state of a stage of a running pipeline.
+type PipelineStageState struct {
+	Name   string
+	JobID  string
+	Status StageStatus
+	// Reason explains a skipped stage, e.g. "stage carve failed" or
+	// "condition not met: carve extracted *.exe".
+	Reason string
+	// Result is the job's, once it ran.
+	Result *JobResult
+	// Err explains a stage that failed without a result.
+	Err error
+}
+
+// PipelineRun is a pipeline started by StartPipeline, tracked as a single
+// parent job.
+type PipelineRun struct {
+	ID     string
+	CaseID string
+	// TraceID is the ID of the pipeline's trace, with Pipeline.Tracer.
+	TraceID string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	stages []PipelineStageState
+	jobs   []Job
+	// finished is closed when stage i is final.
+	finished []chan struct{}
+}
+
+// StartPipeline checks p, submits its stages to s as their dependencies
+// finish and returns at once. A stage whose dependency failed, was
+// cancelled or skipped, or whose conditions do not hold, is skipped with
+// its reason, and so are the stages depending on it. Cancelling ctx, or
+// calling Cancel, cancels the stages still queued or running and those
+// not yet submitted.
+func StartPipeline(ctx context.Context, s JobSubmitter, p Pipeline) (*PipelineRun, error) {
+	deps, err := p.check()
+	if err != nil {
+		return nil, err
+	}
+	run := &PipelineRun{ID: p.ID, CaseID: p.CaseID, done: make(chan struct{})}
+	started := time.Now().UTC()
+	for _, st := range p.Stages {
+		job := st.Job
+		if job.RunTime.IsZero() {
+			job.RunTime = started
+		}
+		job.ID = p.ID + "-" + st.Name
+		job.ParentID = p.ID
+		job.CaseID = p.CaseID
+		job.OutputDir = filepath.Join(p.OutputDir, st.Name)
+		if p.LogDir != "" {
+			job.LogDir = filepath.Join(p.LogDir, st.Name)
+		}
+		if job.Evidence.UID == "" {
+			job.Evidence = p.Evidence
+		}
+		if err := os.MkdirAll(job.OutputDir, 0o755); err != nil {
+			return nil, fmt.Errorf("orchestrator: pipeline %s: %w", p.ID, err)
+		}
+		run.jobs = append(run.jobs, job)
+		run.stages = append(run.stages, PipelineStageState{Name: st.Name, JobID: job.ID, Status: StageWaiting})
+		run.finished = append(run.finished, make(chan struct{}))
+	}
+	span := p.Tracer.startSpan(p.TraceParent, spanPipeline, time.Now(),
+		traceAttr{"datamortem.pipeline.id", p.ID}, traceAttr{"datamortem.case.id", p.CaseID})
+	for i := range run.jobs {
+		if run.jobs[i].TraceParent == "" {
+			run.jobs[i].TraceParent = span.traceParent()
+		}
+	}
+	run.TraceID = span.traceIDHex()
+	ctx, run.cancel = context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := range p.Stages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(run.finished[i])
+			run.runStage(ctx, s, i, p.Stages[i], deps[i])
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		run.cancel()
+		span.end(nil)
+		close(run.done)
+	}()
+	return run, nil
+}
+
+// check validates p and returns the stages each stage depends on, by
+// index.
+func (p Pipeline) check() ([][]int, error) {
+	switch {
+	case p.ID == "" || p.CaseID == "":
+		return nil, errors.New("orchestrator: pipeline needs an ID and a case ID")
+	case p.OutputDir == "":
+		return nil, errors.New("orchestrator: pipeline needs an output directory")
+	case len(p.Stages) == 0:
+		return nil, fmt.Errorf("orchestrator: pipeline %s has no stage", p.ID)
+	}
+	index := map[string]int{}
+	for i, st := range p.Stages {
+		if st.Name == "" || st.Name == "." || st.Name == ".." || strings.ContainsAny(st.Name, `/\`) {
+			return nil, fmt.Errorf("orchestrator: pipeline %s: invalid stage name %q", p.ID, st.Name)
+		}
+		if _, ok := index[st.Name]; ok {
+			return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s is defined twice", p.ID, st.Name)
+		}
+		index[st.Name] = i
+	}
+	deps := make([][]int, len(p.Stages))
+	for i, st := range p.Stages {
+		names := slices.Clone(st.After)
+		for _, c := range st.When {
+			switch c.Kind {
+			case ConditionExitCode:
+				if _, err := strconv.Atoi(c.Value); err != nil {
+					return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: invalid exit code %q", p.ID, st.Name, c.Value)
+				}
+			case ConditionFindings:
+				if c.Value != "" && !sandbox.Severity(c.Value).Valid() {
+					return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: %w %q", p.ID, st.Name, sandbox.ErrInvalidSeverity, c.Value)
+				}
+			case ConditionArtifact:
+				if c.Value == "" {
+					return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: artifact condition without a kind", p.ID, st.Name)
+				}
+			case ConditionExtracted:
+				if _, err := path.Match(c.Value, ""); err != nil {
+					return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: pattern %q: %w", p.ID, st.Name, c.Value, err)
+				}
+			default:
+				return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: unknown condition %q", p.ID, st.Name, c.Kind)
+			}
+			names = append(names, c.Stage)
+		}
+		if st.Input != nil {
+			if _, err := path.Match(st.Input.Match, ""); err != nil {
+				return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s: pattern %q: %w", p.ID, st.Name, st.Input.Match, err)
+			}
+			names = append(names, st.Input.Stage)
+		}
+		for _, name := range names {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("orchestrator: pipeline %s: stage %s depends on unknown stage %q", p.ID, st.Name, name)
+			}
+			if !slices.Contains(deps[i], j) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	if cycle := findCycle(p.Stages, deps); cycle != nil {
+		return nil, fmt.Errorf("orchestrator: pipeline %s: dependency cycle %s", p.ID, strings.Join(cycle, " -> "))
+	}
+	return deps, nil
+}
+
+// findCycle returns the names of the stages of a dependency cycle, the
+// first one repeated at the end, or nil if deps is a DAG.
+func findCycle(stages []PipelineStage, deps [][]int) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(stages))
+	var stack []int
+	var visit func(i int) []string
+	visit = func(i int) []string {
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range deps[i] {
+			switch state[j] {
+			case visiting:
+				var cycle []string
+				for _, k := range stack[slices.Index(stack, j):] {
+					cycle = append(cycle, stages[k].Name)
+				}
+				return append(cycle, stages[j].Name)
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range stages {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// runStage waits for the dependencies of stage i, then runs it or records
+// why it is skipped.
+func (run *PipelineRun) runStage(ctx context.Context, s JobSubmitter, i int, st PipelineStage, deps []int) {
+	for _, j := range deps {
+		select {
+		case <-run.finished[j]:
+		case <-ctx.Done():
+			run.finish(i, nil, ctx.Err())
+			return
+		}
+	}
+	run.mu.Lock()
+	job := run.jobs[i]
+	upstream := map[string]PipelineStageState{}
+	for _, j := range deps {
+		upstream[run.stages[j].Name] = run.stages[j]
+	}
+	run.mu.Unlock()
+	if reason := blockedBy(st, upstream); reason != "" {
+		run.skip(i, reason)
+		return
+	}
+	for _, c := range st.When {
+		if !c.holds(upstream[c.Stage].Result) {
+			run.skip(i, "condition not met: "+c.String())
+			return
+		}
+	}
+	if st.Input != nil {
+		evidence := stageInput(*st.Input, upstream[st.Input.Stage].Result)
+		if len(evidence) == 0 {
+			run.skip(i, fmt.Sprintf("stage %s extracted no evidence matching %q", st.Input.Stage, st.Input.Match))
+			return
+		}
+		job.Evidence, job.ExtraEvidence = evidence[0], evidence[1:]
+		run.mu.Lock()
+		run.jobs[i] = job
+		run.mu.Unlock()
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			run.finish(i, nil, err)
+			return
+		}
+		q, err := s.Submit(ctx, job)
+		if errors.Is(err, ErrQueueFull) {
+			select {
+			case <-time.After(fanOutRetry):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if err != nil {
+			run.finish(i, nil, err)
+			return
+		}
+		run.setStatus(i, StageSubmitted)
+		res, err := q.Wait()
+		run.finish(i, res, err)
+		return
+	}
+}
+
+// blockedBy returns why st cannot run after the stages it depends on,
+// or "" if it can. A stage that failed with a result only blocks the
+// stages without a ConditionExitCode on it.
+func blockedBy(st PipelineStage, upstream map[string]PipelineStageState) string {
+	names := make([]string, 0, len(upstream))
+	for name := range upstream {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		u := upstream[name]
+		switch u.Status {
+		case StageSucceeded:
+			continue
+		case StageFailed:
+			exitCond := slices.ContainsFunc(st.When, func(c StageCondition) bool {
+				return c.Stage == name && c.Kind == ConditionExitCode
+			})
+			if u.Result != nil && exitCond {
+				continue
+			}
+			return fmt.Sprintf("stage %s failed", name)
+		case StageCancelled:
+			return fmt.Sprintf("stage %s was cancelled", name)
+		default:
+			return fmt.Sprintf("stage %s was skipped", name)
+		}
+	}
+	return ""
+}
+
+// holds reports whether c holds for res, the result of its stage.
+func (c StageCondition) holds(res *JobResult) bool {
+	if res == nil {
+		return false
+	}
+	switch c.Kind {
+	case ConditionExitCode:
+		code, _ := strconv.Atoi(c.Value)
+		return res.ExitCode == code
+	case ConditionFindings:
+		rank := sandbox.Severity(c.Value).Rank()
+		return slices.ContainsFunc(res.Findings, func(f sandbox.Result) bool { return f.Severity.Rank() >= rank })
+	case ConditionArtifact:
+		return slices.ContainsFunc(res.Artifacts, func(a CollectedArtifact) bool { return a.Kind == c.Value })
+	case ConditionExtracted:
+		return len(stageInput(StageInput{Stage: c.Stage, Match: c.Value}, res)) > 0
+	}
+	return false
+}
+
+// stageInput returns the evidence of res extracted under a name matching
+// in.Match.
+func stageInput(in StageInput, res *JobResult) []Evidence {
+	if res == nil {
+		return nil
+	}
+	var evidence []Evidence
+	for _, x := range res.Extracted {
+		if ok, _ := path.Match(in.Match, path.Base(x.Output)); in.Match == "" || ok {
+			evidence = append(evidence, x.Evidence)
+		}
+	}
+	return evidence
+}
+
+func (run *PipelineRun) setStatus(i int, s StageStatus) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.stages[i].Status = s
+}
+
+func (run *PipelineRun) skip(i int, reason string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.stages[i].Status, run.stages[i].Reason = StageSkipped, reason
+}
+
+// finish records the outcome of stage i, as FanOut does for its children.
+func (run *PipelineRun) finish(i int, res *JobResult, err error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	st := &run.stages[i]
+	st.Result, st.Err = res, err
+	switch {
+	case res == nil && evidenceSkipped(err):
+		st.Status, st.Reason = StageSkipped, err.Error()
+	case res == nil && (errors.Is(err, context.Canceled) || errors.Is(err, ErrWorkerPoolClosed)):
+		st.Status = StageCancelled
+	case res == nil:
+		st.Status = StageFailed
+	case res.Cancelled:
+		st.Status = StageCancelled
+	case res.NotApplicable:
+		st.Status, st.Reason = StageSkipped, "not applicable: "+res.NotApplicableReason
+	case res.Success:
+		st.Status = StageSucceeded
+	default:
+		st.Status = StageFailed
+	}
+}
+
+// Stages returns the state of every stage, in the order of the pipeline.
+func (run *PipelineRun) Stages() []PipelineStageState {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return slices.Clone(run.stages)
+}
+
+// Cancel cancels the stages that have not finished.
+func (run *PipelineRun) Cancel() {
+	run.cancel()
+}
+
+// Done is closed once every stage has finished.
+func (run *PipelineRun) Done() <-chan struct{} { return run.done }
+
+// Wait blocks until every stage has finished and returns the aggregated
+// result.
+func (run *PipelineRun) Wait() (*PipelineResult, error) {
+	<-run.done
+	return run.Result()
+}
+
+// PipelineResult aggregates the stages of a pipeline.
+type PipelineResult struct {
+	ID     string
+	Stages []PipelineStageState
+	// Counts tallies the stages by status.
+	Counts map[StageStatus]int
+	// Findings and IOCs merge those of the stages that ran.
+	Findings *CaseFindings
+	IOCs     *CaseIOCs
+}
+
+// Result returns the aggregate of the stages finished so far.
+func (run *PipelineRun) Result() (*PipelineResult, error) {
+	run.mu.Lock()
+	jobs := slices.Clone(run.jobs)
+	run.mu.Unlock()
+	res := &PipelineResult{
+		ID:       run.ID,
+		Stages:   run.Stages(),
+		Counts:   map[StageStatus]int{},
+		Findings: NewCaseFindings(run.CaseID),
+		IOCs:     NewCaseIOCs(run.CaseID),
+	}
+	for i, st := range res.Stages {
+		res.Counts[st.Status]++
+		if st.Result == nil || !st.Status.finished() {
+			continue
+		}
+		if err := res.Findings.Add(jobs[i], st.Result); err != nil {
+			return nil, err
+		}
+		if err := res.IOCs.Add(jobs[i], st.Result); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
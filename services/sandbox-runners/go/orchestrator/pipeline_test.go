@@ -0,0 +1,177 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// stageRunner plays back the result of each stage of pipeline pipe-1, by
+// stage name, and records the jobs it ran.
+type stageRunner struct {
+	results map[string]JobResult
+	mu      sync.Mutex
+	jobs    map[string]Job
+}
+
+func (s *stageRunner) Run(ctx context.Context, job Job) (*JobResult, error) {
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	res := s.results[strings.TrimPrefix(job.ID, "pipe-1-")]
+	res.JobID = job.ID
+	return &res, nil
+}
+
+func runPipeline(t *testing.T, results map[string]JobResult, stages ...PipelineStage) (*PipelineResult, *stageRunner) {
+	t.Helper()
+	runner := &stageRunner{results: results, jobs: map[string]Job{}}
+	w, _ := NewWorkerPool(runner, WorkerPoolConfig{MaxConcurrent: 2, QueueDepth: 10})
+	defer w.Close()
+	p := Pipeline{
+		ID:        "pipe-1",
+		CaseID:    "case-1",
+		Evidence:  Evidence{UID: "ev-1", Path: "/lake/case-1/ev-1/disk.raw"},
+		OutputDir: t.TempDir(),
+		Stages:    stages,
+	}
+	run, err := StartPipeline(context.Background(), w, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := run.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, runner
+}
+
+// checkStages compares the status and reason of each stage of res.
+func checkStages(t *testing.T, res *PipelineResult, want map[string][2]string) {
+	t.Helper()
+	for _, st := range res.Stages {
+		if w := want[st.Name]; string(st.Status) != w[0] || st.Reason != w[1] {
+			t.Errorf("stage %s: %s %q, want %s %q", st.Name, st.Status, st.Reason, w[0], w[1])
+		}
+	}
+}
+
+func TestPipelineChainsExtractedEvidence(t *testing.T) {
+	extracted := func(name string) ExtractedEvidence {
+		return ExtractedEvidence{Evidence: Evidence{UID: "x-" + name, Path: "/out/carved/" + name}, Output: "carved/" + name, ParentUID: "ev-1"}
+	}
+	results := map[string]JobResult{
+		"carve": {Success: true, Extracted: []ExtractedEvidence{extracted("a.exe"), extracted("b.txt"), extracted("c.exe")}},
+		"pe": {Success: true, Findings: []sandbox.Result{
+			{EvidenceUID: "x-a.exe", Severity: sandbox.SeverityHigh, Title: "packed", FindingKey: "pe/packed"},
+		}},
+		"triage": {Success: true},
+	}
+	res, runner := runPipeline(t, results,
+		PipelineStage{Name: "triage", When: []StageCondition{{Stage: "pe", Kind: ConditionFindings, Value: "high"}}},
+		PipelineStage{Name: "pe", Input: &StageInput{Stage: "carve", Match: "*.exe"}},
+		PipelineStage{Name: "carve"},
+		PipelineStage{Name: "memory", When: []StageCondition{{Stage: "carve", Kind: ConditionArtifact, Value: "memory-dump"}}},
+		PipelineStage{Name: "volatility", After: []string{"memory"}},
+	)
+	checkStages(t, res, map[string][2]string{
+		"carve":      {"succeeded", ""},
+		"pe":         {"succeeded", ""},
+		"triage":     {"succeeded", ""},
+		"memory":     {"skipped", "condition not met: carve artifact memory-dump"},
+		"volatility": {"skipped", "stage memory was skipped"},
+	})
+	pe := runner.jobs["pipe-1-pe"]
+	if pe.Evidence.UID != "x-a.exe" || len(pe.ExtraEvidence) != 1 || pe.ExtraEvidence[0].UID != "x-c.exe" || pe.ParentID != "pipe-1" {
+		t.Errorf("pe job = %+v", pe)
+	}
+	carve := runner.jobs["pipe-1-carve"]
+	if carve.Evidence.UID != "ev-1" || carve.CaseID != "case-1" {
+		t.Errorf("carve job = %+v", carve)
+	}
+	if carve.RunTime.IsZero() || !pe.RunTime.Equal(carve.RunTime) {
+		t.Errorf("run times = %v, %v, want that of the pipeline", carve.RunTime, pe.RunTime)
+	}
+	if res.Counts[StageSucceeded] != 3 || res.Counts[StageSkipped] != 2 || len(res.Findings.Findings()) != 1 {
+		t.Errorf("counts = %v, findings = %+v", res.Counts, res.Findings.Findings())
+	}
+}
+
+func TestPipelineSkipsAfterFailure(t *testing.T) {
+	results := map[string]JobResult{
+		"scan":    {ExitCode: 3},
+		"cleanup": {Success: true},
+	}
+	res, runner := runPipeline(t, results,
+		PipelineStage{Name: "scan"},
+		PipelineStage{Name: "report", After: []string{"scan"}},
+		PipelineStage{Name: "cleanup", When: []StageCondition{{Stage: "scan", Kind: ConditionExitCode, Value: "3"}}},
+		PipelineStage{Name: "notify", When: []StageCondition{{Stage: "scan", Kind: ConditionExitCode, Value: "0"}}},
+	)
+	checkStages(t, res, map[string][2]string{
+		"scan":    {"failed", ""},
+		"report":  {"skipped", "stage scan failed"},
+		"cleanup": {"succeeded", ""},
+		"notify":  {"skipped", "condition not met: scan exit_code 0"},
+	})
+	if _, ok := runner.jobs["pipe-1-report"]; ok {
+		t.Error("stage after a failed stage was run")
+	}
+}
+
+func TestPipelineCancelled(t *testing.T) {
+	w, _ := NewWorkerPool(&stageRunner{jobs: map[string]Job{}}, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 10})
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	run, err := StartPipeline(ctx, w, Pipeline{
+		ID: "pipe-1", CaseID: "case-1", OutputDir: t.TempDir(),
+		Stages: []PipelineStage{{Name: "a"}, {Name: "b", After: []string{"a"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := run.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range res.Stages {
+		if st.Status != StageCancelled && st.Status != StageSkipped {
+			t.Errorf("stage %s: %s after cancellation", st.Name, st.Status)
+		}
+	}
+}
+
+func TestPipelineCheck(t *testing.T) {
+	for name, tc := range map[string]struct {
+		stages []PipelineStage
+		want   string
+	}{
+		"cycle": {
+			[]PipelineStage{{Name: "a", After: []string{"c"}}, {Name: "b", After: []string{"a"}}, {Name: "c", After: []string{"b"}}},
+			"dependency cycle a -> c -> b -> a",
+		},
+		"unknown": {
+			[]PipelineStage{{Name: "a", Input: &StageInput{Stage: "carve"}}},
+			`depends on unknown stage "carve"`,
+		},
+		"duplicate": {[]PipelineStage{{Name: "a"}, {Name: "a"}}, "stage a is defined twice"},
+		"name":      {[]PipelineStage{{Name: "../a"}}, "invalid stage name"},
+		"condition": {
+			[]PipelineStage{{Name: "a"}, {Name: "b", When: []StageCondition{{Stage: "a", Kind: ConditionFindings, Value: "severe"}}}},
+			"invalid severity",
+		},
+		"kind": {
+			[]PipelineStage{{Name: "a"}, {Name: "b", When: []StageCondition{{Stage: "a", Kind: "yara"}}}},
+			`unknown condition "yara"`,
+		},
+	} {
+		p := Pipeline{ID: "pipe-1", CaseID: "case-1", OutputDir: t.TempDir(), Stages: tc.stages}
+		if _, err := StartPipeline(context.Background(), nil, p); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", name, err, tc.want)
+		}
+	}
+}
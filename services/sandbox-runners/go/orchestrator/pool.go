@@ -0,0 +1,747 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// pooledBinary is where a cached build is copied in the slot workspace.
+const pooledBinary = ".datamortem-script"
+
+// idleCmd keeps a pooled container running until it is assigned a job.
+var idleCmd = []string{"tail", "-f", "/dev/null"}
+
+// resetScript runs in a pooled container between jobs. It kills whatever
+// the previous job left running (PID 1 ignores the signal) and empties the
+// writable paths.
+const resetScript = "kill -KILL -1 2>/dev/null; " +
+	"find " + containerTmp + " " + containerScratch + " " + containerWorkspace + " " + containerOutputDir +
+	" -mindepth 1 -delete 2>/dev/null; true"
+
+// Host directories of a pool slot, mounted at the matching container path.
+const (
+	slotWorkspace = "workspace"
+	slotOutput    = "output"
+	slotEvidence  = "evidence"
+	slotContext   = "context"
+)
+
+// PoolConfig sizes a warm container pool.
+type PoolConfig struct {
+	// Size is the number of containers kept ready.
+	Size int
+	// IdleTimeout retires containers left idle for longer; zero keeps
+	// them until Close.
+	IdleTimeout time.Duration
+	// Language selects the runner image of the pooled containers; empty
+	// means Go.
+	Language string
+	// Dir holds the per-container staging directories. Evidence is hard
+	// linked into it when possible, so it should be on the evidence
+	// filesystem. A temporary directory is used when empty.
+	Dir string
+}
+
+// PoolMetrics counts how jobs were served by a Pool.
+type PoolMetrics struct {
+	// Hits are jobs run in a warm container, Misses jobs that needed a
+	// cold start.
+	Hits   uint64
+	Misses uint64
+	Idle   int
+	Busy   int
+}
+
+// Pool runs jobs in pre-started containers. Each job gets a fresh
+// environment through exec, and the container's workspace, output and
+// /tmp are emptied before it is reused, so nothing leaks across cases.
+// Jobs the pool cannot serve, because of their language, configuration or
+// extra evidence, or because every container is busy, fall back to a cold
+// Runner.Run.
+type Pool struct {
+	runner *Runner
+	cfg    PoolConfig
+	dir    string
+	ownDir bool
+
+	mu      sync.Mutex
+	idle    []*poolSlot
+	busy    int
+	warming int
+	seq     int
+	closed  bool
+	hits    uint64
+	misses  uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type poolSlot struct {
+	id       string
+	dir      string
+	lastUsed time.Time
+	// image is the runner image as configured, imageDigest its ID and
+	// goVersion its Go toolchain, for a Go pool.
+	image, imageDigest, goVersion string
+}
+
+// NewPool returns an empty pool running jobs through r; call Warm to start
+// its containers.
+func NewPool(r *Runner, cfg PoolConfig) (*Pool, error) {
+	if cfg.Size < 0 {
+		return nil, errors.New("orchestrator: pool size must not be negative")
+	}
+	if _, err := profile(cfg.Language); err != nil {
+		return nil, err
+	}
+	p := &Pool{runner: r, cfg: cfg, dir: cfg.Dir, stop: make(chan struct{})}
+	if p.dir == "" {
+		dir, err := os.MkdirTemp("", "datamortem-pool-")
+		if err != nil {
+			return nil, err
+		}
+		p.dir, p.ownDir = dir, true
+	}
+	if cfg.IdleTimeout > 0 {
+		p.wg.Add(1)
+		go p.reaper()
+	}
+	return p, nil
+}
+
+// Warm starts containers until the pool holds Size of them.
+func (p *Pool) Warm(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle)+p.busy+p.warming >= p.cfg.Size {
+			p.mu.Unlock()
+			return nil
+		}
+		p.warming++
+		p.mu.Unlock()
+		if err := p.add(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Metrics returns the pool's counters.
+func (p *Pool) Metrics() PoolMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolMetrics{Hits: p.hits, Misses: p.misses, Idle: len(p.idle), Busy: p.busy}
+}
+
+// Run executes job in a warm container when one is available and
+// compatible, and in a cold one otherwise.
+func (p *Pool) Run(ctx context.Context, job Job) (*JobResult, error) {
+	job, err := p.runner.resolveFixture(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+	job, err = resolveParams(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.runner.validateParams(job.Params); err != nil {
+		return nil, err
+	}
+	if err := p.runner.validateCallback(job.Callback); err != nil {
+		return nil, err
+	}
+	if err := p.runner.validateBuild(job); err != nil {
+		return nil, err
+	}
+	// The copy staged in the slot, or by Start, is verified again; a
+	// cached result must not bypass the policy either.
+	if _, err := p.runner.verifyScript(job.Workspace); err != nil {
+		return nil, err
+	}
+	job, err = typeEvidence(job)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := p.runner.Tracer.startJobSpan(ctx, job)
+	res, err := p.runTraced(ctx, job)
+	span.endJob(res, err)
+	return res, err
+}
+
+// runTraced runs the validated job under the job span of ctx.
+func (p *Pool) runTraced(ctx context.Context, job Job) (*JobResult, error) {
+	cached, key, err := p.runner.cachedResult(job)
+	if cached != nil || err != nil {
+		return cached, err
+	}
+	job.RunTime = runTime(job)
+	job.Seed = jobSeed(job)
+	cfg, err := p.runner.jobConfig(job)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PreflightEvidence {
+		if err := preflightEvidence(job, cfg); err != nil {
+			if res, ok := p.runner.evidenceFailure(job, err); ok {
+				return res, nil
+			}
+			return nil, err
+		}
+	}
+	s := p.acquire(p.eligible(job, cfg))
+	if s == nil {
+		return p.runner.run(ctx, job, key)
+	}
+	// A panicking job retires the container and its staging directory.
+	reusable := false
+	defer func() { p.release(context.WithoutCancel(ctx), s, reusable) }()
+	span := spanFrom(ctx).child(spanExecution, time.Now(), traceAttr{"datamortem.pool.warm", "true"})
+	res, reusable, err := p.exec(ctx, s, job, cfg)
+	span.end(err)
+	if tres, ok := p.runner.buildTimeoutFailure(job, err); ok {
+		return tres, nil
+	}
+	if err == nil {
+		res.Attempts = 1
+		res.Image, res.ImageDigest = s.image, s.imageDigest
+		res.Architecture = p.runner.arch()
+		res.GoVersion = s.goVersion
+		res.TraceID = spanFrom(ctx).traceIDHex()
+		p.runner.Enrichment.enrich(context.WithoutCancel(ctx), job, res)
+		p.runner.record(job, res)
+		p.runner.storeResult(key, job, res)
+	}
+	return res, err
+}
+
+// Close removes the idle containers; busy ones are removed when their job
+// completes.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	close(p.stop)
+	p.wg.Wait()
+	for _, s := range idle {
+		p.retire(ctx, s)
+	}
+	if p.ownDir && p.Metrics().Busy == 0 {
+		return os.RemoveAll(p.dir)
+	}
+	return nil
+}
+
+// eligible reports whether job can run in a pooled container, which was
+// created with the runner defaults and image, their scratch quota included, no
+// network, no output quota and a single read-only evidence mount, without a YARA ruleset, secrets,
+// shared directory or heartbeat.
+func (p *Pool) eligible(job Job, cfg ExecConfig) bool {
+	base := p.runner.Defaults
+	return len(job.ExtraEvidence) == 0 &&
+		job.Evidence.URL == "" &&
+		!job.customBuild() &&
+		(p.runner.VulnCheck == nil || languageKey(job.Language) != LanguageGo) &&
+		job.YaraRules == "" &&
+		len(job.Secrets) == 0 &&
+		!(cfg.DecompressEvidence && job.Evidence.compressed()) &&
+		!cfg.AllowEvidenceFetch &&
+		!cfg.AllowFindingQuery &&
+		!cfg.SharedDir &&
+		!cfg.EvidenceBlockDevice &&
+		!cfg.EvidenceOverlay &&
+		cfg.HeartbeatTimeout <= 0 &&
+		languageKey(job.Language) == languageKey(p.cfg.Language) &&
+		!cfg.EvidenceWritable &&
+		cfg.networkMode() == NetworkNone &&
+		cfg.memoryLimit() == base.memoryLimit() &&
+		cfg.cpuQuota() == base.cpuQuota() &&
+		cfg.scratchQuota() == base.scratchQuota() &&
+		cfg.SeccompProfile == base.SeccompProfile &&
+		cfg.KeepCapabilities == base.KeepCapabilities &&
+		cfg.ImageDigest == base.ImageDigest &&
+		cfg.Runtime == base.Runtime &&
+		cfg.OutputQuotaBytes == 0
+}
+
+// acquire takes an idle container, or records a miss and returns nil. A
+// miss on an undersized pool starts a replacement in the background.
+func (p *Pool) acquire(eligible bool) *poolSlot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if eligible && len(p.idle) > 0 {
+		// Take the most recently used container so that the others
+		// can reach the idle timeout.
+		s := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.busy++
+		p.hits++
+		return s
+	}
+	p.misses++
+	if eligible && !p.closed && len(p.idle)+p.busy+p.warming < p.cfg.Size {
+		p.warming++
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.add(context.Background())
+		}()
+	}
+	return nil
+}
+
+// add starts a container and makes it idle. The caller has counted it in
+// p.warming.
+func (p *Pool) add(ctx context.Context) error {
+	s, err := p.newSlot(ctx)
+	p.mu.Lock()
+	p.warming--
+	if err == nil && !p.closed {
+		s.lastUsed = time.Now()
+		p.idle = append(p.idle, s)
+		s = nil
+	}
+	p.mu.Unlock()
+	if s != nil {
+		p.retire(ctx, s)
+	}
+	return err
+}
+
+// release resets s and returns it to the pool, or removes it when it
+// cannot be reused.
+func (p *Pool) release(ctx context.Context, s *poolSlot, reusable bool) {
+	if reusable {
+		reusable = p.reset(ctx, s) == nil
+	}
+	p.mu.Lock()
+	p.busy--
+	if reusable && !p.closed {
+		s.lastUsed = time.Now()
+		p.idle = append(p.idle, s)
+		s = nil
+	}
+	p.mu.Unlock()
+	if s != nil {
+		p.retire(ctx, s)
+	}
+}
+
+func (p *Pool) retire(ctx context.Context, s *poolSlot) {
+	p.runner.removeContainer(ctx, s.id)
+	os.RemoveAll(s.dir)
+}
+
+func (p *Pool) reaper() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.reap(now)
+		}
+	}
+}
+
+// reap retires the containers idle since before now minus IdleTimeout.
+func (p *Pool) reap(now time.Time) {
+	p.mu.Lock()
+	var expired []*poolSlot
+	kept := p.idle[:0]
+	for _, s := range p.idle {
+		if now.Sub(s.lastUsed) >= p.cfg.IdleTimeout {
+			expired = append(expired, s)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	p.idle = kept
+	p.mu.Unlock()
+	for _, s := range expired {
+		p.retire(context.Background(), s)
+	}
+}
+
+// newSlot creates the staging directories of a pooled container and
+// starts it idling.
+func (p *Pool) newSlot(ctx context.Context) (*poolSlot, error) {
+	p.mu.Lock()
+	p.seq++
+	dir := filepath.Join(p.dir, fmt.Sprintf("slot-%d", p.seq))
+	p.mu.Unlock()
+	for _, sub := range []string{slotWorkspace, slotOutput, slotEvidence, slotContext} {
+		d := filepath.Join(dir, sub)
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return nil, err
+		}
+		// The sandbox user must be able to write the workspace and output
+		// whatever uid the orchestrator runs as.
+		if err := os.Chmod(d, 0o777); err != nil {
+			return nil, err
+		}
+	}
+	spec, err := p.slotSpec(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	image := spec.Image
+	info, err := p.runner.resolveImage(ctx, image, p.runner.imageDigest(p.cfg.Language, p.runner.Defaults))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	spec.Image = info.ID
+	if err := p.runner.checkRuntime(ctx, spec.Runtime); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	rt := p.runner.Runtime
+	id, err := p.runner.createContainer(ctx, spec)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("create container: %w", err)
+	}
+	if err := rt.Start(ctx, id); err != nil {
+		rt.Remove(context.WithoutCancel(ctx), id)
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("start container: %w", err)
+	}
+	return &poolSlot{id: id, dir: dir, image: image, imageDigest: spec.Image, goVersion: goVersion(Job{Language: p.cfg.Language}, info)}, nil
+}
+
+// slotSpec mirrors containerSpec for a container that is not yet bound to
+// a job: the contract variables are passed when a job is executed.
+func (p *Pool) slotSpec(dir string) (ContainerSpec, error) {
+	pr, err := profile(p.cfg.Language)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	cfg := p.runner.Defaults
+	env := map[string]string{}
+	for k, v := range pr.Env {
+		env[k] = v
+	}
+	spec := ContainerSpec{
+		Image: p.runner.image(languageKey(p.cfg.Language), pr),
+		Cmd:   idleCmd,
+		Env:   env,
+		Mounts: []Mount{
+			{Source: filepath.Join(dir, slotWorkspace), Target: containerWorkspace},
+			{Source: filepath.Join(dir, slotOutput), Target: containerOutputDir},
+			{Source: filepath.Join(dir, slotEvidence), Target: containerEvidence, ReadOnly: true},
+			{Source: filepath.Join(dir, slotContext), Target: containerContextDir, ReadOnly: true},
+		},
+		WorkDir:        containerWorkspace,
+		User:           "sandbox",
+		ReadOnlyRootfs: true,
+		Tmpfs:          []string{containerTmp, cfg.scratchTmpfs()},
+		Network:        string(NetworkNone),
+		MemoryBytes:    cfg.memoryLimit(),
+		CPUs:           cfg.cpuQuota(),
+		Labels:         map[string]string{LabelJob: "pool"},
+	}
+	if err := applySecurity(&spec, cfg); err != nil {
+		return ContainerSpec{}, err
+	}
+	return spec, nil
+}
+
+// exec runs job in s. reusable is false when the container must not serve
+// another job.
+func (p *Pool) exec(ctx context.Context, s *poolSlot, job Job, cfg ExecConfig) (res *JobResult, reusable bool, err error) {
+	pr, err := profile(job.Language)
+	if err != nil {
+		return nil, true, err
+	}
+	rt := p.runner.Runtime
+	buildCtx, cancelBuild := context.WithTimeout(ctx, cfg.buildTimeout())
+	defer cancelBuild()
+	if cfg.Offline && languageKey(job.Language) == LanguageGo {
+		spec, err := p.runner.containerSpec(job, cfg)
+		if err != nil {
+			return nil, true, err
+		}
+		if err := p.runner.prepareOffline(buildCtx, job, spec); err != nil {
+			if ctx.Err() != nil {
+				res, err := p.runner.cancelledResult(job)
+				return res, true, err
+			}
+			return nil, true, buildTimeout(ctx, buildCtx, cfg, err)
+		}
+	}
+	if err := s.stage(job); err != nil {
+		return nil, true, fmt.Errorf("stage job: %w", err)
+	}
+	// The script is verified, and built, from the slot's copy.
+	staged := job
+	staged.Workspace = filepath.Join(s.dir, slotWorkspace)
+	signer, err := p.runner.verifyScript(staged.Workspace)
+	if err != nil {
+		return nil, true, err
+	}
+	scriptSHA256, err := workspaceKey(staged.Workspace, "")
+	if err != nil {
+		return nil, true, fmt.Errorf("stage job: %w", err)
+	}
+	if err := p.runner.restoreCheckpoint(job, filepath.Join(s.dir, slotOutput)); err != nil {
+		return nil, true, fmt.Errorf("restore checkpoint: %w", err)
+	}
+	env := jobEnv(job, cfg, pr)
+	env[sandbox.EnvContextPath] = contextPath
+	cmd, buildCmd := pr.Cmd, pr.Cmd
+	bin, binarySHA256, failedBuild, ok := p.cachedBuild(buildCtx, staged, cfg)
+	if err := buildTimeout(ctx, buildCtx, cfg, nil); err != nil {
+		return nil, true, err
+	}
+	cancelBuild()
+	if ok {
+		// Pooled jobs do not customise their build.
+		cmd, buildCmd = pr.builtCmd(path.Join(containerWorkspace, pooledBinary)), pr.Build
+		if err := copyFile(bin, filepath.Join(s.dir, slotWorkspace, pooledBinary)); err != nil {
+			return nil, true, fmt.Errorf("stage job: %w", err)
+		}
+	} else if languageKey(job.Language) == LanguageGo {
+		cmd = boundGoCompile(cmd, cfg.buildTimeout())
+	}
+	if cfg.Entrypoint {
+		cmd = wrapEntrypoint(cmd)
+	}
+	if cfg.ResourceMetrics {
+		cmd = wrapMetrics(cmd)
+	}
+	stdout, stderr := newCappedLog(cfg.maxLogBytes()), newCappedLog(cfg.maxLogBytes())
+	w := newWatchdog(cfg, filepath.Join(s.dir, slotOutput))
+	records := newRecordWatch(cfg, filepath.Join(s.dir, slotOutput))
+	runCtx, cancel := context.WithTimeout(ctx, cfg.runTimeout())
+	defer cancel()
+	execCtx, stopExec := context.WithCancel(runCtx)
+	defer stopExec()
+	w.start(execCtx, nil, cfg.KillIdle, stopExec)
+	records.start(execCtx, nil, stopExec)
+	started := time.Now()
+	code, err := rt.Exec(execCtx, s.id, ExecSpec{
+		Cmd:     cmd,
+		Env:     env,
+		WorkDir: containerWorkspace,
+		User:    "sandbox",
+	}, w.output(stdout), w.output(stderr))
+	duration := time.Since(started)
+	stopExec()
+	timedOut, cancelled, idle, overLimit := false, false, false, false
+	if err != nil {
+		bg := context.WithoutCancel(ctx)
+		// Give the job its grace period, as Runner.stop would, so that
+		// its sandbox.OnShutdown handlers flush partial results.
+		switch {
+		case ctx.Err() != nil:
+			cancelled, code = true, 143
+			p.terminate(bg, s, cfg.gracePeriod())
+		case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+			timedOut, code = true, 137
+			p.terminate(bg, s, cfg.gracePeriod())
+		case w != nil && w.killed.Load():
+			idle, code = true, 143
+			p.terminate(bg, s, cfg.gracePeriod())
+		case records != nil && records.killed.Load():
+			overLimit, code = true, 143
+			p.terminate(bg, s, cfg.gracePeriod())
+		default:
+			return nil, false, fmt.Errorf("exec job: %w", err)
+		}
+		// The job outlives the exec client, so only removing the
+		// container is sure to stop it.
+		if err := rt.Remove(bg, s.id); err != nil {
+			return nil, false, fmt.Errorf("stop container: %w", err)
+		}
+	}
+	if err := moveContents(filepath.Join(s.dir, slotOutput), job.OutputDir); err != nil {
+		return nil, false, fmt.Errorf("collect outputs: %w", err)
+	}
+	res, err = p.runner.result(job, cfg, ContainerState{ExitCode: code}, timedOut, duration, stdout.String(), stderr.String())
+	if err == nil {
+		res.Metrics.Started = started
+		res.StdoutDropped, res.StderrDropped = stdout.Dropped(), stderr.Dropped()
+		res.BinarySHA256 = binarySHA256
+		res.SignerKeyID = signer
+		res.ScriptSHA256 = scriptSHA256
+		res.attachBuildLog(job, failedBuild, env)
+		res.EffectiveConfig = p.runner.explainConfig(job, cfg, s.image, s.imageDigest, env, buildCmd)
+	}
+	if err == nil && cancelled {
+		res.markCancelled()
+	}
+	if err == nil {
+		w.apply(res, cfg, idle)
+		records.apply(res, overLimit)
+	}
+	return res, !timedOut && !cancelled && !idle && !overLimit, err
+}
+
+// terminate sends SIGTERM to the processes of s and waits up to grace for
+// them to exit.
+func (p *Pool) terminate(ctx context.Context, s *poolSlot, grace time.Duration) {
+	// kill -0 -1 succeeds while a process other than PID 1 and the shell
+	// remains.
+	script := fmt.Sprintf("kill -TERM -1 2>/dev/null; i=0; "+
+		"while [ $i -lt %d ] && kill -0 -1 2>/dev/null; do sleep 0.1; i=$((i+1)); done; true",
+		int(grace/(100*time.Millisecond)))
+	p.runner.Runtime.Exec(ctx, s.id, ExecSpec{
+		Cmd:  []string{"sh", "-c", script},
+		User: "sandbox",
+	}, io.Discard, io.Discard)
+}
+
+// cachedBuild looks up or builds the job's binary in a cold container,
+// since a pooled container cannot gain the cache mount.
+func (p *Pool) cachedBuild(ctx context.Context, job Job, cfg ExecConfig) (bin, sum string, failed *BuildLog, ok bool) {
+	if p.runner.BuildCache == nil {
+		return "", "", nil, false
+	}
+	spec, err := p.runner.containerSpec(job, cfg)
+	if err != nil {
+		return "", "", nil, false
+	}
+	if spec.Image, err = p.runner.pinImage(ctx, job.Language, spec.Image, cfg); err != nil {
+		return "", "", nil, false
+	}
+	spec.Network = string(NetworkNone)
+	return p.runner.cachedBuild(ctx, job, spec)
+}
+
+// reset empties the container's writable paths so that the next job
+// starts from a clean state.
+func (p *Pool) reset(ctx context.Context, s *poolSlot) error {
+	code, err := p.runner.Runtime.Exec(ctx, s.id, ExecSpec{
+		Cmd:  []string{"sh", "-c", resetScript},
+		User: "sandbox",
+	}, io.Discard, io.Discard)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("reset container: exit code %d", code)
+	}
+	for _, sub := range []string{slotWorkspace, slotOutput, slotEvidence, slotContext} {
+		if err := clearDir(filepath.Join(s.dir, sub)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stage copies the job's workspace into the slot and links its evidence
+// where containerEnv expects it.
+func (s *poolSlot) stage(job Job) error {
+	if err := copyTree(job.Workspace, filepath.Join(s.dir, slotWorkspace)); err != nil {
+		return err
+	}
+	if err := writeContext(filepath.Join(s.dir, slotContext), job); err != nil {
+		return err
+	}
+	if job.Evidence.Path == "" {
+		return nil
+	}
+	dst := filepath.Join(s.dir, slotEvidence, filepath.Base(job.Evidence.Path))
+	if err := os.Link(job.Evidence.Path, dst); err == nil {
+		return nil
+	}
+	return copyFile(job.Evidence.Path, dst)
+}
+
+// clearDir removes the contents of dir, keeping dir itself.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveContents moves the entries of src into dst, copying them when they
+// cannot be renamed across filesystems.
+func moveContents(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		if err := os.Rename(from, to); err == nil {
+			continue
+		}
+		if err := copyTree(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree copies the directories and regular files under src to dst.
+// Symlinks and special files are skipped so that a job cannot make the
+// orchestrator read outside its tree.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case info.Mode().IsRegular():
+			return copyFile(path, target)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,259 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// poolJob is testJob with evidence and a script that exist on disk.
+func poolJob(t *testing.T, caseID string) Job {
+	t.Helper()
+	job := testJob(t)
+	job.CaseID = caseID
+	job.Evidence.Path = filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(job.Evidence.Path, []byte("evidence"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func newTestPool(t *testing.T, rt *fakeRuntime, cfg PoolConfig) *Pool {
+	t.Helper()
+	cfg.Dir = t.TempDir()
+	p, err := NewPool(NewRunner(rt), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close(context.Background()) })
+	if err := p.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestPoolReusesContainerWithoutLeaks(t *testing.T) {
+	rt := &fakeRuntime{}
+	var workspaces [][]string
+	rt.onExec = func(id string, spec ExecSpec) {
+		if spec.Env[sandbox.EnvCaseID] == "" {
+			return // reset
+		}
+		ws := rt.hostPath(id, containerWorkspace)
+		workspaces = append(workspaces, dirNames(t, ws))
+		os.WriteFile(filepath.Join(ws, "stale-"+spec.Env[sandbox.EnvCaseID]), nil, 0o644)
+		os.WriteFile(filepath.Join(rt.hostPath(id, containerOutputDir), "report.txt"), []byte("done"), 0o644)
+		ev := filepath.Join(rt.hostPath(id, containerEvidence), "disk.raw")
+		if _, err := os.Stat(ev); err != nil {
+			t.Errorf("evidence not staged: %v", err)
+		}
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+
+	for _, caseID := range []string{"case-1", "case-2"} {
+		job := poolJob(t, caseID)
+		res, err := p.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Success || !reflect.DeepEqual(res.Outputs, []string{"report.txt"}) {
+			t.Errorf("%s: result = %+v", caseID, res)
+		}
+		if res.ImageDigest != fakeImageID(res.Image) || rt.specs[0].Image != res.ImageDigest {
+			t.Errorf("%s: image %s (%s), container image %s", caseID, res.Image, res.ImageDigest, rt.specs[0].Image)
+		}
+		if _, err := os.Stat(filepath.Join(job.OutputDir, "report.txt")); err != nil {
+			t.Errorf("%s: output not moved: %v", caseID, err)
+		}
+		env := rt.execs[len(rt.execs)-2].Env
+		if env[sandbox.EnvCaseID] != caseID || env[sandbox.EnvEvidencePath] != "/evidence/disk.raw" {
+			t.Errorf("%s: exec env = %v", caseID, env)
+		}
+	}
+
+	if len(rt.specs) != 1 {
+		t.Fatalf("created %d containers, want 1", len(rt.specs))
+	}
+	if want := [][]string{{"main.go"}, {"main.go"}}; !reflect.DeepEqual(workspaces, want) {
+		t.Errorf("workspaces = %v, want %v", workspaces, want)
+	}
+	if got := rt.execs[len(rt.execs)-1].Cmd; !reflect.DeepEqual(got, []string{"sh", "-c", resetScript}) {
+		t.Errorf("last exec = %v, want a reset", got)
+	}
+	if m := p.Metrics(); m.Hits != 2 || m.Misses != 0 || m.Idle != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestPoolMissFallsBackToColdStart(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+
+	job := poolJob(t, "case-1")
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: job.Evidence.Path}}
+	if _, err := p.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.specs) != 2 || len(rt.execs) != 0 {
+		t.Errorf("specs = %d, execs = %d; want a cold container", len(rt.specs), len(rt.execs))
+	}
+	if m := p.Metrics(); m.Hits != 0 || m.Misses != 1 || m.Idle != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+func TestPoolPassesRunTime(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	p.runner.Audit = &AuditLog{Dir: t.TempDir()}
+	runTimeOf := func(job Job) string {
+		t.Helper()
+		if _, err := p.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		for i := len(rt.execs) - 1; i >= 0; i-- {
+			if jobExec(rt.execs[i]) {
+				return rt.execs[i].Env[sandbox.EnvRunTime]
+			}
+		}
+		t.Fatal("no job ran in the pooled container")
+		return ""
+	}
+
+	job := poolJob(t, "case-1")
+	job.RunTime = time.Date(2026, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	if got := runTimeOf(job); got != "2026-03-01T09:00:00Z" {
+		t.Errorf("%s = %q", sandbox.EnvRunTime, got)
+	}
+
+	before := time.Now()
+	job = poolJob(t, "case-1")
+	job.ID = "job-2"
+	got, err := time.Parse(time.RFC3339Nano, runTimeOf(job))
+	if err != nil || got.Before(before.Add(-time.Second)) || got.After(time.Now()) {
+		t.Errorf("%s of a job without RunTime = %v, %v, want now", sandbox.EnvRunTime, got, err)
+	}
+	var buf bytes.Buffer
+	if err := p.runner.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil || len(entries) != 2 || entries[1].RunTime == nil || !entries[1].RunTime.Equal(got) {
+		t.Errorf("audit entries = %+v, %v, want the run time %v recorded", entries, err, got)
+	}
+	if m := p.Metrics(); m.Hits != 2 {
+		t.Errorf("metrics = %+v, want both jobs pooled", m)
+	}
+}
+
+func TestPoolMissesOtherScratchQuota(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+
+	job := poolJob(t, "case-1")
+	cfg := p.runner.Defaults
+	cfg.ScratchQuotaBytes = 1 << 30
+	job.Config = &cfg
+	if _, err := p.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if m := p.Metrics(); m.Hits != 0 || m.Misses != 1 {
+		t.Errorf("metrics = %+v, want the job run cold", m)
+	}
+	if tmpfs := rt.lastSpec().Tmpfs; !slices.Contains(tmpfs, cfg.scratchTmpfs()) {
+		t.Errorf("tmpfs = %v, want %s", tmpfs, cfg.scratchTmpfs())
+	}
+}
+
+func TestPoolRefillsAfterMiss(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	p.reap(time.Now().Add(time.Hour))
+	if m := p.Metrics(); m.Idle != 0 {
+		t.Fatalf("metrics after reap = %+v", m)
+	}
+
+	if _, err := p.Run(context.Background(), poolJob(t, "case-1")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.Metrics().Idle != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not refilled: %+v", p.Metrics())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolReapsIdleContainers(t *testing.T) {
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 2, IdleTimeout: time.Minute})
+
+	p.reap(time.Now())
+	if m := p.Metrics(); m.Idle != 2 {
+		t.Fatalf("fresh containers reaped: %+v", m)
+	}
+	p.reap(time.Now().Add(time.Minute))
+	if m := p.Metrics(); m.Idle != 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if len(rt.removed) != 2 {
+		t.Errorf("removed = %v", rt.removed)
+	}
+}
+
+func TestPoolTimeoutRetiresContainer(t *testing.T) {
+	rt := &fakeRuntime{execBlock: true}
+	rt.onExec = func(id string, spec ExecSpec) {
+		// The shutdown handlers of the script flush on SIGTERM.
+		if !jobExec(spec) && strings.Contains(strings.Join(spec.Cmd, " "), "kill -TERM") {
+			os.WriteFile(filepath.Join(rt.hostPath(id, containerOutputDir), "partial.csv"), nil, 0o644)
+		}
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+
+	job := poolJob(t, "case-1")
+	cfg := DefaultExecConfig()
+	cfg.RunTimeout = 20 * time.Millisecond
+	job.Config = &cfg
+	res, err := p.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || res.Success || res.ExitCode != 137 {
+		t.Errorf("result = %+v", res)
+	}
+	if !reflect.DeepEqual(res.Outputs, []string{"partial.csv"}) {
+		t.Errorf("outputs = %v, want those flushed on SIGTERM", res.Outputs)
+	}
+	if m := p.Metrics(); m.Idle != 0 || m.Busy != 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if len(rt.removed) == 0 || rt.removed[0] != "c1" {
+		t.Errorf("removed = %v", rt.removed)
+	}
+}
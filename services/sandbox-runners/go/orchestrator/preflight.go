@@ -0,0 +1,99 @@
+package orchestrator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// EvidenceError is returned by Start when an evidence item fails the
+// preflight check of ExecConfig.PreflightEvidence, or does not match its
+// digest as it is decompressed. Run and Pool.Run report it as a failed
+// JobResult rather than an error.
+type EvidenceError struct {
+	UID  string
+	Path string
+	// Reason is FailureEvidenceMissing for an item that does not exist,
+	// is empty or cannot be read, and FailureEvidenceCorrupt for one that
+	// does not match its digest.
+	Reason FailureReason
+	Err    error
+}
+
+func (e *EvidenceError) Error() string {
+	return fmt.Sprintf("orchestrator: evidence %s: %v", e.UID, e.Err)
+}
+
+func (e *EvidenceError) Unwrap() error { return e.Err }
+
+// preflightEvidence checks that every evidence item of job is a non-empty
+// file the runner can read and, when its digest is recorded, that the
+// file matches it. Items that decryption or decompression will verify are
+// not hashed twice.
+func preflightEvidence(job Job, cfg ExecConfig) error {
+	for _, ev := range job.allEvidence() {
+		if ev.Path == "" {
+			continue
+		}
+		missing := func(err error) error {
+			return &EvidenceError{UID: ev.UID, Path: ev.Path, Reason: FailureEvidenceMissing, Err: err}
+		}
+		info, err := os.Stat(ev.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return missing(fmt.Errorf("%s%s", evidenceNotFound, ev.Path))
+		case err != nil:
+			return missing(err)
+		case !info.Mode().IsRegular():
+			return missing(fmt.Errorf("%s is not a regular file", ev.Path))
+		case info.Size() == 0:
+			return missing(fmt.Errorf("%s is empty", ev.Path))
+		}
+		f, err := os.Open(ev.Path)
+		if err != nil {
+			return missing(err)
+		}
+		if ev.SHA256 == "" || ev.encrypted() || cfg.DecompressEvidence && ev.compressed() {
+			f.Close()
+			continue
+		}
+		h, err := sandbox.NewHash(ev.HashAlgo)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return missing(err)
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, ev.SHA256) {
+			return &EvidenceError{UID: ev.UID, Path: ev.Path, Reason: FailureEvidenceCorrupt,
+				Err: fmt.Errorf("%w: %s is %s, want %s", sandbox.ErrEvidenceHashMismatch, ev.Path, sum, ev.SHA256)}
+		}
+	}
+	return nil
+}
+
+// evidenceFailure returns the result of job when err is an EvidenceError:
+// a job that failed before its container was created.
+func (r *Runner) evidenceFailure(job Job, err error) (*JobResult, bool) {
+	var evErr *EvidenceError
+	if !errors.As(err, &evErr) {
+		return nil, false
+	}
+	res, rerr := r.result(job, r.execConfig(job), ContainerState{}, false, 0, "", "")
+	if rerr != nil {
+		return nil, false
+	}
+	res.Success = false
+	res.FailureReason, res.FailureDetail = evErr.Reason, evErr.Err.Error()
+	res.Attempts = 1
+	r.record(job, res)
+	return res, true
+}
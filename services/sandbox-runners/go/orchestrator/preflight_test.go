@@ -0,0 +1,88 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflightEvidence(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	sum := sha256.Sum256([]byte("MBR"))
+	digest := hex.EncodeToString(sum[:])
+	unreadable := write("locked.raw", "MBR", 0o000)
+
+	for _, tc := range []struct {
+		name   string
+		ev     Evidence
+		reason FailureReason
+		detail string
+	}{
+		{"valid", Evidence{Path: write("disk.raw", "MBR", 0o644), SHA256: digest}, "", ""},
+		{"missing", Evidence{Path: filepath.Join(dir, "gone.raw"), SHA256: digest}, FailureEvidenceMissing, "evidence not found"},
+		{"empty", Evidence{Path: write("empty.raw", "", 0o644)}, FailureEvidenceMissing, "is empty"},
+		{"directory", Evidence{Path: dir}, FailureEvidenceMissing, "not a regular file"},
+		{"unreadable", Evidence{Path: unreadable}, FailureEvidenceMissing, "permission denied"},
+		{"hash mismatch", Evidence{Path: write("tampered.raw", "MBX", 0o644), SHA256: digest}, FailureEvidenceCorrupt, "hash mismatch"},
+	} {
+		if tc.name == "unreadable" && os.Geteuid() == 0 {
+			// root reads any file.
+			continue
+		}
+		rt := &fakeRuntime{}
+		cfg := DefaultExecConfig()
+		cfg.PreflightEvidence = true
+		job := testJob(t)
+		job.Config = &cfg
+		tc.ev.UID = "ev-1"
+		job.Evidence = tc.ev
+
+		res, err := NewRunner(rt).Run(context.Background(), job)
+		if err != nil {
+			t.Errorf("%s: Run() = %v", tc.name, err)
+			continue
+		}
+		if tc.reason == "" {
+			if !res.Success || len(rt.specs) != 1 {
+				t.Errorf("%s: result = %+v", tc.name, res)
+			}
+			continue
+		}
+		if res.Success || res.FailureReason != tc.reason || !strings.Contains(res.FailureDetail, tc.detail) {
+			t.Errorf("%s: failure = %s %q, want %s %q", tc.name, res.FailureReason, res.FailureDetail, tc.reason, tc.detail)
+		}
+		if len(rt.specs) != 0 {
+			t.Errorf("%s: a container was created", tc.name)
+		}
+	}
+}
+
+func TestPoolPreflightsEvidence(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	p, err := NewPool(r, PoolConfig{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close(context.Background())
+	cfg := DefaultExecConfig()
+	cfg.PreflightEvidence = true
+	job := testJob(t)
+	job.Config = &cfg
+
+	res, err := p.Run(context.Background(), job)
+	if err != nil || res.FailureReason != FailureEvidenceMissing {
+		t.Errorf("Run() = %+v, %v, want an evidence_missing result", res, err)
+	}
+}
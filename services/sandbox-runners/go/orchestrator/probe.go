@@ -0,0 +1,132 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// probeScript checks the runner contract in an image before running the
+// toolchain command ("$@"): the container runs as the sandbox user, and
+// the workspace and output directories exist and are writable by it.
+const probeScript = `[ "$(id -un)" = sandbox ] || { echo "runs as $(id -un), not sandbox" >&2; exit 1; }
+for d in ` + containerWorkspace + ` ` + containerOutputDir + `; do
+	[ -d "$d" ] || { echo "$d does not exist" >&2; exit 1; }
+	touch "$d/.datamortem-probe" 2>/dev/null && rm -f "$d/.datamortem-probe" || { echo "$d is not writable" >&2; exit 1; }
+done
+exec "$@"`
+
+// ImageProbe is the outcome of Runner.Probe for one runner image.
+type ImageProbe struct {
+	Language string
+	// Image is the configured image and ImageDigest its ID, which the
+	// outcome is cached for.
+	Image       string
+	ImageDigest string
+	// Ready reports that the image satisfies the runner contract.
+	Ready bool
+	// Toolchain is the output of the toolchain's version command, e.g.
+	// "go version go1.21.13 linux/amd64".
+	Toolchain string
+	// Problem tells why the image is not ready.
+	Problem string
+	Checked time.Time
+}
+
+// Probe checks that the runner image of language works before jobs are
+// scheduled on it: it runs the toolchain's version command (`go version`,
+// `python --version`…) in the image, as the sandbox user and without
+// network, after checking that /workspace and /output exist and are
+// writable. The outcome is cached per image ID, so only a new image is
+// probed again; a probe that could not run, e.g. because the runtime is
+// down, is not cached. For an image that is not ready, the error wraps
+// ErrImageContract.
+func (r *Runner) Probe(ctx context.Context, language string) (*ImageProbe, error) {
+	p, err := profile(language)
+	if err != nil {
+		return nil, err
+	}
+	cfg := r.Defaults
+	image := r.image(language, p)
+	digest, err := r.pinImage(ctx, language, image, cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.probeMu.Lock()
+	probe, ok := r.probes[digest]
+	r.probeMu.Unlock()
+	if !ok {
+		if probe, err = r.probeImage(ctx, language, image, digest, p, cfg); err != nil {
+			return nil, err
+		}
+		r.probeMu.Lock()
+		if r.probes == nil {
+			r.probes = map[string]*ImageProbe{}
+		}
+		r.probes[digest] = probe
+		r.probeMu.Unlock()
+	}
+	out := *probe
+	out.Language, out.Image = languageKey(language), image
+	if !out.Ready {
+		return &out, fmt.Errorf("%w: %s", ErrImageContract, out.Problem)
+	}
+	return &out, nil
+}
+
+func (r *Runner) probeImage(ctx context.Context, language, image, digest string, p runnerProfile, cfg ExecConfig) (*ImageProbe, error) {
+	env := map[string]string{}
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	// The image's own directories are checked, so the root filesystem
+	// stays writable; the container is removed afterwards.
+	spec := ContainerSpec{
+		Image:       digest,
+		Cmd:         append([]string{"sh", "-c", probeScript, "sh"}, p.Probe...),
+		Env:         env,
+		WorkDir:     containerWorkspace,
+		User:        "sandbox",
+		Network:     string(NetworkNone),
+		Tmpfs:       []string{containerTmp},
+		MemoryBytes: cfg.memoryLimit(),
+		CPUs:        cfg.cpuQuota(),
+		Labels:      map[string]string{LabelJob: "probe"},
+	}
+	if err := applySecurity(&spec, cfg); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.runTimeout())
+	defer cancel()
+	probe := &ImageProbe{ImageDigest: digest, Checked: time.Now().UTC()}
+	id, err := r.createContainer(ctx, spec)
+	if err != nil {
+		return nil, &InfraError{Op: "create container", Err: err}
+	}
+	bg := context.WithoutCancel(ctx)
+	defer r.removeContainer(bg, id)
+	// A missing sandbox user makes the container fail to start.
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		probe.Problem = err.Error()
+		return probe, nil
+	}
+	state, err := r.Runtime.Wait(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := r.Runtime.Logs(bg, id, &out, &out); err != nil {
+		return nil, err
+	}
+	if state.ExitCode != 0 {
+		probe.Problem = fmt.Sprintf("probe exited with code %d: %s", state.ExitCode, strings.TrimSpace(out.String()))
+	} else {
+		probe.Ready, probe.Toolchain = true, strings.TrimSpace(out.String())
+	}
+	return probe, nil
+}
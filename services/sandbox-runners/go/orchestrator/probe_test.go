@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProbeCachesPerImageDigest(t *testing.T) {
+	const image = "registry.corp/sandbox-go:1.22"
+	rt := &fakeRuntime{stdout: "go version go1.22.5 linux/amd64\n"}
+	r := NewRunner(rt)
+	r.Images = map[string]string{LanguageGo: image}
+
+	probe, err := r.Probe(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !probe.Ready || probe.Language != LanguageGo || probe.Image != image || probe.ImageDigest != fakeImageID(image) || probe.Toolchain != "go version go1.22.5 linux/amd64" {
+		t.Errorf("probe = %+v", probe)
+	}
+	spec := rt.lastSpec()
+	if spec.User != "sandbox" || spec.Network != string(NetworkNone) || spec.Cmd[0] != "sh" || !strings.Contains(spec.Cmd[2], containerOutputDir) {
+		t.Errorf("probe container = %+v", spec)
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("%d containers removed", len(rt.removed))
+	}
+
+	if _, err := r.Probe(context.Background(), LanguageGo); err != nil || len(rt.specs) != 1 {
+		t.Errorf("second probe: %v, %d containers", err, len(rt.specs))
+	}
+	// The tag moved to a new image, which is probed again.
+	rt.images = map[string]ImageInfo{image: {ID: "sha256:" + strings.Repeat("d", 64)}}
+	if _, err := r.Probe(context.Background(), LanguageGo); err != nil || len(rt.specs) != 2 {
+		t.Errorf("new image: %v, %d containers", err, len(rt.specs))
+	}
+}
+
+func TestProbeReportsBrokenImage(t *testing.T) {
+	rt := &fakeRuntime{createErrs: []error{errors.New("daemon unavailable")}}
+	r := NewRunner(rt)
+	if _, err := r.Probe(context.Background(), LanguagePython); !Retryable(err) {
+		t.Fatalf("err = %v, want an infrastructure error", err)
+	}
+
+	rt.state = ContainerState{ExitCode: 1}
+	rt.stderr = "/output is not writable\n"
+	probe, err := r.Probe(context.Background(), LanguagePython)
+	if !errors.Is(err, ErrImageContract) || probe == nil || probe.Ready || !strings.Contains(probe.Problem, "/output is not writable") {
+		t.Fatalf("probe = %+v, err = %v", probe, err)
+	}
+	// Failed probes are cached as well: the image stays broken.
+	if _, err := r.Probe(context.Background(), LanguagePython); !errors.Is(err, ErrImageContract) || len(rt.specs) != 1 {
+		t.Errorf("cached: %v, %d containers", err, len(rt.specs))
+	}
+	if _, err := r.Probe(context.Background(), "cobol"); err == nil {
+		t.Error("probed an unsupported language")
+	}
+}
@@ -0,0 +1,92 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// progressPollInterval is how often progress.ndjson is re-read.
+var progressPollInterval = 500 * time.Millisecond
+
+// Progress follows the progress records the script writes with
+// sandbox.Progress. The channel is closed once the container has exited
+// and the file has been read to the end, or when ctx is done.
+func (e *Execution) Progress(ctx context.Context) <-chan sandbox.ProgressRecord {
+	ch := make(chan sandbox.ProgressRecord, 16)
+	go func() {
+		defer close(ch)
+		t := &ndjsonTail{path: filepath.Join(e.job.OutputDir, sandbox.ProgressFile)}
+		ticker := time.NewTicker(progressPollInterval)
+		defer ticker.Stop()
+		for {
+			exited := false
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.exited:
+				exited = true
+			case <-ticker.C:
+			}
+			for _, line := range t.poll() {
+				if _, ok := sandbox.ParseHeader(line); ok {
+					continue
+				}
+				var rec sandbox.ProgressRecord
+				if json.Unmarshal(line, &rec) != nil {
+					continue
+				}
+				select {
+				case ch <- rec:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if exited {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// ndjsonTail reads complete lines appended to a file since the last poll.
+type ndjsonTail struct {
+	path    string
+	offset  int64
+	partial []byte
+}
+
+func (t *ndjsonTail) poll() [][]byte {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(f)
+	t.offset += int64(len(data))
+	data = append(t.partial, data...)
+
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		t.partial = data
+		return nil
+	}
+	t.partial = append([]byte(nil), data[end+1:]...)
+	var lines [][]byte
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
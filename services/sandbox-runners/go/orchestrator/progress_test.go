@@ -0,0 +1,68 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestExecutionProgress(t *testing.T) {
+	progressPollInterval = 5 * time.Millisecond
+	defer func() { progressPollInterval = 500 * time.Millisecond }()
+
+	job := testJob(t)
+	path := filepath.Join(job.OutputDir, sandbox.ProgressFile)
+	rt := &fakeRuntime{
+		onStart: func(ContainerSpec) {
+			// The last record is still being written when the job ends.
+			os.WriteFile(path, []byte(
+				`{"fraction":0,"message":"start"}`+"\n"+
+					`{"fraction":0.5}`+"\n"+
+					`{"fraction":1,"mess`), 0644)
+		},
+	}
+	r := NewRunner(rt)
+	ctx := context.Background()
+	exec, err := r.Start(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := exec.Progress(ctx)
+	if _, err := exec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float64
+	for rec := range updates {
+		got = append(got, rec.Fraction)
+	}
+	if len(got) != 2 || got[0] != 0 || got[1] != 0.5 {
+		t.Errorf("fractions = %v, want [0 0.5]", got)
+	}
+}
+
+func TestNDJSONTailHoldsPartialLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.ndjson")
+	tail := &ndjsonTail{path: path}
+	if lines := tail.poll(); lines != nil {
+		t.Fatalf("missing file returned %q", lines)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString(`{"a":1}` + "\n" + `{"a":`)
+	if lines := tail.poll(); len(lines) != 1 || string(lines[0]) != `{"a":1}` {
+		t.Fatalf("first poll = %q", lines)
+	}
+	f.WriteString("2}\n")
+	if lines := tail.poll(); len(lines) != 1 || string(lines[0]) != `{"a":2}` {
+		t.Fatalf("second poll = %q", lines)
+	}
+}
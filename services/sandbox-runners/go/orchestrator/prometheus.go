@@ -0,0 +1,226 @@
+package orchestrator
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Histogram buckets of PrometheusMetrics.
+var (
+	durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+	memoryBuckets   = []float64{64 << 20, 128 << 20, 256 << 20, 512 << 20, 1 << 30, 2 << 30, 4 << 30}
+)
+
+// Job outcomes counted by PrometheusMetrics.
+const (
+	outcomeSuccess       = "success"
+	outcomeFailure       = "failure"
+	outcomeTimeout       = "timeout"
+	outcomeOOM           = "oom"
+	outcomeCancelled     = "cancelled"
+	outcomeNotApplicable = "not_applicable"
+)
+
+// PrometheusMetrics aggregates job metrics per language and serves them in
+// the Prometheus text format. Set it as Runner.MetricsRecorder and mount
+// it on the /metrics endpoint of the worker.
+type PrometheusMetrics struct {
+	mu        sync.Mutex
+	languages map[string]*languageMetrics
+	queue     *WorkerPool
+	runner    *Runner
+}
+
+type languageMetrics struct {
+	outcomes     map[string]int64
+	duration     histogram
+	memory       histogram
+	cpuSeconds   float64
+	evidenceRead int64
+	outputBytes  int64
+}
+
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(buckets))
+	}
+	for i, le := range buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewPrometheusMetrics returns an empty PrometheusMetrics.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{languages: map[string]*languageMetrics{}}
+}
+
+// TrackQueue adds the queue depth, active jobs, wait times and
+// preemptions of w to the metrics.
+func (p *PrometheusMetrics) TrackQueue(w *WorkerPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = w
+}
+
+// TrackLeaks adds the resources reclaimed by the Reconcile passes of r to
+// the metrics.
+func (p *PrometheusMetrics) TrackLeaks(r *Runner) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runner = r
+}
+
+// RecordJob implements MetricsRecorder.
+func (p *PrometheusMetrics) RecordJob(job Job, res *JobResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lang := languageKey(job.Language)
+	lm, ok := p.languages[lang]
+	if !ok {
+		lm = &languageMetrics{outcomes: map[string]int64{}}
+		p.languages[lang] = lm
+	}
+	lm.outcomes[outcome(res)]++
+	m := res.Metrics
+	lm.duration.observe(durationBuckets, m.Duration.Seconds())
+	if m.PeakMemoryBytes > 0 {
+		lm.memory.observe(memoryBuckets, float64(m.PeakMemoryBytes))
+	}
+	lm.cpuSeconds += m.CPUTime.Seconds()
+	lm.evidenceRead += m.EvidenceBytesRead
+	lm.outputBytes += m.OutputBytes
+}
+
+func outcome(res *JobResult) string {
+	switch {
+	case res.Cancelled:
+		return outcomeCancelled
+	case res.NotApplicable:
+		return outcomeNotApplicable
+	case res.TimedOut || res.BuildTimedOut:
+		return outcomeTimeout
+	case res.OOMKilled:
+		return outcomeOOM
+	case res.Success:
+		return outcomeSuccess
+	default:
+		return outcomeFailure
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	p.write(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (p *PrometheusMetrics) write(buf *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	langs := make([]string, 0, len(p.languages))
+	for lang := range p.languages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+
+	header := func(name, typ, help string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	header("datamortem_sandbox_jobs_total", "counter", "Jobs finished, by language and outcome.")
+	for _, lang := range langs {
+		lm := p.languages[lang]
+		outcomes := make([]string, 0, len(lm.outcomes))
+		for o := range lm.outcomes {
+			outcomes = append(outcomes, o)
+		}
+		sort.Strings(outcomes)
+		for _, o := range outcomes {
+			fmt.Fprintf(buf, "datamortem_sandbox_jobs_total{language=%q,outcome=%q} %d\n", lang, o, lm.outcomes[o])
+		}
+	}
+	header("datamortem_sandbox_job_duration_seconds", "histogram", "Wall-clock duration of jobs.")
+	for _, lang := range langs {
+		writeHistogram(buf, "datamortem_sandbox_job_duration_seconds", lang, durationBuckets, p.languages[lang].duration)
+	}
+	header("datamortem_sandbox_job_peak_memory_bytes", "histogram", "Peak memory usage of jobs.")
+	for _, lang := range langs {
+		writeHistogram(buf, "datamortem_sandbox_job_peak_memory_bytes", lang, memoryBuckets, p.languages[lang].memory)
+	}
+	counters := []struct {
+		name, help string
+		value      func(*languageMetrics) string
+	}{
+		{"datamortem_sandbox_job_cpu_seconds_total", "CPU time used by jobs.",
+			func(lm *languageMetrics) string { return formatFloat(lm.cpuSeconds) }},
+		{"datamortem_sandbox_evidence_read_bytes_total", "Bytes read from evidence devices by jobs.",
+			func(lm *languageMetrics) string { return strconv.FormatInt(lm.evidenceRead, 10) }},
+		{"datamortem_sandbox_output_bytes_total", "Bytes of outputs collected from jobs.",
+			func(lm *languageMetrics) string { return strconv.FormatInt(lm.outputBytes, 10) }},
+	}
+	for _, c := range counters {
+		header(c.name, "counter", c.help)
+		for _, lang := range langs {
+			fmt.Fprintf(buf, "%s{language=%q} %s\n", c.name, lang, c.value(p.languages[lang]))
+		}
+	}
+	if p.queue != nil {
+		m := p.queue.Metrics()
+		header("datamortem_sandbox_queue_depth", "gauge", "Jobs waiting for a worker.")
+		fmt.Fprintf(buf, "datamortem_sandbox_queue_depth %d\n", m.Queued)
+		header("datamortem_sandbox_active_jobs", "gauge", "Jobs running in the worker pool.")
+		fmt.Fprintf(buf, "datamortem_sandbox_active_jobs %d\n", m.Active)
+		header("datamortem_sandbox_queue_rejected_total", "counter", "Jobs refused because the queue was full.")
+		fmt.Fprintf(buf, "datamortem_sandbox_queue_rejected_total %d\n", m.Rejected)
+		priorities := m.sortedPriorities()
+		header("datamortem_sandbox_queue_wait_seconds", "summary", "Time jobs waited for a worker, by priority.")
+		for _, pr := range priorities {
+			pm := m.Priorities[pr]
+			fmt.Fprintf(buf, "datamortem_sandbox_queue_wait_seconds_sum{priority=\"%d\"} %s\n", pr, formatFloat(pm.TotalWait.Seconds()))
+			fmt.Fprintf(buf, "datamortem_sandbox_queue_wait_seconds_count{priority=\"%d\"} %d\n", pr, pm.Started)
+		}
+		header("datamortem_sandbox_preemptions_total", "counter", "Jobs cancelled for a job of higher priority, by priority.")
+		for _, pr := range priorities {
+			fmt.Fprintf(buf, "datamortem_sandbox_preemptions_total{priority=\"%d\"} %d\n", pr, m.Priorities[pr].Preempted)
+		}
+	}
+	if p.runner != nil {
+		leaks := p.runner.Leaks()
+		header("datamortem_sandbox_leaked_resources_total", "counter", "Leaked resources reclaimed by Reconcile, by kind.")
+		for _, kind := range []string{LeakedContainer, LeakedScratchDir} {
+			fmt.Fprintf(buf, "datamortem_sandbox_leaked_resources_total{kind=%q} %d\n", kind, leaks[kind])
+		}
+	}
+}
+
+func writeHistogram(buf *bytes.Buffer, name, lang string, buckets []float64, h histogram) {
+	for i, le := range buckets {
+		var n int64
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+		fmt.Fprintf(buf, "%s_bucket{language=%q,le=%q} %d\n", name, lang, formatFloat(le), n)
+	}
+	fmt.Fprintf(buf, "%s_bucket{language=%q,le=\"+Inf\"} %d\n", name, lang, h.count)
+	fmt.Fprintf(buf, "%s_sum{language=%q} %s\n", name, lang, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count{language=%q} %d\n", name, lang, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,231 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// FindingSource holds the findings of the earlier jobs of each case, which
+// the scripts of ExecConfig.AllowFindingQuery query with
+// sandbox.QueryFindings.
+type FindingSource interface {
+	// CaseFindings returns the findings of caseID in order of first
+	// report, e.g. CaseFindings.Findings of the case.
+	CaseFindings(ctx context.Context, caseID string) ([]Finding, error)
+}
+
+// FindingSourceFunc adapts a function to a FindingSource.
+type FindingSourceFunc func(ctx context.Context, caseID string) ([]Finding, error)
+
+// CaseFindings calls f.
+func (f FindingSourceFunc) CaseFindings(ctx context.Context, caseID string) ([]Finding, error) {
+	return f(ctx, caseID)
+}
+
+// maxFindingPageBytes caps the encoded findings of a query page: a page
+// past it is cut short, the script resuming after its last finding. A
+// single finding larger than that is still returned, on a page of its own.
+const maxFindingPageBytes = 1 << 20
+
+// Files of a job's query directory on the host.
+const (
+	queryDirPrefix = "datamortem-query-"
+	querySocket    = "query.sock"
+)
+
+// queryServer answers the sandbox.QueryFindings requests of one job on a
+// unix socket mounted in its container, from the findings of the job's
+// case only.
+type queryServer struct {
+	runner *Runner
+	source FindingSource
+	job    Job
+	dir    string
+	ln     net.Listener
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	queries int
+}
+
+// startQueryServer listens for the queries of job in a fresh directory
+// under Runner.WorkDir. Lookups run under ctx.
+func (r *Runner) startQueryServer(ctx context.Context, job Job) (*queryServer, error) {
+	dir, err := r.scratchDir(queryDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s := &queryServer{runner: r, source: r.FindingSource, job: job, dir: dir}
+	socket := filepath.Join(dir, querySocket)
+	err = os.Chmod(dir, 0o755)
+	if err == nil {
+		s.ln, err = net.Listen("unix", socket)
+	}
+	if err == nil {
+		// The script connects as the sandbox user.
+		err = os.Chmod(socket, 0o777)
+	}
+	if err != nil {
+		if s.ln != nil {
+			s.ln.Close()
+		}
+		r.removeDir(dir)
+		return nil, fmt.Errorf("finding query: %w", err)
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// apply mounts the socket into spec.
+func (s *queryServer) apply(spec *ContainerSpec) {
+	spec.Mounts = append(spec.Mounts, Mount{Source: s.dir, Target: containerQueryDir, ReadOnly: true})
+	spec.Env[sandbox.EnvFindingQuerySocket] = path.Join(containerQueryDir, querySocket)
+	if spec.SeccompProfile == defaultSeccompProfile(false) {
+		// The built-in profile of a job without network blocks sockets.
+		spec.SeccompProfile = unixSocketSeccompProfile()
+	}
+}
+
+func (s *queryServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle answers the queries of one connection, a line each.
+func (s *queryServer) handle(conn net.Conn) {
+	defer conn.Close()
+	go func() {
+		<-s.ctx.Done()
+		conn.Close()
+	}()
+	sc := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		var filter sandbox.FindingFilter
+		var resp sandbox.FindingQueryResponse
+		if err := json.Unmarshal(sc.Bytes(), &filter); err != nil {
+			resp.Error = "malformed query: " + err.Error()
+		} else {
+			resp = s.query(filter)
+		}
+		if enc.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+// query returns the page of the findings of the job's case that filter
+// selects.
+func (s *queryServer) query(filter sandbox.FindingFilter) sandbox.FindingQueryResponse {
+	if filter.CaseID != "" && filter.CaseID != s.job.CaseID {
+		return sandbox.FindingQueryResponse{Error: "findings belong to another case", Denied: true}
+	}
+	if filter.MinSeverity != "" && !filter.MinSeverity.Valid() {
+		return sandbox.FindingQueryResponse{Error: fmt.Sprintf("invalid severity %q", filter.MinSeverity)}
+	}
+	start := 0
+	if filter.Cursor != "" {
+		n, err := strconv.Atoi(filter.Cursor)
+		if err != nil || n < 0 {
+			return sandbox.FindingQueryResponse{Error: fmt.Sprintf("invalid cursor %q", filter.Cursor)}
+		}
+		start = n
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > sandbox.MaxFindingPage {
+		limit = sandbox.MaxFindingPage
+	}
+	findings, err := s.source.CaseFindings(s.ctx, s.job.CaseID)
+	if err != nil {
+		return sandbox.FindingQueryResponse{Error: err.Error()}
+	}
+	s.mu.Lock()
+	s.queries++
+	s.mu.Unlock()
+	var resp sandbox.FindingQueryResponse
+	size := 0
+	for i := start; i < len(findings) && len(resp.Findings) < limit; i++ {
+		f := findings[i]
+		if !matchFindingFilter(f, filter) {
+			continue
+		}
+		out := sandbox.Finding{
+			Result:       f.Result,
+			JobIDs:       f.JobIDs,
+			EvidenceUIDs: f.EvidenceUIDs,
+			Count:        f.Count,
+			Cursor:       strconv.Itoa(i + 1),
+		}
+		if f.Module.Name != "" {
+			out.Module = f.Module.String()
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return sandbox.FindingQueryResponse{Error: err.Error()}
+		}
+		if size += len(data); size > maxFindingPageBytes && len(resp.Findings) > 0 {
+			break
+		}
+		resp.Findings = append(resp.Findings, out)
+	}
+	return resp
+}
+
+// matchFindingFilter reports whether filter selects f.
+func matchFindingFilter(f Finding, filter sandbox.FindingFilter) bool {
+	if k := filter.Kind; k != "" && f.FindingKey != k && !strings.HasPrefix(f.FindingKey, strings.TrimSuffix(k, "/")+"/") {
+		return false
+	}
+	if filter.MinSeverity != "" && f.Severity.Rank() < filter.MinSeverity.Rank() {
+		return false
+	}
+	if uid := filter.EvidenceUID; uid != "" && f.EvidenceUID != uid && !slices.Contains(f.EvidenceUIDs, uid) {
+		return false
+	}
+	return true
+}
+
+// Close stops the server and waits for pending queries. It returns the
+// number of queries answered and may be called again.
+func (s *queryServer) Close() int {
+	if s == nil {
+		return 0
+	}
+	s.closeOnce.Do(func() {
+		s.ln.Close()
+		s.cancel()
+		s.wg.Wait()
+		s.runner.removeDir(s.dir)
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
@@ -0,0 +1,150 @@
+package orchestrator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// queryFrom sends a sandbox.QueryFindings request on the socket under dir.
+func queryFrom(t *testing.T, dir string, filter sandbox.FindingFilter) sandbox.FindingQueryResponse {
+	t.Helper()
+	conn, err := net.Dial("unix", filepath.Join(dir, querySocket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	json.NewEncoder(conn).Encode(filter)
+	var resp sandbox.FindingQueryResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRunServesFindingQueries(t *testing.T) {
+	prior := NewCaseFindings("case-1")
+	prior.Add(Job{ID: "job-0", CaseID: "case-1"}, &JobResult{
+		Module: ScriptModule{Name: "hash-triage", Version: "1.2.0"},
+		Findings: []sandbox.Result{
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityHigh, Title: "Known bad hash", FindingKey: "ioc/sha256/aa"},
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityLow, Title: "Rare hash", FindingKey: "ioc/sha256/bb"},
+			{EvidenceUID: "ev-2", Severity: sandbox.SeverityCritical, Title: "Mimikatz", FindingKey: "yara/mimikatz"},
+			{EvidenceUID: "ev-2", Severity: sandbox.SeverityHigh, Title: "Implant hash", FindingKey: "ioc/sha256/cc"},
+		},
+	})
+	var cases []string
+	source := FindingSourceFunc(func(ctx context.Context, caseID string) ([]Finding, error) {
+		cases = append(cases, caseID)
+		return prior.Findings(), nil
+	})
+
+	var pages [][]sandbox.Finding
+	responses := map[string]sandbox.FindingQueryResponse{}
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		if spec.Env[sandbox.EnvFindingQuerySocket] != "/run/datamortem-query/query.sock" {
+			t.Errorf("%s = %q", sandbox.EnvFindingQuerySocket, spec.Env[sandbox.EnvFindingQuerySocket])
+		}
+		if spec.SeccompProfile != unixSocketSeccompProfile() {
+			t.Error("seccomp profile does not allow unix sockets")
+		}
+		var dir string
+		for _, m := range spec.Mounts {
+			if m.Target == containerQueryDir {
+				dir = m.Source
+				if !m.ReadOnly {
+					t.Errorf("mount %s is writable", m.Target)
+				}
+			}
+		}
+		// "Did anyone flag this hash?", a page at a time.
+		filter := sandbox.FindingFilter{Kind: "ioc/sha256/", MinSeverity: sandbox.SeverityMedium, Limit: 1}
+		for {
+			resp := queryFrom(t, dir, filter)
+			if resp.Error != "" || len(resp.Findings) == 0 {
+				break
+			}
+			pages = append(pages, resp.Findings)
+			filter.Cursor = resp.Findings[len(resp.Findings)-1].Cursor
+		}
+		responses["ev-2"] = queryFrom(t, dir, sandbox.FindingFilter{EvidenceUID: "ev-2", Limit: 1000})
+		responses["own case"] = queryFrom(t, dir, sandbox.FindingFilter{CaseID: "case-1", Kind: "yara/mimikatz"})
+		responses["other case"] = queryFrom(t, dir, sandbox.FindingFilter{CaseID: "case-2"})
+		responses["bad cursor"] = queryFrom(t, dir, sandbox.FindingFilter{Cursor: "next"})
+	}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.FindingSource = source
+	cfg := DefaultExecConfig()
+	cfg.AllowFindingQuery = true
+	job := testJob(t)
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[0][0].FindingKey != "ioc/sha256/aa" || pages[1][0].FindingKey != "ioc/sha256/cc" {
+		t.Fatalf("pages = %+v", pages)
+	}
+	if f := pages[0][0]; f.Module != "hash-triage v1.2.0" || f.Count != 1 || f.JobIDs[0] != "job-0" {
+		t.Errorf("finding = %+v", f)
+	}
+	if resp := responses["ev-2"]; len(resp.Findings) != 2 {
+		t.Errorf("findings of ev-2 = %+v", resp)
+	}
+	if resp := responses["own case"]; len(resp.Findings) != 1 || resp.Findings[0].Title != "Mimikatz" {
+		t.Errorf("query of the own case = %+v", resp)
+	}
+	if resp := responses["other case"]; !resp.Denied || len(resp.Findings) != 0 {
+		t.Errorf("cross-case query = %+v, want denied", resp)
+	}
+	if resp := responses["bad cursor"]; resp.Denied || resp.Error == "" {
+		t.Errorf("bad cursor = %+v, want an error", resp)
+	}
+	for _, c := range cases {
+		if c != "case-1" {
+			t.Errorf("source queried for %s", c)
+		}
+	}
+	if res.FindingQueries != len(cases) || len(cases) != 5 {
+		t.Errorf("%d queries recorded, %d made", res.FindingQueries, len(cases))
+	}
+}
+
+func TestFindingQueryPageCap(t *testing.T) {
+	var findings []Finding
+	for i := 0; i < sandbox.MaxFindingPage+5; i++ {
+		findings = append(findings, Finding{Result: sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityInfo, Title: "Hit"}})
+	}
+	// Two findings that fill most of a page's bytes each.
+	huge := strings.Repeat("x", maxFindingPageBytes*2/3)
+	findings = append(findings,
+		Finding{Result: sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityInfo, Title: "Big", Description: huge}},
+		Finding{Result: sandbox.Result{EvidenceUID: "ev-1", Severity: sandbox.SeverityInfo, Title: "Big", Description: huge}},
+	)
+	s := &queryServer{ctx: context.Background(), job: testJob(t),
+		source: FindingSourceFunc(func(context.Context, string) ([]Finding, error) { return findings, nil })}
+	if resp := s.query(sandbox.FindingFilter{Limit: 1000}); len(resp.Findings) != sandbox.MaxFindingPage {
+		t.Errorf("%d findings on a page, want %d", len(resp.Findings), sandbox.MaxFindingPage)
+	}
+	// The big findings do not fit on one page together.
+	cursor := strconv.Itoa(sandbox.MaxFindingPage + 5)
+	for _, want := range []int{1, 1, 0} {
+		resp := s.query(sandbox.FindingFilter{Cursor: cursor})
+		if len(resp.Findings) != want {
+			t.Fatalf("cursor %s: %d findings, want %d", cursor, len(resp.Findings), want)
+		}
+		if want > 0 {
+			cursor = resp.Findings[0].Cursor
+		}
+	}
+}
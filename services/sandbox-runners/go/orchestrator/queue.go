@@ -0,0 +1,415 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by WorkerPool.Submit when MaxConcurrent jobs
+// are running and QueueDepth more are waiting.
+var ErrQueueFull = errors.New("orchestrator: job queue is full")
+
+// ErrWorkerPoolClosed is returned for jobs submitted to, or still queued
+// in, a closed WorkerPool.
+var ErrWorkerPoolClosed = errors.New("orchestrator: worker pool is closed")
+
+// JobRunner runs a job to completion; Runner and Pool implement it.
+type JobRunner interface {
+	Run(ctx context.Context, job Job) (*JobResult, error)
+}
+
+var (
+	_ JobRunner = (*Runner)(nil)
+	_ JobRunner = (*Pool)(nil)
+	_ JobRunner = (*WorkerPool)(nil)
+	_ JobRunner = (*Scheduler)(nil)
+	_ JobRunner = (*EngineMonitor)(nil)
+)
+
+// WorkerPoolConfig bounds a WorkerPool.
+type WorkerPoolConfig struct {
+	// MaxConcurrent is the number of jobs run at once.
+	MaxConcurrent int
+	// QueueDepth is the number of jobs that may wait for a free worker;
+	// zero rejects jobs while every worker is busy.
+	QueueDepth int
+	// Capacity is the most a worker of the pool offers a job, e.g. the
+	// memory of its host; a zero field is unlimited. A job whose script
+	// requires more is rejected with ErrUnsatisfiableRequirements.
+	Capacity Requirements
+}
+
+// WorkerPoolMetrics describes the load of a WorkerPool.
+type WorkerPoolMetrics struct {
+	Queued int
+	Active int
+	// Completed counts the jobs run, Rejected those refused with
+	// ErrQueueFull and Preempted those cancelled for a job of higher
+	// priority.
+	Completed uint64
+	Rejected  uint64
+	Preempted uint64
+	// Priorities breaks the started jobs down by Job.Priority.
+	Priorities map[int]PriorityMetrics
+}
+
+// PriorityMetrics describes the jobs of one priority of a WorkerPool.
+type PriorityMetrics struct {
+	// Started counts the jobs that got a worker and Preempted those of
+	// them that were cancelled for a job of higher priority.
+	Started   uint64
+	Preempted uint64
+	// TotalWait is the time the started jobs spent queued, MaxWait the
+	// longest any of them waited.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// WorkerPool limits how many jobs run at once. Jobs beyond MaxConcurrent
+// wait in a queue ordered by Job.Priority, higher first, then by
+// submission.
+//
+// A job submitted while every worker is busy preempts a running job of
+// lower priority that is Preemptible, the lowest priority first and the
+// latest started among equals: that job is cancelled, ending Cancelled
+// with PreemptedBy set, and the new one takes its worker, even when the
+// queue is full. Its checkpoint is kept in Runner.Checkpoints, if any, so
+// that submitting it again resumes it.
+type WorkerPool struct {
+	runner JobRunner
+	cfg    WorkerPoolConfig
+
+	mu         sync.Mutex
+	queue      jobQueue
+	running    map[*QueuedJob]bool
+	seq        uint64
+	active     int
+	closed     bool
+	completed  uint64
+	rejected   uint64
+	preempted  uint64
+	priorities map[int]*PriorityMetrics
+	wg         sync.WaitGroup
+}
+
+// QueuedJob is a job submitted to a WorkerPool.
+type QueuedJob struct {
+	ctx       context.Context
+	job       Job
+	seq       uint64
+	index     int
+	submitted time.Time
+	// started is when the job got a worker and cancel cancels it from
+	// then on; preemptedBy is the job it was cancelled for.
+	started     time.Time
+	cancel      context.CancelFunc
+	preemptedBy string
+	done        chan struct{}
+	res         *JobResult
+	err         error
+}
+
+// Done is closed once the job has finished or left the queue.
+func (q *QueuedJob) Done() <-chan struct{} { return q.done }
+
+// Wait blocks until the job has finished and returns its result.
+func (q *QueuedJob) Wait() (*JobResult, error) {
+	<-q.done
+	return q.res, q.err
+}
+
+// NewWorkerPool returns a WorkerPool running jobs through r.
+func NewWorkerPool(r JobRunner, cfg WorkerPoolConfig) (*WorkerPool, error) {
+	if cfg.MaxConcurrent <= 0 {
+		return nil, errors.New("orchestrator: MaxConcurrent must be positive")
+	}
+	if cfg.QueueDepth < 0 {
+		return nil, errors.New("orchestrator: QueueDepth must not be negative")
+	}
+	return &WorkerPool{runner: r, cfg: cfg, running: map[*QueuedJob]bool{}, priorities: map[int]*PriorityMetrics{}}, nil
+}
+
+// Submit starts job, or queues it while every worker is busy. ctx covers
+// the job from submission: cancelling it removes a queued job, which ends
+// with ctx's error, or cancels a running one.
+func (w *WorkerPool) Submit(ctx context.Context, job Job) (*QueuedJob, error) {
+	if err := w.admit(job); err != nil {
+		return nil, err
+	}
+	q := &QueuedJob{ctx: ctx, job: job, submitted: time.Now(), done: make(chan struct{})}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrWorkerPoolClosed
+	}
+	if w.active < w.cfg.MaxConcurrent {
+		w.active++
+		w.wg.Add(1)
+		go w.work(q)
+		return q, nil
+	}
+	victim := w.victim(job)
+	if victim == nil && w.queue.Len() >= w.cfg.QueueDepth {
+		w.rejected++
+		return nil, ErrQueueFull
+	}
+	if victim != nil {
+		victim.preemptedBy = job.ID
+		victim.cancel()
+	}
+	w.seq++
+	q.seq = w.seq
+	heap.Push(&w.queue, q)
+	go w.dropOnCancel(q)
+	return q, nil
+}
+
+// victim returns the running job to preempt for job, or nil: the
+// Preemptible one of lowest priority below job's, the latest started
+// among equals, that is not already being preempted.
+func (w *WorkerPool) victim(job Job) *QueuedJob {
+	var victim *QueuedJob
+	for q := range w.running {
+		if !q.job.Preemptible || q.preemptedBy != "" || q.job.Priority >= job.Priority {
+			continue
+		}
+		if victim == nil || q.job.Priority < victim.job.Priority ||
+			q.job.Priority == victim.job.Priority && q.started.After(victim.started) {
+			victim = q
+		}
+	}
+	return victim
+}
+
+// admit checks the requirements of job's script against the pool's
+// capacity.
+func (w *WorkerPool) admit(job Job) error {
+	if w.cfg.Capacity == (Requirements{}) || job.Workspace == "" {
+		return nil
+	}
+	req, err := ReadRequirements(job.Workspace)
+	if err != nil {
+		return err
+	}
+	if over := req.unsatisfied(w.cfg.Capacity); over != "" {
+		return fmt.Errorf("%w: job %s requires %s", ErrUnsatisfiableRequirements, job.ID, over)
+	}
+	return nil
+}
+
+// Run submits job and waits for its result.
+func (w *WorkerPool) Run(ctx context.Context, job Job) (*JobResult, error) {
+	q, err := w.Submit(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return q.Wait()
+}
+
+// Metrics returns the pool's load.
+func (w *WorkerPool) Metrics() WorkerPoolMetrics {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := WorkerPoolMetrics{
+		Queued:     w.queue.Len(),
+		Active:     w.active,
+		Completed:  w.completed,
+		Rejected:   w.rejected,
+		Preempted:  w.preempted,
+		Priorities: make(map[int]PriorityMetrics, len(w.priorities)),
+	}
+	for p, pm := range w.priorities {
+		m.Priorities[p] = *pm
+	}
+	return m
+}
+
+// priorityMetrics returns the metrics of priority, under w.mu.
+func (w *WorkerPool) priorityMetrics(priority int) *PriorityMetrics {
+	pm := w.priorities[priority]
+	if pm == nil {
+		pm = &PriorityMetrics{}
+		w.priorities[priority] = pm
+	}
+	return pm
+}
+
+// sortedPriorities returns the priorities of m, highest first.
+func (m WorkerPoolMetrics) sortedPriorities() []int {
+	ps := make([]int, 0, len(m.Priorities))
+	for p := range m.Priorities {
+		ps = append(ps, p)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ps)))
+	return ps
+}
+
+// Close rejects new jobs, ends the queued ones with ErrWorkerPoolClosed
+// and waits for the running ones.
+func (w *WorkerPool) Close() {
+	w.mu.Lock()
+	w.closed = true
+	queued := w.queue
+	w.queue = nil
+	w.mu.Unlock()
+	for _, q := range queued {
+		q.err = ErrWorkerPoolClosed
+		close(q.done)
+	}
+	w.wg.Wait()
+}
+
+// work runs q, then the queued jobs, until the queue is empty.
+func (w *WorkerPool) work(q *QueuedJob) {
+	defer w.wg.Done()
+	for q != nil {
+		ctx, cancel := context.WithCancel(q.ctx)
+		w.mu.Lock()
+		q.started, q.cancel = time.Now(), cancel
+		w.running[q] = true
+		pm := w.priorityMetrics(q.job.Priority)
+		wait := q.started.Sub(q.submitted)
+		pm.Started++
+		pm.TotalWait += wait
+		pm.MaxWait = max(pm.MaxWait, wait)
+		w.mu.Unlock()
+
+		q.res, q.err = w.runner.Run(withQueued(ctx, q.submitted), q.job)
+		cancel()
+
+		w.mu.Lock()
+		delete(w.running, q)
+		// A job that finished before its cancellation took effect was
+		// not preempted.
+		if q.preemptedBy != "" && q.res != nil && q.res.Cancelled && q.ctx.Err() == nil {
+			q.res.PreemptedBy = q.preemptedBy
+			q.res.FailureDetail = "preempted by job " + q.preemptedBy
+			w.preempted++
+			w.priorityMetrics(q.job.Priority).Preempted++
+		}
+		w.mu.Unlock()
+		close(q.done)
+
+		w.mu.Lock()
+		w.completed++
+		q = nil
+		if w.queue.Len() > 0 {
+			q = heap.Pop(&w.queue).(*QueuedJob)
+		} else {
+			w.active--
+		}
+		w.mu.Unlock()
+	}
+}
+
+// dropOnCancel removes q from the queue if its context is done first.
+func (w *WorkerPool) dropOnCancel(q *QueuedJob) {
+	select {
+	case <-q.done:
+		return
+	case <-q.ctx.Done():
+	}
+	w.mu.Lock()
+	queued := q.index >= 0 && q.index < w.queue.Len() && w.queue[q.index] == q
+	if queued {
+		heap.Remove(&w.queue, q.index)
+	}
+	w.mu.Unlock()
+	if queued {
+		q.err = q.ctx.Err()
+		close(q.done)
+	}
+}
+
+// Scheduler places each job on the first of several WorkerPools, e.g. a
+// pool of small workers followed by one of large workers, whose capacity
+// covers the requirements of the job's script, moving on to the next one
+// when that pool's queue is full.
+type Scheduler struct {
+	pools []*WorkerPool
+}
+
+// NewScheduler returns a Scheduler over pools, tried in order.
+func NewScheduler(pools ...*WorkerPool) (*Scheduler, error) {
+	if len(pools) == 0 {
+		return nil, errors.New("orchestrator: a scheduler needs a worker pool")
+	}
+	return &Scheduler{pools: pools}, nil
+}
+
+// Submit submits job to the first pool that can run it. A job that no
+// pool can satisfy is rejected with ErrUnsatisfiableRequirements, naming
+// the requirements beyond the capacity of the last pool.
+func (s *Scheduler) Submit(ctx context.Context, job Job) (*QueuedJob, error) {
+	var req Requirements
+	if job.Workspace != "" {
+		var err error
+		if req, err = ReadRequirements(job.Workspace); err != nil {
+			return nil, err
+		}
+	}
+	var full error
+	for _, p := range s.pools {
+		if req.unsatisfied(p.cfg.Capacity) != "" {
+			continue
+		}
+		q, err := p.Submit(ctx, job)
+		if errors.Is(err, ErrQueueFull) {
+			full = err
+			continue
+		}
+		return q, err
+	}
+	if full != nil {
+		return nil, full
+	}
+	over := req.unsatisfied(s.pools[len(s.pools)-1].cfg.Capacity)
+	return nil, fmt.Errorf("%w: job %s requires %s", ErrUnsatisfiableRequirements, job.ID, over)
+}
+
+// Run submits job and waits for its result.
+func (s *Scheduler) Run(ctx context.Context, job Job) (*JobResult, error) {
+	q, err := s.Submit(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	return q.Wait()
+}
+
+// jobQueue is a heap of queued jobs, highest priority first, then oldest.
+type jobQueue []*QueuedJob
+
+func (h jobQueue) Len() int { return len(h) }
+
+func (h jobQueue) Less(i, j int) bool {
+	if h[i].job.Priority != h[j].job.Priority {
+		return h[i].job.Priority > h[j].job.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobQueue) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobQueue) Push(x any) {
+	q := x.(*QueuedJob)
+	q.index = len(*h)
+	*h = append(*h, q)
+}
+
+func (h *jobQueue) Pop() any {
+	old := *h
+	q := old[len(old)-1]
+	old[len(old)-1] = nil
+	q.index = -1
+	*h = old[:len(old)-1]
+	return q
+}
@@ -0,0 +1,226 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gatedRunner holds each job until release is called and records the
+// order in which jobs started.
+type gatedRunner struct {
+	mu      sync.Mutex
+	started []string
+	gate    chan struct{}
+	running chan string
+}
+
+func newGatedRunner() *gatedRunner {
+	return &gatedRunner{gate: make(chan struct{}), running: make(chan string, 16)}
+}
+
+func (g *gatedRunner) Run(ctx context.Context, job Job) (*JobResult, error) {
+	g.mu.Lock()
+	g.started = append(g.started, job.ID)
+	g.mu.Unlock()
+	g.running <- job.ID
+	<-g.gate
+	return &JobResult{JobID: job.ID, Success: true}, nil
+}
+
+func (g *gatedRunner) release() { g.gate <- struct{}{} }
+
+// releaseAll lets every current and future job finish.
+func (g *gatedRunner) releaseAll() { close(g.gate) }
+
+func TestWorkerPoolQueuesByPriority(t *testing.T) {
+	g := newGatedRunner()
+	w, err := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var jobs []*QueuedJob
+	for _, j := range []Job{{ID: "first"}, {ID: "low"}, {ID: "high", Priority: 5}, {ID: "low-2"}} {
+		q, err := w.Submit(context.Background(), j)
+		if err != nil {
+			t.Fatalf("%s: %v", j.ID, err)
+		}
+		jobs = append(jobs, q)
+		if j.ID == "first" {
+			<-g.running
+		}
+	}
+	if m := w.Metrics(); m.Active != 1 || m.Queued != 3 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if _, err := w.Submit(context.Background(), Job{ID: "overflow"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit() = %v, want ErrQueueFull", err)
+	}
+
+	for range jobs {
+		g.release()
+	}
+	for _, q := range jobs {
+		if res, err := q.Wait(); err != nil || !res.Success {
+			t.Errorf("result = %+v, %v", res, err)
+		}
+	}
+	if want := []string{"first", "high", "low", "low-2"}; !reflect.DeepEqual(g.started, want) {
+		t.Errorf("start order = %v, want %v", g.started, want)
+	}
+	if m := w.Metrics(); m.Active != 0 || m.Queued != 0 || m.Completed != 4 || m.Rejected != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+}
+
+// cancellableRunner holds each job until release or its context is
+// done, reporting the latter as a cancelled job, as Runner does.
+type cancellableRunner struct {
+	gatedRunner
+}
+
+func (c *cancellableRunner) Run(ctx context.Context, job Job) (*JobResult, error) {
+	c.running <- job.ID
+	select {
+	case <-c.gate:
+		return &JobResult{JobID: job.ID, Success: true}, nil
+	case <-ctx.Done():
+		return &JobResult{JobID: job.ID, Cancelled: true, FailureReason: FailureCancelled}, nil
+	}
+}
+
+func TestWorkerPoolPreemptsLowerPriority(t *testing.T) {
+	c := &cancellableRunner{*newGatedRunner()}
+	w, _ := NewWorkerPool(c, WorkerPoolConfig{MaxConcurrent: 2})
+	defer w.Close()
+
+	pinned, _ := w.Submit(context.Background(), Job{ID: "pinned"})
+	<-c.running
+	batch, _ := w.Submit(context.Background(), Job{ID: "batch", Preemptible: true})
+	<-c.running
+	if _, err := w.Submit(context.Background(), Job{ID: "same", Priority: 0}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit() at equal priority = %v, want ErrQueueFull", err)
+	}
+
+	triage, err := w.Submit(context.Background(), Job{ID: "triage", Priority: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := batch.Wait()
+	if err != nil || !res.Cancelled || res.PreemptedBy != "triage" {
+		t.Errorf("batch = %+v, %v, want preempted by triage", res, err)
+	}
+	if id := <-c.running; id != "triage" {
+		t.Errorf("started %s, want triage", id)
+	}
+	// Nothing is left to preempt.
+	if _, err := w.Submit(context.Background(), Job{ID: "urgent", Priority: 20}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit() = %v, want ErrQueueFull", err)
+	}
+
+	c.release()
+	c.release()
+	for _, q := range []*QueuedJob{pinned, triage} {
+		if res, err := q.Wait(); err != nil || !res.Success {
+			t.Errorf("result = %+v, %v", res, err)
+		}
+	}
+	m := w.Metrics()
+	if m.Preempted != 1 || m.Priorities[0].Preempted != 1 || m.Priorities[0].Started != 2 || m.Priorities[10].Started != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if m.Priorities[10].MaxWait <= 0 || m.Priorities[10].TotalWait != m.Priorities[10].MaxWait {
+		t.Errorf("wait of triage = %+v", m.Priorities[10])
+	}
+}
+
+func TestWorkerPoolCancelledWhileQueued(t *testing.T) {
+	g := newGatedRunner()
+	w, _ := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 1})
+	defer w.Close()
+
+	running, _ := w.Submit(context.Background(), Job{ID: "running"})
+	<-g.running
+	ctx, cancel := context.WithCancel(context.Background())
+	queued, err := w.Submit(ctx, Job{ID: "queued"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := queued.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() = %v, want context.Canceled", err)
+	}
+	if m := w.Metrics(); m.Queued != 0 {
+		t.Errorf("cancelled job still queued: %+v", m)
+	}
+	g.release()
+	running.Wait()
+	if !reflect.DeepEqual(g.started, []string{"running"}) {
+		t.Errorf("started = %v", g.started)
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	g := newGatedRunner()
+	w, _ := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 1})
+	running, _ := w.Submit(context.Background(), Job{ID: "running"})
+	<-g.running
+	queued, _ := w.Submit(context.Background(), Job{ID: "queued"})
+
+	closed := make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+	if _, err := queued.Wait(); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Errorf("queued job = %v, want ErrWorkerPoolClosed", err)
+	}
+	g.release()
+	<-closed
+	if res, err := running.Wait(); err != nil || !res.Success {
+		t.Errorf("running job = %+v, %v", res, err)
+	}
+	if _, err := w.Submit(context.Background(), Job{ID: "late"}); !errors.Is(err, ErrWorkerPoolClosed) {
+		t.Errorf("Submit() after Close = %v", err)
+	}
+}
+
+func TestWorkerPoolPrometheus(t *testing.T) {
+	g := newGatedRunner()
+	w, _ := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 2})
+	p := NewPrometheusMetrics()
+	p.TrackQueue(w)
+	for _, id := range []string{"a", "b"} {
+		w.Submit(context.Background(), Job{ID: id})
+	}
+	<-g.running
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		"datamortem_sandbox_queue_depth 1\n",
+		"datamortem_sandbox_active_jobs 1\n",
+		`datamortem_sandbox_queue_wait_seconds_count{priority="0"} 1` + "\n",
+		`datamortem_sandbox_preemptions_total{priority="0"} 0` + "\n",
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("missing %q in:\n%s", line, rec.Body.String())
+		}
+	}
+	g.releaseAll()
+	w.Close()
+}
+
+func TestNewWorkerPoolValidatesConfig(t *testing.T) {
+	for _, cfg := range []WorkerPoolConfig{{}, {MaxConcurrent: 1, QueueDepth: -1}} {
+		if _, err := NewWorkerPool(newGatedRunner(), cfg); err == nil {
+			t.Errorf("NewWorkerPool(%+v) succeeded", cfg)
+		}
+	}
+}
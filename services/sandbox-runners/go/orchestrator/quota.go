@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// containerOutputSync is where OutputDir is mounted when the job writes to
+// a size-limited tmpfs at /output instead.
+const containerOutputSync = "/run/datamortem-output"
+
+// quotaMarker is created in OutputDir when the job filled its quota.
+const quotaMarker = ".quota-exceeded"
+
+// quotaWrapper runs the job's command ("$@") with /output on a tmpfs, then
+// copies the tmpfs to OutputDir before the container exits and the tmpfs is
+// lost. SIGTERM is forwarded so that a stopped job is still collected. A
+// tmpfs with less than one page left means writes failed with ENOSPC.
+const quotaWrapper = `"$@" &
+pid=$!
+trap 'kill -TERM $pid 2>/dev/null' TERM INT
+while :; do
+	wait $pid
+	code=$?
+	kill -0 $pid 2>/dev/null || break
+done
+avail=$(df -Pk ` + containerOutputDir + ` | awk 'NR == 2 { print $4 }')
+cp -R ` + containerOutputDir + `/. ` + containerOutputSync + `/
+if [ "${avail:-4}" -lt 4 ]; then
+	: > ` + containerOutputSync + `/` + quotaMarker + `
+fi
+exit $code`
+
+// applyOutputQuota moves spec's /output onto a tmpfs of quota bytes. It
+// must be applied last, as it wraps the final command.
+func applyOutputQuota(spec *ContainerSpec, quota int64) {
+	mounts := make([]Mount, len(spec.Mounts))
+	for i, m := range spec.Mounts {
+		if m.Target == containerOutputDir {
+			m.Target = containerOutputSync
+		}
+		mounts[i] = m
+	}
+	spec.Mounts = mounts
+	spec.Tmpfs = append(append([]string(nil), spec.Tmpfs...),
+		fmt.Sprintf("%s:rw,size=%d,mode=1777", containerOutputDir, quota))
+	spec.Cmd = append([]string{"sh", "-c", quotaWrapper, "sh"}, spec.Cmd...)
+}
+
+// takeQuotaMarker reports whether the job in dir exceeded its output quota,
+// removing the marker so that it is not collected as an output.
+func takeQuotaMarker(dir string) (bool, error) {
+	err := os.Remove(filepath.Join(dir, quotaMarker))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
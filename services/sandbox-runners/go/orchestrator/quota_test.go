@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRunOutputQuota(t *testing.T) {
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputSync {
+				os.WriteFile(filepath.Join(m.Source, "dump.bin"), make([]byte, 64), 0o644)
+				os.WriteFile(filepath.Join(m.Source, quotaMarker), nil, 0o644)
+			}
+		}
+	}
+	r := NewRunner(rt)
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.OutputQuotaBytes = 1 << 20
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OutputTruncated || !reflect.DeepEqual(res.Outputs, []string{"dump.bin"}) {
+		t.Errorf("truncated = %v, outputs = %v", res.OutputTruncated, res.Outputs)
+	}
+
+	spec := rt.lastSpec()
+	if want := []string{"/tmp", "/scratch:rw,size=268435456,mode=1777", "/output:rw,size=1048576,mode=1777"}; !reflect.DeepEqual(spec.Tmpfs, want) {
+		t.Errorf("tmpfs = %v, want %v", spec.Tmpfs, want)
+	}
+	for _, m := range spec.Mounts {
+		if m.Target == containerOutputDir {
+			t.Errorf("OutputDir still bind mounted at /output")
+		}
+	}
+	if want := append([]string{"sh", "-c", quotaWrapper, "sh"}, goRunCmd("go", "run", ".")...); !reflect.DeepEqual(spec.Cmd, want) {
+		t.Errorf("cmd = %q", spec.Cmd)
+	}
+}
+
+func TestRunWithoutQuotaKeepsBindMount(t *testing.T) {
+	rt := &fakeRuntime{}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OutputTruncated {
+		t.Error("output reported truncated without a quota")
+	}
+	if got := rt.lastSpec().Mounts[1].Target; got != containerOutputDir {
+		t.Errorf("output mount target = %s", got)
+	}
+}
@@ -0,0 +1,302 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LabelJob is the container label naming the job a container was created
+// for, e.g. "datamortem.job=job-42". Every container of the orchestrator
+// has it; image probes and container pools use "probe" and "pool".
+const LabelJob = "datamortem.job"
+
+// scratchPrefixes are the prefixes of the per-job directories under
+// Runner.WorkDir and Runner.SecretsDir, which Reconcile may reclaim.
+var scratchPrefixes = []string{jobDirPrefix, contextDirPrefix, evidenceDirPrefix, fetchDirPrefix, streamDirPrefix, heartbeatDirPrefix, secretsDirPrefix}
+
+// Kinds of LeakedResource.
+const (
+	LeakedContainer  = "container"
+	LeakedScratchDir = "scratch_dir"
+)
+
+// LeakedResource is a resource of a job that outlived it and was
+// reclaimed by Reconcile.
+type LeakedResource struct {
+	// Kind is LeakedContainer or LeakedScratchDir.
+	Kind string
+	// ID is the container ID or the directory path.
+	ID string
+	// Job is the LabelJob of a container, empty for a directory.
+	Job string
+	// Error is why the resource could not be removed, empty when it was.
+	Error string
+}
+
+// ReconcileReport is the outcome of a Reconcile pass.
+type ReconcileReport struct {
+	Started time.Time
+	// Reclaimed lists the leaked resources found, containers first, those
+	// that could not be removed included.
+	Reclaimed []LeakedResource
+}
+
+// Failed counts the resources of rep that could not be removed.
+func (rep ReconcileReport) Failed() int {
+	n := 0
+	for _, l := range rep.Reclaimed {
+		if l.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// LeakCounts are the resources reclaimed by the Reconcile passes of a
+// Runner since it was created, by kind, for PrometheusMetrics.
+type LeakCounts map[string]int64
+
+// liveSet holds the containers and scratch directories of the jobs in
+// flight, which Reconcile leaves alone. Creating or removing one and
+// updating the set hold mu for reading, a Reconcile pass holds it for
+// writing while it looks for leaks: a resource it finds is then either
+// live or leaked.
+type liveSet struct {
+	mu sync.RWMutex
+
+	itemsMu sync.Mutex
+	items   map[string]bool
+	leaks   LeakCounts
+}
+
+func (s *liveSet) add(id string) {
+	s.itemsMu.Lock()
+	defer s.itemsMu.Unlock()
+	if s.items == nil {
+		s.items = map[string]bool{}
+	}
+	s.items[id] = true
+}
+
+func (s *liveSet) remove(id string) {
+	s.itemsMu.Lock()
+	defer s.itemsMu.Unlock()
+	delete(s.items, id)
+}
+
+func (s *liveSet) has(id string) bool {
+	s.itemsMu.Lock()
+	defer s.itemsMu.Unlock()
+	return s.items[id]
+}
+
+// createContainer creates spec once checkMounts accepts it, as a live
+// container until removeContainer.
+func (r *Runner) createContainer(ctx context.Context, spec ContainerSpec) (string, error) {
+	if err := checkMounts(spec); err != nil {
+		return "", err
+	}
+	r.live.mu.RLock()
+	defer r.live.mu.RUnlock()
+	id, err := r.Runtime.Create(ctx, spec)
+	if err == nil {
+		r.live.add(id)
+	}
+	return id, err
+}
+
+// removeContainer removes container id. It is no longer live even if
+// that fails: the next Reconcile pass retries.
+func (r *Runner) removeContainer(ctx context.Context, id string) error {
+	r.live.mu.RLock()
+	defer r.live.mu.RUnlock()
+	defer r.live.remove(id)
+	return r.Runtime.Remove(ctx, id)
+}
+
+// tempDir creates a fresh directory named after prefix under root, as a
+// live directory until removeDir.
+func (r *Runner) tempDir(root, prefix string) (string, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	r.live.mu.RLock()
+	defer r.live.mu.RUnlock()
+	dir, err := os.MkdirTemp(root, prefix)
+	if err == nil {
+		r.live.add(dir)
+	}
+	return dir, err
+}
+
+// removeDir removes dir, created by tempDir.
+func (r *Runner) removeDir(dir string) error {
+	r.live.mu.RLock()
+	defer r.live.mu.RUnlock()
+	defer r.live.remove(dir)
+	return os.RemoveAll(dir)
+}
+
+// workRoot is Runner.WorkDir, os.TempDir() when empty.
+func (r *Runner) workRoot() string {
+	if r.WorkDir == "" {
+		return os.TempDir()
+	}
+	return r.WorkDir
+}
+
+// Reconcile removes the resources that jobs left behind, e.g. when the
+// orchestrator was killed mid-job or a removal failed: the containers
+// with a LabelJob label and the per-job directories under WorkDir and
+// SecretsDir that no job of r is using. Run it on startup, before any
+// job, and then periodically, see ReconcileEvery. It assumes that r is
+// the only Runner using its container engine and directories.
+//
+// A resource that cannot be removed is reported with its error and
+// retried by the next pass; the error only reports failures to list the
+// resources.
+func (r *Runner) Reconcile(ctx context.Context) (ReconcileReport, error) {
+	rep := ReconcileReport{Started: time.Now()}
+	leaks, err := r.findLeaks(ctx)
+	if err != nil {
+		return rep, fmt.Errorf("orchestrator: reconcile: %w", err)
+	}
+	// A leaked resource cannot become live again: it is removed without
+	// holding up the jobs.
+	for _, leak := range leaks {
+		if leak.Kind == LeakedContainer {
+			err = r.Runtime.Remove(ctx, leak.ID)
+		} else {
+			err = os.RemoveAll(leak.ID)
+		}
+		if err != nil {
+			leak.Error = err.Error()
+		}
+		rep.Reclaimed = append(rep.Reclaimed, leak)
+	}
+	r.live.itemsMu.Lock()
+	if r.live.leaks == nil {
+		r.live.leaks = LeakCounts{}
+	}
+	for _, l := range rep.Reclaimed {
+		if l.Error == "" {
+			r.live.leaks[l.Kind]++
+		}
+	}
+	r.live.itemsMu.Unlock()
+	return rep, nil
+}
+
+// findLeaks lists the labelled containers and the per-job directories
+// that are not live.
+func (r *Runner) findLeaks(ctx context.Context) ([]LeakedResource, error) {
+	r.live.mu.Lock()
+	defer r.live.mu.Unlock()
+	containers, err := r.Runtime.Containers(ctx, LabelJob)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := r.scratchDirs()
+	if err != nil {
+		return nil, err
+	}
+	var leaks []LeakedResource
+	ids := make([]string, 0, len(containers))
+	for id := range containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !r.live.has(id) {
+			leaks = append(leaks, LeakedResource{Kind: LeakedContainer, ID: id, Job: containers[id]})
+		}
+	}
+	for _, dir := range dirs {
+		if !r.live.has(dir) {
+			leaks = append(leaks, LeakedResource{Kind: LeakedScratchDir, ID: dir})
+		}
+	}
+	return leaks, nil
+}
+
+// scratchDirs lists the per-job directories under WorkDir and SecretsDir.
+func (r *Runner) scratchDirs() ([]string, error) {
+	roots := []string{r.workRoot()}
+	if r.SecretsDir != "" && r.SecretsDir != roots[0] {
+		roots = append(roots, r.SecretsDir)
+	}
+	var dirs []string
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() && hasScratchPrefix(e.Name()) {
+				dirs = append(dirs, filepath.Join(root, e.Name()))
+			}
+		}
+	}
+	return dirs, nil
+}
+
+func hasScratchPrefix(name string) bool {
+	for _, p := range scratchPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Leaks returns the resources reclaimed by the Reconcile passes of r so
+// far, by kind.
+func (r *Runner) Leaks() LeakCounts {
+	r.live.itemsMu.Lock()
+	defer r.live.itemsMu.Unlock()
+	counts := LeakCounts{LeakedContainer: 0, LeakedScratchDir: 0}
+	for k, n := range r.live.leaks {
+		counts[k] = n
+	}
+	return counts
+}
+
+// ReconcileEvery runs Reconcile at once, then every interval until ctx is
+// done, logging each reclaimed resource and failed pass to log,
+// slog.Default() when nil.
+func (r *Runner) ReconcileEvery(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	if log == nil {
+		log = slog.Default()
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		rep, err := r.Reconcile(ctx)
+		if err != nil {
+			log.Warn("reconcile failed", "error", err)
+		}
+		for _, l := range rep.Reclaimed {
+			if l.Error != "" {
+				log.Warn("leaked resource not removed", "kind", l.Kind, "id", l.ID, "job", l.Job, "error", l.Error)
+			} else {
+				log.Info("leaked resource reclaimed", "kind", l.Kind, "id", l.ID, "job", l.Job)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
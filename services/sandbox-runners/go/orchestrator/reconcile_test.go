@@ -0,0 +1,95 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReconcileRemovesLeaks(t *testing.T) {
+	rt := &fakeRuntime{}
+	// Left behind by an orchestrator that was killed mid-job.
+	rt.Create(context.Background(), ContainerSpec{Labels: map[string]string{LabelJob: "job-old"}})
+	rt.Create(context.Background(), ContainerSpec{Image: "unrelated"})
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	leaked := filepath.Join(r.WorkDir, jobDirPrefix+"123")
+	other := filepath.Join(r.WorkDir, "keep-me")
+	for _, dir := range []string{leaked, other} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rep, err := r.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LeakedResource{
+		{Kind: LeakedContainer, ID: "c1", Job: "job-old"},
+		{Kind: LeakedScratchDir, ID: leaked},
+	}
+	if len(rep.Reclaimed) != len(want) || rep.Reclaimed[0] != want[0] || rep.Reclaimed[1] != want[1] || rep.Failed() != 0 {
+		t.Errorf("reclaimed = %+v, want %+v", rep.Reclaimed, want)
+	}
+	if _, err := os.Stat(leaked); !os.IsNotExist(err) {
+		t.Errorf("leaked directory still there: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated directory removed: %v", err)
+	}
+	if len(rt.removed) != 1 || rt.removed[0] != "c1" {
+		t.Errorf("removed = %v, want [c1]", rt.removed)
+	}
+	if rep, _ := r.Reconcile(context.Background()); len(rep.Reclaimed) != 0 {
+		t.Errorf("second pass reclaimed %+v", rep.Reclaimed)
+	}
+
+	m := NewPrometheusMetrics()
+	m.TrackLeaks(r)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`datamortem_sandbox_leaked_resources_total{kind="container"} 1`,
+		`datamortem_sandbox_leaked_resources_total{kind="scratch_dir"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
+
+func TestReconcileKeepsLiveJobs(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	job := testJob(t)
+	job.Secrets = map[string]string{"API_KEY": "s3cret"}
+	exec, err := r.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Labels[LabelJob]; got != job.ID {
+		t.Errorf("container label = %q, want %s", got, job.ID)
+	}
+	rep, err := r.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Reclaimed) != 0 {
+		t.Errorf("live job reclaimed: %+v", rep.Reclaimed)
+	}
+	exec.Cancel()
+	if _, err := exec.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if rep, _ := r.Reconcile(context.Background()); len(rep.Reclaimed) != 0 {
+		t.Errorf("finished job left %+v", rep.Reclaimed)
+	}
+	if len(r.live.items) != 0 {
+		t.Errorf("live set not emptied: %v", r.live.items)
+	}
+}
@@ -0,0 +1,176 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Kinds of TruncatedRecords.
+const (
+	RecordFindings       = "findings"
+	RecordArtifacts      = "artifacts"
+	RecordTimelineEvents = "timeline_events"
+)
+
+// TruncatedRecords reports records of a kind that the job wrote past their
+// cap in ExecConfig and that were not ingested: those written first are.
+type TruncatedRecords struct {
+	Kind string
+	// Limit is the cap and Total the records the job wrote, rejected ones
+	// included.
+	Limit int
+	Total int
+}
+
+// recordCaps returns the caps of cfg by kind, those set only.
+func recordCaps(cfg ExecConfig) map[string]int {
+	caps := map[string]int{}
+	for kind, n := range map[string]int{
+		RecordFindings:       cfg.MaxFindings,
+		RecordArtifacts:      cfg.MaxArtifacts,
+		RecordTimelineEvents: cfg.MaxTimelineEvents,
+	} {
+		if n > 0 {
+			caps[kind] = n
+		}
+	}
+	return caps
+}
+
+// recordLimitEnv passes the caps of cfg to the script's SDK.
+func recordLimitEnv(env map[string]string, cfg ExecConfig) {
+	for kind, key := range map[string]string{
+		RecordFindings:       sandbox.EnvMaxFindings,
+		RecordArtifacts:      sandbox.EnvMaxArtifacts,
+		RecordTimelineEvents: sandbox.EnvMaxTimelineEvents,
+	} {
+		if n, ok := recordCaps(cfg)[kind]; ok {
+			env[key] = strconv.Itoa(n)
+		}
+	}
+}
+
+// truncatedRecords lists the kinds of record of cfg whose cap was reached,
+// kept records out of total, with records left out.
+func truncatedRecords(cfg ExecConfig, kept, total map[string]int) []TruncatedRecords {
+	var truncated []TruncatedRecords
+	caps := recordCaps(cfg)
+	for _, kind := range []string{RecordFindings, RecordArtifacts, RecordTimelineEvents} {
+		if limit, ok := caps[kind]; ok && kept[kind] >= limit && total[kind] > limit {
+			truncated = append(truncated, TruncatedRecords{Kind: kind, Limit: limit, Total: total[kind]})
+		}
+	}
+	return truncated
+}
+
+// recordWatch stops a job that writes more records than a cap allows,
+// with ExecConfig.KillOverRecordLimit, rather than letting it fill the
+// output quota with records that will not be ingested.
+type recordWatch struct {
+	dir   string
+	caps  map[string]int
+	tails map[string]*ndjsonTail
+	lines map[string]int
+	// killed is set once the job was stopped for its records, exceeded
+	// names the kind whose cap it went over.
+	killed   atomic.Bool
+	exceeded atomic.Value
+}
+
+// newRecordWatch returns the watch of a job with cfg whose OUTPUT_DIR is
+// outputDir on the host, nil unless cfg kills the jobs over a cap.
+func newRecordWatch(cfg ExecConfig, outputDir string) *recordWatch {
+	caps := recordCaps(cfg)
+	if !cfg.KillOverRecordLimit || len(caps) == 0 {
+		return nil
+	}
+	w := &recordWatch{dir: outputDir, caps: caps, tails: map[string]*ndjsonTail{}, lines: map[string]int{}}
+	for kind, file := range map[string]string{RecordFindings: sandbox.ResultsFile, RecordTimelineEvents: sandbox.TimelineFile} {
+		if _, ok := caps[kind]; ok {
+			w.tails[kind] = &ndjsonTail{path: filepath.Join(outputDir, file)}
+		}
+	}
+	return w
+}
+
+// over returns the kind of record the job wrote more of than its cap,
+// counting the lines appended since the last call.
+func (w *recordWatch) over() (string, bool) {
+	for kind, t := range w.tails {
+		for _, line := range t.poll() {
+			if _, ok := sandbox.ParseHeader(line); !ok {
+				w.lines[kind]++
+			}
+		}
+		if w.lines[kind] > w.caps[kind] {
+			return kind, true
+		}
+	}
+	if limit, ok := w.caps[RecordArtifacts]; ok {
+		outputs, _ := collectOutputs(w.dir)
+		n := 0
+		for _, rel := range outputs {
+			if !contractFiles[rel] {
+				n++
+			}
+		}
+		if n > limit {
+			return RecordArtifacts, true
+		}
+	}
+	return "", false
+}
+
+// start checks the job's records in the background until ctx or done is,
+// calling cancel to stop it once it went over a cap.
+func (w *recordWatch) start(ctx context.Context, done <-chan struct{}, cancel context.CancelFunc) {
+	if w == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(progressPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if kind, ok := w.over(); ok {
+				w.exceeded.Store(kind)
+				w.killed.Store(true)
+				cancel()
+				return
+			}
+		}
+	}()
+}
+
+// apply records in res that the job was stopped for its records, when
+// killed.
+func (w *recordWatch) apply(res *JobResult, killed bool) {
+	if w == nil || !killed || res.Cancelled {
+		return
+	}
+	kind, _ := w.exceeded.Load().(string)
+	res.Incomplete = true
+	res.Success = false
+	res.FailureReason = FailureRecordLimit
+	res.FailureDetail = fmt.Sprintf("stopped after writing more than %d %s", w.caps[kind], kindName(kind))
+}
+
+// kindName is kind for display, e.g. "timeline events".
+func kindName(kind string) string {
+	if kind == RecordTimelineEvents {
+		return "timeline events"
+	}
+	return kind
+}
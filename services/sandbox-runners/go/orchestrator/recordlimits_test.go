@@ -0,0 +1,104 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// writeRecords makes the container of spec write n findings, n timeline
+// events and n untracked artifacts.
+func writeRecords(spec ContainerSpec, n int) {
+	out := ""
+	for _, m := range spec.Mounts {
+		if m.Target == containerOutputDir {
+			out = m.Source
+		}
+	}
+	var results, timeline strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&results, `{"evidence_uid":"ev-1","severity":"low","title":"finding %d"}`+"\n", i)
+		fmt.Fprintf(&timeline, `{"timestamp":"2024-01-01T00:00:0%dZ","evidence_uid":"ev-1","source":"s","message":"m"}`+"\n", i)
+		os.WriteFile(filepath.Join(out, fmt.Sprintf("file-%d.txt", i)), []byte("x"), 0o644)
+	}
+	os.WriteFile(filepath.Join(out, sandbox.ResultsFile), []byte(results.String()), 0o644)
+	os.WriteFile(filepath.Join(out, sandbox.TimelineFile), []byte(timeline.String()), 0o644)
+}
+
+func recordLimitJob(t *testing.T, findings, artifacts, timeline int) Job {
+	t.Helper()
+	cfg := DefaultExecConfig()
+	cfg.MaxFindings, cfg.MaxArtifacts, cfg.MaxTimelineEvents = findings, artifacts, timeline
+	cfg.GracePeriod = 10 * time.Millisecond
+	job := testJob(t)
+	job.Config = &cfg
+	return job
+}
+
+func TestRunCapsRecords(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) { writeRecords(spec, 5) }}
+	r := NewRunner(rt)
+	// The cap on timeline events is met exactly, not exceeded.
+	res, err := r.Run(context.Background(), recordLimitJob(t, 3, 2, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TruncatedRecords{{Kind: RecordFindings, Limit: 3, Total: 5}, {Kind: RecordArtifacts, Limit: 2, Total: 5}}
+	if !reflect.DeepEqual(res.RecordsTruncated, want) {
+		t.Errorf("truncated = %+v, want %+v", res.RecordsTruncated, want)
+	}
+	if len(res.Findings) != 3 || res.Findings[2].Title != "finding 2" || len(res.Artifacts) != 2 || len(res.Timeline) != 5 {
+		t.Errorf("ingested %d findings, %d artifacts, %d events", len(res.Findings), len(res.Artifacts), len(res.Timeline))
+	}
+	if !res.Success || res.Incomplete || len(res.InvalidRecords) != 0 {
+		t.Errorf("result = %+v, want a success", res)
+	}
+	env := rt.lastSpec().Env
+	if env[sandbox.EnvMaxFindings] != "3" || env[sandbox.EnvMaxArtifacts] != "2" || env[sandbox.EnvMaxTimelineEvents] != "5" {
+		t.Errorf("cap env = %s, %s, %s", env[sandbox.EnvMaxFindings], env[sandbox.EnvMaxArtifacts], env[sandbox.EnvMaxTimelineEvents])
+	}
+
+	res, err = r.Run(context.Background(), recordLimitJob(t, 0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RecordsTruncated != nil || len(res.Findings) != 5 || rt.lastSpec().Env[sandbox.EnvMaxFindings] != "" {
+		t.Errorf("without caps: truncated %+v, %d findings", res.RecordsTruncated, len(res.Findings))
+	}
+}
+
+func TestKillOverRecordLimit(t *testing.T) {
+	progressPollInterval = 5 * time.Millisecond
+	defer func() { progressPollInterval = 500 * time.Millisecond }()
+	rt := &fakeRuntime{block: true, onStart: func(spec ContainerSpec) { writeRecords(spec, 4) }}
+	r := NewRunner(rt)
+	job := recordLimitJob(t, 10, 0, 3)
+	job.Config.KillOverRecordLimit = true
+	job.Config.RunTimeout = 5 * time.Second
+
+	started := time.Now()
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("job stopped after %s", time.Since(started))
+	}
+	if res.Success || !res.Incomplete || res.TimedOut || res.FailureReason != FailureRecordLimit ||
+		res.FailureDetail != "stopped after writing more than 3 timeline events" {
+		t.Errorf("result = %+v, want stopped over its cap", res)
+	}
+	if want := []TruncatedRecords{{Kind: RecordTimelineEvents, Limit: 3, Total: 4}}; !reflect.DeepEqual(res.RecordsTruncated, want) {
+		t.Errorf("truncated = %+v, want %+v", res.RecordsTruncated, want)
+	}
+	if len(rt.signals) == 0 || rt.signals[0] != "SIGTERM" {
+		t.Errorf("signals = %v, want a graceful stop", rt.signals)
+	}
+}
@@ -0,0 +1,25 @@
+package orchestrator
+
+import "github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+
+// InvalidRecord is a line of results.ndjson, timeline.ndjson or
+// iocs.ndjson rejected at collection. It is quarantined in the result for
+// review instead of being ingested or lost.
+type InvalidRecord struct {
+	File string
+	Line int
+	// Record is the line as the script wrote it.
+	Record string
+	Error  string
+}
+
+// invalidRecords lists the rejected lines reported by the collection errs.
+func invalidRecords(errs ...error) []InvalidRecord {
+	var records []InvalidRecord
+	for _, err := range errs {
+		for _, e := range sandbox.RecordErrors(err) {
+			records = append(records, InvalidRecord{File: e.File, Line: e.Line, Record: e.Record, Error: e.Err.Error()})
+		}
+	}
+	return records
+}
@@ -0,0 +1,333 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ErrNotReplayable is returned by Runner.Replay for a job Runner.Replays
+// holds no record of, or whose inputs cannot be reproduced: secret values
+// are not kept, and evidence without a digest cannot be shown to be the
+// same.
+var ErrNotReplayable = errors.New("orchestrator: job cannot be replayed")
+
+const (
+	// replayRecordFile and replayWorkspaceDir hold the ReplayRecord and
+	// the workspace of a job in its ReplayStore directory.
+	replayRecordFile   = "replay.json"
+	replayWorkspaceDir = "workspace"
+	// replayDirPrefix names the staging directories of ReplayStore and
+	// the workspaces of replays.
+	replayDirPrefix = ".replay-"
+	// ReplayIDSuffix is appended to the job ID of a replay, e.g.
+	// "job-7-replay", so that the audit log and Runner.Jobs tell it from
+	// the original.
+	ReplayIDSuffix = "-replay"
+)
+
+// ReplayStore keeps the inputs of every job the runner records, the
+// digests of what it produced and its records, on a host volume, for
+// Runner.Replay to reproduce the job after an incident and Runner.DiffJobs
+// to compare it with another.
+type ReplayStore struct {
+	// Dir holds one directory per job ID: a copy of the job's workspace,
+	// go.mod and go.sum included, and its ReplayRecord.
+	Dir string
+}
+
+// ReplayRecord is what ReplayStore keeps of a job.
+type ReplayRecord struct {
+	// Job is the job as it ran: its parameters resolved, RunTime and Seed
+	// set, and Config the configuration it ran with, pinned to
+	// ImageDigest. Workspace, OutputDir, LogDir, Callback and Secrets are
+	// cleared; SecretNames lists the secrets by name.
+	Job         Job      `json:"job"`
+	SecretNames []string `json:"secret_names,omitempty"`
+	// Env is the environment of the script.
+	Env          map[string]string `json:"env"`
+	Image        string            `json:"image"`
+	ImageDigest  string            `json:"image_digest"`
+	BinarySHA256 string            `json:"binary_sha256,omitempty"`
+	ExitCode     int               `json:"exit_code"`
+	Success      bool              `json:"success"`
+	// FailureReason is empty on success.
+	FailureReason FailureReason `json:"failure_reason,omitempty"`
+	// Outputs maps each file of JobResult.Outputs to its SHA256.
+	Outputs map[string]string `json:"outputs"`
+	// Findings, IOCs and Timeline are the records collected from the
+	// job, for Runner.DiffJobs.
+	Findings []sandbox.Result        `json:"findings,omitempty"`
+	IOCs     []sandbox.IOC           `json:"iocs,omitempty"`
+	Timeline []sandbox.TimelineEvent `json:"timeline,omitempty"`
+	Recorded time.Time               `json:"recorded"`
+}
+
+// dir is the directory of jobID in s.
+func (s *ReplayStore) dir(jobID string) (string, error) {
+	if jobID == "" || jobID == ".." || strings.HasPrefix(jobID, ".") || strings.ContainsAny(jobID, `/\`) {
+		return "", fmt.Errorf("orchestrator: job ID %q cannot name a replay record", jobID)
+	}
+	return filepath.Join(s.Dir, jobID), nil
+}
+
+// Load returns the record of jobID, with ErrNotReplayable when s holds
+// none.
+func (s *ReplayStore) Load(jobID string) (ReplayRecord, error) {
+	dir, err := s.dir(jobID)
+	if err != nil {
+		return ReplayRecord{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, replayRecordFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ReplayRecord{}, fmt.Errorf("%w: no record of job %s", ErrNotReplayable, jobID)
+	}
+	if err != nil {
+		return ReplayRecord{}, err
+	}
+	var rec ReplayRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return ReplayRecord{}, fmt.Errorf("orchestrator: replay record of job %s: %w", jobID, err)
+	}
+	return rec, nil
+}
+
+// store saves rec and a copy of workspace under the ID of rec's job,
+// replacing the earlier record.
+func (s *ReplayStore) store(rec ReplayRecord, workspace string) error {
+	final, err := s.dir(rec.Job.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(s.Dir, replayDirPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(workspace, filepath.Join(tmp, replayWorkspaceDir)); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, replayRecordFile), data, 0o644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(final); err != nil {
+		return err
+	}
+	return os.Rename(tmp, final)
+}
+
+// storeReplay records job, which ended with res, in r.Replays. The
+// workspace is copied as it is when the job ends.
+func (r *Runner) storeReplay(job Job, res *JobResult) error {
+	p, err := profile(job.Language)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.jobConfig(job)
+	if err != nil {
+		cfg = r.profiledConfig(job)
+	}
+	outputs, err := outputDigests(job.OutputDir, res.Outputs)
+	if err != nil {
+		return err
+	}
+	rec := ReplayRecord{
+		Env:           jobEnv(job, cfg, p),
+		Image:         res.Image,
+		ImageDigest:   res.ImageDigest,
+		BinarySHA256:  res.BinarySHA256,
+		ExitCode:      res.ExitCode,
+		Success:       res.Success,
+		FailureReason: res.FailureReason,
+		Outputs:       outputs,
+		Findings:      res.Findings,
+		IOCs:          res.IOCs,
+		Timeline:      res.Timeline,
+		Recorded:      time.Now().UTC(),
+	}
+	for name := range job.Secrets {
+		rec.SecretNames = append(rec.SecretNames, name)
+	}
+	sort.Strings(rec.SecretNames)
+
+	// The requirements file is in the stored workspace: the replay
+	// applies it again to the configuration the job was given.
+	base := r.profiledConfig(job)
+	if job.Flavor == "" && res.ImageDigest != "" {
+		// A flavor image is built from the base image, whose ID the
+		// result does not hold; the replay compares the flavor's.
+		base.ImageDigest = res.ImageDigest
+	}
+	workspace := job.Workspace
+	job.Config = &base
+	job.Workspace, job.OutputDir, job.LogDir, job.Callback, job.Secrets = "", "", "", "", nil
+	rec.Job = job
+	return r.Replays.store(rec, workspace)
+}
+
+// outputDigests returns the SHA256 of each of outputs, relative to dir.
+// Files removed since they were listed, e.g. a discarded checkpoint, are
+// left out.
+func outputDigests(dir string, outputs []string) (map[string]string, error) {
+	sums := map[string]string{}
+	for _, name := range outputs {
+		sum, _, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sums[name] = sum
+	}
+	return sums, nil
+}
+
+// ReplayResult is the outcome of Runner.Replay.
+type ReplayResult struct {
+	// Original is the record of the job replayed, and Result the outcome
+	// of the replay.
+	Original ReplayRecord
+	Result   *JobResult
+	// Differences describes how the replay departs from the original, e.g.
+	// "output report.csv: SHA256 9f86..., was 60303..."; empty when the
+	// replay reproduced it.
+	Differences []string
+}
+
+// Reproduced reports whether the replay ended as the original did, with
+// the same output files.
+func (r *ReplayResult) Reproduced() bool {
+	return len(r.Differences) == 0
+}
+
+// Replay reruns the job jobID stored in r.Replays with the inputs it ran
+// with: the same script sources, go.mod and go.sum, image, configuration,
+// parameters, RunTime and Seed, against the same evidence, writing its
+// outputs to outputDir. The replay runs as jobID+ReplayIDSuffix and
+// bypasses Runner.ResultCache.
+//
+// Replay refuses, with an EvidenceError wrapping
+// sandbox.ErrEvidenceHashMismatch, to run against an evidence file that no
+// longer matches the digest recorded in the case, and with
+// ErrNotReplayable a job that had secrets or evidence without a digest.
+func (r *Runner) Replay(ctx context.Context, jobID, outputDir string) (*ReplayResult, error) {
+	if r.Replays == nil {
+		return nil, fmt.Errorf("%w: no Runner.Replays", ErrNotReplayable)
+	}
+	rec, err := r.Replays.Load(jobID)
+	if err != nil {
+		return nil, err
+	}
+	job := rec.Job
+	if len(rec.SecretNames) > 0 {
+		return nil, fmt.Errorf("%w: job %s had secrets %s, which are not kept", ErrNotReplayable, jobID, strings.Join(rec.SecretNames, ", "))
+	}
+	for _, ev := range job.allEvidence() {
+		if ev.SHA256 == "" {
+			return nil, fmt.Errorf("%w: evidence %s of job %s has no digest", ErrNotReplayable, ev.UID, jobID)
+		}
+	}
+	// The zero configuration hashes compressed evidence too.
+	if err := preflightEvidence(job, ExecConfig{}); err != nil {
+		return nil, err
+	}
+
+	dir, err := r.Replays.dir(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if r.WorkDir != "" {
+		if err := os.MkdirAll(r.WorkDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// The replay works on a copy: a cancelled job's workspace is
+	// emptied.
+	workspace, err := os.MkdirTemp(r.WorkDir, replayDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workspace)
+	if err := copyTree(filepath.Join(dir, replayWorkspaceDir), workspace); err != nil {
+		return nil, err
+	}
+	job.ID += ReplayIDSuffix
+	job.Workspace, job.OutputDir = workspace, outputDir
+	job.ForceRerun = true
+	res, err := r.Run(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := compareReplay(rec, res, outputDir)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayResult{Original: rec, Result: res, Differences: diffs}, nil
+}
+
+// compareReplay lists how res, the replay of rec written to outputDir,
+// differs from the original job.
+func compareReplay(rec ReplayRecord, res *JobResult, outputDir string) ([]string, error) {
+	var diffs []string
+	differ := func(what string, got, was any) {
+		diffs = append(diffs, fmt.Sprintf("%s %v, was %v", what, got, was))
+	}
+	if res.ExitCode != rec.ExitCode {
+		differ("exit code", res.ExitCode, rec.ExitCode)
+	}
+	if res.Success != rec.Success {
+		differ("success", res.Success, rec.Success)
+	}
+	if res.FailureReason != rec.FailureReason.Canonical() {
+		diffs = append(diffs, fmt.Sprintf("failure reason %q, was %q", res.FailureReason, rec.FailureReason))
+	}
+	if rec.ImageDigest != "" && res.ImageDigest != rec.ImageDigest {
+		differ("image", res.ImageDigest, rec.ImageDigest)
+	}
+	if rec.BinarySHA256 != "" && res.BinarySHA256 != "" && res.BinarySHA256 != rec.BinarySHA256 {
+		differ("binary SHA256", res.BinarySHA256, rec.BinarySHA256)
+	}
+	outputs, err := outputDigests(outputDir, res.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(outputs)+len(rec.Outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	for name := range rec.Outputs {
+		if _, ok := outputs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		got, ok := outputs[name]
+		was, existed := rec.Outputs[name]
+		switch {
+		case !existed:
+			diffs = append(diffs, "output "+name+": not in the original")
+		case !ok:
+			diffs = append(diffs, "output "+name+": missing")
+		case got != was:
+			differ("output "+name+": SHA256", got, was)
+		}
+	}
+	return diffs, nil
+}
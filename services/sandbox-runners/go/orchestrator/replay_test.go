@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestReplay(t *testing.T) {
+	// The script writes its seed and run time, then whatever extra holds.
+	extra := ""
+	rt := &fakeRuntime{}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				report := spec.Env[sandbox.EnvSeed] + " " + spec.Env[sandbox.EnvRunTime] + extra
+				os.WriteFile(filepath.Join(m.Source, "report.csv"), []byte(report), 0o644)
+			}
+		}
+	}
+	r := NewRunner(rt)
+	r.WorkDir = t.TempDir()
+	r.Replays = &ReplayStore{Dir: t.TempDir()}
+	evidence := writeFixture(t, "disk.raw", "v1")
+	sum, _, err := fileSHA256(evidence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := testJob(t)
+	job.Evidence.Path, job.Evidence.SHA256 = evidence, sum
+	job.Params = map[string]string{"PARAM_YEAR": "2026"}
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+	os.WriteFile(filepath.Join(job.Workspace, "go.mod"), []byte(testGoMod), 0o644)
+	os.WriteFile(filepath.Join(job.Workspace, "go.sum"), nil, 0o644)
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ReplayError != "" {
+		t.Fatalf("replay error: %s", res.ReplayError)
+	}
+	original := rt.lastSpec()
+
+	rec, err := r.Replays.Load("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Job.Params["PARAM_YEAR"] != "2026" || rec.Job.Seed == 0 || rec.Job.Config.ImageDigest != res.ImageDigest || rec.Outputs["report.csv"] == "" {
+		t.Errorf("record = %+v", rec)
+	}
+	if rec.Env[sandbox.EnvSeed] != original.Env[sandbox.EnvSeed] {
+		t.Errorf("recorded env %v", rec.Env)
+	}
+	for _, name := range []string{"main.go", "go.mod", "go.sum"} {
+		if _, err := os.Stat(filepath.Join(r.Replays.Dir, "job-1", replayWorkspaceDir, name)); err != nil {
+			t.Errorf("workspace not stored: %v", err)
+		}
+	}
+
+	// The workspace may change after the job ran: the replay uses the
+	// stored copy.
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main // v2"), 0o644)
+	replay, err := r.Replay(context.Background(), "job-1", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replay.Reproduced() || replay.Result.JobID != "job-1"+ReplayIDSuffix {
+		t.Errorf("replay = %+v", replay)
+	}
+	spec := rt.lastSpec()
+	for _, name := range []string{sandbox.EnvSeed, sandbox.EnvRunTime, "PARAM_YEAR"} {
+		if spec.Env[name] != original.Env[name] {
+			t.Errorf("replay %s = %q, want %q", name, spec.Env[name], original.Env[name])
+		}
+	}
+	if spec.Image != original.Image {
+		t.Errorf("replay image %s, want %s", spec.Image, original.Image)
+	}
+
+	// A script that does not behave the same is reported.
+	extra = " nondeterministic"
+	replay, err = r.Replay(context.Background(), "job-1", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.Reproduced() || len(replay.Differences) != 1 || !strings.HasPrefix(replay.Differences[0], "output report.csv: SHA256 ") {
+		t.Errorf("differences = %q", replay.Differences)
+	}
+
+	os.WriteFile(evidence, []byte("v2"), 0o644)
+	if _, err := r.Replay(context.Background(), "job-1", t.TempDir()); !errors.Is(err, sandbox.ErrEvidenceHashMismatch) {
+		t.Errorf("Replay() on changed evidence = %v, want ErrEvidenceHashMismatch", err)
+	}
+	if _, err := r.Replay(context.Background(), "job-2", t.TempDir()); !errors.Is(err, ErrNotReplayable) {
+		t.Errorf("Replay() of an unknown job = %v, want ErrNotReplayable", err)
+	}
+
+	os.WriteFile(evidence, []byte("v1"), 0o644)
+	job.ID = "job-2"
+	job.Secrets = map[string]string{"vt_api_key": testSecret}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Replay(context.Background(), "job-2", t.TempDir()); !errors.Is(err, ErrNotReplayable) {
+		t.Errorf("Replay() of a job with secrets = %v, want ErrNotReplayable", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(r.Replays.Dir, "job-2", replayRecordFile)); strings.Contains(string(data), testSecret) {
+		t.Error("replay record holds a secret value")
+	}
+}
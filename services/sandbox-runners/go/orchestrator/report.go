@@ -0,0 +1,249 @@
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ReportCSP is the Content-Security-Policy of the documents of
+// RenderReport: no scripts, plugins, forms or network requests, only
+// inline styles and embedded images. It should also be sent as a header
+// when serving them, and with the "sandbox" directive for PDF reports.
+const ReportCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src data:; form-action 'none'; base-uri 'none'"
+
+// ErrReportNotRenderable is returned by RenderReport for a PDF report,
+// which is served as is.
+var ErrReportNotRenderable = errors.New("orchestrator: report is not rendered as HTML")
+
+// primaryReport returns the report to show in the case view: the first
+// one the script emitted with sandbox.EmitReport, else the first artifact
+// of kind report in a known format.
+func primaryReport(artifacts []CollectedArtifact) *CollectedArtifact {
+	var first *CollectedArtifact
+	for i := range artifacts {
+		a := &artifacts[i]
+		if a.Kind != sandbox.ArtifactReport || reportFormat(a.Artifact) == "" {
+			continue
+		}
+		if a.Format != "" {
+			return a
+		}
+		if first == nil {
+			first = a
+		}
+	}
+	return first
+}
+
+// reportFormat returns the format of a report artifact, from its
+// extension for those registered with sandbox.RegisterArtifact.
+func reportFormat(a sandbox.Artifact) sandbox.ReportFormat {
+	if a.Format != "" {
+		return a.Format
+	}
+	switch strings.ToLower(path.Ext(a.Path)) {
+	case ".html", ".htm":
+		return sandbox.ReportHTML
+	case ".md", ".markdown":
+		return sandbox.ReportMarkdown
+	case ".pdf":
+		return sandbox.ReportPDF
+	}
+	return ""
+}
+
+// RenderReport returns report, an artifact of outputDir such as
+// JobResult.Report, as a standalone HTML document safe to display in the
+// case view under ReportCSP. The script's HTML is untrusted: it is shown
+// in an iframe sandboxed without scripts, forms or same-origin access, so
+// that it cannot reach the platform's pages. Markdown is rendered to HTML
+// server-side, raw HTML in it escaped, with links limited to http, https
+// and mailto.
+func RenderReport(outputDir string, report CollectedArtifact) ([]byte, error) {
+	format := reportFormat(report.Artifact)
+	if format == sandbox.ReportPDF {
+		return nil, ErrReportNotRenderable
+	}
+	if format == "" {
+		return nil, fmt.Errorf("orchestrator: %s is not a report", report.Path)
+	}
+	p, err := sandbox.SafeJoin(outputDir, report.Path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var body string
+	if format == sandbox.ReportHTML {
+		body = `<iframe sandbox="" referrerpolicy="no-referrer" style="border:0;width:100%;height:100vh" srcdoc="` + html.EscapeString(string(data)) + `"></iframe>`
+	} else {
+		body = renderMarkdown(string(data))
+	}
+	doc := "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">" +
+		`<meta http-equiv="Content-Security-Policy" content="` + html.EscapeString(ReportCSP) + `">` +
+		"<title>" + html.EscapeString(report.Path) + "</title></head>\n<body>\n" + body + "</body></html>\n"
+	return []byte(doc), nil
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBullet  = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdRule    = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+)
+
+// renderMarkdown renders the common subset of Markdown: headings,
+// paragraphs, lists, block quotes, rules, fenced code, and inline code,
+// emphasis and links. Everything else is text, escaped.
+func renderMarkdown(src string) string {
+	var b strings.Builder
+	var para []string
+	list := ""
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + mdInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			b.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			closeList()
+			b.WriteString("<pre><code>")
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				b.WriteString(html.EscapeString(lines[i]) + "\n")
+			}
+			b.WriteString("</code></pre>\n")
+		case trimmed == "":
+			flush()
+			closeList()
+		case mdHeading.MatchString(trimmed):
+			flush()
+			closeList()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			level := fmt.Sprint(len(m[1]))
+			b.WriteString("<h" + level + ">" + mdInline(m[2]) + "</h" + level + ">\n")
+		case mdRule.MatchString(trimmed):
+			flush()
+			closeList()
+			b.WriteString("<hr>\n")
+		case mdBullet.MatchString(line):
+			flush()
+			openList("ul")
+			b.WriteString("<li>" + mdInline(mdBullet.FindStringSubmatch(line)[1]) + "</li>\n")
+		case mdOrdered.MatchString(line):
+			flush()
+			openList("ol")
+			b.WriteString("<li>" + mdInline(mdOrdered.FindStringSubmatch(line)[1]) + "</li>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			closeList()
+			b.WriteString("<blockquote>" + mdInline(strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))) + "</blockquote>\n")
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	closeList()
+	return b.String()
+}
+
+// mdInline renders the inline markup of s, escaping the rest.
+func mdInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '`':
+			if end := strings.IndexByte(rest[1:], '`'); end >= 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:1+end]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**"):
+			if end := strings.Index(rest[2:], "**"); end > 0 {
+				b.WriteString("<strong>" + mdInline(rest[2:2+end]) + "</strong>")
+				i += end + 4
+				continue
+			}
+		case rest[0] == '*' || rest[0] == '_':
+			if end := strings.IndexByte(rest[1:], rest[0]); end > 0 {
+				b.WriteString("<em>" + mdInline(rest[1:1+end]) + "</em>")
+				i += end + 2
+				continue
+			}
+		case rest[0] == '[':
+			if mid := strings.Index(rest, "]("); mid > 0 {
+				if end := closingParen(rest[mid+2:]); end >= 0 {
+					text, url := rest[1:mid], strings.TrimSpace(rest[mid+2:mid+2+end])
+					if safeLink(url) {
+						b.WriteString(`<a href="` + html.EscapeString(url) + `" rel="noopener noreferrer">` + mdInline(text) + "</a>")
+					} else {
+						b.WriteString(mdInline(text))
+					}
+					i += mid + 3 + end
+					continue
+				}
+			}
+		}
+		b.WriteString(html.EscapeString(rest[:1]))
+		i++
+	}
+	return b.String()
+}
+
+// closingParen returns the index of the ')' closing a link target in s,
+// which may itself contain balanced parentheses, or -1.
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// safeLink reports whether a Markdown link target may become an href.
+func safeLink(url string) bool {
+	lower := strings.ToLower(url)
+	for _, scheme := range []string{"https://", "http://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunSurfacesReport(t *testing.T) {
+	job := testJob(t)
+	dir := job.OutputDir
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		os.WriteFile(filepath.Join(dir, "notes.html"), []byte("<p>draft</p>"), 0o644)
+		os.WriteFile(filepath.Join(dir, "report.md"), []byte("# Summary\n"), 0o644)
+		os.WriteFile(filepath.Join(dir, sandbox.ManifestFile), []byte(`{"artifacts":[
+			{"path":"notes.html","kind":"report","sha256":"x","size":12},
+			{"path":"report.md","kind":"report","format":"markdown","sha256":"y","size":10}]}`), 0o644)
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Report == nil || res.Report.Path != "report.md" {
+		t.Fatalf("report = %+v", res.Report)
+	}
+}
+
+func TestRenderReport(t *testing.T) {
+	dir := writeWorkspace(t, map[string]string{
+		"report.html": `<h1>Hits</h1><script>fetch("/api/cases")</script><img src=x onerror=alert(1)>`,
+		"report.md": "# Hits for <b>case</b>\n\nFound **3** `evil.exe` in _C:\\temp_.\n\n" +
+			"- [MISP](https://misp.example/events/1)\n- [click](javascript:alert(1))\n\n" +
+			"<script>alert(1)</script>\n\n```\n<not html>\n```\n",
+		"report.pdf": "%PDF-1.7",
+	})
+	report := func(path string, format sandbox.ReportFormat) CollectedArtifact {
+		return CollectedArtifact{Artifact: sandbox.Artifact{Path: path, Kind: sandbox.ArtifactReport, Format: format}}
+	}
+
+	out, err := RenderReport(dir, report("report.html", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(out)
+	if !strings.Contains(doc, `<iframe sandbox=""`) || !strings.Contains(doc, "Content-Security-Policy") ||
+		strings.Contains(doc, "<script>") || strings.Contains(doc, "<img") {
+		t.Errorf("html document = %s", doc)
+	}
+
+	out, err = RenderReport(dir, report("report.md", sandbox.ReportMarkdown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = string(out)
+	for _, want := range []string{
+		"<h1>Hits for &lt;b&gt;case&lt;/b&gt;</h1>",
+		"<p>Found <strong>3</strong> <code>evil.exe</code> in <em>C:\\temp</em>.</p>",
+		`<li><a href="https://misp.example/events/1" rel="noopener noreferrer">MISP</a></li>`,
+		"<li>click</li>",
+		"<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>",
+		"<pre><code>&lt;not html&gt;\n</code></pre>",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("markdown document lacks %s:\n%s", want, doc)
+		}
+	}
+
+	if _, err := RenderReport(dir, report("report.pdf", sandbox.ReportPDF)); !errors.Is(err, ErrReportNotRenderable) {
+		t.Errorf("pdf: err = %v", err)
+	}
+	if _, err := RenderReport(dir, report("../report.md", sandbox.ReportMarkdown)); !errors.Is(err, sandbox.ErrPathEscape) {
+		t.Errorf("escaping path: err = %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// RequirementsFile is the name of the requirements manifest at the root of
+// a script's workspace.
+const RequirementsFile = "sandbox.json"
+
+// requiresDirective starts the requirements line of a script's header
+// comment, e.g. `// sandbox:requires memory=4GB timeout=30m cpus=2`.
+const requiresDirective = "sandbox:requires"
+
+// ErrUnsatisfiableRequirements is returned for a job whose declared
+// requirements exceed what every worker offers.
+var ErrUnsatisfiableRequirements = errors.New("orchestrator: no worker can satisfy the script's requirements")
+
+// Requirements are the resources a script declares it needs. A zero field
+// declares nothing.
+type Requirements struct {
+	MemoryBytes int64
+	Timeout     time.Duration
+	CPUs        float64
+}
+
+// requirementsManifest is the content of RequirementsFile.
+type requirementsManifest struct {
+	// ScriptModule holds the name, version, author and description of the
+	// script.
+	ScriptModule
+	Requires struct {
+		Memory  string  `json:"memory"`
+		Timeout string  `json:"timeout"`
+		CPUs    float64 `json:"cpus"`
+	} `json:"requires"`
+	// Accepts lists the evidence types the script accepts.
+	Accepts []string `json:"accepts"`
+	// MinEvidenceBytes and MaxEvidenceBytes bound the size of the
+	// evidence the script accepts.
+	MinEvidenceBytes int64 `json:"min_evidence_bytes"`
+	MaxEvidenceBytes int64 `json:"max_evidence_bytes"`
+}
+
+// ReadRequirements returns the requirements declared in workspace, by
+// RequirementsFile or by a `sandbox:requires` line in the comments heading
+// a file at the workspace root. When several declare the same resource the
+// largest amount is kept.
+func ReadRequirements(workspace string) (Requirements, error) {
+	var req Requirements
+	entries, err := os.ReadDir(workspace)
+	if err != nil {
+		return req, err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(workspace, e.Name())
+		var r Requirements
+		if e.Name() == RequirementsFile {
+			r, err = readRequirementsManifest(path)
+		} else {
+			r, err = readRequiresDirective(path)
+		}
+		if err != nil {
+			return Requirements{}, fmt.Errorf("orchestrator: %s: %w", e.Name(), err)
+		}
+		req = req.union(r)
+	}
+	return req, nil
+}
+
+// readManifest parses the RequirementsFile at path.
+func readManifest(path string) (requirementsManifest, error) {
+	var m requirementsManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func readRequirementsManifest(path string) (Requirements, error) {
+	m, err := readManifest(path)
+	if err != nil {
+		return Requirements{}, err
+	}
+	var req Requirements
+	if m.Requires.Memory != "" {
+		if err := req.set("memory", m.Requires.Memory); err != nil {
+			return Requirements{}, err
+		}
+	}
+	if m.Requires.Timeout != "" {
+		if err := req.set("timeout", m.Requires.Timeout); err != nil {
+			return Requirements{}, err
+		}
+	}
+	if m.Requires.CPUs < 0 {
+		return Requirements{}, fmt.Errorf("invalid cpus %g", m.Requires.CPUs)
+	}
+	req.CPUs = m.Requires.CPUs
+	return req, nil
+}
+
+// readRequiresDirective parses the requirements line of the comment block
+// heading the file at path, if any.
+func readRequiresDirective(path string) (Requirements, error) {
+	args, err := headerDirective(path, requiresDirective)
+	if err != nil {
+		return Requirements{}, err
+	}
+	var req Requirements
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return Requirements{}, fmt.Errorf("%s: invalid argument %q, want key=value", requiresDirective, arg)
+		}
+		if err := req.set(key, value); err != nil {
+			return Requirements{}, fmt.Errorf("%s: %w", requiresDirective, err)
+		}
+	}
+	return req, nil
+}
+
+// headerDirective returns the arguments of the directive line in the
+// comment block heading the file at path, or nil if there is none.
+func headerDirective(path, directive string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		comment, ok := strings.CutPrefix(line, "//")
+		if !ok {
+			if comment, ok = strings.CutPrefix(line, "#"); !ok {
+				break
+			}
+		}
+		if args, ok := strings.CutPrefix(strings.TrimSpace(comment), directive); ok {
+			return strings.Fields(args), nil
+		}
+	}
+	// A binary file or a long first line is not a script header.
+	return nil, nil
+}
+
+// set parses the requirement key, "memory", "timeout" or "cpus".
+func (r *Requirements) set(key, value string) error {
+	switch key {
+	case "memory":
+		n, err := parseBytes(value)
+		if err != nil {
+			return fmt.Errorf("invalid memory %q: %w", value, err)
+		}
+		r.MemoryBytes = n
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q", value)
+		}
+		r.Timeout = d
+	case "cpus":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(n >= 0) {
+			return fmt.Errorf("invalid cpus %q", value)
+		}
+		r.CPUs = n
+	default:
+		return fmt.Errorf("unknown requirement %q", key)
+	}
+	return nil
+}
+
+// byteUnits are the size suffixes of parseBytes, as in Docker: K, M and G
+// are powers of 1024.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// parseBytes parses a size such as "4GB", "512m" or "1073741824".
+func parseBytes(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if num, ok := strings.CutSuffix(lower, u.suffix); ok {
+			lower, unit = strings.TrimSpace(num), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("not a size")
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// union returns the largest of each requirement of r and o.
+func (r Requirements) union(o Requirements) Requirements {
+	return Requirements{
+		MemoryBytes: max(r.MemoryBytes, o.MemoryBytes),
+		Timeout:     max(r.Timeout, o.Timeout),
+		CPUs:        max(r.CPUs, o.CPUs),
+	}
+}
+
+// apply raises the limits of cfg to the requirements.
+func (r Requirements) apply(cfg ExecConfig) ExecConfig {
+	if r.MemoryBytes > cfg.memoryLimit() {
+		cfg.MemoryLimitBytes = r.MemoryBytes
+	}
+	if r.Timeout > cfg.runTimeout() {
+		cfg.RunTimeout = r.Timeout
+	}
+	if r.CPUs > cfg.cpuQuota() {
+		cfg.CPUQuota = r.CPUs
+	}
+	return cfg
+}
+
+// unsatisfied describes the requirements beyond capacity, whose zero
+// fields are unlimited, or returns "" when capacity covers them.
+func (r Requirements) unsatisfied(capacity Requirements) string {
+	var over []string
+	if capacity.MemoryBytes > 0 && r.MemoryBytes > capacity.MemoryBytes {
+		over = append(over, fmt.Sprintf("memory %d bytes (at most %d)", r.MemoryBytes, capacity.MemoryBytes))
+	}
+	if capacity.Timeout > 0 && r.Timeout > capacity.Timeout {
+		over = append(over, fmt.Sprintf("timeout %s (at most %s)", r.Timeout, capacity.Timeout))
+	}
+	if capacity.CPUs > 0 && r.CPUs > capacity.CPUs {
+		over = append(over, fmt.Sprintf("%g CPUs (at most %g)", r.CPUs, capacity.CPUs))
+	}
+	return strings.Join(over, ", ")
+}
+
+// ResourceProfile is the default resources of the jobs run against one
+// type of evidence, in Runner.ResourceProfiles. A zero field keeps the
+// limit of the case or runner configuration.
+type ResourceProfile struct {
+	MemoryLimitBytes int64
+	Timeout          time.Duration
+	CPUQuota         float64
+}
+
+// apply sets the limits of cfg to those of the profile.
+func (p ResourceProfile) apply(cfg ExecConfig) ExecConfig {
+	if p.MemoryLimitBytes > 0 {
+		cfg.MemoryLimitBytes = p.MemoryLimitBytes
+	}
+	if p.Timeout > 0 {
+		cfg.RunTimeout = p.Timeout
+	}
+	if p.CPUQuota > 0 {
+		cfg.CPUQuota = p.CPUQuota
+	}
+	return cfg
+}
+
+// profiledConfig is the configuration of job before the requirements of
+// its script: its own, or that of its case with the limits of the
+// resource profile of its evidence type.
+func (r *Runner) profiledConfig(job Job) ExecConfig {
+	cfg := r.execConfig(job)
+	if job.Config == nil {
+		cfg = r.ResourceProfiles[strings.ToLower(job.Evidence.Type)].apply(cfg)
+	}
+	return cfg
+}
+
+// ceiling returns the most requirements may raise cfg to under r: the
+// larger of r and cfg's own limit, for each field r bounds.
+func (r Requirements) ceiling(cfg ExecConfig) Requirements {
+	if r.MemoryBytes > 0 && cfg.memoryLimit() > r.MemoryBytes {
+		r.MemoryBytes = cfg.memoryLimit()
+	}
+	if r.Timeout > 0 && cfg.runTimeout() > r.Timeout {
+		r.Timeout = cfg.runTimeout()
+	}
+	if r.CPUs > 0 && cfg.cpuQuota() > r.CPUs {
+		r.CPUs = cfg.cpuQuota()
+	}
+	return r
+}
+
+// jobConfig is the configuration job runs with: that of its case, with
+// the resource profile of its evidence type, or its own, with the limits
+// raised to the requirements of its script, up to Runner.MaxRequirements.
+func (r *Runner) jobConfig(job Job) (ExecConfig, error) {
+	req, err := ReadRequirements(job.Workspace)
+	if err != nil {
+		return ExecConfig{}, err
+	}
+	profiled := r.profiledConfig(job)
+	if over := req.unsatisfied(r.MaxRequirements.ceiling(profiled)); over != "" {
+		return ExecConfig{}, fmt.Errorf("%w: job %s requires %s", ErrUnsatisfiableRequirements, job.ID, over)
+	}
+	cfg := req.apply(profiled)
+	if cfg.OutputMode != "" {
+		if _, err := sandbox.ParseOutputMode(cfg.OutputMode); err != nil {
+			return ExecConfig{}, fmt.Errorf("orchestrator: %w", err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,173 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func writeWorkspace(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadRequirements(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  Requirements
+	}{
+		{"none", map[string]string{"main.go": "package main\n"}, Requirements{}},
+		{"go header", map[string]string{
+			"main.go": "// Command parser parses hives.\n//\n// sandbox:requires memory=4GB timeout=30m\npackage main\n",
+		}, Requirements{MemoryBytes: 4 << 30, Timeout: 30 * time.Minute}},
+		{"python header", map[string]string{
+			"main.py": "#!/usr/bin/env python3\n# sandbox:requires cpus=2 memory=512m\nimport sys\n",
+		}, Requirements{MemoryBytes: 512 << 20, CPUs: 2}},
+		{"after the header", map[string]string{
+			"main.go": "package main\n\n// sandbox:requires memory=4GB\n",
+		}, Requirements{}},
+		{"manifest", map[string]string{
+			RequirementsFile: `{"requires": {"memory": "2GiB", "timeout": "1h", "cpus": 1.5}}`,
+			"main.go":        "// sandbox:requires memory=3GB\npackage main\n",
+		}, Requirements{MemoryBytes: 3 << 30, Timeout: time.Hour, CPUs: 1.5}},
+	} {
+		got, err := ReadRequirements(writeWorkspace(t, tc.files))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("%s: requirements = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	for _, header := range []string{"memory=lots", "timeout=forever", "gpus=1", "memory"} {
+		ws := writeWorkspace(t, map[string]string{"main.go": "// sandbox:requires " + header + "\npackage main\n"})
+		if _, err := ReadRequirements(ws); err == nil {
+			t.Errorf("%q accepted", header)
+		}
+	}
+}
+
+func TestRunAppliesRequirements(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires memory=4GB cpus=0.5\npackage main\n"), 0o644)
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	// Requirements raise the limits but never lower them.
+	if spec := rt.lastSpec(); spec.MemoryBytes != 4<<30 || spec.CPUs != DefaultCPUQuota {
+		t.Errorf("limits = %d bytes, %v CPUs", spec.MemoryBytes, spec.CPUs)
+	}
+}
+
+func TestRunCapsRequirements(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.MaxRequirements = Requirements{MemoryBytes: 8 << 30, Timeout: 2 * time.Hour}
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires memory=64GB timeout=1h\npackage main\n"), 0o644)
+
+	_, err := r.Run(context.Background(), job)
+	if !errors.Is(err, ErrUnsatisfiableRequirements) || !strings.Contains(err.Error(), "memory 68719476736 bytes (at most 8589934592)") || len(rt.specs) != 0 {
+		t.Errorf("err = %v, %d containers, want an unsatisfiable memory requirement", err, len(rt.specs))
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	p.runner.MaxRequirements = r.MaxRequirements
+	if _, err := p.Run(context.Background(), poolJob(t, "case-1")); err != nil {
+		t.Fatal(err)
+	}
+	pjob := poolJob(t, "case-1")
+	os.WriteFile(filepath.Join(pjob.Workspace, "main.go"), []byte("// sandbox:requires timeout=3h\npackage main\n"), 0o644)
+	if _, err := p.Run(context.Background(), pjob); !errors.Is(err, ErrUnsatisfiableRequirements) {
+		t.Errorf("pool: err = %v, want ErrUnsatisfiableRequirements", err)
+	}
+
+	// A limit the configuration grants is not capped.
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires memory=16GB\npackage main\n"), 0o644)
+	job.Config = &ExecConfig{MemoryLimitBytes: 16 << 30}
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Errorf("configured limit: %v", err)
+	}
+}
+
+func TestJobConfigAppliesResourceProfiles(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.Defaults = ExecConfig{MemoryLimitBytes: 8 << 30, CPUQuota: 4}
+	r.ResourceProfiles = map[string]ResourceProfile{
+		sandbox.EvidenceTypeMemory:       {MemoryLimitBytes: 64 << 30, Timeout: 4 * time.Hour},
+		sandbox.EvidenceTypeRegistryHive: {MemoryLimitBytes: 512 << 20, CPUQuota: 1},
+	}
+	for _, tc := range []struct {
+		name     string
+		typ      string
+		config   *ExecConfig
+		requires string
+		memory   int64
+		cpus     float64
+		timeout  time.Duration
+	}{
+		{"memory", sandbox.EvidenceTypeMemory, nil, "", 64 << 30, 4, 4 * time.Hour},
+		{"hive", sandbox.EvidenceTypeRegistryHive, nil, "", 512 << 20, 1, DefaultRunTimeout},
+		{"no profile", sandbox.EvidenceTypePCAP, nil, "", 8 << 30, 4, DefaultRunTimeout},
+		{"job config", sandbox.EvidenceTypeMemory, &ExecConfig{MemoryLimitBytes: 1 << 30}, "", 1 << 30, DefaultCPUQuota, DefaultRunTimeout},
+		{"requirements", sandbox.EvidenceTypeRegistryHive, nil, "// sandbox:requires memory=2GB\n", 2 << 30, 1, DefaultRunTimeout},
+	} {
+		job := testJob(t)
+		job.Evidence.Type, job.Config = tc.typ, tc.config
+		os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte(tc.requires+"package main\n"), 0o644)
+		cfg, err := r.jobConfig(job)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if cfg.memoryLimit() != tc.memory || cfg.cpuQuota() != tc.cpus || cfg.runTimeout() != tc.timeout {
+			t.Errorf("%s: limits = %d bytes, %v CPUs, %s timeout", tc.name, cfg.memoryLimit(), cfg.cpuQuota(), cfg.runTimeout())
+		}
+	}
+}
+
+func TestWorkerPoolRejectsUnsatisfiableRequirements(t *testing.T) {
+	g := newGatedRunner()
+	g.releaseAll()
+	small, _ := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, Capacity: Requirements{MemoryBytes: 2 << 30}})
+	large, _ := NewWorkerPool(g, WorkerPoolConfig{MaxConcurrent: 1, Capacity: Requirements{MemoryBytes: 16 << 30, Timeout: time.Hour}})
+	defer small.Close()
+	defer large.Close()
+
+	job := Job{ID: "hives", Workspace: writeWorkspace(t, map[string]string{
+		"main.go": "// sandbox:requires memory=8GB timeout=2h\npackage main\n",
+	})}
+	_, err := small.Run(context.Background(), job)
+	if !errors.Is(err, ErrUnsatisfiableRequirements) || !strings.Contains(err.Error(), "memory 8589934592 bytes") {
+		t.Errorf("err = %v, want an unsatisfiable memory requirement", err)
+	}
+
+	s, err := NewScheduler(small, large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Run(context.Background(), job); !errors.Is(err, ErrUnsatisfiableRequirements) || !strings.Contains(err.Error(), "timeout 2h0m0s (at most 1h0m0s)") {
+		t.Errorf("err = %v, want an unsatisfiable timeout", err)
+	}
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires memory=8GB\npackage main\n"), 0o644)
+	if _, err := s.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if m := large.Metrics(); m.Completed != 1 || small.Metrics().Completed != 0 {
+		t.Errorf("large pool metrics = %+v, want the job placed on it", m)
+	}
+}
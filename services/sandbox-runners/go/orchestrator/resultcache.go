@@ -0,0 +1,225 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	resultFile      = "result.json"
+	cachedOutputDir = "output"
+	resultDirPrefix = ".result-"
+)
+
+// ResultCache keeps the results and outputs of successful jobs on a host
+// volume, keyed by a fingerprint of the script, its evidence and its
+// parameters, so that re-running a script on the same evidence returns
+// the earlier result instead of executing it again.
+type ResultCache struct {
+	// Dir holds one directory per cached result.
+	Dir string
+}
+
+// jobFingerprint hashes the workspace and image of job with the UID,
+// recorded digest and byte range of every evidence item, its YARA ruleset
+// and its parameters. It reports
+// false when an evidence item has no digest, as its content then cannot
+// be told apart from a re-ingested one.
+func jobFingerprint(job Job, image string) (string, bool) {
+	h := sha256.New()
+	script, err := workspaceKey(job.Workspace, image)
+	if err != nil {
+		return "", false
+	}
+	io.WriteString(h, script+"\x00"+languageKey(job.Language)+"\x00")
+	for _, ev := range job.allEvidence() {
+		if ev.SHA256 == "" {
+			return "", false
+		}
+		io.WriteString(h, ev.UID+"\x00"+ev.HashAlgo+"\x00"+ev.SHA256+"\x00"+ev.Compression+"\x00")
+		if ev.ranged() {
+			fmt.Fprintf(h, "range\x00%d\x00%d\x00", ev.Offset, ev.Length)
+		}
+	}
+	if job.YaraRules != "" {
+		rules, _, err := fileSHA256(job.YaraRules)
+		if err != nil {
+			return "", false
+		}
+		io.WriteString(h, "yara\x00"+rules+"\x00")
+	}
+	names := make([]string, 0, len(job.Params))
+	for k := range job.Params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		io.WriteString(h, k+"="+job.Params[k]+"\x00")
+	}
+	for _, tag := range job.BuildTags {
+		io.WriteString(h, "tag\x00"+tag+"\x00")
+	}
+	for _, m := range job.Replace {
+		io.WriteString(h, "replace\x00"+m.String()+"\x00")
+	}
+	// Secrets, e.g. API keys, are not expected to change the result; their
+	// values stay out of the key.
+	for _, name := range secretNames(job.Secrets) {
+		io.WriteString(h, "secret\x00"+name+"\x00")
+	}
+	if job.Seed != 0 {
+		fmt.Fprintf(h, "seed\x00%d\x00", job.Seed)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// jobSeed is job.Seed, or when zero a seed derived from the fingerprint of
+// job without its image, so that the same script, evidence and parameters
+// get the same seed across runs and image upgrades. Evidence without a
+// digest is identified by its UID instead.
+func jobSeed(job Job) int64 {
+	if job.Seed != 0 {
+		return job.Seed
+	}
+	key, ok := jobFingerprint(job, "")
+	if !ok {
+		key, _ = workspaceKey(job.Workspace, "")
+		key += "\x00" + languageKey(job.Language)
+		for _, ev := range job.allEvidence() {
+			key += "\x00" + ev.UID
+		}
+	}
+	sum := sha256.Sum256([]byte("seed\x00" + key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// lookup restores the outputs cached under key into job's OutputDir and
+// returns the cached result, relabelled for job.
+func (c *ResultCache) lookup(key string, job Job) (*JobResult, bool) {
+	entry := filepath.Join(c.Dir, key)
+	data, err := os.ReadFile(filepath.Join(entry, resultFile))
+	if err != nil {
+		return nil, false
+	}
+	var res JobResult
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, false
+	}
+	if err := copyOutputs(filepath.Join(entry, cachedOutputDir), job.OutputDir, res.Outputs); err != nil {
+		return nil, false
+	}
+	res.JobID = job.ID
+	res.FromCache, res.Attempts = true, 0
+	namespaceArtifacts(job, res.Artifacts)
+	res.Report = primaryReport(res.Artifacts)
+	for i := range res.Extracted {
+		res.Extracted[i].Path = filepath.Join(job.OutputDir, filepath.FromSlash(res.Extracted[i].Output))
+	}
+	return &res, true
+}
+
+// store saves res and the outputs of job under key, replacing an earlier
+// entry.
+func (c *ResultCache) store(key string, job Job, res *JobResult) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(c.Dir, resultDirPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := copyOutputs(job.OutputDir, filepath.Join(dir, cachedOutputDir), res.Outputs); err != nil {
+		return err
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, resultFile), data, 0o644); err != nil {
+		return err
+	}
+	final := filepath.Join(c.Dir, key)
+	if err := os.RemoveAll(final); err != nil {
+		return err
+	}
+	return os.Rename(dir, final)
+}
+
+// copyOutputs copies the outputs listed in files from src to dst.
+func copyOutputs(src, dst string, files []string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	for _, rel := range files {
+		to := filepath.Join(dst, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(src, filepath.FromSlash(rel)), to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedResult returns the result of an earlier identical job from the
+// ResultCache, and the fingerprint under which to store job's result. The
+// key is empty when the job is not cacheable.
+func (r *Runner) cachedResult(job Job) (*JobResult, string, error) {
+	c := r.ResultCache
+	// A job given the shared directory of its case depends on more than
+	// its fingerprint.
+	if c == nil || r.execConfig(job).SharedDir {
+		return nil, "", nil
+	}
+	p, err := profile(job.Language)
+	if err != nil {
+		return nil, "", nil
+	}
+	image := r.jobImage(job, p)
+	if job.Flavor != "" {
+		// The modules of a flavor are those the script builds against.
+		f, err := r.flavor(job.Flavor)
+		if err != nil {
+			return nil, "", err
+		}
+		image += "\x00flavor=" + r.Flavors.dependencyKey(f)
+	}
+	key, ok := jobFingerprint(job, image)
+	if !ok {
+		return nil, "", nil
+	}
+	if job.ForceRerun {
+		return nil, key, nil
+	}
+	res, ok := c.lookup(key, job)
+	if !ok {
+		return nil, key, nil
+	}
+	if job.LogDir != "" {
+		if err := writeJobLogs(job.LogDir, res.Stdout, res.Stderr); err != nil {
+			return nil, "", fmt.Errorf("store logs: %w", err)
+		}
+	}
+	r.notify(job, res)
+	return res, key, nil
+}
+
+// storeResult caches res under key when the job ran to completion. The
+// cache is best effort: a failure to store only costs a re-run.
+func (r *Runner) storeResult(key string, job Job, res *JobResult) {
+	// A job that fetched evidence or queried findings depends on more
+	// than its fingerprint.
+	if key == "" || !res.Success || res.Incomplete || res.OutputTruncated || len(res.FetchedEvidence) > 0 || res.FindingQueries > 0 {
+		return
+	}
+	r.ResultCache.store(key, job, res)
+}
@@ -0,0 +1,131 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobFingerprint(t *testing.T) {
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+	job.Params = map[string]string{"PARAM_YEAR": "2024"}
+
+	key := func(job Job) string {
+		t.Helper()
+		k, ok := jobFingerprint(job, "img")
+		if !ok {
+			t.Fatal("job is not cacheable")
+		}
+		return k
+	}
+	k1 := key(job)
+	if key(job) != k1 {
+		t.Error("fingerprint is not stable")
+	}
+	rehashed := job
+	rehashed.Evidence.SHA256 = "def456"
+	if key(rehashed) == k1 {
+		t.Error("fingerprint ignores the evidence digest")
+	}
+	other := job
+	other.Evidence.Offset = 512
+	if key(other) == k1 {
+		t.Error("fingerprint ignores the evidence range")
+	}
+	other = job
+	other.Params = map[string]string{"PARAM_YEAR": "2025"}
+	if key(other) == k1 {
+		t.Error("fingerprint ignores the parameters")
+	}
+	other = job
+	other.YaraRules = filepath.Join(t.TempDir(), "rules.yar")
+	os.WriteFile(other.YaraRules, []byte("rule a { condition: true }"), 0o644)
+	withRules := key(other)
+	os.WriteFile(other.YaraRules, []byte("rule b { condition: true }"), 0o644)
+	if withRules == k1 || key(other) == withRules {
+		t.Error("fingerprint ignores the YARA ruleset")
+	}
+	other = job
+	other.ID, other.OutputDir = "job-2", t.TempDir()
+	if key(other) != k1 {
+		t.Error("fingerprint depends on the job ID or output directory")
+	}
+
+	job.Evidence.SHA256 = ""
+	if _, ok := jobFingerprint(job, "img"); ok {
+		t.Error("evidence without a digest is cacheable")
+	}
+}
+
+func TestRunReturnsCachedResult(t *testing.T) {
+	rt := &fakeRuntime{onStart: writePartialOutput, stdout: "done\n"}
+	r := NewRunner(rt)
+	r.ResultCache = &ResultCache{Dir: t.TempDir()}
+	workspace := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "main.go"), []byte("package main"), 0o644)
+
+	run := func(id string, change func(*Job)) (*JobResult, Job) {
+		t.Helper()
+		job := testJob(t)
+		job.ID, job.Workspace = id, workspace
+		if change != nil {
+			change(&job)
+		}
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, job
+	}
+	if res, _ := run("job-1", nil); res.FromCache {
+		t.Error("first run served from the cache")
+	}
+
+	res, job := run("job-2", func(j *Job) { j.LogDir = t.TempDir() })
+	if !res.FromCache || res.JobID != "job-2" || res.Stdout != "done\n" {
+		t.Errorf("result = %+v, want job-1's result for job-2", res)
+	}
+	if len(rt.specs) != 1 {
+		t.Errorf("%d containers, want the second job not to run", len(rt.specs))
+	}
+	if data, err := os.ReadFile(filepath.Join(job.OutputDir, "partial.csv")); err != nil || string(data) != "a,b\n" {
+		t.Errorf("restored output = %q, %v", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(job.LogDir, StdoutLogFile)); string(data) != "done\n" {
+		t.Errorf("stdout log = %q", data)
+	}
+
+	if res, _ := run("job-3", func(j *Job) { j.Evidence.SHA256 = "def456" }); res.FromCache {
+		t.Error("result reused after the evidence digest changed")
+	}
+	if res, _ := run("job-4", func(j *Job) { j.ForceRerun = true }); res.FromCache {
+		t.Error("ForceRerun served from the cache")
+	}
+	if len(rt.specs) != 3 {
+		t.Errorf("%d containers, want 3", len(rt.specs))
+	}
+}
+
+func TestRunDoesNotCacheFailures(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 1}}
+	r := NewRunner(rt)
+	r.ResultCache = &ResultCache{Dir: t.TempDir()}
+	workspace := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		job := testJob(t)
+		job.Workspace = workspace
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.FromCache {
+			t.Error("failed job served from the cache")
+		}
+	}
+	if len(rt.specs) != 2 {
+		t.Errorf("%d containers, want 2", len(rt.specs))
+	}
+}
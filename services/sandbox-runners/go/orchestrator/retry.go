@@ -0,0 +1,86 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryPolicy retries jobs lost to the container engine. Only errors for
+// which Retryable holds are retried: once the script has started it may
+// have had side effects, so its failures are never retried, but for those
+// of a lost worker with RetryWorkerLost.
+type RetryPolicy struct {
+	// MaxAttempts bounds the number of runs of a job, the first included;
+	// zero or one disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each
+	// further one.
+	Backoff time.Duration
+	// RetryWorkerLost also runs again, within MaxAttempts, a job that
+	// failed with FailureWorkerLost, its OUTPUT_DIR emptied first. Only
+	// set it for scripts that can safely run twice: the lost run may have
+	// gone on for a while.
+	RetryWorkerLost bool
+}
+
+// InfraError is a failure of the container engine before the script
+// started: an image that could not be pulled, a daemon that timed out, a
+// container that could not be created or started.
+type InfraError struct {
+	Op  string
+	Err error
+}
+
+func (e *InfraError) Error() string { return e.Op + ": " + e.Err.Error() }
+
+func (e *InfraError) Unwrap() error { return e.Err }
+
+// Retryable reports whether a job whose Run failed with err may be run
+// again: the error is an InfraError, so the script never ran.
+func Retryable(err error) bool {
+	var ie *InfraError
+	return errors.As(err, &ie)
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// delay is the wait before the given attempt, counted from 1.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 2; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// startWithRetry starts job, retrying on Retryable errors according to
+// Runner.Retry, and returns the number of attempts made.
+func (r *Runner) startWithRetry(ctx context.Context, job Job) (*Execution, int, error) {
+	policy := r.Retry
+	for attempt := 1; ; attempt++ {
+		exec, err := r.Start(ctx, job)
+		if err == nil || !Retryable(err) || ctx.Err() != nil {
+			return exec, attempt, err
+		}
+		if attempt >= policy.maxAttempts() {
+			if attempt > 1 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt)
+			}
+			return nil, attempt, err
+		}
+		t := time.NewTimer(policy.delay(attempt + 1))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, attempt, err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	daemon := errors.New("docker create: exit status 125: Cannot connect to the Docker daemon")
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&InfraError{Op: "create container", Err: daemon}, true},
+		{fmt.Errorf("run job: %w", &InfraError{Op: "start container", Err: daemon}), true},
+		{daemon, false},
+		{ErrInvalidOutputDir, false},
+		{fmt.Errorf("wait container: %w", daemon), false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunRetriesInfrastructureFailures(t *testing.T) {
+	pull := errors.New("docker create: exit status 125: pull access denied")
+	rt := &fakeRuntime{createErrs: []error{pull, pull}}
+	r := NewRunner(rt)
+	r.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Attempts != 3 || !res.Success {
+		t.Errorf("result = %+v, want success on attempt 3", res)
+	}
+
+	rt.createErrs = []error{pull, pull}
+	r.Retry.MaxAttempts = 2
+	_, err = r.Run(context.Background(), testJob(t))
+	if !Retryable(err) || !errors.Is(err, pull) || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Errorf("Run() = %v, want the engine error after 2 attempts", err)
+	}
+}
+
+func TestRunDoesNotRetryScriptFailures(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 1}}
+	r := NewRunner(rt)
+	r.Retry = RetryPolicy{MaxAttempts: 3}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Attempts != 1 || len(rt.specs) != 1 {
+		t.Errorf("attempts = %d with %d containers, want 1", res.Attempts, len(rt.specs))
+	}
+
+	// Without a policy, an engine failure is returned as is.
+	rt.createErrs = []error{errors.New("daemon timeout")}
+	if _, err := NewRunner(rt).Run(context.Background(), testJob(t)); !Retryable(err) || len(rt.specs) != 1 {
+		t.Errorf("Run() = %v after %d containers, want one failed attempt", err, len(rt.specs))
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second}
+	for attempt, want := range map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second} {
+		if got := p.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Runner executes jobs in sandbox containers.
+type Runner struct {
+	Runtime Runtime
+	// Images overrides the runner image per language, e.g. with one
+	// built from an internal mirror; CheckImages verifies that the
+	// overrides still satisfy the runner contract.
+	Images map[string]string
+	// ImageDigests pins the runner image per language, as
+	// ExecConfig.ImageDigest does for the jobs whose configuration pins
+	// none.
+	ImageDigests map[string]string
+	// ArchImageDigests pins the runner image per language and
+	// architecture, e.g. the image IDs of the amd64 and arm64 variants of
+	// a multi-architecture tag, before ImageDigests.
+	ArchImageDigests map[string]map[string]string
+	// Architecture is that of the container engine's host, ArchAMD64 or
+	// ArchARM64; the orchestrator's own when empty, which a remote
+	// DOCKER_HOST may not share. Runner images are pulled for it, and an
+	// image built for another fails with ErrImageArchitecture.
+	Architecture string
+	// Defaults applies to jobs without a job or case configuration.
+	Defaults ExecConfig
+	// CaseConfigs holds per-case configuration keyed by case ID.
+	CaseConfigs map[string]ExecConfig
+	// ResourceProfiles sets the memory, timeout and CPU limits of the jobs
+	// without a job configuration by the type of their evidence, keyed by
+	// the sandbox EvidenceType constants, e.g. a larger memory limit for
+	// sandbox.EvidenceTypeMemory than for sandbox.EvidenceTypeRegistryHive.
+	// The requirements a script declares still raise them.
+	ResourceProfiles map[string]ResourceProfile
+	// MaxRequirements is the most the requirements a script declares may
+	// raise its limits to, e.g. the memory of the host; a zero field is
+	// unlimited, and a limit its configuration already grants is not
+	// capped. A job whose script requires more is rejected with
+	// ErrUnsatisfiableRequirements.
+	MaxRequirements Requirements
+	// Egress must be set for jobs to use NetworkAllowlist.
+	Egress *EgressConfig
+	// BuildCache, when set, compiles each distinct Go or Rust script once.
+	BuildCache *BuildCache
+	// WorkDir holds the per-job copies of the workspaces; os.TempDir()
+	// when empty. Like BuildCache.Dir, it must be visible to the Docker
+	// daemon at the same path.
+	WorkDir string
+	// SecretsDir holds the per-job files of Job.Secrets, WorkDir when
+	// empty. It should be a tmpfs, so that secrets are never written to
+	// disk, and like WorkDir it must be visible to the Docker daemon at the
+	// same path.
+	SecretsDir string
+	// ResultCache, when set, returns the result of an earlier identical
+	// job instead of running it again.
+	ResultCache *ResultCache
+	// Vendor configures how offline jobs are vendored.
+	Vendor *VendorConfig
+	// ReplaceAllowlist lists the module path prefixes that Job.Replace
+	// may point to, e.g. "git.lab.example/forks"; only workspace
+	// directories may replace modules when it is empty.
+	ReplaceAllowlist []string
+	// ModuleAllowlist, when set, lists the module path prefixes that the
+	// go.mod of Go jobs may require, e.g. "github.com/Velocidex"; modules
+	// replaced by a directory, such as the SDK, need not be listed.
+	ModuleAllowlist []string
+	// Flavors are the images with pre-downloaded modules that Go jobs may
+	// select with Job.Flavor.
+	Flavors *Flavors
+	// GoToolchains are the Go runner images, keyed by Go version, e.g.
+	// "1.22", that Go jobs may select with Job.GoVersion instead of the
+	// default image's DefaultGoVersion.
+	GoToolchains map[string]GoToolchain
+	// ParamPatterns is the allowlist of Job.Params names, as path.Match
+	// patterns; DefaultParamPatterns when nil.
+	ParamPatterns []string
+	// EvidenceCatalog resolves the evidence fetched by jobs run with
+	// ExecConfig.AllowEvidenceFetch.
+	EvidenceCatalog EvidenceCatalog
+	// FindingSource holds the findings queried by jobs run with
+	// ExecConfig.AllowFindingQuery.
+	FindingSource FindingSource
+	// ObjectStore reads the evidence at Evidence.URL; an HTTPObjectStore
+	// with http.DefaultClient when nil.
+	ObjectStore ObjectStore
+	// Tracer exports the spans of each job's phases; nothing is traced
+	// when nil.
+	Tracer *Tracer
+	// Fixtures holds the sample evidence that Job.EvidenceFixture selects.
+	Fixtures *FixtureRegistry
+	// Retry retries jobs whose container could not be created or
+	// started; jobs are run once when it is zero.
+	Retry RetryPolicy
+	// MetricsRecorder, when set, receives the result of every job.
+	MetricsRecorder MetricsRecorder
+	// Audit, when set, records every job that ran, cached results
+	// excepted.
+	Audit *AuditLog
+	// Signatures, when set, only runs the scripts it verifies.
+	Signatures *SignaturePolicy
+	// VulnCheck, when set, scans the dependencies of Go jobs for known
+	// vulnerabilities before they are built.
+	VulnCheck *VulnCheckConfig
+	// Jobs, when set, indexes every job that ran, cached results
+	// excepted, for JobIndex.Query.
+	Jobs *JobIndex
+	// Checkpoints, when set, keeps the checkpoints of the jobs that did
+	// not complete for the next run with the same fingerprint.
+	Checkpoints *CheckpointStore
+	// Replays, when set, keeps the inputs of every job that ran, cached
+	// results and cancelled jobs excepted, for Runner.Replay.
+	Replays *ReplayStore
+	// BlockDevices attaches the evidence of jobs run with
+	// ExecConfig.EvidenceBlockDevice; LoopDevices when nil.
+	BlockDevices BlockDeviceAttacher
+	// Overlays mounts the evidence of jobs run with
+	// ExecConfig.EvidenceOverlay; OverlayFS when nil.
+	Overlays EvidenceOverlayer
+	// EvidenceCache, when set, shares the evidence prepared for a job,
+	// decompressed or attached as a block device, with the next jobs on
+	// the same evidence.
+	EvidenceCache *EvidenceCache
+	// Shared holds the case directories of the jobs run with
+	// ExecConfig.SharedDir.
+	Shared *SharedStore
+	// Enrichment, when set, runs its enrichers over the findings and
+	// indicators of every job that completes, before they are recorded.
+	Enrichment *EnrichmentPipeline
+	// Callbacks, when set, notifies the Job.Callback of every job that
+	// finishes.
+	Callbacks *Callbacks
+	// StderrTailLines is the length of JobResult.StderrTail;
+	// DefaultStderrTailLines when zero.
+	StderrTailLines int
+
+	probeMu sync.Mutex
+	// probes caches the outcome of Probe per image ID.
+	probes map[string]*ImageProbe
+	// runtimes caches the OCI runtimes found by checkRuntime.
+	runtimes map[string]bool
+	// live holds the containers and directories of the jobs in flight,
+	// for Reconcile.
+	live liveSet
+}
+
+// NewRunner returns a Runner using rt with DefaultExecConfig defaults.
+func NewRunner(rt Runtime) *Runner {
+	return &Runner{Runtime: rt, Defaults: DefaultExecConfig()}
+}
+
+// execConfig resolves the configuration for job: the job's own, then its
+// case's, then the runner defaults.
+func (r *Runner) execConfig(job Job) ExecConfig {
+	if job.Config != nil {
+		return job.Config.migrate()
+	}
+	if cfg, ok := r.CaseConfigs[job.CaseID]; ok {
+		return cfg.migrate()
+	}
+	return r.Defaults.migrate()
+}
+
+func (r *Runner) image(language string, p runnerProfile) string {
+	if img, ok := r.Images[languageKey(language)]; ok {
+		return img
+	}
+	return p.Image
+}
+
+// containerEnv builds the environment contract consumed by the SDK.
+func containerEnv(job Job) map[string]string {
+	env := map[string]string{}
+	for k, v := range job.Params {
+		env[k] = v
+	}
+	env[sandbox.EnvCaseID] = job.CaseID
+	env[sandbox.EnvEvidenceUID] = job.Evidence.UID
+	env[sandbox.EnvOutputDir] = containerOutputDir
+	if !job.RunTime.IsZero() {
+		env[sandbox.EnvRunTime] = job.RunTime.UTC().Format(time.RFC3339Nano)
+	}
+	timezone, locale, _ := caseLocale(job)
+	if timezone != "" {
+		env[sandbox.EnvCaseTimezone] = timezone
+	}
+	if locale != "" {
+		env[sandbox.EnvCaseLocale] = locale
+	}
+	if job.Seed != 0 {
+		env[sandbox.EnvSeed] = strconv.FormatInt(job.Seed, 10)
+	}
+	if job.Evidence.Path != "" {
+		env[sandbox.EnvEvidencePath] = evidenceTarget(0, job.Evidence)
+	}
+	if len(job.ExtraEvidence) > 0 {
+		all := job.allEvidence()
+		env[sandbox.EnvEvidenceCount] = strconv.Itoa(len(all))
+		for i, ev := range all {
+			env[sandbox.IndexedEnv(sandbox.EnvEvidenceUID, i)] = ev.UID
+			if ev.Path != "" {
+				env[sandbox.IndexedEnv(sandbox.EnvEvidencePath, i)] = evidenceTarget(i, ev)
+			}
+			if ev.Type != "" {
+				env[sandbox.IndexedEnv(sandbox.EnvEvidenceType, i)] = ev.Type
+			}
+			if ev.compressed() {
+				env[sandbox.IndexedEnv(sandbox.EnvEvidenceCompression, i)] = strings.ToLower(ev.Compression)
+			}
+			rangeEnv(env, ev, func(key string) string { return sandbox.IndexedEnv(key, i) })
+			if ev.MetaPath != "" {
+				env[sandbox.IndexedEnv(sandbox.EnvEvidenceMetaPath, i)] = evidenceMetaTarget(i)
+			}
+		}
+	}
+	if job.Evidence.MetaPath != "" {
+		env[sandbox.EnvEvidenceMetaPath] = evidenceMetaTarget(0)
+	}
+	if job.Evidence.Type != "" {
+		env[sandbox.EnvEvidenceType] = job.Evidence.Type
+	}
+	if job.Evidence.compressed() {
+		env[sandbox.EnvEvidenceCompression] = strings.ToLower(job.Evidence.Compression)
+	}
+	rangeEnv(env, job.Evidence, func(key string) string { return key })
+	// OpenEvidence would hash all of streamed evidence.
+	if job.Evidence.SHA256 != "" && !job.Evidence.streamed() {
+		env[sandbox.EnvEvidenceSHA256] = job.Evidence.SHA256
+	}
+	if job.Evidence.HashAlgo != "" {
+		env[sandbox.EnvEvidenceHashAlgo] = job.Evidence.HashAlgo
+	}
+	if job.YaraRules != "" {
+		env[sandbox.EnvYaraRulesPath] = yaraTarget(job.YaraRules)
+	}
+	return env
+}
+
+// rangeEnv sets the byte range variables of ev, named by name.
+func rangeEnv(env map[string]string, ev Evidence, name func(string) string) {
+	if ev.Offset > 0 {
+		env[name(sandbox.EnvEvidenceOffset)] = strconv.FormatInt(ev.Offset, 10)
+	}
+	if ev.Length > 0 {
+		env[name(sandbox.EnvEvidenceLength)] = strconv.FormatInt(ev.Length, 10)
+	}
+}
+
+// jobEnv is the environment of job's script: the contract variables, the
+// container's resource limits and the toolchain settings of its language
+// and configuration.
+func jobEnv(job Job, cfg ExecConfig, p runnerProfile) map[string]string {
+	env := containerEnv(job)
+	env[sandbox.EnvMemoryLimitBytes] = strconv.FormatInt(cfg.memoryLimit(), 10)
+	env[sandbox.EnvCPUCount] = strconv.FormatFloat(cfg.cpuQuota(), 'g', -1, 64)
+	env[sandbox.EnvScratchDir] = containerScratch
+	recordLimitEnv(env, cfg)
+	if cfg.OutputMode != "" {
+		env[sandbox.EnvOutputMode] = cfg.OutputMode
+	}
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	if cfg.Offline && languageKey(job.Language) == LanguageGo {
+		for k, v := range offlineEnv {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// evidenceTarget is where the i-th evidence file appears inside the
+// container. Extra evidence gets its own directory so names cannot clash.
+func evidenceTarget(i int, ev Evidence) string {
+	if i == 0 {
+		return path.Join(containerEvidence, filepath.Base(ev.Path))
+	}
+	return path.Join(containerEvidence, strconv.Itoa(i), filepath.Base(ev.Path))
+}
+
+// yaraTarget is where the ruleset at rules appears inside the container.
+func yaraTarget(rules string) string {
+	return path.Join(containerYaraDir, filepath.Base(rules))
+}
+
+// containerSpec describes the container for job. Only /workspace, OUTPUT_DIR,
+// /tmp and the scratch area are writable; the evidence is too with
+// cfg.EvidenceWritable or cfg.EvidenceOverlay.
+func (r *Runner) containerSpec(job Job, cfg ExecConfig) (ContainerSpec, error) {
+	p, err := profile(job.Language)
+	if err != nil {
+		return ContainerSpec{}, err
+	}
+	mounts := []Mount{
+		{Source: job.Workspace, Target: containerWorkspace},
+		{Source: job.OutputDir, Target: containerOutputDir},
+	}
+	for i, ev := range job.allEvidence() {
+		if ev.Path == "" {
+			continue
+		}
+		mounts = append(mounts, Mount{
+			Source:   ev.Path,
+			Target:   evidenceTarget(i, ev),
+			ReadOnly: !cfg.EvidenceWritable && !cfg.EvidenceOverlay,
+		})
+	}
+	if job.YaraRules != "" {
+		mounts = append(mounts, Mount{Source: job.YaraRules, Target: yaraTarget(job.YaraRules), ReadOnly: true})
+	}
+	spec := ContainerSpec{
+		Image:          r.jobImage(job, p),
+		Cmd:            withBuildTags(p.Cmd, job.BuildTags),
+		Env:            jobEnv(job, cfg, p),
+		Mounts:         mounts,
+		WorkDir:        containerWorkspace,
+		User:           "sandbox",
+		ReadOnlyRootfs: true,
+		Tmpfs:          []string{containerTmp, cfg.scratchTmpfs()},
+		MemoryBytes:    cfg.memoryLimit(),
+		CPUs:           cfg.cpuQuota(),
+		Labels:         map[string]string{LabelJob: job.ID},
+	}
+	if err := applySecurity(&spec, cfg); err != nil {
+		return ContainerSpec{}, err
+	}
+	return spec, nil
+}
+
+// ErrInvalidOutputDir is returned for a job whose OutputDir is not an
+// existing directory. The job is a configuration failure: it is not run,
+// as it could not deliver any output.
+var ErrInvalidOutputDir = errors.New("orchestrator: invalid output directory")
+
+func validateJob(job Job) error {
+	switch {
+	case job.ID == "":
+		return errors.New("orchestrator: job ID is required")
+	case job.CaseID == "":
+		return errors.New("orchestrator: case ID is required")
+	case job.Evidence.UID == "":
+		return errors.New("orchestrator: evidence UID is required")
+	case job.Workspace == "" || job.OutputDir == "":
+		return errors.New("orchestrator: workspace and output directory are required")
+	}
+	if err := validateLabels(job.Labels); err != nil {
+		return err
+	}
+	if err := validateSecrets(job.Secrets); err != nil {
+		return err
+	}
+	if err := validateEncryption(job); err != nil {
+		return err
+	}
+	for i, ev := range job.ExtraEvidence {
+		if ev.UID == "" || ev.Path == "" && ev.URL == "" {
+			return fmt.Errorf("orchestrator: extra evidence %d needs a UID and a path", i)
+		}
+	}
+	for _, ev := range job.allEvidence() {
+		if ev.URL != "" && ev.Path != "" {
+			return fmt.Errorf("orchestrator: evidence %s: both a path and a URL", ev.UID)
+		}
+		if !sandbox.ValidCompression(ev.Compression) {
+			return fmt.Errorf("orchestrator: evidence %s: unsupported compression %q", ev.UID, ev.Compression)
+		}
+		if ev.Offset < 0 || ev.Length < 0 {
+			return fmt.Errorf("orchestrator: evidence %s: invalid range %d+%d", ev.UID, ev.Offset, ev.Length)
+		}
+	}
+	if job.YaraRules != "" {
+		if _, err := os.Stat(job.YaraRules); err != nil {
+			return fmt.Errorf("orchestrator: YARA rules: %w", err)
+		}
+	}
+	// Docker would create a missing bind mount source as an empty,
+	// root-owned directory that the script cannot write to.
+	info, err := os.Stat(job.OutputDir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOutputDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInvalidOutputDir, job.OutputDir)
+	}
+	return nil
+}
+
+// Run executes job to completion and returns its result. A job that
+// exceeds its RunTimeout or whose ctx is cancelled is stopped and reported
+// with TimedOut or Cancelled set, and one whose build exceeds its
+// BuildTimeout is reported with BuildTimedOut set, without running; what it wrote to OutputDir is still
+// collected. With a ResultCache, an identical job that already succeeded
+// is not run again: its result is returned with FromCache set.
+func (r *Runner) Run(ctx context.Context, job Job) (*JobResult, error) {
+	job, err := r.resolveFixture(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJob(job); err != nil {
+		return nil, err
+	}
+	job, err = resolveParams(job)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.validateParams(job.Params); err != nil {
+		return nil, err
+	}
+	if err := r.validateCallback(job.Callback); err != nil {
+		return nil, err
+	}
+	if err := r.validateBuild(job); err != nil {
+		return nil, err
+	}
+	// Start verifies the staged copy of the script; a cached result must
+	// not bypass the policy either.
+	if _, err := r.verifyScript(job.Workspace); err != nil {
+		return nil, err
+	}
+	ctx, span := r.Tracer.startJobSpan(ctx, job)
+	cached, key, err := r.cachedResult(job)
+	if cached != nil || err != nil {
+		span.endJob(cached, err)
+		return cached, err
+	}
+	// Every attempt of the job shares its logical start time and seed.
+	job.RunTime = runTime(job)
+	job.Seed = jobSeed(job)
+	res, err := r.run(ctx, job, key)
+	span.endJob(res, err)
+	return res, err
+}
+
+// runTime is job.RunTime, now when zero.
+func runTime(job Job) time.Time {
+	if job.RunTime.IsZero() {
+		return time.Now().UTC()
+	}
+	return job.RunTime
+}
+
+// run executes a validated job and caches its result under key.
+func (r *Runner) run(ctx context.Context, job Job, key string) (*JobResult, error) {
+	attempts := 0
+	for {
+		exec, n, err := r.startWithRetry(ctx, job)
+		attempts += n
+		if err != nil {
+			if res, ok := r.evidenceFailure(job, err); ok {
+				return res, nil
+			}
+			if res, ok := r.vulnerabilityFailure(job, err); ok {
+				return res, nil
+			}
+			if res, ok := r.buildTimeoutFailure(job, err); ok {
+				return res, nil
+			}
+			if ctx.Err() != nil {
+				// Cancelled while vendoring or compiling, before the job's
+				// container started.
+				res, err := r.cancelledResult(job)
+				if err == nil {
+					res.Attempts = attempts
+					r.record(job, res)
+				}
+				return res, err
+			}
+			return nil, err
+		}
+		exec.attempts = attempts
+		res, err := exec.Wait()
+		if err == nil && r.retryLost(ctx, job, res) {
+			continue
+		}
+		if err == nil {
+			r.storeResult(key, job, res)
+		}
+		return res, err
+	}
+}
+
+// retryLost reports whether job, whose run ended with res, is to run
+// again for its lost worker, by RetryPolicy.RetryWorkerLost. It waits for
+// the backoff and empties the job's OUTPUT_DIR first.
+func (r *Runner) retryLost(ctx context.Context, job Job, res *JobResult) bool {
+	if !res.WorkerLost || !r.Retry.RetryWorkerLost || res.Attempts >= r.Retry.maxAttempts() {
+		return false
+	}
+	t := time.NewTimer(r.Retry.delay(res.Attempts + 1))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+	}
+	return clearDir(job.OutputDir) == nil
+}
+
+func (r *Runner) cancelledResult(job Job) (*JobResult, error) {
+	res, err := r.result(job, r.execConfig(job), ContainerState{}, false, 0, "", "")
+	if err != nil {
+		return nil, err
+	}
+	res.markCancelled()
+	return res, nil
+}
+
+// record keeps or discards the checkpoint of job, adds job to the audit
+// log, the replay store and the job index, passes res to the MetricsRecorder and sends the
+// callback of job, if any.
+func (r *Runner) record(job Job, res *JobResult) {
+	r.keepCheckpoint(job, res)
+	if r.Audit != nil {
+		if err := r.Audit.Record(job, res); err != nil {
+			res.AuditError = err.Error()
+		}
+	}
+	if r.Replays != nil && !res.Cancelled {
+		if err := r.storeReplay(job, res); err != nil {
+			res.ReplayError = err.Error()
+		}
+	}
+	if r.Jobs != nil {
+		r.Jobs.add(job, res)
+	}
+	if r.MetricsRecorder != nil {
+		r.MetricsRecorder.RecordJob(job, res)
+	}
+	r.notify(job, res)
+}
+
+// notify sends the callback of job, which ended with res, if it has one.
+func (r *Runner) notify(job Job, res *JobResult) {
+	if r.Callbacks != nil && job.Callback != "" {
+		r.Callbacks.send(job, res)
+	}
+}
+
+// stop sends SIGTERM to the container and escalates to SIGKILL if it has
+// not exited once grace has elapsed.
+func (r *Runner) stop(ctx context.Context, id string, grace time.Duration) (ContainerState, error) {
+	if err := r.Runtime.Kill(ctx, id, "SIGTERM"); err != nil {
+		return ContainerState{}, err
+	}
+	graceCtx, cancel := context.WithTimeout(ctx, grace)
+	state, err := r.Runtime.Wait(graceCtx, id)
+	cancel()
+	if err == nil {
+		return state, nil
+	}
+	if err := r.Runtime.Kill(ctx, id, "SIGKILL"); err != nil {
+		return ContainerState{}, err
+	}
+	return r.Runtime.Wait(ctx, id)
+}
@@ -0,0 +1,486 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func testJob(t *testing.T) Job {
+	t.Helper()
+	return Job{
+		ID:     "job-1",
+		CaseID: "case-1",
+		Evidence: Evidence{
+			UID:    "ev-1",
+			Path:   "/lake/case-1/ev-1/disk.raw",
+			SHA256: "abc123",
+		},
+		Workspace: t.TempDir(),
+		OutputDir: t.TempDir(),
+	}
+}
+
+func TestRunPassesEvidenceDigest(t *testing.T) {
+	rt := &fakeRuntime{stdout: "hello\n"}
+	r := NewRunner(rt)
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stdout != "hello\n" || res.ExitCode != 0 {
+		t.Errorf("result = %+v", res)
+	}
+
+	env := rt.lastSpec().Env
+	want := map[string]string{
+		sandbox.EnvCaseID:         "case-1",
+		sandbox.EnvEvidenceUID:    "ev-1",
+		sandbox.EnvEvidencePath:   "/evidence/disk.raw",
+		sandbox.EnvOutputDir:      "/output",
+		sandbox.EnvEvidenceSHA256: "abc123",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("env[%s] = %q, want %q", k, env[k], v)
+		}
+	}
+	if _, ok := env[sandbox.EnvEvidenceHashAlgo]; ok {
+		t.Errorf("EVIDENCE_HASH_ALGO should be omitted when unset")
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("container not removed: %v", rt.removed)
+	}
+}
+
+func TestRunRejectsIncompleteJob(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	job := testJob(t)
+	job.CaseID = ""
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Fatal("expected validation error")
+	}
+}
+
+func TestRunRejectsInvalidOutputDir(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	file := filepath.Join(job.OutputDir, "file")
+	os.WriteFile(file, nil, 0o644)
+	for _, dir := range []string{filepath.Join(job.OutputDir, "missing"), file} {
+		job.OutputDir = dir
+		if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrInvalidOutputDir) {
+			t.Errorf("OutputDir %s: err = %v, want ErrInvalidOutputDir", dir, err)
+		}
+	}
+	if len(rt.specs) != 0 {
+		t.Errorf("%d containers created", len(rt.specs))
+	}
+}
+
+func TestRunTimeoutEscalatesToSIGKILL(t *testing.T) {
+	job := testJob(t)
+	rt := &fakeRuntime{
+		block:      true,
+		ignoreTerm: true,
+		onStart: func(ContainerSpec) {
+			os.WriteFile(filepath.Join(job.OutputDir, "partial.txt"), []byte("x"), 0644)
+		},
+	}
+	r := NewRunner(rt)
+	job.Config = &ExecConfig{RunTimeout: 20 * time.Millisecond, GracePeriod: 20 * time.Millisecond}
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut {
+		t.Error("TimedOut = false, want true")
+	}
+	if res.FailureReason != FailureRunTimeout || res.FailureDetail != "stopped after the 20ms run timeout" {
+		t.Errorf("failure = %s: %q", res.FailureReason, res.FailureDetail)
+	}
+	if want := []string{"SIGTERM", "SIGKILL"}; !reflect.DeepEqual(rt.signals, want) {
+		t.Errorf("signals = %v, want %v", rt.signals, want)
+	}
+	if want := []string{"partial.txt"}; !reflect.DeepEqual(res.Outputs, want) {
+		t.Errorf("outputs = %v, want %v", res.Outputs, want)
+	}
+}
+
+func TestRunTimeoutHonoursSIGTERM(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	r.CaseConfigs = map[string]ExecConfig{
+		"case-1": {RunTimeout: 20 * time.Millisecond, GracePeriod: time.Minute},
+	}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || res.ExitCode != 143 {
+		t.Errorf("result = %+v, want timed out with exit 143", res)
+	}
+	if want := []string{"SIGTERM"}; !reflect.DeepEqual(rt.signals, want) {
+		t.Errorf("signals = %v, want %v", rt.signals, want)
+	}
+}
+
+func TestRunTimeoutKeepsShutdownFindings(t *testing.T) {
+	job := testJob(t)
+	rt := &fakeRuntime{
+		block: true,
+		onTerm: func(ContainerSpec) {
+			line := `{"evidence_uid":"ev-1","severity":"high","title":"flushed on SIGTERM"}` + "\n"
+			os.WriteFile(filepath.Join(job.OutputDir, sandbox.ResultsFile), []byte(line), 0o644)
+		},
+	}
+	r := NewRunner(rt)
+	job.Config = &ExecConfig{RunTimeout: 20 * time.Millisecond, GracePeriod: time.Minute}
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.TimedOut || !res.Incomplete {
+		t.Errorf("timed out = %v, incomplete = %v", res.TimedOut, res.Incomplete)
+	}
+	if len(res.Findings) != 1 || res.Findings[0].Title != "flushed on SIGTERM" {
+		t.Errorf("findings = %+v, want the one flushed on SIGTERM", res.Findings)
+	}
+}
+
+func TestEvidenceMountedReadOnlyByDefault(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	job := testJob(t)
+
+	spec, err := r.containerSpec(job, r.execConfig(job))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.ReadOnlyRootfs {
+		t.Error("root filesystem should be read-only")
+	}
+	for _, m := range spec.Mounts {
+		wantRO := m.Target == "/evidence/disk.raw"
+		if m.ReadOnly != wantRO {
+			t.Errorf("mount %s ReadOnly = %v, want %v", m.Target, m.ReadOnly, wantRO)
+		}
+	}
+
+	cfg := DefaultExecConfig()
+	cfg.EvidenceWritable = true
+	spec, _ = r.containerSpec(job, cfg)
+	for _, m := range spec.Mounts {
+		if m.ReadOnly {
+			t.Errorf("mount %s should be writable with EvidenceWritable", m.Target)
+		}
+	}
+}
+
+func TestPartialConfigKeepsSecurityDefaults(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.Config = &ExecConfig{RunTimeout: time.Minute}
+	r.CaseConfigs = map[string]ExecConfig{"case-2": {MemoryLimitBytes: 1 << 30}}
+	for _, job := range []Job{job, {ID: "job-2", CaseID: "case-2", Evidence: job.Evidence, Workspace: t.TempDir(), OutputDir: t.TempDir()}} {
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+		spec := rt.lastSpec()
+		if !reflect.DeepEqual(spec.CapDrop, []string{"ALL"}) || !spec.NoNewPrivileges {
+			t.Errorf("%s: capabilities = %v, no-new-privileges = %v", job.ID, spec.CapDrop, spec.NoNewPrivileges)
+		}
+		for _, m := range spec.Mounts {
+			if m.Target == "/evidence/disk.raw" && !m.ReadOnly {
+				t.Errorf("%s: evidence mounted writable", job.ID)
+			}
+		}
+	}
+}
+
+func TestRunResourceLimits(t *testing.T) {
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 137, OOMKilled: true}}
+	r := NewRunner(rt)
+	cfg := DefaultExecConfig()
+	cfg.MemoryLimitBytes = 4 << 30
+	cfg.CPUQuota = 2.5
+	r.CaseConfigs = map[string]ExecConfig{"case-1": cfg}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OOMKilled || res.TimedOut {
+		t.Errorf("result = %+v, want OOM killed", res)
+	}
+	if res.FailureReason != FailureOOMKilled || res.FailureDetail != "exceeded the 4096 MiB memory limit" {
+		t.Errorf("failure = %s: %q", res.FailureReason, res.FailureDetail)
+	}
+	spec := rt.lastSpec()
+	if spec.MemoryBytes != 4<<30 || spec.CPUs != 2.5 {
+		t.Errorf("limits = %d bytes, %v CPUs", spec.MemoryBytes, spec.CPUs)
+	}
+	if spec.Env[sandbox.EnvMemoryLimitBytes] != "4294967296" || spec.Env[sandbox.EnvCPUCount] != "2.5" {
+		t.Errorf("limits env = %s, %s", spec.Env[sandbox.EnvMemoryLimitBytes], spec.Env[sandbox.EnvCPUCount])
+	}
+
+	spec, _ = r.containerSpec(testJob(t), ExecConfig{})
+	if spec.MemoryBytes != DefaultMemoryLimitBytes || spec.CPUs != DefaultCPUQuota {
+		t.Errorf("zero config limits = %d bytes, %v CPUs, want defaults", spec.MemoryBytes, spec.CPUs)
+	}
+}
+
+func TestContainerSpecScratchArea(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	cfg := DefaultExecConfig()
+	cfg.ScratchQuotaBytes = 64 << 20
+	spec, err := r.containerSpec(testJob(t), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/tmp", "/scratch:rw,size=67108864,mode=1777"}; !reflect.DeepEqual(spec.Tmpfs, want) {
+		t.Errorf("tmpfs = %v, want %v", spec.Tmpfs, want)
+	}
+	if spec.Env[sandbox.EnvScratchDir] != containerScratch {
+		t.Errorf("%s = %q, want %s", sandbox.EnvScratchDir, spec.Env[sandbox.EnvScratchDir], containerScratch)
+	}
+	for _, m := range spec.Mounts {
+		if m.Target == containerScratch {
+			t.Errorf("scratch area bind mounted from %s, want a tmpfs discarded with the container", m.Source)
+		}
+	}
+}
+
+func TestRunPassesRunTime(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.RunTime = time.Date(2026, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Env[sandbox.EnvRunTime]; got != "2026-03-01T09:00:00Z" {
+		t.Errorf("%s = %q", sandbox.EnvRunTime, got)
+	}
+
+	before := time.Now()
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := time.Parse(time.RFC3339Nano, rt.lastSpec().Env[sandbox.EnvRunTime])
+	if err != nil || got.Before(before.Add(-time.Second)) || got.After(time.Now()) {
+		t.Errorf("%s of a job without RunTime = %v, %v, want now", sandbox.EnvRunTime, got, err)
+	}
+}
+
+func TestRunPassesSeed(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	seed := func(job Job) string {
+		t.Helper()
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := rt.lastSpec().Env[sandbox.EnvSeed]
+		if got == "" || got != strconv.FormatInt(res.Seed, 10) {
+			t.Errorf("%s = %q, result seed %d", sandbox.EnvSeed, got, res.Seed)
+		}
+		return got
+	}
+	first := seed(testJob(t))
+	if again := seed(testJob(t)); again != first {
+		t.Errorf("seed %s, then %s for the same job", first, again)
+	}
+	other := testJob(t)
+	other.Evidence.SHA256 = "def456"
+	params := testJob(t)
+	params.Params = map[string]string{"PARAM_SAMPLE": "10"}
+	if seed(other) == first || seed(params) == first {
+		t.Error("seed ignores the evidence or parameters")
+	}
+	replay := testJob(t)
+	replay.Seed = 42
+	if got := seed(replay); got != "42" {
+		t.Errorf("seed of a replay = %s, want 42", got)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strconv.FormatInt(entries[0].Seed, 10); got != first || entries[len(entries)-1].Seed != 42 {
+		t.Errorf("audit seeds %s and %d", got, entries[len(entries)-1].Seed)
+	}
+}
+
+func TestRunPassesOutputMode(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := rt.lastSpec().Env[sandbox.EnvOutputMode]; ok {
+		t.Errorf("%s = %q without ExecConfig.OutputMode", sandbox.EnvOutputMode, got)
+	}
+	r.CaseConfigs = map[string]ExecConfig{"case-1": {OutputMode: "0640"}}
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Env[sandbox.EnvOutputMode]; got != "0640" {
+		t.Errorf("%s = %q, want 0640", sandbox.EnvOutputMode, got)
+	}
+	for _, mode := range []string{"0666", "0644x", "4755"} {
+		r.CaseConfigs["case-1"] = ExecConfig{OutputMode: mode}
+		if _, err := r.Run(context.Background(), testJob(t)); !errors.Is(err, sandbox.ErrInvalidOutputMode) {
+			t.Errorf("Run() with mode %s = %v, want ErrInvalidOutputMode", mode, err)
+		}
+	}
+}
+
+func TestRunSelectsImageByLanguage(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Images = map[string]string{LanguagePython: "mirror/python:3.12"}
+
+	for _, tc := range []struct {
+		language, image string
+		cmd             []string
+	}{
+		{"", "datamortem-sandbox-go:1.21", goRunCmd("go", "run", ".")},
+		{"go", "datamortem-sandbox-go:1.21", goRunCmd("go", "run", ".")},
+		{"Python", "mirror/python:3.12", []string{"python", "script.py"}},
+		{"node", "datamortem-sandbox-node:20", []string{"node", "script.js"}},
+		{"rust", "datamortem-sandbox-rust:1.75", []string{"cargo", "run", "--release", "--quiet"}},
+		{"PowerShell", "datamortem-sandbox-powershell:7.4", []string{"pwsh", "-NoLogo", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", "script.ps1"}},
+		{"bash", "datamortem-sandbox-shell:3.20", []string{"bash", "-c", bashRunner, "script.sh"}},
+		{"java", "datamortem-sandbox-java:21", []string{"sh", "-c", javaRunner, "java"}},
+	} {
+		job := testJob(t)
+		job.Language = tc.language
+		res, err := r.Run(context.Background(), job)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.language, err)
+		}
+		spec := rt.lastSpec()
+		if res.Image != tc.image || spec.Image != fakeImageID(tc.image) || !reflect.DeepEqual(spec.Cmd, tc.cmd) {
+			t.Errorf("%q: image %s (%s) cmd %v, want %s %v", tc.language, res.Image, spec.Image, spec.Cmd, tc.image, tc.cmd)
+		}
+	}
+
+	job := testJob(t)
+	job.Language = "cobol"
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("expected error for unsupported language")
+	}
+}
+
+func TestRunMountsExtraEvidence(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/case-1/ev-2/disk.raw"}}
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	want := map[string]string{
+		"EVIDENCE_COUNT":  "2",
+		"EVIDENCE_UID_0":  "ev-1",
+		"EVIDENCE_PATH_0": "/evidence/disk.raw",
+		"EVIDENCE_UID_1":  "ev-2",
+		"EVIDENCE_PATH_1": "/evidence/1/disk.raw",
+		"EVIDENCE_PATH":   "/evidence/disk.raw",
+	}
+	for k, v := range want {
+		if spec.Env[k] != v {
+			t.Errorf("env[%s] = %q, want %q", k, spec.Env[k], v)
+		}
+	}
+	var targets []string
+	for _, m := range spec.Mounts {
+		if m.ReadOnly {
+			targets = append(targets, m.Target)
+		}
+	}
+	if want := []string{"/evidence/disk.raw", "/evidence/1/disk.raw", containerContextDir}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("read-only mounts = %v, want %v", targets, want)
+	}
+}
+
+func TestRunPassesEvidenceRange(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.Evidence.Offset, job.Evidence.Length = 1048576, 4096
+	job.ExtraEvidence = []Evidence{{UID: "ev-2", Path: "/lake/case-1/ev-2/disk.raw", Offset: 512}}
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	env := rt.lastSpec().Env
+	want := map[string]string{
+		"EVIDENCE_OFFSET":   "1048576",
+		"EVIDENCE_LENGTH":   "4096",
+		"EVIDENCE_OFFSET_0": "1048576",
+		"EVIDENCE_LENGTH_0": "4096",
+		"EVIDENCE_OFFSET_1": "512",
+		"EVIDENCE_LENGTH_1": "",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("env[%s] = %q, want %q", k, env[k], v)
+		}
+	}
+
+	job.Evidence.Length = -1
+	if _, err := r.Run(context.Background(), job); err == nil || !strings.Contains(err.Error(), "invalid range") {
+		t.Errorf("err = %v, want an invalid range", err)
+	}
+}
+
+func TestRunMountsYaraRules(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	job := testJob(t)
+	job.YaraRules = filepath.Join(t.TempDir(), "triage.yar")
+	os.WriteFile(job.YaraRules, []byte("rule r { condition: true }"), 0o644)
+
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	if got := spec.Env[sandbox.EnvYaraRulesPath]; got != "/yara/triage.yar" {
+		t.Errorf("%s = %q", sandbox.EnvYaraRulesPath, got)
+	}
+	want := Mount{Source: job.YaraRules, Target: "/yara/triage.yar", ReadOnly: true}
+	if !slices.Contains(spec.Mounts, want) {
+		t.Errorf("mounts = %+v, want %+v", spec.Mounts, want)
+	}
+
+	job.YaraRules += ".missing"
+	if _, err := r.Run(context.Background(), job); err == nil {
+		t.Error("missing ruleset should fail")
+	}
+}
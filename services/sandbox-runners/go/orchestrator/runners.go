@@ -0,0 +1,101 @@
+package orchestrator
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Features of a runner language, in RunnerInfo.Features.
+const (
+	// FeatureBuildCache: Runner.BuildCache compiles a script once for all
+	// the jobs that run it.
+	FeatureBuildCache = "build_cache"
+	// FeatureSDK: the image ships the datamortem SDK, the sandbox package.
+	FeatureSDK = "sdk"
+	// FeatureOffline: ExecConfig.Offline builds scripts from a vendor tree.
+	FeatureOffline = "offline"
+	// FeatureBuildConfig: jobs may set Job.BuildTags and Job.Replace.
+	FeatureBuildConfig = "build_config"
+	// FeatureFlavors: jobs may select an image of Runner.Flavors.
+	FeatureFlavors = "flavors"
+	// FeatureGoVersions: jobs may select a toolchain of
+	// Runner.GoToolchains with Job.GoVersion.
+	FeatureGoVersions = "go_versions"
+	// FeatureShellFailure: a failed command is reported in
+	// JobResult.ShellFailure.
+	FeatureShellFailure = "shell_failure"
+)
+
+// RunnerInfo describes a runner language for the clients submitting jobs,
+// e.g. to build a submission form.
+type RunnerInfo struct {
+	Language string
+	// Image is the runner image, from Runner.Images or the Makefile tag, and
+	// ImageDigest its ID, empty when it could not be resolved.
+	Image       string
+	ImageDigest string
+	// Ready reports that the image passed Runner.Probe; Problem tells why
+	// it did not, or why it could not be probed.
+	Ready     bool
+	Toolchain string
+	Problem   string
+	// EvidenceTypes are the evidence types the runner can be given; a
+	// script narrows them in its manifest.
+	EvidenceTypes []string
+	// Timeout, MemoryLimitBytes, CPUQuota and Network are the defaults of
+	// jobs without a case or job configuration, from Runner.Defaults.
+	Timeout          time.Duration
+	MemoryLimitBytes int64
+	CPUQuota         float64
+	Network          NetworkMode
+	// Features lists the Feature constants the language supports.
+	Features []string
+	// GoVersions are the Job.GoVersion a Go job may select, from
+	// Runner.GoVersions.
+	GoVersions []string
+}
+
+// Runners describes every language the runner has a profile for, sorted
+// by name, probing its image with Probe: a language added to the runner
+// profiles, with its Dockerfile and Makefile target, is listed without
+// further change. Probes are cached per image ID, so listing the runners
+// again only probes images that changed.
+func (r *Runner) Runners(ctx context.Context) []RunnerInfo {
+	languages := make([]string, 0, len(runnerProfiles))
+	for lang := range runnerProfiles {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	cfg := r.Defaults
+	infos := make([]RunnerInfo, 0, len(languages))
+	for _, lang := range languages {
+		p := runnerProfiles[lang]
+		info := RunnerInfo{
+			Language:         lang,
+			Image:            r.image(lang, p),
+			EvidenceTypes:    sandbox.EvidenceTypes(),
+			Timeout:          cfg.runTimeout(),
+			MemoryLimitBytes: cfg.memoryLimit(),
+			CPUQuota:         cfg.cpuQuota(),
+			Network:          cfg.networkMode(),
+		}
+		if p.Build != nil {
+			info.Features = append(info.Features, FeatureBuildCache)
+		}
+		info.Features = append(info.Features, p.Features...)
+		if lang == LanguageGo {
+			info.GoVersions = r.GoVersions()
+		}
+		probe, err := r.Probe(ctx, lang)
+		if probe != nil {
+			info.ImageDigest, info.Ready, info.Toolchain, info.Problem = probe.ImageDigest, probe.Ready, probe.Toolchain, probe.Problem
+		} else if err != nil {
+			info.Problem = err.Error()
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,60 @@
+package orchestrator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestRunners(t *testing.T) {
+	python := fakeImageID("datamortem-sandbox-python:3.11")
+	rt := &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Image == python {
+			return ContainerState{ExitCode: 1}, "/output is not writable\n"
+		}
+		return ContainerState{}, ""
+	}}
+	r := NewRunner(rt)
+	r.Images = map[string]string{LanguageGo: "registry.corp/sandbox-go:1.22"}
+
+	infos := r.Runners(context.Background())
+	if len(infos) != len(runnerProfiles) {
+		t.Fatalf("%d runners, want %d", len(infos), len(runnerProfiles))
+	}
+	byLang := map[string]RunnerInfo{}
+	for i, info := range infos {
+		if i > 0 && infos[i-1].Language >= info.Language {
+			t.Errorf("runners not sorted: %s before %s", infos[i-1].Language, info.Language)
+		}
+		byLang[info.Language] = info
+	}
+	for _, lang := range []string{LanguageBash, LanguageGo, LanguageJava, LanguageNode, LanguagePowerShell, LanguagePython, LanguageRust} {
+		if _, ok := byLang[lang]; !ok {
+			t.Errorf("%s not listed", lang)
+		}
+	}
+
+	goInfo := byLang[LanguageGo]
+	if !goInfo.Ready || goInfo.Image != "registry.corp/sandbox-go:1.22" || goInfo.ImageDigest != fakeImageID(goInfo.Image) {
+		t.Errorf("go runner %+v", goInfo)
+	}
+	if want := []string{FeatureBuildCache, FeatureSDK, FeatureOffline, FeatureBuildConfig, FeatureFlavors, FeatureGoVersions}; !reflect.DeepEqual(goInfo.Features, want) {
+		t.Errorf("go features %v, want %v", goInfo.Features, want)
+	}
+	if goInfo.Timeout != DefaultRunTimeout || goInfo.MemoryLimitBytes != DefaultMemoryLimitBytes || goInfo.Network != NetworkNone || len(goInfo.EvidenceTypes) == 0 {
+		t.Errorf("go defaults %+v", goInfo)
+	}
+	if p := byLang[LanguagePython]; p.Ready || p.Problem == "" || p.ImageDigest != python || len(p.Features) != 0 {
+		t.Errorf("python runner %+v, want not ready", p)
+	}
+	if f := byLang[LanguageBash].Features; !reflect.DeepEqual(f, []string{FeatureShellFailure}) {
+		t.Errorf("bash features %v", f)
+	}
+
+	// The probes are cached per image.
+	created := len(rt.specs)
+	r.Runners(context.Background())
+	if len(rt.specs) != created {
+		t.Errorf("listing again created %d containers", len(rt.specs)-created)
+	}
+}
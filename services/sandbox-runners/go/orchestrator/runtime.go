@@ -0,0 +1,43 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+)
+
+// Runtime is the container engine used to run sandbox jobs. DockerRuntime
+// is the production implementation; tests substitute a fake.
+type Runtime interface {
+	Create(ctx context.Context, spec ContainerSpec) (id string, err error)
+	Start(ctx context.Context, id string) error
+	// Wait blocks until the container exits.
+	Wait(ctx context.Context, id string) (ContainerState, error)
+	// Kill sends signal (e.g. "SIGTERM") to the container's main process.
+	Kill(ctx context.Context, id, signal string) error
+	// Logs copies everything the container has written so far.
+	Logs(ctx context.Context, id string, stdout, stderr io.Writer) error
+	// Follow copies the container's output as it is produced and returns
+	// once the container exits.
+	Follow(ctx context.Context, id string, stdout, stderr io.Writer) error
+	// Exec runs a command in a running container and returns its exit
+	// code.
+	Exec(ctx context.Context, id string, spec ExecSpec, stdout, stderr io.Writer) (int, error)
+	// Attach copies stdin to the main process of a running container
+	// created Interactive, closing its stdin once stdin ends, and returns
+	// once stdin ends or the container exits.
+	Attach(ctx context.Context, id string, stdin io.Reader) error
+	Remove(ctx context.Context, id string) error
+	// Containers lists the containers, running or not, that have label,
+	// by ID, with the value of label.
+	Containers(ctx context.Context, label string) (map[string]string, error)
+	// InspectImage resolves image, pulling it if it is not present:
+	// platform, e.g. "linux/arm64", selects the variant of a
+	// multi-architecture image to pull, the engine's own when empty.
+	InspectImage(ctx context.Context, image, platform string) (ImageInfo, error)
+	// BuildImage builds and tags an image, reusing the layers the engine
+	// has cached.
+	BuildImage(ctx context.Context, spec ImageBuildSpec) error
+	// Runtimes lists the OCI runtimes containers can be created with,
+	// e.g. "runc" and "runsc".
+	Runtimes(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,204 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SeccompUnconfined in ExecConfig.SeccompProfile disables syscall
+// filtering.
+const SeccompUnconfined = "unconfined"
+
+// seccompProfile is the subset of the Docker seccomp profile format used by
+// the built-in profile.
+type seccompProfile struct {
+	DefaultAction   string            `json:"defaultAction"`
+	DefaultErrnoRet int               `json:"defaultErrnoRet"`
+	ArchMap         []seccompArch     `json:"archMap"`
+	Syscalls        []seccompSyscalls `json:"syscalls"`
+}
+
+type seccompArch struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures"`
+}
+
+type seccompSyscalls struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet int          `json:"errnoRet,omitempty"`
+	Args     []seccompArg `json:"args,omitempty"`
+}
+
+type seccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// seccompAllowed are the syscalls a parser, its interpreter or the Go
+// toolchain need: file I/O, memory mapping, threads, signals, timers and
+// process management. ptrace, mount, namespaces, kernel modules, BPF and
+// keyrings are absent and fail with EPERM.
+var seccompAllowed = []string{
+	"access", "alarm", "arch_prctl", "brk", "capget", "chdir", "chmod",
+	"chown", "chown32", "clock_getres", "clock_getres_time64",
+	"clock_gettime", "clock_gettime64", "clock_nanosleep",
+	"clock_nanosleep_time64", "close", "close_range", "copy_file_range",
+	"creat", "dup", "dup2", "dup3", "epoll_create", "epoll_create1",
+	"epoll_ctl", "epoll_pwait", "epoll_pwait2", "epoll_wait", "eventfd",
+	"eventfd2", "execve", "execveat", "exit", "exit_group", "faccessat",
+	"faccessat2", "fadvise64", "fadvise64_64", "fallocate", "fchdir",
+	"fchmod", "fchmodat", "fchmodat2", "fchown", "fchown32", "fchownat",
+	"fcntl", "fcntl64", "fdatasync", "fgetxattr", "flistxattr", "flock", "fork",
+	"fstat", "fstat64", "fstatat64", "fstatfs", "fstatfs64", "fsync",
+	"ftruncate", "ftruncate64", "futex", "futex_time64", "futex_waitv",
+	"getcpu", "getcwd", "getdents", "getdents64", "getegid", "getegid32",
+	"geteuid", "geteuid32", "getgid", "getgid32", "getgroups",
+	"getgroups32", "getitimer", "getpgid", "getpgrp", "getpid", "getppid",
+	"getpriority", "getrandom", "getresgid", "getresgid32", "getresuid",
+	"getresuid32", "getrlimit", "get_robust_list", "getrusage", "getsid",
+	"gettid", "gettimeofday", "getuid", "getuid32", "getxattr",
+	"inotify_add_watch", "inotify_init", "inotify_init1",
+	"inotify_rm_watch", "io_cancel", "io_destroy", "io_getevents",
+	"io_pgetevents", "io_setup", "io_submit", "ioctl", "ioprio_get",
+	"kill", "lchown", "lchown32", "lgetxattr", "link", "linkat",
+	"listxattr", "llistxattr", "_llseek", "lseek", "lstat", "lstat64",
+	"madvise", "membarrier", "memfd_create", "mincore", "mkdir", "mkdirat",
+	"mlock", "mlock2", "mlockall", "mmap", "mmap2", "mprotect", "mremap",
+	"msync", "munlock", "munlockall", "munmap", "nanosleep", "newfstatat",
+	"_newselect", "open", "openat", "openat2", "pause", "pipe", "pipe2",
+	"poll", "ppoll", "ppoll_time64", "prctl", "pread64", "preadv",
+	"preadv2", "prlimit64", "pselect6", "pselect6_time64", "pwrite64",
+	"pwritev", "pwritev2", "read", "readahead", "readlink", "readlinkat",
+	"readv", "rename", "renameat", "renameat2", "restart_syscall", "rmdir",
+	"rseq", "rt_sigaction", "rt_sigpending", "rt_sigprocmask",
+	"rt_sigqueueinfo", "rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait",
+	"rt_sigtimedwait_time64", "rt_tgsigqueueinfo", "sched_getaffinity",
+	"sched_getattr", "sched_getparam", "sched_get_priority_max",
+	"sched_get_priority_min", "sched_getscheduler", "sched_rr_get_interval",
+	"sched_setaffinity", "sched_yield", "select", "sendfile", "sendfile64",
+	"set_robust_list", "set_thread_area", "set_tid_address", "setitimer",
+	"setpgid", "setrlimit", "setsid", "sigaltstack", "signalfd", "signalfd4",
+	"splice",
+	"stat", "stat64", "statfs", "statfs64", "statx", "symlink", "symlinkat",
+	"sync", "sync_file_range", "syncfs", "sysinfo", "tee", "tgkill", "time",
+	"timer_create", "timer_delete", "timer_getoverrun", "timer_gettime",
+	"timer_gettime64", "timer_settime", "timer_settime64", "timerfd_create",
+	"timerfd_gettime", "timerfd_gettime64", "timerfd_settime",
+	"timerfd_settime64", "tkill", "truncate", "truncate64", "umask",
+	"uname", "unlink", "unlinkat", "utime", "utimensat", "utimensat_time64",
+	"utimes", "vfork", "wait4", "waitid", "write", "writev",
+}
+
+// seccompNetwork are the socket syscalls, allowed only for jobs that have
+// a network.
+var seccompNetwork = []string{
+	"accept", "accept4", "bind", "connect", "getpeername", "getsockname",
+	"getsockopt", "listen", "recvfrom", "recvmmsg", "recvmmsg_time64",
+	"recvmsg", "sendmmsg", "sendmsg", "sendto", "setsockopt", "shutdown",
+	"socket", "socketcall", "socketpair",
+}
+
+// cloneNamespaceFlags are the CLONE_NEW* flags; clone is only allowed
+// without them so that a job cannot create namespaces.
+const cloneNamespaceFlags = 0x7E020000
+
+// afUnix is the AF_UNIX socket domain.
+const afUnix = 1
+
+// defaultSeccompProfile renders the built-in profile as Docker JSON.
+func defaultSeccompProfile(network bool) string {
+	return renderSeccompProfile(network, false)
+}
+
+// unixSocketSeccompProfile is the built-in profile of a job without
+// network that may still use unix sockets, to reach the orchestrator's
+// evidence fetch socket.
+func unixSocketSeccompProfile() string {
+	return renderSeccompProfile(false, true)
+}
+
+func renderSeccompProfile(network, unixSockets bool) string {
+	allowed := append([]string(nil), seccompAllowed...)
+	if network {
+		allowed = append(allowed, seccompNetwork...)
+	}
+	var extra []seccompSyscalls
+	if unixSockets && !network {
+		for _, name := range seccompNetwork {
+			// socketcall multiplexes every socket call on 32-bit x86
+			// and cannot be filtered by domain.
+			if name != "socket" && name != "socketcall" {
+				allowed = append(allowed, name)
+			}
+		}
+		extra = append(extra, seccompSyscalls{
+			Names:  []string{"socket"},
+			Action: "SCMP_ACT_ALLOW",
+			Args:   []seccompArg{{Index: 0, Value: afUnix, Op: "SCMP_CMP_EQ"}},
+		})
+	}
+	p := seccompProfile{
+		DefaultAction:   "SCMP_ACT_ERRNO",
+		DefaultErrnoRet: 1, // EPERM
+		ArchMap: []seccompArch{
+			{"SCMP_ARCH_X86_64", []string{"SCMP_ARCH_X86", "SCMP_ARCH_X32"}},
+			{"SCMP_ARCH_AARCH64", []string{"SCMP_ARCH_ARM"}},
+		},
+		Syscalls: []seccompSyscalls{
+			{Names: allowed, Action: "SCMP_ACT_ALLOW"},
+			{
+				Names:  []string{"clone"},
+				Action: "SCMP_ACT_ALLOW",
+				Args:   []seccompArg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}},
+			},
+			// clone3 flags cannot be inspected; ENOSYS makes libc and
+			// the Go runtime fall back to clone.
+			{Names: []string{"clone3"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: 38},
+		},
+	}
+	p.Syscalls = append(p.Syscalls, extra...)
+	data, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// seccompFor returns the seccomp profile of a container running with cfg:
+// the built-in one, SeccompUnconfined, or the JSON of cfg.SeccompProfile.
+func seccompFor(cfg ExecConfig) (string, error) {
+	switch cfg.SeccompProfile {
+	case "":
+		return defaultSeccompProfile(cfg.networkMode() != NetworkNone), nil
+	case SeccompUnconfined:
+		return SeccompUnconfined, nil
+	}
+	data, err := os.ReadFile(cfg.SeccompProfile)
+	if err != nil {
+		return "", fmt.Errorf("orchestrator: read seccomp profile: %w", err)
+	}
+	if !json.Valid(data) {
+		return "", fmt.Errorf("orchestrator: seccomp profile %s is not valid JSON", cfg.SeccompProfile)
+	}
+	return string(data), nil
+}
+
+// applySecurity sets the seccomp profile, capabilities and OCI runtime of
+// spec.
+func applySecurity(spec *ContainerSpec, cfg ExecConfig) error {
+	profile, err := seccompFor(cfg)
+	if err != nil {
+		return err
+	}
+	spec.SeccompProfile = profile
+	spec.Runtime = cfg.Runtime
+	if !cfg.KeepCapabilities {
+		spec.CapDrop = []string{"ALL"}
+		spec.NoNewPrivileges = true
+	}
+	return nil
+}
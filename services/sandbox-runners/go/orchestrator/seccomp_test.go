@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// seccompRules maps each syscall named in profile to its action.
+func seccompRules(t *testing.T, profile string) map[string]string {
+	t.Helper()
+	var p seccompProfile
+	if err := json.Unmarshal([]byte(profile), &p); err != nil {
+		t.Fatalf("profile is not valid JSON: %v", err)
+	}
+	if p.DefaultAction != "SCMP_ACT_ERRNO" {
+		t.Errorf("default action = %s", p.DefaultAction)
+	}
+	rules := map[string]string{}
+	for _, s := range p.Syscalls {
+		for _, name := range s.Names {
+			rules[name] = s.Action
+		}
+	}
+	return rules
+}
+
+func TestDefaultSeccompProfile(t *testing.T) {
+	offline := seccompRules(t, defaultSeccompProfile(false))
+	online := seccompRules(t, defaultSeccompProfile(true))
+
+	for _, name := range []string{"read", "pread64", "openat", "mmap", "munmap", "madvise", "futex", "execve", "clone"} {
+		if offline[name] != "SCMP_ACT_ALLOW" {
+			t.Errorf("%s not allowed", name)
+		}
+	}
+	for _, name := range []string{"ptrace", "mount", "umount2", "unshare", "setns", "bpf", "kexec_load", "init_module", "keyctl"} {
+		if _, ok := online[name]; ok {
+			t.Errorf("%s allowed", name)
+		}
+	}
+	for _, name := range []string{"socket", "connect", "sendto"} {
+		if _, ok := offline[name]; ok {
+			t.Errorf("%s allowed without network", name)
+		}
+		if online[name] != "SCMP_ACT_ALLOW" {
+			t.Errorf("%s not allowed with network", name)
+		}
+	}
+
+	var unix seccompProfile
+	json.Unmarshal([]byte(unixSocketSeccompProfile()), &unix)
+	for _, s := range unix.Syscalls {
+		for _, name := range s.Names {
+			if name == "socket" && (len(s.Args) != 1 || s.Args[0].Value != afUnix || s.Args[0].Op != "SCMP_CMP_EQ") {
+				t.Errorf("socket allowed without an AF_UNIX filter: %+v", s)
+			}
+			if name == "socketcall" {
+				t.Error("socketcall allowed for unix sockets")
+			}
+		}
+	}
+	if seccompRules(t, unixSocketSeccompProfile())["connect"] != "SCMP_ACT_ALLOW" {
+		t.Error("connect not allowed for unix sockets")
+	}
+}
+
+func TestRunSecurityOptions(t *testing.T) {
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	if _, err := r.Run(context.Background(), testJob(t)); err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	if !reflect.DeepEqual(spec.CapDrop, []string{"ALL"}) || !spec.NoNewPrivileges {
+		t.Errorf("capabilities = %v, no-new-privileges = %v", spec.CapDrop, spec.NoNewPrivileges)
+	}
+	if spec.SeccompProfile != defaultSeccompProfile(false) {
+		t.Error("default seccomp profile not applied")
+	}
+
+	custom := filepath.Join(t.TempDir(), "profile.json")
+	os.WriteFile(custom, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0o644)
+	job := testJob(t)
+	cfg := DefaultExecConfig()
+	cfg.SeccompProfile = custom
+	cfg.KeepCapabilities = true
+	job.Config = &cfg
+	if _, err := r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	spec = rt.lastSpec()
+	if spec.SeccompProfile != `{"defaultAction":"SCMP_ACT_ALLOW"}` || spec.CapDrop != nil || spec.NoNewPrivileges {
+		t.Errorf("spec = %+v", spec)
+	}
+
+	os.WriteFile(custom, []byte("not json"), 0o644)
+	if _, err := r.Run(context.Background(), job); err == nil || !strings.Contains(err.Error(), "seccomp") {
+		t.Errorf("err = %v, want an invalid profile error", err)
+	}
+}
+
+func TestCreateArgsSecurity(t *testing.T) {
+	spec := ContainerSpec{Image: "img", CapDrop: []string{"ALL"}, NoNewPrivileges: true, SeccompProfile: "{}"}
+	got := strings.Join(createArgs(spec, "/tmp/p.json"), " ")
+	want := "create --network none --cap-drop ALL --security-opt no-new-privileges --security-opt seccomp=/tmp/p.json img"
+	if got != want {
+		t.Errorf("args =\n%s\nwant\n%s", got, want)
+	}
+	spec = ContainerSpec{Image: "img", SeccompProfile: SeccompUnconfined}
+	if got := strings.Join(createArgs(spec, ""), " "); !strings.Contains(got, "seccomp=unconfined") {
+		t.Errorf("args = %s", got)
+	}
+}
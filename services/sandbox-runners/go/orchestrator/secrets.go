@@ -0,0 +1,130 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+const secretsDirPrefix = "datamortem-secrets-"
+
+// minSecretLength is the length of the shortest secret value: a shorter
+// one could not be masked in the logs without garbling them.
+const minSecretLength = 4
+
+// validateSecrets checks the names and values of Job.Secrets.
+func validateSecrets(secrets map[string]string) error {
+	for name, value := range secrets {
+		if err := sandbox.ValidateSecretName(name); err != nil {
+			return err
+		}
+		if len(value) < minSecretLength {
+			return fmt.Errorf("orchestrator: secret %s is shorter than %d bytes, too short to be masked in logs", name, minSecretLength)
+		}
+	}
+	return nil
+}
+
+// secretNames returns the names of secrets, sorted.
+func secretNames(secrets map[string]string) []string {
+	if len(secrets) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stageSecrets writes the secrets of job, one file each, to a fresh
+// directory mounted read-only by applySecrets, and returns "" for a job
+// without secrets. The caller removes the directory.
+func (r *Runner) stageSecrets(job Job) (string, error) {
+	if len(job.Secrets) == 0 {
+		return "", nil
+	}
+	var dir string
+	var err error
+	if r.SecretsDir != "" {
+		dir, err = r.tempDir(r.SecretsDir, secretsDirPrefix)
+	} else {
+		dir, err = r.scratchDir(secretsDirPrefix)
+	}
+	if err != nil {
+		return "", err
+	}
+	// The sandbox user must read the files whatever uid the orchestrator
+	// runs as; the directory can be traversed but not listed.
+	err = os.Chmod(dir, 0o711)
+	for name, value := range job.Secrets {
+		if err != nil {
+			break
+		}
+		err = os.WriteFile(filepath.Join(dir, name), []byte(value), 0o444)
+	}
+	if err != nil {
+		r.removeDir(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// applySecrets mounts the secrets directory dir in spec, if any.
+func applySecrets(spec *ContainerSpec, dir string) {
+	if dir == "" {
+		return
+	}
+	spec.Mounts = append(spec.Mounts, Mount{Source: dir, Target: containerSecretsDir, ReadOnly: true})
+	spec.Env[sandbox.EnvSecretsDir] = containerSecretsDir
+}
+
+// scrubber masks the values of a job's secrets in text the runner keeps. A
+// nil scrubber leaves text as is.
+type scrubber struct {
+	r *strings.Replacer
+}
+
+// newScrubber returns the scrubber of secrets, nil when there are none.
+// Each value is replaced by "[REDACTED:<name>]".
+func newScrubber(secrets map[string]string) *scrubber {
+	if len(secrets) == 0 {
+		return nil
+	}
+	names := secretNames(secrets)
+	// At a given position the replacer tries its pairs in order: the
+	// longest value goes first, so that a secret containing another one
+	// is masked whole.
+	sort.SliceStable(names, func(i, j int) bool { return len(secrets[names[i]]) > len(secrets[names[j]]) })
+	var pairs []string
+	for _, name := range names {
+		if secrets[name] != "" {
+			pairs = append(pairs, secrets[name], "[REDACTED:"+name+"]")
+		}
+	}
+	return &scrubber{r: strings.NewReplacer(pairs...)}
+}
+
+func (s *scrubber) scrub(text string) string {
+	if s == nil {
+		return text
+	}
+	return s.r.Replace(text)
+}
+
+// scrubMap returns m with its values scrubbed.
+func (s *scrubber) scrubMap(m map[string]string) map[string]string {
+	if s == nil || m == nil {
+		return m
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = s.scrub(v)
+	}
+	return out
+}
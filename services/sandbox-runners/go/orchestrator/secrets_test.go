@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+const testSecret = "vt-0123456789abcdef"
+
+func TestSecretsAreMountedAndMasked(t *testing.T) {
+	rt := &fakeRuntime{
+		stdout: "querying with key " + testSecret + "\n",
+		stderr: "panic: 401 for " + testSecret + "\n",
+		state:  ContainerState{ExitCode: 2},
+	}
+	var secretFile string
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerSecretsDir && m.ReadOnly {
+				secretFile = filepath.Join(m.Source, "vt_api_key")
+			}
+		}
+		if data, err := os.ReadFile(secretFile); err != nil || string(data) != testSecret {
+			t.Errorf("secret file = %q, %v", data, err)
+		}
+		for k, v := range spec.Env {
+			// PARAM_NOTE quotes it on purpose.
+			if k != "PARAM_NOTE" && strings.Contains(v, testSecret) {
+				t.Errorf("secret passed in %s", k)
+			}
+		}
+		if spec.Env[sandbox.EnvSecretsDir] != containerSecretsDir {
+			t.Errorf("%s = %q", sandbox.EnvSecretsDir, spec.Env[sandbox.EnvSecretsDir])
+		}
+	}
+	r := NewRunner(rt)
+	r.SecretsDir = t.TempDir()
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	job := testJob(t)
+	job.LogDir = t.TempDir()
+	job.Params = map[string]string{"PARAM_NOTE": "key=" + testSecret}
+	job.Secrets = map[string]string{"vt_api_key": testSecret}
+
+	exec, err := r.Start(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := exec.Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []string
+	for line := range lines {
+		streamed = append(streamed, line.Text)
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"querying with key [REDACTED:vt_api_key]", "panic: 401 for [REDACTED:vt_api_key]"}
+	if !reflect.DeepEqual(streamed, want) {
+		t.Errorf("streamed = %q, want %q", streamed, want)
+	}
+	var kept bytes.Buffer
+	kept.WriteString(res.Stdout + res.Stderr + res.FailureDetail + strings.Join(res.StderrTail, "\n"))
+	for _, name := range []string{StdoutLogFile, StderrLogFile} {
+		data, _ := os.ReadFile(filepath.Join(job.LogDir, name))
+		kept.Write(data)
+	}
+	if err := r.Audit.Export("case-1", &kept); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(kept.String(), testSecret) {
+		t.Errorf("secret kept by the runner:\n%s", kept.String())
+	}
+	if !strings.Contains(res.Stdout, "[REDACTED:vt_api_key]") || !strings.Contains(kept.String(), `"secrets":["vt_api_key"]`) {
+		t.Errorf("stdout = %q, want the secret masked and named in the audit log", res.Stdout)
+	}
+	if _, err := os.Stat(filepath.Dir(secretFile)); !os.IsNotExist(err) {
+		t.Errorf("secrets directory left behind: %v", err)
+	}
+}
+
+func TestInvalidSecrets(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.SecretsDir = t.TempDir()
+	for _, secrets := range []map[string]string{
+		{"../key": testSecret},
+		{"vt_api_key": "abc"},
+	} {
+		job := testJob(t)
+		job.Secrets = secrets
+		if _, err := r.Run(context.Background(), job); err == nil {
+			t.Errorf("Run with secrets %v succeeded, want an error", secrets)
+		}
+	}
+}
+
+func TestScrubberMasksLongestSecretFirst(t *testing.T) {
+	s := newScrubber(map[string]string{"user": "alice", "token": "alice-token-42"})
+	if got := s.scrub("alice-token-42 for alice"); got != "[REDACTED:token] for [REDACTED:user]" {
+		t.Errorf("scrub = %q", got)
+	}
+	if got := (*scrubber)(nil).scrub("alice"); got != "alice" {
+		t.Errorf("nil scrub = %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// defaultSessionCmd is the command of a session that sets none.
+var defaultSessionCmd = []string{"sh"}
+
+// SessionRecord is what an analyst ran in an interactive session, kept in
+// JobResult.Session and the audit log.
+type SessionRecord struct {
+	// Command is the shell or REPL the session ran, e.g. ["sh"].
+	Command []string `json:"command"`
+	// Input is everything the container was sent, secrets scrubbed.
+	Input string `json:"input"`
+	// Error reports why the input stopped short of the end of stdin,
+	// e.g. a failure to attach to the container.
+	Error string `json:"error,omitempty"`
+}
+
+// sessionInput delivers the stdin of a session to its container and keeps
+// a copy of what it read.
+type sessionInput struct {
+	cmd   []string
+	stdin io.Reader
+
+	mu    sync.Mutex
+	typed bytes.Buffer
+	err   error
+	// stop ends the attachment, done is closed once it has ended.
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func (s *sessionInput) Read(p []byte) (int, error) {
+	n, err := s.stdin.Read(p)
+	s.mu.Lock()
+	s.typed.Write(p[:n])
+	s.mu.Unlock()
+	return n, err
+}
+
+// StartSession starts an interactive session against the evidence of job:
+// its container runs cmd, a shell or REPL such as ["python3", "-i"], or sh
+// when empty, instead of the job's script, reading stdin until it ends.
+// The session is a job in every other respect: it gets the job's mounts,
+// environment contract, isolation and timeout, Stream follows its output,
+// Cancel ends it, and Wait collects OUTPUT_DIR and records the session,
+// what was typed included, in the audit log.
+func (r *Runner) StartSession(ctx context.Context, job Job, cmd []string, stdin io.Reader) (*Execution, error) {
+	if stdin == nil {
+		return nil, errors.New("orchestrator: a session needs stdin")
+	}
+	if len(cmd) == 0 {
+		cmd = defaultSessionCmd
+	}
+	return r.start(ctx, job, &sessionInput{cmd: append([]string(nil), cmd...), stdin: stdin})
+}
+
+// attach streams the input of the session to container id until the input
+// ends, the container exits or ctx is done.
+func (s *sessionInput) attach(ctx context.Context, rt Runtime, id string) {
+	ctx, s.stop = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		err := rt.Attach(ctx, id, s)
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}()
+}
+
+// end stops the attachment and waits for it to return. It is a no-op on a
+// nil session.
+func (s *sessionInput) end() {
+	if s == nil || s.done == nil {
+		return
+	}
+	s.stop()
+	<-s.done
+}
+
+// record ends the session and returns its SessionRecord, nil for a batch
+// job.
+func (s *sessionInput) record(scrub *scrubber) *SessionRecord {
+	if s == nil {
+		return nil
+	}
+	s.end()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := &SessionRecord{Command: s.cmd, Input: scrub.scrub(s.typed.String())}
+	if s.err != nil {
+		rec.Error = scrub.scrub(s.err.Error())
+	}
+	return rec
+}
@@ -0,0 +1,111 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestStartSession(t *testing.T) {
+	rt := &fakeRuntime{block: true, stdout: "disk.raw\n"}
+	rt.onStart = func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, "notes.txt"), []byte("offsets"), 0o644)
+			}
+		}
+	}
+	r := NewRunner(rt)
+	r.SecretsDir = t.TempDir()
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	job := testJob(t)
+	job.Secrets = map[string]string{"vt_api_key": testSecret}
+	input := "ls \"$EVIDENCE_PATH\"\ncurl -H 'x-apikey: " + testSecret + "' vt\nexit\n"
+
+	exec, err := r.StartSession(context.Background(), job, []string{"python3", "-i"}, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := exec.Stream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamed []string
+	for line := range lines {
+		streamed = append(streamed, line.Text)
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) == 0 || streamed[0] != "disk.raw" {
+		t.Errorf("streamed %q", streamed)
+	}
+
+	spec := rt.lastSpec()
+	if !reflect.DeepEqual(spec.Cmd, []string{"python3", "-i"}) || !spec.Interactive {
+		t.Errorf("cmd %v, interactive %v", spec.Cmd, spec.Interactive)
+	}
+	if spec.Env[sandbox.EnvEvidencePath] == "" || !spec.ReadOnlyRootfs || spec.Network != "none" {
+		t.Errorf("session spec %+v", spec)
+	}
+	if rt.attached["c1"] != input {
+		t.Errorf("container got %q", rt.attached["c1"])
+	}
+	if !res.Success || len(res.Outputs) != 1 || res.Outputs[0] != "notes.txt" {
+		t.Errorf("result %+v", res)
+	}
+	want := strings.ReplaceAll(input, testSecret, "[REDACTED:vt_api_key]")
+	if res.Session == nil || res.Session.Input != want {
+		t.Fatalf("session %+v", res.Session)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := entries[0].Session; s == nil || s.Input != want || !reflect.DeepEqual(s.Command, []string{"python3", "-i"}) {
+		t.Errorf("audited session %+v", s)
+	}
+	if strings.Contains(buf.String(), testSecret) {
+		t.Error("audit log holds the secret")
+	}
+}
+
+func TestStartSessionDefaults(t *testing.T) {
+	rt := &fakeRuntime{block: true}
+	r := NewRunner(rt)
+	if _, err := r.StartSession(context.Background(), testJob(t), nil, nil); err == nil {
+		t.Error("started a session without stdin")
+	}
+	exec, err := r.StartSession(context.Background(), testJob(t), nil, strings.NewReader("exit 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := exec.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rt.lastSpec().Cmd; !reflect.DeepEqual(got, []string{"sh"}) {
+		t.Errorf("cmd %v", got)
+	}
+	if res.Session == nil || res.Session.Input != "exit 3\n" || res.Session.Error != "" {
+		t.Errorf("session %+v", res.Session)
+	}
+
+	// A batch job has no session.
+	rt.block = false
+	if res, err := r.Run(context.Background(), testJob(t)); err != nil || res.Session != nil || rt.lastSpec().Interactive {
+		t.Errorf("batch job: session %+v, %v", res.Session, err)
+	}
+}
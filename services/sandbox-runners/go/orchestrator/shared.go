@@ -0,0 +1,146 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// containerSharedDir is where the shared directory of the job's case is
+// mounted, as SHARED_DIR.
+const containerSharedDir = "/shared"
+
+// ErrNoSharedStore is returned for a job run with ExecConfig.SharedDir by
+// a runner without Runner.Shared.
+var ErrNoSharedStore = errors.New("orchestrator: shared directory requires Runner.Shared")
+
+// SharedStore keeps a directory per case on a host volume, mounted
+// read-write at SHARED_DIR in the jobs of the case run with
+// ExecConfig.SharedDir, for the data the stages of a pipeline reuse
+// instead of recomputing it, e.g. a string table. The directory of a case
+// is only ever mounted in the jobs of that case.
+type SharedStore struct {
+	// Dir holds one directory per case.
+	Dir string
+	// QuotaBytes caps the size of the directory of a case; zero means no
+	// quota. The quota is checked when a job starts, not during its run:
+	// a job may take the directory beyond it, and the next jobs of the
+	// case then get it read-only until it is cleared with Remove.
+	QuotaBytes int64
+}
+
+// SharedUsage is the state of the shared directory of a job's case, in
+// JobResult.Shared.
+type SharedUsage struct {
+	// Bytes is the size of the directory when the job ended.
+	Bytes int64
+	// QuotaBytes is SharedStore.QuotaBytes.
+	QuotaBytes int64
+	// ReadOnly reports that the directory was mounted read-only, the case
+	// being at its quota when the job started.
+	ReadOnly bool
+}
+
+// OverQuota reports whether the directory holds more than its quota.
+func (u SharedUsage) OverQuota() bool {
+	return u.QuotaBytes > 0 && u.Bytes > u.QuotaBytes
+}
+
+// caseDir returns the directory of caseID, named by the SHA256 of the ID
+// so that no case ID, "../case-2" say, resolves to another case's.
+func (s *SharedStore) caseDir(caseID string) string {
+	sum := sha256.Sum256([]byte(caseID))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:]))
+}
+
+// Usage returns the size of the shared directory of caseID, zero when it
+// has none.
+func (s *SharedStore) Usage(caseID string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(s.caseDir(caseID), func(_ string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err == nil {
+			total += info.Size()
+		}
+		return err
+	})
+	return total, err
+}
+
+// Remove deletes the shared directory of caseID, e.g. once the case is
+// closed or to reclaim its quota.
+func (s *SharedStore) Remove(caseID string) error {
+	return os.RemoveAll(s.caseDir(caseID))
+}
+
+// prepare creates the shared directory of caseID and returns it, with its
+// usage when the job starts.
+func (s *SharedStore) prepare(caseID string) (string, *SharedUsage, error) {
+	dir := s.caseDir(caseID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", nil, err
+	}
+	// The sandbox user writes to it whatever uid the orchestrator runs
+	// as.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		return "", nil, err
+	}
+	used, err := s.Usage(caseID)
+	if err != nil {
+		return "", nil, err
+	}
+	u := &SharedUsage{Bytes: used, QuotaBytes: s.QuotaBytes}
+	u.ReadOnly = s.QuotaBytes > 0 && used >= s.QuotaBytes
+	return dir, u, nil
+}
+
+// prepareShared creates the shared directory of job's case when cfg asks
+// for it, "" otherwise.
+func (r *Runner) prepareShared(job Job, cfg ExecConfig) (string, *SharedUsage, error) {
+	if !cfg.SharedDir {
+		return "", nil, nil
+	}
+	if r.Shared == nil {
+		return "", nil, ErrNoSharedStore
+	}
+	dir, u, err := r.Shared.prepare(job.CaseID)
+	if err != nil {
+		return "", nil, fmt.Errorf("prepare shared directory: %w", err)
+	}
+	return dir, u, nil
+}
+
+// applyShared mounts the shared directory dir in spec, read-only when its
+// case is at its quota.
+func applyShared(spec *ContainerSpec, dir string, u *SharedUsage) {
+	if dir == "" {
+		return
+	}
+	spec.Mounts = append(spec.Mounts, Mount{Source: dir, Target: containerSharedDir, ReadOnly: u.ReadOnly})
+	spec.Env[sandbox.EnvSharedDir] = containerSharedDir
+}
+
+// sharedUsage returns u with the size of the shared directory of job's
+// case once the job ended, nil when the job had none.
+func (r *Runner) sharedUsage(job Job, u *SharedUsage) *SharedUsage {
+	if u == nil {
+		return nil
+	}
+	out := *u
+	if used, err := r.Shared.Usage(job.CaseID); err == nil {
+		out.Bytes = used
+	}
+	return &out
+}
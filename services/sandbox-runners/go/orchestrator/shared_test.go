@@ -0,0 +1,89 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// sharedMount returns the mount of the shared directory in spec.
+func sharedMount(spec ContainerSpec) (Mount, bool) {
+	for _, m := range spec.Mounts {
+		if m.Target == containerSharedDir {
+			return m, true
+		}
+	}
+	return Mount{}, false
+}
+
+func TestRunMountsSharedDir(t *testing.T) {
+	// The first job builds an index, which the next ones of the case see.
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		if m, ok := sharedMount(spec); ok && !m.ReadOnly {
+			os.WriteFile(filepath.Join(m.Source, "strings.idx"), []byte("0123456789"), 0o644)
+		}
+	}}
+	r := NewRunner(rt)
+	r.Shared = &SharedStore{Dir: t.TempDir(), QuotaBytes: 10}
+	r.ResultCache = &ResultCache{Dir: t.TempDir()}
+	r.CaseConfigs = map[string]ExecConfig{"case-1": {SharedDir: true}}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := rt.lastSpec()
+	m, ok := sharedMount(spec)
+	if !ok || m.ReadOnly || spec.Env[sandbox.EnvSharedDir] != containerSharedDir {
+		t.Fatalf("shared mount %+v, %s = %q", m, sandbox.EnvSharedDir, spec.Env[sandbox.EnvSharedDir])
+	}
+	if res.Shared == nil || res.Shared.Bytes != 10 || res.Shared.ReadOnly || res.Shared.OverQuota() {
+		t.Errorf("shared usage %+v", res.Shared)
+	}
+
+	// At its quota, the directory is only read; and the job ran again as
+	// its result depends on the directory.
+	job := testJob(t)
+	job.ID = "job-2"
+	if res, err = r.Run(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := sharedMount(rt.lastSpec()); m.Source == "" || !m.ReadOnly || res.FromCache || !res.Shared.ReadOnly {
+		t.Errorf("shared mount at quota %+v, result %+v", m, res.Shared)
+	}
+
+	// Another case gets its own directory, and a case without SharedDir
+	// none.
+	other := testJob(t)
+	other.CaseID = "../case-1"
+	r.CaseConfigs[other.CaseID] = ExecConfig{SharedDir: true}
+	if _, err := r.Run(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+	if o, _ := sharedMount(rt.lastSpec()); o.Source == m.Source || filepath.Dir(o.Source) != r.Shared.Dir {
+		t.Errorf("case %q gets %s, case-1 %s", other.CaseID, o.Source, m.Source)
+	}
+	plain := testJob(t)
+	plain.CaseID = "case-3"
+	if res, err := r.Run(context.Background(), plain); err != nil || res.Shared != nil {
+		t.Fatalf("Run() without SharedDir = %+v, %v", res, err)
+	}
+	if _, ok := sharedMount(rt.lastSpec()); ok {
+		t.Error("shared directory mounted without ExecConfig.SharedDir")
+	}
+
+	if err := r.Shared.Remove("case-1"); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := r.Shared.Usage("case-1"); err != nil || used != 0 {
+		t.Errorf("Usage() after Remove = %d, %v", used, err)
+	}
+	r.Shared = nil
+	if _, err := r.Run(context.Background(), testJob(t)); !errors.Is(err, ErrNoSharedStore) {
+		t.Errorf("Run() without Runner.Shared = %v, want ErrNoSharedStore", err)
+	}
+}
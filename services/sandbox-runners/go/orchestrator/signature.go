@@ -0,0 +1,231 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureFile is the name of the detached minisign signature at the root
+// of a script's workspace. It signs the SignedManifest of the workspace.
+const SignatureFile = "script.minisig"
+
+var (
+	// ErrUnsignedScript is returned for a job whose workspace has no
+	// SignatureFile.
+	ErrUnsignedScript = errors.New("orchestrator: the script is not signed")
+	// ErrUntrustedScript is returned for a job whose script is signed by a
+	// key that is not in SignaturePolicy.TrustedKeys.
+	ErrUntrustedScript = errors.New("orchestrator: the script is not signed by a trusted key")
+	// ErrInvalidSignature is returned for a job whose signature does not
+	// verify: the script was modified after it was signed.
+	ErrInvalidSignature = errors.New("orchestrator: the script signature does not verify")
+)
+
+// SignaturePolicy decides which scripts may run: those whose SignatureFile
+// verifies against one of TrustedKeys.
+type SignaturePolicy struct {
+	TrustedKeys []PublicKey
+	// AllowUnsigned runs scripts that are unsigned or signed by an unknown
+	// key, without a signer. A script whose signature by a trusted key
+	// does not verify is rejected all the same.
+	AllowUnsigned bool
+}
+
+// PublicKey is a minisign Ed25519 public key.
+type PublicKey struct {
+	// ID is the key ID as minisign prints it, 16 hexadecimal digits.
+	ID  string
+	Key ed25519.PublicKey
+}
+
+// minisign algorithm tags: signatures of the message itself, and of its
+// BLAKE2b-512 hash, the default since minisign 0.10.
+const (
+	minisignAlgo    = "Ed"
+	minisignHashed  = "ED"
+	minisignIDSize  = 8
+	minisignComment = "untrusted comment:"
+	minisignTrusted = "trusted comment:"
+)
+
+// ParsePublicKey parses a minisign public key: the content of a
+// minisign.pub file, or its base64 line alone.
+func ParsePublicKey(s string) (PublicKey, error) {
+	lines := nonEmptyLines(s)
+	if len(lines) > 0 && strings.HasPrefix(lines[0], minisignComment) {
+		lines = lines[1:]
+	}
+	if len(lines) != 1 {
+		return PublicKey{}, errors.New("orchestrator: public key: want one base64 line")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(raw) != 2+minisignIDSize+ed25519.PublicKeySize || string(raw[:2]) != minisignAlgo {
+		return PublicKey{}, errors.New("orchestrator: public key: not a minisign Ed25519 key")
+	}
+	return PublicKey{ID: keyID(raw[2 : 2+minisignIDSize]), Key: ed25519.PublicKey(raw[2+minisignIDSize:])}, nil
+}
+
+// keyID formats a key number as minisign does: the little-endian integer in
+// upper-case hexadecimal.
+func keyID(b []byte) string {
+	rev := make([]byte, len(b))
+	for i := range b {
+		rev[len(b)-1-i] = b[i]
+	}
+	return strings.ToUpper(hex.EncodeToString(rev))
+}
+
+// SignedManifest returns the message SignatureFile signs for workspace: a
+// line "<sha256>  <path>" per regular file but SignatureFile, sorted by
+// slash-separated path, as printed by
+//
+//	find . -type f ! -name script.minisig -printf '%P\n' | LC_ALL=C sort | xargs -d '\n' sha256sum
+//
+// run at the workspace root. Authors sign it with `minisign -S -m`.
+func SignedManifest(workspace string) ([]byte, error) {
+	var files []string
+	err := filepath.WalkDir(workspace, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(workspace, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); rel != SignatureFile {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var buf bytes.Buffer
+	for _, rel := range files {
+		sum, _, err := fileSHA256(filepath.Join(workspace, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, rel)
+	}
+	return buf.Bytes(), nil
+}
+
+// minisignSignature is a parsed minisign signature file.
+type minisignSignature struct {
+	algo    string
+	keyID   string
+	sig     []byte
+	trusted string
+	global  []byte
+}
+
+func parseSignature(data []byte) (minisignSignature, error) {
+	var s minisignSignature
+	lines := nonEmptyLines(string(data))
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], minisignComment) || !strings.HasPrefix(lines[2], minisignTrusted) {
+		return s, errors.New("not a minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 2+minisignIDSize+ed25519.SignatureSize {
+		return s, errors.New("invalid signature line")
+	}
+	if s.global, err = base64.StdEncoding.DecodeString(lines[3]); err != nil || len(s.global) != ed25519.SignatureSize {
+		return s, errors.New("invalid global signature line")
+	}
+	s.algo, s.keyID, s.sig = string(raw[:2]), keyID(raw[2:2+minisignIDSize]), raw[2+minisignIDSize:]
+	if s.algo != minisignAlgo && s.algo != minisignHashed {
+		return s, fmt.Errorf("unsupported algorithm %q", s.algo)
+	}
+	s.trusted = strings.TrimSpace(strings.TrimPrefix(lines[2], minisignTrusted))
+	return s, nil
+}
+
+// verify checks s over message and its trusted comment with key.
+func (s minisignSignature) verify(key ed25519.PublicKey, message []byte) bool {
+	if s.algo == minisignHashed {
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	}
+	if !ed25519.Verify(key, message, s.sig) {
+		return false
+	}
+	return ed25519.Verify(key, append(append([]byte(nil), s.sig...), s.trusted...), s.global)
+}
+
+func nonEmptyLines(s string) []string {
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// VerifyScript checks the SignatureFile of workspace against p and returns
+// the ID of the trusted key that signed it, or "" for a script p allows
+// unsigned.
+func (p *SignaturePolicy) VerifyScript(workspace string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(workspace, SignatureFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		if p.AllowUnsigned {
+			return "", nil
+		}
+		return "", ErrUnsignedScript
+	}
+	if err != nil {
+		return "", err
+	}
+	sig, err := parseSignature(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidSignature, SignatureFile, err)
+	}
+	var key *PublicKey
+	for i := range p.TrustedKeys {
+		if p.TrustedKeys[i].ID == sig.keyID {
+			key = &p.TrustedKeys[i]
+			break
+		}
+	}
+	if key == nil {
+		if p.AllowUnsigned {
+			return "", nil
+		}
+		return "", fmt.Errorf("%w: key %s", ErrUntrustedScript, sig.keyID)
+	}
+	message, err := SignedManifest(workspace)
+	if err != nil {
+		return "", fmt.Errorf("orchestrator: signed manifest: %w", err)
+	}
+	if !sig.verify(key.Key, message) {
+		return "", fmt.Errorf("%w: key %s", ErrInvalidSignature, key.ID)
+	}
+	return key.ID, nil
+}
+
+// verifyScript applies r.Signatures to workspace, which any script passes
+// when it is nil.
+func (r *Runner) verifyScript(workspace string) (string, error) {
+	if r.Signatures == nil {
+		return "", nil
+	}
+	return r.Signatures.VerifyScript(workspace)
+}
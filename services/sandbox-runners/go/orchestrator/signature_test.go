@@ -0,0 +1,209 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testSigner signs workspaces as `minisign -S` does.
+type testSigner struct {
+	id   []byte
+	priv ed25519.PrivateKey
+	pub  string
+}
+
+func newTestSigner(t *testing.T, id byte) testSigner {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyNum := []byte{id, 0, 0, 0, 0, 0, 0, 0xa0}
+	raw := append(append([]byte(minisignAlgo), keyNum...), pub...)
+	return testSigner{
+		id:   keyNum,
+		priv: priv,
+		pub:  "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n",
+	}
+}
+
+func (s testSigner) key(t *testing.T) PublicKey {
+	t.Helper()
+	k, err := ParsePublicKey(s.pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func (s testSigner) sign(t *testing.T, workspace string) {
+	t.Helper()
+	message, err := SignedManifest(workspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := blake2b.Sum512(message)
+	sig := ed25519.Sign(s.priv, hash[:])
+	trusted := "timestamp:1700000000\tfile:manifest\thashed"
+	global := ed25519.Sign(s.priv, append(append([]byte(nil), sig...), trusted...))
+	data := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(minisignHashed), s.id...), sig...)) + "\n" +
+		"trusted comment: " + trusted + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n"
+	if err := os.WriteFile(filepath.Join(workspace, SignatureFile), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	s := newTestSigner(t, 0x01)
+	k := s.key(t)
+	if k.ID != "A000000000000001" || !bytes.Equal(k.Key, s.priv.Public().(ed25519.PublicKey)) {
+		t.Errorf("key = %s %x", k.ID, k.Key)
+	}
+	for _, bad := range []string{"", "not base64", base64.StdEncoding.EncodeToString([]byte("Ed short"))} {
+		if _, err := ParsePublicKey(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestSignedManifest(t *testing.T) {
+	ws := writeWorkspace(t, map[string]string{"main.go": "package main\n", SignatureFile: "ignored"})
+	os.Mkdir(filepath.Join(ws, "lib"), 0o755)
+	os.WriteFile(filepath.Join(ws, "lib", "a.go"), []byte(""), 0o644)
+	got, err := SignedManifest(ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  lib/a.go\n" +
+		"df1d036cbbf3df46e2045071e082245ece204c7f53ecf0a4e022bff9bb228f47  main.go\n"
+	if string(got) != want {
+		t.Errorf("manifest = %q, want %q", got, want)
+	}
+}
+
+func TestVerifyScript(t *testing.T) {
+	trusted, other := newTestSigner(t, 0x01), newTestSigner(t, 0x02)
+	policy := &SignaturePolicy{TrustedKeys: []PublicKey{trusted.key(t)}}
+	permissive := &SignaturePolicy{TrustedKeys: policy.TrustedKeys, AllowUnsigned: true}
+
+	ws := writeWorkspace(t, map[string]string{"main.go": "package main\n"})
+	if _, err := policy.VerifyScript(ws); !errors.Is(err, ErrUnsignedScript) {
+		t.Errorf("unsigned: err = %v, want ErrUnsignedScript", err)
+	}
+	if id, err := permissive.VerifyScript(ws); id != "" || err != nil {
+		t.Errorf("unsigned, allowed: %q, %v", id, err)
+	}
+
+	trusted.sign(t, ws)
+	if id, err := policy.VerifyScript(ws); id != "A000000000000001" || err != nil {
+		t.Errorf("signed: %q, %v", id, err)
+	}
+
+	other.sign(t, ws)
+	if _, err := policy.VerifyScript(ws); !errors.Is(err, ErrUntrustedScript) {
+		t.Errorf("untrusted: err = %v, want ErrUntrustedScript", err)
+	}
+	if id, err := permissive.VerifyScript(ws); id != "" || err != nil {
+		t.Errorf("untrusted, allowed: %q, %v", id, err)
+	}
+
+	// Modifying any file after signing invalidates the signature, even
+	// when unsigned scripts are allowed.
+	trusted.sign(t, ws)
+	os.WriteFile(filepath.Join(ws, "helper.go"), []byte("package main\n"), 0o644)
+	for _, p := range []*SignaturePolicy{policy, permissive} {
+		if _, err := p.VerifyScript(ws); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("modified: err = %v, want ErrInvalidSignature", err)
+		}
+	}
+}
+
+func TestRunRecordsSigner(t *testing.T) {
+	signer := newTestSigner(t, 0x07)
+	rt := &fakeRuntime{}
+	r := NewRunner(rt)
+	r.Signatures = &SignaturePolicy{TrustedKeys: []PublicKey{signer.key(t)}}
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main\n"), 0o644)
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrUnsignedScript) || len(rt.specs) != 0 {
+		t.Fatalf("unsigned: err = %v, %d containers", err, len(rt.specs))
+	}
+
+	signer.sign(t, job.Workspace)
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SignerKeyID != "A000000000000007" {
+		t.Errorf("signer = %q", res.SignerKeyID)
+	}
+	var buf bytes.Buffer
+	if err := r.Audit.Export("case-1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := VerifyAuditLog(&buf)
+	if err != nil || len(entries) != 1 || entries[0].SignerKeyID != res.SignerKeyID {
+		t.Errorf("audit = %+v, %v", entries, err)
+	}
+}
+
+func TestPoolVerifiesSignature(t *testing.T) {
+	signer := newTestSigner(t, 0x07)
+	rt := &fakeRuntime{}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	p.runner.Signatures = &SignaturePolicy{TrustedKeys: []PublicKey{signer.key(t)}}
+
+	job := poolJob(t, "case-1")
+	if _, err := p.Run(context.Background(), job); !errors.Is(err, ErrUnsignedScript) {
+		t.Fatalf("err = %v, want ErrUnsignedScript", err)
+	}
+	signer.sign(t, job.Workspace)
+	res, err := p.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SignerKeyID != "A000000000000007" {
+		t.Errorf("signer = %q", res.SignerKeyID)
+	}
+}
+
+// swapRuntime rewrites a file of the workspace when the runner lists the
+// OCI runtimes, after the source tree was verified and before it is
+// staged.
+type swapRuntime struct {
+	*fakeRuntime
+	file string
+}
+
+func (s *swapRuntime) Runtimes(ctx context.Context) ([]string, error) {
+	os.WriteFile(s.file, []byte("package main // swapped\n"), 0o644)
+	return s.fakeRuntime.Runtimes(ctx)
+}
+
+func TestRunVerifiesStagedScript(t *testing.T) {
+	signer := newTestSigner(t, 0x07)
+	job := testJob(t)
+	main := filepath.Join(job.Workspace, "main.go")
+	os.WriteFile(main, []byte("package main\n"), 0o644)
+	signer.sign(t, job.Workspace)
+	rt := &swapRuntime{fakeRuntime: &fakeRuntime{runtimes: []string{"runc", RuntimeGVisor}}, file: main}
+	r := NewRunner(rt)
+	r.Signatures = &SignaturePolicy{TrustedKeys: []PublicKey{signer.key(t)}}
+	r.Defaults.Runtime = RuntimeGVisor
+
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrInvalidSignature) || len(rt.specs) != 0 {
+		t.Errorf("err = %v, %d containers, want ErrInvalidSignature before any", err, len(rt.specs))
+	}
+}
@@ -0,0 +1,291 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// stixNamespace is the UUIDv5 namespace of STIX 2.1 cyber-observable IDs,
+// so that the same observable always gets the same ID.
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixHashNames are the STIX hash algorithm names of the hash kinds.
+var stixHashNames = map[sandbox.IOCKind]string{sandbox.IOCMD5: "MD5", sandbox.IOCSHA1: "SHA-1", sandbox.IOCSHA256: "SHA-256"}
+
+type stixObject map[string]any
+
+// stixExport accumulates the objects of a bundle.
+type stixExport struct {
+	caseID  string
+	now     string
+	objects []stixObject
+	ids     map[string]bool
+	// observed maps an evidence UID to its observed-data, and indicators
+	// an IOC key to its indicator.
+	observed   map[string]string
+	indicators map[string]string
+}
+
+// ExportSTIX returns the indicators in iocs and the findings in findings,
+// both of case caseID and either one nil, as a STIX 2.1 bundle for a
+// threat intelligence platform such as MISP:
+//   - each indicator becomes a cyber observable (ipv4-addr, domain-name,
+//     url, file with its hash…) and an indicator object whose pattern
+//     matches it;
+//   - each evidence item becomes an observed-data object listing the
+//     observables found in it, to which its indicators are related with
+//     based-on relationships;
+//   - each finding becomes a note about the observed-data of its evidence
+//     and, when its FindingKey is that of an indicator, the indicator;
+//   - a report of the case lists every object.
+func ExportSTIX(caseID string, iocs *CaseIOCs, findings *CaseFindings) ([]byte, error) {
+	if iocs != nil && iocs.CaseID != caseID {
+		return nil, fmt.Errorf("orchestrator: IOC index of case %s, not %s", iocs.CaseID, caseID)
+	}
+	if findings != nil && findings.CaseID != caseID {
+		return nil, fmt.Errorf("orchestrator: findings of case %s, not %s", findings.CaseID, caseID)
+	}
+	e := &stixExport{
+		caseID:     caseID,
+		now:        time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		ids:        map[string]bool{},
+		observed:   map[string]string{},
+		indicators: map[string]string{},
+	}
+	var iocList []CaseIOC
+	if iocs != nil {
+		iocList = iocs.IOCs()
+	}
+	var findingList []Finding
+	if findings != nil {
+		findingList = findings.Findings()
+	}
+	if err := e.addIOCs(iocList); err != nil {
+		return nil, err
+	}
+	reportID := stixRandomID("report")
+	for _, f := range findingList {
+		e.addFinding(f, reportID)
+	}
+	if len(e.objects) > 0 {
+		refs := make([]string, len(e.objects))
+		for i, o := range e.objects {
+			refs[i] = o["id"].(string)
+		}
+		report := e.domainObject("report", reportID)
+		report["name"] = "datamortem case " + caseID
+		report["published"] = e.now
+		report["report_types"] = []string{"threat-report"}
+		report["object_refs"] = refs
+		report["x_datamortem_case_id"] = caseID
+		e.add(report)
+	}
+	bundle := stixObject{"type": "bundle", "id": stixRandomID("bundle")}
+	if len(e.objects) > 0 {
+		bundle["objects"] = e.objects
+	}
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+func (e *stixExport) add(o stixObject) {
+	id := o["id"].(string)
+	if !e.ids[id] {
+		e.ids[id] = true
+		e.objects = append(e.objects, o)
+	}
+}
+
+// domainObject returns a STIX domain or relationship object created now.
+func (e *stixExport) domainObject(typ, id string) stixObject {
+	return stixObject{"type": typ, "spec_version": "2.1", "id": id, "created": e.now, "modified": e.now}
+}
+
+func (e *stixExport) addIOCs(iocs []CaseIOC) error {
+	// The observables and indicators of each evidence item, in order of
+	// first report.
+	var evidence []string
+	refs := map[string][]string{}
+	inds := map[string][]string{}
+	for _, ioc := range iocs {
+		scos, pattern, err := stixObservable(ioc)
+		if err != nil {
+			return err
+		}
+		ind := e.domainObject("indicator", stixRandomID("indicator"))
+		ind["name"] = ioc.Value
+		if len(ioc.Contexts) > 0 {
+			ind["description"] = strings.Join(ioc.Contexts, "\n")
+		}
+		ind["pattern"] = pattern
+		ind["pattern_type"] = "stix"
+		ind["valid_from"] = e.now
+		ind["x_datamortem_kind"] = string(ioc.Kind)
+		ind["x_datamortem_count"] = ioc.Count
+		for _, o := range scos {
+			e.add(o)
+		}
+		e.add(ind)
+		e.indicators[ioc.Key] = ind["id"].(string)
+		for _, uid := range ioc.EvidenceUIDs {
+			if _, ok := refs[uid]; !ok {
+				evidence = append(evidence, uid)
+			}
+			for _, o := range scos {
+				refs[uid] = appendUnique(refs[uid], o["id"].(string))
+			}
+			inds[uid] = append(inds[uid], ind["id"].(string))
+		}
+	}
+	for _, uid := range evidence {
+		obs := e.domainObject("observed-data", stixRandomID("observed-data"))
+		obs["first_observed"] = e.now
+		obs["last_observed"] = e.now
+		obs["number_observed"] = 1
+		obs["object_refs"] = refs[uid]
+		obs["x_datamortem_case_id"] = e.caseID
+		obs["x_datamortem_evidence_uid"] = uid
+		e.add(obs)
+		e.observed[uid] = obs["id"].(string)
+		for _, ind := range inds[uid] {
+			rel := e.domainObject("relationship", stixRandomID("relationship"))
+			rel["relationship_type"] = "based-on"
+			rel["source_ref"] = ind
+			rel["target_ref"] = obs["id"]
+			e.add(rel)
+		}
+	}
+	return nil
+}
+
+// addFinding adds f as a note. A note must refer to an object: one whose
+// evidence had no indicator refers to the case report.
+func (e *stixExport) addFinding(f Finding, reportID string) {
+	var refs []string
+	for _, uid := range f.EvidenceUIDs {
+		if id, ok := e.observed[uid]; ok {
+			refs = appendUnique(refs, id)
+		}
+	}
+	if id, ok := e.indicators[f.FindingKey]; ok && f.FindingKey != "" {
+		refs = append(refs, id)
+	}
+	if len(refs) == 0 {
+		refs = []string{reportID}
+	}
+	note := e.domainObject("note", stixRandomID("note"))
+	note["abstract"] = f.Title
+	note["content"] = f.Title
+	if f.Description != "" {
+		note["content"] = f.Description
+	}
+	note["object_refs"] = refs
+	note["x_datamortem_severity"] = string(f.Severity)
+	note["x_datamortem_evidence_uids"] = f.EvidenceUIDs
+	if f.FindingKey != "" {
+		note["x_datamortem_finding_key"] = f.FindingKey
+	}
+	if f.Module.Name != "" {
+		note["x_datamortem_module"] = f.Module.String()
+	}
+	e.add(note)
+}
+
+// stixObservable returns the cyber observables of ioc, the one it
+// describes last, and the indicator pattern matching it.
+func stixObservable(ioc CaseIOC) ([]stixObject, string, error) {
+	v := ioc.Value
+	switch ioc.Kind {
+	case sandbox.IOCIPv4, sandbox.IOCIPv6, sandbox.IOCURL, sandbox.IOCEmail, sandbox.IOCDomain:
+		typ := map[sandbox.IOCKind]string{
+			sandbox.IOCIPv4: "ipv4-addr", sandbox.IOCIPv6: "ipv6-addr", sandbox.IOCURL: "url",
+			sandbox.IOCEmail: "email-addr", sandbox.IOCDomain: "domain-name",
+		}[ioc.Kind]
+		if ioc.Kind == sandbox.IOCDomain {
+			v = strings.TrimSuffix(v, ".")
+		}
+		return []stixObject{stixObservableObject(typ, stixObject{"value": v})}, stixPattern(typ+":value", v), nil
+	case sandbox.IOCMD5, sandbox.IOCSHA1, sandbox.IOCSHA256:
+		name, v := stixHashNames[ioc.Kind], strings.ToLower(v)
+		o := stixObservableObject("file", stixObject{"hashes": map[string]string{name: v}})
+		return []stixObject{o}, stixPattern("file:hashes.'"+name+"'", v), nil
+	case sandbox.IOCMutex:
+		return []stixObject{stixObservableObject("mutex", stixObject{"name": v})}, stixPattern("mutex:name", v), nil
+	case sandbox.IOCRegistryKey:
+		return []stixObject{stixObservableObject("windows-registry-key", stixObject{"key": v})}, stixPattern("windows-registry-key:key", v), nil
+	case sandbox.IOCFilePath:
+		i := strings.LastIndexAny(v, `/\`)
+		if i < 0 {
+			return []stixObject{stixObservableObject("file", stixObject{"name": v})}, stixPattern("file:name", v), nil
+		}
+		dir, name := v[:i], v[i+1:]
+		if dir == "" {
+			dir = v[:1]
+		}
+		d := stixObservableObject("directory", stixObject{"path": dir})
+		f := stixObservableObject("file", stixObject{"name": name, "parent_directory_ref": d["id"]})
+		pattern := "[file:name = " + stixQuote(name) + " AND file:parent_directory_ref.path = " + stixQuote(dir) + "]"
+		return []stixObject{d, f}, pattern, nil
+	}
+	return nil, "", fmt.Errorf("orchestrator: STIX export: unknown IOC kind %q", ioc.Kind)
+}
+
+// stixObservableObject returns the cyber observable of type typ with
+// props, its ID-contributing properties, and the ID STIX derives from
+// them.
+func stixObservableObject(typ string, props stixObject) stixObject {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(props)
+	o := stixObject{"type": typ, "spec_version": "2.1", "id": typ + "--" + uuidV5(stixNamespace, bytes.TrimSpace(buf.Bytes()))}
+	for k, v := range props {
+		o[k] = v
+	}
+	return o
+}
+
+func stixPattern(path, value string) string {
+	return "[" + path + " = " + stixQuote(value) + "]"
+}
+
+// stixQuote quotes s as a STIX pattern string literal.
+func stixQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// stixRandomID returns an ID of type typ with a random UUIDv4, as STIX
+// recommends for the objects that are not observables.
+func stixRandomID(typ string) string {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return typ + "--" + formatUUID(u)
+}
+
+// uuidV5 returns the name-based UUID of name in namespace, RFC 4122.
+func uuidV5(namespace [16]byte, name []byte) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write(name)
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	s := hex.EncodeToString(u[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
@@ -0,0 +1,118 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestExportSTIX(t *testing.T) {
+	iocs, findings := NewCaseIOCs("case-1"), NewCaseFindings("case-1")
+	job := Job{ID: "job-1", CaseID: "case-1"}
+	res := &JobResult{
+		IOCs: []sandbox.IOC{
+			{EvidenceUID: "ev-1", Kind: sandbox.IOCDomain, Value: "evil.example", Context: "C2 in config"},
+			{EvidenceUID: "ev-1", Kind: sandbox.IOCSHA256, Value: strings.Repeat("AB", 32)},
+			{EvidenceUID: "ev-2", Kind: sandbox.IOCFilePath, Value: `C:\Users\x\it's.exe`},
+		},
+		Findings: []sandbox.Result{
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityHigh, Title: "C2 domain", FindingKey: "ioc/domain/evil.example"},
+			{EvidenceUID: "ev-3", Severity: sandbox.SeverityLow, Title: "Cleared event log"},
+		},
+	}
+	if err := iocs.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	findings.Add(job, res)
+
+	data, err := ExportSTIX("case-1", iocs, findings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle struct {
+		Type    string           `json:"type"`
+		ID      string           `json:"id"`
+		Objects []map[string]any `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Type != "bundle" || !strings.HasPrefix(bundle.ID, "bundle--") {
+		t.Errorf("bundle %s %s", bundle.Type, bundle.ID)
+	}
+	byID := map[string]map[string]any{}
+	byType := map[string][]map[string]any{}
+	for _, o := range bundle.Objects {
+		id, typ := o["id"].(string), o["type"].(string)
+		if !strings.HasPrefix(id, typ+"--") || o["spec_version"] != "2.1" {
+			t.Errorf("object %v", o)
+		}
+		byID[id] = o
+		byType[typ] = append(byType[typ], o)
+	}
+	// Every reference resolves within the bundle.
+	for _, o := range bundle.Objects {
+		var refs []any
+		for k, v := range o {
+			switch {
+			case strings.HasSuffix(k, "_refs"):
+				refs = append(refs, v.([]any)...)
+			case strings.HasSuffix(k, "_ref"):
+				refs = append(refs, v)
+			}
+		}
+		for _, ref := range refs {
+			if byID[ref.(string)] == nil {
+				t.Errorf("%s: dangling reference %s", o["id"], ref)
+			}
+		}
+	}
+
+	// Observable IDs are the UUIDv5 STIX derives from their properties.
+	if byID["domain-name--69228563-c8d2-54ae-aeca-5f4134cb59aa"] == nil {
+		t.Errorf("domain-name = %v", byType["domain-name"])
+	}
+	patterns := map[string]bool{}
+	for _, ind := range byType["indicator"] {
+		patterns[ind["pattern"].(string)] = true
+	}
+	for _, want := range []string{
+		"[domain-name:value = 'evil.example']",
+		"[file:hashes.'SHA-256' = '" + strings.Repeat("ab", 32) + "']",
+		`[file:name = 'it\'s.exe' AND file:parent_directory_ref.path = 'C:\\Users\\x']`,
+	} {
+		if !patterns[want] {
+			t.Errorf("missing pattern %s in %v", want, patterns)
+		}
+	}
+	if len(byType["observed-data"]) != 2 || len(byType["relationship"]) != 3 {
+		t.Errorf("%d observed-data, %d relationships", len(byType["observed-data"]), len(byType["relationship"]))
+	}
+	for _, rel := range byType["relationship"] {
+		if rel["relationship_type"] != "based-on" || byID[rel["source_ref"].(string)]["type"] != "indicator" || byID[rel["target_ref"].(string)]["type"] != "observed-data" {
+			t.Errorf("relationship %v", rel)
+		}
+	}
+
+	notes := byType["note"]
+	if len(notes) != 2 {
+		t.Fatalf("notes = %v", notes)
+	}
+	if refs := notes[0]["object_refs"].([]any); len(refs) != 2 || byID[refs[0].(string)]["type"] != "observed-data" || byID[refs[1].(string)]["type"] != "indicator" {
+		t.Errorf("note refs = %v", refs)
+	}
+	// Without indicators on its evidence, a finding refers to the report.
+	if refs := notes[1]["object_refs"].([]any); len(refs) != 1 || byID[refs[0].(string)]["type"] != "report" {
+		t.Errorf("note refs = %v", refs)
+	}
+	report := byType["report"]
+	if len(report) != 1 || len(report[0]["object_refs"].([]any)) != len(bundle.Objects)-1 {
+		t.Errorf("report = %v", report)
+	}
+
+	if _, err := ExportSTIX("case-2", iocs, nil); err == nil {
+		t.Error("exported the IOCs of another case")
+	}
+}
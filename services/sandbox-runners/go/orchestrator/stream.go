@@ -0,0 +1,296 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Files of a job's stream directory on the host.
+const (
+	streamDirPrefix = "datamortem-stream-"
+	streamSocket    = "stream.sock"
+)
+
+// streamed reports whether the script reads the evidence from object
+// storage as it goes, rather than from a file.
+func (ev Evidence) streamed() bool {
+	return ev.URL != "" && ev.Path == ""
+}
+
+// streamable reports whether the evidence at ev.URL, described by info,
+// can be streamed to the job: only its own evidence, read through
+// sandbox.OpenEvidence, when the store serves ranges.
+func streamable(ev Evidence, info ObjectInfo, cfg ExecConfig) bool {
+	return cfg.StreamEvidence && info.Ranges &&
+		!ev.encrypted() &&
+		!(cfg.DecompressEvidence && ev.compressed()) &&
+		!cfg.EvidenceBlockDevice &&
+		!cfg.EvidenceOverlay
+}
+
+// objectStore is Runner.ObjectStore, an HTTPObjectStore when nil.
+func (r *Runner) objectStore() ObjectStore {
+	if r.ObjectStore == nil {
+		return &HTTPObjectStore{}
+	}
+	return r.ObjectStore
+}
+
+// resolveObjects prepares the evidence of job kept in object storage. The
+// job's own evidence is left to be streamed when cfg and the store allow
+// it, and info describes it then; other items are downloaded to a fresh
+// directory under Runner.WorkDir, checked against their recorded digest
+// on the way, and returned job reads those files instead. The caller
+// removes the directory, which is "" when nothing was downloaded.
+func (r *Runner) resolveObjects(ctx context.Context, job Job, cfg ExecConfig) (Job, string, *ObjectInfo, error) {
+	all := job.allEvidence()
+	dir := ""
+	var streamedInfo *ObjectInfo
+	for i, ev := range all {
+		if ev.URL == "" {
+			continue
+		}
+		store := r.objectStore()
+		info, err := store.Stat(ctx, ev.URL)
+		if err != nil {
+			return Job{}, "", nil, r.objectError(ev, dir, err)
+		}
+		if i == 0 && streamable(ev, info, cfg) {
+			streamedInfo = &info
+			continue
+		}
+		if dir == "" {
+			if dir, err = r.scratchDir(evidenceDirPrefix); err != nil {
+				return Job{}, "", nil, err
+			}
+			if err := os.Chmod(dir, 0o755); err != nil {
+				r.removeDir(dir)
+				return Job{}, "", nil, err
+			}
+		}
+		if all[i], err = downloadObject(ctx, store, ev, info, filepath.Join(dir, strconv.Itoa(i))); err != nil {
+			return Job{}, "", nil, r.objectError(ev, dir, err)
+		}
+	}
+	job.Evidence = all[0]
+	if len(job.ExtraEvidence) > 0 {
+		job.ExtraEvidence = all[1:]
+	}
+	return job, dir, streamedInfo, nil
+}
+
+// objectError removes dir, unless empty, and reports the failure to read
+// the object of ev, a missing or corrupt one as an EvidenceError.
+func (r *Runner) objectError(ev Evidence, dir string, err error) error {
+	if dir != "" {
+		r.removeDir(dir)
+	}
+	name := objectName(ev.URL)
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return &EvidenceError{UID: ev.UID, Path: name, Reason: FailureEvidenceMissing, Err: err}
+	case errors.Is(err, sandbox.ErrEvidenceHashMismatch):
+		return &EvidenceError{UID: ev.UID, Path: name, Reason: FailureEvidenceCorrupt, Err: err}
+	}
+	return fmt.Errorf("evidence %s: %w", ev.UID, err)
+}
+
+// downloadObject copies the object of ev into dir and returns the evidence
+// read from that file.
+func downloadObject(ctx context.Context, store ObjectStore, ev Evidence, info ObjectInfo, dir string) (Evidence, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Evidence{}, err
+	}
+	h, err := sandbox.NewHash(ev.HashAlgo)
+	if err != nil {
+		return Evidence{}, err
+	}
+	body, err := store.Open(ctx, ev.URL, info)
+	if err != nil {
+		return Evidence{}, err
+	}
+	defer body.Close()
+	path := filepath.Join(dir, objectFileName(ev))
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Evidence{}, err
+	}
+	n, err := io.Copy(io.MultiWriter(dst, h), body)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != info.Size {
+		err = fmt.Errorf("download of %s: got %d bytes, want %d", objectName(ev.URL), n, info.Size)
+	}
+	if err != nil {
+		return Evidence{}, err
+	}
+	if ev.SHA256 != "" {
+		if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, ev.SHA256) {
+			return Evidence{}, fmt.Errorf("%w: %s is %s, want %s", sandbox.ErrEvidenceHashMismatch, objectName(ev.URL), sum, ev.SHA256)
+		}
+	}
+	ev.Path, ev.URL = path, ""
+	return ev, nil
+}
+
+// objectFileName is the name of the file the object of ev is downloaded
+// to: the last element of its URL path, or its UID.
+func objectFileName(ev Evidence) string {
+	if u, err := url.Parse(ev.URL); err == nil {
+		if name := path.Base(u.Path); name != "/" && name != "." && name != ".." {
+			return name
+		}
+	}
+	return ev.UID
+}
+
+// streamServer serves the job's evidence, kept in object storage, by byte
+// ranges on a unix socket mounted in its container, as sandbox.OpenEvidence
+// reads it: HEAD gives the size, GET a single range.
+type streamServer struct {
+	runner *Runner
+	store  ObjectStore
+	ev     Evidence
+	info   ObjectInfo
+	dir    string
+	srv    *http.Server
+	// served counts the bytes the script read.
+	served atomic.Int64
+
+	closeOnce sync.Once
+}
+
+// startStreamServer listens for the range requests of the job reading ev
+// in a fresh directory under Runner.WorkDir. Reads of the store run under
+// ctx.
+func (r *Runner) startStreamServer(ctx context.Context, ev Evidence, info ObjectInfo) (*streamServer, error) {
+	dir, err := r.scratchDir(streamDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s := &streamServer{runner: r, store: r.objectStore(), ev: ev, info: info, dir: dir}
+	socket := filepath.Join(dir, streamSocket)
+	err = os.Chmod(dir, 0o755)
+	var ln net.Listener
+	if err == nil {
+		ln, err = net.Listen("unix", socket)
+	}
+	if err == nil {
+		// The script connects as the sandbox user.
+		err = os.Chmod(socket, 0o777)
+	}
+	if err != nil {
+		if ln != nil {
+			ln.Close()
+		}
+		r.removeDir(dir)
+		return nil, fmt.Errorf("evidence stream: %w", err)
+	}
+	s.srv = &http.Server{
+		Handler:     s,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go s.srv.Serve(ln)
+	return s, nil
+}
+
+// apply mounts the socket into spec.
+func (s *streamServer) apply(spec *ContainerSpec) {
+	spec.Mounts = append(spec.Mounts, Mount{Source: s.dir, Target: containerStreamDir, ReadOnly: true})
+	spec.Env[sandbox.EnvEvidenceStreamSocket] = path.Join(containerStreamDir, streamSocket)
+	if spec.SeccompProfile == defaultSeccompProfile(false) {
+		// The built-in profile of a job without network blocks sockets.
+		spec.SeccompProfile = unixSocketSeccompProfile()
+	}
+}
+
+func (s *streamServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/evidence" {
+		http.NotFound(w, req)
+		return
+	}
+	size := s.info.Size
+	w.Header().Set("Accept-Ranges", "bytes")
+	switch req.Method {
+	case http.MethodHead:
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		return
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	off, length, ok := parseRange(req.Header.Get("Range"), size)
+	if !ok {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, "a single satisfiable byte range is required", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	body, err := s.store.ReadRange(req.Context(), s.ev.URL, s.info, off, length)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrObjectChanged) || errors.Is(err, ErrObjectNotFound) {
+			// Retrying would not help.
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, off+length-1, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	n, _ := io.CopyN(w, body, length)
+	s.served.Add(n)
+}
+
+// parseRange parses a Range header of a single range "bytes=first-last"
+// or "bytes=first-" of an object of size bytes, clamped to its end.
+func parseRange(header string, size int64) (off, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	first, last, dash := strings.Cut(spec, "-")
+	if !found || !dash || strings.Contains(last, ",") {
+		return 0, 0, false
+	}
+	off, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || off < 0 || off >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < off {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return off, end - off + 1, true
+}
+
+// Close stops serving and removes the socket's directory. It returns the
+// number of bytes the script read.
+func (s *streamServer) Close() int64 {
+	if s == nil {
+		return 0
+	}
+	s.closeOnce.Do(func() {
+		s.srv.Close()
+		s.runner.removeDir(s.dir)
+	})
+	return s.served.Load()
+}
@@ -0,0 +1,275 @@
+package orchestrator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+var (
+	// ErrInvalidSuppression is returned for a suppression rule of unknown
+	// kind or whose pattern cannot match.
+	ErrInvalidSuppression = errors.New("orchestrator: invalid suppression rule")
+	// ErrUnknownSuppression is returned when removing a rule that
+	// Suppressions does not hold.
+	ErrUnknownSuppression = errors.New("orchestrator: unknown suppression rule")
+)
+
+// SuppressionKind is what a SuppressionRule matches.
+type SuppressionKind string
+
+// Kinds of SuppressionRule.
+const (
+	// SuppressFindingKey matches findings by sandbox.Result FindingKey.
+	SuppressFindingKey SuppressionKind = "finding_key"
+	// SuppressIOC matches the indicators of one kind by value, and the
+	// findings whose key is that of such an indicator, e.g.
+	// "ioc/ipv4/10.0.0.1".
+	SuppressIOC SuppressionKind = "ioc"
+	// SuppressFileHash matches a file by its MD5, SHA-1 or SHA256: the
+	// hash indicators of that value, the findings whose key is that of
+	// such an indicator, and those with the digest in their "md5", "sha1"
+	// or "sha256" data field.
+	SuppressFileHash SuppressionKind = "file_hash"
+)
+
+// SuppressionRule marks the findings and indicators it matches as
+// suppressed when they are merged into CaseFindings and CaseIOCs, e.g. a
+// known-good hash or an expected system file: they are kept, but left out
+// of Findings and IOCs.
+type SuppressionRule struct {
+	// ID is set by Suppressions.Add.
+	ID string
+	// CaseID restricts the rule to the findings of one case; the rule
+	// applies to every case when empty.
+	CaseID string
+	Kind   SuppressionKind
+	// IOCKind is the kind of the indicators a SuppressIOC rule matches.
+	IOCKind sandbox.IOCKind
+	// Pattern is what the rule matches: a finding key or an indicator
+	// value, where '*' matches any run of characters, e.g.
+	// "yara/BenignPacker/*" or "*.windowsupdate.com", compared
+	// case-insensitively as sandbox.IOC Key does for the kind; for IP
+	// addresses, a CIDR prefix such as "10.0.0.0/8" too; for file hashes,
+	// the hexadecimal digest.
+	Pattern string
+	// Reason tells why the matches are benign, and Analyst who added the
+	// rule.
+	Reason  string
+	Analyst string
+	// Created is set by Suppressions.Add.
+	Created time.Time
+}
+
+// validate checks the kind and pattern of rule.
+func (rule SuppressionRule) validate() error {
+	if rule.Pattern == "" || strings.ContainsAny(rule.Pattern, "\x00\r\n") {
+		return fmt.Errorf("%w: empty or multi-line pattern", ErrInvalidSuppression)
+	}
+	switch rule.Kind {
+	case SuppressFindingKey:
+	case SuppressIOC:
+		if !knownIOCKind(rule.IOCKind) {
+			return fmt.Errorf("%w: unknown IOC kind %q", ErrInvalidSuppression, rule.IOCKind)
+		}
+		if strings.Contains(rule.Pattern, "/") && isIPKind(rule.IOCKind) {
+			prefix, err := netip.ParsePrefix(rule.Pattern)
+			if err != nil || prefix.Addr().Is4() != (rule.IOCKind == sandbox.IOCIPv4) {
+				return fmt.Errorf("%w: %q is not an %s prefix", ErrInvalidSuppression, rule.Pattern, rule.IOCKind)
+			}
+		}
+	case SuppressFileHash:
+		if _, err := hex.DecodeString(rule.Pattern); err != nil || hashKind(rule.Pattern) == "" {
+			return fmt.Errorf("%w: %q is not an MD5, SHA-1 or SHA256 digest", ErrInvalidSuppression, rule.Pattern)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidSuppression, rule.Kind)
+	}
+	return nil
+}
+
+// knownIOCKind reports whether kind is one of the sandbox IOC kinds.
+func knownIOCKind(kind sandbox.IOCKind) bool {
+	switch kind {
+	case sandbox.IOCIPv4, sandbox.IOCIPv6, sandbox.IOCDomain, sandbox.IOCURL, sandbox.IOCEmail,
+		sandbox.IOCMD5, sandbox.IOCSHA1, sandbox.IOCSHA256, sandbox.IOCMutex, sandbox.IOCRegistryKey, sandbox.IOCFilePath:
+		return true
+	}
+	return false
+}
+
+func isIPKind(kind sandbox.IOCKind) bool {
+	return kind == sandbox.IOCIPv4 || kind == sandbox.IOCIPv6
+}
+
+// hashKind is the IOC kind of a hexadecimal digest by its length, or "".
+func hashKind(digest string) sandbox.IOCKind {
+	switch len(digest) {
+	case 32:
+		return sandbox.IOCMD5
+	case 40:
+		return sandbox.IOCSHA1
+	case 64:
+		return sandbox.IOCSHA256
+	}
+	return ""
+}
+
+// iocKeyValue is the value of an indicator of kind as sandbox.IOC Key
+// normalises it.
+func iocKeyValue(kind sandbox.IOCKind, value string) string {
+	return strings.TrimPrefix(sandbox.IOC{Kind: kind, Value: value}.Key(), "ioc/"+string(kind)+"/")
+}
+
+// matchIOC reports whether rule matches the indicator of kind with value,
+// normalised as by iocKeyValue.
+func (rule SuppressionRule) matchIOC(kind sandbox.IOCKind, value string) bool {
+	switch rule.Kind {
+	case SuppressIOC:
+		if kind != rule.IOCKind {
+			return false
+		}
+		if isIPKind(kind) {
+			addr, err := netip.ParseAddr(value)
+			if prefix, perr := netip.ParsePrefix(rule.Pattern); perr == nil {
+				return err == nil && prefix.Contains(addr)
+			}
+			if want, perr := netip.ParseAddr(rule.Pattern); perr == nil {
+				return err == nil && addr == want
+			}
+		}
+		return wildcardMatch(iocKeyValue(kind, rule.Pattern), value)
+	case SuppressFileHash:
+		return kind == hashKind(rule.Pattern) && strings.EqualFold(value, rule.Pattern)
+	}
+	return false
+}
+
+// matchFinding reports whether rule matches r.
+func (rule SuppressionRule) matchFinding(r sandbox.Result) bool {
+	if rule.Kind == SuppressFindingKey {
+		return r.FindingKey != "" && wildcardMatch(rule.Pattern, r.FindingKey)
+	}
+	if rest, ok := strings.CutPrefix(r.FindingKey, "ioc/"); ok {
+		if kind, value, ok := strings.Cut(rest, "/"); ok && rule.matchIOC(sandbox.IOCKind(kind), value) {
+			return true
+		}
+	}
+	if rule.Kind == SuppressFileHash {
+		for _, field := range []string{"md5", "sha1", "sha256"} {
+			if v, ok := r.Data[field].(string); ok && strings.EqualFold(v, rule.Pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardMatch reports whether s matches pattern, where '*' matches any
+// run of characters, '/' included.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// Suppressions is the suppression list of the platform: global rules and
+// those of each case, consulted by the CaseFindings and CaseIOCs that
+// point to it. Its zero value is empty and ready to use, and it is safe
+// for concurrent use.
+type Suppressions struct {
+	mu    sync.Mutex
+	next  int
+	rules []SuppressionRule
+}
+
+// Add validates rule and adds it to the list, which then returns it with
+// its ID and Created set. The rule applies to the findings and indicators
+// merged from then on.
+func (s *Suppressions) Add(rule SuppressionRule) (SuppressionRule, error) {
+	if err := rule.validate(); err != nil {
+		return SuppressionRule{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	rule.ID, rule.Created = "suppression-"+strconv.Itoa(s.next), time.Now().UTC()
+	s.rules = append(s.rules, rule)
+	return rule, nil
+}
+
+// Remove deletes the rule id. What the rule suppressed already stays so.
+func (s *Suppressions) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, rule := range s.rules {
+		if rule.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownSuppression, id)
+}
+
+// Rules returns the rules that apply to caseID, the global ones and its
+// own, in the order they were added; only the global ones for "".
+func (s *Suppressions) Rules(caseID string) []SuppressionRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []SuppressionRule
+	for _, rule := range s.rules {
+		if rule.CaseID == "" || rule.CaseID == caseID {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// matchFinding returns the ID of the first rule of caseID that matches r,
+// or "" when none does or s is nil.
+func (s *Suppressions) matchFinding(caseID string, r sandbox.Result) string {
+	if s == nil {
+		return ""
+	}
+	for _, rule := range s.Rules(caseID) {
+		if rule.matchFinding(r) {
+			return rule.ID
+		}
+	}
+	return ""
+}
+
+// matchIOC returns the ID of the first rule of caseID that matches ioc,
+// or "" when none does or s is nil.
+func (s *Suppressions) matchIOC(caseID string, ioc sandbox.IOC) string {
+	if s == nil {
+		return ""
+	}
+	value := iocKeyValue(ioc.Kind, ioc.Value)
+	for _, rule := range s.Rules(caseID) {
+		if rule.matchIOC(ioc.Kind, value) {
+			return rule.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package orchestrator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+const benignSHA256 = "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855"
+
+func TestSuppressionRules(t *testing.T) {
+	for _, tc := range []struct {
+		rule SuppressionRule
+		ok   bool
+	}{
+		{SuppressionRule{Kind: SuppressFindingKey, Pattern: "yara/BenignPacker/*"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/8"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCDomain, Pattern: "*.windowsupdate.com"}, true},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256}, true},
+		{SuppressionRule{Kind: SuppressFindingKey}, false},
+		{SuppressionRule{Kind: SuppressIOC, Pattern: "10.0.0.1"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv6, Pattern: "10.0.0.0/8"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/33"}, false},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: "abc123"}, false},
+		{SuppressionRule{Kind: "title", Pattern: "x"}, false},
+	} {
+		if err := tc.rule.validate(); (err == nil) != tc.ok || (err != nil && !errors.Is(err, ErrInvalidSuppression)) {
+			t.Errorf("validate(%+v) = %v", tc.rule, err)
+		}
+	}
+
+	var s Suppressions
+	global, err := s.Add(SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256, Reason: "empty file"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	own, err := s.Add(SuppressionRule{CaseID: "case-1", Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global.ID == "" || global.ID == own.ID || global.Created.IsZero() {
+		t.Errorf("added %+v and %+v", global, own)
+	}
+	if got := s.Rules("case-1"); len(got) != 2 {
+		t.Errorf("Rules(case-1) = %+v", got)
+	}
+	if got := s.Rules("case-2"); len(got) != 1 || got[0].ID != global.ID {
+		t.Errorf("Rules(case-2) = %+v, want the global rule", got)
+	}
+	if err := s.Remove(own.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(own.ID); !errors.Is(err, ErrUnknownSuppression) {
+		t.Errorf("Remove() twice = %v, want ErrUnknownSuppression", err)
+	}
+}
+
+func TestSuppressionMatches(t *testing.T) {
+	for _, tc := range []struct {
+		rule SuppressionRule
+		ioc  sandbox.IOC
+		want bool
+	}{
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/8"}, sandbox.IOC{Kind: sandbox.IOCIPv4, Value: "10.1.2.3"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/8"}, sandbox.IOC{Kind: sandbox.IOCIPv4, Value: "11.1.2.3"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "192.168.*.1"}, sandbox.IOC{Kind: sandbox.IOCIPv4, Value: "192.168.7.1"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv6, Pattern: "2001:db8::/32"}, sandbox.IOC{Kind: sandbox.IOCIPv6, Value: "2001:DB8::1"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv6, Pattern: "2001:db8::1"}, sandbox.IOC{Kind: sandbox.IOCIPv6, Value: "2001:db8:0::1"}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCDomain, Pattern: "*.WindowsUpdate.com"}, sandbox.IOC{Kind: sandbox.IOCDomain, Value: "dl.windowsupdate.com."}, true},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCDomain, Pattern: "*.windowsupdate.com"}, sandbox.IOC{Kind: sandbox.IOCDomain, Value: "windowsupdate.com.evil.example"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCDomain, Pattern: "*.windowsupdate.com"}, sandbox.IOC{Kind: sandbox.IOCURL, Value: "http://dl.windowsupdate.com"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCFilePath, Pattern: `C:\Windows\System32\*.dll`}, sandbox.IOC{Kind: sandbox.IOCFilePath, Value: `C:\Windows\System32\drivers\x.dll`}, true},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256}, sandbox.IOC{Kind: sandbox.IOCSHA256, Value: benignSHA256}, true},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256}, sandbox.IOC{Kind: sandbox.IOCMutex, Value: benignSHA256}, false},
+	} {
+		var s Suppressions
+		if _, err := s.Add(tc.rule); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.matchIOC("case-1", tc.ioc) != ""; got != tc.want {
+			t.Errorf("%s %q matching %s %q = %v, want %v", tc.rule.Kind, tc.rule.Pattern, tc.ioc.Kind, tc.ioc.Value, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		rule    SuppressionRule
+		finding sandbox.Result
+		want    bool
+	}{
+		{SuppressionRule{Kind: SuppressFindingKey, Pattern: "yara/BenignPacker/*"}, sandbox.Result{FindingKey: "yara/BenignPacker/ev-1"}, true},
+		{SuppressionRule{Kind: SuppressFindingKey, Pattern: "yara/BenignPacker/*"}, sandbox.Result{FindingKey: "yara/Packer/ev-1"}, false},
+		{SuppressionRule{Kind: SuppressFindingKey, Pattern: "*"}, sandbox.Result{Title: "no key"}, false},
+		{SuppressionRule{Kind: SuppressIOC, IOCKind: sandbox.IOCIPv4, Pattern: "10.0.0.0/8"}, sandbox.Result{FindingKey: "ioc/ipv4/10.9.9.9"}, true},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256}, sandbox.Result{Data: map[string]any{"sha256": benignSHA256}}, true},
+		{SuppressionRule{Kind: SuppressFileHash, Pattern: benignSHA256}, sandbox.Result{Data: map[string]any{"name": benignSHA256}}, false},
+	} {
+		var s Suppressions
+		if _, err := s.Add(tc.rule); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.matchFinding("case-1", tc.finding) != ""; got != tc.want {
+			t.Errorf("%s %q matching %+v = %v, want %v", tc.rule.Kind, tc.rule.Pattern, tc.finding, got, tc.want)
+		}
+	}
+}
+
+func TestCaseFindingsSuppression(t *testing.T) {
+	s := &Suppressions{}
+	rule, err := s.Add(SuppressionRule{CaseID: "case-1", Kind: SuppressFileHash, Pattern: benignSHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add(SuppressionRule{CaseID: "case-2", Kind: SuppressFindingKey, Pattern: "*"})
+	findings, iocs := NewCaseFindings("case-1"), NewCaseIOCs("case-1")
+	findings.Suppressions, iocs.Suppressions = s, s
+	job := Job{ID: "job-1", CaseID: "case-1"}
+	res := &JobResult{
+		Findings: []sandbox.Result{
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityLow, Title: "known file", FindingKey: "file/a", Data: map[string]any{"sha256": benignSHA256}, Techniques: []string{"T1055"}},
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityHigh, Title: "beacon", FindingKey: "ioc/ipv4/198.51.100.4"},
+			{EvidenceUID: "ev-1", Severity: sandbox.SeverityLow, Title: "known file again", FindingKey: "file/b", Data: map[string]any{"sha256": benignSHA256}},
+		},
+		IOCs: []sandbox.IOC{
+			{EvidenceUID: "ev-1", Kind: sandbox.IOCSHA256, Value: benignSHA256},
+			{EvidenceUID: "ev-1", Kind: sandbox.IOCIPv4, Value: "198.51.100.4"},
+		},
+	}
+	if err := findings.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := iocs.Add(job, res); err != nil {
+		t.Fatal(err)
+	}
+	if got := findings.Findings(); len(got) != 1 || got[0].Title != "beacon" {
+		t.Errorf("Findings() = %+v, want the beacon only", got)
+	}
+	if got := findings.Suppressed(); len(got) != 2 || got[0].SuppressedBy != rule.ID {
+		t.Errorf("Suppressed() = %+v", got)
+	}
+	if got := findings.Coverage(); len(got) != 0 {
+		t.Errorf("Coverage() = %+v, want the suppressed finding left out", got)
+	}
+	if got := iocs.IOCs(); len(got) != 1 || got[0].Kind != sandbox.IOCIPv4 {
+		t.Errorf("IOCs() = %+v", got)
+	}
+	if got, ok := iocs.Lookup(sandbox.IOCSHA256, benignSHA256); !ok || got.SuppressedBy != rule.ID {
+		t.Errorf("Lookup() = %+v, %v", got, ok)
+	}
+
+	// A report that no rule matches shows the finding again.
+	err = findings.Add(Job{ID: "job-2", CaseID: "case-1"}, &JobResult{Findings: []sandbox.Result{
+		{EvidenceUID: "ev-2", Severity: sandbox.SeverityMedium, Title: "modified file", FindingKey: "file/a", Data: map[string]any{"sha256": "0000"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findings.Findings(); len(got) != 2 || got[0].FindingKey != "file/a" || got[0].Count != 2 {
+		t.Errorf("Findings() = %+v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package orchestrator
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// paramTemplate matches a reference to a case field in a parameter value,
+// e.g. "{{.Case.Examiner}}".
+var paramTemplate = regexp.MustCompile(`\{\{\s*\.Case\.([A-Za-z]+)\s*\}\}`)
+
+// caseFields are the case fields a parameter value may reference, by
+// name; no other data is reachable from a template.
+var caseFields = map[string]func(Job) string{
+	"ID":       func(j Job) string { return j.CaseID },
+	"Name":     func(j Job) string { return j.CaseName },
+	"Number":   func(j Job) string { return j.CaseNumber },
+	"Examiner": func(j Job) string { return j.Analyst },
+}
+
+// resolveParams returns job with the case field references in the values
+// of its Params replaced by the fields of the case, e.g.
+// REPORT_AUTHOR={{.Case.Examiner}}. A reference to an unknown or empty
+// field, or any other use of "{{", fails with an *InvalidParamError
+// rather than reaching the script as is. A value is only expanded once: a
+// case field holding "{{" is rejected, so resolving again is a no-op.
+func resolveParams(job Job) (Job, error) {
+	var names []string
+	for name, value := range job.Params {
+		if strings.Contains(value, "{{") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return job, nil
+	}
+	sort.Strings(names)
+	params := make(map[string]string, len(job.Params))
+	for name, value := range job.Params {
+		params[name] = value
+	}
+	for _, name := range names {
+		value, err := expandTemplate(job, params[name])
+		if err != nil {
+			return job, &InvalidParamError{name, err.Error()}
+		}
+		params[name] = value
+	}
+	job.Params = params
+	return job, nil
+}
+
+// expandTemplate replaces the case field references in value.
+func expandTemplate(job Job, value string) (string, error) {
+	var out strings.Builder
+	for value != "" {
+		i := strings.Index(value, "{{")
+		if i < 0 {
+			out.WriteString(value)
+			break
+		}
+		out.WriteString(value[:i])
+		value = value[i:]
+		m := paramTemplate.FindStringSubmatchIndex(value)
+		if m == nil || m[0] != 0 {
+			end := strings.Index(value, "}}")
+			if end < 0 {
+				return "", fmt.Errorf("unterminated template %q", value)
+			}
+			return "", fmt.Errorf("template %s: only {{.Case.<field>}} is supported, with field one of %s", value[:end+2], fieldNames())
+		}
+		ref, field := value[:m[1]], value[m[2]:m[3]]
+		get, ok := caseFields[field]
+		if !ok {
+			return "", fmt.Errorf("template %s: unknown case field %s, want one of %s", ref, field, fieldNames())
+		}
+		v := get(job)
+		switch {
+		case v == "":
+			return "", fmt.Errorf("template %s: case field %s is not set for this job", ref, field)
+		case strings.Contains(v, "{{"):
+			return "", fmt.Errorf("template %s: case field %s contains \"{{\"", ref, field)
+		}
+		out.WriteString(v)
+		value = value[m[1]:]
+	}
+	return out.String(), nil
+}
+
+// fieldNames lists the names of caseFields, sorted.
+func fieldNames() string {
+	names := make([]string, 0, len(caseFields))
+	for name := range caseFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
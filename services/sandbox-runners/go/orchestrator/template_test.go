@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunResolvesParamTemplates(t *testing.T) {
+	rt := &fakeRuntime{}
+	job := testJob(t)
+	job.CaseName = "Exfiltration ACME"
+	job.CaseNumber = "2026-0142"
+	job.Analyst = "alice"
+	job.Params = map[string]string{
+		"PARAM_REPORT_AUTHOR": "{{.Case.Examiner}}",
+		"PARAM_REPORT_TITLE":  "Case {{ .Case.Number }}: {{.Case.Name}} ({{.Case.ID}})",
+		"PARAM_DEPTH":         "2",
+	}
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := rt.lastSpec().Env
+	if env["PARAM_REPORT_AUTHOR"] != "alice" || env["PARAM_REPORT_TITLE"] != "Case 2026-0142: Exfiltration ACME (case-1)" || env["PARAM_DEPTH"] != "2" {
+		t.Errorf("env = %v", env)
+	}
+	if job.Params["PARAM_REPORT_AUTHOR"] != "{{.Case.Examiner}}" {
+		t.Error("the job's parameters were modified")
+	}
+	if !res.Success {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestRunRejectsUnresolvedParamTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		value, reason string
+	}{
+		{"{{.Case.Password}}", "unknown case field Password"},
+		{"{{.Case.Number}}", "case field Number is not set"},
+		{"{{.Evidence.Path}}", "only {{.Case.<field>}}"},
+		{`{{printf "%s" .Case.ID}}`, "only {{.Case.<field>}}"},
+		{"by {{.Case.Examiner", "unterminated"},
+	} {
+		rt := &fakeRuntime{}
+		job := testJob(t)
+		job.Analyst = "alice"
+		job.Params = map[string]string{"PARAM_X": tc.value}
+		_, err := NewRunner(rt).Run(context.Background(), job)
+		var invalid *InvalidParamError
+		if !errors.As(err, &invalid) || invalid.Name != "PARAM_X" || !strings.Contains(invalid.Reason, tc.reason) {
+			t.Errorf("%s: err = %v, want %q", tc.value, err, tc.reason)
+		}
+		if len(rt.specs) != 0 {
+			t.Errorf("%s: container created", tc.value)
+		}
+	}
+}
+
+func TestResolveParamsOnce(t *testing.T) {
+	job := testJob(t)
+	job.CaseName = "{{.Case.ID}}"
+	job.Params = map[string]string{"PARAM_NAME": "{{.Case.Name}}"}
+	if _, err := resolveParams(job); err == nil {
+		t.Error("case field holding a template expanded")
+	}
+	job.CaseName = "ACME"
+	resolved, err := resolveParams(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := resolveParams(resolved); err != nil || again.Params["PARAM_NAME"] != "ACME" {
+		t.Errorf("resolved again = %v, %v", again.Params, err)
+	}
+}
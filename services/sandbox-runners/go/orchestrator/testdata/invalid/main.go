@@ -0,0 +1,13 @@
+// Does not compile: Validate must report the error without running it.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	var count int = "many"
+	fmt.Println(count)
+	os.WriteFile("/output/ran", nil, 0o644)
+}
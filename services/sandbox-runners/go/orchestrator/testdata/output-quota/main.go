@@ -0,0 +1,32 @@
+// Writes past the output quota; exits 0 only if the write fails with
+// ENOSPC instead of filling the disk.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func main() {
+	f, err := os.Create(filepath.Join(os.Getenv("OUTPUT_DIR"), "dump.bin"))
+	if err != nil {
+		fmt.Println("create:", err)
+		os.Exit(1)
+	}
+	chunk := make([]byte, 64<<10)
+	for written := 0; written < 16<<20; written += len(chunk) {
+		if _, err := f.Write(chunk); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				fmt.Printf("write refused after %d bytes: %v\n", written, err)
+				return
+			}
+			fmt.Println("unexpected error:", err)
+			os.Exit(4)
+		}
+	}
+	fmt.Println("quota not enforced")
+	os.Exit(3)
+}
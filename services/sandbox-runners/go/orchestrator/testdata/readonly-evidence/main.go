@@ -0,0 +1,23 @@
+// Tries to overwrite the mounted evidence; exits 0 only if that is refused.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+func main() {
+	err := os.WriteFile(os.Getenv("EVIDENCE_PATH"), []byte("tampered"), 0644)
+	if err == nil {
+		fmt.Println("evidence is writable")
+		os.Exit(3)
+	}
+	if !errors.Is(err, fs.ErrPermission) && !errors.Is(err, syscall.EROFS) {
+		fmt.Println("unexpected error:", err)
+		os.Exit(4)
+	}
+	fmt.Println("write refused:", err)
+}
@@ -0,0 +1,32 @@
+// Reads the evidence and touches 64 MiB of memory so that the job has
+// measurable CPU time and peak memory.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	f, err := os.Open(os.Getenv("EVIDENCE_PATH"))
+	if err != nil {
+		fmt.Println("open:", err)
+		os.Exit(1)
+	}
+	n, err := io.Copy(io.Discard, f)
+	if err != nil {
+		fmt.Println("read:", err)
+		os.Exit(1)
+	}
+	buf := make([]byte, 64<<20)
+	for i := range buf {
+		buf[i] = byte(i)
+	}
+	out := fmt.Sprintf("read %d bytes, checksum %d\n", n, buf[len(buf)-1])
+	if err := os.WriteFile(filepath.Join(os.Getenv("OUTPUT_DIR"), "report.txt"), []byte(out), 0o644); err != nil {
+		fmt.Println("write:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,7 @@
+module script
+
+go 1.21
+
+require github.com/St0n14/datamortem/services/sandbox-runners/go v0.0.0
+
+replace github.com/St0n14/datamortem/services/sandbox-runners/go => /opt/datamortem-sdk
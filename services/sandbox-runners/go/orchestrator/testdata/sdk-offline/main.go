@@ -0,0 +1,22 @@
+// Imports the SDK, whose dependencies must resolve from the module cache
+// of the image under --network none, and emits a finding.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func main() {
+	err := sandbox.EmitResult(sandbox.Result{
+		EvidenceUID: os.Getenv(sandbox.EnvEvidenceUID),
+		Severity:    sandbox.SeverityLow,
+		Title:       "built offline",
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
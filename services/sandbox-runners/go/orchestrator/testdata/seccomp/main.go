@@ -0,0 +1,43 @@
+// Checks the sandbox security options: the evidence can be memory mapped,
+// while ptrace, mount, namespaces and sockets are refused. Exits 0 only if
+// every check passes.
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func main() {
+	failed := false
+	check := func(name string, ok bool, detail any) {
+		fmt.Printf("%s: ok=%v (%v)\n", name, ok, detail)
+		failed = failed || !ok
+	}
+
+	f, err := os.Open(os.Getenv("EVIDENCE_PATH"))
+	if err != nil {
+		check("open evidence", false, err)
+		os.Exit(1)
+	}
+	info, _ := f.Stat()
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	check("mmap evidence", err == nil, err)
+	if err == nil {
+		syscall.Munmap(data)
+	}
+
+	err = syscall.PtraceAttach(os.Getppid())
+	check("ptrace refused", err == syscall.EPERM, err)
+	err = syscall.Mount("tmpfs", "/workspace", "tmpfs", 0, "")
+	check("mount refused", err == syscall.EPERM, err)
+	err = syscall.Unshare(syscall.CLONE_NEWUSER)
+	check("unshare refused", err == syscall.EPERM, err)
+	_, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	check("socket refused", err == syscall.EPERM, err)
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,19 @@
+package orchestrator
+
+import (
+	"sort"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectTimeline reads the timeline the script wrote with
+// sandbox.EmitTimelineEvent, in time order. Lines that fail validation
+// are dropped and reported as err. It reads up to limit events when limit
+// is positive, the first ones written; total counts them all.
+func collectTimeline(dir string, limit int) ([]sandbox.TimelineEvent, int, error) {
+	events, total, err := sandbox.ReadTimelineUpTo(dir, limit)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, total, err
+}
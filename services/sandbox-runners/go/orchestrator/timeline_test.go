@@ -0,0 +1,33 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunCollectsTimeline(t *testing.T) {
+	job := testJob(t)
+	rt := &fakeRuntime{onStart: func(ContainerSpec) {
+		data := `{"timestamp":"2024-03-01T12:00:00.000000000Z","evidence_uid":"ev-1","source":"evtx","message":"logon"}
+{"timestamp":"2024-03-01T09:30:00.000000000Z","evidence_uid":"ev-1","source":"prefetch","message":"run"}
+{"timestamp":"","source":"broken","message":"no time"}
+`
+		os.WriteFile(filepath.Join(job.OutputDir, "timeline.ndjson"), []byte(data), 0o644)
+	}}
+
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Timeline) != 2 || res.Timeline[0].Source != "prefetch" || res.Timeline[1].Source != "evtx" {
+		t.Errorf("timeline = %+v, want two events in time order", res.Timeline)
+	}
+	if res.TimelineError == "" {
+		t.Error("TimelineError not set for the malformed line")
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("timeline collected as an artifact: %+v", res.Artifacts)
+	}
+}
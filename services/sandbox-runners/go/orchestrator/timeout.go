@@ -0,0 +1,119 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrBuildTimeout is wrapped by the BuildTimeoutError of a job whose build
+// phase outlasted ExecConfig.BuildTimeout.
+var ErrBuildTimeout = errors.New("orchestrator: build timed out")
+
+// BuildTimeoutError is returned for a job whose vendoring, dependency scan
+// or compilation did not finish within ExecConfig.BuildTimeout, e.g. on a
+// module resolution that hangs; Runner.Run reports it as a
+// FailureCompileTimeout result. The script was not run.
+type BuildTimeoutError struct {
+	Timeout time.Duration
+	// Err is the error of the step that was cut short, if it returned
+	// one.
+	Err error
+}
+
+func (e *BuildTimeoutError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%v after %s", ErrBuildTimeout, e.Timeout)
+	}
+	return fmt.Sprintf("%v after %s: %v", ErrBuildTimeout, e.Timeout, e.Err)
+}
+
+func (e *BuildTimeoutError) Unwrap() error { return ErrBuildTimeout }
+
+// compileTimeoutMarker is created in OutputDir by goCompileWrapper when
+// the compilation of a `go run` job outlasted its build timeout.
+const compileTimeoutMarker = ".compile-timeout"
+
+// goCompileWrapper compiles the package of `go run` ("$@" after the
+// timeout in seconds, "$1", and the path of compileTimeoutMarker, "$2")
+// before running it, so that the compilation, which `go run` does in the
+// container, is bounded by the build timeout rather than only the run one.
+// The packages it compiles are kept in GOCACHE: `go run` then only links
+// them. A compilation cut short leaves the marker and exits with code 124;
+// a compile error exits as `go run` would have.
+const goCompileWrapper = `t=$1 marker=$2
+shift 4
+go build -buildvcs=false -o /dev/null "$@" &
+pid=$!
+(
+	i=0
+	while [ $i -lt $t ] && kill -0 $pid 2>/dev/null; do sleep 1; i=$((i+1)); done
+	if kill -0 $pid 2>/dev/null; then
+		: > "$marker"
+		kill -TERM $pid
+	fi
+) &
+dog=$!
+wait $pid
+code=$?
+kill $dog 2>/dev/null
+[ ! -e "$marker" ] || exit 124
+[ $code -eq 0 ] || exit $code
+exec go run "$@"`
+
+// boundGoCompile wraps cmd, a `go run` command, with goCompileWrapper
+// under timeout; other commands are returned as is.
+func boundGoCompile(cmd []string, timeout time.Duration) []string {
+	if len(cmd) < 2 || cmd[0] != "go" || cmd[1] != "run" {
+		return cmd
+	}
+	secs := max(int((timeout+time.Second-1)/time.Second), 1)
+	marker := path.Join(containerOutputDir, compileTimeoutMarker)
+	return append([]string{"sh", "-c", goCompileWrapper, "sh", strconv.Itoa(secs), marker}, cmd...)
+}
+
+// takeCompileTimeout reports whether goCompileWrapper stopped the
+// compilation of the job in dir, removing its marker so that it is not
+// collected as an output.
+func takeCompileTimeout(dir string) (bool, error) {
+	err := os.Remove(filepath.Join(dir, compileTimeoutMarker))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// buildTimeout returns a BuildTimeoutError in place of err when buildCtx,
+// the build phase of a job under ctx, ran out of cfg.BuildTimeout, and err
+// otherwise. A cancelled job is not a timeout.
+func buildTimeout(ctx, buildCtx context.Context, cfg ExecConfig, err error) error {
+	if ctx.Err() == nil && errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+		return &BuildTimeoutError{Timeout: cfg.buildTimeout(), Err: err}
+	}
+	return err
+}
+
+// buildTimeoutFailure records the result of a job whose build timed out,
+// and reports whether err is such a timeout.
+func (r *Runner) buildTimeoutFailure(job Job, err error) (*JobResult, bool) {
+	var tErr *BuildTimeoutError
+	if !errors.As(err, &tErr) {
+		return nil, false
+	}
+	res, rerr := r.result(job, r.execConfig(job), ContainerState{}, false, 0, "", "")
+	if rerr != nil {
+		return nil, false
+	}
+	res.Success, res.BuildTimedOut = false, true
+	res.FailureReason = FailureCompileTimeout
+	res.FailureDetail = fmt.Sprintf("build stopped after the %s build timeout", tErr.Timeout)
+	res.Attempts = 1
+	r.record(job, res)
+	return res, true
+}
@@ -0,0 +1,162 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowBuild makes the build containers of a job take d.
+func slowBuild(d time.Duration) func(ContainerSpec) {
+	return func(spec ContainerSpec) {
+		if len(spec.Cmd) > 1 && spec.Cmd[1] == "build" {
+			time.Sleep(d)
+		}
+		fakeBuild(spec)
+	}
+}
+
+func TestRunBuildTimeout(t *testing.T) {
+	rt := &fakeRuntime{onStart: slowBuild(100 * time.Millisecond)}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+	cfg := DefaultExecConfig()
+	cfg.BuildTimeout = 20 * time.Millisecond
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || !res.BuildTimedOut || res.TimedOut {
+		t.Errorf("success %v, build timed out %v, timed out %v", res.Success, res.BuildTimedOut, res.TimedOut)
+	}
+	if res.FailureReason != FailureCompileTimeout || res.FailureDetail != "build stopped after the 20ms build timeout" {
+		t.Errorf("failure = %s: %q", res.FailureReason, res.FailureDetail)
+	}
+	// Only the build container was created: the script did not run.
+	if len(rt.specs) != 1 {
+		t.Errorf("%d containers created", len(rt.specs))
+	}
+
+	// A build past its timeout may still have reached the cache.
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	_, err = r.Start(context.Background(), job)
+	var tErr *BuildTimeoutError
+	if !errors.As(err, &tErr) || !errors.Is(err, ErrBuildTimeout) || Retryable(err) {
+		t.Errorf("Start() = %v, want a BuildTimeoutError", err)
+	}
+}
+
+func TestRunTimeoutExcludesBuild(t *testing.T) {
+	rt := &fakeRuntime{onStart: slowBuild(100 * time.Millisecond)}
+	r := NewRunner(rt)
+	r.BuildCache = &BuildCache{Dir: t.TempDir()}
+	job := testJob(t)
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("package main"), 0o644)
+	cfg := DefaultExecConfig()
+	// The build takes longer than the run may, and still fits its own
+	// timeout.
+	cfg.RunTimeout = 50 * time.Millisecond
+	job.Config = &cfg
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.TimedOut || res.BuildTimedOut || res.BinarySHA256 == "" {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+// goRunCmd is the command of a `go run` job without BuildCache: its
+// compilation bounded by DefaultBuildTimeout, in the wrapper that passes
+// SIGTERM on.
+func goRunCmd(run ...string) []string {
+	return wrapGoRun(boundGoCompile(run, DefaultBuildTimeout))
+}
+
+func TestRunGoCompileTimeout(t *testing.T) {
+	job := testJob(t)
+	// The wrapper stopped `go build` and exited with 124.
+	rt := &fakeRuntime{state: ContainerState{ExitCode: 124}, onStart: func(ContainerSpec) {
+		os.WriteFile(filepath.Join(job.OutputDir, compileTimeoutMarker), nil, 0o644)
+	}}
+	cfg := DefaultExecConfig()
+	cfg.BuildTimeout = 90 * time.Second
+	job.Config = &cfg
+
+	res, err := NewRunner(rt).Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rt.lastSpec().Cmd, wrapGoRun(boundGoCompile([]string{"go", "run", "."}, 90*time.Second)); !reflect.DeepEqual(got, want) {
+		t.Errorf("cmd = %q, want %q", got, want)
+	}
+	if res.Success || !res.BuildTimedOut || res.TimedOut || res.FailureReason != FailureCompileTimeout {
+		t.Errorf("success %v, build timed out %v, timed out %v, failure %s", res.Success, res.BuildTimedOut, res.TimedOut, res.FailureReason)
+	}
+	if len(res.Outputs) != 0 {
+		t.Errorf("outputs = %v, want the marker taken", res.Outputs)
+	}
+}
+
+func TestGoCompileWrapper(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not in PATH")
+	}
+	// A fake go records its runs; its build takes $BUILD_SECONDS.
+	bin, dir := t.TempDir(), t.TempDir()
+	log, marker := filepath.Join(dir, "log"), filepath.Join(dir, compileTimeoutMarker)
+	os.WriteFile(filepath.Join(bin, "go"), []byte("#!/bin/sh\necho \"$@\" >> "+log+"\n"+
+		"[ \"$1\" != build ] || sleep ${BUILD_SECONDS:-0}\n"), 0o755)
+	run := func(buildSeconds string) (int, string) {
+		os.Remove(log)
+		os.Remove(marker)
+		cmd := exec.Command("sh", "-c", goCompileWrapper, "sh", "1", marker, "go", "run", "-tags=x", ".")
+		cmd.Env = []string{"PATH=" + bin + ":" + os.Getenv("PATH"), "BUILD_SECONDS=" + buildSeconds}
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(log)
+		return cmd.ProcessState.ExitCode(), string(data)
+	}
+
+	if code, runs := run("0"); code != 0 || runs != "build -buildvcs=false -o /dev/null -tags=x .\nrun -tags=x .\n" {
+		t.Errorf("exit %d, runs %q", code, runs)
+	}
+	if code, runs := run("5"); code != 124 || strings.Contains(runs, "run ") {
+		t.Errorf("slow build: exit %d, runs %q, want 124 without running", code, runs)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("marker: %v", err)
+	}
+}
+
+func TestDeprecatedTimeout(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	// Code written before RunTimeout sets Timeout on the defaults.
+	r.Defaults.Timeout = time.Hour
+	job := testJob(t)
+	cfg, err := r.jobConfig(job)
+	if err != nil || cfg.runTimeout() != time.Hour {
+		t.Errorf("run timeout = %s, %v, want 1h", cfg.runTimeout(), err)
+	}
+	os.WriteFile(filepath.Join(job.Workspace, "main.go"), []byte("// sandbox:requires timeout=2h\npackage main\n"), 0o644)
+	if cfg, err := r.jobConfig(job); err != nil || cfg.runTimeout() != 2*time.Hour {
+		t.Errorf("required run timeout = %s, %v, want 2h", cfg.runTimeout(), err)
+	}
+	if DefaultTimeout != DefaultRunTimeout || FailureTimeout.Canonical() != FailureRunTimeout || FailureOOMKilled.Canonical() != FailureOOMKilled {
+		t.Error("deprecated aliases changed")
+	}
+}
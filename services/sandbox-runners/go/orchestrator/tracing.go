@@ -0,0 +1,446 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultServiceName is the service.name of the spans of a Tracer without
+// a ServiceName.
+const DefaultServiceName = "datamortem-sandbox-runner"
+
+// traceScope is the instrumentation scope of the spans.
+const traceScope = "github.com/St0n14/datamortem/services/sandbox-runners/go/orchestrator"
+
+// Names of the spans of a job. The job span covers the whole run, queue
+// wait included, and the others its phases.
+const (
+	spanPipeline  = "pipeline"
+	spanJob       = "job"
+	spanQueue     = "queue"
+	spanEvidence  = "evidence"
+	spanImage     = "image"
+	spanBuild     = "build"
+	spanExecution = "execution"
+	spanIngestion = "ingestion"
+)
+
+// Tracer exports the spans of jobs and pipelines as OpenTelemetry traces,
+// over OTLP/HTTP in JSON, to Endpoint. Each job is a "job" span with a
+// child per phase: "queue" (the wait in a WorkerPool), "evidence" (its
+// preparation: download, decryption, decompression, devices and
+// overlays), "image" (resolving, and pulling, the runner image), "build",
+// "execution" (the container running) and "ingestion" (collecting the
+// outputs). A job whose Job.TraceParent is set is part of that trace, as
+// the stages of a pipeline are of the pipeline's.
+//
+// A nil Tracer, or one without an Endpoint, records nothing. The spans of
+// a job are sent together once it ends, in the background: Close waits
+// for the exports in flight.
+type Tracer struct {
+	// Endpoint is the URL spans are posted to, e.g.
+	// "http://otel-collector:4318/v1/traces".
+	Endpoint string
+	// ServiceName is the service.name resource attribute,
+	// DefaultServiceName when empty.
+	ServiceName string
+	// Headers are sent with each export, e.g. an API key of the tracing
+	// backend.
+	Headers map[string]string
+	// Client is http.DefaultClient when nil.
+	Client *http.Client
+
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+}
+
+// TracerFromEnv returns a Tracer configured by the standard OpenTelemetry
+// variables: OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or
+// OTEL_EXPORTER_OTLP_ENDPOINT followed by /v1/traces, and
+// OTEL_SERVICE_NAME. It returns nil, which records nothing, when no
+// endpoint is set.
+func TracerFromEnv() *Tracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil
+	}
+	return &Tracer{Endpoint: endpoint, ServiceName: os.Getenv("OTEL_SERVICE_NAME")}
+}
+
+// Dropped returns the number of spans that could not be exported.
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return t.dropped.Load()
+}
+
+// Close waits for the exports in flight.
+func (t *Tracer) Close() {
+	if t != nil {
+		t.wg.Wait()
+	}
+}
+
+// enabled reports whether t records spans.
+func (t *Tracer) enabled() bool {
+	return t != nil && t.Endpoint != ""
+}
+
+// traceAttr is an attribute of a span.
+type traceAttr struct {
+	key, value string
+}
+
+// jobAttrs are the attributes identifying job.
+func jobAttrs(job Job) []traceAttr {
+	attrs := []traceAttr{{"datamortem.job.id", job.ID}, {"datamortem.case.id", job.CaseID}}
+	if job.ParentID != "" {
+		attrs = append(attrs, traceAttr{"datamortem.parent.id", job.ParentID})
+	}
+	if job.Evidence.UID != "" {
+		attrs = append(attrs, traceAttr{"datamortem.evidence.uid", job.Evidence.UID})
+	}
+	if job.Language != "" {
+		attrs = append(attrs, traceAttr{"datamortem.language", languageKey(job.Language)})
+	}
+	return attrs
+}
+
+// traceSpan is a span being recorded. The root span of a trace in this
+// process collects the spans ended under it and exports them as it ends.
+// The methods of a nil traceSpan do nothing.
+type traceSpan struct {
+	tracer   *Tracer
+	root     *traceSpan
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	attrs    []traceAttr
+
+	mu    sync.Mutex
+	ended []spanRecord
+}
+
+// spanRecord is an ended span, as exported.
+type spanRecord struct {
+	traceID, spanID, parentID string
+	name                      string
+	start, end                time.Time
+	attrs                     []traceAttr
+	err                       string
+}
+
+// startSpan starts a root span named name at start, a child of the span
+// of the W3C traceparent header parent when valid, and of a new trace
+// otherwise: an invalid header is ignored, as the recommendation says.
+func (t *Tracer) startSpan(parent, name string, start time.Time, attrs ...traceAttr) *traceSpan {
+	if !t.enabled() {
+		return nil
+	}
+	s := &traceSpan{tracer: t, name: name, start: start, attrs: attrs}
+	s.root = s
+	if traceID, spanID, ok := parseTraceParent(parent); ok {
+		s.traceID, s.parentID = traceID, spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// child starts a span named name at start under s.
+func (s *traceSpan) child(name string, start time.Time, attrs ...traceAttr) *traceSpan {
+	if s == nil {
+		return nil
+	}
+	c := &traceSpan{tracer: s.tracer, root: s.root, traceID: s.traceID, parentID: s.spanID, name: name, start: start, attrs: attrs}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// end ends s now, failed when err is not nil.
+func (s *traceSpan) end(err error) {
+	s.endAt(time.Now(), err)
+}
+
+// endAt ends s at end. Ending the root span exports the spans ended under
+// it.
+func (s *traceSpan) endAt(end time.Time, err error) {
+	if s == nil {
+		return
+	}
+	rec := spanRecord{
+		traceID: hex.EncodeToString(s.traceID[:]),
+		spanID:  hex.EncodeToString(s.spanID[:]),
+		name:    s.name,
+		start:   s.start,
+		end:     end,
+		attrs:   s.attrs,
+	}
+	if s.parentID != [8]byte{} {
+		rec.parentID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		rec.err = err.Error()
+	}
+	s.root.mu.Lock()
+	s.root.ended = append(s.root.ended, rec)
+	var spans []spanRecord
+	if s == s.root {
+		spans, s.root.ended = s.root.ended, nil
+	}
+	s.root.mu.Unlock()
+	if spans != nil {
+		s.tracer.export(spans)
+	}
+}
+
+// traceParent returns the W3C traceparent header of s, "" for a nil span.
+func (s *traceSpan) traceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// traceIDHex returns the trace ID of s in hex, "" for a nil span.
+func (s *traceSpan) traceIDHex() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// parseTraceParent parses a W3C traceparent header,
+// "00-<trace ID>-<parent span ID>-<flags>".
+func parseTraceParent(header string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, traceID != [16]byte{} && spanID != [8]byte{}
+}
+
+// export posts spans in the background.
+func (t *Tracer) export(spans []spanRecord) {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		t.dropped.Add(uint64(len(spans)))
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		if err := t.post(body); err != nil {
+			t.dropped.Add(uint64(len(spans)))
+		}
+	}()
+}
+
+func (t *Tracer) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("orchestrator: export spans: %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON messages, as in opentelemetry/proto/collector/trace/v1.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID      string      `json:"traceId"`
+		SpanID       string      `json:"spanId"`
+		ParentSpanID string      `json:"parentSpanId,omitempty"`
+		Name         string      `json:"name"`
+		Kind         int         `json:"kind"`
+		Start        string      `json:"startTimeUnixNano"`
+		End          string      `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr  `json:"attributes,omitempty"`
+		Status       *otlpStatus `json:"status,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP enumeration values.
+const (
+	otlpKindInternal = 1
+	otlpStatusError  = 2
+)
+
+func otlpAttrs(attrs []traceAttr) []otlpAttr {
+	out := make([]otlpAttr, len(attrs))
+	for i, a := range attrs {
+		out[i].Key, out[i].Value.StringValue = a.key, a.value
+	}
+	return out
+}
+
+// request builds the export request of spans.
+func (t *Tracer) request(spans []spanRecord) otlpRequest {
+	service := t.ServiceName
+	if service == "" {
+		service = DefaultServiceName
+	}
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = traceScope
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:      s.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parentID,
+			Name:         s.name,
+			Kind:         otlpKindInternal,
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:   otlpAttrs(s.attrs),
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: s.err}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttrs([]traceAttr{{"service.name", service}})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
+
+// spanKey and queuedKey are the context keys of the job span a job runs
+// under and of when it was submitted to a WorkerPool.
+type (
+	spanKey   struct{}
+	queuedKey struct{}
+)
+
+func contextWithSpan(ctx context.Context, s *traceSpan) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// spanFrom returns the job span of ctx, nil if none.
+func spanFrom(ctx context.Context) *traceSpan {
+	s, _ := ctx.Value(spanKey{}).(*traceSpan)
+	return s
+}
+
+// withQueued records in ctx that the job waited in a queue since
+// submitted.
+func withQueued(ctx context.Context, submitted time.Time) context.Context {
+	return context.WithValue(ctx, queuedKey{}, submitted)
+}
+
+// startJobSpan starts the span of job, from its submission to a
+// WorkerPool if it waited in one, and returns ctx carrying it. The span is
+// a child of job.TraceParent, or else of the job span of ctx, e.g. that
+// of the job a fan-out child is run from.
+func (t *Tracer) startJobSpan(ctx context.Context, job Job) (context.Context, *traceSpan) {
+	if !t.enabled() {
+		return ctx, nil
+	}
+	now := time.Now()
+	submitted, queued := ctx.Value(queuedKey{}).(time.Time)
+	start := now
+	if queued {
+		start = submitted
+	}
+	parent := job.TraceParent
+	if parent == "" {
+		parent = spanFrom(ctx).traceParent()
+	}
+	s := t.startSpan(parent, spanJob, start, jobAttrs(job)...)
+	if queued {
+		s.child(spanQueue, submitted).endAt(now, nil)
+	}
+	return contextWithSpan(ctx, s), s
+}
+
+// endJob ends the job span s with the outcome of the job.
+func (s *traceSpan) endJob(res *JobResult, err error) {
+	if s == nil {
+		return
+	}
+	if err == nil && res != nil && !res.Success && !res.NotApplicable {
+		err = errors.New(string(res.FailureReason))
+		if res.FailureDetail != "" {
+			err = fmt.Errorf("%s: %s", res.FailureReason, res.FailureDetail)
+		}
+	}
+	if res != nil {
+		s.attrs = append(s.attrs, traceAttr{"datamortem.job.attempts", strconv.Itoa(res.Attempts)})
+		if res.FromCache {
+			s.attrs = append(s.attrs, traceAttr{"datamortem.job.from_cache", "true"})
+		}
+	}
+	s.end(err)
+}
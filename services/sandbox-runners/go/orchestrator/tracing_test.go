@@ -0,0 +1,166 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collector is an OTLP/HTTP endpoint recording the spans it is sent.
+type collector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+	auth  []string
+}
+
+func newCollector(t *testing.T) (*collector, *Tracer) {
+	t.Helper()
+	c := &collector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body otlpRequest
+		if req.URL.Path != "/v1/traces" || json.NewDecoder(req.Body).Decode(&body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.auth = append(c.auth, req.Header.Get("Authorization"))
+		for _, rs := range body.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return c, &Tracer{Endpoint: srv.URL + "/v1/traces", Headers: map[string]string{"Authorization": "Bearer k"}}
+}
+
+// byName indexes the spans by name; only the last of a name is kept.
+func (c *collector) byName() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := map[string]otlpSpan{}
+	for _, s := range c.spans {
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func spanAttr(s otlpSpan, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue
+		}
+	}
+	return ""
+}
+
+func TestRunExportsSpans(t *testing.T) {
+	c, tracer := newCollector(t)
+	r := NewRunner(&fakeRuntime{})
+	r.Tracer = tracer
+	job := testJob(t)
+	job.TraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	res, err := r.Run(context.Background(), job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer.Close()
+	if res.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %q", res.TraceID)
+	}
+	spans := c.byName()
+	root, ok := spans[spanJob]
+	if !ok || root.ParentSpanID != "00f067aa0ba902b7" || spanAttr(root, "datamortem.job.id") != "job-1" || spanAttr(root, "datamortem.case.id") != "case-1" {
+		t.Fatalf("job span = %+v", root)
+	}
+	for _, name := range []string{spanEvidence, spanImage, spanBuild, spanExecution, spanIngestion} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if s.TraceID != root.TraceID || s.ParentSpanID != root.SpanID || s.Start > s.End {
+			t.Errorf("%s span = %+v, want a child of %s", name, s, root.SpanID)
+		}
+	}
+	if _, ok := spans[spanQueue]; ok {
+		t.Error("queue span for a job run directly")
+	}
+	if c.auth[0] != "Bearer k" {
+		t.Errorf("Authorization = %q", c.auth[0])
+	}
+}
+
+func TestPipelineIsOneTrace(t *testing.T) {
+	c, tracer := newCollector(t)
+	r := NewRunner(&fakeRuntime{})
+	r.Tracer = tracer
+	w, _ := NewWorkerPool(r, WorkerPoolConfig{MaxConcurrent: 1, QueueDepth: 10})
+	defer w.Close()
+	template := testJob(t)
+	run, err := StartPipeline(context.Background(), w, Pipeline{
+		ID:        "pipe-1",
+		CaseID:    "case-1",
+		Evidence:  template.Evidence,
+		OutputDir: t.TempDir(),
+		Stages: []PipelineStage{
+			{Name: "carve", Job: template},
+			{Name: "pe", Job: template, After: []string{"carve"}},
+		},
+		Tracer: tracer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Close()
+	pipeline, ok := c.byName()[spanPipeline]
+	if !ok || pipeline.ParentSpanID != "" || pipeline.TraceID != run.TraceID {
+		t.Fatalf("pipeline span = %+v", pipeline)
+	}
+	jobs, queued := map[string]bool{}, 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.spans {
+		if s.TraceID != pipeline.TraceID {
+			t.Errorf("%s span in trace %s, want %s", s.Name, s.TraceID, pipeline.TraceID)
+		}
+		switch s.Name {
+		case spanJob:
+			if s.ParentSpanID != pipeline.SpanID {
+				t.Errorf("job span %+v is not a child of the pipeline", s)
+			}
+			jobs[spanAttr(s, "datamortem.job.id")] = true
+		case spanQueue:
+			queued++
+		}
+	}
+	if !jobs["pipe-1-carve"] || !jobs["pipe-1-pe"] || queued != 2 {
+		t.Errorf("job spans %v, %d queue spans", jobs, queued)
+	}
+}
+
+func TestTracerDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if tracer := TracerFromEnv(); tracer != nil {
+		t.Errorf("TracerFromEnv() = %+v without an endpoint", tracer)
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+	if tracer := TracerFromEnv(); tracer == nil || tracer.Endpoint != "http://collector:4318/v1/traces" {
+		t.Errorf("TracerFromEnv() = %+v", tracer)
+	}
+	r := NewRunner(&fakeRuntime{})
+	r.Tracer = &Tracer{}
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil || res.TraceID != "" {
+		t.Errorf("Run() without an endpoint = %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,208 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is one error or warning reported by the Go toolchain.
+type Diagnostic struct {
+	// File is relative to the workspace.
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// ValidationResult is the outcome of Runner.Validate.
+type ValidationResult struct {
+	// Valid reports that the script builds; vet warnings do not make it
+	// invalid.
+	Valid bool
+	// ModuleProblems are the problems of its go.mod and go.sum, found
+	// before the build, which is not attempted when there are any.
+	ModuleProblems []ModuleProblem
+	// CompileErrors are the errors of `go build`.
+	CompileErrors []Diagnostic
+	// UnresolvedImports lists the imported packages, or modules missing
+	// from the vendor tree, that could not be resolved.
+	UnresolvedImports []string
+	// VetWarnings are the findings of `go vet`, which only runs once the
+	// script builds.
+	VetWarnings []Diagnostic
+	// Output is the toolchain's output, for failures that are not parsed
+	// into the fields above.
+	Output string
+}
+
+var (
+	diagnosticLine = regexp.MustCompile(`^(\S+\.go):(\d+)(?::(\d+))?: (.*)$`)
+	unresolvedLine = []*regexp.Regexp{
+		regexp.MustCompile(`no required module provides package ([^\s;:]+)`),
+		regexp.MustCompile(`cannot find module providing package ([^\s;:]+)`),
+		regexp.MustCompile(`missing go\.sum entry for module providing package ([^\s;:]+)`),
+		regexp.MustCompile(`package ([^\s;:]+) is not in (?:GOROOT|std)`),
+		regexp.MustCompile(`cannot find package "([^"]+)"`),
+	}
+)
+
+// Validate checks the go.mod and go.sum of the Go script in workspace,
+// resolving its modules through the Runner.Vendor proxy when it has one
+// and the runner defaults are not Offline, then that the script compiles
+// and that its imports resolve, and runs `go vet` on it, without running
+// the script.
+// The toolchain runs in the sandbox image with the runner defaults, the
+// workspace read-only and an empty dummy evidence. The error only reports
+// failures to run the checks.
+func (r *Runner) Validate(ctx context.Context, workspace string) (*ValidationResult, error) {
+	scratch, err := os.MkdirTemp("", "datamortem-validate-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scratch)
+	evidence := filepath.Join(scratch, "evidence.raw")
+	output := filepath.Join(scratch, "output")
+	if err := os.WriteFile(evidence, nil, 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(output, 0o777); err != nil {
+		return nil, err
+	}
+	job := Job{
+		ID:        "validate",
+		CaseID:    "validate",
+		Evidence:  Evidence{UID: "validate", Path: evidence},
+		Language:  LanguageGo,
+		Workspace: workspace,
+		OutputDir: output,
+	}
+	cfg := r.Defaults
+	ctx, cancel := context.WithTimeout(ctx, cfg.runTimeout())
+	defer cancel()
+
+	spec, err := r.containerSpec(job, cfg)
+	if err != nil {
+		return nil, err
+	}
+	res := &ValidationResult{}
+	// Vendored builds do not read go.sum.
+	problems, err := moduleProblems(workspace, nil, r.ModuleAllowlist, !cfg.Offline)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) == 0 && !cfg.Offline && r.Vendor != nil && r.Vendor.Proxy != "" {
+		if problems, err = r.resolveModules(ctx, workspace, spec); err != nil {
+			return nil, err
+		}
+	}
+	if len(problems) > 0 {
+		res.ModuleProblems = problems
+		return res, nil
+	}
+	if cfg.Offline {
+		var missing *MissingVendorError
+		err := r.prepareOffline(ctx, job, spec)
+		if errors.As(err, &missing) {
+			res.UnresolvedImports = missing.Modules
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	spec.Network = string(NetworkNone)
+	spec.Mounts[0].ReadOnly = true
+	// Nothing is executed from the build directory, so it can live on the
+	// noexec /tmp.
+	spec.Env["GOTMPDIR"] = containerTmp
+
+	spec.Cmd = []string{"go", "build", "-o", "/dev/null", "."}
+	code, out, err := r.runToCompletion(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("go build: %w", err)
+	}
+	if code != 0 {
+		res.CompileErrors, res.UnresolvedImports = parseBuildOutput(out)
+		res.Output = out
+		return res, nil
+	}
+	res.Valid = true
+
+	spec.Cmd = []string{"go", "vet", "./..."}
+	code, out, err = r.runToCompletion(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("go vet: %w", err)
+	}
+	if code != 0 {
+		res.VetWarnings, _ = parseBuildOutput(out)
+		res.Output = out
+	}
+	return res, nil
+}
+
+// runToCompletion runs spec and returns its exit code and combined output.
+func (r *Runner) runToCompletion(ctx context.Context, spec ContainerSpec) (int, string, error) {
+	id, err := r.createContainer(ctx, spec)
+	if err != nil {
+		return 0, "", err
+	}
+	bg := context.WithoutCancel(ctx)
+	defer r.removeContainer(bg, id)
+	if err := r.Runtime.Start(ctx, id); err != nil {
+		return 0, "", err
+	}
+	state, err := r.Runtime.Wait(ctx, id)
+	if err != nil {
+		return 0, "", err
+	}
+	var out bytes.Buffer
+	if err := r.Runtime.Logs(bg, id, &out, &out); err != nil {
+		return 0, "", err
+	}
+	return state.ExitCode, out.String(), nil
+}
+
+// parseBuildOutput splits the output of `go build` or `go vet` into
+// diagnostics and unresolved import paths.
+func parseBuildOutput(out string) (diags []Diagnostic, unresolved []string) {
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if pkg := unresolvedPackage(line); pkg != "" {
+			if !seen[pkg] {
+				seen[pkg] = true
+				unresolved = append(unresolved, pkg)
+			}
+			continue
+		}
+		m := diagnosticLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		d := Diagnostic{File: strings.TrimPrefix(m[1], "./"), Message: m[4]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diags = append(diags, d)
+	}
+	return diags, unresolved
+}
+
+func unresolvedPackage(line string) string {
+	for _, re := range unresolvedLine {
+		if m := re.FindStringSubmatch(line); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
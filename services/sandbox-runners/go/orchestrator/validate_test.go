@@ -0,0 +1,69 @@
+package orchestrator
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateCompileErrors(t *testing.T) {
+	rt := &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		return ContainerState{ExitCode: 1}, "# script\n" +
+			"./main.go:6:14: cannot use \"s\" (untyped string constant) as int value in variable declaration\n" +
+			"main.go:5:2: no required module provides package github.com/foo/bar; to add it:\n" +
+			"\tgo get github.com/foo/bar\n" +
+			"utils.go:4:8: package notstd/pkg is not in std (/usr/local/go/src/notstd/pkg)\n"
+	}}
+	res, err := NewRunner(rt).Validate(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid {
+		t.Error("broken script reported valid")
+	}
+	want := []Diagnostic{{File: "main.go", Line: 6, Column: 14, Message: `cannot use "s" (untyped string constant) as int value in variable declaration`}}
+	if !reflect.DeepEqual(res.CompileErrors, want) {
+		t.Errorf("compile errors = %+v", res.CompileErrors)
+	}
+	if want := []string{"github.com/foo/bar", "notstd/pkg"}; !reflect.DeepEqual(res.UnresolvedImports, want) {
+		t.Errorf("unresolved = %v, want %v", res.UnresolvedImports, want)
+	}
+	if len(rt.specs) != 1 {
+		t.Errorf("%d containers, want vet skipped after a failed build", len(rt.specs))
+	}
+}
+
+func TestValidateRunsVetWithoutExecuting(t *testing.T) {
+	rt := &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Cmd[1] == "vet" {
+			return ContainerState{ExitCode: 1}, "main.go:6:14: fmt.Printf format %d has arg \"s\" of wrong type string\n"
+		}
+		return ContainerState{}, ""
+	}}
+	ws := t.TempDir()
+	res, err := NewRunner(rt).Validate(context.Background(), ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Valid || len(res.CompileErrors) != 0 {
+		t.Errorf("result = %+v, want valid", res)
+	}
+	if len(res.VetWarnings) != 1 || !strings.Contains(res.VetWarnings[0].Message, "wrong type") {
+		t.Errorf("vet warnings = %+v", res.VetWarnings)
+	}
+
+	var cmds []string
+	for _, spec := range rt.specs {
+		cmds = append(cmds, strings.Join(spec.Cmd, " "))
+		if spec.Network != "none" || spec.Mounts[0].Source != ws || !spec.Mounts[0].ReadOnly {
+			t.Errorf("spec = %+v, want an offline read-only workspace", spec)
+		}
+		if spec.Env["EVIDENCE_PATH"] == "" {
+			t.Error("no dummy evidence")
+		}
+	}
+	if want := []string{"go build -o /dev/null .", "go vet ./..."}; !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %q, want %q", cmds, want)
+	}
+}
@@ -0,0 +1,318 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ErrVulnerableDependency is wrapped by the VulnerabilityError of a Go job
+// that Runner.VulnCheck blocks.
+var ErrVulnerableDependency = errors.New("orchestrator: vulnerable dependency")
+
+// How far the script reaches a vulnerability, as govulncheck tells.
+const (
+	// ReachCalled: the script calls a vulnerable function.
+	ReachCalled = "called"
+	// ReachImported: the script imports a vulnerable package but does not
+	// call its vulnerable functions.
+	ReachImported = "imported"
+	// ReachRequired: a vulnerable module is only required.
+	ReachRequired = "required"
+)
+
+// VulnCheckConfig scans the dependencies of Go jobs for known
+// vulnerabilities with govulncheck before they are built, in a container
+// of the runner image, and blocks or reports them.
+type VulnCheckConfig struct {
+	// Database is the URL of the vulnerability database, govulncheck's
+	// default (https://vuln.go.dev) when empty; DatabaseDir, when set, is
+	// a host directory holding a copy of it, mounted read-only and used
+	// instead, for air-gapped runners.
+	Database    string
+	DatabaseDir string
+	// Network is the docker network of the scanning container, to reach
+	// Database; the modules of a job that is not Offline are resolved
+	// through Runner.Vendor as for vendoring. "none" when empty.
+	Network string
+	// Command is the govulncheck command of the runner image,
+	// "govulncheck" when empty.
+	Command []string
+	// BlockAt is the lowest severity that blocks a job, which then fails
+	// with FailureVulnerableDependency before it runs; vulnerabilities
+	// are only reported when it is empty.
+	BlockAt sandbox.Severity
+	// Severities sets the severity of vulnerabilities by ID or alias, e.g.
+	// "GO-2024-2687" or "CVE-2023-45288". Others get that of their
+	// database entry, or else one by how the script reaches them:
+	// SeverityHigh when called, SeverityMedium when imported and
+	// SeverityLow when only required.
+	Severities map[string]sandbox.Severity
+	// Ignore lists the IDs or aliases of accepted vulnerabilities, left
+	// out of the results.
+	Ignore []string
+}
+
+// Vulnerability is a known vulnerability of a dependency of a Go job.
+type Vulnerability struct {
+	// ID is the ID of the database entry, e.g. "GO-2024-2687", and Aliases
+	// its other IDs, e.g. CVE and GHSA ones.
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+	Summary string   `json:"summary,omitempty"`
+	// Module is the vulnerable module, "stdlib" for the standard library
+	// of the runner image, at Version. FixedVersion is the first version
+	// without the vulnerability, empty when there is none.
+	Module       string `json:"module"`
+	Version      string `json:"version,omitempty"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+	// Reach is ReachCalled, ReachImported or ReachRequired, and Symbol the
+	// vulnerable function called, e.g. "net/http.Server.Serve".
+	Reach    string           `json:"reach"`
+	Symbol   string           `json:"symbol,omitempty"`
+	Severity sandbox.Severity `json:"severity"`
+	// Blocking reports that the vulnerability reaches VulnCheckConfig.BlockAt.
+	Blocking bool `json:"blocking,omitempty"`
+}
+
+func (v Vulnerability) String() string {
+	s := fmt.Sprintf("%s (%s) in %s", v.ID, v.Severity, strings.TrimSpace(v.Module+" "+v.Version))
+	if v.Symbol != "" {
+		s += ", called: " + v.Symbol
+	}
+	return s
+}
+
+// VulnerabilityError is returned for a Go job whose dependencies have
+// vulnerabilities at or above VulnCheckConfig.BlockAt; Runner.Run reports
+// it as a FailureVulnerableDependency result.
+type VulnerabilityError struct {
+	Vulnerabilities []Vulnerability
+}
+
+func (e *VulnerabilityError) Error() string {
+	var blocking []string
+	for _, v := range e.Vulnerabilities {
+		if v.Blocking {
+			blocking = append(blocking, v.String())
+		}
+	}
+	return fmt.Sprintf("%v: %s", ErrVulnerableDependency, strings.Join(blocking, "; "))
+}
+
+func (e *VulnerabilityError) Unwrap() error { return ErrVulnerableDependency }
+
+// scanVulnerabilities runs govulncheck on the Go job in a container
+// derived from spec, and returns the vulnerabilities found, with a
+// VulnerabilityError when some block it. Other jobs are not scanned.
+func (r *Runner) scanVulnerabilities(ctx context.Context, job Job, cfg ExecConfig, spec ContainerSpec) ([]Vulnerability, error) {
+	vc := r.VulnCheck
+	if vc == nil || languageKey(job.Language) != LanguageGo {
+		return nil, nil
+	}
+	// The copy of the environment is the scan's own.
+	spec = r.moduleFetchSpec(spec)
+	if cfg.Offline {
+		for k, v := range offlineEnv {
+			spec.Env[k] = v
+		}
+		spec.Network = string(NetworkNone)
+	}
+	spec.Mounts = append([]Mount(nil), spec.Mounts...)
+	spec.Mounts[0].ReadOnly = true
+	spec.Env["GOTMPDIR"] = containerTmp
+	if vc.Network != "" {
+		spec.Network = vc.Network
+		if vc.Network != "none" && spec.SeccompProfile == defaultSeccompProfile(false) {
+			// The built-in profile of a job without network blocks
+			// sockets.
+			spec.SeccompProfile = defaultSeccompProfile(true)
+		}
+	}
+	cmd := vc.Command
+	if len(cmd) == 0 {
+		cmd = []string{"govulncheck"}
+	}
+	cmd = append(slices.Clone(cmd), "-json")
+	switch {
+	case vc.DatabaseDir != "":
+		spec.Mounts = append(spec.Mounts, Mount{Source: vc.DatabaseDir, Target: containerVulnDB, ReadOnly: true})
+		cmd = append(cmd, "-db", "file://"+containerVulnDB)
+	case vc.Database != "":
+		cmd = append(cmd, "-db", vc.Database)
+	}
+	spec.Cmd = append(cmd, "./...")
+	code, out, err := r.runToCompletion(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("vulnerability scan: %w", err)
+	}
+	// govulncheck -json exits 0 whatever it finds.
+	if code != 0 {
+		return nil, fmt.Errorf("orchestrator: vulnerability scan failed with exit code %d: %s", code, strings.TrimSpace(lastLines(out, 5)))
+	}
+	vulns, err := parseGovulncheck(strings.NewReader(out), vc)
+	if err != nil {
+		return nil, fmt.Errorf("orchestrator: vulnerability scan: %w", err)
+	}
+	for _, v := range vulns {
+		if v.Blocking {
+			return vulns, &VulnerabilityError{Vulnerabilities: vulns}
+		}
+	}
+	return vulns, nil
+}
+
+// lastLines returns the last n lines of out.
+func lastLines(out string, n int) string {
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n")
+}
+
+// govulncheckMessage is a message of the output of govulncheck -json; only
+// osv entries and findings are read.
+type govulncheckMessage struct {
+	OSV *struct {
+		ID               string   `json:"id"`
+		Aliases          []string `json:"aliases"`
+		Summary          string   `json:"summary"`
+		DatabaseSpecific struct {
+			Severity string `json:"severity"`
+		} `json:"database_specific"`
+	} `json:"osv"`
+	Finding *struct {
+		OSV          string `json:"osv"`
+		FixedVersion string `json:"fixed_version"`
+		Trace        []struct {
+			Module   string `json:"module"`
+			Version  string `json:"version"`
+			Package  string `json:"package"`
+			Function string `json:"function"`
+			Receiver string `json:"receiver"`
+		} `json:"trace"`
+	} `json:"finding"`
+}
+
+// reachRanks orders the reach of a vulnerability.
+var reachRanks = map[string]int{ReachRequired: 1, ReachImported: 2, ReachCalled: 3}
+
+// parseGovulncheck reads the vulnerabilities of the output of govulncheck
+// -json, one per database entry at its deepest reach, rated by vc.
+func parseGovulncheck(out io.Reader, vc *VulnCheckConfig) ([]Vulnerability, error) {
+	type entry struct {
+		aliases  []string
+		summary  string
+		severity string
+	}
+	entries := map[string]entry{}
+	found := map[string]*Vulnerability{}
+	var order []string
+	dec := json.NewDecoder(out)
+	for {
+		var m govulncheckMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if m.OSV != nil {
+			entries[m.OSV.ID] = entry{m.OSV.Aliases, m.OSV.Summary, m.OSV.DatabaseSpecific.Severity}
+		}
+		f := m.Finding
+		if f == nil || len(f.Trace) == 0 {
+			continue
+		}
+		// The first frame is the vulnerable symbol, package or module.
+		frame := f.Trace[0]
+		v := Vulnerability{ID: f.OSV, Module: frame.Module, Version: frame.Version, FixedVersion: f.FixedVersion, Reach: ReachRequired}
+		switch {
+		case frame.Function != "":
+			v.Reach, v.Symbol = ReachCalled, frame.Package+"."+frame.Function
+			if frame.Receiver != "" {
+				v.Symbol = frame.Package + "." + strings.TrimPrefix(frame.Receiver, "*") + "." + frame.Function
+			}
+		case frame.Package != "":
+			v.Reach = ReachImported
+		}
+		if prev, ok := found[v.ID]; !ok {
+			order = append(order, v.ID)
+			found[v.ID] = &v
+		} else if reachRanks[v.Reach] > reachRanks[prev.Reach] {
+			*prev = v
+		}
+	}
+	var vulns []Vulnerability
+	for _, id := range order {
+		v := *found[id]
+		e := entries[id]
+		v.Aliases, v.Summary = e.aliases, e.summary
+		if vc.ignored(v) {
+			continue
+		}
+		v.Severity = vc.severity(v, e.severity)
+		v.Blocking = vc.BlockAt != "" && v.Severity.Rank() >= vc.BlockAt.Rank()
+		vulns = append(vulns, v)
+	}
+	return vulns, nil
+}
+
+// ids returns the ID and aliases of v.
+func (v Vulnerability) ids() []string {
+	return append([]string{v.ID}, v.Aliases...)
+}
+
+func (vc *VulnCheckConfig) ignored(v Vulnerability) bool {
+	for _, id := range v.ids() {
+		if slices.Contains(vc.Ignore, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// severity rates v, whose database entry has the severity rated, if any,
+// e.g. "HIGH" or GitHub's "MODERATE".
+func (vc *VulnCheckConfig) severity(v Vulnerability, rated string) sandbox.Severity {
+	for _, id := range v.ids() {
+		if s, ok := vc.Severities[id]; ok {
+			return s
+		}
+	}
+	if strings.EqualFold(rated, "moderate") {
+		return sandbox.SeverityMedium
+	}
+	if s, err := sandbox.ParseSeverity(rated); err == nil {
+		return s
+	}
+	switch v.Reach {
+	case ReachCalled:
+		return sandbox.SeverityHigh
+	case ReachImported:
+		return sandbox.SeverityMedium
+	}
+	return sandbox.SeverityLow
+}
+
+// vulnerabilityFailure records the result of a job that Runner.VulnCheck
+// blocked, and reports whether err blocked it.
+func (r *Runner) vulnerabilityFailure(job Job, err error) (*JobResult, bool) {
+	var vErr *VulnerabilityError
+	if !errors.As(err, &vErr) {
+		return nil, false
+	}
+	res, rerr := r.result(job, r.execConfig(job), ContainerState{}, false, 0, "", "")
+	if rerr != nil {
+		return nil, false
+	}
+	res.Success = false
+	res.FailureReason, res.FailureDetail = FailureVulnerableDependency, strings.TrimPrefix(vErr.Error(), "orchestrator: ")
+	res.Vulnerabilities = vErr.Vulnerabilities
+	res.Attempts = 1
+	r.record(job, res)
+	return res, true
+}
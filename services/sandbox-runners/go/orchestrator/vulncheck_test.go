@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// govulncheckOutput reports GO-2023-2102 at each level up to a call, and a
+// standard library vulnerability that is only imported.
+const govulncheckOutput = `{"config":{"protocol_version":"v1.0.0","scanner_name":"govulncheck"}}
+{"progress":{"message":"Scanning your code and 42 packages across 3 dependent modules for known vulnerabilities..."}}
+{"osv":{"id":"GO-2023-2102","aliases":["CVE-2023-39325","GHSA-4374-p667-p6c8"],"summary":"HTTP/2 rapid reset can cause excessive work in net/http"}}
+{"finding":{"osv":"GO-2023-2102","fixed_version":"v0.17.0","trace":[{"module":"golang.org/x/net","version":"v0.10.0"}]}}
+{"finding":{"osv":"GO-2023-2102","fixed_version":"v0.17.0","trace":[{"module":"golang.org/x/net","version":"v0.10.0","package":"golang.org/x/net/http2"}]}}
+{"finding":{"osv":"GO-2023-2102","fixed_version":"v0.17.0","trace":[{"module":"golang.org/x/net","version":"v0.10.0","package":"golang.org/x/net/http2","function":"ServeConn","receiver":"*Server"},{"module":"example.com/parser","package":"main","function":"main"}]}}
+{"osv":{"id":"GO-2024-2600","aliases":["CVE-2023-45290"],"summary":"Memory exhaustion in multipart form parsing","database_specific":{"severity":"MODERATE"}}}
+{"finding":{"osv":"GO-2024-2600","fixed_version":"v1.21.8","trace":[{"module":"stdlib","version":"v1.21.0","package":"net/http"}]}}
+`
+
+// vulnRuntime plays back govulncheckOutput for the scanning container.
+func vulnRuntime() *fakeRuntime {
+	return &fakeRuntime{outcome: func(spec ContainerSpec) (ContainerState, string) {
+		if spec.Cmd[0] == "govulncheck" {
+			return ContainerState{}, govulncheckOutput
+		}
+		return ContainerState{}, ""
+	}}
+}
+
+func TestVulnCheckReports(t *testing.T) {
+	rt := vulnRuntime()
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	r.VulnCheck = &VulnCheckConfig{Database: "https://vulndb.lab.internal", Network: "vulndb"}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || len(res.Vulnerabilities) != 2 {
+		t.Fatalf("Run() = success %v, vulnerabilities %+v; want a successful job reporting two", res.Success, res.Vulnerabilities)
+	}
+	x, std := res.Vulnerabilities[0], res.Vulnerabilities[1]
+	if x.ID != "GO-2023-2102" || x.Reach != ReachCalled || x.Symbol != "golang.org/x/net/http2.Server.ServeConn" || x.Severity != sandbox.SeverityHigh || x.FixedVersion != "v0.17.0" || x.Blocking {
+		t.Errorf("x/net vulnerability = %+v", x)
+	}
+	if std.Module != "stdlib" || std.Reach != ReachImported || std.Severity != sandbox.SeverityMedium {
+		t.Errorf("stdlib vulnerability = %+v", std)
+	}
+	scan, job := rt.specs[0], rt.specs[1]
+	if !slices.Equal(scan.Cmd, []string{"govulncheck", "-json", "-db", "https://vulndb.lab.internal", "./..."}) || scan.Network != "vulndb" || !scan.Mounts[0].ReadOnly {
+		t.Errorf("scan container = %v on %q", scan.Cmd, scan.Network)
+	}
+	if job.Env["GOTMPDIR"] != containerWorkspace || job.Env["GOFLAGS"] == "-mod=mod" {
+		t.Errorf("the scan changed the job's environment: %v", job.Env)
+	}
+	audit, err := os.ReadFile(filepath.Join(r.Audit.Dir, "case-1"+auditFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(audit), `"vulnerabilities":[{"id":"GO-2023-2102"`) {
+		t.Errorf("audit entry = %s", audit)
+	}
+}
+
+func TestVulnCheckBlocks(t *testing.T) {
+	rt := vulnRuntime()
+	r := NewRunner(rt)
+	r.Audit = &AuditLog{Dir: t.TempDir()}
+	db := t.TempDir()
+	r.VulnCheck = &VulnCheckConfig{DatabaseDir: db, BlockAt: sandbox.SeverityHigh}
+
+	res, err := r.Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || res.FailureReason != FailureVulnerableDependency || !strings.Contains(res.FailureDetail, "GO-2023-2102 (high)") || len(res.Vulnerabilities) != 2 {
+		t.Errorf("Run() = %+v, want a vulnerable_dependency failure", res)
+	}
+	if len(rt.specs) != 1 {
+		t.Errorf("%d containers created, want the scan only", len(rt.specs))
+	}
+	scan := rt.specs[0]
+	if !slices.Contains(scan.Cmd, "file://"+containerVulnDB) || scan.Network != "" {
+		t.Errorf("air-gapped scan = %v on %q", scan.Cmd, scan.Network)
+	}
+	mounted := false
+	for _, m := range scan.Mounts {
+		mounted = mounted || (m.Source == db && m.Target == containerVulnDB && m.ReadOnly)
+	}
+	if !mounted {
+		t.Errorf("database not mounted read-only: %+v", scan.Mounts)
+	}
+	if audit, _ := os.ReadFile(filepath.Join(r.Audit.Dir, "case-1"+auditFileSuffix)); !strings.Contains(string(audit), `"failure_reason":"vulnerable_dependency"`) {
+		t.Errorf("audit entry = %s", audit)
+	}
+
+	// An accepted vulnerability no longer blocks.
+	r.VulnCheck.Ignore = []string{"CVE-2023-39325"}
+	res, err = r.Run(context.Background(), testJob(t))
+	if err != nil || !res.Success || len(res.Vulnerabilities) != 1 {
+		t.Errorf("Run() with the vulnerability ignored = %+v, %v", res, err)
+	}
+	r.VulnCheck.Ignore = nil
+	if _, err := r.Start(context.Background(), testJob(t)); !errors.Is(err, ErrVulnerableDependency) {
+		t.Errorf("Start() = %v, want ErrVulnerableDependency", err)
+	}
+}
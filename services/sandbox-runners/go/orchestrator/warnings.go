@@ -0,0 +1,39 @@
+package orchestrator
+
+import (
+	"sort"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// collectWarnings reads the warnings the script wrote with sandbox.Warn.
+// Invalid lines are dropped and reported as err.
+func collectWarnings(dir string) ([]sandbox.Warning, error) {
+	return sandbox.ReadWarnings(dir)
+}
+
+// WarningCount is the number of warnings of one code.
+type WarningCount struct {
+	Code  string
+	Count int
+	// Message is that of the first warning of the code, as an example.
+	Message string
+}
+
+// WarningCounts aggregates r.Warnings by code, the most frequent first,
+// for a summary such as "mft.truncated_record ×1204".
+func (r *JobResult) WarningCounts() []WarningCount {
+	index := map[string]int{}
+	var counts []WarningCount
+	for _, w := range r.Warnings {
+		i, ok := index[w.Code]
+		if !ok {
+			i = len(counts)
+			index[w.Code] = i
+			counts = append(counts, WarningCount{Code: w.Code, Message: w.Message})
+		}
+		counts[i].Count++
+	}
+	sort.SliceStable(counts, func(i, j int) bool { return counts[i].Count > counts[j].Count })
+	return counts
+}
@@ -0,0 +1,48 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunCollectsWarnings(t *testing.T) {
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		for _, m := range spec.Mounts {
+			if m.Target == containerOutputDir {
+				os.WriteFile(filepath.Join(m.Source, sandbox.WarningsFile), []byte(
+					`{"evidence_uid":"ev-1","code":"mft.truncated_record","message":"record 12 truncated","fields":{"record":12}}`+"\n"+
+						`{"evidence_uid":"ev-1","code":"mft.unknown_version","message":"version 3.2"}`+"\n"+
+						`{"evidence_uid":"ev-1","code":"mft.truncated_record","message":"record 40 truncated"}`+"\n"+
+						`{"evidence_uid":"ev-1","code":"Bad Code","message":"x"}`+"\n"), 0o644)
+			}
+		}
+	}}
+	res, err := NewRunner(rt).Run(context.Background(), testJob(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || len(res.Findings) != 0 {
+		t.Errorf("success = %v, findings = %+v; warnings should affect neither", res.Success, res.Findings)
+	}
+	if len(res.Warnings) != 3 {
+		t.Errorf("warnings = %+v", res.Warnings)
+	}
+	want := []WarningCount{
+		{Code: "mft.truncated_record", Count: 2, Message: "record 12 truncated"},
+		{Code: "mft.unknown_version", Count: 1, Message: "version 3.2"},
+	}
+	if got := res.WarningCounts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+	if res.WarningsError == "" || len(res.InvalidRecords) != 1 || res.InvalidRecords[0].File != sandbox.WarningsFile || res.InvalidRecords[0].Line != 4 {
+		t.Errorf("invalid records = %+v, want the bad code quarantined", res.InvalidRecords)
+	}
+	if len(res.Artifacts) != 0 {
+		t.Errorf("artifacts = %+v, want warnings.ndjson left out", res.Artifacts)
+	}
+}
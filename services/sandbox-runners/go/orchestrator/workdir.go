@@ -0,0 +1,49 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+)
+
+// jobDirPrefix names the per-job working directories under Runner.WorkDir.
+const jobDirPrefix = "datamortem-job-"
+
+// stageWorkspace copies the job's workspace into a fresh directory under
+// Runner.WorkDir, which the container gets as /workspace. What the job
+// writes there, build temp files included, is thus never seen by another
+// job run from the same sources. The copy is what Runner.Signatures
+// verifies, and signer the key that signed it. The caller removes the
+// directory.
+func (r *Runner) stageWorkspace(job Job) (dir, signer string, err error) {
+	dir, err = r.scratchDir(jobDirPrefix)
+	if err != nil {
+		return "", "", fmt.Errorf("stage workspace: %w", err)
+	}
+	// The job runs as the sandbox user.
+	if err := os.Chmod(dir, 0o777); err != nil {
+		r.removeDir(dir)
+		return "", "", fmt.Errorf("stage workspace: %w", err)
+	}
+	if err := copyTree(job.Workspace, dir); err != nil {
+		r.removeDir(dir)
+		return "", "", fmt.Errorf("stage workspace: %w", err)
+	}
+	// The copy is verified, before the orchestrator's own changes to it,
+	// so that the source tree changing after verification cannot run.
+	signer, err = r.verifyScript(dir)
+	if err != nil {
+		r.removeDir(dir)
+		return "", "", err
+	}
+	if err := applyReplacements(dir, job.Replace); err != nil {
+		r.removeDir(dir)
+		return "", "", fmt.Errorf("stage workspace: %w", err)
+	}
+	return dir, signer, nil
+}
+
+// scratchDir creates a fresh directory named after prefix under
+// Runner.WorkDir, which the caller removes with removeDir.
+func (r *Runner) scratchDir(prefix string) (string, error) {
+	return r.tempDir(r.workRoot(), prefix)
+}
@@ -0,0 +1,87 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestRunIsolatesWorkspaces(t *testing.T) {
+	workDir := t.TempDir()
+	var sources []string
+	var seen [][]string
+	rt := &fakeRuntime{onStart: func(spec ContainerSpec) {
+		ws := spec.Mounts[0].Source
+		sources = append(sources, ws)
+		seen = append(seen, dirNames(t, ws))
+		os.WriteFile(filepath.Join(ws, "scratch-"+spec.Env[sandbox.EnvCaseID]), []byte("secret"), 0o644)
+	}}
+	r := NewRunner(rt)
+	r.WorkDir = workDir
+	workspace := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "main.go"), []byte("package main"), 0o644)
+
+	for _, caseID := range []string{"case-a", "case-b"} {
+		job := testJob(t)
+		job.CaseID, job.Workspace = caseID, workspace
+		if _, err := r.Run(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := [][]string{{"main.go"}, {"main.go"}}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("workspaces = %v, want job B not to see job A's files", seen)
+	}
+	if len(sources) != 2 || sources[0] == sources[1] || !strings.HasPrefix(sources[0], workDir) {
+		t.Errorf("workspace mounts = %v, want distinct directories under %s", sources, workDir)
+	}
+	if names := dirNames(t, workDir); len(names) != 0 {
+		t.Errorf("work directories left behind: %v", names)
+	}
+	if names := dirNames(t, workspace); !reflect.DeepEqual(names, []string{"main.go"}) {
+		t.Errorf("source workspace = %v, want it untouched", names)
+	}
+}
+
+func TestStartFailureRemovesWorkDir(t *testing.T) {
+	r := NewRunner(&fakeRuntime{})
+	r.WorkDir = t.TempDir()
+	job := testJob(t)
+	job.Language = "cobol"
+	if _, err := r.Start(context.Background(), job); err == nil {
+		t.Fatal("expected an unknown language error")
+	}
+	if names := dirNames(t, r.WorkDir); len(names) != 0 {
+		t.Errorf("work directories left behind: %v", names)
+	}
+}
+
+func TestPoolRetiresContainerAfterPanic(t *testing.T) {
+	rt := &fakeRuntime{}
+	rt.onExec = func(id string, spec ExecSpec) {
+		if spec.Env[sandbox.EnvCaseID] != "" {
+			panic("runtime failure")
+		}
+	}
+	p := newTestPool(t, rt, PoolConfig{Size: 1})
+	slots := dirNames(t, p.dir)
+
+	func() {
+		defer func() { recover() }()
+		p.Run(context.Background(), poolJob(t, "case-1"))
+		t.Error("Run did not panic")
+	}()
+	if m := p.Metrics(); m.Busy != 0 || m.Idle != 0 {
+		t.Errorf("metrics = %+v, want the container retired", m)
+	}
+	if len(rt.removed) != 1 {
+		t.Errorf("removed containers = %v", rt.removed)
+	}
+	if _, err := os.Stat(filepath.Join(p.dir, slots[0])); !os.IsNotExist(err) {
+		t.Errorf("slot directory %s not removed: %v", slots[0], err)
+	}
+}
@@ -0,0 +1,168 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ManifestFile is the name of the artifact manifest inside OUTPUT_DIR.
+const ManifestFile = "artifacts.json"
+
+// Common artifact kinds.
+const (
+	ArtifactReport        = "report"
+	ArtifactTimeline      = "timeline"
+	ArtifactExtractedFile = "extracted-file"
+	// ArtifactExtractedEvidence marks files written by ExtractFile, which
+	// the orchestrator ingests as child evidence.
+	ArtifactExtractedEvidence = "extracted-evidence"
+)
+
+// Artifact describes one file of OUTPUT_DIR in the manifest.
+type Artifact struct {
+	// Path is relative to OUTPUT_DIR, with forward slashes.
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Description string `json:"description,omitempty"`
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+	// Format is set for the reports of EmitReport.
+	Format ReportFormat `json:"format,omitempty"`
+	// The fields below are set for ArtifactExtractedEvidence.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	// Parent is the UID of the evidence the file was extracted from.
+	Parent string `json:"parent,omitempty"`
+	// Offset is the file's byte offset in the parent evidence, if known.
+	Offset     *int64 `json:"offset,omitempty"`
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// Manifest is the content of artifacts.json.
+type Manifest struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+var manifestMu sync.Mutex
+
+// RegisterArtifact records the file at path, absolute or relative to
+// OUTPUT_DIR, in the artifact manifest with its kind, SHA256 and size.
+// Registering a path again replaces its entry; registering a new one past
+// the cap of SANDBOX_MAX_ARTIFACTS returns ErrRecordLimit.
+func RegisterArtifact(path, kind, description string) error {
+	if kind == "" {
+		return errors.New("sandbox: artifact kind is required")
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	rel, err := outputRel(dir, path)
+	if err != nil {
+		return err
+	}
+	full := filepath.Join(dir, rel)
+	info, err := os.Stat(full)
+	if err != nil {
+		return fmt.Errorf("sandbox: artifact %s: %w", rel, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("sandbox: artifact %s is not a regular file", rel)
+	}
+	sum, err := hashFile(full, HashSHA256)
+	if err != nil {
+		return fmt.Errorf("sandbox: hash artifact %s: %w", rel, err)
+	}
+
+	return updateManifest(dir, Artifact{
+		Path:        filepath.ToSlash(rel),
+		Kind:        kind,
+		Description: description,
+		SHA256:      sum,
+		Size:        info.Size(),
+	})
+}
+
+// outputRel returns path relative to dir, rejecting paths outside it.
+func outputRel(dir, path string) (string, error) {
+	rel := path
+	if filepath.IsAbs(path) {
+		var err error
+		if rel, err = filepath.Rel(dir, path); err != nil {
+			return "", err
+		}
+	}
+	rel = filepath.Clean(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("sandbox: %s is outside OUTPUT_DIR", path)
+	}
+	return rel, nil
+}
+
+// ReadManifest parses the manifest in dir; a missing file is an empty
+// manifest.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("sandbox: parse %s: %w", ManifestFile, err)
+	}
+	return &m, nil
+}
+
+// updateManifest adds or replaces a in the manifest, rewriting it through
+// a rename so readers never see a partial file.
+func updateManifest(dir string, a Artifact) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range m.Artifacts {
+		if m.Artifacts[i].Path == a.Path {
+			m.Artifacts[i] = a
+			replaced = true
+		}
+	}
+	if !replaced {
+		if err := checkArtifactLimit(dir, m); err != nil {
+			return err
+		}
+		m.Artifacts = append(m.Artifacts, a)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".artifacts-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(OutputMode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, ManifestFile))
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegisterArtifact(t *testing.T) {
+	dir := setupEnv(t)
+	if err := os.WriteFile(filepath.Join(dir, "report.html"), []byte("<h1>ok</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "carved"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "carved", "a.exe"), []byte("MZ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterArtifact("report.html", ArtifactReport, "draft"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterArtifact(filepath.Join(dir, "carved", "a.exe"), ArtifactExtractedFile, "PE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterArtifact("report.html", ArtifactReport, "final"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Artifacts) != 2 {
+		t.Fatalf("artifacts = %+v", m.Artifacts)
+	}
+	report, carved := m.Artifacts[0], m.Artifacts[1]
+	if report.Path != "report.html" || report.Description != "final" || report.Size != 11 {
+		t.Errorf("report = %+v", report)
+	}
+	const mzSHA256 = "9b8db510ef42b8ed54a3712636fda55a4f8cfcd5493e20b74ab00cd4f3979f2d"
+	if carved.Path != "carved/a.exe" || carved.Kind != ArtifactExtractedFile || carved.SHA256 != mzSHA256 || carved.Size != 2 {
+		t.Errorf("carved = %+v", carved)
+	}
+}
+
+func TestRegisterArtifactRejectsEscapes(t *testing.T) {
+	setupEnv(t)
+	for _, p := range []string{"../etc/passwd", "/etc/passwd"} {
+		if err := RegisterArtifact(p, ArtifactReport, ""); err == nil {
+			t.Errorf("RegisterArtifact(%q) should fail", p)
+		}
+	}
+	if err := RegisterArtifact("missing.txt", ArtifactReport, ""); err == nil {
+		t.Error("missing artifact should fail")
+	}
+}
@@ -0,0 +1,60 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidTechnique(t *testing.T) {
+	for id, want := range map[string]string{
+		"T1055":     "Process Injection",
+		"T1055.012": "Process Injection",
+		"T1547.001": "Boot or Logon Autostart Execution",
+		"T1059":     "Command and Scripting Interpreter",
+	} {
+		if name, ok := TechniqueName(id); !ok || name != want || !ValidTechnique(id) {
+			t.Errorf("TechniqueName(%q) = %q, %v, want %q", id, name, ok, want)
+		}
+	}
+	for _, id := range []string{"", "T1", "t1055", "T1055.1", "T1055.0123", "T9999", "T9999.001", "TA0003", " T1055"} {
+		if ValidTechnique(id) {
+			t.Errorf("ValidTechnique(%q) = true", id)
+		}
+	}
+}
+
+func TestWithTechnique(t *testing.T) {
+	base := Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "Run key persistence"}
+	r := base.WithTechnique("T1547.001").WithTechnique("T1112").WithTechnique("T1547.001")
+	if want := []string{"T1547.001", "T1112"}; !reflect.DeepEqual(r.Techniques, want) {
+		t.Errorf("techniques %q, want %q", r.Techniques, want)
+	}
+	if base.Techniques != nil {
+		t.Errorf("base result changed to %q", base.Techniques)
+	}
+	if err := ValidateResult(r); err != nil {
+		t.Errorf("ValidateResult() = %v", err)
+	}
+	if err := ValidateResult(r.WithTechnique("T0000")); !errors.Is(err, ErrInvalidTechnique) {
+		t.Errorf("ValidateResult() of an unknown technique = %v, want ErrInvalidTechnique", err)
+	}
+}
+
+func TestEmitResultTechniques(t *testing.T) {
+	dir := setupEnv(t)
+	r := Result{EvidenceUID: "ev-1", Severity: SeverityMedium, Title: "Injected thread"}.WithTechnique("T1055")
+	if err := EmitResult(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitResult(r.WithTechnique("T1055.999.1")); !errors.Is(err, ErrInvalidTechnique) {
+		t.Errorf("EmitResult() = %v, want ErrInvalidTechnique", err)
+	}
+	results, err := ReadResults(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Techniques, []string{"T1055"}) {
+		t.Errorf("results %+v", results)
+	}
+}
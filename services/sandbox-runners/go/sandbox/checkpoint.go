@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckpointDir is the directory of the checkpoint inside OUTPUT_DIR. The
+// orchestrator does not collect it as an output: it keeps it for the next
+// run of the same script on the same evidence when the job does not
+// complete, and discards it when the job succeeds.
+const CheckpointDir = ".checkpoint"
+
+// checkpointFile holds the state inside CheckpointDir.
+const checkpointFile = "state"
+
+// Checkpoint saves state, opaque to the SDK, as the progress of the
+// script, replacing the previous checkpoint. The file is replaced
+// atomically, so that a crash or timeout during Checkpoint leaves the
+// previous state. Serializing the parser's state, and resuming from it
+// consistently with the outputs written before, is the script's
+// responsibility.
+func Checkpoint(state []byte) error {
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	dir = filepath.Join(dir, CheckpointDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("sandbox: checkpoint: %w", err)
+	}
+	f, err := os.CreateTemp(dir, checkpointFile+".*")
+	if err != nil {
+		return fmt.Errorf("sandbox: checkpoint: %w", err)
+	}
+	_, err = f.Write(state)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, checkpointFile))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("sandbox: checkpoint: %w", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint returns the state of the last Checkpoint, of this run or
+// of an earlier run that did not complete, and false when there is none:
+// the script then starts from the beginning.
+func LoadCheckpoint() ([]byte, bool, error) {
+	dir, err := outputDir()
+	if err != nil {
+		return nil, false, err
+	}
+	state, err := os.ReadFile(filepath.Join(dir, CheckpointDir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("sandbox: load checkpoint: %w", err)
+	}
+	return state, true, nil
+}
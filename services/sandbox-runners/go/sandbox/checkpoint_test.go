@@ -0,0 +1,33 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := setupEnv(t)
+	if state, ok, err := LoadCheckpoint(); state != nil || ok || err != nil {
+		t.Fatalf("LoadCheckpoint = %q, %v, %v before any checkpoint", state, ok, err)
+	}
+	for _, state := range []string{"offset=4096", "offset=8192"} {
+		if err := Checkpoint([]byte(state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, ok, err := LoadCheckpoint()
+	if string(state) != "offset=8192" || !ok || err != nil {
+		t.Errorf("LoadCheckpoint = %q, %v, %v, want the last state", state, ok, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, CheckpointDir))
+	if len(entries) != 1 {
+		t.Errorf("%d files in %s, want the state alone", len(entries), CheckpointDir)
+	}
+
+	t.Setenv(EnvOutputDir, "")
+	if err := Checkpoint(nil); !errors.Is(err, ErrNoOutputDir) {
+		t.Errorf("err = %v, want ErrNoOutputDir", err)
+	}
+}
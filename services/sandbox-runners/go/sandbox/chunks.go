@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// DefaultChunkSize is the chunk size of ParallelChunks when given zero.
+const DefaultChunkSize = 64 << 20
+
+// EvidenceChunk is a range of the evidence handed to a ParallelChunks
+// worker. The chunk owns the records that start in [Offset,
+// Offset+Length): it reads past its end to finish the record that
+// straddles it, and before its start to tell whether its first bytes
+// continue a record of the previous chunk, which that chunk reports.
+type EvidenceChunk struct {
+	// Index is the position of the chunk, from 0.
+	Index int
+	// Offset and Length are the range of the chunk, in the offsets of
+	// EvidenceFile.ReadAt.
+	Offset, Length int64
+
+	ev   io.ReaderAt
+	size int64
+}
+
+// Owns reports whether a record starting at off belongs to the chunk.
+func (c EvidenceChunk) Owns(off int64) bool {
+	return off >= c.Offset && off < c.Offset+c.Length
+}
+
+// Reader returns a reader of the chunk extended by up to before bytes
+// before its start and after bytes after its end, within the evidence,
+// and the offset of its first byte. The overlap bytes are read again by
+// the neighbouring chunks: only the records the chunk Owns should be
+// reported.
+func (c EvidenceChunk) Reader(before, after int64) (r *io.SectionReader, start int64) {
+	start = max(c.Offset-max(before, 0), 0)
+	end := min(c.Offset+c.Length+max(after, 0), c.size)
+	return io.NewSectionReader(c.ev, start, end-start), start
+}
+
+// ChunkError is the failure of a chunk of ParallelChunks.
+type ChunkError struct {
+	Index          int
+	Offset, Length int64
+	Err            error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("sandbox: chunk %d (%d+%d): %v", e.Index, e.Offset, e.Length, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error { return e.Err }
+
+// ParallelChunks opens the evidence like OpenEvidence and runs fn on each
+// of its chunks of chunkSize bytes, DefaultChunkSize when zero, on at most
+// workers goroutines. The workers are bounded by the CPUs granted to the
+// container, as Limits tells, or those of the host without a limit; zero
+// workers uses them all. fn is called concurrently and may emit records.
+//
+// Every chunk is run even if some fail: the error joins a *ChunkError per
+// failed chunk, in chunk order. Compressed evidence cannot be read at
+// random and is refused.
+func ParallelChunks(chunkSize int64, workers int, fn func(chunk EvidenceChunk) error) error {
+	ef, err := OpenEvidence(WithReadAhead(0))
+	if err != nil {
+		return err
+	}
+	defer ef.Close()
+	return ef.ParallelChunks(chunkSize, workers, fn)
+}
+
+// ParallelChunks is the function ParallelChunks on ef. ef should be opened
+// WithReadAhead(0): its read-ahead buffer serialises small reads.
+func (ef *EvidenceFile) ParallelChunks(chunkSize int64, workers int, fn func(chunk EvidenceChunk) error) error {
+	if ef.compression != "" {
+		return fmt.Errorf("sandbox: parallel chunks of %s-compressed evidence: decompress it first", ef.compression)
+	}
+	if chunkSize < 0 || workers < 0 {
+		return fmt.Errorf("sandbox: invalid chunk size %d or worker count %d", chunkSize, workers)
+	}
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	limits, err := Limits()
+	if err != nil {
+		return err
+	}
+	workers = chunkWorkers(workers, limits)
+	size := ef.Size()
+	n := int((size + chunkSize - 1) / chunkSize)
+	chunks := make(chan EvidenceChunk)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				if err := fn(c); err != nil {
+					errs[c.Index] = &ChunkError{Index: c.Index, Offset: c.Offset, Length: c.Length, Err: err}
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		off := int64(i) * chunkSize
+		chunks <- EvidenceChunk{Index: i, Offset: off, Length: min(chunkSize, size-off), ev: ef, size: size}
+	}
+	close(chunks)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// chunkWorkers returns the number of workers of ParallelChunks: workers,
+// or all the CPUs when zero, at most the CPUs granted, rounded up.
+func chunkWorkers(workers int, limits ResourceLimits) int {
+	cpus := runtime.NumCPU()
+	if limits.CPUs > 0 {
+		cpus = int(math.Ceil(limits.CPUs))
+	}
+	if workers == 0 || workers > cpus {
+		workers = cpus
+	}
+	return max(workers, 1)
+}
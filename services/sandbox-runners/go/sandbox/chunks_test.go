@@ -0,0 +1,164 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// setEvidence writes data as the evidence of the test.
+func setEvidence(tb testing.TB, data []byte) {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Setenv(EnvEvidencePath, path)
+}
+
+func TestParallelChunks(t *testing.T) {
+	data := bytes.Repeat([]byte{0}, 1000)
+	// Markers inside a chunk, straddling the boundaries at 100 and 500 and
+	// starting right on the one at 300.
+	marks := []int{10, 98, 300, 498, 996}
+	for _, off := range marks {
+		copy(data[off:], "MARK")
+	}
+	setEvidence(t, data)
+	t.Setenv(EnvCPUCount, "4")
+
+	var mu sync.Mutex
+	var found []int64
+	var chunks atomic.Int32
+	err := ParallelChunks(100, 0, func(c EvidenceChunk) error {
+		chunks.Add(1)
+		r, start := c.Reader(0, 3)
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i+4 <= len(buf); i++ {
+			if off := start + int64(i); string(buf[i:i+4]) == "MARK" && c.Owns(off) {
+				mu.Lock()
+				found = append(found, off)
+				mu.Unlock()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks.Load() != 10 || len(found) != len(marks) {
+		t.Errorf("%d chunks, found markers at %v, want %v", chunks.Load(), found, marks)
+	}
+}
+
+func TestParallelChunksReader(t *testing.T) {
+	c := EvidenceChunk{Index: 1, Offset: 100, Length: 100, ev: bytes.NewReader(make([]byte, 250)), size: 250}
+	for _, tc := range []struct {
+		before, after, start, n int64
+	}{
+		{0, 0, 100, 100},
+		{10, 20, 90, 130},
+		{200, 200, 0, 250},
+	} {
+		r, start := c.Reader(tc.before, tc.after)
+		if start != tc.start || r.Size() != tc.n {
+			t.Errorf("Reader(%d, %d) = %d bytes at %d, want %d at %d", tc.before, tc.after, r.Size(), start, tc.n, tc.start)
+		}
+	}
+	if c.Owns(99) || !c.Owns(100) || !c.Owns(199) || c.Owns(200) {
+		t.Error("Owns does not match [100, 200)")
+	}
+}
+
+func TestParallelChunksErrors(t *testing.T) {
+	setEvidence(t, make([]byte, 50))
+	boom := errors.New("boom")
+	err := ParallelChunks(10, 2, func(c EvidenceChunk) error {
+		if c.Index == 1 || c.Index == 3 {
+			return boom
+		}
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want boom", err)
+	}
+	var ce *ChunkError
+	if !errors.As(err, &ce) || ce.Index != 1 || ce.Offset != 10 || ce.Length != 10 {
+		t.Errorf("first chunk error = %+v", ce)
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 2 {
+		t.Errorf("%d errors, want 2: %v", n, err)
+	}
+	if err := ParallelChunks(-1, 0, func(EvidenceChunk) error { return nil }); err == nil {
+		t.Error("negative chunk size accepted")
+	}
+}
+
+func TestChunkWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		workers int
+		cpus    float64
+		want    int
+	}{
+		{0, 4, 4},
+		{2, 4, 2},
+		{8, 4, 4},
+		{0, 1.5, 2},
+		{3, 0.5, 1},
+	} {
+		if got := chunkWorkers(tc.workers, ResourceLimits{CPUs: tc.cpus}); got != tc.want {
+			t.Errorf("chunkWorkers(%d, %g CPUs) = %d, want %d", tc.workers, tc.cpus, got, tc.want)
+		}
+	}
+}
+
+// BenchmarkParallelChunks computes the byte entropy of each 1 MiB chunk of
+// a 64 MiB evidence with 1 to 8 workers: the throughput grows about
+// linearly with the workers up to the CPUs of the host.
+func BenchmarkParallelChunks(b *testing.B) {
+	data := make([]byte, 64<<20)
+	for i := range data {
+		data[i] = byte(i * 2654435761 >> 13)
+	}
+	setEvidence(b, data)
+	b.Setenv(EnvCPUCount, "")
+	entropy := func(c EvidenceChunk) error {
+		r, _ := c.Reader(0, 0)
+		buf := make([]byte, c.Length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		var counts [256]int
+		for _, v := range buf {
+			counts[v]++
+		}
+		var h float64
+		for _, n := range counts {
+			if n > 0 {
+				p := float64(n) / float64(len(buf))
+				h -= p * math.Log2(p)
+			}
+		}
+		return nil
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if err := ParallelChunks(1<<20, workers, entropy); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
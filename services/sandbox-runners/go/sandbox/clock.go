@@ -0,0 +1,21 @@
+package sandbox
+
+import (
+	"os"
+	"time"
+)
+
+// Now returns the logical start time of the job, SANDBOX_RUN_TIME, in UTC.
+// Every job of a fan-out or pipeline gets the time the run started, and a
+// replay the time of the run it replays, so that records stamped with Now
+// agree across the run and from one replay to the next. Outside the
+// sandbox, or when the variable is malformed, it returns the wall clock.
+//
+// Now is the time of the analysis, not of the events found in the
+// evidence; scripts that need to measure time use time.Now.
+func Now() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, os.Getenv(EnvRunTime)); err == nil {
+		return t.UTC()
+	}
+	return time.Now().UTC()
+}
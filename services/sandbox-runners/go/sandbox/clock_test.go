@@ -0,0 +1,22 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNow(t *testing.T) {
+	t.Setenv(EnvRunTime, "2026-03-01T10:00:00.5+01:00")
+	want := time.Date(2026, 3, 1, 9, 0, 0, 5e8, time.UTC)
+	if got := Now(); !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+
+	for _, v := range []string{"", "yesterday"} {
+		t.Setenv(EnvRunTime, v)
+		before := time.Now()
+		if got := Now(); got.Before(before.Add(-time.Second)) || got.After(time.Now()) {
+			t.Errorf("Now() with %s=%q = %v, want the wall clock", EnvRunTime, v, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package sandbox
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported values for EVIDENCE_COMPRESSION.
+const (
+	CompressionRaw  = "raw"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ValidCompression reports whether c is a supported EVIDENCE_COMPRESSION;
+// empty means raw.
+func ValidCompression(c string) bool {
+	switch strings.ToLower(c) {
+	case "", CompressionRaw, CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// Decompress returns the decompressed stream of r, stored with compression
+// c. It is how OpenEvidence reads compressed evidence; use it for the
+// extra evidence items, whose compression is in EVIDENCE_COMPRESSION_<n>.
+// Decompression is streaming: memory use does not grow with the input.
+func Decompress(r io.Reader, c string) (io.ReadCloser, error) {
+	switch strings.ToLower(c) {
+	case "", CompressionRaw:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("sandbox: unsupported evidence compression %q", c)
+	}
+}
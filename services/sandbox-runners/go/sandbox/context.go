@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ContextVersion is the version of the case context format this SDK reads.
+// It is raised only by incompatible changes: new fields do not change it.
+const ContextVersion = 1
+
+// ContextFile is the name of the case context file mounted read-only by
+// the runners; EnvContextPath gives its full path.
+const ContextFile = "context.json"
+
+// ErrNoContext is returned by Context when the runner mounted no case
+// context.
+var ErrNoContext = errors.New("sandbox: no case context (" + EnvContextPath + " unset)")
+
+// CaseContext describes the case and the job a script runs for, beyond
+// what the environment variables carry.
+type CaseContext struct {
+	Version  int    `json:"version"`
+	CaseID   string `json:"case_id"`
+	CaseName string `json:"case_name,omitempty"`
+	// CaseNumber is the reference of the case in the lab's case register.
+	CaseNumber string `json:"case_number,omitempty"`
+	// Timezone and Locale are CASE_TIMEZONE and CASE_LOCALE.
+	Timezone string `json:"timezone,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	// Examiner identifies the user who requested the job.
+	Examiner string            `json:"examiner,omitempty"`
+	JobID    string            `json:"job_id,omitempty"`
+	Evidence []ContextEvidence `json:"evidence"`
+	// Params are the job parameters, as read by GetParam.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// ContextEvidence is an evidence item of the job.
+type ContextEvidence struct {
+	UID string `json:"uid"`
+	// Path is the evidence location inside the sandbox, empty for an item
+	// that is not mounted.
+	Path string `json:"path,omitempty"`
+	// Type is the kind of evidence recorded at ingestion, e.g.
+	// "disk_image" or "memory_dump".
+	Type     string `json:"type,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	HashAlgo string `json:"hash_algo,omitempty"`
+	// Compression, Offset and Length are as described by
+	// EVIDENCE_COMPRESSION, EVIDENCE_OFFSET and EVIDENCE_LENGTH.
+	Compression string `json:"compression,omitempty"`
+	Offset      int64  `json:"offset,omitempty"`
+	Length      int64  `json:"length,omitempty"`
+}
+
+// Context reads the case context file named by SANDBOX_CONTEXT_PATH. It
+// returns ErrNoContext when the variable is unset, and an error for a
+// file of a later, incompatible version than ContextVersion.
+func Context() (*CaseContext, error) {
+	path := os.Getenv(EnvContextPath)
+	if path == "" {
+		return nil, ErrNoContext
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: case context: %w", err)
+	}
+	var c CaseContext
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("sandbox: case context: %w", err)
+	}
+	if c.Version < 1 || c.Version > ContextVersion {
+		return nil, fmt.Errorf("sandbox: case context version %d, this SDK reads version %d", c.Version, ContextVersion)
+	}
+	return &c, nil
+}
@@ -0,0 +1,41 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	t.Setenv(EnvContextPath, "")
+	if _, err := Context(); !errors.Is(err, ErrNoContext) {
+		t.Errorf("err = %v, want ErrNoContext", err)
+	}
+
+	path := filepath.Join(t.TempDir(), ContextFile)
+	t.Setenv(EnvContextPath, path)
+	os.WriteFile(path, []byte(`{
+		"version": 1, "case_id": "case-1", "case_name": "Exfiltration ACME", "examiner": "alice",
+		"evidence": [{"uid": "ev-1", "path": "/evidence/disk.raw", "type": "disk_image", "sha256": "abc123", "offset": 1048576}],
+		"params": {"PARAM_DEPTH": "2"},
+		"added_later": true
+	}`), 0o644)
+	c, err := Context()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.CaseName != "Exfiltration ACME" || c.Examiner != "alice" || c.Params["PARAM_DEPTH"] != "2" {
+		t.Errorf("context = %+v", c)
+	}
+	if len(c.Evidence) != 1 || c.Evidence[0].Type != "disk_image" || c.Evidence[0].Offset != 1<<20 {
+		t.Errorf("evidence = %+v", c.Evidence)
+	}
+
+	for _, bad := range []string{`{"case_id": "case-1"}`, `{"version": 2}`, `{`} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := Context(); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
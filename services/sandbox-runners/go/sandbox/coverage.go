@@ -0,0 +1,219 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CoverageFile is the name of the file inside OUTPUT_DIR in which
+// ReportCoverage records how much of the evidence the script examined.
+const CoverageFile = "coverage.json"
+
+// Units of Coverage, for ReportCoverage.
+const (
+	CoverageBytes   = "bytes"
+	CoverageRecords = "records"
+	CoverageFiles   = "files"
+)
+
+// MaxSkippedRegions is the number of regions noted by SkipRegion that
+// Coverage details; the others are only counted.
+const MaxSkippedRegions = 1000
+
+// ErrInvalidCoverage is returned for a coverage report that examines more
+// than the total, or a skipped region that is negative or without a
+// reason.
+var ErrInvalidCoverage = errors.New("sandbox: invalid coverage report")
+
+// SkippedRegion is a region of the evidence the script did not examine,
+// in Coverage units from the start of its evidence.
+type SkippedRegion struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+	// Reason says why, e.g. "unreadable sectors" or "encrypted volume".
+	Reason string `json:"reason"`
+}
+
+// Coverage is the content of CoverageFile: what the script examined of
+// its evidence against the total, so that an analyst can attest to the
+// thoroughness of the analysis.
+type Coverage struct {
+	// EvidenceUID is EVIDENCE_UID when it is set.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	Examined    int64  `json:"examined"`
+	Total       int64  `json:"total"`
+	// Unit is what Examined and Total count, e.g. CoverageBytes.
+	Unit string `json:"unit"`
+	// Skipped details the first MaxSkippedRegions regions noted by
+	// SkipRegion, and SkippedCount counts them all.
+	Skipped      []SkippedRegion `json:"skipped,omitempty"`
+	SkippedCount int             `json:"skipped_count,omitempty"`
+}
+
+func (c Coverage) validate() error {
+	switch {
+	case c.Examined < 0 || c.Total < 0:
+		return fmt.Errorf("%w: negative examined %d or total %d", ErrInvalidCoverage, c.Examined, c.Total)
+	case c.Examined > c.Total:
+		return fmt.Errorf("%w: examined %d %s of %d", ErrInvalidCoverage, c.Examined, c.Unit, c.Total)
+	case c.Unit == "" || strings.ContainsAny(c.Unit, " \t\r\n"):
+		return fmt.Errorf("%w: unit %q must be one word", ErrInvalidCoverage, c.Unit)
+	case c.SkippedCount < len(c.Skipped):
+		return fmt.Errorf("%w: %d skipped regions detailed, %d counted", ErrInvalidCoverage, len(c.Skipped), c.SkippedCount)
+	}
+	for _, r := range c.Skipped {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r SkippedRegion) validate() error {
+	switch {
+	case r.Offset < 0 || r.Length <= 0:
+		return fmt.Errorf("%w: skipped region at %d of length %d", ErrInvalidCoverage, r.Offset, r.Length)
+	case strings.TrimSpace(r.Reason) == "":
+		return fmt.Errorf("%w: skipped region at %d without a reason", ErrInvalidCoverage, r.Offset)
+	}
+	return nil
+}
+
+// Percent is the share of Total examined, 100 when Total is zero.
+func (c Coverage) Percent() float64 {
+	if c.Total == 0 {
+		return 100
+	}
+	return float64(c.Examined) * 100 / float64(c.Total)
+}
+
+// String describes c for display, e.g. "examined 40 GiB of 40 GiB
+// (100%), 12 regions skipped".
+func (c Coverage) String() string {
+	// The share is rounded down, so that a gap never shows as 100%.
+	percent := "100"
+	if c.Examined != c.Total {
+		percent = trimZero(fmt.Sprintf("%.1f", math.Floor(c.Percent()*10)/10))
+	}
+	s := fmt.Sprintf("examined %s of %s (%s%%)", c.format(c.Examined), c.format(c.Total), percent)
+	switch c.SkippedCount {
+	case 0:
+	case 1:
+		s += ", 1 region skipped"
+	default:
+		s += fmt.Sprintf(", %d regions skipped", c.SkippedCount)
+	}
+	return s
+}
+
+// format writes n in the unit of c, bytes in binary multiples.
+func (c Coverage) format(n int64) string {
+	if c.Unit != CoverageBytes {
+		return fmt.Sprintf("%d %s", n, c.Unit)
+	}
+	const units = "KMGTPE"
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/(1<<10), 0
+	for v >= 1<<10 && i < len(units)-1 {
+		v /= 1 << 10
+		i++
+	}
+	return trimZero(fmt.Sprintf("%.1f", v)) + " " + units[i:i+1] + "iB"
+}
+
+// trimZero drops the ".0" of a number formatted with one decimal.
+func trimZero(s string) string {
+	return strings.TrimSuffix(s, ".0")
+}
+
+// coverage holds the regions noted by SkipRegion for the next
+// ReportCoverage.
+var coverage struct {
+	mu      sync.Mutex
+	skipped []SkippedRegion
+	count   int
+}
+
+// SkipRegion notes a region of the evidence the script could not or
+// chose not to examine, from offset for length units of the coverage
+// report, for the next ReportCoverage:
+//
+//	if _, err := ev.ReadAt(buf, off); err != nil {
+//		sandbox.SkipRegion(off, int64(len(buf)), "unreadable sectors")
+//	}
+func SkipRegion(offset, length int64, reason string) error {
+	r := SkippedRegion{Offset: offset, Length: length, Reason: reason}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	coverage.mu.Lock()
+	defer coverage.mu.Unlock()
+	if len(coverage.skipped) < MaxSkippedRegions {
+		coverage.skipped = append(coverage.skipped, r)
+	}
+	coverage.count++
+	return nil
+}
+
+// ReportCoverage records in CoverageFile that the script examined
+// examined of total units of its evidence, e.g. CoverageBytes, with the
+// regions noted by SkipRegion so far, for the orchestrator to show with
+// the result:
+//
+//	sandbox.ReportCoverage(scanned, size, sandbox.CoverageBytes)
+//
+// Call it once the analysis is done, or as it progresses: each call
+// replaces the report. It fails with ErrInvalidCoverage when examined
+// exceeds total.
+func ReportCoverage(examined, total int64, unit string) error {
+	coverage.mu.Lock()
+	c := Coverage{
+		EvidenceUID:  os.Getenv(EnvEvidenceUID),
+		Examined:     examined,
+		Total:        total,
+		Unit:         unit,
+		Skipped:      append([]SkippedRegion(nil), coverage.skipped...),
+		SkippedCount: coverage.count,
+	}
+	coverage.mu.Unlock()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return WriteOutput(filepath.Join(dir, CoverageFile), append(data, '\n'))
+}
+
+// ReadCoverage returns what ReportCoverage recorded in dir, nil when the
+// script did not call it.
+func ReadCoverage(dir string) (*Coverage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CoverageFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Coverage
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("sandbox: %s: %w", CoverageFile, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
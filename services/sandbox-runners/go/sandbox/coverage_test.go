@@ -0,0 +1,76 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReportCoverage(t *testing.T) {
+	dir := setupEnv(t)
+	t.Cleanup(func() {
+		coverage.mu.Lock()
+		coverage.skipped, coverage.count = nil, 0
+		coverage.mu.Unlock()
+	})
+	if c, err := ReadCoverage(dir); c != nil || err != nil {
+		t.Errorf("ReadCoverage() before the report = %+v, %v", c, err)
+	}
+	if err := SkipRegion(4096, 512, "unreadable sectors"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SkipRegion(-1, 512, "unreadable sectors"); !errors.Is(err, ErrInvalidCoverage) {
+		t.Errorf("SkipRegion() at a negative offset = %v", err)
+	}
+	if err := SkipRegion(0, 512, " "); !errors.Is(err, ErrInvalidCoverage) {
+		t.Errorf("SkipRegion() without a reason = %v", err)
+	}
+	if err := ReportCoverage(41, 40, CoverageBytes); !errors.Is(err, ErrInvalidCoverage) {
+		t.Errorf("ReportCoverage() examining more than the total = %v", err)
+	}
+	if err := ReportCoverage(40<<30-512, 40<<30, CoverageBytes); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadCoverage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.EvidenceUID != "ev-1" || c.SkippedCount != 1 || len(c.Skipped) != 1 || c.Skipped[0].Reason != "unreadable sectors" {
+		t.Fatalf("ReadCoverage() = %+v", c)
+	}
+	if got, want := c.String(), "examined 40 GiB of 40 GiB (99.9%), 1 region skipped"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for i := 0; i < MaxSkippedRegions; i++ {
+		SkipRegion(int64(i), 1, "encrypted")
+	}
+	if err := ReportCoverage(950, 1000, CoverageRecords); err != nil {
+		t.Fatal(err)
+	}
+	c, err = ReadCoverage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Skipped) != MaxSkippedRegions || c.SkippedCount != MaxSkippedRegions+1 {
+		t.Errorf("%d regions detailed, %d counted", len(c.Skipped), c.SkippedCount)
+	}
+	if got, want := c.String(), "examined 950 records of 1000 records (95%), 1001 regions skipped"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestCoverageString(t *testing.T) {
+	for _, tc := range []struct {
+		c    Coverage
+		want string
+	}{
+		{Coverage{Examined: 40 << 30, Total: 40 << 30, Unit: CoverageBytes}, "examined 40 GiB of 40 GiB (100%)"},
+		{Coverage{Examined: 1536, Total: 3 << 20, Unit: CoverageBytes}, "examined 1.5 KiB of 3 MiB (0%)"},
+		{Coverage{Examined: 0, Total: 0, Unit: CoverageFiles}, "examined 0 files of 0 files (100%)"},
+		{Coverage{Examined: 2, Total: 3, Unit: CoverageFiles}, "examined 2 files of 3 files (66.6%)"},
+	} {
+		if got := tc.c.String(); got != tc.want {
+			t.Errorf("%+v: String() = %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package sandbox
+
+import (
+	"os"
+	"strings"
+)
+
+// RequiredEnv lists the variables every sandbox container is launched with.
+var RequiredEnv = []string{EnvCaseID, EnvEvidenceUID, EnvEvidencePath, EnvOutputDir}
+
+// MissingEnvError reports every required variable that was empty or unset.
+type MissingEnvError struct {
+	Keys []string
+}
+
+func (e *MissingEnvError) Error() string {
+	return "sandbox: missing required environment variables: " + strings.Join(e.Keys, ", ")
+}
+
+// MustGetEnv returns the value of key, or an error when it is empty or unset.
+func MustGetEnv(key string) (string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return "", &MissingEnvError{Keys: []string{key}}
+	}
+	return value, nil
+}
+
+// RequireEnv checks that all keys are set, defaulting to RequiredEnv when
+// none are given. The returned *MissingEnvError names every missing key.
+func RequireEnv(keys ...string) error {
+	if len(keys) == 0 {
+		keys = RequiredEnv
+	}
+	var missing []string
+	for _, key := range keys {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingEnvError{Keys: missing}
+	}
+	return nil
+}
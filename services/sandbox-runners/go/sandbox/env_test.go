@@ -0,0 +1,42 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMustGetEnv(t *testing.T) {
+	t.Setenv(EnvCaseID, "case-1")
+	t.Setenv(EnvEvidenceUID, "")
+
+	if v, err := MustGetEnv(EnvCaseID); err != nil || v != "case-1" {
+		t.Fatalf("MustGetEnv(CASE_ID) = %q, %v", v, err)
+	}
+	if _, err := MustGetEnv(EnvEvidenceUID); err == nil {
+		t.Fatal("expected error for empty EVIDENCE_UID")
+	}
+}
+
+func TestRequireEnvNamesEveryMissingKey(t *testing.T) {
+	t.Setenv(EnvCaseID, "case-1")
+	t.Setenv(EnvEvidenceUID, "")
+	t.Setenv(EnvEvidencePath, "")
+	t.Setenv(EnvOutputDir, "/output")
+
+	err := RequireEnv()
+	var missing *MissingEnvError
+	if !errors.As(err, &missing) {
+		t.Fatalf("err = %v, want *MissingEnvError", err)
+	}
+	want := []string{EnvEvidenceUID, EnvEvidencePath}
+	if !reflect.DeepEqual(missing.Keys, want) {
+		t.Errorf("missing = %v, want %v", missing.Keys, want)
+	}
+
+	t.Setenv(EnvEvidenceUID, "ev-1")
+	t.Setenv(EnvEvidencePath, "/evidence")
+	if err := RequireEnv(); err != nil {
+		t.Errorf("RequireEnv() = %v, want nil", err)
+	}
+}
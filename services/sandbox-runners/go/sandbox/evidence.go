@@ -0,0 +1,85 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Supported values for EVIDENCE_HASH_ALGO.
+const (
+	HashSHA256  = "sha256"
+	HashSHA512  = "sha512"
+	HashBLAKE2b = "blake2b"
+)
+
+// hashChunkSize bounds memory use when hashing multi-gigabyte images.
+const hashChunkSize = 1 << 20
+
+// ErrEvidenceHashMismatch is returned when the evidence on disk does not
+// match the digest recorded at ingestion.
+var ErrEvidenceHashMismatch = errors.New("sandbox: evidence hash mismatch")
+
+// NewHash returns a hash for algo, one of the EVIDENCE_HASH_ALGO values;
+// sha256 when empty.
+func NewHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashSHA512:
+		return sha512.New(), nil
+	case HashBLAKE2b:
+		return blake2b.New512(nil)
+	default:
+		return nil, fmt.Errorf("sandbox: unsupported hash algorithm %q", algo)
+	}
+}
+
+// hashFile streams the file at path through algo and returns the hex digest.
+func hashFile(path, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.CopyBuffer(h, f, make([]byte, hashChunkSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyEvidence hashes the file at EVIDENCE_PATH and compares it with
+// EVIDENCE_SHA256, using EVIDENCE_HASH_ALGO (sha256 by default). Scripts
+// should call it before reading evidence so tampering fails fast.
+func VerifyEvidence() error {
+	path, err := MustGetEnv(EnvEvidencePath)
+	if err != nil {
+		return err
+	}
+	expected, err := MustGetEnv(EnvEvidenceSHA256)
+	if err != nil {
+		return err
+	}
+	algo := os.Getenv(EnvEvidenceHashAlgo)
+
+	actual, err := hashFile(path, algo)
+	if err != nil {
+		return fmt.Errorf("sandbox: hash evidence: %w", err)
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("%w: %s is %s, want %s", ErrEvidenceHashMismatch, path, actual, expected)
+	}
+	return nil
+}
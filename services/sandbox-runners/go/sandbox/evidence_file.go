@@ -0,0 +1,367 @@
+package sandbox
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultReadAhead is the read-ahead buffer size of OpenEvidence.
+const DefaultReadAhead = 1 << 20
+
+// EvidenceNotFoundError is returned by OpenEvidence when the file at
+// EVIDENCE_PATH does not exist.
+type EvidenceNotFoundError struct {
+	Path string
+}
+
+func (e *EvidenceNotFoundError) Error() string {
+	return "sandbox: evidence not found: " + e.Path
+}
+
+// Unwrap makes errors.Is(err, fs.ErrNotExist) hold.
+func (e *EvidenceNotFoundError) Unwrap() error { return fs.ErrNotExist }
+
+// EvidenceOption configures OpenEvidence.
+type EvidenceOption func(*EvidenceFile)
+
+// WithReadAhead sets the read-ahead buffer size; reads of at least n bytes
+// bypass the buffer. Zero or less disables buffering.
+func WithReadAhead(n int) EvidenceOption {
+	return func(f *EvidenceFile) { f.readAhead = n }
+}
+
+// EvidenceFile is a read-only handle on the evidence. Small reads are
+// served from a read-ahead buffer, which matters on network-backed mounts.
+// It is safe for concurrent use; wrap it in an io.SectionReader for
+// sequential reads.
+//
+// Compressed evidence is decompressed on the fly, without temporary files:
+// reads moving forward continue the stream, while a read before the
+// current position restarts it from the beginning, so parsers should read
+// compressed evidence mostly sequentially.
+//
+// Evidence given with a byte range is a view of that range: offsets are
+// relative to its start and reads beyond its end return io.EOF.
+//
+// Evidence streamed from object storage, with EVIDENCE_STREAM_SOCKET, is
+// read by HTTP range requests of StreamBlockSize blocks, which are cached
+// in the scratch area, so that a parser reading headers only fetches a
+// few of them.
+type EvidenceFile struct {
+	// f is the file, or the stream, the evidence is read from, and name
+	// how errors refer to it.
+	f    io.Closer
+	name string
+	// src reads f, retrying transient errors.
+	src *retryReaderAt
+	// size is the decompressed size, -1 until known.
+	size int64
+	// stored is the size of the file as stored.
+	stored      int64
+	hash        string
+	readAhead   int
+	compression string
+	// offset and limit are the byte range of the view; limit is -1 when
+	// it runs to the end.
+	offset, limit int64
+
+	mu     sync.Mutex
+	buf    []byte
+	bufOff int64
+	// stream is the decompressed content of compressed evidence, read up
+	// to pos.
+	stream io.ReadCloser
+	pos    int64
+}
+
+// OpenEvidence opens the file at EVIDENCE_PATH, decompressed according to
+// EVIDENCE_COMPRESSION and limited to the range set by EVIDENCE_OFFSET and
+// EVIDENCE_LENGTH. When EVIDENCE_SHA256 is set the stored file is hashed
+// with EVIDENCE_HASH_ALGO through the opened handle and
+// ErrEvidenceHashMismatch is returned if it differs. Reads of the file are
+// retried as WithReadRetry sets; one that still fails returns an
+// EvidenceReadError.
+//
+// Without EVIDENCE_PATH but with EVIDENCE_STREAM_SOCKET, it opens the
+// evidence streamed from object storage. Streamed evidence is not hashed,
+// which would fetch all of it: the orchestrator serves the version of the
+// object checked at the start of the job.
+func OpenEvidence(opts ...EvidenceOption) (*EvidenceFile, error) {
+	offset, length, err := evidenceRange(0)
+	if err != nil {
+		return nil, err
+	}
+	compression := os.Getenv(EnvEvidenceCompression)
+	if socket := os.Getenv(EnvEvidenceStreamSocket); socket != "" && os.Getenv(EnvEvidencePath) == "" {
+		s, err := openStream(socket)
+		if err != nil {
+			return nil, err
+		}
+		name := "streamed evidence " + os.Getenv(EnvEvidenceUID)
+		return newEvidenceFile(s, s, name, s.size, "", "", compression, offset, length, opts)
+	}
+	path, err := MustGetEnv(EnvEvidencePath)
+	if err != nil {
+		return nil, err
+	}
+	return openEvidence(path, os.Getenv(EnvEvidenceSHA256), os.Getenv(EnvEvidenceHashAlgo), compression, offset, length, opts)
+}
+
+// openEvidence opens the evidence at path, stored with compression, and
+// checks it against the digest expected when that is set. A non-zero
+// offset or length limits it to that range.
+func openEvidence(path, expected, algo, compression string, offset, length int64, opts []EvidenceOption) (*EvidenceFile, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &EvidenceNotFoundError{Path: path}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: open evidence: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("sandbox: open evidence: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("sandbox: evidence %s is not a regular file", path)
+	}
+	return newEvidenceFile(f, f, path, info.Size(), expected, algo, compression, offset, length, opts)
+}
+
+// newEvidenceFile returns the evidence read from r, of stored bytes, as
+// openEvidence does; it closes c if it fails.
+func newEvidenceFile(r io.ReaderAt, c io.Closer, name string, stored int64, expected, algo, compression string, offset, length int64, opts []EvidenceOption) (*EvidenceFile, error) {
+	compression = strings.ToLower(compression)
+	if compression == CompressionRaw {
+		compression = ""
+	}
+	if !ValidCompression(compression) {
+		c.Close()
+		return nil, fmt.Errorf("sandbox: unsupported evidence compression %q", compression)
+	}
+	ef := &EvidenceFile{
+		f:           c,
+		name:        name,
+		src:         newRetryReaderAt(r, name),
+		size:        stored,
+		stored:      stored,
+		readAhead:   DefaultReadAhead,
+		compression: compression,
+		offset:      offset,
+		limit:       -1,
+	}
+	if length > 0 {
+		ef.limit = length
+	}
+	if compression != "" {
+		ef.size = -1
+	} else if offset+length > ef.size {
+		c.Close()
+		return nil, fmt.Errorf("sandbox: evidence range %d+%d is beyond the end of %s (%d bytes)", offset, length, name, ef.size)
+	}
+	for _, opt := range opts {
+		opt(ef)
+	}
+	if expected != "" {
+		if err := ef.verify(expected, algo); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return ef, nil
+}
+
+func (ef *EvidenceFile) verify(expected, algo string) error {
+	h, err := NewHash(algo)
+	if err != nil {
+		return err
+	}
+	r := io.NewSectionReader(ef.src, 0, ef.stored)
+	if _, err := io.CopyBuffer(h, r, make([]byte, hashChunkSize)); err != nil {
+		return fmt.Errorf("sandbox: hash evidence: %w", err)
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("%w: %s is %s, want %s", ErrEvidenceHashMismatch, ef.name, actual, expected)
+	}
+	ef.hash = actual
+	return nil
+}
+
+// Size returns the evidence size in bytes, decompressed, or that of its
+// range. For compressed evidence the first call reads the stream to its
+// end; it returns -1 if the stream is corrupt.
+func (ef *EvidenceFile) Size() int64 {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	if ef.size < 0 {
+		if ef.stream == nil {
+			if err := ef.rewind(); err != nil {
+				return -1
+			}
+		}
+		n, err := io.Copy(io.Discard, ef.stream)
+		ef.pos += n
+		if err != nil {
+			return -1
+		}
+		ef.size = ef.pos
+	}
+	size := max(ef.size-ef.offset, 0)
+	if ef.limit >= 0 {
+		size = min(size, ef.limit)
+	}
+	return size
+}
+
+// Range returns the byte range of the evidence the file is limited to,
+// with a zero length when it runs to the end. Offsets in the evidence as a
+// whole, e.g. for provenance, are those of ReadAt plus offset.
+func (ef *EvidenceFile) Range() (offset, length int64) {
+	return ef.offset, max(ef.limit, 0)
+}
+
+// Hash returns the hex digest verified by OpenEvidence, or "" when no
+// EVIDENCE_SHA256 was given. It is the digest of the file as stored.
+func (ef *EvidenceFile) Hash() string { return ef.hash }
+
+// Compression returns the EVIDENCE_COMPRESSION the evidence is read with,
+// "" for raw evidence.
+func (ef *EvidenceFile) Compression() string { return ef.compression }
+
+// ReadAt implements io.ReaderAt, at offsets relative to the start of the
+// evidence's range.
+func (ef *EvidenceFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("sandbox: negative offset %d", off)
+	}
+	if ef.limit >= 0 {
+		if off >= ef.limit {
+			return 0, io.EOF
+		}
+		if rest := ef.limit - off; int64(len(p)) > rest {
+			n, err := ef.readAt(p[:rest], ef.offset+off)
+			if err == nil {
+				err = io.EOF
+			}
+			return n, err
+		}
+	}
+	return ef.readAt(p, ef.offset+off)
+}
+
+// readAt reads at off in the whole evidence.
+func (ef *EvidenceFile) readAt(p []byte, off int64) (int, error) {
+	if len(p) >= ef.readAhead {
+		if ef.compression == "" {
+			return ef.src.ReadAt(p, off)
+		}
+		ef.mu.Lock()
+		defer ef.mu.Unlock()
+		return ef.readStream(p, off)
+	}
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if ef.size >= 0 && pos >= ef.size {
+			return n, io.EOF
+		}
+		if pos < ef.bufOff || pos >= ef.bufOff+int64(len(ef.buf)) {
+			if err := ef.fill(pos); err != nil {
+				return n, err
+			}
+		}
+		n += copy(p[n:], ef.buf[pos-ef.bufOff:])
+	}
+	return n, nil
+}
+
+// fill loads the read-ahead buffer from off.
+func (ef *EvidenceFile) fill(off int64) error {
+	if cap(ef.buf) < ef.readAhead {
+		ef.buf = make([]byte, ef.readAhead)
+	}
+	var n int
+	var err error
+	if ef.compression == "" {
+		n, err = ef.src.ReadAt(ef.buf[:cap(ef.buf)], off)
+	} else {
+		n, err = ef.readStream(ef.buf[:cap(ef.buf)], off)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		ef.buf = ef.buf[:0]
+		return err
+	}
+	ef.buf, ef.bufOff = ef.buf[:n], off
+	if n == 0 {
+		return io.EOF
+	}
+	return nil
+}
+
+// readStream reads the decompressed content at off, continuing the stream
+// when off is at or after its position and restarting it otherwise. The
+// caller holds mu.
+func (ef *EvidenceFile) readStream(p []byte, off int64) (int, error) {
+	if ef.stream == nil || off < ef.pos {
+		if err := ef.rewind(); err != nil {
+			return 0, err
+		}
+	}
+	if skip := off - ef.pos; skip > 0 {
+		n, err := io.CopyN(io.Discard, ef.stream, skip)
+		ef.pos += n
+		if errors.Is(err, io.EOF) {
+			ef.size = ef.pos
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("sandbox: decompress evidence: %w", err)
+		}
+	}
+	n, err := io.ReadFull(ef.stream, p)
+	ef.pos += int64(n)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		ef.size = ef.pos
+		return n, io.EOF
+	case err != nil:
+		return n, fmt.Errorf("sandbox: decompress evidence: %w", err)
+	}
+	return n, nil
+}
+
+// rewind restarts the decompressed stream from the start of the file.
+func (ef *EvidenceFile) rewind() error {
+	if ef.stream != nil {
+		ef.stream.Close()
+		ef.stream = nil
+	}
+	stream, err := Decompress(io.NewSectionReader(ef.src, 0, ef.stored), ef.compression)
+	if err != nil {
+		return fmt.Errorf("sandbox: decompress evidence: %w", err)
+	}
+	ef.stream, ef.pos = stream, 0
+	return nil
+}
+
+// Close closes the evidence file.
+func (ef *EvidenceFile) Close() error {
+	ef.mu.Lock()
+	defer ef.mu.Unlock()
+	if ef.stream != nil {
+		ef.stream.Close()
+		ef.stream = nil
+	}
+	return ef.f.Close()
+}
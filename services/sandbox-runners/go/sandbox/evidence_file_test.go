@@ -0,0 +1,195 @@
+package sandbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestOpenEvidenceReadAt(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidencePath, path)
+
+	for _, readAhead := range []int{0, 7, 64, 1 << 20} {
+		ef, err := OpenEvidence(WithReadAhead(readAhead))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ef.Size() != int64(len(data)) {
+			t.Errorf("Size() = %d, want %d", ef.Size(), len(data))
+		}
+		got, err := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("readAhead=%d: sequential read = %q, %v", readAhead, got, err)
+		}
+		p := make([]byte, 10)
+		if n, err := ef.ReadAt(p, 95); n != 5 || err != io.EOF || string(p[:n]) != "56789" {
+			t.Errorf("readAhead=%d: ReadAt past end = %d, %v", readAhead, n, err)
+		}
+		if n, err := ef.ReadAt(p, 200); n != 0 || err != io.EOF {
+			t.Errorf("readAhead=%d: ReadAt beyond size = %d, %v", readAhead, n, err)
+		}
+		if ef.Hash() != "" {
+			t.Errorf("Hash() = %q without EVIDENCE_SHA256", ef.Hash())
+		}
+		ef.Close()
+	}
+}
+
+func TestOpenEvidenceRange(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidencePath, path)
+	t.Setenv(EnvEvidenceOffset, "25")
+	t.Setenv(EnvEvidenceLength, "30")
+
+	for _, readAhead := range []int{0, 7, 1 << 20} {
+		ef, err := OpenEvidence(WithReadAhead(readAhead))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off, n := ef.Range(); ef.Size() != 30 || off != 25 || n != 30 {
+			t.Errorf("Size() = %d, Range() = %d, %d", ef.Size(), off, n)
+		}
+		got, err := io.ReadAll(io.NewSectionReader(ef, 0, 100))
+		if err != nil || !bytes.Equal(got, data[25:55]) {
+			t.Errorf("readAhead=%d: read = %q, %v", readAhead, got, err)
+		}
+		p := make([]byte, 10)
+		if n, err := ef.ReadAt(p, 25); n != 5 || err != io.EOF || string(p[:n]) != "01234" {
+			t.Errorf("readAhead=%d: ReadAt past end = %q, %v", readAhead, p[:n], err)
+		}
+		if n, err := ef.ReadAt(p, 30); n != 0 || err != io.EOF {
+			t.Errorf("readAhead=%d: ReadAt beyond range = %d, %v", readAhead, n, err)
+		}
+		ef.Close()
+	}
+
+	// Without a length the range runs to the end.
+	t.Setenv(EnvEvidenceLength, "")
+	ef, err := OpenEvidence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ef.Size() != 75 {
+		t.Errorf("Size() = %d, want 75", ef.Size())
+	}
+	ef.Close()
+
+	for _, tc := range [][2]string{{"90", "20"}, {"-1", ""}, {"0", "0"}, {"x", ""}} {
+		t.Setenv(EnvEvidenceOffset, tc[0])
+		t.Setenv(EnvEvidenceLength, tc[1])
+		if ef, err := OpenEvidence(); err == nil {
+			ef.Close()
+			t.Errorf("range %s+%s accepted", tc[0], tc[1])
+		}
+	}
+}
+
+func TestOpenEvidenceNotFound(t *testing.T) {
+	t.Setenv(EnvEvidencePath, filepath.Join(t.TempDir(), "missing.raw"))
+	_, err := OpenEvidence()
+	var nf *EvidenceNotFoundError
+	if !errors.As(err, &nf) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("OpenEvidence() = %v, want EvidenceNotFoundError", err)
+	}
+}
+
+func TestOpenEvidenceVerifiesHash(t *testing.T) {
+	path := writeEvidence(t)
+	sum, err := hashFile(path, HashSHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidenceHashAlgo, HashSHA512)
+	t.Setenv(EnvEvidenceSHA256, sum)
+
+	ef, err := OpenEvidence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ef.Hash() != sum {
+		t.Errorf("Hash() = %q, want %q", ef.Hash(), sum)
+	}
+	ef.Close()
+
+	if err := os.WriteFile(path, []byte("tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenEvidence(); !errors.Is(err, ErrEvidenceHashMismatch) {
+		t.Fatalf("OpenEvidence() = %v, want ErrEvidenceHashMismatch", err)
+	}
+}
+
+func TestOpenEvidenceCompressed(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	compressors := map[string]func(io.Writer) io.WriteCloser{
+		CompressionGzip: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		CompressionZstd: func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+	}
+	for compression, compress := range compressors {
+		var stored bytes.Buffer
+		w := compress(&stored)
+		w.Write(data)
+		w.Close()
+		path := filepath.Join(t.TempDir(), "disk.raw."+compression)
+		if err := os.WriteFile(path, stored.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum, _ := hashFile(path, HashSHA256)
+		t.Setenv(EnvEvidencePath, path)
+		t.Setenv(EnvEvidenceCompression, compression)
+		t.Setenv(EnvEvidenceSHA256, sum)
+
+		for _, readAhead := range []int{0, 64, 1 << 20} {
+			ef, err := OpenEvidence(WithReadAhead(readAhead))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, 10)
+			if n, err := ef.ReadAt(p, 5000); n != 10 || err != nil || string(p) != "0123456789" {
+				t.Errorf("%s: ReadAt(5000) = %q, %v", compression, p[:n], err)
+			}
+			// Reading backwards restarts the stream.
+			if n, err := ef.ReadAt(p[:3], 2); n != 3 || err != nil || string(p[:3]) != "234" {
+				t.Errorf("%s: ReadAt(2) = %q, %v", compression, p[:n], err)
+			}
+			if ef.Size() != int64(len(data)) {
+				t.Errorf("%s: Size() = %d, want %d", compression, ef.Size(), len(data))
+			}
+			got, err := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s, readAhead=%d: sequential read = %d bytes, %v", compression, readAhead, len(got), err)
+			}
+			if n, err := ef.ReadAt(p, int64(len(data))+5); n != 0 || err != io.EOF {
+				t.Errorf("%s: ReadAt beyond size = %d, %v", compression, n, err)
+			}
+			if ef.Hash() != sum || ef.Compression() != compression {
+				t.Errorf("%s: Hash() = %q, Compression() = %q", compression, ef.Hash(), ef.Compression())
+			}
+			ef.Close()
+		}
+	}
+
+	t.Setenv(EnvEvidenceCompression, "lz4")
+	if _, err := OpenEvidence(); err == nil {
+		t.Error("OpenEvidence() accepted an unsupported compression")
+	}
+}
@@ -0,0 +1,123 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// EvidenceMetaFile is the name the runners give the evidence metadata
+// sidecar in the case context directory; EnvEvidenceMetaPath gives its
+// full path.
+const EvidenceMetaFile = "evidence-meta.json"
+
+// ErrInvalidEvidenceMeta is returned for an evidence metadata sidecar that
+// does not match its schema, RecordSchema(EvidenceMetaFile).
+var ErrInvalidEvidenceMeta = errors.New("sandbox: invalid evidence metadata")
+
+// EvidenceMetadata is the acquisition metadata of an evidence item, from
+// the JSON sidecar that came with it. A nil *EvidenceMetadata stands for
+// evidence without one: its methods are safe to call.
+type EvidenceMetadata struct {
+	// AcquiredAt is when the evidence was acquired, against which the
+	// relative times of some artifacts are interpreted, e.g. the uptime
+	// of a memory image.
+	AcquiredAt time.Time `json:"acquired_at"`
+	// Tool and ToolVersion name the acquisition tool, e.g. "FTK Imager"
+	// and "4.7.1".
+	Tool        string `json:"tool"`
+	ToolVersion string `json:"tool_version,omitempty"`
+	// Examiner is who acquired the evidence.
+	Examiner string             `json:"examiner,omitempty"`
+	Device   *AcquisitionDevice `json:"device,omitempty"`
+	// Hashes are the digests computed at acquisition, by algorithm:
+	// "md5", "sha1", "sha256" or "sha512", in hex.
+	Hashes map[string]string `json:"hashes,omitempty"`
+	Notes  string            `json:"notes,omitempty"`
+}
+
+// AcquisitionDevice is the original device an evidence item was acquired
+// from.
+type AcquisitionDevice struct {
+	Model       string `json:"model,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	Description string `json:"description,omitempty"`
+	SizeBytes   int64  `json:"size_bytes,omitempty"`
+}
+
+// Available reports whether the evidence came with metadata.
+func (m *EvidenceMetadata) Available() bool {
+	return m != nil
+}
+
+// Acquired returns the acquisition time, the zero time without metadata.
+func (m *EvidenceMetadata) Acquired() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+	return m.AcquiredAt
+}
+
+// Hash returns the digest of algo computed at acquisition, "" when there
+// is none.
+func (m *EvidenceMetadata) Hash(algo string) string {
+	if m == nil {
+		return ""
+	}
+	return m.Hashes[algo]
+}
+
+// ParseEvidenceMeta parses and validates an evidence metadata sidecar,
+// failing with ErrInvalidEvidenceMeta when it does not match its schema.
+// The orchestrator validates the sidecar before the job runs.
+func ParseEvidenceMeta(data []byte) (*EvidenceMetadata, error) {
+	if err := validateRecord(EvidenceMetaFile, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvidenceMeta, err)
+	}
+	var m EvidenceMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEvidenceMeta, err)
+	}
+	if m.AcquiredAt.IsZero() {
+		return nil, fmt.Errorf("%w: zero acquired_at", ErrInvalidEvidenceMeta)
+	}
+	return &m, nil
+}
+
+// ReadEvidenceMeta reads the evidence metadata sidecar at path. It
+// returns nil and no error when path is empty.
+func ReadEvidenceMeta(path string) (*EvidenceMetadata, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: evidence metadata: %w", err)
+	}
+	return ParseEvidenceMeta(data)
+}
+
+// EvidenceMeta reads the metadata sidecar of the evidence, named by
+// EVIDENCE_META_PATH. It returns nil and no error when the evidence came
+// without one, which Available then reports:
+//
+//	meta, err := sandbox.EvidenceMeta()
+//	if err != nil {
+//		return err
+//	}
+//	if meta.Available() {
+//		boot = meta.Acquired().Add(-uptime)
+//	}
+//
+// EvidenceRef.Meta reads that of another evidence item of the job.
+func EvidenceMeta() (*EvidenceMetadata, error) {
+	return ReadEvidenceMeta(os.Getenv(EnvEvidenceMetaPath))
+}
+
+// Meta reads the metadata sidecar of the evidence item, as EvidenceMeta
+// does.
+func (r EvidenceRef) Meta() (*EvidenceMetadata, error) {
+	return ReadEvidenceMeta(r.MetaPath)
+}
@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testEvidenceMeta = `{
+  "acquired_at": "2024-03-01T12:00:00Z",
+  "tool": "FTK Imager",
+  "tool_version": "4.7.1",
+  "examiner": "j.doe",
+  "device": {"model": "Samsung SSD 860", "serial": "S3Z9NB0K", "size_bytes": 500107862016},
+  "hashes": {"md5": "d41d8cd98f00b204e9800998ecf8427e", "sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}
+}`
+
+func TestEvidenceMeta(t *testing.T) {
+	t.Setenv(EnvEvidenceMetaPath, "")
+	meta, err := EvidenceMeta()
+	if err != nil || meta.Available() || !meta.Acquired().IsZero() || meta.Hash("md5") != "" {
+		t.Fatalf("EvidenceMeta() without a sidecar = %+v, %v", meta, err)
+	}
+
+	path := filepath.Join(t.TempDir(), EvidenceMetaFile)
+	if err := os.WriteFile(path, []byte(testEvidenceMeta), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidenceMetaPath, path)
+	if meta, err = EvidenceMeta(); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.Available() || !meta.Acquired().Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) || meta.Tool != "FTK Imager" ||
+		meta.Device.Serial != "S3Z9NB0K" || meta.Hash("md5") != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("EvidenceMeta() = %+v", meta)
+	}
+
+	t.Setenv(EnvEvidenceUID, "ev-1")
+	t.Setenv(EnvEvidencePath, "/evidence/disk.raw")
+	refs, err := Evidence()
+	if err != nil || len(refs) != 1 || refs[0].MetaPath != path {
+		t.Fatalf("Evidence() = %+v, %v", refs, err)
+	}
+	if meta, err := refs[0].Meta(); err != nil || meta.Tool != "FTK Imager" {
+		t.Errorf("Meta() = %+v, %v", meta, err)
+	}
+
+	os.Remove(path)
+	if _, err := EvidenceMeta(); err == nil || errors.Is(err, ErrInvalidEvidenceMeta) {
+		t.Errorf("EvidenceMeta() of a missing sidecar: %v", err)
+	}
+}
+
+func TestParseEvidenceMeta(t *testing.T) {
+	for name, data := range map[string]string{
+		"not JSON":      `acquired_at: today`,
+		"no tool":       `{"acquired_at": "2024-03-01T12:00:00Z"}`,
+		"no time":       `{"tool": "dd"}`,
+		"bad time":      `{"acquired_at": "yesterday", "tool": "dd"}`,
+		"zero time":     `{"acquired_at": "0001-01-01T00:00:00Z", "tool": "dd"}`,
+		"bad hash":      `{"acquired_at": "2024-03-01T12:00:00Z", "tool": "dd", "hashes": {"md5": "xyz"}}`,
+		"unknown hash":  `{"acquired_at": "2024-03-01T12:00:00Z", "tool": "dd", "hashes": {"crc32": "0badf00d"}}`,
+		"unknown field": `{"acquired_at": "2024-03-01T12:00:00Z", "tool": "dd", "operator": "x"}`,
+	} {
+		if _, err := ParseEvidenceMeta([]byte(data)); !errors.Is(err, ErrInvalidEvidenceMeta) {
+			t.Errorf("%s: ParseEvidenceMeta() = %v, want ErrInvalidEvidenceMeta", name, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Defaults of the read retries of OpenEvidence.
+const (
+	DefaultReadAttempts = 3
+	DefaultReadBackoff  = 100 * time.Millisecond
+)
+
+// ErrEvidenceReadFailed is returned, through an EvidenceReadError, when the
+// evidence file cannot be read: the storage failed, not the parser.
+var ErrEvidenceReadFailed = errors.New("sandbox: evidence read failed")
+
+// EvidenceReadError reports a read of the evidence file that failed, after
+// Attempts tries for an error that looked transient. Offset is that of the
+// file as stored: of the compressed file for compressed evidence, and
+// from its start, not that of its range.
+type EvidenceReadError struct {
+	Path     string
+	Offset   int64
+	Attempts int
+	Err      error
+}
+
+func (e *EvidenceReadError) Error() string {
+	attempts := "1 attempt"
+	if e.Attempts != 1 {
+		attempts = fmt.Sprintf("%d attempts", e.Attempts)
+	}
+	return fmt.Sprintf("sandbox: evidence read failed at offset %d of %s after %s: %v", e.Offset, e.Path, attempts, e.Err)
+}
+
+// Unwrap makes errors.Is(err, ErrEvidenceReadFailed) hold, as well as
+// errors.Is with the error of the last attempt.
+func (e *EvidenceReadError) Unwrap() []error { return []error{ErrEvidenceReadFailed, e.Err} }
+
+// WithReadRetry sets how reads of the evidence file that fail with a
+// transient error, such as EIO or ETIMEDOUT on network storage, are
+// retried: up to attempts tries, the first included, waiting backoff
+// before the first retry and twice as long before each further one. An
+// attempts of one or less disables retries. The default is
+// DefaultReadAttempts and DefaultReadBackoff.
+func WithReadRetry(attempts int, backoff time.Duration) EvidenceOption {
+	return func(f *EvidenceFile) {
+		f.src.attempts, f.src.backoff = max(attempts, 1), backoff
+	}
+}
+
+// retryReaderAt reads the evidence file, retrying transient errors, and
+// reports failures as EvidenceReadError.
+type retryReaderAt struct {
+	r        io.ReaderAt
+	path     string
+	attempts int
+	backoff  time.Duration
+	sleep    func(time.Duration)
+}
+
+func newRetryReaderAt(r io.ReaderAt, path string) *retryReaderAt {
+	return &retryReaderAt{r: r, path: path, attempts: DefaultReadAttempts, backoff: DefaultReadBackoff, sleep: time.Sleep}
+}
+
+// ReadAt implements io.ReaderAt. Bytes read before a transient error are
+// kept: the retry continues after them.
+func (r *retryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, attempt, delay := 0, 1, r.backoff
+	for {
+		m, err := r.r.ReadAt(p[n:], off+int64(n))
+		n += m
+		switch {
+		case err == nil || errors.Is(err, io.EOF):
+			return n, err
+		case attempt < r.attempts && transientReadError(err):
+			r.sleep(delay)
+			attempt, delay = attempt+1, delay*2
+		default:
+			return n, &EvidenceReadError{Path: r.path, Offset: off + int64(n), Attempts: attempt, Err: err}
+		}
+	}
+}
+
+// transientReadError reports whether a read failing with err may succeed
+// if tried again, as on network storage that hiccups.
+func transientReadError(err error) bool {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT, syscall.ESTALE, syscall.ECONNRESET:
+			return true
+		}
+	}
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, errStreamUnavailable)
+}
@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyReaderAt fails the reads at or after failAt with err, failures
+// times, after reading the bytes before failAt.
+type flakyReaderAt struct {
+	r        io.ReaderAt
+	failAt   int64
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.calls++
+	if f.failures > 0 && off+int64(len(p)) > f.failAt {
+		f.failures--
+		if off >= f.failAt {
+			return 0, f.err
+		}
+		n, _ := f.r.ReadAt(p[:f.failAt-off], off)
+		return n, f.err
+	}
+	return f.r.ReadAt(p, off)
+}
+
+// openFlaky opens data as the evidence, compressed with gzip when gz is
+// set, read through a flakyReaderAt.
+func openFlaky(t *testing.T, data []byte, gz bool, flaky *flakyReaderAt, opts ...EvidenceOption) (*EvidenceFile, *[]time.Duration) {
+	t.Helper()
+	stored := data
+	if gz {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		stored = buf.Bytes()
+	}
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, stored, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidencePath, path)
+	if gz {
+		t.Setenv(EnvEvidenceCompression, CompressionGzip)
+	}
+	ef, err := OpenEvidence(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ef.Close() })
+	var slept []time.Duration
+	flaky.r = ef.src.r
+	ef.src.r = flaky
+	ef.src.sleep = func(d time.Duration) { slept = append(slept, d) }
+	return ef, &slept
+}
+
+func TestEvidenceReadRetries(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	flaky := &flakyReaderAt{failAt: 512, failures: 2, err: &os.PathError{Op: "read", Path: "disk.raw", Err: syscall.EIO}}
+	ef, slept := openFlaky(t, data, false, flaky, WithReadAhead(0))
+	got, err := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, %v", len(got), err)
+	}
+	if want := []time.Duration{DefaultReadBackoff, 2 * DefaultReadBackoff}; len(*slept) != 2 || (*slept)[0] != want[0] || (*slept)[1] != want[1] {
+		t.Errorf("backoff %v, want %v", *slept, want)
+	}
+}
+
+func TestEvidenceReadFails(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	for name, tc := range map[string]struct {
+		gz       bool
+		err      error
+		opts     []EvidenceOption
+		attempts int
+	}{
+		"exhausted":     {err: syscall.ETIMEDOUT, opts: []EvidenceOption{WithReadRetry(4, time.Millisecond)}, attempts: 4},
+		"not transient": {err: syscall.EBADF, attempts: 1},
+		"no retry":      {err: syscall.EIO, opts: []EvidenceOption{WithReadRetry(0, 0)}, attempts: 1},
+		"compressed":    {gz: true, err: syscall.EIO, attempts: DefaultReadAttempts},
+	} {
+		t.Run(name, func(t *testing.T) {
+			failAt := int64(300)
+			if tc.gz {
+				// Within the few bytes of the compressed file.
+				failAt = 10
+			}
+			flaky := &flakyReaderAt{failAt: failAt, failures: 100, err: tc.err}
+			ef, _ := openFlaky(t, data, tc.gz, flaky, append([]EvidenceOption{WithReadAhead(64)}, tc.opts...)...)
+			_, err := io.ReadAll(io.NewSectionReader(ef, 0, int64(len(data))))
+			var re *EvidenceReadError
+			if !errors.Is(err, ErrEvidenceReadFailed) || !errors.Is(err, tc.err) || !errors.As(err, &re) {
+				t.Fatalf("read error %v, want ErrEvidenceReadFailed", err)
+			}
+			if re.Attempts != tc.attempts || re.Offset != failAt || !strings.Contains(err.Error(), "evidence read failed at offset ") {
+				t.Errorf("error %+v: %v", re, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// EvidenceRef identifies one evidence item mounted in the sandbox.
+type EvidenceRef struct {
+	UID  string
+	Path string
+	// Type is the evidence type from EVIDENCE_TYPE, empty when unknown.
+	Type string
+	// Offset and Length are the byte range of the file the script is
+	// given, from EVIDENCE_OFFSET and EVIDENCE_LENGTH; a zero Length runs
+	// to the end of the file.
+	Offset int64
+	Length int64
+	// BlockDevice is the read-only block device of EVIDENCE_BLOCK_DEV
+	// exposing the raw disk, empty when the evidence is only a file.
+	BlockDevice string
+	// Streamed reports evidence streamed from object storage, which has
+	// no Path: it is read with OpenEvidence.
+	Streamed bool
+	// MetaPath is the metadata sidecar of EVIDENCE_META_PATH, read with
+	// Meta, empty when the evidence came without one.
+	MetaPath string
+}
+
+// Evidence returns the evidence items the script was launched with. When
+// EVIDENCE_COUNT is unset it falls back to the single EVIDENCE_UID and
+// EVIDENCE_PATH variables, which also stand in for index 0. Only the item
+// of index 0 may be streamed.
+func Evidence() ([]EvidenceRef, error) {
+	countEnv := os.Getenv(EnvEvidenceCount)
+	streamed := os.Getenv(EnvEvidenceStreamSocket) != "" && indexedValue(EnvEvidencePath, 0) == ""
+	if countEnv == "" {
+		uid, path := os.Getenv(EnvEvidenceUID), os.Getenv(EnvEvidencePath)
+		if uid == "" && path == "" {
+			return []EvidenceRef{}, nil
+		}
+		if uid == "" || path == "" && !streamed {
+			return nil, RequireEnv(EnvEvidenceUID, EnvEvidencePath)
+		}
+		ref := EvidenceRef{UID: uid, Path: path, Type: os.Getenv(EnvEvidenceType), BlockDevice: os.Getenv(EnvEvidenceBlockDev), Streamed: streamed, MetaPath: os.Getenv(EnvEvidenceMetaPath)}
+		var err error
+		if ref.Offset, ref.Length, err = evidenceRange(0); err != nil {
+			return nil, err
+		}
+		return []EvidenceRef{ref}, nil
+	}
+
+	count, err := strconv.Atoi(countEnv)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("sandbox: invalid %s %q", EnvEvidenceCount, countEnv)
+	}
+	refs := make([]EvidenceRef, 0, count)
+	var missing []string
+	for i := 0; i < count; i++ {
+		ref := EvidenceRef{
+			UID:  indexedValue(EnvEvidenceUID, i),
+			Path: indexedValue(EnvEvidencePath, i),
+			Type: indexedValue(EnvEvidenceType, i),
+			// Only the disk images of the job have a block device.
+			BlockDevice: indexedValue(EnvEvidenceBlockDev, i),
+			MetaPath:    indexedValue(EnvEvidenceMetaPath, i),
+		}
+		if ref.UID == "" {
+			missing = append(missing, IndexedEnv(EnvEvidenceUID, i))
+		}
+		if i == 0 && streamed {
+			ref.Streamed = true
+		} else if ref.Path == "" {
+			missing = append(missing, IndexedEnv(EnvEvidencePath, i))
+		}
+		if ref.Offset, ref.Length, err = evidenceRange(i); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	if len(missing) > 0 {
+		return nil, &MissingEnvError{Keys: missing}
+	}
+	return refs, nil
+}
+
+// evidenceRange reads the byte range of the n-th evidence item; a zero
+// length runs to the end of the file.
+func evidenceRange(n int) (offset, length int64, err error) {
+	parse := func(key string) (int64, error) {
+		v := indexedValue(key, n)
+		if v == "" {
+			return 0, nil
+		}
+		name := IndexedEnv(key, n)
+		if os.Getenv(name) == "" {
+			name = key
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || i < 0 || (i == 0 && key == EnvEvidenceLength) {
+			return 0, fmt.Errorf("sandbox: invalid %s %q", name, v)
+		}
+		return i, nil
+	}
+	if offset, err = parse(EnvEvidenceOffset); err != nil {
+		return 0, 0, err
+	}
+	if length, err = parse(EnvEvidenceLength); err != nil {
+		return 0, 0, err
+	}
+	return offset, length, nil
+}
+
+// rangeOffset returns the start of the byte range the evidence item uid is
+// given with, 0 when it is read whole or is not one of the job's items.
+func rangeOffset(uid string) (int64, error) {
+	n := 0
+	if count, err := strconv.Atoi(os.Getenv(EnvEvidenceCount)); err == nil {
+		for n < count && indexedValue(EnvEvidenceUID, n) != uid {
+			n++
+		}
+		if n == count {
+			return 0, nil
+		}
+	} else if os.Getenv(EnvEvidenceUID) != uid {
+		return 0, nil
+	}
+	offset, _, err := evidenceRange(n)
+	return offset, err
+}
+
+// indexedValue reads the n-th instance of key, using the unindexed
+// variable as an alias for index 0.
+func indexedValue(key string, n int) string {
+	if v := os.Getenv(IndexedEnv(key, n)); v != "" {
+		return v
+	}
+	if n == 0 {
+		return os.Getenv(key)
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package sandbox
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEvidence(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want []EvidenceRef
+	}{
+		{
+			name: "zero",
+			env:  map[string]string{},
+			want: []EvidenceRef{},
+		},
+		{
+			name: "zero with count",
+			env:  map[string]string{EnvEvidenceCount: "0"},
+			want: []EvidenceRef{},
+		},
+		{
+			name: "one legacy",
+			env:  map[string]string{EnvEvidenceUID: "ev-1", EnvEvidencePath: "/evidence/mem.raw"},
+			want: []EvidenceRef{{UID: "ev-1", Path: "/evidence/mem.raw"}},
+		},
+		{
+			name: "one block device",
+			env:  map[string]string{EnvEvidenceUID: "ev-1", EnvEvidencePath: "/evidence/disk.raw", EnvEvidenceBlockDev: "/dev/evidence"},
+			want: []EvidenceRef{{UID: "ev-1", Path: "/evidence/disk.raw", BlockDevice: "/dev/evidence"}},
+		},
+		{
+			name: "many",
+			env: map[string]string{
+				EnvEvidenceCount:       "3",
+				EnvEvidenceUID:         "ev-1",
+				EnvEvidencePath:        "/evidence/mem.raw",
+				"EVIDENCE_UID_1":       "ev-2",
+				"EVIDENCE_PATH_1":      "/evidence/1/pagefile.sys",
+				"EVIDENCE_UID_2":       "ev-3",
+				"EVIDENCE_PATH_2":      "/evidence/2/hiberfil.sys",
+				"EVIDENCE_TYPE_2":      EvidenceTypeMemory,
+				"EVIDENCE_BLOCK_DEV_1": "/dev/evidence1",
+			},
+			want: []EvidenceRef{
+				{UID: "ev-1", Path: "/evidence/mem.raw"},
+				{UID: "ev-2", Path: "/evidence/1/pagefile.sys", BlockDevice: "/dev/evidence1"},
+				{UID: "ev-3", Path: "/evidence/2/hiberfil.sys", Type: EvidenceTypeMemory},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range []string{EnvEvidenceCount, EnvEvidenceUID, EnvEvidencePath, EnvEvidenceType, EnvEvidenceBlockDev} {
+				t.Setenv(key, tc.env[key])
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			got, err := Evidence()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Evidence() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvidenceMissingIndexedVariable(t *testing.T) {
+	t.Setenv(EnvEvidenceCount, "2")
+	t.Setenv(EnvEvidenceUID, "ev-1")
+	t.Setenv(EnvEvidencePath, "/evidence/mem.raw")
+	t.Setenv("EVIDENCE_UID_1", "ev-2")
+
+	_, err := Evidence()
+	var missing *MissingEnvError
+	if !errors.As(err, &missing) || !reflect.DeepEqual(missing.Keys, []string{"EVIDENCE_PATH_1"}) {
+		t.Fatalf("Evidence() error = %v, want missing EVIDENCE_PATH_1", err)
+	}
+}
@@ -0,0 +1,149 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamBlockSize is the unit in which OpenEvidence fetches evidence
+// streamed from object storage and caches it in the scratch area.
+const StreamBlockSize = 1 << 20
+
+// StreamTimeout bounds each range request for streamed evidence.
+var StreamTimeout = 2 * time.Minute
+
+// StreamURL is the address of the evidence on EVIDENCE_STREAM_SOCKET; its
+// host is ignored. The server answers HEAD with the evidence size and GET
+// with a single "bytes=<first>-<last>" range with 206 Partial Content.
+const StreamURL = "http://evidence/evidence"
+
+// errStreamUnavailable marks the failures of the stream server that may
+// pass, which are retried as WithReadRetry sets.
+var errStreamUnavailable = errors.New("evidence stream unavailable")
+
+// streamReaderAt reads the evidence served on EVIDENCE_STREAM_SOCKET. The
+// blocks it fetched are kept in an unlinked file of the scratch area, so
+// that each is requested once; reads fetching blocks are serialised.
+type streamReaderAt struct {
+	client *http.Client
+	size   int64
+	cache  *os.File
+
+	mu     sync.Mutex
+	cached map[int64]bool
+}
+
+// openStream asks the server on socket for the evidence size and creates
+// the block cache.
+func openStream(socket string) (*streamReaderAt, error) {
+	client := &http.Client{
+		Timeout: StreamTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	resp, err := client.Head(StreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: open streamed evidence: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return nil, fmt.Errorf("sandbox: open streamed evidence: %s", resp.Status)
+	}
+	cache, err := TempFile("evidence-stream-*")
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: open streamed evidence: %w", err)
+	}
+	// The blocks are only read through the handle.
+	os.Remove(cache.Name())
+	return &streamReaderAt{client: client, size: resp.ContentLength, cache: cache, cached: map[int64]bool{}}, nil
+}
+
+// ReadAt implements io.ReaderAt, fetching the blocks of p not cached yet.
+func (s *streamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), s.size)
+	s.mu.Lock()
+	err := s.load(off, end)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.cache.ReadAt(p[:end-off], off)
+	if err == nil && end-off < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// load caches the blocks covering off to end, each run of missing blocks
+// with one range request. The caller holds mu.
+func (s *streamReaderAt) load(off, end int64) error {
+	for b := off / StreamBlockSize; b*StreamBlockSize < end; {
+		if s.cached[b] {
+			b++
+			continue
+		}
+		first := b
+		for b*StreamBlockSize < end && !s.cached[b] {
+			b++
+		}
+		if err := s.fetch(first, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetch caches the blocks from first up to last, excluded.
+func (s *streamReaderAt) fetch(first, last int64) error {
+	start, end := first*StreamBlockSize, min(last*StreamBlockSize, s.size)
+	req, err := http.NewRequest(http.MethodGet, StreamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStreamUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("%w: %v", errStreamUnavailable, err)
+		}
+		return err
+	}
+	n, err := io.Copy(io.NewOffsetWriter(s.cache, start), io.LimitReader(resp.Body, end-start))
+	if err == nil && n < end-start {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errStreamUnavailable, err)
+	}
+	for b := first; b < last; b++ {
+		s.cached[b] = true
+	}
+	return nil
+}
+
+// Close removes the block cache.
+func (s *streamReaderAt) Close() error {
+	s.client.CloseIdleConnections()
+	return s.cache.Close()
+}
@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveStream serves data on a socket in a temporary directory as the
+// orchestrator streams evidence, counting the range requests; the first
+// failures of them fail with 503.
+func serveStream(t *testing.T, data []byte, failures int32) (socket string, requests *atomic.Int32) {
+	t.Helper()
+	socket = filepath.Join(t.TempDir(), "stream.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests = &atomic.Int32{}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			if requests.Add(1) <= failures {
+				http.Error(w, "object store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return socket, requests
+}
+
+func TestOpenEvidenceStreamed(t *testing.T) {
+	data := make([]byte, 3*StreamBlockSize+5)
+	for i := range data {
+		data[i] = byte(i / 7)
+	}
+	socket, requests := serveStream(t, data, 0)
+	t.Setenv(EnvEvidenceUID, "ev-1")
+	t.Setenv(EnvEvidencePath, "")
+	t.Setenv(EnvEvidenceStreamSocket, socket)
+	t.Setenv(EnvScratchDir, t.TempDir())
+
+	ef, err := OpenEvidence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if ef.Size() != int64(len(data)) || ef.Hash() != "" {
+		t.Errorf("Size() = %d, Hash() = %q", ef.Size(), ef.Hash())
+	}
+	header := make([]byte, 512)
+	if _, err := ef.ReadAt(header, 0); err != nil || !bytes.Equal(header, data[:512]) {
+		t.Fatalf("header read = %v", err)
+	}
+	ef.ReadAt(header, 1024)
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d range requests for the header, want 1", n)
+	}
+	got, err := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("sequential read = %d bytes, %v", len(got), err)
+	}
+	if n := requests.Load(); n > 4 {
+		t.Errorf("%d range requests for %d blocks", n, 4)
+	}
+	before := requests.Load()
+	io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+	if requests.Load() != before {
+		t.Error("cached blocks were fetched again")
+	}
+
+	refs, err := Evidence()
+	if err != nil || len(refs) != 1 || !refs[0].Streamed || refs[0].Path != "" {
+		t.Errorf("Evidence() = %+v, %v", refs, err)
+	}
+}
+
+func TestOpenEvidenceStreamedRetries(t *testing.T) {
+	data := []byte("MBR and the rest of the disk")
+	socket, _ := serveStream(t, data, 1)
+	t.Setenv(EnvEvidencePath, "")
+	t.Setenv(EnvEvidenceStreamSocket, socket)
+	t.Setenv(EnvScratchDir, t.TempDir())
+	t.Setenv(EnvEvidenceOffset, "4")
+
+	ef, err := OpenEvidence(WithReadRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	got, err := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+	if err != nil || string(got) != string(data[4:]) {
+		t.Errorf("read %q, %v", got, err)
+	}
+}
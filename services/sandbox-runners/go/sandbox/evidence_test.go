@@ -0,0 +1,61 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeEvidence(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, []byte("evidence bytes\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidencePath, path)
+	return path
+}
+
+func TestVerifyEvidence(t *testing.T) {
+	path := writeEvidence(t)
+
+	for _, algo := range []string{"", HashSHA256, HashSHA512, HashBLAKE2b} {
+		t.Run("algo="+algo, func(t *testing.T) {
+			sum, err := hashFile(path, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv(EnvEvidenceHashAlgo, algo)
+			t.Setenv(EnvEvidenceSHA256, sum)
+			if err := VerifyEvidence(); err != nil {
+				t.Errorf("VerifyEvidence() = %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyEvidenceMismatch(t *testing.T) {
+	path := writeEvidence(t)
+	sum, err := hashFile(path, HashSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvEvidenceSHA256, sum)
+
+	if err := os.WriteFile(path, []byte("tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyEvidence(); !errors.Is(err, ErrEvidenceHashMismatch) {
+		t.Fatalf("VerifyEvidence() = %v, want ErrEvidenceHashMismatch", err)
+	}
+}
+
+func TestVerifyEvidenceUnknownAlgo(t *testing.T) {
+	writeEvidence(t)
+	t.Setenv(EnvEvidenceSHA256, "00")
+	t.Setenv(EnvEvidenceHashAlgo, "md5")
+	if err := VerifyEvidence(); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+)
+
+// Evidence types of EVIDENCE_TYPE, as detected by DetectEvidenceType.
+const (
+	EvidenceTypeMemory       = "memory_dump"
+	EvidenceTypeEWF          = "ewf"
+	EvidenceTypePCAP         = "pcap"
+	EvidenceTypeRegistryHive = "registry_hive"
+	EvidenceTypeSQLite       = "sqlite"
+	EvidenceTypeDiskImage    = "disk_image"
+)
+
+// EvidenceTypes returns the evidence types DetectEvidenceType can report.
+func EvidenceTypes() []string {
+	return []string{EvidenceTypeDiskImage, EvidenceTypeEWF, EvidenceTypeMemory, EvidenceTypePCAP, EvidenceTypeRegistryHive, EvidenceTypeSQLite}
+}
+
+// EvidenceTypeHeaderSize is the number of leading bytes DetectEvidenceType
+// needs to tell every type apart.
+const EvidenceTypeHeaderSize = 4096
+
+// evidenceMagics are the signatures at the start of each format.
+var evidenceMagics = []struct {
+	magic string
+	typ   string
+}{
+	{"EVF\x09\x0d\x0a\xff\x00", EvidenceTypeEWF}, // E01
+	{"EVF2\x0d\x0a\x81\x00", EvidenceTypeEWF},    // Ex01
+	// pcap in both byte orders, with micro- and nanosecond timestamps,
+	// and pcapng.
+	{"\xd4\xc3\xb2\xa1", EvidenceTypePCAP},
+	{"\xa1\xb2\xc3\xd4", EvidenceTypePCAP},
+	{"\x4d\x3c\xb2\xa1", EvidenceTypePCAP},
+	{"\xa1\xb2\x3c\x4d", EvidenceTypePCAP},
+	{"\x0a\x0d\x0d\x0a", EvidenceTypePCAP},
+	{"regf", EvidenceTypeRegistryHive},
+	{"SQLite format 3\x00", EvidenceTypeSQLite},
+	// LiME and AVML captures, Windows crash dumps and hibernation files.
+	{"EMiL", EvidenceTypeMemory},
+	{"PAGEDUMP", EvidenceTypeMemory},
+	{"PAGEDU64", EvidenceTypeMemory},
+	{"hibr", EvidenceTypeMemory},
+	{"HIBR", EvidenceTypeMemory},
+	{"wake", EvidenceTypeMemory},
+	{"WAKE", EvidenceTypeMemory},
+}
+
+// memoryExts name the raw memory images that have no header.
+var memoryExts = []string{".mem", ".vmem", ".lime"}
+
+// DetectEvidenceType returns the type of an evidence file from header, its
+// first bytes (EvidenceTypeHeaderSize are enough), and its name: by
+// signature, then as a raw disk image by its partition table, then as raw
+// memory by the extensions of acquisition tools, since raw memory has no
+// signature. It returns "" for a type it does not recognize.
+func DetectEvidenceType(header []byte, name string) string {
+	for _, m := range evidenceMagics {
+		if bytes.HasPrefix(header, []byte(m.magic)) {
+			return m.typ
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range memoryExts {
+		if ext == e {
+			return EvidenceTypeMemory
+		}
+	}
+	// A GPT header follows the protective MBR; an MBR ends with 0x55AA.
+	if len(header) >= 520 && string(header[512:520]) == "EFI PART" {
+		return EvidenceTypeDiskImage
+	}
+	if len(header) >= 512 && binary.LittleEndian.Uint16(header[510:]) == 0xaa55 {
+		return EvidenceTypeDiskImage
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package sandbox
+
+import "testing"
+
+func TestDetectEvidenceType(t *testing.T) {
+	mbr := make([]byte, 512)
+	mbr[510], mbr[511] = 0x55, 0xaa
+	gpt := append(append([]byte{}, mbr...), "EFI PART"...)
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		file   string
+		want   string
+	}{
+		{"e01", []byte("EVF\x09\x0d\x0a\xff\x00\x01"), "disk.E01", EvidenceTypeEWF},
+		{"pcap", []byte("\xd4\xc3\xb2\xa1\x02\x00\x04\x00"), "capture.bin", EvidenceTypePCAP},
+		{"pcapng", []byte("\x0a\x0d\x0d\x0a\x1c\x00\x00\x00"), "capture.pcapng", EvidenceTypePCAP},
+		{"hive", []byte("regf\x00\x00\x00\x00"), "NTUSER.DAT", EvidenceTypeRegistryHive},
+		{"sqlite", []byte("SQLite format 3\x00\x10\x00"), "History", EvidenceTypeSQLite},
+		{"lime", []byte("EMiL\x01\x00\x00\x00"), "mem.bin", EvidenceTypeMemory},
+		{"crash dump", []byte("PAGEDU64"), "MEMORY.DMP", EvidenceTypeMemory},
+		{"raw memory", make([]byte, 4096), "host.vmem", EvidenceTypeMemory},
+		{"mbr", mbr, "disk.raw", EvidenceTypeDiskImage},
+		{"gpt", gpt, "disk.raw", EvidenceTypeDiskImage},
+		{"unknown", []byte("MZ\x90\x00"), "sample.exe", ""},
+		{"short", []byte("EV"), "x", ""},
+	} {
+		if got := DetectEvidenceType(tc.header, tc.file); got != tc.want {
+			t.Errorf("%s: type = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
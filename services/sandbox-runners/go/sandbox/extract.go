@@ -0,0 +1,124 @@
+package sandbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ExtractedDir is the subdirectory of OUTPUT_DIR that holds the files
+// written by ExtractFile.
+const ExtractedDir = "extracted"
+
+// ExtractOption configures ExtractFile.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	parent     string
+	offset     *int64
+	provenance string
+}
+
+// FromEvidence sets the UID of the evidence the file was carved from;
+// EVIDENCE_UID by default.
+func FromEvidence(uid string) ExtractOption {
+	return func(o *extractOptions) { o.parent = uid }
+}
+
+// AtOffset records the file's byte offset in the parent evidence, as read
+// by the script: for evidence given with a byte range, the offset is
+// relative to the range and the manifest records it in the whole file.
+func AtOffset(offset int64) ExtractOption {
+	return func(o *extractOptions) { o.offset = &offset }
+}
+
+// WithProvenance describes how the file was found, e.g. "PE header in
+// VAD of pid 1234".
+func WithProvenance(provenance string) ExtractOption {
+	return func(o *extractOptions) { o.provenance = provenance }
+}
+
+// ExtractedEvidenceUID is the UID given to a file extracted from the
+// evidence parent with the given SHA256.
+func ExtractedEvidenceUID(parent, sha256 string) string {
+	if len(sha256) > 16 {
+		sha256 = sha256[:16]
+	}
+	return parent + "-" + sha256
+}
+
+// ExtractFile writes the content of r to OUTPUT_DIR/extracted/name and
+// registers it in the artifact manifest as ArtifactExtractedEvidence,
+// with its SHA256 and a reference to the evidence it was carved from.
+// The orchestrator ingests it as a child of that evidence, under the
+// returned UID. An existing file of the same name is not overwritten.
+func ExtractFile(name string, r io.Reader, opts ...ExtractOption) (EvidenceRef, error) {
+	var o extractOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.parent == "" {
+		uid, err := MustGetEnv(EnvEvidenceUID)
+		if err != nil {
+			return EvidenceRef{}, err
+		}
+		o.parent = uid
+	}
+	if o.offset != nil {
+		start, err := rangeOffset(o.parent)
+		if err != nil {
+			return EvidenceRef{}, err
+		}
+		offset := *o.offset + start
+		o.offset = &offset
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		return EvidenceRef{}, fmt.Errorf("sandbox: invalid extracted file name %q", name)
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return EvidenceRef{}, err
+	}
+	dirMode := outputDirMode(OutputMode())
+	if err := os.MkdirAll(filepath.Join(dir, ExtractedDir), dirMode); err != nil {
+		return EvidenceRef{}, err
+	}
+	if err := os.Chmod(filepath.Join(dir, ExtractedDir), dirMode); err != nil {
+		return EvidenceRef{}, err
+	}
+	path := filepath.Join(dir, ExtractedDir, name)
+	f, err := openOutput(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return EvidenceRef{}, fmt.Errorf("sandbox: extract %s: %w", name, err)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return EvidenceRef{}, fmt.Errorf("sandbox: extract %s: %w", name, err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	ref := EvidenceRef{UID: ExtractedEvidenceUID(o.parent, sum), Path: path}
+	err = updateManifest(dir, Artifact{
+		Path:        ExtractedDir + "/" + name,
+		Kind:        ArtifactExtractedEvidence,
+		SHA256:      sum,
+		Size:        size,
+		EvidenceUID: ref.UID,
+		Parent:      o.parent,
+		Offset:      o.offset,
+		Provenance:  o.provenance,
+	})
+	if err != nil {
+		return EvidenceRef{}, errors.Join(fmt.Errorf("sandbox: register %s: %w", name, err), os.Remove(path))
+	}
+	return ref, nil
+}
@@ -0,0 +1,78 @@
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractFile(t *testing.T) {
+	dir := setupEnv(t)
+
+	ref, err := ExtractFile("payload.exe", strings.NewReader("MZ"), AtOffset(4096), WithProvenance("VAD of pid 1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const mzSHA256 = "9b8db510ef42b8ed54a3712636fda55a4f8cfcd5493e20b74ab00cd4f3979f2d"
+	if ref.UID != "ev-1-9b8db510ef42b8ed" || ref.Path != filepath.Join(dir, ExtractedDir, "payload.exe") {
+		t.Errorf("ref = %+v", ref)
+	}
+	if data, _ := os.ReadFile(ref.Path); string(data) != "MZ" {
+		t.Errorf("content = %q", data)
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Artifacts) != 1 {
+		t.Fatalf("artifacts = %+v", m.Artifacts)
+	}
+	a := m.Artifacts[0]
+	if a.Path != "extracted/payload.exe" || a.Kind != ArtifactExtractedEvidence || a.SHA256 != mzSHA256 || a.Size != 2 {
+		t.Errorf("artifact = %+v", a)
+	}
+	if a.EvidenceUID != ref.UID || a.Parent != "ev-1" || a.Offset == nil || *a.Offset != 4096 || a.Provenance != "VAD of pid 1234" {
+		t.Errorf("evidence fields = %+v", a)
+	}
+
+	if _, err := ExtractFile("payload.exe", strings.NewReader("MZ")); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("second extraction err = %v, want fs.ErrExist", err)
+	}
+}
+
+func TestExtractFileFromEvidence(t *testing.T) {
+	dir := setupEnv(t)
+	ref, err := ExtractFile("page.bin", strings.NewReader("x"), FromEvidence("ev-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := ReadManifest(dir)
+	if a := m.Artifacts[0]; a.Parent != "ev-2" || a.Offset != nil || !strings.HasPrefix(ref.UID, "ev-2-") {
+		t.Errorf("artifact = %+v, ref = %+v", a, ref)
+	}
+}
+
+func TestExtractFileInRange(t *testing.T) {
+	dir := setupEnv(t)
+	t.Setenv(EnvEvidenceOffset, "1048576")
+	if _, err := ExtractFile("payload.exe", strings.NewReader("MZ"), AtOffset(4096)); err != nil {
+		t.Fatal(err)
+	}
+	m, _ := ReadManifest(dir)
+	if a := m.Artifacts[0]; a.Offset == nil || *a.Offset != 1048576+4096 {
+		t.Errorf("artifact = %+v, want the offset in the whole evidence", a)
+	}
+}
+
+func TestExtractFileRejectsPaths(t *testing.T) {
+	setupEnv(t)
+	for _, name := range []string{"../escape", "sub/file", "", ".."} {
+		if _, err := ExtractFile(name, strings.NewReader("x")); err == nil {
+			t.Errorf("ExtractFile(%q) should fail", name)
+		}
+	}
+}
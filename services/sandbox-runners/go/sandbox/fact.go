@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FactsFile is the name of the facts file inside OUTPUT_DIR, merged by the
+// platform into the profile of each evidence item.
+const FactsFile = "facts.ndjson"
+
+// ErrInvalidFact is returned for a fact with a malformed key, an empty
+// value or a confidence outside [0, 1].
+var ErrInvalidFact = errors.New("sandbox: invalid fact")
+
+// factKey is the format of Fact.Key: lowercase dotted words.
+var factKey = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+
+// Fact is one line of facts.ndjson: a descriptive attribute of an
+// evidence item, such as its OS version or hostname.
+type Fact struct {
+	EvidenceUID string `json:"evidence_uid"`
+	// Key names the attribute in lowercase dotted words, e.g.
+	// "os.version", "host.name" or "os.install_date".
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Confidence is how sure the script is of Value, from 0 to 1.
+	Confidence float64 `json:"confidence"`
+}
+
+func (f Fact) validate() error {
+	switch {
+	case len(f.Key) > 128 || !factKey.MatchString(f.Key):
+		return fmt.Errorf("%w: key %q, want lowercase dotted words", ErrInvalidFact, f.Key)
+	case strings.TrimSpace(f.Value) == "":
+		return fmt.Errorf("%w: %s has an empty value", ErrInvalidFact, f.Key)
+	case !(f.Confidence >= 0 && f.Confidence <= 1):
+		return fmt.Errorf("%w: %s has confidence %g, want 0 to 1", ErrInvalidFact, f.Key, f.Confidence)
+	}
+	return nil
+}
+
+// ValidateFact checks fact against the schema of facts.ndjson, which the
+// orchestrator enforces on every line after the run. EmitFact calls it.
+func ValidateFact(fact Fact) error {
+	if err := fact.validate(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(fact)
+	if err != nil {
+		return fmt.Errorf("sandbox: invalid fact: %w", err)
+	}
+	if err := validateRecord(FactsFile, line); err != nil {
+		return fmt.Errorf("sandbox: invalid fact: %w", err)
+	}
+	return nil
+}
+
+// EmitFact appends a fact about the evidence item of EVIDENCE_UID to
+// facts.ndjson in OUTPUT_DIR, e.g.
+//
+//	sandbox.EmitFact("os.version", "Windows 10 22H2", 0.9)
+//
+// It is safe for concurrent use.
+func EmitFact(key, value string, confidence float64) error {
+	uid, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return err
+	}
+	return EmitFactFor(uid, key, value, confidence)
+}
+
+// EmitFactFor is EmitFact for the evidence item evidenceUID, one of
+// several the job correlates.
+func EmitFactFor(evidenceUID, key, value string, confidence float64) error {
+	fact := Fact{EvidenceUID: evidenceUID, Key: key, Value: value, Confidence: confidence}
+	if err := ValidateFact(fact); err != nil {
+		return err
+	}
+	return appendRecord(FactsFile, fact)
+}
+
+// ReadFacts parses the facts in dir; a missing file means none. Malformed
+// lines and facts that do not match the schema are skipped and reported
+// in err, after the facts that could be read; RecordErrors lists them.
+func ReadFacts(dir string) ([]Fact, error) {
+	return readRecords(dir, FactsFile, Fact.validate)
+}
@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFact(t *testing.T) {
+	for _, tc := range []struct {
+		fact Fact
+		ok   bool
+	}{
+		{Fact{Key: "os.version", Value: "Windows 10 22H2", Confidence: 0.9}, true},
+		{Fact{Key: "host.name", Value: "WS-042", Confidence: 0}, true},
+		{Fact{Key: "os.install_date", Value: "2021-03-04T10:00:00Z", Confidence: 1}, true},
+		{Fact{Key: "OS.Version", Value: "x", Confidence: 1}, false},
+		{Fact{Key: "os..version", Value: "x", Confidence: 1}, false},
+		{Fact{Key: "", Value: "x", Confidence: 1}, false},
+		{Fact{Key: "host.name", Value: " ", Confidence: 1}, false},
+		{Fact{Key: "host.name", Value: "WS-042", Confidence: 1.5}, false},
+		{Fact{Key: "host.name", Value: "WS-042", Confidence: -0.1}, false},
+	} {
+		tc.fact.EvidenceUID = "ev-1"
+		err := ValidateFact(tc.fact)
+		if tc.ok && err != nil {
+			t.Errorf("%+v: %v", tc.fact, err)
+		} else if !tc.ok && !errors.Is(err, ErrInvalidFact) {
+			t.Errorf("%+v: err = %v, want ErrInvalidFact", tc.fact, err)
+		}
+	}
+}
+
+func TestEmitFact(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitFact("os.version", "Windows 10 22H2", 0.9); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitFactFor("ev-2", "host.name", "WS-042", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitFact("os.version", "", 0.5); !errors.Is(err, ErrInvalidFact) {
+		t.Errorf("err = %v, want ErrInvalidFact", err)
+	}
+	// Lines written by scripts in other languages are checked on reading.
+	f, err := os.OpenFile(filepath.Join(dir, FactsFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"evidence_uid":"ev-1","key":"os.version","value":"x"}` + "\n")
+	f.Close()
+
+	facts, err := ReadFacts(dir)
+	want := []Fact{
+		{EvidenceUID: "ev-1", Key: "os.version", Value: "Windows 10 22H2", Confidence: 0.9},
+		{EvidenceUID: "ev-2", Key: "host.name", Value: "WS-042", Confidence: 1},
+	}
+	if len(facts) != 2 || facts[0] != want[0] || facts[1] != want[1] {
+		t.Errorf("facts = %+v, want %+v", facts, want)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 4 {
+		t.Errorf("err = %v, want line 4 rejected", err)
+	}
+}
@@ -0,0 +1,75 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrEvidenceFetchUnavailable is returned by FetchEvidence when the job
+// was not allowed to fetch evidence.
+var ErrEvidenceFetchUnavailable = errors.New("sandbox: evidence fetching is not enabled for this job")
+
+// ErrEvidenceFetchDenied is returned by FetchEvidence when the orchestrator
+// refuses the request, e.g. for evidence of another case.
+var ErrEvidenceFetchDenied = errors.New("sandbox: evidence fetch denied")
+
+// FetchTimeout bounds a FetchEvidence request, which may have to copy the
+// evidence.
+var FetchTimeout = 10 * time.Minute
+
+// FetchRequest is a line sent on EVIDENCE_FETCH_SOCKET.
+type FetchRequest struct {
+	UID string `json:"uid"`
+}
+
+// FetchResponse answers a FetchRequest: the evidence's path in the
+// container, or why it was not fetched.
+type FetchResponse struct {
+	Path        string `json:"path,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	HashAlgo    string `json:"hash_algo,omitempty"`
+	Compression string `json:"compression,omitempty"`
+	// Offset and Length are the evidence's byte range, as EVIDENCE_OFFSET
+	// and EVIDENCE_LENGTH.
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Denied reports that the request was refused rather than failed.
+	Denied bool `json:"denied,omitempty"`
+}
+
+// FetchEvidence asks the orchestrator for the evidence item uid of the
+// script's case and opens it like OpenEvidence: read-only, checked against
+// its recorded digest and decompressed on the fly. The item is mounted
+// for the rest of the job, so fetching it again is cheap.
+func FetchEvidence(uid string, opts ...EvidenceOption) (*EvidenceFile, error) {
+	socket := os.Getenv(EnvEvidenceFetchSocket)
+	if socket == "" {
+		return nil, ErrEvidenceFetchUnavailable
+	}
+	conn, err := net.DialTimeout("unix", socket, FetchTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: fetch evidence %s: %w", uid, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(FetchTimeout))
+	if err := json.NewEncoder(conn).Encode(FetchRequest{UID: uid}); err != nil {
+		return nil, fmt.Errorf("sandbox: fetch evidence %s: %w", uid, err)
+	}
+	var resp FetchResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("sandbox: fetch evidence %s: %w", uid, err)
+	}
+	switch {
+	case resp.Denied:
+		return nil, fmt.Errorf("%w: %s: %s", ErrEvidenceFetchDenied, uid, resp.Error)
+	case resp.Error != "":
+		return nil, fmt.Errorf("sandbox: fetch evidence %s: %s", uid, resp.Error)
+	}
+	return openEvidence(resp.Path, resp.SHA256, resp.HashAlgo, resp.Compression, resp.Offset, resp.Length, opts)
+}
@@ -0,0 +1,74 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serveFetches answers FetchEvidence requests on a socket in a temporary
+// directory with the responses keyed by UID.
+func serveFetches(t *testing.T, responses map[string]FetchResponse) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "fetch.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req FetchRequest
+			json.NewDecoder(bufio.NewReader(conn)).Decode(&req)
+			json.NewEncoder(conn).Encode(responses[req.UID])
+			conn.Close()
+		}
+	}()
+	return socket
+}
+
+func TestFetchEvidence(t *testing.T) {
+	path := writeEvidence(t)
+	sum, _ := hashFile(path, HashSHA256)
+	t.Setenv(EnvEvidenceFetchSocket, serveFetches(t, map[string]FetchResponse{
+		"ev-2":     {Path: path, SHA256: sum},
+		"ev-bad":   {Path: path, SHA256: "abc123"},
+		"ev-other": {Error: "evidence belongs to another case", Denied: true},
+		"ev-404":   {Error: "evidence ev-404 not found"},
+	}))
+
+	ef, err := FetchEvidence("ev-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(io.NewSectionReader(ef, 0, ef.Size()))
+	want, _ := os.ReadFile(path)
+	if string(data) != string(want) || ef.Hash() != sum {
+		t.Errorf("fetched %q with hash %q", data, ef.Hash())
+	}
+	ef.Close()
+
+	if _, err := FetchEvidence("ev-bad"); !errors.Is(err, ErrEvidenceHashMismatch) {
+		t.Errorf("tampered evidence: err = %v, want ErrEvidenceHashMismatch", err)
+	}
+	if _, err := FetchEvidence("ev-other"); !errors.Is(err, ErrEvidenceFetchDenied) {
+		t.Errorf("cross-case fetch: err = %v, want ErrEvidenceFetchDenied", err)
+	}
+	if _, err := FetchEvidence("ev-404"); err == nil || errors.Is(err, ErrEvidenceFetchDenied) {
+		t.Errorf("missing evidence: err = %v", err)
+	}
+
+	t.Setenv(EnvEvidenceFetchSocket, "")
+	if _, err := FetchEvidence("ev-2"); !errors.Is(err, ErrEvidenceFetchUnavailable) {
+		t.Errorf("without a socket: err = %v, want ErrEvidenceFetchUnavailable", err)
+	}
+}
@@ -0,0 +1,151 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GraphFile is the name of the relationships file inside OUTPUT_DIR,
+// merged by the platform into the case's investigation graph.
+const GraphFile = "graph.ndjson"
+
+// ErrInvalidEdge is returned for an edge with a malformed node or an
+// unknown relation.
+var ErrInvalidEdge = errors.New("sandbox: invalid graph edge")
+
+// Relations of the platform's vocabulary. Any other relation must be
+// namespaced, e.g. "acme:beaconed_to", so that the relations of different
+// scripts never collide.
+const (
+	RelationSpawned     = "spawned"
+	RelationDropped     = "dropped"
+	RelationWrote       = "wrote"
+	RelationRead        = "read"
+	RelationDeleted     = "deleted"
+	RelationExecuted    = "executed"
+	RelationLoaded      = "loaded"
+	RelationConnectedTo = "connected_to"
+	RelationResolved    = "resolved"
+	RelationLoggedOnTo  = "logged_on_to"
+	RelationOwns        = "owns"
+	RelationContains    = "contains"
+	RelationPersistsVia = "persists_via"
+)
+
+// knownRelations is the set of the Relation constants.
+var knownRelations = map[string]bool{
+	RelationSpawned: true, RelationDropped: true, RelationWrote: true, RelationRead: true,
+	RelationDeleted: true, RelationExecuted: true, RelationLoaded: true, RelationConnectedTo: true,
+	RelationResolved: true, RelationLoggedOnTo: true, RelationOwns: true, RelationContains: true,
+	RelationPersistsVia: true,
+}
+
+var (
+	// nodeType is the format of Node.Type: a lowercase word, e.g.
+	// "process" or "registry_key".
+	nodeType = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// namespacedRelation is the format of a relation outside the
+	// vocabulary: "<namespace>:<relation>" in lowercase words.
+	namespacedRelation = regexp.MustCompile(`^[a-z][a-z0-9_]*:[a-z][a-z0-9_]*$`)
+)
+
+// Node is an entity of the investigation graph, e.g. a process, a file or
+// a host. The platform merges the nodes that share a Type and an ID, e.g.
+// the process of PID 4242 at a given start time, which the script chooses
+// to be unique within the case.
+type Node struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func (n Node) String() string { return n.Type + "/" + n.ID }
+
+func (n Node) validate() error {
+	switch {
+	case len(n.Type) > 64 || !nodeType.MatchString(n.Type):
+		return fmt.Errorf("node type %q, want a lowercase word", n.Type)
+	case n.ID == "" || len(n.ID) > 1024 || strings.ContainsAny(n.ID, "\x00\r\n"):
+		return fmt.Errorf("node %s: empty, multi-line or too long ID", n.Type)
+	}
+	return nil
+}
+
+// Edge is one line of graph.ndjson: a relationship between two nodes
+// found in an evidence item, e.g. process A spawned process B.
+type Edge struct {
+	// EvidenceUID defaults to EVIDENCE_UID in EmitEdge.
+	EvidenceUID string `json:"evidence_uid"`
+	Source      Node   `json:"source"`
+	// Relation is one of the Relation constants or a namespaced one.
+	Relation string `json:"relation"`
+	Target   Node   `json:"target"`
+	// Fields describe the relationship, e.g. the command line of a
+	// spawned process.
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+func (e Edge) validate() error {
+	if err := e.Source.validate(); err != nil {
+		return fmt.Errorf("%w: source %v", ErrInvalidEdge, err)
+	}
+	if err := e.Target.validate(); err != nil {
+		return fmt.Errorf("%w: target %v", ErrInvalidEdge, err)
+	}
+	if !knownRelations[e.Relation] && (len(e.Relation) > 128 || !namespacedRelation.MatchString(e.Relation)) {
+		return fmt.Errorf("%w: unknown relation %q, want a known one or <namespace>:<relation>", ErrInvalidEdge, e.Relation)
+	}
+	return nil
+}
+
+// ValidateEdge checks edge against the relation vocabulary and the schema
+// of graph.ndjson, which the orchestrator enforces on every line after the
+// run. EmitEdge calls it.
+func ValidateEdge(edge Edge) error {
+	if err := edge.validate(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(edge)
+	if err != nil {
+		return fmt.Errorf("sandbox: invalid graph edge: %w", err)
+	}
+	if err := validateRecord(GraphFile, line); err != nil {
+		return fmt.Errorf("sandbox: invalid graph edge: %w", err)
+	}
+	return nil
+}
+
+// EmitEdge appends a relationship found in the evidence item of
+// EVIDENCE_UID to graph.ndjson in OUTPUT_DIR, e.g.
+//
+//	sandbox.EmitEdge("process", "4242@2024-05-01T10:00:00Z", sandbox.RelationSpawned,
+//		"process", "4300@2024-05-01T10:00:02Z", map[string]any{"cmdline": "cmd.exe /c whoami"})
+//
+// It is safe for concurrent use.
+func EmitEdge(srcType, srcID, relation, dstType, dstID string, fields map[string]any) error {
+	uid, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return err
+	}
+	edge := Edge{
+		EvidenceUID: uid,
+		Source:      Node{Type: srcType, ID: srcID},
+		Relation:    relation,
+		Target:      Node{Type: dstType, ID: dstID},
+		Fields:      fields,
+	}
+	if err := ValidateEdge(edge); err != nil {
+		return err
+	}
+	return appendRecord(GraphFile, edge)
+}
+
+// ReadGraph parses the edges in dir; a missing file means none. Malformed
+// lines, unknown relations and edges that do not match the schema are
+// skipped and reported in err, after the edges that could be read;
+// RecordErrors lists them.
+func ReadGraph(dir string) ([]Edge, error) {
+	return readRecords(dir, GraphFile, Edge.validate)
+}
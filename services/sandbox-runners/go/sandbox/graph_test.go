@@ -0,0 +1,70 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateEdge(t *testing.T) {
+	proc := func(id string) Node { return Node{Type: "process", ID: id} }
+	for _, tc := range []struct {
+		edge Edge
+		ok   bool
+	}{
+		{Edge{Source: proc("4242"), Relation: RelationSpawned, Target: proc("4300")}, true},
+		{Edge{Source: proc("4242"), Relation: RelationDropped, Target: Node{Type: "file", ID: `C:\Temp\x.exe`}}, true},
+		{Edge{Source: proc("4242"), Relation: "acme:beaconed_to", Target: Node{Type: "domain", ID: "evil.example"}}, true},
+		{Edge{Source: proc("4242"), Relation: "beaconed_to", Target: proc("4300")}, false},
+		{Edge{Source: proc("4242"), Relation: "Spawned", Target: proc("4300")}, false},
+		{Edge{Source: proc("4242"), Relation: "acme:", Target: proc("4300")}, false},
+		{Edge{Source: proc("4242"), Relation: "", Target: proc("4300")}, false},
+		{Edge{Source: proc(""), Relation: RelationSpawned, Target: proc("4300")}, false},
+		{Edge{Source: proc("4242"), Relation: RelationSpawned, Target: proc("43\n00")}, false},
+		{Edge{Source: Node{Type: "Process", ID: "4242"}, Relation: RelationSpawned, Target: proc("4300")}, false},
+		{Edge{Source: proc("4242"), Relation: RelationSpawned, Target: Node{ID: "4300"}}, false},
+	} {
+		tc.edge.EvidenceUID = "ev-1"
+		err := ValidateEdge(tc.edge)
+		if tc.ok && err != nil {
+			t.Errorf("%+v: %v", tc.edge, err)
+		} else if !tc.ok && !errors.Is(err, ErrInvalidEdge) {
+			t.Errorf("%+v: err = %v, want ErrInvalidEdge", tc.edge, err)
+		}
+	}
+}
+
+func TestEmitEdge(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitEdge("process", "4242", RelationSpawned, "process", "4300", map[string]any{"cmdline": "cmd.exe /c whoami"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitEdge("file", `C:\Temp\x.exe`, "acme:signed_by", "certificate", "ab:cd", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitEdge("process", "4242", "talks_to", "host", "dc01", nil); !errors.Is(err, ErrInvalidEdge) || !strings.Contains(err.Error(), "<namespace>:<relation>") {
+		t.Errorf("err = %v, want the relation rejected", err)
+	}
+	// Lines written by scripts in other languages are checked on reading.
+	f, err := os.OpenFile(filepath.Join(dir, GraphFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"evidence_uid":"ev-1","source":{"type":"process","id":"1"},"relation":"spawned","target":{"type":"process"}}` + "\n")
+	f.Close()
+
+	edges, err := ReadGraph(dir)
+	want := []Edge{
+		{EvidenceUID: "ev-1", Source: Node{"process", "4242"}, Relation: RelationSpawned, Target: Node{"process", "4300"}, Fields: map[string]any{"cmdline": "cmd.exe /c whoami"}},
+		{EvidenceUID: "ev-1", Source: Node{"file", `C:\Temp\x.exe`}, Relation: "acme:signed_by", Target: Node{"certificate", "ab:cd"}},
+	}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("edges = %+v, want %+v", edges, want)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 4 {
+		t.Errorf("err = %v, want line 4 rejected", err)
+	}
+}
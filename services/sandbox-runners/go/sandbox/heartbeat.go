@@ -0,0 +1,48 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WriteHeartbeat records t as the latest heartbeat of the job in the file
+// path, SANDBOX_HEARTBEAT_PATH, replacing it at once so that a reader
+// never sees a partial write. The entrypoint of the runner images calls it
+// every SANDBOX_HEARTBEAT_INTERVAL while the script runs.
+func WriteHeartbeat(path string, t time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".heartbeat-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(t.UTC().Format(time.RFC3339Nano) + "\n")
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// ReadHeartbeat returns the latest heartbeat recorded in the file path,
+// the zero time when there is none yet.
+func ReadHeartbeat(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sandbox: heartbeat: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,31 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "heartbeat")
+	if got, err := ReadHeartbeat(path); !got.IsZero() || err != nil {
+		t.Errorf("ReadHeartbeat() before any = %v, %v", got, err)
+	}
+	beat := time.Date(2026, 3, 1, 10, 0, 0, 5e8, time.FixedZone("CET", 3600))
+	for _, b := range []time.Time{beat, beat.Add(time.Second)} {
+		if err := WriteHeartbeat(path, b); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadHeartbeat(path); !got.Equal(b) || got.Location() != time.UTC || err != nil {
+			t.Errorf("ReadHeartbeat() = %v, %v; want %v", got, err, b)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files left, want the heartbeat only", len(entries))
+	}
+	os.WriteFile(path, []byte("soon"), 0o644)
+	if _, err := ReadHeartbeat(path); err == nil {
+		t.Error("ReadHeartbeat() of a malformed heartbeat succeeded")
+	}
+}
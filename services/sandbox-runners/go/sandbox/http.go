@@ -0,0 +1,51 @@
+package sandbox
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPTimeout bounds a request of the HTTPClient client, the time it waits
+// in the egress proxy for its turn included.
+var HTTPTimeout = 5 * time.Minute
+
+// HTTPClient returns an HTTP client that sends every request through the
+// egress proxy of a job run with network access, named by HTTPS_PROXY and
+// HTTP_PROXY. The proxy only lets allowlisted hosts through and may hold
+// requests back to keep to the per-host rate of the job: the client waits
+// for its turn rather than fails. It opens a connection per request so
+// that the proxy sees, and counts, every HTTPS request. Without network
+// access its requests fail.
+func HTTPClient() *http.Client {
+	proxies := map[string]*url.URL{
+		"http":  proxyURL("HTTP_PROXY", "http_proxy"),
+		"https": proxyURL("HTTPS_PROXY", "https_proxy"),
+	}
+	return &http.Client{
+		Timeout: HTTPTimeout,
+		Transport: &http.Transport{
+			Proxy: func(req *http.Request) (*url.URL, error) {
+				return proxies[req.URL.Scheme], nil
+			},
+			DisableKeepAlives:   true,
+			TLSHandshakeTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// proxyURL returns the proxy named by the first of keys that is set, or
+// nil.
+func proxyURL(keys ...string) *url.URL {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			u, err := url.Parse(v)
+			if err != nil {
+				return nil
+			}
+			return u
+		}
+	}
+	return nil
+}
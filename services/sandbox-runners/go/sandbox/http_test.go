@@ -0,0 +1,29 @@
+package sandbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var seen []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.URL.String())
+		io.WriteString(w, "intel")
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("http_proxy", "")
+
+	resp, err := HTTPClient().Get("http://api.intel.example/v1/ip/10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "intel" || len(seen) != 1 || seen[0] != "http://api.intel.example/v1/ip/10.0.0.1" {
+		t.Errorf("body %q, proxy saw %q", body, seen)
+	}
+}
@@ -0,0 +1,195 @@
+package sandbox
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// IOCsFile is the name of the indicators file inside OUTPUT_DIR, merged by
+// the platform into the case's IOC index.
+const IOCsFile = "iocs.ndjson"
+
+// ErrInvalidIOC is returned for an indicator whose value is not valid for
+// its kind.
+var ErrInvalidIOC = errors.New("sandbox: invalid IOC")
+
+// IOCKind is the type of an indicator's value.
+type IOCKind string
+
+// Kinds accepted in IOC.Kind. Mutex and file path values are free-form.
+const (
+	IOCIPv4        IOCKind = "ipv4"
+	IOCIPv6        IOCKind = "ipv6"
+	IOCDomain      IOCKind = "domain"
+	IOCURL         IOCKind = "url"
+	IOCEmail       IOCKind = "email"
+	IOCMD5         IOCKind = "md5"
+	IOCSHA1        IOCKind = "sha1"
+	IOCSHA256      IOCKind = "sha256"
+	IOCMutex       IOCKind = "mutex"
+	IOCRegistryKey IOCKind = "registry_key"
+	IOCFilePath    IOCKind = "file_path"
+)
+
+// hashSizes are the hexadecimal lengths of the hash kinds.
+var hashSizes = map[IOCKind]int{IOCMD5: 32, IOCSHA1: 40, IOCSHA256: 64}
+
+// registryHives are the root keys a registry_key value starts with.
+var registryHives = []string{
+	"HKEY_LOCAL_MACHINE", "HKLM",
+	"HKEY_CURRENT_USER", "HKCU",
+	"HKEY_USERS", "HKU",
+	"HKEY_CLASSES_ROOT", "HKCR",
+	"HKEY_CURRENT_CONFIG", "HKCC",
+}
+
+// IOC is one line of iocs.ndjson: an indicator of compromise found in an
+// evidence item.
+type IOC struct {
+	// EvidenceUID defaults to EVIDENCE_UID in EmitIOC.
+	EvidenceUID string  `json:"evidence_uid"`
+	Kind        IOCKind `json:"kind"`
+	Value       string  `json:"value"`
+	// Context tells where the indicator was found, e.g. "C2 in the
+	// config of svchost.exe".
+	Context string `json:"context,omitempty"`
+}
+
+func (i IOC) validate() error {
+	if err := validateIOCValue(i.Kind, i.Value); err != nil {
+		return fmt.Errorf("%w: %s %q: %v", ErrInvalidIOC, i.Kind, i.Value, err)
+	}
+	return nil
+}
+
+func validateIOCValue(kind IOCKind, v string) error {
+	if v == "" || strings.ContainsAny(v, "\x00\r\n") {
+		return errors.New("empty or multi-line value")
+	}
+	switch kind {
+	case IOCIPv4, IOCIPv6:
+		addr, err := netip.ParseAddr(v)
+		if err != nil || addr.Zone() != "" {
+			return errors.New("not an IP address")
+		}
+		if (kind == IOCIPv4) != addr.Is4() {
+			return fmt.Errorf("not an %s address", kind)
+		}
+	case IOCDomain:
+		return validateDomain(v)
+	case IOCURL:
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("not an absolute URL")
+		}
+	case IOCEmail:
+		addr, err := mail.ParseAddress(v)
+		if err != nil || addr.Address != v || addr.Name != "" {
+			return errors.New("not an email address")
+		}
+		return validateDomain(v[strings.LastIndexByte(v, '@')+1:])
+	case IOCMD5, IOCSHA1, IOCSHA256:
+		if _, err := hex.DecodeString(v); err != nil || len(v) != hashSizes[kind] {
+			return fmt.Errorf("want %d hexadecimal digits", hashSizes[kind])
+		}
+	case IOCRegistryKey:
+		root, _, _ := strings.Cut(v, `\`)
+		for _, h := range registryHives {
+			if strings.EqualFold(root, h) {
+				return nil
+			}
+		}
+		return errors.New("does not start with a registry hive")
+	case IOCMutex, IOCFilePath:
+	default:
+		return errors.New("unknown kind")
+	}
+	return nil
+}
+
+// validateDomain checks a host name: at least two labels of letters,
+// digits, hyphens and underscores, a trailing dot allowed.
+func validateDomain(v string) error {
+	name := strings.TrimSuffix(v, ".")
+	labels := strings.Split(name, ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return errors.New("not a domain name")
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return errors.New("not a domain name")
+		}
+		for _, c := range l {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return errors.New("not a domain name")
+			}
+		}
+	}
+	return nil
+}
+
+// Key identifies the indicator across scripts in the format of
+// Result.FindingKey, e.g. "ioc/domain/evil.example". Domains, email
+// addresses, hashes and registry keys compare case-insensitively and IP
+// addresses in their canonical form.
+func (i IOC) Key() string {
+	v := i.Value
+	switch i.Kind {
+	case IOCDomain:
+		v = strings.ToLower(strings.TrimSuffix(v, "."))
+	case IOCEmail, IOCMD5, IOCSHA1, IOCSHA256, IOCRegistryKey:
+		v = strings.ToLower(v)
+	case IOCIPv4, IOCIPv6:
+		if addr, err := netip.ParseAddr(v); err == nil {
+			v = addr.String()
+		}
+	}
+	return "ioc/" + string(i.Kind) + "/" + v
+}
+
+// ValidateIOC checks ioc against its kind and the schema of iocs.ndjson,
+// which the orchestrator enforces on every line after the run. EmitIOC
+// calls it.
+func ValidateIOC(ioc IOC) error {
+	if err := ioc.validate(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(ioc)
+	if err != nil {
+		return fmt.Errorf("sandbox: invalid IOC: %w", err)
+	}
+	if err := validateRecord(IOCsFile, line); err != nil {
+		return fmt.Errorf("sandbox: invalid IOC: %w", err)
+	}
+	return nil
+}
+
+// EmitIOC appends ioc as one line of iocs.ndjson in OUTPUT_DIR, once
+// ValidateIOC accepts it. It is safe for concurrent use.
+func EmitIOC(ioc IOC) error {
+	if ioc.EvidenceUID == "" {
+		uid, err := MustGetEnv(EnvEvidenceUID)
+		if err != nil {
+			return err
+		}
+		ioc.EvidenceUID = uid
+	}
+	if err := ValidateIOC(ioc); err != nil {
+		return err
+	}
+	return appendRecord(IOCsFile, ioc)
+}
+
+// ReadIOCs parses the indicators in dir; a missing file means none.
+// Malformed lines, values invalid for their kind and indicators that do
+// not match the schema are skipped and reported in err, after the
+// indicators that could be read; RecordErrors lists them.
+func ReadIOCs(dir string) ([]IOC, error) {
+	return readRecords(dir, IOCsFile, IOC.validate)
+}
@@ -0,0 +1,87 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateIOC(t *testing.T) {
+	for _, tc := range []struct {
+		kind  IOCKind
+		value string
+		ok    bool
+	}{
+		{IOCIPv4, "203.0.113.7", true},
+		{IOCIPv4, "203.0.113.256", false},
+		{IOCIPv4, "2001:db8::1", false},
+		{IOCIPv6, "2001:db8::1", true},
+		{IOCIPv6, "fe80::1%eth0", false},
+		{IOCDomain, "Evil.example.", true},
+		{IOCDomain, "localhost", false},
+		{IOCDomain, "-bad.example", false},
+		{IOCDomain, "evil example.com", false},
+		{IOCURL, "https://evil.example/payload.bin", true},
+		{IOCURL, "/payload.bin", false},
+		{IOCEmail, "phish@evil.example", true},
+		{IOCEmail, "Phisher <phish@evil.example>", false},
+		{IOCMD5, "d41d8cd98f00b204e9800998ecf8427e", true},
+		{IOCSHA1, "d41d8cd98f00b204e9800998ecf8427e", false},
+		{IOCSHA256, "E3B0C44298FC1C149AFBF4C8996FB92427AE41E4649B934CA495991B7852B855", true},
+		{IOCSHA256, "z3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", false},
+		{IOCRegistryKey, `HKLM\Software\Microsoft\Windows\CurrentVersion\Run`, true},
+		{IOCRegistryKey, `Software\Run`, false},
+		{IOCMutex, `Global\MsWinZonesCacheCounterMutexA`, true},
+		{IOCMutex, "", false},
+		{IOCFilePath, "C:\\Users\\Public\\a.exe\nb.exe", false},
+		{"asn", "AS64496", false},
+	} {
+		err := ValidateIOC(IOC{EvidenceUID: "ev-1", Kind: tc.kind, Value: tc.value})
+		if tc.ok && err != nil {
+			t.Errorf("%s %q: %v", tc.kind, tc.value, err)
+		} else if !tc.ok && !errors.Is(err, ErrInvalidIOC) {
+			t.Errorf("%s %q: err = %v, want ErrInvalidIOC", tc.kind, tc.value, err)
+		}
+	}
+}
+
+func TestIOCKey(t *testing.T) {
+	for _, tc := range []struct {
+		ioc  IOC
+		want string
+	}{
+		{IOC{Kind: IOCDomain, Value: "Evil.Example."}, "ioc/domain/evil.example"},
+		{IOC{Kind: IOCIPv6, Value: "2001:DB8:0::1"}, "ioc/ipv6/2001:db8::1"},
+		{IOC{Kind: IOCMutex, Value: "Global\\Mtx"}, "ioc/mutex/Global\\Mtx"},
+	} {
+		if got := tc.ioc.Key(); got != tc.want {
+			t.Errorf("Key(%+v) = %q, want %q", tc.ioc, got, tc.want)
+		}
+	}
+}
+
+func TestEmitIOC(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitIOC(IOC{Kind: IOCDomain, Value: "evil.example", Context: "C2 in config"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitIOC(IOC{Kind: IOCIPv4, Value: "10.0.0"}); !errors.Is(err, ErrInvalidIOC) {
+		t.Errorf("err = %v, want ErrInvalidIOC", err)
+	}
+	// Lines written by scripts in other languages are checked on reading.
+	f, err := os.OpenFile(filepath.Join(dir, IOCsFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"evidence_uid":"ev-1","kind":"sha1","value":"abc"}` + "\n")
+	f.Close()
+
+	iocs, err := ReadIOCs(dir)
+	if len(iocs) != 1 || iocs[0] != (IOC{EvidenceUID: "ev-1", Kind: IOCDomain, Value: "evil.example", Context: "C2 in config"}) {
+		t.Errorf("iocs = %+v", iocs)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 3 || !errors.Is(recs[0], ErrInvalidIOC) {
+		t.Errorf("err = %v, want line 3 rejected", err)
+	}
+}
@@ -0,0 +1,64 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JobStatusFile is the name of the file inside OUTPUT_DIR in which the
+// entrypoint of the runner images records how the script ended.
+const JobStatusFile = "job-status.json"
+
+// ContractExitCode is the exit status of the entrypoint when the container
+// does not meet the environment contract; the script is not run.
+const ContractExitCode = 125
+
+// JobStatus is the content of JobStatusFile.
+type JobStatus struct {
+	// Command is the script command the entrypoint ran.
+	Command  []string  `json:"command"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// ExitCode is the script's exit status, 128 plus the signal number
+	// when a signal killed it, as a shell reports it.
+	ExitCode int `json:"exit_code"`
+	// Signal is the signal that killed the script, e.g. "SIGKILL".
+	Signal string `json:"signal,omitempty"`
+	// Terminated reports that the entrypoint received SIGTERM or SIGINT
+	// and passed it on to the script.
+	Terminated bool `json:"terminated,omitempty"`
+	// ContractError says why the container did not meet the environment
+	// contract, in which case the script was not run and ExitCode is
+	// ContractExitCode.
+	ContractError string `json:"contract_error,omitempty"`
+}
+
+// WriteJobStatus records status in JobStatusFile of dir.
+func WriteJobStatus(dir string, status JobStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return WriteOutput(filepath.Join(dir, JobStatusFile), append(data, '\n'))
+}
+
+// ReadJobStatus returns what the entrypoint recorded in dir, nil when the
+// job did not run through it.
+func ReadJobStatus(dir string) (*JobStatus, error) {
+	data, err := os.ReadFile(filepath.Join(dir, JobStatusFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status JobStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("sandbox: %s: %w", JobStatusFile, err)
+	}
+	return &status, nil
+}
@@ -0,0 +1,36 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ResourceLimits are the resource limits of the sandbox container, for
+// scripts that size their buffers or worker count to them. A zero field
+// means no limit was set.
+type ResourceLimits struct {
+	MemoryBytes int64
+	// CPUs is the CPU quota, possibly fractional, e.g. 1.5.
+	CPUs float64
+}
+
+// Limits reads SANDBOX_MEMORY_LIMIT_BYTES and SANDBOX_CPU_COUNT.
+func Limits() (ResourceLimits, error) {
+	var l ResourceLimits
+	if v := os.Getenv(EnvMemoryLimitBytes); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return ResourceLimits{}, fmt.Errorf("sandbox: invalid %s %q", EnvMemoryLimitBytes, v)
+		}
+		l.MemoryBytes = n
+	}
+	if v := os.Getenv(EnvCPUCount); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(n >= 0) {
+			return ResourceLimits{}, fmt.Errorf("sandbox: invalid %s %q", EnvCPUCount, v)
+		}
+		l.CPUs = n
+	}
+	return l, nil
+}
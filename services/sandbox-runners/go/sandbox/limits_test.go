@@ -0,0 +1,28 @@
+package sandbox
+
+import "testing"
+
+func TestLimits(t *testing.T) {
+	t.Setenv(EnvMemoryLimitBytes, "")
+	t.Setenv(EnvCPUCount, "")
+	l, err := Limits()
+	if err != nil || l != (ResourceLimits{}) {
+		t.Errorf("Limits() without limits = %+v, %v", l, err)
+	}
+
+	t.Setenv(EnvMemoryLimitBytes, "1073741824")
+	t.Setenv(EnvCPUCount, "1.5")
+	l, err = Limits()
+	if err != nil || l != (ResourceLimits{MemoryBytes: 1 << 30, CPUs: 1.5}) {
+		t.Errorf("Limits() = %+v, %v", l, err)
+	}
+
+	for key, bad := range map[string]string{EnvMemoryLimitBytes: "1g", EnvCPUCount: "-1"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, bad)
+			if _, err := Limits(); err == nil {
+				t.Errorf("%s=%s should fail", key, bad)
+			}
+		})
+	}
+}
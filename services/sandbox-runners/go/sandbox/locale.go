@@ -0,0 +1,59 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// CaseLocation returns the time zone of the case, CASE_TIMEZONE, in which
+// scripts render the times they display, e.g. t.In(loc) in a report, so
+// that every script of a case shows the same wall clock. Stored times,
+// those of findings, artifacts and timeline events, remain UTC.
+//
+// It returns UTC when the variable is unset, and UTC with an error when
+// it does not name an IANA zone, for the script to warn about.
+func CaseLocation() (*time.Location, error) {
+	name := os.Getenv(EnvCaseTimezone)
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := LoadTimezone(name)
+	if err != nil {
+		return time.UTC, err
+	}
+	return loc, nil
+}
+
+// LoadTimezone returns the IANA zone name, e.g. "Europe/Paris". "Local",
+// the zone of whichever machine reads it, is not a case timezone.
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("sandbox: invalid case timezone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: invalid case timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// localeTag matches a BCP 47 language tag, e.g. "fr", "fr-FR" or
+// "zh-Hant-TW", loosely: a language then subtags.
+var localeTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// ValidLocale reports whether tag is a BCP 47 language tag.
+func ValidLocale(tag string) bool {
+	return localeTag.MatchString(tag)
+}
+
+// CaseLocale returns the locale of the case, CASE_LOCALE, e.g. "fr-FR", in
+// which scripts format the dates and numbers they display; empty when
+// unset or not a BCP 47 language tag.
+func CaseLocale() string {
+	if tag := os.Getenv(EnvCaseLocale); ValidLocale(tag) {
+		return tag
+	}
+	return ""
+}
@@ -0,0 +1,37 @@
+package sandbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaseLocation(t *testing.T) {
+	t.Setenv(EnvCaseTimezone, "")
+	if loc, err := CaseLocation(); loc != time.UTC || err != nil {
+		t.Errorf("CaseLocation() unset = %v, %v; want UTC", loc, err)
+	}
+	t.Setenv(EnvCaseTimezone, "Europe/Paris")
+	loc, err := CaseLocation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	if got := event.In(loc).Format("15:04 MST"); got != "10:00 CET" {
+		t.Errorf("event in %v = %s, want 10:00 CET", loc, got)
+	}
+	for _, v := range []string{"Mars/Olympus_Mons", "Local"} {
+		t.Setenv(EnvCaseTimezone, v)
+		if loc, err := CaseLocation(); loc != time.UTC || err == nil {
+			t.Errorf("CaseLocation() with %q = %v, %v; want UTC and an error", v, loc, err)
+		}
+	}
+}
+
+func TestCaseLocale(t *testing.T) {
+	for tag, want := range map[string]string{"fr-FR": "fr-FR", "zh-Hant-TW": "zh-Hant-TW", "": "", "fr_FR.UTF-8": "", "français": ""} {
+		t.Setenv(EnvCaseLocale, tag)
+		if got := CaseLocale(); got != want {
+			t.Errorf("CaseLocale() with %q = %q, want %q", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// appendMu serialises appends so that records written from concurrent
+// goroutines never interleave within a line.
+var appendMu sync.Mutex
+
+// ErrTruncatedRecord is reported for the last line of an NDJSON file when
+// it was cut short, its writer interrupted mid-write, e.g. killed on
+// timeout. The partial record is not read.
+var ErrTruncatedRecord = errors.New("sandbox: record cut short by an interrupted write")
+
+// MaxRecordSize bounds a line of an NDJSON output file, its newline
+// included.
+const MaxRecordSize = 1 << 20
+
+// ErrRecordTooLarge is returned by the emit helpers for a record whose
+// line would exceed MaxRecordSize, and reported by the readers for such a
+// line, which they skip.
+var ErrRecordTooLarge = errors.New("sandbox: record larger than MaxRecordSize")
+
+// recordScanner reads the lines of an NDJSON file as a bufio.Scanner with
+// bufio.ScanLines does, except that a line longer than MaxRecordSize is
+// skipped, read as an empty line with oversized set, rather than ending
+// the scan.
+type recordScanner struct {
+	*bufio.Scanner
+	// truncated reports that the line just read is the last one and
+	// lacks its newline, oversized that it was skipped.
+	truncated, oversized bool
+	skipping             bool
+}
+
+func newRecordScanner(r io.Reader) *recordScanner {
+	s := &recordScanner{Scanner: bufio.NewScanner(r)}
+	s.Buffer(nil, MaxRecordSize)
+	s.Split(s.split)
+	return s
+}
+
+func (s *recordScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	s.truncated, s.oversized = false, false
+	if s.skipping {
+		i := bytes.IndexByte(data, '\n')
+		switch {
+		case i >= 0:
+			s.skipping, s.oversized = false, true
+			return i + 1, []byte{}, nil
+		case atEOF:
+			s.skipping, s.oversized, s.truncated = false, true, true
+			return len(data), []byte{}, nil
+		}
+		return len(data), nil, nil
+	}
+	if !atEOF && len(data) >= MaxRecordSize && bytes.IndexByte(data, '\n') < 0 {
+		s.skipping = true
+		return len(data), nil, nil
+	}
+	advance, line, err := bufio.ScanLines(data, atEOF)
+	s.truncated = atEOF && line != nil && bytes.IndexByte(data[:advance], '\n') < 0
+	return advance, line, err
+}
+
+// prepareAppend returns lines, whole lines to append to f, preceded by the
+// header line when f is empty, and by a newline when f ends with a line cut
+// short by an interrupted writer: the partial line is then rejected alone
+// instead of corrupting the first record appended after it. The caller
+// holds appendMu; f is open for reading and appending.
+func prepareAppend(f *os.File, lines []byte) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return append(append([]byte(nil), headerLine...), lines...), nil
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return nil, err
+	}
+	if last[0] != '\n' {
+		return append([]byte{'\n'}, lines...), nil
+	}
+	return lines, nil
+}
+
+// appendRecord marshals v as a single JSON line and appends it to the named
+// file inside OUTPUT_DIR, creating the file, with its header line, if
+// needed. The line is written whole in one write, so that a reader never
+// sees part of it unless the script dies during the write.
+func appendRecord(name string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %s record: %w", name, err)
+	}
+	line = append(line, '\n')
+	if len(line) > MaxRecordSize {
+		return fmt.Errorf("%w: %s record of %d bytes", ErrRecordTooLarge, name, len(line))
+	}
+
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	if err := reserveRecord(dir, name); err != nil {
+		return err
+	}
+
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	f, err := openOutput(filepath.Join(dir, name), os.O_RDWR|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	if line, err = prepareAppend(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	// A single write on an O_APPEND descriptor keeps the line contiguous.
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readRecords parses the named NDJSON file in dir; a missing file has no
+// records. Records written with an earlier output version are migrated
+// to OutputVersion; those after a header of a later version are not read
+// and err wraps ErrUnsupportedOutputVersion. Lines that do not parse,
+// that valid rejects or that do not match the file's schema are skipped
+// and reported in err as *RecordError, after the records that could be
+// read; a last line without its newline that does not parse is reported
+// with ErrTruncatedRecord.
+func readRecords[T any](dir, name string, valid func(T) error) ([]T, error) {
+	records, _, err := readRecordsUpTo(dir, name, valid, 0)
+	return records, err
+}
+
+// readRecordsUpTo parses the named NDJSON file in dir as readRecords does,
+// stopping once it has read limit records, when limit is positive. total
+// counts the record lines of the file, those not read or rejected
+// included: the file is read a line at a time, and the lines past limit
+// are only counted, not decoded.
+func readRecordsUpTo[T any](dir, name string, valid func(T) error, limit int) (_ []T, total int, _ error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var records []T
+	var errs []error
+	version := 1
+	sc := newRecordScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		// A last line without its newline may have been cut short.
+		truncated := sc.truncated
+		if sc.oversized {
+			total++
+			if limit <= 0 || len(records) < limit {
+				errs = append(errs, &RecordError{File: name, Line: n, Err: ErrRecordTooLarge})
+			}
+			continue
+		}
+		raw := bytes.TrimSpace(sc.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		if sdk, ok := ParseHeader(raw); ok {
+			v, err := outputVersion(sdk)
+			if err != nil {
+				errs = append(errs, &RecordError{File: name, Line: n, Record: string(raw), Err: err})
+				continue
+			}
+			if v > OutputVersion {
+				errs = append(errs, fmt.Errorf("%w: %s was written by SDK %s (output version %d), this SDK %s reads up to version %d",
+					ErrUnsupportedOutputVersion, name, sdk, v, sdkVersion, OutputVersion))
+				break
+			}
+			version = v
+			continue
+		}
+		total++
+		if limit > 0 && len(records) >= limit {
+			continue
+		}
+		line, err := migrateRecord(name, version, raw)
+		var rec T
+		if err == nil {
+			if err = json.Unmarshal(line, &rec); err != nil && truncated {
+				err = fmt.Errorf("%w: %v", ErrTruncatedRecord, err)
+			}
+		}
+		if err == nil && valid != nil {
+			err = valid(rec)
+		}
+		if err == nil {
+			err = validateRecord(name, line)
+		}
+		if err != nil {
+			errs = append(errs, &RecordError{File: name, Line: n, Record: string(raw), Err: err})
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return records, total, fmt.Errorf("sandbox: parse %s: %w", name, errors.Join(errs...))
+	}
+	return records, total, nil
+}
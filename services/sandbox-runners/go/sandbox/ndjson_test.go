@@ -0,0 +1,91 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRecordCutShortByCrash simulates a script killed in the middle of
+// writing a record, then a later writer appending to the same file.
+func TestRecordCutShortByCrash(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "complete"}); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "cut short"})
+	f, err := os.OpenFile(filepath.Join(dir, ResultsFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(line[:len(line)/2])
+	f.Close()
+
+	results, err := ReadResults(dir)
+	if len(results) != 1 || results[0].Title != "complete" {
+		t.Errorf("results = %+v, want only the complete record", results)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 3 || !errors.Is(recs[0], ErrTruncatedRecord) {
+		t.Errorf("err = %v, want line 3 reported as truncated", err)
+	}
+
+	// The next record starts on a line of its own.
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = ReadResults(dir)
+	if len(results) != 2 || results[1].Title != "after" {
+		t.Errorf("results = %+v, want the record appended after the partial line", results)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 3 {
+		t.Errorf("err = %v, want only the partial line rejected", err)
+	}
+}
+
+func TestUnterminatedLastRecordIsRead(t *testing.T) {
+	dir := t.TempDir()
+	// A script in another language may omit the last newline.
+	os.WriteFile(filepath.Join(dir, WarningsFile), []byte(`{"code":"mft.truncated_record","message":"record 12 cut short"}`), 0o644)
+	if warnings, err := ReadWarnings(dir); err != nil || len(warnings) != 1 {
+		t.Errorf("ReadWarnings = %+v, %v", warnings, err)
+	}
+}
+
+func TestEmitResultTooLarge(t *testing.T) {
+	dir := setupEnv(t)
+	big := Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "big", Description: strings.Repeat("x", 2*MaxRecordSize)}
+	if err := EmitResult(big); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("EmitResult() = %v, want ErrRecordTooLarge", err)
+	}
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "small"}); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := ReadResults(dir); err != nil || len(results) != 1 || results[0].Title != "small" {
+		t.Errorf("results = %+v, %v, want only the small one", results, err)
+	}
+}
+
+func TestReadSkipsOversizedRecord(t *testing.T) {
+	dir := t.TempDir()
+	small, _ := json.Marshal(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "small"})
+	big, _ := json.Marshal(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "big", Description: strings.Repeat("x", 2*MaxRecordSize)})
+	// Written by a script in another language, which the SDK does not bound.
+	data := bytes.Join([][]byte{headerLine[:len(headerLine)-1], big, small, big}, []byte("\n"))
+	os.WriteFile(filepath.Join(dir, ResultsFile), data, 0o644)
+
+	results, total, err := ReadResultsUpTo(dir, nil, 0)
+	if len(results) != 1 || results[0].Title != "small" || total != 3 {
+		t.Errorf("%d results of %d: %+v, want the small one of 3", len(results), total, results)
+	}
+	recs := RecordErrors(err)
+	if len(recs) != 2 || recs[0].Line != 2 || recs[1].Line != 4 || !errors.Is(recs[0], ErrRecordTooLarge) || !errors.Is(recs[1], ErrRecordTooLarge) {
+		t.Errorf("err = %v, want lines 2 and 4 rejected as too large", err)
+	}
+	if n := countRecords(filepath.Join(dir, ResultsFile)); n != 3 {
+		t.Errorf("countRecords() = %d, want 3", n)
+	}
+}
@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// NotApplicableFile is the name of the file inside OUTPUT_DIR in which
+// SkipNotApplicable records why the script did not examine its evidence.
+const NotApplicableFile = "not_applicable.json"
+
+// NotApplicableExitCode is the exit status of a script stopped by
+// SkipNotApplicable, apart from the 1 of a failure, the 2 of a panic and
+// PanicExitCode. `go run` reports it as 1: the orchestrator goes by
+// NotApplicableFile.
+const NotApplicableExitCode = 3
+
+// NotApplicable is the content of NotApplicableFile.
+type NotApplicable struct {
+	// EvidenceUID is EVIDENCE_UID when it is set.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	// Reason says why the evidence is not for this script, e.g. "not an
+	// NTFS volume".
+	Reason string `json:"reason"`
+}
+
+// SkipNotApplicable stops a script whose evidence is not the kind it
+// analyses, e.g. a registry parser handed a PCAP, so that the orchestrator
+// records the job as skipped for reason rather than as a success without
+// findings or a failure:
+//
+//	if !bytes.HasPrefix(header, []byte("regf")) {
+//		sandbox.SkipNotApplicable("not a registry hive")
+//	}
+//
+// It records reason in NotApplicableFile and on stderr, runs the
+// OnShutdown handlers and exits with NotApplicableExitCode. It does not
+// return.
+func SkipNotApplicable(reason string) {
+	rec := NotApplicable{EvidenceUID: os.Getenv(EnvEvidenceUID), Reason: reason}
+	fmt.Fprintf(os.Stderr, "sandbox: not applicable: %s\n", reason)
+	if err := writeNotApplicable(rec); err != nil {
+		log.Printf("sandbox: record not applicable: %v", err)
+	}
+	shutdown()
+	exit(NotApplicableExitCode)
+}
+
+func writeNotApplicable(rec NotApplicable) error {
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return WriteOutput(filepath.Join(dir, NotApplicableFile), append(data, '\n'))
+}
+
+// ReadNotApplicable returns what SkipNotApplicable recorded in dir, nil
+// when the script did not call it.
+func ReadNotApplicable(dir string) (*NotApplicable, error) {
+	data, err := os.ReadFile(filepath.Join(dir, NotApplicableFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec NotApplicable
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("sandbox: %s: %w", NotApplicableFile, err)
+	}
+	return &rec, nil
+}
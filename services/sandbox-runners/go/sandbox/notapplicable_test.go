@@ -0,0 +1,39 @@
+package sandbox
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSkipNotApplicable(t *testing.T) {
+	dir := setupEnv(t)
+	if rec, err := ReadNotApplicable(dir); rec != nil || err != nil {
+		t.Errorf("ReadNotApplicable() before the skip = %+v, %v", rec, err)
+	}
+	var code int
+	exit = func(c int) { code = c }
+	shutdownMu.Lock()
+	handlers := shutdownHandlers
+	shutdownHandlers = nil
+	shutdownMu.Unlock()
+	t.Cleanup(func() {
+		exit = os.Exit
+		shutdownMu.Lock()
+		shutdownHandlers = handlers
+		shutdownMu.Unlock()
+	})
+	flushed := false
+	OnShutdown(func() { flushed = true })
+
+	SkipNotApplicable("not a registry hive")
+	if code != NotApplicableExitCode || !flushed {
+		t.Errorf("exit code = %d, shutdown handlers run %v", code, flushed)
+	}
+	rec, err := ReadNotApplicable(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil || rec.Reason != "not a registry hive" || rec.EvidenceUID != "ev-1" {
+		t.Errorf("ReadNotApplicable() = %+v", rec)
+	}
+}
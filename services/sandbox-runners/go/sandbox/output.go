@@ -0,0 +1,150 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoOutputDir is returned by the output helpers when OUTPUT_DIR is
+// unset, and by Init when it is not a writable directory. A script that
+// cannot write its outputs must fail rather than end with an empty job.
+var ErrNoOutputDir = errors.New("sandbox: no usable OUTPUT_DIR")
+
+// ErrPathEscape is returned by SafeJoin for a path that leads out of its
+// base directory.
+var ErrPathEscape = errors.New("sandbox: path escapes its base directory")
+
+// outputDir returns OUTPUT_DIR.
+func outputDir() (string, error) {
+	dir := os.Getenv(EnvOutputDir)
+	if dir == "" {
+		return "", fmt.Errorf("%w: %s is not set", ErrNoOutputDir, EnvOutputDir)
+	}
+	return dir, nil
+}
+
+// Init checks that OUTPUT_DIR is an existing directory the script can
+// write to. Call it first in main, before any analysis runs.
+func Init() error {
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoOutputDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrNoOutputDir, dir)
+	}
+	f, err := os.CreateTemp(dir, ".datamortem-init-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoOutputDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// SafeJoin joins rel, a path taken from the evidence or the parameters, to
+// base, usually OUTPUT_DIR, as filepath.Join does, and returns ErrPathEscape
+// when the result is not under base: rel is absolute, climbs out with
+// "..", or goes through a symlink that points outside base. Use it instead
+// of filepath.Join for every file a script writes:
+//
+//	path, err := sandbox.SafeJoin(outputDir, name)
+func SafeJoin(base, rel string) (string, error) {
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", fmt.Errorf("%w: %s is absolute", ErrPathEscape, rel)
+	}
+	base = filepath.Clean(base)
+	full := filepath.Join(base, rel)
+	if !within(base, full) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, rel)
+	}
+	// The part of full that exists may go through symlinks: resolve it and
+	// compare with base resolved the same way.
+	root, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return full, nil
+	}
+	existing := full
+	for existing != base {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", fmt.Errorf("sandbox: %s: %w", rel, err)
+	}
+	if !within(root, resolved) {
+		return "", fmt.Errorf("%w: %s resolves to %s", ErrPathEscape, rel, resolved)
+	}
+	return full, nil
+}
+
+// OutputName returns name, a path relative to OUTPUT_DIR, with its file
+// name prefixed by the UID of the evidence item it was produced from, e.g.
+// "reports/ev-42_summary.html" for "reports/summary.html": the outputs of
+// the jobs of a fan-out, or of one job for several items, then never
+// collide once copied to shared storage. Characters of the UID other than
+// letters, digits, '.', '-' and '_' are replaced by '_'. A name that
+// already carries the prefix is returned as is.
+func OutputName(evidenceUID, name string) string {
+	prefix := outputPrefix(evidenceUID)
+	dir, file := path.Split(filepath.ToSlash(name))
+	if strings.HasPrefix(file, prefix) {
+		return filepath.FromSlash(dir + file)
+	}
+	return filepath.FromSlash(dir + prefix + file)
+}
+
+// outputPrefix is the prefix of the file names of OutputName.
+func outputPrefix(evidenceUID string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, evidenceUID) + "_"
+}
+
+// OutputPath returns the path in OUTPUT_DIR for an output file called
+// name, namespaced by OutputName with EVIDENCE_UID and checked by
+// SafeJoin, e.g.
+//
+//	path, err := sandbox.OutputPath("strings.txt") // /output/ev-42_strings.txt
+//
+// The orchestrator associates the file with that evidence item on
+// ingestion. Directories in name are not created.
+func OutputPath(name string) (string, error) {
+	uid, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return "", err
+	}
+	return OutputPathFor(uid, name)
+}
+
+// OutputPathFor is OutputPath for the evidence item evidenceUID, one of
+// several the job correlates.
+func OutputPathFor(evidenceUID, name string) (string, error) {
+	if evidenceUID == "" {
+		return "", errors.New("sandbox: output path: evidence UID is required")
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return "", err
+	}
+	return SafeJoin(dir, OutputName(evidenceUID, name))
+}
+
+// within reports whether path is dir or lies under it; both are clean.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
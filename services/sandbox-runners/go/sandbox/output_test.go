@@ -0,0 +1,92 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInit(t *testing.T) {
+	dir := setupEnv(t)
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Init left %d files in OUTPUT_DIR", len(entries))
+	}
+
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+	for _, bad := range []string{"", filepath.Join(dir, "missing"), file} {
+		t.Setenv(EnvOutputDir, bad)
+		if err := Init(); !errors.Is(err, ErrNoOutputDir) {
+			t.Errorf("Init with OUTPUT_DIR=%q: err = %v, want ErrNoOutputDir", bad, err)
+		}
+	}
+}
+
+func TestOutputHelpersWithoutOutputDir(t *testing.T) {
+	setupEnv(t)
+	t.Setenv(EnvOutputDir, "")
+	errs := map[string]error{
+		"EmitResult":        EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityInfo, Title: "x"}),
+		"RegisterArtifact":  RegisterArtifact("report.html", ArtifactReport, ""),
+		"EmitTimelineEvent": EmitTimelineEvent(time.Now(), "test", "x", nil),
+	}
+	for name, err := range errs {
+		if !errors.Is(err, ErrNoOutputDir) {
+			t.Errorf("%s: err = %v, want ErrNoOutputDir", name, err)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	for _, tc := range []struct{ uid, name, want string }{
+		{"ev-42", "strings.txt", "ev-42_strings.txt"},
+		{"ev-42", "reports/summary.html", "reports/ev-42_summary.html"},
+		{"ev-42", "ev-42_strings.txt", "ev-42_strings.txt"},
+		{"case/1:ev 2", "a.txt", "case_1_ev_2_a.txt"},
+	} {
+		if got := OutputName(tc.uid, tc.name); got != filepath.FromSlash(tc.want) {
+			t.Errorf("OutputName(%q, %q) = %q, want %q", tc.uid, tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	dir := setupEnv(t)
+	p, err := OutputPath("strings.txt")
+	if err != nil || p != filepath.Join(dir, "ev-1_strings.txt") {
+		t.Errorf("OutputPath = %q, %v", p, err)
+	}
+	if p, err := OutputPathFor("ev-2", "carved/a.bin"); err != nil || p != filepath.Join(dir, "carved", "ev-2_a.bin") {
+		t.Errorf("OutputPathFor = %q, %v", p, err)
+	}
+	if _, err := OutputPath("../escape.txt"); !errors.Is(err, ErrPathEscape) {
+		t.Errorf("err = %v, want ErrPathEscape", err)
+	}
+	if _, err := OutputPathFor("", "a.txt"); err == nil {
+		t.Error("empty evidence UID accepted")
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "reports"), 0o755)
+	os.Symlink("/etc", filepath.Join(dir, "etc"))
+	os.Symlink("reports", filepath.Join(dir, "inside"))
+
+	for _, rel := range []string{"out.txt", "reports/a/b.txt", "reports/../out.txt", "inside/out.txt", "."} {
+		got, err := SafeJoin(dir, rel)
+		if err != nil || got != filepath.Join(dir, rel) {
+			t.Errorf("SafeJoin(%q) = %q, %v", rel, got, err)
+		}
+	}
+	for _, rel := range []string{"../out.txt", "reports/../../out.txt", "/etc/passwd", "etc/passwd", "etc"} {
+		if got, err := SafeJoin(dir, rel); !errors.Is(err, ErrPathEscape) {
+			t.Errorf("SafeJoin(%q) = %q, %v, want ErrPathEscape", rel, got, err)
+		}
+	}
+}
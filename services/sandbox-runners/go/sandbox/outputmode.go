@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultOutputMode is the mode of the files the SDK creates in OUTPUT_DIR
+// when SANDBOX_OUTPUT_MODE is unset.
+const DefaultOutputMode os.FileMode = 0o644
+
+// ErrInvalidOutputMode is returned by ParseOutputMode for a mode that is
+// not octal permission bits, is world-writable, or that the owner cannot
+// read and write.
+var ErrInvalidOutputMode = errors.New("sandbox: invalid output mode")
+
+// ParseOutputMode parses an output mode in octal, e.g. "0640" or "640".
+func ParseOutputMode(s string) (os.FileMode, error) {
+	v, err := strconv.ParseUint(s, 8, 32)
+	switch {
+	case err != nil || v&^0o777 != 0:
+		return 0, fmt.Errorf("%w: %q is not octal permission bits", ErrInvalidOutputMode, s)
+	case v&0o002 != 0:
+		return 0, fmt.Errorf("%w: %s is world-writable", ErrInvalidOutputMode, s)
+	case v&0o600 != 0o600:
+		// The SDK appends to its files, and the orchestrator reads them.
+		return 0, fmt.Errorf("%w: %s is not readable and writable by its owner", ErrInvalidOutputMode, s)
+	}
+	return os.FileMode(v), nil
+}
+
+// OutputMode returns the mode of SANDBOX_OUTPUT_MODE, which the emit
+// helpers give the files they create in OUTPUT_DIR, or DefaultOutputMode
+// when it is unset or invalid.
+func OutputMode() os.FileMode {
+	s := os.Getenv(EnvOutputMode)
+	if s == "" {
+		return DefaultOutputMode
+	}
+	mode, err := ParseOutputMode(s)
+	if err != nil {
+		return DefaultOutputMode
+	}
+	return mode
+}
+
+// outputDirMode is the mode of a directory holding files of mode: those
+// who may read the files may list it.
+func outputDirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0o444)>>2
+}
+
+// openOutput opens the file at path with flag, creating it if flag says so,
+// and sets its mode to OutputMode: the umask would otherwise clear bits of
+// a mode such as 0660.
+func openOutput(path string, flag int) (*os.File, error) {
+	mode := OutputMode()
+	f, err := os.OpenFile(path, flag, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteOutput writes data to the file at path, such as returned by
+// OutputPath, with the mode of OutputMode, replacing the file if it exists.
+func WriteOutput(path string, data []byte) error {
+	f, err := openOutput(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputMode(t *testing.T) {
+	for s, want := range map[string]os.FileMode{"0640": 0o640, "600": 0o600, "0o644": 0, "0664": 0o664} {
+		got, err := ParseOutputMode(s)
+		if want == 0 {
+			if !errors.Is(err, ErrInvalidOutputMode) {
+				t.Errorf("ParseOutputMode(%q) = %v, want ErrInvalidOutputMode", s, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("ParseOutputMode(%q) = %o, %v, want %o", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "rw-r--r--", "0646", "0777", "01644", "0440", "0200"} {
+		if _, err := ParseOutputMode(s); !errors.Is(err, ErrInvalidOutputMode) {
+			t.Errorf("ParseOutputMode(%q) = %v, want ErrInvalidOutputMode", s, err)
+		}
+	}
+}
+
+func TestOutputMode(t *testing.T) {
+	for v, want := range map[string]os.FileMode{"": DefaultOutputMode, "0640": 0o640, "0666": DefaultOutputMode} {
+		t.Setenv(EnvOutputMode, v)
+		if got := OutputMode(); got != want {
+			t.Errorf("OutputMode() with %s=%q = %o, want %o", EnvOutputMode, v, got, want)
+		}
+	}
+}
+
+func TestOutputFilesHonorOutputMode(t *testing.T) {
+	dir := setupEnv(t)
+	// The usual umask of 022 must not clear the group write bit.
+	t.Setenv(EnvOutputMode, "0660")
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: "info", Title: "finding"}); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.txt")
+	if err := WriteOutput(report, []byte("report\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterArtifact(report, ArtifactReport, "report"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractFile("carved.bin", strings.NewReader("carved")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{
+		ResultsFile:  0o660,
+		"report.txt": 0o660,
+		ManifestFile: 0o660,
+		ExtractedDir: 0o770 | os.ModeDir,
+		filepath.Join(ExtractedDir, "carved.bin"): 0o660,
+	} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode() & (os.ModePerm | os.ModeDir); got != want {
+			t.Errorf("%s has mode %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package sandbox
+
+import (
+	"os"
+	"strings"
+)
+
+// ReservedParamPrefixes are the variable name prefixes that job parameters
+// may not use: they belong to the contract and to the Go toolchain.
+var ReservedParamPrefixes = []string{"CASE_", "EVIDENCE_", "OUTPUT_", "GO"}
+
+// IsReservedParam reports whether name starts with a reserved prefix,
+// ignoring case.
+func IsReservedParam(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range ReservedParamPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetParam returns the job parameter name, e.g. "PARAM_YARA_RULES", and
+// whether the job was given it. Reserved names are never parameters.
+func GetParam(name string) (string, bool) {
+	if IsReservedParam(name) {
+		return "", false
+	}
+	return os.LookupEnv(name)
+}
@@ -0,0 +1,34 @@
+package sandbox
+
+import "testing"
+
+func TestGetParam(t *testing.T) {
+	t.Setenv("PARAM_YARA_RULES", "/workspace/rules.yar")
+	t.Setenv(EnvCaseID, "case-1")
+
+	if v, ok := GetParam("PARAM_YARA_RULES"); !ok || v != "/workspace/rules.yar" {
+		t.Errorf("GetParam(PARAM_YARA_RULES) = %q, %v", v, ok)
+	}
+	if _, ok := GetParam("PARAM_MISSING"); ok {
+		t.Error("GetParam reported an unset parameter")
+	}
+	if _, ok := GetParam(EnvCaseID); ok {
+		t.Error("GetParam returned a reserved variable")
+	}
+}
+
+func TestIsReservedParam(t *testing.T) {
+	for name, want := range map[string]bool{
+		"CASE_ID":      true,
+		"evidence_uid": true,
+		"OUTPUT_DIR":   true,
+		"GOFLAGS":      true,
+		"GOPROXY":      true,
+		"PARAM_FROM":   false,
+		"YARA_RULES":   false,
+	} {
+		if got := IsReservedParam(name); got != want {
+			t.Errorf("IsReservedParam(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package sandbox
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ProgressFile is the name of the progress file inside OUTPUT_DIR, tailed
+// by the orchestrator while the script runs.
+const ProgressFile = "progress.ndjson"
+
+// ProgressRecord is one line of progress.ndjson.
+type ProgressRecord struct {
+	// Time is the logical start time of the job, see Now, so that the
+	// file of a replay matches; the watchdog of the orchestrator tracks
+	// when lines are added instead.
+	Time     time.Time `json:"time"`
+	Fraction float64   `json:"fraction"`
+	Message  string    `json:"message,omitempty"`
+}
+
+var (
+	progressMu   sync.Mutex
+	lastProgress = -1.0
+)
+
+// Progress reports how far the script has got, as a fraction in [0,1].
+// Values outside that range are clamped; a value lower than the previous
+// one is recorded anyway but logged as a warning.
+func Progress(fraction float64, message string) error {
+	switch {
+	case !(fraction >= 0): // also catches NaN
+		fraction = 0
+	case fraction > 1:
+		fraction = 1
+	}
+
+	progressMu.Lock()
+	if fraction < lastProgress {
+		log.Printf("sandbox: progress went backwards from %.3f to %.3f", lastProgress, fraction)
+	}
+	lastProgress = fraction
+	progressMu.Unlock()
+
+	return appendRecord(ProgressFile, ProgressRecord{
+		Time:     Now(),
+		Fraction: fraction,
+		Message:  message,
+	})
+}
@@ -0,0 +1,47 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProgressClampsAndWarns(t *testing.T) {
+	dir := setupEnv(t)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	lastProgress = -1
+
+	for _, f := range []float64{-0.5, 0.5, 0.25, 2} {
+		if err := Progress(f, "step"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []float64
+	for _, line := range readLines(t, filepath.Join(dir, ProgressFile)) {
+		var rec ProgressRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec.Fraction)
+	}
+	want := []float64{0, 0.5, 0.25, 1}
+	if len(got) != len(want) {
+		t.Fatalf("fractions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("fractions = %v, want %v", got, want)
+			break
+		}
+	}
+	if !strings.Contains(logs.String(), "backwards") {
+		t.Errorf("expected a warning for decreasing progress, got %q", logs.String())
+	}
+}
@@ -0,0 +1,109 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrFindingQueryUnavailable is returned by QueryFindings when the job was
+// not allowed to query the findings of its case.
+var ErrFindingQueryUnavailable = errors.New("sandbox: finding queries are not enabled for this job")
+
+// ErrFindingQueryDenied is returned by QueryFindings when the orchestrator
+// refuses the query, e.g. for the findings of another case.
+var ErrFindingQueryDenied = errors.New("sandbox: finding query denied")
+
+// MaxFindingPage is the largest number of findings a QueryFindings call
+// returns, whatever FindingFilter.Limit asks for.
+const MaxFindingPage = 100
+
+// QueryTimeout bounds a QueryFindings request.
+var QueryTimeout = time.Minute
+
+// FindingFilter selects the findings QueryFindings returns; its zero value
+// selects every finding of the case, a page at a time.
+type FindingFilter struct {
+	// CaseID is the case queried, the script's own when empty: the
+	// findings of any other case are refused.
+	CaseID string `json:"case_id,omitempty"`
+	// Kind selects findings whose FindingKey is Kind or starts with Kind
+	// and a "/", e.g. "ioc/sha256" for "ioc/sha256/<hash>". A full key
+	// selects that finding.
+	Kind string `json:"kind,omitempty"`
+	// MinSeverity selects findings at least that severe.
+	MinSeverity Severity `json:"min_severity,omitempty"`
+	// EvidenceUID selects findings reported on that evidence item.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	// Limit caps the findings of the page, MaxFindingPage when zero or
+	// larger.
+	Limit int `json:"limit,omitempty"`
+	// Cursor resumes the query after the finding with that Cursor, the
+	// last of the previous page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Finding is a finding that earlier jobs of the case reported, as
+// QueryFindings returns it.
+type Finding struct {
+	Result
+	// JobIDs and EvidenceUIDs list the jobs and evidence items that
+	// reported the finding.
+	JobIDs       []string `json:"job_ids,omitempty"`
+	EvidenceUIDs []string `json:"evidence_uids,omitempty"`
+	// Count is the number of times the finding was reported.
+	Count int `json:"count"`
+	// Module names the analysis module that reported Result, e.g.
+	// "yara-triage v1.2.0", when known.
+	Module string `json:"module,omitempty"`
+	// Cursor is the FindingFilter.Cursor of the page after this finding.
+	Cursor string `json:"cursor"`
+}
+
+// FindingQueryResponse answers a FindingFilter sent on
+// FINDING_QUERY_SOCKET.
+type FindingQueryResponse struct {
+	Findings []Finding `json:"findings,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Denied reports that the query was refused rather than failed.
+	Denied bool `json:"denied,omitempty"`
+}
+
+// QueryFindings asks the orchestrator for a page of the findings earlier
+// jobs of the script's case reported that match filter, in the order they
+// were first reported, e.g. for a correlation module to check whether
+// another module flagged a hash. The next page is queried with the Cursor
+// of the last finding of this one; an empty page is the last.
+func QueryFindings(filter FindingFilter) ([]Finding, error) {
+	socket := os.Getenv(EnvFindingQuerySocket)
+	if socket == "" {
+		return nil, ErrFindingQueryUnavailable
+	}
+	if filter.MinSeverity != "" && !filter.MinSeverity.Valid() {
+		return nil, fmt.Errorf("%w %q", ErrInvalidSeverity, filter.MinSeverity)
+	}
+	conn, err := net.DialTimeout("unix", socket, QueryTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: query findings: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(QueryTimeout))
+	if err := json.NewEncoder(conn).Encode(filter); err != nil {
+		return nil, fmt.Errorf("sandbox: query findings: %w", err)
+	}
+	var resp FindingQueryResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("sandbox: query findings: %w", err)
+	}
+	switch {
+	case resp.Denied:
+		return nil, fmt.Errorf("%w: %s", ErrFindingQueryDenied, resp.Error)
+	case resp.Error != "":
+		return nil, fmt.Errorf("sandbox: query findings: %s", resp.Error)
+	}
+	return resp.Findings, nil
+}
@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// serveQueries answers QueryFindings requests on a socket in a temporary
+// directory with answer, recording the filters it receives.
+func serveQueries(t *testing.T, answer func(FindingFilter) FindingQueryResponse) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "query.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var filter FindingFilter
+			json.NewDecoder(bufio.NewReader(conn)).Decode(&filter)
+			json.NewEncoder(conn).Encode(answer(filter))
+			conn.Close()
+		}
+	}()
+	return socket
+}
+
+func TestQueryFindings(t *testing.T) {
+	var got FindingFilter
+	t.Setenv(EnvFindingQuerySocket, serveQueries(t, func(f FindingFilter) FindingQueryResponse {
+		got = f
+		switch f.CaseID {
+		case "case-2":
+			return FindingQueryResponse{Error: "findings belong to another case", Denied: true}
+		case "case-down":
+			return FindingQueryResponse{Error: "store unavailable"}
+		}
+		return FindingQueryResponse{Findings: []Finding{{
+			Result: Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "Known bad hash", FindingKey: "ioc/sha256/ab"},
+			Count:  2,
+			Cursor: "4",
+		}}}
+	}))
+
+	filter := FindingFilter{Kind: "ioc/sha256", MinSeverity: SeverityMedium, Limit: 10, Cursor: "3"}
+	findings, err := QueryFindings(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != filter {
+		t.Errorf("sent %+v, want %+v", got, filter)
+	}
+	if len(findings) != 1 || findings[0].FindingKey != "ioc/sha256/ab" || findings[0].Count != 2 || findings[0].Cursor != "4" {
+		t.Errorf("findings = %+v", findings)
+	}
+
+	if _, err := QueryFindings(FindingFilter{CaseID: "case-2"}); !errors.Is(err, ErrFindingQueryDenied) {
+		t.Errorf("cross-case query: err = %v, want ErrFindingQueryDenied", err)
+	}
+	if _, err := QueryFindings(FindingFilter{CaseID: "case-down"}); err == nil || errors.Is(err, ErrFindingQueryDenied) {
+		t.Errorf("failed query: err = %v", err)
+	}
+	if _, err := QueryFindings(FindingFilter{MinSeverity: "urgent"}); !errors.Is(err, ErrInvalidSeverity) {
+		t.Errorf("invalid severity: err = %v, want ErrInvalidSeverity", err)
+	}
+
+	t.Setenv(EnvFindingQuerySocket, "")
+	if _, err := QueryFindings(FindingFilter{}); !errors.Is(err, ErrFindingQueryUnavailable) {
+		t.Errorf("without a socket: err = %v, want ErrFindingQueryUnavailable", err)
+	}
+}
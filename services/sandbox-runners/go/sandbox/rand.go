@@ -0,0 +1,32 @@
+package sandbox
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Seed returns the job's seed, SANDBOX_SEED, and false outside the
+// sandbox or when the variable is malformed. The orchestrator derives it
+// from the script, evidence and parameters of the job and records it in
+// the job's result and audit entry.
+func Seed() (int64, bool) {
+	seed, err := strconv.ParseInt(os.Getenv(EnvSeed), 10, 64)
+	return seed, err == nil
+}
+
+// Rand returns a math/rand generator seeded with Seed, so that a script
+// sampling or shuffling with it makes the same choices on every run of
+// the same job. Outside the sandbox it is seeded from the clock. Each
+// call starts the sequence over: keep the generator for the whole run.
+//
+// Using Rand is opt-in: the global math/rand functions and crypto/rand
+// are left alone, and Rand must not be used for keys or tokens.
+func Rand() *rand.Rand {
+	seed, ok := Seed()
+	if !ok {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
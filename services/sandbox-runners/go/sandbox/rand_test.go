@@ -0,0 +1,26 @@
+package sandbox
+
+import "testing"
+
+func TestRand(t *testing.T) {
+	t.Setenv(EnvSeed, "-42")
+	if seed, ok := Seed(); !ok || seed != -42 {
+		t.Errorf("Seed() = %d, %v", seed, ok)
+	}
+	a, b := Rand(), Rand()
+	for i := 0; i < 5; i++ {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("draw %d: %d, then %d with the same seed", i, x, y)
+		}
+	}
+
+	for _, v := range []string{"", "0x2a", "seed"} {
+		t.Setenv(EnvSeed, v)
+		if _, ok := Seed(); ok {
+			t.Errorf("Seed() with %s=%q reports a seed", EnvSeed, v)
+		}
+		if Rand() == nil {
+			t.Errorf("Rand() with %s=%q = nil", EnvSeed, v)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ErrRecordLimit is returned by the emit helpers for a record past the cap
+// the orchestrator set on the records of its kind: it would not be
+// ingested. A script that reaches a cap is most likely emitting records
+// in a loop, or should aggregate them.
+var ErrRecordLimit = errors.New("sandbox: record limit reached")
+
+// cappedRecords describes the files whose records may be capped.
+var cappedRecords = map[string]struct{ env, kind string }{
+	ResultsFile:  {EnvMaxFindings, "findings"},
+	ManifestFile: {EnvMaxArtifacts, "artifacts"},
+	TimelineFile: {EnvMaxTimelineEvents, "timeline events"},
+}
+
+var (
+	limitMu sync.Mutex
+	// emitted counts the records of each capped file, by path, those the
+	// file held before this process first wrote to it included.
+	emitted = map[string]int{}
+	// limitLogged records the files whose cap was reported in the log.
+	limitLogged = map[string]bool{}
+)
+
+// recordLimit returns the cap on the records of the named file, zero
+// when there is none.
+func recordLimit(name string) int {
+	c, ok := cappedRecords[name]
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(os.Getenv(c.env))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// limitError reports that the named file in dir reached limit, logging it
+// the first time so that the script author sees it even if the error is
+// ignored. The caller holds limitMu.
+func limitError(dir, name string, limit int) error {
+	c := cappedRecords[name]
+	err := fmt.Errorf("%w: the job may emit at most %d %s (%s)", ErrRecordLimit, limit, c.kind, c.env)
+	if path := filepath.Join(dir, name); !limitLogged[path] {
+		limitLogged[path] = true
+		log.Printf("%v: further %s are dropped; is the parser runaway?", err, c.kind)
+	}
+	return err
+}
+
+// reserveRecord counts a record about to be appended to the named NDJSON
+// file in dir, or returns an ErrRecordLimit error when the file already
+// holds as many as its cap.
+func reserveRecord(dir, name string) error {
+	limit := recordLimit(name)
+	if limit == 0 {
+		return nil
+	}
+	path := filepath.Join(dir, name)
+	limitMu.Lock()
+	defer limitMu.Unlock()
+	n, ok := emitted[path]
+	if !ok {
+		n = countRecords(path)
+	}
+	if n >= limit {
+		emitted[path] = n
+		return limitError(dir, name, limit)
+	}
+	emitted[path] = n + 1
+	return nil
+}
+
+// checkArtifactLimit returns an ErrRecordLimit error when m, the manifest
+// in dir, already registers as many artifacts as their cap.
+func checkArtifactLimit(dir string, m *Manifest) error {
+	limit := recordLimit(ManifestFile)
+	if limit == 0 || len(m.Artifacts) < limit {
+		return nil
+	}
+	limitMu.Lock()
+	defer limitMu.Unlock()
+	return limitError(dir, ManifestFile, limit)
+}
+
+// countRecords returns the number of records of the NDJSON file at path,
+// its header and blank lines left out; zero when it cannot be read.
+func countRecords(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	n := 0
+	sc := newRecordScanner(f)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if _, header := ParseHeader(line); sc.oversized || len(line) > 0 && !header {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,155 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEmitResultLimit(t *testing.T) {
+	dir := setupEnv(t)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	finding := Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "x"}
+	// Records written before the cap was known count towards it.
+	if err := EmitResult(finding); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvMaxFindings, "50")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	refused := 0
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				err := EmitResult(finding)
+				if err != nil && !errors.Is(err, ErrRecordLimit) {
+					t.Error(err)
+				}
+				mu.Lock()
+				if err != nil {
+					refused++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(readLines(t, filepath.Join(dir, ResultsFile))); n != 50 || refused != 51 {
+		t.Errorf("%d findings written, %d refused, want 50 and 51", n, refused)
+	}
+	if n := strings.Count(logs.String(), "runaway"); n != 1 {
+		t.Errorf("cap logged %d times, want once: %q", n, logs.String())
+	}
+	err := EmitResult(finding)
+	if !errors.Is(err, ErrRecordLimit) || !strings.Contains(err.Error(), "at most 50 findings (SANDBOX_MAX_FINDINGS)") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestTimelineLimit(t *testing.T) {
+	dir := setupEnv(t)
+	t.Setenv(EnvMaxTimelineEvents, "2")
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := EmitTimelineEvent(ts, "prefetch", "run", nil); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewTimelineWriter(WithFlushInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Emit(ts, "prefetch", "run", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Emit(ts, "prefetch", "run", nil); !errors.Is(err, ErrRecordLimit) {
+		t.Errorf("third event: err = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitTimelineEvent(ts, "prefetch", "run", nil); !errors.Is(err, ErrRecordLimit) {
+		t.Errorf("fourth event: err = %v", err)
+	}
+	if n := len(readLines(t, filepath.Join(dir, TimelineFile))); n != 2 {
+		t.Errorf("%d events written, want 2", n)
+	}
+}
+
+func TestRegisterArtifactLimit(t *testing.T) {
+	dir := setupEnv(t)
+	t.Setenv(EnvMaxArtifacts, "1")
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+	for _, name := range []string{"a.csv", "b.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RegisterArtifact("a.csv", "table", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterArtifact("b.csv", "table", ""); !errors.Is(err, ErrRecordLimit) {
+		t.Errorf("second artifact: err = %v", err)
+	}
+	// Registering a path again does not add an artifact.
+	if err := RegisterArtifact("a.csv", "table", "again"); err != nil {
+		t.Errorf("re-registering: %v", err)
+	}
+	if _, err := ExtractFile("c.bin", strings.NewReader("y")); !errors.Is(err, ErrRecordLimit) {
+		t.Errorf("extracted file: err = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ExtractedDir, "c.bin")); !os.IsNotExist(err) {
+		t.Errorf("refused extracted file left behind: %v", err)
+	}
+}
+
+func TestReadResultsUpTo(t *testing.T) {
+	dir := setupEnv(t)
+	for i := 0; i < 3; i++ {
+		if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct{ limit, want int }{{0, 3}, {2, 2}, {3, 3}, {4, 3}} {
+		results, total, err := ReadResultsUpTo(dir, nil, tc.limit)
+		if err != nil || len(results) != tc.want || total != 3 {
+			t.Errorf("limit %d: %d results of %d, %v, want %d of 3", tc.limit, len(results), total, err, tc.want)
+		}
+	}
+	if results, _, _ := ReadResultsUpTo(dir, nil, 2); results[1].Title != "1" {
+		t.Errorf("kept %+v, want the first findings", results)
+	}
+	// The lines past the limit are counted, not decoded.
+	f, err := os.OpenFile(filepath.Join(dir, ResultsFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n{\"cut\": ")
+	f.Close()
+	if results, total, err := ReadResultsUpTo(dir, nil, 3); len(results) != 3 || total != 5 || err != nil {
+		t.Errorf("limit 3: %d results of %d, %v, want 3 of 5 without error", len(results), total, err)
+	}
+	if _, _, err := ReadResultsUpTo(dir, nil, 0); len(RecordErrors(err)) != 2 {
+		t.Errorf("without limit: %v, want both lines rejected", err)
+	}
+
+	events, total, err := ReadTimelineUpTo(dir, 1)
+	if len(events) != 0 || total != 0 || err != nil {
+		t.Errorf("timeline without a file = %v, %d, %v", events, total, err)
+	}
+}
@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+)
+
+// PanicFile is the name of the file inside OUTPUT_DIR in which Recover
+// records the panic of a script.
+const PanicFile = "panic.json"
+
+// PanicExitCode is the exit status of a script whose panic Recover caught,
+// EX_SOFTWARE, apart from the 2 of an unrecovered panic.
+const PanicExitCode = 70
+
+// PanicRecord is the content of PanicFile.
+type PanicRecord struct {
+	// EvidenceUID is EVIDENCE_UID when it is set.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	// Message is the panic value, e.g. "runtime error: index out of range
+	// [5] with length 3".
+	Message string `json:"message"`
+	// Stack is the trace of the goroutine that panicked.
+	Stack string `json:"stack"`
+}
+
+// Recover, deferred directly at the top of main, turns a panic of the main
+// goroutine into a result for a parser that has emitted findings before
+// failing:
+//
+//	func main() {
+//		defer sandbox.Recover()
+//		...
+//	}
+//
+// It records the panic and its stack in PanicFile and on stderr, runs the
+// OnShutdown handlers so that buffered findings are flushed, and exits
+// with PanicExitCode. The orchestrator then reports the job as completed
+// with a panic and keeps everything written before it. Panics of other
+// goroutines still crash the script: they need their own deferred
+// Recover.
+func Recover() {
+	p := recover()
+	if p == nil {
+		return
+	}
+	recoverPanic(p, debug.Stack())
+}
+
+// recoverPanic records p, raised with stack, and exits.
+func recoverPanic(p any, stack []byte) {
+	rec := PanicRecord{EvidenceUID: os.Getenv(EnvEvidenceUID), Message: fmt.Sprint(p), Stack: string(stack)}
+	fmt.Fprintf(os.Stderr, "panic: %s [recovered]\n\n%s", rec.Message, rec.Stack)
+	if err := writePanic(rec); err != nil {
+		log.Printf("sandbox: record panic: %v", err)
+	}
+	shutdown()
+	exit(PanicExitCode)
+}
+
+func writePanic(rec PanicRecord) error {
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return WriteOutput(filepath.Join(dir, PanicFile), append(data, '\n'))
+}
+
+// ReadPanic returns the panic Recover recorded in dir, nil when there is
+// none.
+func ReadPanic(dir string) (*PanicRecord, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PanicFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec PanicRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("sandbox: %s: %w", PanicFile, err)
+	}
+	return &rec, nil
+}
@@ -0,0 +1,70 @@
+package sandbox
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoverKeepsFindings(t *testing.T) {
+	dir := setupEnv(t)
+	var code int
+	exit = func(c int) { code = c }
+	shutdownMu.Lock()
+	handlers := shutdownHandlers
+	shutdownHandlers = nil
+	shutdownMu.Unlock()
+	t.Cleanup(func() {
+		exit = os.Exit
+		shutdownMu.Lock()
+		shutdownHandlers = handlers
+		shutdownMu.Unlock()
+	})
+
+	// The parser emits one finding as it goes and buffers another.
+	buffered := Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "buffered"}
+	OnShutdown(func() {
+		if err := EmitResult(buffered); err != nil {
+			t.Error(err)
+		}
+	})
+	func() {
+		defer Recover()
+		if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "emitted"}); err != nil {
+			t.Fatal(err)
+		}
+		var records []int
+		_ = records[5]
+	}()
+
+	if code != PanicExitCode {
+		t.Errorf("exit code = %d, want %d", code, PanicExitCode)
+	}
+	results, err := ReadResults(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Title != "emitted" || results[1].Title != "buffered" {
+		t.Errorf("results %+v, want the findings emitted and buffered before the panic", results)
+	}
+	rec, err := ReadPanic(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil || rec.Message != "runtime error: index out of range [5] with length 0" || rec.EvidenceUID != "ev-1" ||
+		!strings.Contains(rec.Stack, "TestRecoverKeepsFindings") {
+		t.Errorf("panic record %+v", rec)
+	}
+}
+
+func TestRecoverWithoutPanic(t *testing.T) {
+	dir := setupEnv(t)
+	exit = func(c int) { t.Errorf("exited with %d", c) }
+	t.Cleanup(func() { exit = os.Exit })
+	func() {
+		defer Recover()
+	}()
+	if rec, err := ReadPanic(dir); rec != nil || err != nil {
+		t.Errorf("ReadPanic() = %+v, %v", rec, err)
+	}
+}
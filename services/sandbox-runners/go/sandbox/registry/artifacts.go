@@ -0,0 +1,268 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// RunKeys are the keys Autoruns reads, relative to the root of a SOFTWARE
+// hive and then of an NTUSER.DAT hive.
+var RunKeys = []string{
+	`Microsoft\Windows\CurrentVersion\Run`,
+	`Microsoft\Windows\CurrentVersion\RunOnce`,
+	`Wow6432Node\Microsoft\Windows\CurrentVersion\Run`,
+	`Wow6432Node\Microsoft\Windows\CurrentVersion\RunOnce`,
+	`Software\Microsoft\Windows\CurrentVersion\Run`,
+	`Software\Microsoft\Windows\CurrentVersion\RunOnce`,
+}
+
+// Autorun is a command that a Run key starts at boot or logon.
+type Autorun struct {
+	// Key is the path of the Run key, e.g.
+	// `Microsoft\Windows\CurrentVersion\Run`.
+	Key string
+	// Name is the name of the value, Command its data, not expanded.
+	Name, Command string
+	// LastWritten is the time the Run key was last written, an upper
+	// bound for when the most recent of its autoruns was added.
+	LastWritten time.Time
+	// Value is the value the autorun was read from.
+	Value *Value
+}
+
+// Autoruns returns the autoruns of the RunKeys of h, in that order. Keys
+// missing from the hive, which holds only one of the sets, are skipped,
+// as are values that are not strings.
+func Autoruns(h *Hive) ([]Autorun, error) {
+	var autoruns []Autorun
+	for _, path := range RunKeys {
+		k, err := h.Key(path)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values, err := k.Values()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			cmd, err := v.Text()
+			if errors.Is(err, ErrValueType) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			autoruns = append(autoruns, Autorun{Key: k.Path(), Name: v.Name, Command: cmd, LastWritten: k.LastWritten, Value: v})
+		}
+	}
+	return autoruns, nil
+}
+
+// CurrentControlSet returns the control set of a SYSTEM hive that Windows
+// booted with, named by the Select\Current value, e.g. ControlSet001.
+func CurrentControlSet(h *Hive) (*Key, error) {
+	sel, err := h.Key("Select")
+	if err != nil {
+		return nil, err
+	}
+	v, err := sel.Value("Current")
+	if err != nil {
+		return nil, err
+	}
+	n, err := v.Integer()
+	if err != nil {
+		return nil, err
+	}
+	return h.Key(fmt.Sprintf("ControlSet%03d", n))
+}
+
+// Start modes of Service.Start.
+const (
+	StartBoot     = 0
+	StartSystem   = 1
+	StartAuto     = 2
+	StartDemand   = 3
+	StartDisabled = 4
+)
+
+var startModes = []string{"boot", "system", "auto", "demand", "disabled"}
+
+// Service is a service or driver of the current control set of a SYSTEM
+// hive.
+type Service struct {
+	Name        string
+	DisplayName string
+	// ImagePath is the command line of the service, not expanded; empty
+	// for the drivers and services that do not set it.
+	ImagePath string
+	// Start is the start mode, e.g. StartAuto, Type the SERVICE_* type
+	// bits, e.g. 0x10 for a service running in its own process.
+	Start, Type uint32
+	// LastWritten is the time the service key was last written.
+	LastWritten time.Time
+	// Key is the key of the service.
+	Key *Key
+}
+
+// StartMode names the start mode of s, e.g. "auto" for StartAuto.
+func (s Service) StartMode() string {
+	if int(s.Start) < len(startModes) {
+		return startModes[s.Start]
+	}
+	return fmt.Sprintf("%d", s.Start)
+}
+
+// Services returns the services of the current control set of the SYSTEM
+// hive h, sorted by name. Values of the wrong type are left empty.
+func Services(h *Hive) ([]Service, error) {
+	cs, err := CurrentControlSet(h)
+	if err != nil {
+		return nil, err
+	}
+	root, err := cs.Subkey("Services")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := root.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+	services := make([]Service, 0, len(keys))
+	for _, k := range keys {
+		values, err := k.Values()
+		if err != nil {
+			return nil, err
+		}
+		s := Service{Name: k.Name, Start: StartDemand, LastWritten: k.LastWritten, Key: k}
+		for _, v := range values {
+			switch strings.ToLower(v.Name) {
+			case "displayname":
+				s.DisplayName, _ = v.Text()
+			case "imagepath":
+				s.ImagePath, _ = v.Text()
+			case "start":
+				if n, err := v.Integer(); err == nil {
+					s.Start = uint32(n)
+				}
+			case "type":
+				if n, err := v.Integer(); err == nil {
+					s.Type = uint32(n)
+				}
+			}
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// ComputerName returns the computer name recorded in the current control
+// set of the SYSTEM hive h.
+func ComputerName(h *Hive) (string, error) {
+	cs, err := CurrentControlSet(h)
+	if err != nil {
+		return "", err
+	}
+	k, err := h.Key(cs.Path() + `\Control\ComputerName\ComputerName`)
+	if err != nil {
+		return "", err
+	}
+	v, err := k.Value("ComputerName")
+	if err != nil {
+		return "", err
+	}
+	return v.Text()
+}
+
+// EmitAutoruns emits a finding of severity sev with sandbox.EmitResult for
+// each of the Autoruns of h, located at its value, and returns how many it
+// emitted. Scripts that rank autoruns, e.g. by the folder of the command,
+// call Autoruns and emit their own results.
+func EmitAutoruns(h *Hive, sev sandbox.Severity) (int, error) {
+	uid, err := sandbox.MustGetEnv(sandbox.EnvEvidenceUID)
+	if err != nil {
+		return 0, err
+	}
+	autoruns, err := Autoruns(h)
+	if err != nil {
+		return 0, err
+	}
+	for i, a := range autoruns {
+		err := sandbox.EmitResult(sandbox.Result{
+			EvidenceUID: uid,
+			Severity:    sev,
+			Title:       "Autorun " + a.Name,
+			Description: a.Command,
+			FindingKey:  "registry/autorun/" + strings.ToLower(a.Key+`\`+a.Name),
+			Data: map[string]any{
+				"key":          a.Key,
+				"value":        a.Name,
+				"command":      a.Command,
+				"last_written": a.LastWritten.Format(time.RFC3339),
+			},
+			Locations: []sandbox.Location{{
+				EvidenceUID: uid,
+				Offset:      a.Value.Offset(),
+				Length:      a.Value.Length(),
+				Description: fmt.Sprintf("value %q of key %s", a.Name, a.Key),
+			}},
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(autoruns), nil
+}
+
+// EmitServices emits a finding of severity sev with sandbox.EmitResult for
+// each of the Services of h that starts without being asked to, that is
+// with StartAuto or an earlier start mode, located at its key, and returns
+// how many it emitted.
+func EmitServices(h *Hive, sev sandbox.Severity) (int, error) {
+	uid, err := sandbox.MustGetEnv(sandbox.EnvEvidenceUID)
+	if err != nil {
+		return 0, err
+	}
+	services, err := Services(h)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, s := range services {
+		if s.Start > StartAuto {
+			continue
+		}
+		err := sandbox.EmitResult(sandbox.Result{
+			EvidenceUID: uid,
+			Severity:    sev,
+			Title:       "Service " + s.Name,
+			Description: s.ImagePath,
+			FindingKey:  "registry/service/" + strings.ToLower(s.Name),
+			Data: map[string]any{
+				"name":         s.Name,
+				"display_name": s.DisplayName,
+				"image_path":   s.ImagePath,
+				"start":        s.StartMode(),
+				"type":         s.Type,
+				"last_written": s.LastWritten.Format(time.RFC3339),
+			},
+			Locations: []sandbox.Location{{
+				EvidenceUID: uid,
+				Offset:      s.Key.Offset(),
+				Length:      s.Key.Length(),
+				Description: "key " + s.Key.Path(),
+			}},
+		})
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func TestAutoruns(t *testing.T) {
+	autoruns, err := Autoruns(openFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range autoruns {
+		got = append(got, a.Key+`\`+a.Name+"="+a.Command)
+	}
+	want := []string{
+		`Microsoft\Windows\CurrentVersion\Run\OneDrive="C:\Program Files\Microsoft OneDrive\OneDrive.exe" /background`,
+		`Microsoft\Windows\CurrentVersion\Run\updater=%PUBLIC%\upd.exe -silent`,
+		`Microsoft\Windows\CurrentVersion\RunOnce\cleanup=cmd.exe /c del C:\Temp\x.bat`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("autoruns %q, want %q", got, want)
+	}
+	if want := time.Date(2024, 3, 2, 8, 14, 0, 0, time.UTC); !autoruns[0].LastWritten.Equal(want) {
+		t.Errorf("LastWritten = %v, want %v", autoruns[0].LastWritten, want)
+	}
+}
+
+func TestServices(t *testing.T) {
+	h := openFixture(t)
+	services, err := Services(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 3 {
+		t.Fatalf("%d services", len(services))
+	}
+	s := services[0]
+	if s.Name != "EvilSvc" || s.DisplayName != "Evil Service" || s.ImagePath != `%SystemRoot%\system32\evil.exe -k` ||
+		s.StartMode() != "auto" || s.Type != 0x10 || s.Key.Path() != `ControlSet001\Services\EvilSvc` {
+		t.Errorf("service %+v", s)
+	}
+	if services[1].StartMode() != "boot" || services[2].StartMode() != "demand" {
+		t.Errorf("start modes %s, %s", services[1].StartMode(), services[2].StartMode())
+	}
+	if name, err := ComputerName(h); name != "WS-042" || err != nil {
+		t.Errorf("ComputerName() = %q, %v", name, err)
+	}
+}
+
+// TestEmitEmbeddedHive parses the hive as a range of a larger image, as
+// EVIDENCE_OFFSET and EVIDENCE_LENGTH give it.
+func TestEmitEmbeddedHive(t *testing.T) {
+	hive := readFixture(t)
+	const start = 1 << 20
+	image := append(append(make([]byte, start), hive...), make([]byte, 4096)...)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "disk.raw")
+	if err := os.WriteFile(path, image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(sandbox.EnvEvidencePath, path)
+	t.Setenv(sandbox.EnvEvidenceOffset, strconv.Itoa(start))
+	t.Setenv(sandbox.EnvEvidenceLength, strconv.Itoa(len(hive)))
+	t.Setenv(sandbox.EnvEvidenceUID, "ev-1")
+	t.Setenv(sandbox.EnvCaseID, "case-1")
+	t.Setenv(sandbox.EnvOutputDir, out)
+
+	h, err := OpenEvidence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if n, err := EmitAutoruns(h, sandbox.SeverityMedium); n != 3 || err != nil {
+		t.Fatalf("EmitAutoruns() = %d, %v", n, err)
+	}
+	if n, err := EmitServices(h, sandbox.SeverityLow); n != 2 || err != nil {
+		t.Fatalf("EmitServices() = %d, %v", n, err)
+	}
+	results, err := sandbox.ReadResultsFor(out, map[string]int64{"ev-1": int64(len(hive))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("%d results", len(results))
+	}
+	r := results[1]
+	if r.FindingKey != `registry/autorun/microsoft\windows\currentversion\run\updater` || r.Severity != sandbox.SeverityMedium ||
+		r.Data["command"] != `%PUBLIC%\upd.exe -silent` || r.Data["last_written"] != "2024-03-02T08:14:00Z" {
+		t.Errorf("autorun result %+v", r)
+	}
+	if r := results[3]; r.FindingKey != "registry/service/evilsvc" || r.Data["start"] != "auto" {
+		t.Errorf("service result %+v", r)
+	}
+	// Locations are offsets in the evidence view, at the cells.
+	for _, r := range results {
+		l := r.Locations[0]
+		sig := string(hive[l.Offset+4 : l.Offset+6])
+		if l.EvidenceUID != "ev-1" || (sig != "vk" && sig != "nk") {
+			t.Errorf("%s located at %+v, a %q cell", r.Title, l, sig)
+		}
+	}
+}
@@ -0,0 +1,671 @@
+// Package registry parses Windows registry hives, the REGF files such as
+// SYSTEM, SOFTWARE or NTUSER.DAT, read from the evidence: it enumerates
+// their keys and values and resolves the artifacts most scripts look for,
+// see Autoruns and Services.
+//
+// A hive is read in place through an io.ReaderAt, so that one embedded in
+// a larger image is parsed without being copied out: OpenEvidence parses
+// the evidence view, EVIDENCE_OFFSET and EVIDENCE_LENGTH included, and
+// OpenAt a hive at any offset of a reader. Offsets reported by Key.Offset
+// and Value.Offset are those of that reader, as sandbox.Location wants.
+//
+// The transaction logs of a hive (.LOG1, .LOG2) are not replayed: a hive
+// that was not flushed, see Hive.Dirty, may miss its latest changes.
+package registry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ErrNotHive is returned by Open for data that does not start with a
+// registry hive base block.
+var ErrNotHive = errors.New("registry: not a registry hive")
+
+// ErrCorrupt is returned for a cell of the hive that is truncated, out of
+// the hive or does not have the expected type, e.g. in a partly
+// overwritten or carved hive.
+var ErrCorrupt = errors.New("registry: corrupt hive")
+
+// ErrNotFound is returned for a key or value that does not exist.
+var ErrNotFound = errors.New("registry: not found")
+
+// ErrValueType is returned when reading a value as a type it does not have,
+// e.g. a REG_BINARY value as a string.
+var ErrValueType = errors.New("registry: wrong value type")
+
+// SkipKey is returned by a Walk callback to skip the subkeys of the key.
+var SkipKey = errors.New("registry: skip key")
+
+const (
+	baseBlockSize = 4096
+	// bigDataSegment is the most data a cell holds: longer values are
+	// split in "db" segments, from hive version 1.4.
+	bigDataSegment = 16344
+	// noCell marks a missing subkey or value list.
+	noCell = 0xffffffff
+	// maxDepth bounds the nesting of keys, beyond which Windows refuses
+	// to create them.
+	maxDepth = 512
+
+	nkCompressedName = 0x20
+	vkCompressedName = 0x1
+)
+
+// Hive is a registry hive opened by Open, OpenAt or OpenEvidence. It is
+// safe for concurrent use when its reader is.
+type Hive struct {
+	// FileName is the path the hive was last saved to, as the base block
+	// records it, e.g. `\??\C:\Windows\System32\config\SYSTEM`; it may be
+	// truncated to its last 31 characters.
+	FileName string
+	// LastWritten is the time the hive was last written.
+	LastWritten time.Time
+	// Dirty reports that the hive was not flushed after its last change:
+	// its transaction logs, not replayed here, may hold newer data.
+	Dirty bool
+
+	r io.ReaderAt
+	// base is the offset of the base block in r, size that of the hive.
+	base, size int64
+	minor      uint32
+	root       uint32
+	closer     io.Closer
+}
+
+// Open parses the hive at the start of r, which holds size bytes.
+func Open(r io.ReaderAt, size int64) (*Hive, error) {
+	return OpenAt(r, 0, size)
+}
+
+// OpenAt parses the hive at offset off of r, of at most size bytes, e.g. a
+// hive found inside a disk image or memory dump.
+func OpenAt(r io.ReaderAt, off, size int64) (*Hive, error) {
+	if off < 0 || size < baseBlockSize+32 {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d", ErrNotHive, size, off)
+	}
+	var block [baseBlockSize + 4]byte
+	if _, err := r.ReadAt(block[:], off); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("registry: read base block: %w", err)
+	}
+	if string(block[:4]) != "regf" || string(block[baseBlockSize:]) != "hbin" {
+		return nil, ErrNotHive
+	}
+	if major := le32(block[20:]); major != 1 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrNotHive, major)
+	}
+	h := &Hive{
+		FileName:    utf16String(block[48:112]),
+		LastWritten: filetime(binary.LittleEndian.Uint64(block[12:])),
+		Dirty:       le32(block[4:]) != le32(block[8:]),
+		r:           r,
+		base:        off,
+		size:        size,
+		minor:       le32(block[24:]),
+		root:        le32(block[36:]),
+	}
+	if bins := int64(le32(block[40:])); bins > 0 && baseBlockSize+bins < size {
+		h.size = baseBlockSize + bins
+	}
+	if _, err := h.Root(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// OpenEvidence opens the evidence like sandbox.OpenEvidence and parses it
+// as a hive; close the hive to close the evidence. Compressed evidence
+// works but is slow, hives being read at random.
+func OpenEvidence(opts ...sandbox.EvidenceOption) (*Hive, error) {
+	ef, err := sandbox.OpenEvidence(opts...)
+	if err != nil {
+		return nil, err
+	}
+	h, err := Open(ef, ef.Size())
+	if err != nil {
+		ef.Close()
+		return nil, err
+	}
+	h.closer = ef
+	return h, nil
+}
+
+// Close closes the evidence opened by OpenEvidence; it does nothing for a
+// hive given its reader.
+func (h *Hive) Close() error {
+	if h.closer == nil {
+		return nil
+	}
+	return h.closer.Close()
+}
+
+// cell returns the data of the allocated cell at off, relative to the
+// first hive bin, without its size field.
+func (h *Hive) cell(off uint32) ([]byte, error) {
+	start := h.base + baseBlockSize + int64(off)
+	if off == noCell || int64(off)+baseBlockSize+4 > h.size {
+		return nil, fmt.Errorf("%w: cell %#x out of the hive", ErrCorrupt, off)
+	}
+	var hdr [4]byte
+	if _, err := h.r.ReadAt(hdr[:], start); err != nil {
+		return nil, fmt.Errorf("registry: read cell %#x: %w", off, err)
+	}
+	n := -int64(int32(le32(hdr[:])))
+	if n < 8 || int64(off)+baseBlockSize+n > h.size {
+		return nil, fmt.Errorf("%w: cell %#x has size %d", ErrCorrupt, off, n)
+	}
+	buf := make([]byte, n-4)
+	if _, err := h.r.ReadAt(buf, start+4); err != nil {
+		return nil, fmt.Errorf("registry: read cell %#x: %w", off, err)
+	}
+	return buf, nil
+}
+
+// typedCell returns the cell at off, which must start with sig and hold
+// at least min bytes.
+func (h *Hive) typedCell(off uint32, sig string, min int) ([]byte, error) {
+	buf, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < min || string(buf[:2]) != sig {
+		return nil, fmt.Errorf("%w: cell %#x is not a %q cell", ErrCorrupt, off, sig)
+	}
+	return buf, nil
+}
+
+// offset returns the offset in the reader of the cell at off.
+func (h *Hive) offset(off uint32) int64 {
+	return h.base + baseBlockSize + int64(off)
+}
+
+// Root returns the root key of the hive, whose path is empty.
+func (h *Hive) Root() (*Key, error) {
+	return h.key(h.root, "")
+}
+
+// Key returns the key at path, relative to the root and separated by
+// backslashes, e.g. `Microsoft\Windows\CurrentVersion\Run`. Names are
+// matched regardless of case, as Windows does. It returns an error
+// wrapping ErrNotFound when a key of path does not exist.
+func (h *Hive) Key(path string) (*Key, error) {
+	k, err := h.Root()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(strings.Trim(path, `\`), `\`) {
+		if name == "" {
+			continue
+		}
+		if k, err = k.Subkey(name); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Walk calls fn for every key of the hive, parents before their subkeys
+// and subkeys in the order of the hive, which is sorted by name. A
+// callback returning SkipKey skips the subkeys of the key; any other
+// error stops the walk and is returned.
+func (h *Hive) Walk(fn func(k *Key) error) error {
+	root, err := h.Root()
+	if err != nil {
+		return err
+	}
+	seen := map[uint32]bool{}
+	var walk func(k *Key, depth int) error
+	walk = func(k *Key, depth int) error {
+		if depth > maxDepth || seen[k.off] {
+			return fmt.Errorf("%w: key %s nested in itself or too deep", ErrCorrupt, k.Path())
+		}
+		seen[k.off] = true
+		if err := fn(k); errors.Is(err, SkipKey) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		subkeys, err := k.Subkeys()
+		if err != nil {
+			return err
+		}
+		for _, sk := range subkeys {
+			if err := walk(sk, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, 0)
+}
+
+// Key is a key of a hive.
+type Key struct {
+	Name        string
+	LastWritten time.Time
+
+	h    *Hive
+	off  uint32
+	size int64
+	path string
+	// subkeys and values are the offsets of the subkey and value lists.
+	subkeys, values uint32
+	nValues         uint32
+}
+
+// key parses the key cell at off, whose path is path.
+func (h *Hive) key(off uint32, path string) (*Key, error) {
+	buf, err := h.typedCell(off, "nk", 76)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint16(buf[72:]))
+	if 76+n > len(buf) {
+		return nil, fmt.Errorf("%w: key cell %#x has a %d-byte name", ErrCorrupt, off, n)
+	}
+	k := &Key{
+		Name:        cellName(buf[76:76+n], binary.LittleEndian.Uint16(buf[2:])&nkCompressedName != 0),
+		LastWritten: filetime(binary.LittleEndian.Uint64(buf[4:])),
+		h:           h,
+		off:         off,
+		size:        int64(len(buf)) + 4,
+		subkeys:     le32(buf[28:]),
+		values:      le32(buf[40:]),
+		nValues:     le32(buf[36:]),
+		path:        path,
+	}
+	if le32(buf[20:]) == 0 {
+		k.subkeys = noCell
+	}
+	return k, nil
+}
+
+// Path returns the path of k from the root, e.g.
+// `Microsoft\Windows\CurrentVersion\Run`, empty for the root.
+func (k *Key) Path() string { return k.path }
+
+// Offset returns the offset of the key cell in the reader of the hive.
+func (k *Key) Offset() int64 { return k.h.offset(k.off) }
+
+// Length is the size of the key cell.
+func (k *Key) Length() int64 { return k.size }
+
+// Subkeys returns the subkeys of k, sorted by name.
+func (k *Key) Subkeys() ([]*Key, error) {
+	if k.subkeys == noCell {
+		return nil, nil
+	}
+	offs, err := k.h.subkeyList(k.subkeys, true)
+	if err != nil {
+		return nil, err
+	}
+	subkeys := make([]*Key, 0, len(offs))
+	for _, off := range offs {
+		sk, err := k.h.key(off, "")
+		if err != nil {
+			return nil, err
+		}
+		sk.path = k.child(sk.Name)
+		subkeys = append(subkeys, sk)
+	}
+	return subkeys, nil
+}
+
+func (k *Key) child(name string) string {
+	if k.path == "" {
+		return name
+	}
+	return k.path + `\` + name
+}
+
+// Subkey returns the subkey of k named name, in any case.
+func (k *Key) Subkey(name string) (*Key, error) {
+	subkeys, err := k.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, sk := range subkeys {
+		if strings.EqualFold(sk.Name, name) {
+			return sk, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: key %s", ErrNotFound, k.child(name))
+}
+
+// subkeyList returns the key offsets of the subkey list at off: an "li",
+// "lf" or "lh" list, or, when nested is set, an "ri" list of those.
+func (h *Hive) subkeyList(off uint32, nested bool) ([]uint32, error) {
+	buf, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("%w: subkey list %#x", ErrCorrupt, off)
+	}
+	n := int(binary.LittleEndian.Uint16(buf[2:]))
+	stride := 8
+	switch sig := string(buf[:2]); {
+	case sig == "li" || (sig == "ri" && nested):
+		stride = 4
+	case sig == "lf" || sig == "lh":
+	default:
+		return nil, fmt.Errorf("%w: cell %#x is not a subkey list", ErrCorrupt, off)
+	}
+	if 4+n*stride > len(buf) {
+		return nil, fmt.Errorf("%w: subkey list %#x holds %d entries", ErrCorrupt, off, n)
+	}
+	offs := make([]uint32, 0, n)
+	for i := 0; i < n; i++ {
+		offs = append(offs, le32(buf[4+i*stride:]))
+	}
+	if string(buf[:2]) != "ri" {
+		return offs, nil
+	}
+	var all []uint32
+	for _, sub := range offs {
+		list, err := h.subkeyList(sub, false)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, list...)
+	}
+	return all, nil
+}
+
+// Values returns the values of k, in the order of the hive.
+func (k *Key) Values() ([]*Value, error) {
+	if k.nValues == 0 || k.values == noCell {
+		return nil, nil
+	}
+	buf, err := k.h.cell(k.values)
+	if err != nil {
+		return nil, err
+	}
+	if int64(k.nValues)*4 > int64(len(buf)) {
+		return nil, fmt.Errorf("%w: value list of key %s holds %d values", ErrCorrupt, k.path, k.nValues)
+	}
+	values := make([]*Value, 0, k.nValues)
+	for i := 0; i < int(k.nValues); i++ {
+		v, err := k.h.value(le32(buf[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		v.Key = k
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Value returns the value of k named name, in any case; the default value
+// of the key has an empty name.
+func (k *Key) Value(name string) (*Value, error) {
+	values, err := k.Values()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values {
+		if strings.EqualFold(v.Name, name) {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: value %q of key %s", ErrNotFound, name, k.path)
+}
+
+// ValueType is the type of a value, e.g. TypeString for REG_SZ.
+type ValueType uint32
+
+// Value types.
+const (
+	TypeNone             ValueType = 0
+	TypeString           ValueType = 1
+	TypeExpandString     ValueType = 2
+	TypeBinary           ValueType = 3
+	TypeDword            ValueType = 4
+	TypeDwordBigEndian   ValueType = 5
+	TypeLink             ValueType = 6
+	TypeMultiString      ValueType = 7
+	TypeResourceList     ValueType = 8
+	TypeFullResourceDesc ValueType = 9
+	TypeResourceReqList  ValueType = 10
+	TypeQword            ValueType = 11
+)
+
+var typeNames = map[ValueType]string{
+	TypeNone:             "REG_NONE",
+	TypeString:           "REG_SZ",
+	TypeExpandString:     "REG_EXPAND_SZ",
+	TypeBinary:           "REG_BINARY",
+	TypeDword:            "REG_DWORD",
+	TypeDwordBigEndian:   "REG_DWORD_BIG_ENDIAN",
+	TypeLink:             "REG_LINK",
+	TypeMultiString:      "REG_MULTI_SZ",
+	TypeResourceList:     "REG_RESOURCE_LIST",
+	TypeFullResourceDesc: "REG_FULL_RESOURCE_DESCRIPTOR",
+	TypeResourceReqList:  "REG_RESOURCE_REQUIREMENTS_LIST",
+	TypeQword:            "REG_QWORD",
+}
+
+// String returns the Windows name of t, e.g. "REG_SZ".
+func (t ValueType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("REG_%#x", uint32(t))
+}
+
+// Value is a value of a key.
+type Value struct {
+	// Name is empty for the default value of the key.
+	Name string
+	Type ValueType
+	// Key is the key the value belongs to.
+	Key *Key
+
+	h    *Hive
+	off  uint32
+	cell int64
+	// size is the data size, data its offset or, for inline data, the
+	// data itself.
+	size   uint32
+	data   uint32
+	inline bool
+}
+
+// value parses the value cell at off.
+func (h *Hive) value(off uint32) (*Value, error) {
+	buf, err := h.typedCell(off, "vk", 20)
+	if err != nil {
+		return nil, err
+	}
+	n := int(binary.LittleEndian.Uint16(buf[2:]))
+	if 20+n > len(buf) {
+		return nil, fmt.Errorf("%w: value cell %#x has a %d-byte name", ErrCorrupt, off, n)
+	}
+	size := le32(buf[4:])
+	return &Value{
+		Name:   cellName(buf[20:20+n], binary.LittleEndian.Uint16(buf[16:])&vkCompressedName != 0),
+		Type:   ValueType(le32(buf[12:])),
+		h:      h,
+		off:    off,
+		cell:   int64(len(buf)) + 4,
+		size:   size &^ 0x80000000,
+		data:   le32(buf[8:]),
+		inline: size&0x80000000 != 0,
+	}, nil
+}
+
+// Offset returns the offset of the value cell in the reader of the hive.
+// The data of the value may be stored elsewhere.
+func (v *Value) Offset() int64 { return v.h.offset(v.off) }
+
+// Length is the size of the value cell.
+func (v *Value) Length() int64 { return v.cell }
+
+// Data returns the raw data of v.
+func (v *Value) Data() ([]byte, error) {
+	if v.inline {
+		if v.size > 4 {
+			return nil, fmt.Errorf("%w: value %q has %d bytes of inline data", ErrCorrupt, v.Name, v.size)
+		}
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], v.data)
+		return buf[:v.size], nil
+	}
+	if v.size == 0 {
+		return []byte{}, nil
+	}
+	if int64(v.size) > v.h.size {
+		return nil, fmt.Errorf("%w: value %q has %d bytes of data", ErrCorrupt, v.Name, v.size)
+	}
+	buf, err := v.h.cell(v.data)
+	if err != nil {
+		return nil, err
+	}
+	if v.size > bigDataSegment && v.h.minor >= 4 && len(buf) >= 8 && string(buf[:2]) == "db" {
+		return v.h.bigData(buf, v.size)
+	}
+	if int64(len(buf)) < int64(v.size) {
+		return nil, fmt.Errorf("%w: value %q has %d of its %d bytes", ErrCorrupt, v.Name, len(buf), v.size)
+	}
+	return buf[:v.size], nil
+}
+
+// bigData joins the segments of the "db" cell db into size bytes.
+func (h *Hive) bigData(db []byte, size uint32) ([]byte, error) {
+	n := int(binary.LittleEndian.Uint16(db[2:]))
+	list, err := h.cell(le32(db[4:]))
+	if err != nil {
+		return nil, err
+	}
+	if n*4 > len(list) {
+		return nil, fmt.Errorf("%w: big data list holds %d segments", ErrCorrupt, n)
+	}
+	data := make([]byte, 0, size)
+	for i := 0; i < n && len(data) < int(size); i++ {
+		seg, err := h.cell(le32(list[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, seg[:min(len(seg), bigDataSegment, int(size)-len(data))]...)
+	}
+	if len(data) < int(size) {
+		return nil, fmt.Errorf("%w: big data has %d of its %d bytes", ErrCorrupt, len(data), size)
+	}
+	return data, nil
+}
+
+// Text returns the string of a REG_SZ, REG_EXPAND_SZ or REG_LINK value, up
+// to its first NUL. REG_EXPAND_SZ strings are not expanded.
+func (v *Value) Text() (string, error) {
+	if v.Type != TypeString && v.Type != TypeExpandString && v.Type != TypeLink {
+		return "", fmt.Errorf("%w: %s is %s, not a string", ErrValueType, v.Name, v.Type)
+	}
+	data, err := v.Data()
+	if err != nil {
+		return "", err
+	}
+	return utf16String(data), nil
+}
+
+// Strings returns the strings of a REG_MULTI_SZ value, or the string of a
+// value Text reads.
+func (v *Value) Strings() ([]string, error) {
+	if v.Type != TypeMultiString {
+		s, err := v.Text()
+		if err != nil {
+			return nil, err
+		}
+		return []string{s}, nil
+	}
+	data, err := v.Data()
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	for _, s := range strings.Split(string(utf16.Decode(utf16Units(data))), "\x00") {
+		// An empty string ends the list.
+		if s == "" {
+			break
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// Integer returns the number of a REG_DWORD, REG_DWORD_BIG_ENDIAN or
+// REG_QWORD value.
+func (v *Value) Integer() (uint64, error) {
+	want := 4
+	if v.Type == TypeQword {
+		want = 8
+	} else if v.Type != TypeDword && v.Type != TypeDwordBigEndian {
+		return 0, fmt.Errorf("%w: %s is %s, not an integer", ErrValueType, v.Name, v.Type)
+	}
+	data, err := v.Data()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < want {
+		return 0, fmt.Errorf("%w: %s %s has %d bytes", ErrCorrupt, v.Type, v.Name, len(data))
+	}
+	switch v.Type {
+	case TypeQword:
+		return binary.LittleEndian.Uint64(data), nil
+	case TypeDwordBigEndian:
+		return uint64(binary.BigEndian.Uint32(data)), nil
+	default:
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+}
+
+func le32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+// cellName decodes a key or value name, stored in Latin-1 when compressed
+// and in UTF-16 otherwise.
+func cellName(b []byte, compressed bool) string {
+	if !compressed {
+		return string(utf16.Decode(utf16Units(b)))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return units
+}
+
+// utf16String decodes the UTF-16 string in b up to its first NUL.
+func utf16String(b []byte) string {
+	units := utf16Units(b)
+	for i, u := range units {
+		if u == 0 {
+			units = units[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// filetime converts a Windows FILETIME, in 100 ns since 1601, to UTC; zero
+// is the zero time.
+func filetime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const unixEpoch = 11644473600
+	return time.Unix(int64(ft/1e7)-unixEpoch, int64(ft%1e7)*100).UTC()
+}
@@ -0,0 +1,248 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testdata/fixture.hive is a small hive written by
+// testdata/make_fixture.py, with the keys of a SOFTWARE hive (Run keys)
+// and of a SYSTEM hive (Select, ControlSet001), under subkey lists of
+// every kind, and a Types key holding a value of each common type.
+const fixture = "testdata/fixture.hive"
+
+var fixtureTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func openFixture(t *testing.T) *Hive {
+	t.Helper()
+	data := readFixture(t)
+	h, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestOpen(t *testing.T) {
+	h := openFixture(t)
+	if h.FileName != `\??\C:\fixture\SYSTEM` || !h.LastWritten.Equal(fixtureTime) || h.Dirty {
+		t.Errorf("hive %q written %v, dirty %v", h.FileName, h.LastWritten, h.Dirty)
+	}
+	root, err := h.Root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "ROOT" || root.Path() != "" || !root.LastWritten.Equal(fixtureTime) {
+		t.Errorf("root %q, path %q, written %v", root.Name, root.Path(), root.LastWritten)
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	data := readFixture(t)
+	for name, data := range map[string][]byte{
+		"empty":    nil,
+		"zeros":    make([]byte, 8192),
+		"no hbin":  append(append([]byte{}, data[:4096]...), make([]byte, 4096)...),
+		"not regf": append([]byte("REGF"), data[4:]...),
+	} {
+		if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNotHive) {
+			t.Errorf("%s: Open() = %v, want ErrNotHive", name, err)
+		}
+	}
+}
+
+func TestOpenTruncated(t *testing.T) {
+	data := readFixture(t)
+	h, err := Open(bytes.NewReader(data[:8192]), 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Walk(func(*Key) error { return nil })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Walk() = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	h := openFixture(t)
+	k, err := h.Key(`microsoft\WINDOWS\CurrentVersion\run\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Name != "Run" || k.Path() != `Microsoft\Windows\CurrentVersion\Run` {
+		t.Errorf("key %q at %q", k.Name, k.Path())
+	}
+	if want := time.Date(2024, 3, 2, 8, 14, 0, 0, time.UTC); !k.LastWritten.Equal(want) {
+		t.Errorf("LastWritten = %v, want %v", k.LastWritten, want)
+	}
+	if _, err := h.Key(`Microsoft\Windows\Missing`); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing key: %v, want ErrNotFound", err)
+	}
+	if k, err := h.Key(`Types\日本`); err != nil || k.Name != "日本" {
+		t.Errorf("UTF-16 key: %v, %v", k, err)
+	}
+}
+
+func TestSubkeys(t *testing.T) {
+	h := openFixture(t)
+	// Root is an lf list, ControlSet001 an li list and Services an ri
+	// list of lh lists.
+	for path, want := range map[string][]string{
+		``:                       {"ControlSet001", "Microsoft", "Select", "Types"},
+		`ControlSet001`:          {"Control", "Services"},
+		`ControlSet001\Services`: {"EvilSvc", "Tcpip", "W32Time"},
+		`Select`:                 nil,
+	} {
+		k, err := h.Key(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subkeys, err := k.Subkeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, sk := range subkeys {
+			names = append(names, sk.Name)
+			if want := k.child(sk.Name); sk.Path() != want {
+				t.Errorf("subkey path %q, want %q", sk.Path(), want)
+			}
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("subkeys of %q = %q, want %q", path, names, want)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	h := openFixture(t)
+	k, err := h.Key("Types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := k.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range values {
+		names = append(names, v.Name+":"+v.Type.String())
+	}
+	want := []string{":REG_SZ", "Binary:REG_BINARY", "Dword:REG_DWORD", "DwordBE:REG_DWORD_BIG_ENDIAN",
+		"Qword:REG_QWORD", "Multi:REG_MULTI_SZ", "Big:REG_BINARY", "名前:REG_SZ"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("values %q, want %q", names, want)
+	}
+
+	value := func(name string) *Value {
+		t.Helper()
+		v, err := k.Value(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if s, err := value("").Text(); s != "default" || err != nil {
+		t.Errorf("default value = %q, %v", s, err)
+	}
+	if s, err := value("名前").Text(); s != "value" || err != nil {
+		t.Errorf("UTF-16 value = %q, %v", s, err)
+	}
+	for name, want := range map[string]uint64{"dword": 0xdeadbeef, "DwordBE": 0x01020304, "Qword": 1 << 40} {
+		if n, err := value(name).Integer(); n != want || err != nil {
+			t.Errorf("%s = %#x, %v, want %#x", name, n, err, want)
+		}
+	}
+	if list, err := value("Multi").Strings(); !reflect.DeepEqual(list, []string{"one", "two"}) || err != nil {
+		t.Errorf("Multi = %q, %v", list, err)
+	}
+	if data, err := value("Binary").Data(); !bytes.Equal(data, []byte{0, 1, 2, 3, 4, 5, 6, 7}) || err != nil {
+		t.Errorf("Binary = %x, %v", data, err)
+	}
+	// Big is split in "db" segments.
+	data, err := value("Big").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 20000 || data[0] != 0 || data[16344] != byte(16344%251) || data[19999] != byte(19999%251) {
+		t.Errorf("Big has %d bytes", len(data))
+	}
+
+	if _, err := value("Binary").Text(); !errors.Is(err, ErrValueType) {
+		t.Errorf("Text() of REG_BINARY = %v, want ErrValueType", err)
+	}
+	if _, err := value("Multi").Integer(); !errors.Is(err, ErrValueType) {
+		t.Errorf("Integer() of REG_MULTI_SZ = %v, want ErrValueType", err)
+	}
+	if _, err := k.Value("Missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing value: %v, want ErrNotFound", err)
+	}
+	if v := value("Dword"); v.Key != k {
+		t.Errorf("value of key %v, want %v", v.Key, k)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	h := openFixture(t)
+	var paths []string
+	err := h.Walk(func(k *Key) error {
+		paths = append(paths, k.Path())
+		if k.Name == "Microsoft" || k.Name == "Control" {
+			return SkipKey
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "ControlSet001", `ControlSet001\Control`, `ControlSet001\Services`,
+		`ControlSet001\Services\EvilSvc`, `ControlSet001\Services\Tcpip`, `ControlSet001\Services\W32Time`,
+		"Microsoft", "Select", "Types", `Types\日本`}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("walked %q, want %q", paths, want)
+	}
+
+	stop := errors.New("stop")
+	if err := h.Walk(func(*Key) error { return stop }); err != stop {
+		t.Errorf("Walk() = %v, want the callback error", err)
+	}
+}
+
+func TestOpenAt(t *testing.T) {
+	hive := readFixture(t)
+	image := append(append(make([]byte, 1536), hive...), make([]byte, 512)...)
+	h, err := OpenAt(bytes.NewReader(image), 1536, int64(len(image)-1536))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := h.Key(`Microsoft\Windows\CurrentVersion\Run`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := k.Value("updater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Offsets are those of the image, at the key and value cells.
+	for _, cell := range []struct {
+		off, n int64
+		sig    string
+	}{{k.Offset(), k.Length(), "nk"}, {v.Offset(), v.Length(), "vk"}} {
+		if cell.off < 1536+4096 || cell.off+cell.n > int64(len(image)) || string(image[cell.off+4:cell.off+6]) != cell.sig {
+			t.Errorf("%s cell at %d+%d", cell.sig, cell.off, cell.n)
+		}
+	}
+}
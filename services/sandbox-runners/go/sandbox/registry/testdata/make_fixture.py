@@ -0,0 +1,176 @@
+#!/usr/bin/env python3
+"""Writes fixture.hive, the registry hive of the registry package tests.
+
+Usage: python3 make_fixture.py fixture.hive
+
+The hive is built cell by cell in a single hive bin, following the REGF
+format as Windows writes it (version 1.5), so that the tests do not depend
+on a hive taken from a real system.
+"""
+import datetime
+import struct
+import sys
+
+def filetime(s):
+    dt = datetime.datetime.fromisoformat(s).replace(tzinfo=datetime.timezone.utc)
+    epoch = datetime.datetime(1601, 1, 1, tzinfo=datetime.timezone.utc)
+    return int((dt - epoch).total_seconds()) * 10_000_000
+
+class Hive:
+    def __init__(self):
+        self.data = bytearray(b"\0" * 32)  # hbin header placeholder
+
+    def cell(self, payload):
+        size = 4 + len(payload)
+        size = (size + 7) & ~7
+        off = len(self.data)
+        self.data += struct.pack("<i", -size) + payload + b"\0" * (size - 4 - len(payload))
+        return off
+
+def name_bytes(name):
+    try:
+        return name.encode("latin-1"), True
+    except UnicodeEncodeError:
+        return name.encode("utf-16-le"), False
+
+REG_SZ, REG_EXPAND_SZ, REG_BINARY, REG_DWORD, REG_DWORD_BE, REG_MULTI_SZ, REG_QWORD = 1, 2, 3, 4, 5, 7, 11
+
+def sz(s):
+    return (s + "\0").encode("utf-16-le")
+
+class Key:
+    def __init__(self, name, ts, values=(), subkeys=(), list_kind="lf"):
+        self.name, self.ts, self.values, self.subkeys, self.list_kind = name, ts, list(values), list(subkeys), list_kind
+
+def lh_hash(name):
+    h = 0
+    for c in name.upper():
+        h = (h * 37 + ord(c)) & 0xffffffff
+    return h
+
+def lf_hash(name):
+    b = name[:4].encode("latin-1", "replace").ljust(4, b"\0")
+    return struct.unpack("<I", b)[0]
+
+def write_value(h, name, typ, data):
+    nb, comp = name_bytes(name)
+    if len(data) <= 4:
+        doff = struct.unpack("<I", data.ljust(4, b"\0"))[0]
+        dsize = len(data) | 0x80000000
+    elif len(data) > 16344:
+        segs = []
+        for i in range(0, len(data), 16344):
+            segs.append(h.cell(data[i:i + 16344]))
+        lst = h.cell(b"".join(struct.pack("<I", s) for s in segs))
+        doff = h.cell(b"db" + struct.pack("<HI", len(segs), lst))
+        dsize = len(data)
+    else:
+        doff = h.cell(data)
+        dsize = len(data)
+    return h.cell(b"vk" + struct.pack("<HIIIHH", len(nb), dsize, doff, typ, 1 if comp else 0, 0) + nb)
+
+def write_list(h, kind, entries):
+    # entries: (offset, name)
+    if kind == "li":
+        return h.cell(b"li" + struct.pack("<H", len(entries)) + b"".join(struct.pack("<I", o) for o, _ in entries))
+    if kind == "ri":
+        half = (len(entries) + 1) // 2
+        parts = [write_list(h, "lh", entries[:half]), write_list(h, "lh", entries[half:])]
+        return h.cell(b"ri" + struct.pack("<H", len(parts)) + b"".join(struct.pack("<I", o) for o in parts))
+    hf = lh_hash if kind == "lh" else lf_hash
+    return h.cell(kind.encode() + struct.pack("<H", len(entries)) + b"".join(struct.pack("<II", o, hf(n)) for o, n in entries))
+
+def write_key(h, key, parent, flags=0x20):
+    # children first so their offsets are known; parent offsets patched after.
+    nb, comp = name_bytes(key.name)
+    if not comp:
+        flags &= ~0x20
+    nk_off = h.cell(b"\0" * (76 + len(nb)))
+    children = []
+    for sk in sorted(key.subkeys, key=lambda k: k.name.upper()):
+        children.append((write_key(h, sk, nk_off), sk.name))
+    sub_list = write_list(h, key.list_kind, children) if children else 0xffffffff
+    vals = [write_value(h, *v) for v in key.values]
+    val_list = h.cell(b"".join(struct.pack("<I", v) for v in vals)) if vals else 0xffffffff
+    body = b"nk" + struct.pack("<HQIIIIIIIIIIIIIIIHH", flags, filetime(key.ts), 0, parent,
+                               len(children), 0, sub_list, 0xffffffff, len(vals), val_list,
+                               0xffffffff, 0xffffffff, 0, 0, 0, 0, 0, len(nb), 0) + nb
+    assert len(body) == 76 + len(nb)
+    h.data[nk_off + 4:nk_off + 4 + len(body)] = body
+    return nk_off
+
+ts = "2024-03-01T12:00:00"
+services = [Key("EvilSvc", "2024-03-02T08:15:00", [
+        ("DisplayName", REG_SZ, sz("Evil Service")),
+        ("ImagePath", REG_EXPAND_SZ, sz(r"%SystemRoot%\system32\evil.exe -k")),
+        ("Start", REG_DWORD, struct.pack("<I", 2)),
+        ("Type", REG_DWORD, struct.pack("<I", 0x10)),
+    ]),
+    Key("Tcpip", ts, [
+        ("ImagePath", REG_EXPAND_SZ, sz(r"System32\drivers\tcpip.sys")),
+        ("Start", REG_DWORD, struct.pack("<I", 0)),
+        ("Type", REG_DWORD, struct.pack("<I", 1)),
+    ]),
+    Key("W32Time", ts, [
+        ("ImagePath", REG_EXPAND_SZ, sz(r"%SystemRoot%\system32\svchost.exe -k LocalService")),
+        ("Start", REG_DWORD, struct.pack("<I", 3)),
+        ("Type", REG_DWORD, struct.pack("<I", 0x20)),
+    ]),
+]
+big = bytes(i % 251 for i in range(20000))
+root = Key("ROOT", ts, subkeys=[
+    Key("Microsoft", ts, list_kind="lh", subkeys=[
+        Key("Windows", ts, list_kind="lh", subkeys=[
+            Key("CurrentVersion", ts, list_kind="lh", subkeys=[
+                Key("Run", "2024-03-02T08:14:00", [
+                    ("OneDrive", REG_SZ, sz(r'"C:\Program Files\Microsoft OneDrive\OneDrive.exe" /background')),
+                    ("updater", REG_EXPAND_SZ, sz(r"%PUBLIC%\upd.exe -silent")),
+                ]),
+                Key("RunOnce", ts, [
+                    ("cleanup", REG_SZ, sz(r"cmd.exe /c del C:\Temp\x.bat")),
+                ]),
+            ]),
+        ]),
+    ]),
+    Key("Select", ts, [
+        ("Current", REG_DWORD, struct.pack("<I", 1)),
+        ("Default", REG_DWORD, struct.pack("<I", 1)),
+    ]),
+    Key("ControlSet001", ts, list_kind="li", subkeys=[
+        Key("Control", ts, subkeys=[
+            Key("ComputerName", ts, subkeys=[
+                Key("ComputerName", ts, [("ComputerName", REG_SZ, sz("WS-042"))]),
+            ]),
+        ]),
+        Key("Services", ts, list_kind="ri", subkeys=services),
+    ]),
+    Key("Types", ts, subkeys=[Key("日本", ts, [("", REG_SZ, sz("unicode key"))])], values=[
+        ("", REG_SZ, sz("default")),
+        ("Binary", REG_BINARY, bytes(range(8))),
+        ("Dword", REG_DWORD, struct.pack("<I", 0xdeadbeef)),
+        ("DwordBE", REG_DWORD_BE, struct.pack(">I", 0x01020304)),
+        ("Qword", REG_QWORD, struct.pack("<Q", 1 << 40)),
+        ("Multi", REG_MULTI_SZ, sz("one") + sz("two") + "\0".encode("utf-16-le")),
+        ("Big", REG_BINARY, big),
+        ("名前", REG_SZ, sz("value")),
+    ]),
+])
+
+h = Hive()
+root_off = write_key(h, root, 0xffffffff, flags=0x2c)
+size = (len(h.data) + 8 + 4095) & ~4095
+free = size - len(h.data)
+h.data += struct.pack("<i", free) + b"\0" * (free - 4)
+h.data[0:32] = b"hbin" + struct.pack("<IIQQI", 0, size, 0, filetime(ts), 0)
+
+base = bytearray(4096)
+struct.pack_into("<4sIIQIIIIIII", base, 0, b"regf", 7, 7, filetime(ts), 1, 5, 0, 1, root_off, size, 1)
+fname = "\\??\\C:\\fixture\\SYSTEM".encode("utf-16-le")
+base[48:48 + len(fname)] = fname
+x = 0
+for i in range(127):
+    x ^= struct.unpack_from("<I", base, i * 4)[0]
+if x == 0xffffffff: x = 0xfffffffe
+if x == 0: x = 1
+struct.pack_into("<I", base, 508, x)
+open(sys.argv[1], "wb").write(bytes(base) + bytes(h.data))
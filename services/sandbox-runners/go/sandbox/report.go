@@ -0,0 +1,90 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// ReportFormat is the format of a report written with EmitReport.
+type ReportFormat string
+
+// Formats accepted by EmitReport.
+const (
+	ReportHTML     ReportFormat = "html"
+	ReportMarkdown ReportFormat = "markdown"
+	ReportPDF      ReportFormat = "pdf"
+)
+
+// reportFiles are the names of the reports inside OUTPUT_DIR.
+var reportFiles = map[ReportFormat]string{
+	ReportHTML:     "report.html",
+	ReportMarkdown: "report.md",
+	ReportPDF:      "report.pdf",
+}
+
+// ErrInvalidReport is returned by EmitReport for an unknown format or
+// content that does not match it.
+var ErrInvalidReport = errors.New("sandbox: invalid report")
+
+// ReportFile returns the name of the report of format inside OUTPUT_DIR,
+// e.g. "report.html".
+func ReportFile(format ReportFormat) (string, bool) {
+	name, ok := reportFiles[format]
+	return name, ok
+}
+
+// EmitReport writes content as the human-readable report of the job, in
+// OUTPUT_DIR/report.html, report.md or report.pdf, and registers it in the
+// manifest as an ArtifactReport with its format. The platform shows the
+// first report a job emitted in the case view; emitting a format again
+// replaces its file. HTML reports are displayed sandboxed, without their
+// scripts, and Markdown is rendered without raw HTML.
+func EmitReport(format ReportFormat, content []byte) error {
+	name, ok := reportFiles[format]
+	if !ok {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidReport, format)
+	}
+	if format == ReportPDF && !bytes.HasPrefix(content, []byte("%PDF-")) {
+		return fmt.Errorf("%w: not a PDF document", ErrInvalidReport)
+	}
+	if format != ReportPDF && !utf8.Valid(content) {
+		return fmt.Errorf("%w: %s is not valid UTF-8", ErrInvalidReport, format)
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".report-*")
+	if err != nil {
+		return fmt.Errorf("sandbox: report: %w", err)
+	}
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chmod(OutputMode())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("sandbox: report: %w", err)
+	}
+	sum, err := hashFile(filepath.Join(dir, name), HashSHA256)
+	if err != nil {
+		return fmt.Errorf("sandbox: hash report %s: %w", name, err)
+	}
+	return updateManifest(dir, Artifact{
+		Path:   name,
+		Kind:   ArtifactReport,
+		Format: format,
+		SHA256: sum,
+		Size:   int64(len(content)),
+	})
+}
@@ -0,0 +1,47 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmitReport(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitReport(ReportMarkdown, []byte("# Draft\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitReport(ReportPDF, []byte("%PDF-1.7\n...")); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitReport(ReportMarkdown, []byte("# Final\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "report.md")); string(data) != "# Final\n" {
+		t.Errorf("report.md = %q", data)
+	}
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Artifacts) != 2 {
+		t.Fatalf("artifacts = %+v", m.Artifacts)
+	}
+	md, pdf := m.Artifacts[0], m.Artifacts[1]
+	if md.Path != "report.md" || md.Kind != ArtifactReport || md.Format != ReportMarkdown || md.Size != 8 {
+		t.Errorf("markdown = %+v", md)
+	}
+	if pdf.Path != "report.pdf" || pdf.Format != ReportPDF {
+		t.Errorf("pdf = %+v", pdf)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(dir, ".report-*")); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	for format, content := range map[ReportFormat]string{"docx": "x", ReportPDF: "<html>", ReportHTML: "\xff\xfe"} {
+		if err := EmitReport(format, []byte(content)); !errors.Is(err, ErrInvalidReport) {
+			t.Errorf("%s %q: err = %v, want ErrInvalidReport", format, content, err)
+		}
+	}
+}
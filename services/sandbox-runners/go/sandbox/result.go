@@ -0,0 +1,145 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ResultsFile is the name of the findings file inside OUTPUT_DIR.
+const ResultsFile = "results.ndjson"
+
+// ErrEvidenceMismatch is returned when a result targets an evidence item
+// other than the one the sandbox was launched with.
+var ErrEvidenceMismatch = errors.New("sandbox: result evidence UID does not match EVIDENCE_UID")
+
+// Errors of the locations of a result.
+var (
+	ErrInvalidLocation    = errors.New("sandbox: invalid result location")
+	ErrLocationOutOfRange = errors.New("sandbox: result location beyond the end of the evidence")
+)
+
+// Location points at the bytes of an evidence item a finding was made
+// in, for the case view to show them. Offset and Length are in the
+// evidence as the script reads it with OpenEvidence: decompressed, and
+// from the start of its byte range.
+type Location struct {
+	EvidenceUID string `json:"evidence_uid"`
+	Offset      int64  `json:"offset"`
+	// Length is zero for a location that only marks an offset.
+	Length      int64  `json:"length,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Result is a single finding produced by a script.
+type Result struct {
+	EvidenceUID string   `json:"evidence_uid"`
+	Severity    Severity `json:"severity"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	// FindingKey identifies the finding across scripts, e.g.
+	// "ioc/domain/evil.example": the platform shows the findings of a
+	// case that share a key once, with the highest severity reported.
+	FindingKey string         `json:"finding_key,omitempty"`
+	Data       map[string]any `json:"data,omitempty"`
+	// Locations point back to where in the evidence the finding was made.
+	Locations []Location `json:"locations,omitempty"`
+	// Techniques tags the finding with MITRE ATT&CK techniques, e.g.
+	// "T1055"; see WithTechnique.
+	Techniques []string `json:"techniques,omitempty"`
+}
+
+func (r Result) validate() error {
+	if !r.Severity.Valid() {
+		return fmt.Errorf("%w %q", ErrInvalidSeverity, r.Severity)
+	}
+	for i, l := range r.Locations {
+		if l.EvidenceUID == "" || l.Offset < 0 || l.Length < 0 {
+			return fmt.Errorf("%w %d: evidence %q, offset %d, length %d", ErrInvalidLocation, i, l.EvidenceUID, l.Offset, l.Length)
+		}
+	}
+	return nil
+}
+
+// checkLocations fails with ErrLocationOutOfRange for a location of r past
+// the end of its evidence item, whose size sizes gives; items missing from
+// sizes are not checked.
+func (r Result) checkLocations(sizes map[string]int64) error {
+	for i, l := range r.Locations {
+		size, ok := sizes[l.EvidenceUID]
+		if ok && (l.Offset > size || l.Length > size-l.Offset) {
+			return fmt.Errorf("%w: location %d covers bytes %d to %d of evidence %s, which has %d",
+				ErrLocationOutOfRange, i, l.Offset, l.Offset+l.Length, l.EvidenceUID, size)
+		}
+	}
+	return nil
+}
+
+// ValidateResult checks r against the schema of results.ndjson, which the
+// orchestrator enforces on every line after the run, and its techniques
+// against ATT&CK, which the orchestrator turns into warnings. EmitResult
+// calls it.
+func ValidateResult(r Result) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	if err := r.checkTechniques(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("sandbox: invalid result: %w", err)
+	}
+	if err := validateRecord(ResultsFile, line); err != nil {
+		return fmt.Errorf("sandbox: invalid result: %w", err)
+	}
+	return nil
+}
+
+// EmitResult appends r as one line of results.ndjson in OUTPUT_DIR, once
+// ValidateResult accepts it. Past the cap of SANDBOX_MAX_FINDINGS it
+// returns ErrRecordLimit. It is safe for concurrent use.
+func EmitResult(r Result) error {
+	expected, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return err
+	}
+	if r.EvidenceUID != expected {
+		return fmt.Errorf("%w: got %q, want %q", ErrEvidenceMismatch, r.EvidenceUID, expected)
+	}
+	if err := ValidateResult(r); err != nil {
+		return err
+	}
+	return appendRecord(ResultsFile, r)
+}
+
+// ReadResults parses the findings in dir; a missing file means no
+// findings. Malformed lines, results with an invalid severity and results
+// that do not match the schema are skipped and reported in err, after the
+// results that could be read; RecordErrors lists them.
+func ReadResults(dir string) ([]Result, error) {
+	return readRecords(dir, ResultsFile, Result.validate)
+}
+
+// ReadResultsFor parses the findings in dir as ReadResults does, also
+// rejecting, with ErrLocationOutOfRange, the results with a location past
+// the end of its evidence item. sizes maps the UIDs of the evidence items
+// to their size as the script reads them; locations in other items are
+// not checked.
+func ReadResultsFor(dir string, sizes map[string]int64) ([]Result, error) {
+	results, _, err := ReadResultsUpTo(dir, sizes, 0)
+	return results, err
+}
+
+// ReadResultsUpTo parses the findings in dir as ReadResultsFor does, up
+// to limit of them when limit is positive; the lines after are neither
+// parsed nor checked. total counts the records of the file, read or not,
+// rejected ones included.
+func ReadResultsUpTo(dir string, sizes map[string]int64, limit int) (results []Result, total int, err error) {
+	return readRecordsUpTo(dir, ResultsFile, func(r Result) error {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		return r.checkLocations(sizes)
+	}, limit)
+}
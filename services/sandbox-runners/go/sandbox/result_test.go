@@ -0,0 +1,199 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func setupEnv(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv(EnvOutputDir, dir)
+	t.Setenv(EnvEvidenceUID, "ev-1")
+	t.Setenv(EnvCaseID, "case-1")
+	return dir
+}
+
+// readLines returns the record lines of the NDJSON file at path, after
+// the header line it checks.
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) == 0 || lines[0] != `{"sdk_version":"`+Version()+`"}` {
+		t.Fatalf("%s starts with %q, want the header line", path, lines)
+	}
+	return lines[1:]
+}
+
+func TestEmitResultConcurrent(t *testing.T) {
+	dir := setupEnv(t)
+
+	const n = 200
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := EmitResult(Result{
+				EvidenceUID: "ev-1",
+				Severity:    "high",
+				Title:       fmt.Sprintf("finding %d", i),
+				Data:        map[string]any{"index": i},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	path := filepath.Join(dir, ResultsFile)
+	lines := readLines(t, path)
+	if len(lines) != n {
+		t.Fatalf("got %d lines, want %d", len(lines), n)
+	}
+	for _, line := range lines {
+		var r Result
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("corrupt line %q: %v", line, err)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("mode = %o, want 0644", perm)
+	}
+}
+
+func TestEmitResultEvidenceMismatch(t *testing.T) {
+	dir := setupEnv(t)
+
+	err := EmitResult(Result{EvidenceUID: "other", Title: "x"})
+	if !errors.Is(err, ErrEvidenceMismatch) {
+		t.Fatalf("err = %v, want ErrEvidenceMismatch", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ResultsFile)); !os.IsNotExist(err) {
+		t.Errorf("results file should not exist, stat err = %v", err)
+	}
+}
+
+func TestEmitResultValidatesSeverity(t *testing.T) {
+	setupEnv(t)
+	for _, sev := range []Severity{"", "severe", "High"} {
+		if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: sev, Title: "x"}); !errors.Is(err, ErrInvalidSeverity) {
+			t.Errorf("severity %q: err = %v, want ErrInvalidSeverity", sev, err)
+		}
+	}
+	if sev, err := ParseSeverity(" High "); err != nil || sev != SeverityHigh {
+		t.Errorf("ParseSeverity = %q, %v", sev, err)
+	}
+	if SeverityInfo.Rank() >= SeverityLow.Rank() || SeverityHigh.Rank() >= SeverityCritical.Rank() || Severity("x").Rank() != 0 {
+		t.Error("severities are not ranked info < low < ... < critical")
+	}
+}
+
+func TestValidateResult(t *testing.T) {
+	valid := Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "x", Data: map[string]any{"pid": 4}}
+	if err := ValidateResult(valid); err != nil {
+		t.Errorf("valid result rejected: %v", err)
+	}
+	for name, r := range map[string]Result{
+		"no title":        {EvidenceUID: "ev-1", Severity: SeverityHigh},
+		"no evidence UID": {Severity: SeverityHigh, Title: "x"},
+	} {
+		if err := ValidateResult(r); err == nil {
+			t.Errorf("%s: result accepted", name)
+		}
+	}
+	for _, l := range []Location{{Offset: 4}, {EvidenceUID: "ev-1", Offset: -1}, {EvidenceUID: "ev-1", Length: -1}} {
+		r := valid
+		r.Locations = []Location{l}
+		if err := ValidateResult(r); !errors.Is(err, ErrInvalidLocation) {
+			t.Errorf("location %+v: err = %v, want ErrInvalidLocation", l, err)
+		}
+	}
+	if err := ValidateResult(Result{EvidenceUID: "ev-1", Title: "x"}); !errors.Is(err, ErrInvalidSeverity) {
+		t.Errorf("err = %v, want ErrInvalidSeverity", err)
+	}
+	if schema, err := RecordSchema(ResultsFile); err != nil || !json.Valid(schema) {
+		t.Errorf("RecordSchema = %s, %v", schema, err)
+	}
+}
+
+func TestReadResults(t *testing.T) {
+	dir := setupEnv(t)
+	EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "a", FindingKey: "ioc/ip/10.0.0.1"})
+	f, _ := os.OpenFile(filepath.Join(dir, ResultsFile), os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString("{not json\n{\"evidence_uid\":\"ev-1\",\"severity\":\"urgent\",\"title\":\"b\"}\n" +
+		"{\"evidence_uid\":\"ev-1\",\"severity\":\"high\",\"titel\":\"c\"}\n")
+	f.Close()
+
+	results, err := ReadResults(dir)
+	if len(results) != 1 || results[0].FindingKey != "ioc/ip/10.0.0.1" || results[0].Severity != SeverityLow {
+		t.Errorf("results = %+v", results)
+	}
+	if !errors.Is(err, ErrInvalidSeverity) {
+		t.Errorf("err = %v, want the malformed lines reported", err)
+	}
+	var lines []int
+	for _, e := range RecordErrors(err) {
+		lines = append(lines, e.Line)
+	}
+	// Line 1 is the header.
+	if !reflect.DeepEqual(lines, []int{3, 4, 5}) {
+		t.Errorf("rejected lines = %v, want 3, 4 and 5", lines)
+	}
+	if rejected := RecordErrors(err); len(rejected) == 3 && !strings.Contains(rejected[2].Error(), "titel") {
+		t.Errorf("schema error = %v, want the unknown property named", rejected[2])
+	}
+	if results, err := ReadResults(t.TempDir()); results != nil || err != nil {
+		t.Errorf("ReadResults(empty dir) = %v, %v", results, err)
+	}
+}
+
+func TestReadResultsChecksLocations(t *testing.T) {
+	dir := setupEnv(t)
+	at := func(locs ...Location) {
+		if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "x", Locations: locs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	at(Location{EvidenceUID: "ev-1", Offset: 512, Length: 512, Description: "MFT entry 0"})
+	at(Location{EvidenceUID: "ev-1", Offset: 1024})
+	at(Location{EvidenceUID: "ev-1", Offset: 1000, Length: 100})
+	at(Location{EvidenceUID: "ev-2", Offset: 1 << 40})
+
+	results, err := ReadResultsFor(dir, map[string]int64{"ev-1": 1024})
+	if len(results) != 3 || results[0].Locations[0].Description != "MFT entry 0" || results[2].Locations[0].EvidenceUID != "ev-2" {
+		t.Errorf("results = %+v, want those within ev-1 and the unchecked ev-2 one", results)
+	}
+	if rejected := RecordErrors(err); len(rejected) != 1 || rejected[0].Line != 4 || !errors.Is(err, ErrLocationOutOfRange) {
+		t.Errorf("err = %v, want line 4 rejected with ErrLocationOutOfRange", err)
+	}
+	if results, err := ReadResults(dir); len(results) != 4 || err != nil {
+		t.Errorf("ReadResults = %d results, %v, want locations left unchecked", len(results), err)
+	}
+}
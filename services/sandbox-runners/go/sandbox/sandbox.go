@@ -0,0 +1,121 @@
+// Package sandbox is the SDK for scripts running inside the datamortem
+// sandbox runners. It implements the output contract the platform ingests
+// on top of the environment variables injected at container start.
+package sandbox
+
+import "strconv"
+
+// Environment variables injected into every sandbox container.
+const (
+	EnvCaseID       = "CASE_ID"
+	EnvEvidenceUID  = "EVIDENCE_UID"
+	EnvEvidencePath = "EVIDENCE_PATH"
+	EnvOutputDir    = "OUTPUT_DIR"
+
+	// EnvEvidenceSHA256 carries the digest recorded at ingestion; despite
+	// its name it holds a digest of EnvEvidenceHashAlgo when that is set.
+	EnvEvidenceSHA256   = "EVIDENCE_SHA256"
+	EnvEvidenceHashAlgo = "EVIDENCE_HASH_ALGO"
+
+	// EnvEvidenceCompression is "gzip" or "zstd" when the evidence is
+	// stored compressed; OpenEvidence then decompresses it.
+	EnvEvidenceCompression = "EVIDENCE_COMPRESSION"
+
+	// EnvEvidenceOffset and EnvEvidenceLength limit the evidence to a byte
+	// range, e.g. a partition of a disk image, which OpenEvidence then
+	// reads as the whole evidence. Without EnvEvidenceLength the range
+	// runs to the end of the evidence.
+	EnvEvidenceOffset = "EVIDENCE_OFFSET"
+	EnvEvidenceLength = "EVIDENCE_LENGTH"
+
+	// EnvEvidenceType is the evidence type detected at ingestion or by the
+	// orchestrator, one of the EvidenceType constants, when it is known.
+	EnvEvidenceType = "EVIDENCE_TYPE"
+
+	// EnvEvidenceBlockDev is set when the orchestrator exposes a raw or
+	// EWF disk image as a read-only block device, for tools that prefer
+	// one to a file; EVIDENCE_PATH remains the image file.
+	EnvEvidenceBlockDev = "EVIDENCE_BLOCK_DEV"
+
+	// EnvEvidenceCount is set when several evidence items are mounted;
+	// each is then described by EVIDENCE_UID_<n> and EVIDENCE_PATH_<n>.
+	EnvEvidenceCount = "EVIDENCE_COUNT"
+
+	// EnvEvidenceFetchSocket is set when the script may fetch further
+	// evidence of its case with FetchEvidence.
+	EnvEvidenceFetchSocket = "EVIDENCE_FETCH_SOCKET"
+
+	// EnvFindingQuerySocket is set when the script may query the findings
+	// of its case with QueryFindings.
+	EnvFindingQuerySocket = "FINDING_QUERY_SOCKET"
+
+	// EnvEvidenceStreamSocket is set instead of EVIDENCE_PATH when the
+	// evidence is streamed from object storage: OpenEvidence reads it by
+	// ranges through this socket.
+	EnvEvidenceStreamSocket = "EVIDENCE_STREAM_SOCKET"
+
+	// EnvEvidenceMetaPath is set when the evidence comes with an
+	// acquisition sidecar, read with EvidenceMeta; EVIDENCE_META_PATH_<n>
+	// describes the n-th evidence item.
+	EnvEvidenceMetaPath = "EVIDENCE_META_PATH"
+
+	// EnvYaraRulesPath is set when the job comes with a YARA ruleset.
+	EnvYaraRulesPath = "YARA_RULES_PATH"
+
+	// EnvSharedDir is set when the job is given the shared directory of
+	// its case, read with SharedPath.
+	EnvSharedDir = "SHARED_DIR"
+
+	// EnvContextPath is the path of the read-only case context file, read
+	// with Context.
+	EnvContextPath = "SANDBOX_CONTEXT_PATH"
+
+	// Resource limits applied to the container, read with Limits.
+	EnvMemoryLimitBytes = "SANDBOX_MEMORY_LIMIT_BYTES"
+	EnvCPUCount         = "SANDBOX_CPU_COUNT"
+
+	// EnvScratchDir is the per-job scratch area of TempDir and TempFile.
+	EnvScratchDir = "SANDBOX_SCRATCH_DIR"
+
+	// EnvSecretsDir is set when the job is given secrets, one file per
+	// secret, read with Secret.
+	EnvSecretsDir = "SANDBOX_SECRETS_DIR"
+
+	// EnvRunTime is the logical start time of the job, in RFC 3339 UTC,
+	// read with Now.
+	EnvRunTime = "SANDBOX_RUN_TIME"
+
+	// EnvCaseTimezone is the IANA time zone of the case, e.g.
+	// "Europe/Paris", read with CaseLocation, and EnvCaseLocale its BCP 47
+	// locale, e.g. "fr-FR", read with CaseLocale. Both concern display only.
+	EnvCaseTimezone = "CASE_TIMEZONE"
+	EnvCaseLocale   = "CASE_LOCALE"
+
+	// EnvHeartbeatPath is the file the entrypoint of the runner images
+	// beats, with WriteHeartbeat, every EnvHeartbeatInterval, a Go
+	// duration such as "10s", for the orchestrator to tell a job whose
+	// worker died from one still running.
+	EnvHeartbeatPath     = "SANDBOX_HEARTBEAT_PATH"
+	EnvHeartbeatInterval = "SANDBOX_HEARTBEAT_INTERVAL"
+
+	// EnvSeed is the decimal seed of the job's random numbers, read with
+	// Rand.
+	EnvSeed = "SANDBOX_SEED"
+
+	// EnvOutputMode is the octal mode, e.g. "0640", of the files the SDK
+	// creates in OUTPUT_DIR, read with OutputMode.
+	EnvOutputMode = "SANDBOX_OUTPUT_MODE"
+
+	// Caps on the findings, artifacts and timeline events the orchestrator
+	// ingests from the job, unset without a cap. The emit helpers refuse
+	// the records past them with ErrRecordLimit.
+	EnvMaxFindings       = "SANDBOX_MAX_FINDINGS"
+	EnvMaxArtifacts      = "SANDBOX_MAX_ARTIFACTS"
+	EnvMaxTimelineEvents = "SANDBOX_MAX_TIMELINE_EVENTS"
+)
+
+// IndexedEnv returns the name of the n-th instance of an indexed variable,
+// e.g. IndexedEnv(EnvEvidencePath, 1) is "EVIDENCE_PATH_1".
+func IndexedEnv(key string, n int) string {
+	return key + "_" + strconv.Itoa(n)
+}
@@ -0,0 +1,154 @@
+package sandbox
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFS holds the JSON schemas of the NDJSON output files and of the
+// evidence metadata sidecar, which the platform shares with scripts
+// written in other languages.
+//
+//go:embed schema/*.json
+var schemaFS embed.FS
+
+// recordSchemas maps each NDJSON output file to its schema in schemaFS.
+var recordSchemas = map[string]string{
+	ResultsFile:  "schema/result.schema.json",
+	TimelineFile: "schema/timeline_event.schema.json",
+	IOCsFile:     "schema/ioc.schema.json",
+	FactsFile:    "schema/fact.schema.json",
+	WarningsFile: "schema/warning.schema.json",
+	GraphFile:    "schema/graph_edge.schema.json",
+	// The sidecar is a single document rather than NDJSON.
+	EvidenceMetaFile: "schema/evidence_meta.schema.json",
+}
+
+var (
+	compileSchemas sync.Once
+	schemas        map[string]*jsonschema.Schema
+	schemasErr     error
+)
+
+// RecordSchema returns the JSON schema of the lines of file, ResultsFile,
+// TimelineFile, IOCsFile, FactsFile, WarningsFile or GraphFile, or of the
+// evidence metadata sidecar, EvidenceMetaFile.
+func RecordSchema(file string) ([]byte, error) {
+	name, ok := recordSchemas[file]
+	if !ok {
+		return nil, fmt.Errorf("sandbox: no schema for %s", file)
+	}
+	return schemaFS.ReadFile(name)
+}
+
+// RecordError reports a line of an NDJSON output file that was rejected.
+type RecordError struct {
+	File string
+	Line int
+	// Record is the line as written.
+	Record string
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("%s line %d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// RecordErrors returns the rejected lines reported in err, as returned by
+// ReadResults, ReadTimeline, ReadIOCs, ReadFacts, ReadWarnings and
+// ReadGraph.
+func RecordErrors(err error) []*RecordError {
+	var records []*RecordError
+	var walk func(error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case *RecordError:
+			records = append(records, e)
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	return records
+}
+
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	compileSchemas.Do(func() {
+		c := jsonschema.NewCompiler()
+		schemas = map[string]*jsonschema.Schema{}
+		for file, name := range recordSchemas {
+			data, err := schemaFS.ReadFile(name)
+			if err != nil {
+				schemasErr = err
+				return
+			}
+			if err := c.AddResource(name, bytes.NewReader(data)); err != nil {
+				schemasErr = err
+				return
+			}
+			if schemas[file], err = c.Compile(name); err != nil {
+				schemasErr = err
+				return
+			}
+		}
+	})
+	return schemas, schemasErr
+}
+
+// validateRecord checks a line of file against the file's schema.
+func validateRecord(file string, line []byte) error {
+	all, err := loadSchemas()
+	if err != nil {
+		return fmt.Errorf("sandbox: load schemas: %w", err)
+	}
+	schema, ok := all[file]
+	if !ok {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(line, &v); err != nil {
+		return err
+	}
+	if err := schema.Validate(v); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			return schemaError(verr)
+		}
+		return err
+	}
+	return nil
+}
+
+// schemaError flattens a validation error into its innermost causes, e.g.
+// `/title: length must be >= 1, but got 0`.
+func schemaError(verr *jsonschema.ValidationError) error {
+	var causes []string
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			causes = append(causes, loc+": "+e.Message)
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(verr)
+	return errors.New("schema: " + strings.Join(causes, "; "))
+}
@@ -0,0 +1,28 @@
+package sandbox
+
+import "os"
+
+// scratchDir returns SANDBOX_SCRATCH_DIR, or the system temporary
+// directory of a run outside the sandbox.
+func scratchDir() string {
+	if dir := os.Getenv(EnvScratchDir); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// TempDir creates a new directory in the job's scratch area and returns its
+// path. The scratch area is not OUTPUT_DIR, so nothing in it is ingested,
+// and the orchestrator discards it when the job ends; writes beyond its
+// quota fail with ENOSPC.
+func TempDir() (string, error) {
+	return os.MkdirTemp(scratchDir(), "tmp-")
+}
+
+// TempFile creates a new file in the job's scratch area, as TempDir does a
+// directory, and opens it for reading and writing. Its name follows
+// pattern as in os.CreateTemp, e.g. "hive-*.dat", so that concurrent
+// parsers never collide.
+func TempFile(pattern string) (*os.File, error) {
+	return os.CreateTemp(scratchDir(), pattern)
+}
@@ -0,0 +1,39 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTempFileInScratchDir(t *testing.T) {
+	out := setupEnv(t)
+	scratch := t.TempDir()
+	t.Setenv(EnvScratchDir, scratch)
+
+	f, err := TempFile("hive-*.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	g, err := TempFile("hive-*.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if filepath.Dir(f.Name()) != scratch || f.Name() == g.Name() || !strings.HasSuffix(f.Name(), ".dat") {
+		t.Errorf("temp files = %s, %s; want distinct files in %s", f.Name(), g.Name(), scratch)
+	}
+
+	dir, err := TempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || filepath.Dir(dir) != scratch {
+		t.Errorf("temp dir = %s, want a directory in %s", dir, scratch)
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 0 {
+		t.Errorf("OUTPUT_DIR has %d entries, want none", len(entries))
+	}
+}
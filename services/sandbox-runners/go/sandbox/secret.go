@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrSecretNotFound is returned by Secret for a secret the job was not
+// given.
+var ErrSecretNotFound = errors.New("sandbox: secret not found")
+
+// ErrInvalidSecretName is returned for a secret name that is not a plain
+// file name of letters, digits and "._-".
+var ErrInvalidSecretName = errors.New("sandbox: invalid secret name")
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateSecretName checks that name, e.g. "virustotal_api_key", can name
+// a secret.
+func ValidateSecretName(name string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidSecretName, name)
+	}
+	return nil
+}
+
+// Secret returns the value of the job's secret name, e.g. an API key of an
+// enrichment service. Secrets are files on an in-memory filesystem under
+// SANDBOX_SECRETS_DIR rather than environment variables, so that they are
+// not inherited by child processes nor shown in a dump of the environment;
+// the orchestrator masks their values in the logs it keeps, but a script
+// should still not print them nor write them to OUTPUT_DIR.
+func Secret(name string) (string, error) {
+	if err := ValidateSecretName(name); err != nil {
+		return "", err
+	}
+	dir := os.Getenv(EnvSecretsDir)
+	if dir == "" {
+		return "", fmt.Errorf("%w: %s (the job has no secrets)", ErrSecretNotFound, name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sandbox: read secret %s: %w", name, err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,33 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "vt_api_key"), []byte("s3cr3t-k3y"), 0o400); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvSecretsDir, dir)
+
+	if v, err := Secret("vt_api_key"); err != nil || v != "s3cr3t-k3y" {
+		t.Errorf("Secret = %q, %v", v, err)
+	}
+	if _, err := Secret("otx_api_key"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("missing secret err = %v, want ErrSecretNotFound", err)
+	}
+	for _, name := range []string{"", "../vt_api_key", ".hidden", "a/b"} {
+		if _, err := Secret(name); !errors.Is(err, ErrInvalidSecretName) {
+			t.Errorf("Secret(%q) err = %v, want ErrInvalidSecretName", name, err)
+		}
+	}
+
+	t.Setenv(EnvSecretsDir, "")
+	if _, err := Secret("vt_api_key"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("err without secrets = %v, want ErrSecretNotFound", err)
+	}
+}
@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Severity ranks a finding, from SeverityInfo to SeverityCritical.
+type Severity string
+
+// Severities accepted in Result.Severity.
+const (
+	SeverityInfo     Severity = "info"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// ErrInvalidSeverity is returned for a result whose severity is not one of
+// the Severity constants.
+var ErrInvalidSeverity = errors.New("sandbox: invalid severity")
+
+var severityRanks = map[Severity]int{
+	SeverityInfo:     1,
+	SeverityLow:      2,
+	SeverityMedium:   3,
+	SeverityHigh:     4,
+	SeverityCritical: 5,
+}
+
+// ParseSeverity returns the Severity named s, in any case.
+func ParseSeverity(s string) (Severity, error) {
+	sev := Severity(strings.ToLower(strings.TrimSpace(s)))
+	if !sev.Valid() {
+		return "", fmt.Errorf("%w %q", ErrInvalidSeverity, s)
+	}
+	return sev, nil
+}
+
+// Valid reports whether s is one of the Severity constants.
+func (s Severity) Valid() bool {
+	_, ok := severityRanks[s]
+	return ok
+}
+
+// Rank orders severities, from 1 for SeverityInfo to 5 for
+// SeverityCritical; it is 0 for an invalid severity.
+func (s Severity) Rank() int {
+	return severityRanks[s]
+}
@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSharedDir is returned by the shared directory helpers when
+// SHARED_DIR is unset: the job was not given the shared directory of its
+// case.
+var ErrNoSharedDir = errors.New("sandbox: no shared directory (SHARED_DIR is unset)")
+
+// sharedLockSuffix names the lock file of LockShared next to its file.
+const sharedLockSuffix = ".lock"
+
+// SharedPath returns the path of name in the shared directory of the job's
+// case, checked by SafeJoin, e.g. for a string table that an enrichment
+// stage builds once and the later stages of a pipeline reuse:
+//
+//	path, err := sandbox.SharedPath("strings.idx") // /shared/strings.idx
+//
+// Every job of the case given the directory sees the same files, the
+// stages of a pipeline and the children of a fan-out alike, possibly at
+// the same time: build a shared file under LockShared, and write it with
+// WriteShared so that readers who do not lock never see part of it. Files
+// are not namespaced by evidence: name them after what they derive from.
+// Directories in name are not created.
+func SharedPath(name string) (string, error) {
+	dir := os.Getenv(EnvSharedDir)
+	if dir == "" {
+		return "", ErrNoSharedDir
+	}
+	return SafeJoin(dir, name)
+}
+
+// WriteShared replaces the shared file name, as SharedPath resolves it,
+// with data atomically: it writes a temporary file in the same directory,
+// with the mode of OutputMode, and renames it into place.
+func WriteShared(name string, data []byte) error {
+	path, err := SharedPath(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".shared-*")
+	if err != nil {
+		return fmt.Errorf("sandbox: shared %s: %w", name, err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(OutputMode())
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("sandbox: shared %s: %w", name, err)
+	}
+	return nil
+}
+
+// LockShared waits for and takes an exclusive lock on the shared file
+// name, held in name+".lock", and returns the function that releases it.
+// Jobs that would each compute the same shared file take the lock, check
+// whether a job before them wrote it, and otherwise build it:
+//
+//	unlock, err := sandbox.LockShared("strings.idx")
+//	if err != nil {
+//		return err
+//	}
+//	defer unlock()
+//	path, _ := sandbox.SharedPath("strings.idx")
+//	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+//		err = sandbox.WriteShared("strings.idx", buildIndex())
+//	}
+//
+// The lock is advisory: it only excludes the jobs that take it. It is
+// released when the script exits, killed by a timeout included, so that a
+// lock is never left behind.
+func LockShared(name string) (unlock func() error, err error) {
+	path, err := SharedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+sharedLockSuffix, os.O_RDWR|os.O_CREATE, OutputMode())
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: lock shared %s: %w", name, err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("sandbox: lock shared %s: %w", name, err)
+	}
+	return func() error {
+		// Closing the file releases the lock.
+		return f.Close()
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile fails where flock does not exist; the sandbox runners are Linux
+// containers.
+func lockFile(f *os.File) error {
+	return errors.New("file locks need a Unix system")
+}
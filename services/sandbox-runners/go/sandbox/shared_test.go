@@ -0,0 +1,74 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSharedPath(t *testing.T) {
+	t.Setenv(EnvSharedDir, "")
+	if _, err := SharedPath("strings.idx"); !errors.Is(err, ErrNoSharedDir) {
+		t.Errorf("SharedPath() without %s = %v, want ErrNoSharedDir", EnvSharedDir, err)
+	}
+	if err := WriteShared("strings.idx", nil); !errors.Is(err, ErrNoSharedDir) {
+		t.Errorf("WriteShared() without %s = %v, want ErrNoSharedDir", EnvSharedDir, err)
+	}
+	dir := t.TempDir()
+	t.Setenv(EnvSharedDir, dir)
+	if path, err := SharedPath("strings.idx"); err != nil || path != filepath.Join(dir, "strings.idx") {
+		t.Errorf("SharedPath() = %q, %v", path, err)
+	}
+	if _, err := SharedPath("../other-case/strings.idx"); !errors.Is(err, ErrPathEscape) {
+		t.Errorf("SharedPath() out of the directory = %v, want ErrPathEscape", err)
+	}
+
+	if err := WriteShared("strings.idx", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteShared("strings.idx", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if data, _ := os.ReadFile(filepath.Join(dir, "strings.idx")); string(data) != "v2" || len(entries) != 1 {
+		t.Errorf("shared file %q, %d entries", data, len(entries))
+	}
+}
+
+func TestLockShared(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no flock")
+	}
+	t.Setenv(EnvSharedDir, t.TempDir())
+	unlock, err := LockShared("strings.idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan func() error)
+	go func() {
+		unlock, err := LockShared("strings.idx")
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lock taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case unlock := <-locked:
+		if unlock != nil {
+			unlock()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not released")
+	}
+}
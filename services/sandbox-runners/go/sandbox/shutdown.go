@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ShutdownExitCode is the exit status of a script stopped by SIGTERM once
+// its shutdown handlers have run, as if the signal had killed it.
+const ShutdownExitCode = 128 + int(syscall.SIGTERM)
+
+var (
+	shutdownMu       sync.Mutex
+	shutdownHandlers []func()
+	shutdownSignals  chan os.Signal
+)
+
+// exit is os.Exit, replaced in tests.
+var exit = os.Exit
+
+// OnShutdown registers fn to run when the script receives SIGTERM or
+// SIGINT, which the orchestrator sends on timeout or cancellation, so
+// that it can emit the findings it has accumulated before the grace
+// period (ExecConfig.GracePeriod) ends in SIGKILL. Handlers run once, last
+// registered first; the script then exits with ShutdownExitCode.
+// Results, timeline events and the artifact manifest are written as they
+// are emitted, and a TimelineWriter closes itself: handlers only need to
+// flush what the script buffers itself.
+func OnShutdown(fn func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	shutdownHandlers = append(shutdownHandlers, fn)
+	if shutdownSignals != nil {
+		return
+	}
+	shutdownSignals = make(chan os.Signal, 1)
+	signal.Notify(shutdownSignals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-shutdownSignals
+		shutdown()
+		exit(ShutdownExitCode)
+	}()
+}
+
+// shutdown runs the registered handlers, last registered first.
+func shutdown() {
+	shutdownMu.Lock()
+	handlers := append([]func(){}, shutdownHandlers...)
+	shutdownMu.Unlock()
+	for i := len(handlers) - 1; i >= 0; i-- {
+		runShutdownHandler(handlers[i])
+	}
+}
+
+// runShutdownHandler runs fn, logging a panic so that the other handlers
+// still run.
+func runShutdownHandler(fn func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("sandbox: shutdown handler panicked: %v", p)
+		}
+	}()
+	fn()
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOnShutdownFlushesOnSIGTERM(t *testing.T) {
+	dir := setupEnv(t)
+	codes := make(chan int, 1)
+	exit = func(code int) { codes <- code }
+	t.Cleanup(func() { exit = os.Exit })
+
+	// The script buffers its findings until it has scanned everything.
+	buffered := []Result{
+		{EvidenceUID: "ev-1", Severity: SeverityHigh, Title: "first"},
+		{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "second"},
+	}
+	var order []string
+	OnShutdown(func() {
+		order = append(order, "manifest")
+	})
+	OnShutdown(func() { panic("broken handler") })
+	OnShutdown(func() {
+		order = append(order, "results")
+		for _, r := range buffered {
+			if err := EmitResult(r); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-codes:
+		if code != ShutdownExitCode {
+			t.Errorf("exit code = %d, want %d", code, ShutdownExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown handlers did not run")
+	}
+	if len(order) != 2 || order[0] != "results" || order[1] != "manifest" {
+		t.Errorf("handlers ran in order %v, want the last registered first", order)
+	}
+	if lines := readLines(t, filepath.Join(dir, ResultsFile)); len(lines) != 2 {
+		t.Errorf("%d results flushed, want 2", len(lines))
+	}
+}
@@ -0,0 +1,321 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// DefaultTimeout is the timeout of a Query that sets none.
+const DefaultTimeout = 30 * time.Second
+
+// Query selects the rows of a table. It is plain Go rather than SQL: the
+// rows are read in rowid order, and the script filters them with Where.
+type Query struct {
+	// Table is the table read, matched without regard to case.
+	Table string
+	// Columns are the columns of the rows returned, in that order; all
+	// the columns of the table when empty. Where sees all of them.
+	Columns []string
+	// Where selects the rows returned; all of them when nil.
+	Where func(Row) bool
+	// Limit caps the rows returned, unlimited when zero.
+	Limit int
+	// Timeout bounds the query, DefaultTimeout when zero: past it, Query
+	// fails with context.DeadlineExceeded.
+	Timeout time.Duration
+	// Deleted also returns, after the rows of the table, those recovered
+	// from the leaf pages of the freelist that have as many columns as the
+	// table, with Row.Deleted set. A recovered row may come from another
+	// table of as many columns, or have been updated since; Where is the
+	// place to check that its values are plausible.
+	Deleted bool
+}
+
+// Row is a row of a table.
+type Row struct {
+	RowID int64
+	// Columns are the names of the values.
+	Columns []string
+	// Values are nil, int64, float64, string or []byte, as stored: SQLite
+	// does not enforce the declared type of a column. A row written before
+	// a column was added lacks its value, nil here whatever its default.
+	Values []any
+	// Offset and Length locate the row in the database file, overflow
+	// pages excluded.
+	Offset, Length int64
+	// Deleted reports a row recovered from the freelist.
+	Deleted bool
+}
+
+// Value returns the value of the column name, matched without regard to
+// case, or nil.
+func (r Row) Value(name string) any {
+	t := Table{Columns: r.Columns}
+	if i := t.column(name); i >= 0 {
+		return r.Values[i]
+	}
+	return nil
+}
+
+// Int returns the value of the column name as an integer, reporting
+// whether it is one.
+func (r Row) Int(name string) (int64, bool) {
+	v, ok := r.Value(name).(int64)
+	return v, ok
+}
+
+// Text returns the value of the column name as a string: a TEXT value, or
+// a BLOB one as is; "" for another type.
+func (r Row) Text(name string) string {
+	switch v := r.Value(name).(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Map returns the values of the row by column name, BLOB values as is.
+func (r Row) Map() map[string]any {
+	m := make(map[string]any, len(r.Columns))
+	for i, c := range r.Columns {
+		m[c] = r.Values[i]
+	}
+	return m
+}
+
+// Query calls fn with each row selected by q, stopping at the first error
+// of fn, which it returns.
+func (db *DB) Query(ctx context.Context, q Query, fn func(Row) error) error {
+	timeout := q.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	t, err := db.Table(ctx, q.Table)
+	if err != nil {
+		return err
+	}
+	if t.err != nil {
+		return t.err
+	}
+	project := make([]int, len(q.Columns))
+	for i, name := range q.Columns {
+		if project[i] = t.column(name); project[i] < 0 {
+			return fmt.Errorf("%w: column %s of table %s", ErrNotFound, name, t.Name)
+		}
+	}
+	n := 0
+	emit := func(c cell, deleted bool) error {
+		values, err := db.record(c.payload)
+		if err != nil {
+			return fmt.Errorf("row %d: %w", c.rowid, err)
+		}
+		if deleted && len(values) != len(t.Columns) {
+			return nil
+		}
+		row := Row{RowID: c.rowid, Columns: t.Columns, Values: make([]any, len(t.Columns)), Offset: c.offset, Length: c.length, Deleted: deleted}
+		copy(row.Values, values)
+		if t.rowid >= 0 && row.Values[t.rowid] == nil {
+			row.Values[t.rowid] = c.rowid
+		}
+		for i, v := range row.Values {
+			if n, ok := v.(int64); ok && i < len(t.real) && t.real[i] {
+				row.Values[i] = float64(n)
+			}
+		}
+		if q.Where != nil && !q.Where(row) {
+			return nil
+		}
+		if len(project) > 0 {
+			all := row.Values
+			row.Columns, row.Values = q.Columns, make([]any, len(project))
+			for i, j := range project {
+				row.Values[i] = all[j]
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+		if n++; q.Limit > 0 && n >= q.Limit {
+			return errLimit
+		}
+		return nil
+	}
+	err = db.walk(t.root, map[uint32]bool{}, ctx.Err, func(c cell) error { return emit(c, false) })
+	if err == nil && q.Deleted {
+		err = db.freeCells(ctx, func(c cell) error { return emit(c, true) })
+	}
+	if errors.Is(err, errLimit) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("sqlite: table %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+// errLimit stops a query at its Limit.
+var errLimit = errors.New("sqlite: limit reached")
+
+// freeCells calls fn with the cells of the freelist leaf pages that still
+// hold a table b-tree leaf, as SQLite leaves them unless secure_delete is
+// on. Cells that do not parse are skipped.
+func (db *DB) freeCells(ctx context.Context, fn func(cell) error) error {
+	leaves, err := db.freeLeaves(ctx)
+	if err != nil {
+		return err
+	}
+	for _, n := range leaves {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		if data[0] != pageLeafTable {
+			continue
+		}
+		p, err := db.parseBtree(n, data)
+		if err != nil {
+			continue
+		}
+		for _, off := range p.cells {
+			c, err := db.leafCell(p, off)
+			if err != nil {
+				continue
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// freeLeaves returns the leaf pages of the freelist, read from its chain
+// of trunk pages.
+func (db *DB) freeLeaves(ctx context.Context) ([]uint32, error) {
+	var leaves []uint32
+	seen := map[uint32]bool{}
+	for trunk := db.freelist; trunk != 0; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if seen[trunk] || uint32(len(seen)+len(leaves)) >= db.freePages {
+			return nil, fmt.Errorf("%w: freelist longer than its %d pages", ErrCorrupt, db.freePages)
+		}
+		seen[trunk] = true
+		data, err := db.page(trunk)
+		if err != nil {
+			return nil, err
+		}
+		count := int(binary.BigEndian.Uint32(data[4:]))
+		if count > db.usable/4-2 {
+			return nil, fmt.Errorf("%w: freelist trunk page %d lists %d pages", ErrCorrupt, trunk, count)
+		}
+		for i := 0; i < count; i++ {
+			leaves = append(leaves, binary.BigEndian.Uint32(data[8+4*i:]))
+		}
+		trunk = binary.BigEndian.Uint32(data)
+	}
+	return leaves, nil
+}
+
+// EmitFindings runs q and emits with sandbox.EmitResult the finding fn
+// makes of each row, skipping the rows for which it returns false, and
+// returns how many it emitted. A finding without Data gets the values of
+// the row, and one without Locations, when the database is the
+// evidence opened by OpenEvidence, is located at the row.
+func EmitFindings(ctx context.Context, db *DB, q Query, fn func(Row) (sandbox.Result, bool)) (int, error) {
+	uid, err := sandbox.MustGetEnv(sandbox.EnvEvidenceUID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = db.Query(ctx, q, func(row Row) error {
+		r, ok := fn(row)
+		if !ok {
+			return nil
+		}
+		if r.EvidenceUID == "" {
+			r.EvidenceUID = uid
+		}
+		if r.Data == nil {
+			r.Data = row.Map()
+		}
+		if r.Locations == nil && db.evidence {
+			r.Locations = []sandbox.Location{{
+				EvidenceUID: uid,
+				Offset:      row.Offset,
+				Length:      row.Length,
+				Description: fmt.Sprintf("row %d of table %s", row.RowID, q.Table),
+			}}
+		}
+		if err := sandbox.EmitResult(r); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// EmitTimeline runs q and emits with sandbox.EmitTimelineEvent the event
+// fn makes of each row, skipping the rows for which it returns false, and
+// returns how many it emitted. An event without Fields gets the values of
+// the row, and its Source defaults to the table.
+func EmitTimeline(ctx context.Context, db *DB, q Query, fn func(Row) (sandbox.TimelineEvent, bool)) (int, error) {
+	n := 0
+	err := db.Query(ctx, q, func(row Row) error {
+		e, ok := fn(row)
+		if !ok {
+			return nil
+		}
+		if e.Source == "" {
+			e.Source = "sqlite/" + q.Table
+		}
+		if e.Fields == nil {
+			e.Fields = row.Map()
+		}
+		if err := sandbox.EmitTimelineEvent(e.Time, e.Source, e.Message, e.Fields); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// webkitEpoch is the epoch of WebKitTime, 1601-01-01, in Unix seconds.
+const webkitEpoch = -11644473600
+
+// WebKitTime converts a timestamp of Chromium-based browsers, in
+// microseconds since 1601-01-01 UTC, e.g. urls.last_visit_time of a
+// History database. Zero gives the zero time.
+func WebKitTime(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.Unix(webkitEpoch+us/1e6, us%1e6*1e3).UTC()
+}
+
+// cocoaEpoch is the epoch of CocoaTime, 2001-01-01, in Unix seconds.
+const cocoaEpoch = 978307200
+
+// CocoaTime converts a timestamp of Apple platforms, in seconds since
+// 2001-01-01 UTC, e.g. of iOS databases. Zero gives the zero time.
+func CocoaTime(s float64) time.Time {
+	if s == 0 {
+		return time.Time{}
+	}
+	return time.Unix(cocoaEpoch, 0).Add(time.Duration(s * float64(time.Second))).UTC()
+}
@@ -0,0 +1,147 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// fixtureTime is the last visit of the first URL of the fixture.
+var fixtureTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestQuery(t *testing.T) {
+	db := openData(t, readFixture(t))
+	got := rows(t, db, Query{
+		Table:   "URLS",
+		Columns: []string{"url", "id"},
+		Where:   func(r Row) bool { n, _ := r.Int("visit_count"); return n >= 3 },
+		Limit:   2,
+	})
+	if len(got) != 2 || got[0].Text("url") != "https://example.com/" || got[0].Values[1] != int64(1) ||
+		got[1].Text("url") != "https://docs.example.org/guide" || got[1].RowID != 3 {
+		t.Errorf("rows %+v", got)
+	}
+	if first := rows(t, db, Query{Table: "urls", Limit: 1})[0]; !WebKitTime(first.Values[4].(int64)).Equal(fixtureTime) {
+		t.Errorf("last visit %v, want %v", WebKitTime(first.Values[4].(int64)), fixtureTime)
+	}
+	if err := db.Query(context.Background(), Query{Table: "urls", Columns: []string{"typed_count"}}, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown column: %v, want ErrNotFound", err)
+	}
+	if err := db.Query(context.Background(), Query{Table: "downloads"}, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown table: %v, want ErrNotFound", err)
+	}
+	stop := errors.New("stop")
+	if err := db.Query(context.Background(), Query{Table: "urls"}, func(Row) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Query() = %v, want the error of fn", err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db := openData(t, readFixture(t))
+	err := db.Query(context.Background(), Query{Table: "urls", Timeout: time.Millisecond}, func(Row) error {
+		time.Sleep(2 * time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Query() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestQueryDeleted(t *testing.T) {
+	db := openData(t, readFixture(t))
+	live, deleted := map[int64]bool{}, map[int64]bool{}
+	for _, r := range rows(t, db, Query{Table: "cache", Deleted: true}) {
+		hits, _ := r.Int("hits")
+		if r.Deleted {
+			deleted[hits] = true
+			if !strings.HasPrefix(r.Text("key"), "key-") {
+				t.Errorf("recovered row %v", r.Values)
+			}
+		} else {
+			live[hits] = true
+		}
+	}
+	if len(live) != 20 {
+		t.Errorf("%d live rows, want 20", len(live))
+	}
+	// Rows deleted from pages left in use are not recovered.
+	if len(deleted) < 300 {
+		t.Errorf("%d rows recovered from the freelist", len(deleted))
+	}
+	for hits := range deleted {
+		if hits < 20 {
+			t.Errorf("row %d recovered, but not deleted", hits)
+		}
+	}
+}
+
+func TestEmit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "History")
+	data := readFixture(t)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(sandbox.EnvEvidencePath, path)
+	t.Setenv(sandbox.EnvEvidenceUID, "ev-1")
+	t.Setenv(sandbox.EnvCaseID, "case-1")
+	t.Setenv(sandbox.EnvOutputDir, out)
+
+	db, err := OpenEvidence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	n, err := EmitFindings(ctx, db, Query{Table: "urls", Columns: []string{"url", "title"}}, func(r Row) (sandbox.Result, bool) {
+		return sandbox.Result{Severity: sandbox.SeverityHigh, Title: "Phishing page visited"}, strings.Contains(r.Text("url"), "evil")
+	})
+	if n != 1 || err != nil {
+		t.Fatalf("EmitFindings() = %d, %v", n, err)
+	}
+	results, err := sandbox.ReadResultsFor(out, map[string]int64{"ev-1": int64(len(data))})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("results %+v, %v", results, err)
+	}
+	r := results[0]
+	if r.EvidenceUID != "ev-1" || r.Data["url"] != "https://login.evil.example/phish" || r.Data["title"] != "Sign in" || len(r.Data) != 2 {
+		t.Errorf("result %+v", r)
+	}
+	if l := r.Locations[0]; l.EvidenceUID != "ev-1" || !strings.Contains(string(data[l.Offset:l.Offset+l.Length]), "login.evil.example") {
+		t.Errorf("located at %+v", l)
+	}
+
+	n, err = EmitTimeline(ctx, db, Query{Table: "urls", Limit: 3}, func(r Row) (sandbox.TimelineEvent, bool) {
+		visited, _ := r.Int("last_visit_time")
+		return sandbox.TimelineEvent{Time: WebKitTime(visited), Message: "Visited " + r.Text("url")}, true
+	})
+	if n != 3 || err != nil {
+		t.Fatalf("EmitTimeline() = %d, %v", n, err)
+	}
+	events, err := sandbox.ReadTimeline(out)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("events %+v, %v", events, err)
+	}
+	if e := events[1]; e.Source != "sqlite/urls" || !e.Time.Equal(fixtureTime.Add(time.Minute)) || e.Message != "Visited https://login.evil.example/phish" || e.Fields["title"] != "Sign in" {
+		t.Errorf("event %+v", e)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	if got := CocoaTime(730987200); !got.Equal(fixtureTime) {
+		t.Errorf("CocoaTime() = %v, want %v", got, fixtureTime)
+	}
+	if !WebKitTime(0).IsZero() || !CocoaTime(0).IsZero() {
+		t.Error("zero timestamps are not the zero time")
+	}
+}
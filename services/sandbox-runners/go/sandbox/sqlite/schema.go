@@ -0,0 +1,260 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Table is a table of the database, as its schema describes it.
+type Table struct {
+	Name string
+	// Columns are the names of the columns, in the order of CREATE TABLE.
+	Columns []string
+	// SQL is the CREATE TABLE statement of the table.
+	SQL string
+
+	root uint32
+	// rowid is the index of the INTEGER PRIMARY KEY column, an alias of
+	// the rowid stored as NULL in the records, or -1.
+	rowid int
+	// real marks the columns of REAL affinity, whose integral values
+	// SQLite stores as integers.
+	real []bool
+	err  error
+}
+
+// schemaRoot is the root page of the schema table, sqlite_schema.
+const schemaRoot = 1
+
+// withoutRowid matches the WITHOUT ROWID option ending a CREATE TABLE.
+var withoutRowid = regexp.MustCompile(`(?i)\)\s*(,\s*strict\s*)?without\s+rowid\s*(,\s*strict\s*)?;?\s*$`)
+
+// Tables returns the tables of the database, in the order of its schema.
+// Virtual tables and tables WITHOUT ROWID are listed, but querying them
+// fails with ErrUnsupported.
+func (db *DB) Tables(ctx context.Context) ([]Table, error) {
+	var tables []Table
+	err := db.walk(schemaRoot, map[uint32]bool{}, ctx.Err, func(c cell) error {
+		values, err := db.record(c.payload)
+		if err != nil {
+			return err
+		}
+		if len(values) < 5 {
+			return fmt.Errorf("%w: schema row %d has %d columns", ErrCorrupt, c.rowid, len(values))
+		}
+		kind, _ := values[0].(string)
+		name, _ := values[1].(string)
+		root, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+		if kind != "table" {
+			return nil
+		}
+		t := Table{Name: name, SQL: sql, rowid: -1}
+		switch {
+		case root <= 0 || strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sql)), "CREATE VIRTUAL"):
+			t.err = fmt.Errorf("%w: %s is a virtual table", ErrUnsupported, name)
+		case withoutRowid.MatchString(sql):
+			t.err = fmt.Errorf("%w: %s is a table WITHOUT ROWID", ErrUnsupported, name)
+		case root > int64(db.pages):
+			t.err = fmt.Errorf("%w: table %s at page %d of %d", ErrCorrupt, name, root, db.pages)
+		default:
+			t.root = uint32(root)
+		}
+		t.Columns, t.rowid, t.real = parseColumns(sql)
+		tables = append(tables, t)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: schema: %w", err)
+	}
+	return tables, nil
+}
+
+// Table returns the table name, matched without regard to case as SQLite
+// does.
+func (db *DB) Table(ctx context.Context, name string) (*Table, error) {
+	tables, err := db.Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tables {
+		if strings.EqualFold(tables[i].Name, name) {
+			return &tables[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: table %s", ErrNotFound, name)
+}
+
+// column returns the index of the column name, matched without regard to
+// case, or -1.
+func (t *Table) column(name string) int {
+	for i, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tableConstraints start the definitions of a CREATE TABLE that are not
+// columns.
+var tableConstraints = map[string]bool{"CONSTRAINT": true, "PRIMARY": true, "UNIQUE": true, "CHECK": true, "FOREIGN": true}
+
+// primaryKey matches a table constraint PRIMARY KEY on a single column.
+var primaryKey = regexp.MustCompile(`(?is)^primary\s+key\s*\(\s*(.+?)\s*(asc|desc)?\s*\)`)
+
+// parseColumns returns the column names of the CREATE TABLE statement sql,
+// the index of its INTEGER PRIMARY KEY column, or -1, and which columns
+// have REAL affinity. The statement is not validated, SQLite having
+// accepted it: names are the first token of each column definition,
+// unquoted.
+func parseColumns(sql string) ([]string, int, []bool) {
+	open, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if open < 0 || end < open {
+		return nil, -1, nil
+	}
+	var names, types []string
+	var real []bool
+	rowid := -1
+	var pk string
+	for _, def := range splitDefinitions(sql[open+1 : end]) {
+		name, rest := firstToken(def)
+		if name == "" {
+			continue
+		}
+		if !isQuoted(def) && tableConstraints[strings.ToUpper(name)] {
+			if m := primaryKey.FindStringSubmatch(def); m != nil && !strings.Contains(m[1], ",") {
+				pk, _ = firstToken(m[1])
+			}
+			continue
+		}
+		if integerPrimaryKey(rest) {
+			rowid = len(names)
+		}
+		names, types = append(names, name), append(types, rest)
+		real = append(real, realAffinity(rest))
+	}
+	if pk != "" && rowid < 0 {
+		for i, name := range names {
+			if strings.EqualFold(name, pk) && integerType(types[i]) {
+				rowid = i
+			}
+		}
+	}
+	return names, rowid, real
+}
+
+// splitDefinitions splits the body of a CREATE TABLE at the commas between
+// its definitions, skipping quoted text, parentheses and comments.
+func splitDefinitions(body string) []string {
+	var defs []string
+	var cur strings.Builder
+	depth := 0
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := i + 1 + strings.IndexByte(body[i+1:], closing)
+			if end == i {
+				end = len(body) - 1
+			}
+			cur.WriteString(body[i : end+1])
+			i = end
+			continue
+		case c == '-' && strings.HasPrefix(body[i:], "--"):
+			j := strings.IndexByte(body[i:], '\n')
+			if j < 0 {
+				j = len(body) - i
+			}
+			i += j
+			cur.WriteByte(' ')
+			continue
+		case c == '/' && strings.HasPrefix(body[i:], "/*"):
+			j := strings.Index(body[i+2:], "*/")
+			if j < 0 {
+				j = len(body) - i - 2
+			}
+			i += j + 3
+			cur.WriteByte(' ')
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, strings.TrimSpace(cur.String()))
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	return append(defs, strings.TrimSpace(cur.String()))
+}
+
+// firstToken returns the first token of def, unquoted, and the rest.
+func firstToken(def string) (string, string) {
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return "", ""
+	}
+	if closing, ok := map[byte]byte{'"': '"', '`': '`', '[': ']', '\'': '\''}[def[0]]; ok {
+		if j := strings.IndexByte(def[1:], closing); j >= 0 {
+			return def[1 : j+1], def[j+2:]
+		}
+		return def[1:], ""
+	}
+	if j := strings.IndexAny(def, " \t\r\n("); j >= 0 {
+		return def[:j], def[j:]
+	}
+	return def, ""
+}
+
+func isQuoted(def string) bool {
+	return def != "" && strings.ContainsRune("\"`['", rune(def[0]))
+}
+
+// integerPrimaryKey reports whether the rest of a column definition,
+// after its name, declares an alias of the rowid: a type of exactly
+// INTEGER and PRIMARY KEY.
+func integerPrimaryKey(rest string) bool {
+	fields := strings.Fields(strings.ToUpper(rest))
+	return len(fields) >= 3 && fields[0] == "INTEGER" && fields[1] == "PRIMARY" && fields[2] == "KEY" &&
+		!(len(fields) > 3 && fields[3] == "DESC")
+}
+
+// columnConstraints end the type of a column definition.
+var columnConstraints = map[string]bool{
+	"CONSTRAINT": true, "PRIMARY": true, "NOT": true, "NULL": true, "UNIQUE": true, "CHECK": true,
+	"DEFAULT": true, "COLLATE": true, "REFERENCES": true, "GENERATED": true, "AS": true,
+}
+
+// realAffinity reports whether the rest of a column definition declares a
+// type of REAL affinity, e.g. REAL, FLOAT or DOUBLE PRECISION, by SQLite's
+// rules.
+func realAffinity(rest string) bool {
+	var words []string
+	for _, w := range strings.Fields(strings.ToUpper(rest)) {
+		if columnConstraints[w] {
+			break
+		}
+		words = append(words, w)
+	}
+	typ := strings.Join(words, " ")
+	if strings.Contains(typ, "INT") || strings.Contains(typ, "CHAR") || strings.Contains(typ, "CLOB") || strings.Contains(typ, "TEXT") {
+		return false
+	}
+	return strings.Contains(typ, "REAL") || strings.Contains(typ, "FLOA") || strings.Contains(typ, "DOUB")
+}
+
+// integerType reports whether the rest of a column definition has a type
+// of exactly INTEGER.
+func integerType(rest string) bool {
+	fields := strings.Fields(strings.ToUpper(rest))
+	return len(fields) > 0 && fields[0] == "INTEGER"
+}
@@ -0,0 +1,486 @@
+// Package sqlite reads SQLite databases, the format of browser histories,
+// messaging apps and most mobile artifacts, read from the evidence or
+// from an extracted artifact, without a SQLite library: it parses the
+// file format itself, read-only, and never writes to the file, its
+// journal or a -wal file.
+//
+// Scripts query a table with DB.Query, select its rows with a Go
+// predicate rather than SQL, and emit them as findings or timeline events
+// with EmitFindings and EmitTimeline. A query stops at its timeout, and
+// the pages of a crafted or corrupt database are bounds-checked as they
+// are read: a loop of pages fails with ErrCorrupt rather than hanging, and
+// a record claiming more than MaxRecordBytes fails with ErrRecordTooLarge
+// rather than being allocated.
+//
+// A database is read in place through an io.ReaderAt: OpenEvidence parses
+// the evidence view, EVIDENCE_OFFSET and EVIDENCE_LENGTH included, and
+// Row.Offset is then an offset of the evidence, as sandbox.Location
+// wants. The write-ahead log of a database in WAL mode, see DB.WAL, is not
+// replayed, so it may miss its latest changes; rows recovered from the
+// freelist, see Query.Deleted, are a best effort.
+package sqlite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"unicode/utf16"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// ErrNotDatabase is returned by Open for data that does not start with a
+// SQLite database header.
+var ErrNotDatabase = errors.New("sqlite: not a SQLite database")
+
+// ErrCorrupt is returned for a page or record that is truncated, out of
+// the file, of an unexpected type or reached twice, e.g. in a partly
+// overwritten or carved database.
+var ErrCorrupt = errors.New("sqlite: corrupt database")
+
+// ErrNotFound is returned for a table or column that does not exist.
+var ErrNotFound = errors.New("sqlite: not found")
+
+// ErrUnsupported is returned for the tables this package does not read:
+// virtual tables and tables WITHOUT ROWID.
+var ErrUnsupported = errors.New("sqlite: unsupported table")
+
+// ErrRecordTooLarge is returned for a record of more than MaxRecordBytes.
+var ErrRecordTooLarge = errors.New("sqlite: record too large")
+
+// MaxRecordBytes bounds the size of the records read, the row of a table
+// with all its values, overflow pages included.
+const MaxRecordBytes = 32 << 20
+
+const (
+	headerSize = 100
+	magic      = "SQLite format 3\x00"
+	// maxDepth bounds the depth of a b-tree, SQLite's BTCURSOR_MAX_DEPTH.
+	maxDepth = 20
+
+	pageInteriorTable = 0x05
+	pageLeafTable     = 0x0d
+
+	encUTF8    = 1
+	encUTF16LE = 2
+	encUTF16BE = 3
+)
+
+// DB is a SQLite database opened by Open, OpenFile or OpenEvidence. It is
+// safe for concurrent use when its reader is.
+type DB struct {
+	// PageSize is the size of the pages of the database, in bytes.
+	PageSize int
+	// WAL reports that the database is in WAL mode: its -wal file, not
+	// replayed here, may hold changes not yet written to the database.
+	WAL bool
+
+	r      io.ReaderAt
+	pages  uint32
+	usable int
+	enc    byte
+	// freelist is the first freelist trunk page, freePages the number of
+	// pages of the freelist.
+	freelist, freePages uint32
+	// evidence reports that r is the evidence, whose offsets locate
+	// findings.
+	evidence bool
+	closer   io.Closer
+}
+
+// Open parses the database at the start of r, which holds size bytes.
+func Open(r io.ReaderAt, size int64) (*DB, error) {
+	if size < headerSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrNotDatabase, size)
+	}
+	var h [headerSize]byte
+	if _, err := r.ReadAt(h[:], 0); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("sqlite: read header: %w", err)
+	}
+	if string(h[:16]) != magic {
+		return nil, ErrNotDatabase
+	}
+	pageSize := int(binary.BigEndian.Uint16(h[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("%w: page size %d", ErrCorrupt, pageSize)
+	}
+	usable := pageSize - int(h[20])
+	if usable < 480 {
+		return nil, fmt.Errorf("%w: %d reserved bytes per page", ErrCorrupt, h[20])
+	}
+	db := &DB{
+		PageSize:  pageSize,
+		WAL:       h[18] == 2 || h[19] == 2,
+		r:         r,
+		usable:    usable,
+		enc:       h[56],
+		freelist:  binary.BigEndian.Uint32(h[32:]),
+		freePages: binary.BigEndian.Uint32(h[36:]),
+	}
+	if db.enc == 0 {
+		db.enc = encUTF8
+	}
+	if db.enc > encUTF16BE {
+		return nil, fmt.Errorf("%w: text encoding %d", ErrCorrupt, db.enc)
+	}
+	// The page count of the header is only valid when written by a
+	// version that maintains it; a truncated file holds fewer pages.
+	pages := size / int64(pageSize)
+	if n := binary.BigEndian.Uint32(h[28:]); n > 0 && binary.BigEndian.Uint32(h[24:]) == binary.BigEndian.Uint32(h[92:]) {
+		pages = min(pages, int64(n))
+	}
+	db.pages = uint32(min(pages, math.MaxUint32))
+	if db.pages == 0 {
+		return nil, fmt.Errorf("%w: no complete page", ErrCorrupt)
+	}
+	return db, nil
+}
+
+// OpenFile opens and parses the database at path, e.g. an artifact the
+// script extracted; close the database to close the file.
+func OpenFile(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fmt.Errorf("sqlite: %s is not a regular file", path)
+	}
+	var db *DB
+	if err == nil {
+		db, err = Open(f, info.Size())
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	db.closer = f
+	return db, nil
+}
+
+// OpenEvidence opens the evidence like sandbox.OpenEvidence and parses it
+// as a database; close the database to close the evidence. Compressed
+// evidence works but is slow, pages being read at random.
+func OpenEvidence(opts ...sandbox.EvidenceOption) (*DB, error) {
+	ef, err := sandbox.OpenEvidence(opts...)
+	if err != nil {
+		return nil, err
+	}
+	db, err := Open(ef, ef.Size())
+	if err != nil {
+		ef.Close()
+		return nil, err
+	}
+	db.closer, db.evidence = ef, true
+	return db, nil
+}
+
+// Close closes the file or evidence opened by OpenFile or OpenEvidence; it
+// does nothing for a database opened with Open.
+func (db *DB) Close() error {
+	if db.closer == nil {
+		return nil
+	}
+	return db.closer.Close()
+}
+
+// offset returns the offset of page n in the file.
+func (db *DB) offset(n uint32) int64 {
+	return int64(n-1) * int64(db.PageSize)
+}
+
+// page reads page n, numbered from 1.
+func (db *DB) page(n uint32) ([]byte, error) {
+	if n == 0 || n > db.pages {
+		return nil, fmt.Errorf("%w: page %d of %d", ErrCorrupt, n, db.pages)
+	}
+	buf := make([]byte, db.PageSize)
+	if _, err := db.r.ReadAt(buf, db.offset(n)); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("sqlite: read page %d: %w", n, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: page %d truncated", ErrCorrupt, n)
+	}
+	return buf, nil
+}
+
+// btreePage is a parsed b-tree page.
+type btreePage struct {
+	n     uint32
+	data  []byte
+	kind  byte
+	cells []int
+	// right is the right-most child of an interior page.
+	right uint32
+}
+
+// btreePage reads and parses the b-tree page n; page 1 starts after the
+// database header.
+func (db *DB) btreePage(n uint32) (*btreePage, error) {
+	data, err := db.page(n)
+	if err != nil {
+		return nil, err
+	}
+	return db.parseBtree(n, data)
+}
+
+func (db *DB) parseBtree(n uint32, data []byte) (*btreePage, error) {
+	hdr := 0
+	if n == 1 {
+		hdr = headerSize
+	}
+	p := &btreePage{n: n, data: data, kind: data[hdr]}
+	size := 8
+	switch p.kind {
+	case pageInteriorTable:
+		size = 12
+		p.right = binary.BigEndian.Uint32(data[hdr+8:])
+	case pageLeafTable:
+	default:
+		return nil, fmt.Errorf("%w: page %d has type %#x, not a table b-tree page", ErrCorrupt, n, p.kind)
+	}
+	count := int(binary.BigEndian.Uint16(data[hdr+3:]))
+	ptrs := hdr + size
+	if ptrs+2*count > db.usable {
+		return nil, fmt.Errorf("%w: page %d has %d cells", ErrCorrupt, n, count)
+	}
+	p.cells = make([]int, count)
+	for i := range p.cells {
+		off := int(binary.BigEndian.Uint16(data[ptrs+2*i:]))
+		if off < ptrs+2*count || off >= db.usable {
+			return nil, fmt.Errorf("%w: cell %d of page %d at %d", ErrCorrupt, i, n, off)
+		}
+		p.cells[i] = off
+	}
+	return p, nil
+}
+
+// cell is a cell of a table b-tree leaf: the row rowid and its record.
+type cell struct {
+	rowid   int64
+	payload []byte
+	// offset and length locate the cell in the file, overflow pages
+	// excluded.
+	offset, length int64
+}
+
+// walk calls fn with each cell of the table b-tree rooted at page root,
+// in rowid order. visited holds the pages already read, a page reached
+// twice being a loop.
+func (db *DB) walk(root uint32, visited map[uint32]bool, check func() error, fn func(cell) error) error {
+	var visit func(n uint32, depth int) error
+	visit = func(n uint32, depth int) error {
+		if err := check(); err != nil {
+			return err
+		}
+		if depth > maxDepth {
+			return fmt.Errorf("%w: b-tree of page %d deeper than %d", ErrCorrupt, root, maxDepth)
+		}
+		if visited[n] {
+			return fmt.Errorf("%w: page %d reached twice", ErrCorrupt, n)
+		}
+		visited[n] = true
+		p, err := db.btreePage(n)
+		if err != nil {
+			return err
+		}
+		if p.kind == pageInteriorTable {
+			for _, off := range p.cells {
+				if off+4 > db.usable {
+					return fmt.Errorf("%w: cell of page %d at %d", ErrCorrupt, n, off)
+				}
+				if err := visit(binary.BigEndian.Uint32(p.data[off:]), depth+1); err != nil {
+					return err
+				}
+			}
+			return visit(p.right, depth+1)
+		}
+		for _, off := range p.cells {
+			c, err := db.leafCell(p, off)
+			if err != nil {
+				return err
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return visit(root, 0)
+}
+
+// leafCell reads the cell at off of the leaf page p, following its
+// overflow pages.
+func (db *DB) leafCell(p *btreePage, off int) (cell, error) {
+	data := p.data[:db.usable]
+	size, n := varint(data[off:])
+	if n == 0 {
+		return cell{}, fmt.Errorf("%w: cell of page %d at %d", ErrCorrupt, p.n, off)
+	}
+	rowid, m := varint(data[off+n:])
+	if m == 0 {
+		return cell{}, fmt.Errorf("%w: cell of page %d at %d", ErrCorrupt, p.n, off)
+	}
+	if size > MaxRecordBytes {
+		return cell{}, fmt.Errorf("%w: row %d of %d bytes", ErrRecordTooLarge, int64(rowid), size)
+	}
+	start := off + n + m
+	total := int(size)
+	local := db.localSize(total)
+	if start+local > len(data) || local < total && start+local+4 > len(data) {
+		return cell{}, fmt.Errorf("%w: row %d overflows page %d", ErrCorrupt, int64(rowid), p.n)
+	}
+	c := cell{rowid: int64(rowid), offset: db.offset(p.n) + int64(off), length: int64(start - off + local)}
+	if local == total {
+		c.payload = data[start : start+total]
+		return c, nil
+	}
+	if int64(total) > int64(db.pages)*int64(db.usable) {
+		return cell{}, fmt.Errorf("%w: row %d of %d bytes, more than the database", ErrCorrupt, c.rowid, total)
+	}
+	c.length += 4
+	payload := make([]byte, local, total)
+	copy(payload, data[start:start+local])
+	next := binary.BigEndian.Uint32(data[start+local:])
+	// Each overflow page holds usable-4 bytes, so that a loop of pages
+	// ends when the payload is complete.
+	for len(payload) < total {
+		if next == 0 {
+			return cell{}, fmt.Errorf("%w: overflow of row %d ends early", ErrCorrupt, c.rowid)
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return cell{}, err
+		}
+		chunk := page[4:db.usable]
+		payload = append(payload, chunk[:min(len(chunk), total-len(payload))]...)
+		next = binary.BigEndian.Uint32(page)
+	}
+	c.payload = payload
+	return c, nil
+}
+
+// localSize is the part of a payload of size bytes stored in the leaf
+// cell itself, the rest going to overflow pages.
+func (db *DB) localSize(size int) int {
+	u := db.usable
+	x := u - 35
+	if size <= x {
+		return size
+	}
+	m := (u-12)*32/255 - 23
+	k := m + (size-m)%(u-4)
+	if k <= x {
+		return k
+	}
+	return m
+}
+
+// varint decodes the SQLite variable-length integer at the start of b and
+// returns it with its length, 0 when b is too short.
+func varint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// record decodes the values of a record: nil, int64, float64, string or
+// []byte.
+func (db *DB) record(payload []byte) ([]any, error) {
+	hsize, n := varint(payload)
+	if n == 0 || hsize < uint64(n) || hsize > uint64(len(payload)) {
+		return nil, fmt.Errorf("%w: record header", ErrCorrupt)
+	}
+	header, body := payload[n:hsize], payload[hsize:]
+	var values []any
+	for len(header) > 0 {
+		st, n := varint(header)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: record header", ErrCorrupt)
+		}
+		header = header[n:]
+		size := serialSize(st)
+		if size > uint64(len(body)) {
+			return nil, fmt.Errorf("%w: record value of %d bytes past its end", ErrCorrupt, size)
+		}
+		v, err := db.value(st, body[:size])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		body = body[size:]
+	}
+	return values, nil
+}
+
+// serialSize is the size of a value of serial type st.
+func serialSize(st uint64) uint64 {
+	switch {
+	case st >= 12:
+		return (st - 12) / 2
+	case st == 5:
+		return 6
+	case st == 6 || st == 7:
+		return 8
+	case st >= 1 && st <= 4:
+		return st
+	}
+	return 0
+}
+
+func (db *DB) value(st uint64, b []byte) (any, error) {
+	switch {
+	case st == 0:
+		return nil, nil
+	case st <= 6:
+		v := int64(int8(b[0]))
+		for _, c := range b[1:] {
+			v = v<<8 | int64(c)
+		}
+		return v, nil
+	case st == 7:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case st == 8:
+		return int64(0), nil
+	case st == 9:
+		return int64(1), nil
+	case st < 12:
+		return nil, fmt.Errorf("%w: reserved serial type %d", ErrCorrupt, st)
+	case st%2 == 0:
+		return append([]byte(nil), b...), nil
+	}
+	return db.text(b), nil
+}
+
+// text decodes b in the text encoding of the database.
+func (db *DB) text(b []byte) string {
+	if db.enc == encUTF8 {
+		return string(b)
+	}
+	order := binary.ByteOrder(binary.LittleEndian)
+	if db.enc == encUTF16BE {
+		order = binary.BigEndian
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,181 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/fixture.db is a small database written by
+// testdata/make_fixture.py, with 1 KiB pages: the tables of a browser
+// history spanning interior pages, messages with overflow pages, a table
+// WITHOUT ROWID and a cache table whose deleted rows are on the freelist.
+const fixture = "testdata/fixture.db"
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func openData(t *testing.T, data []byte) *DB {
+	t.Helper()
+	db, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// rows returns the rows of q.
+func rows(t *testing.T, db *DB, q Query) []Row {
+	t.Helper()
+	var got []Row
+	if err := db.Query(context.Background(), q, func(r Row) error {
+		got = append(got, r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestOpen(t *testing.T) {
+	data := readFixture(t)
+	db := openData(t, data)
+	if db.PageSize != 1024 || db.WAL || db.pages != uint32(len(data)/1024) {
+		t.Errorf("page size %d, %d pages, WAL %v", db.PageSize, db.pages, db.WAL)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"zeros":     make([]byte, 4096),
+		"not magic": append([]byte("SQLite format 2\x00"), data[16:]...),
+	} {
+		if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNotDatabase) {
+			t.Errorf("%s: Open() = %v, want ErrNotDatabase", name, err)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(bad[16:], 1000)
+	if _, err := Open(bytes.NewReader(bad), int64(len(bad))); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("page size 1000: Open() = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestTables(t *testing.T) {
+	tables, err := openData(t, readFixture(t)).Tables(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, tb := range tables {
+		got[tb.Name] = tb.Columns
+	}
+	want := map[string][]string{
+		"urls":            {"id", "url", "title", "visit_count", "last_visit_time"},
+		"sqlite_sequence": {"name", "seq"},
+		"visits":          {"id", "url", "visit_time", "duration"},
+		"messages":        {"msg id", "sender", "body", "attachment"},
+		"cache":           {"key", "value", "hits"},
+		"settings":        {"name", "value"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tables %q, want %q", got, want)
+	}
+	if err := openData(t, readFixture(t)).Query(context.Background(), Query{Table: "settings"}, func(Row) error { return nil }); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("query of a table WITHOUT ROWID: %v, want ErrUnsupported", err)
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	for sql, want := range map[string]struct {
+		columns []string
+		rowid   int
+	}{
+		`CREATE TABLE t(a INTEGER PRIMARY KEY, b)`:                                {[]string{"a", "b"}, 0},
+		`CREATE TABLE t(a INTEGER PRIMARY KEY DESC, b)`:                           {[]string{"a", "b"}, -1},
+		`CREATE TABLE t(a INT PRIMARY KEY, b)`:                                    {[]string{"a", "b"}, -1},
+		`CREATE TABLE t(x TEXT, "y z" INTEGER, PRIMARY KEY("y z"))`:               {[]string{"x", "y z"}, 1},
+		"CREATE TABLE t(a DECIMAL(10, 2), /* b, */ `c` CHECK (c > 0), -- d,\n e)": {[]string{"a", "c", "e"}, -1},
+		`CREATE TABLE t([a], 'b', FOREIGN KEY (a) REFERENCES u(id))`:              {[]string{"a", "b"}, -1},
+	} {
+		columns, rowid, _ := parseColumns(sql)
+		if !reflect.DeepEqual(columns, want.columns) || rowid != want.rowid {
+			t.Errorf("parseColumns(%q) = %q, %d; want %q, %d", sql, columns, rowid, want.columns, want.rowid)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	db := openData(t, readFixture(t))
+	got := rows(t, db, Query{Table: "messages"})
+	if len(got) != 3 {
+		t.Fatalf("%d messages", len(got))
+	}
+	m1, m2, m3 := got[0], got[1], got[2]
+	if m1.Text("msg id") != "m1" || m1.Text("body") != "Réunion à 10h ✓" || !bytes.Equal(m1.Value("attachment").([]byte), []byte{0, 1, 2}) {
+		t.Errorf("message 1 = %v", m1.Values)
+	}
+	// Both spill to overflow pages.
+	if m2.Text("body") != strings.Repeat("x", 5000) || m2.Value("attachment") != nil {
+		t.Errorf("message 2 body of %d bytes, attachment %v", len(m2.Text("body")), m2.Value("attachment"))
+	}
+	if m3.Value("body") != nil || !bytes.Equal(m3.Value("attachment").([]byte), bytes.Repeat([]byte{0xff}, 3000)) {
+		t.Errorf("message 3 = %v", m3.Values[:3])
+	}
+	// Offsets are those of the cells of the file.
+	data := readFixture(t)
+	if n, _ := varint(data[m1.Offset:]); m1.Length <= 0 || int(n) > int(m1.Length) {
+		t.Errorf("message 1 at %d+%d, payload %d", m1.Offset, m1.Length, n)
+	}
+	// Integral REAL values are stored as integers.
+	visits := rows(t, db, Query{Table: "visits", Columns: []string{"id", "duration"}})
+	if len(visits) != 303 || visits[2].Values[0] != int64(3) || visits[2].Values[1] != 3.0 {
+		t.Errorf("%d visits, third %v", len(visits), visits[2].Values)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	ctx := context.Background()
+	data := readFixture(t)
+	tables, err := openData(t, data).Tables(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls Table
+	for _, tb := range tables {
+		if tb.Name == "urls" {
+			urls = tb
+		}
+	}
+	root := int64(urls.root-1) * 1024
+	if data[root] != pageInteriorTable {
+		t.Fatalf("urls root has type %#x", data[root])
+	}
+
+	// The right-most child of the root pointing back at it.
+	loop := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(loop[root+8:], urls.root)
+	// A cell pointer past the page.
+	cells := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(cells[root+12:], 1020)
+	// A record claiming a huge payload.
+	huge := append([]byte(nil), data...)
+	leaf := int64(binary.BigEndian.Uint32(data[root+8:])-1) * 1024
+	cell := leaf + int64(binary.BigEndian.Uint16(data[leaf+8:]))
+	copy(huge[cell:], []byte{0x8f, 0xff, 0xff, 0xff, 0x7f})
+	for name, data := range map[string][]byte{"loop": loop, "cells": cells, "truncated": data[:root+512], "huge": huge} {
+		err := openData(t, data).Query(ctx, Query{Table: "urls"}, func(Row) error { return nil })
+		if !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrRecordTooLarge) {
+			t.Errorf("%s: Query() = %v, want ErrCorrupt or ErrRecordTooLarge", name, err)
+		}
+	}
+}
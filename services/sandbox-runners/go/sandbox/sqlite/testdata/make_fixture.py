@@ -0,0 +1,60 @@
+#!/usr/bin/env python3
+"""Writes fixture.db, the SQLite database of the sqlite package tests.
+
+Usage: python3 make_fixture.py fixture.db
+
+The database has small pages, so that its tables span interior pages and
+overflow pages, the tables of a browser history (urls, visits), messages
+in UTF-8 with a long one, and a cache table most of whose rows are then
+deleted, leaving their pages on the freelist.
+"""
+import os
+import sqlite3
+import sys
+
+path = sys.argv[1]
+if os.path.exists(path):
+    os.remove(path)
+db = sqlite3.connect(path)
+db.executescript("""
+PRAGMA page_size = 1024;
+PRAGMA auto_vacuum = NONE;
+PRAGMA secure_delete = OFF;
+PRAGMA journal_mode = DELETE;
+CREATE TABLE urls(id INTEGER PRIMARY KEY AUTOINCREMENT, url LONGVARCHAR, title LONGVARCHAR, visit_count INTEGER DEFAULT 0 NOT NULL, last_visit_time INTEGER NOT NULL);
+CREATE INDEX urls_url_index ON urls (url);
+CREATE TABLE "visits" (
+    id INTEGER,
+    url INTEGER NOT NULL, -- urls.id
+    visit_time INTEGER NOT NULL,
+    duration REAL,
+    PRIMARY KEY (id)
+);
+CREATE TABLE messages([msg id] TEXT, sender TEXT, body TEXT, attachment BLOB, CONSTRAINT one UNIQUE ([msg id]));
+CREATE TABLE cache(key TEXT, value TEXT, hits);
+CREATE TABLE settings(name TEXT PRIMARY KEY, value) WITHOUT ROWID;
+""")
+# 2024-03-01T12:00:00Z in WebKit time, microseconds since 1601.
+webkit = (1709294400 + 11644473600) * 1_000_000
+urls = [
+    ("https://example.com/", "Example", 3),
+    ("https://login.evil.example/phish", "Sign in", 1),
+    ("https://docs.example.org/guide", "Guide", 12),
+]
+for i in range(300):
+    urls.append((f"https://news.example.net/article/{i}", f"Article {i}", i % 5))
+for i, (url, title, count) in enumerate(urls):
+    db.execute("INSERT INTO urls (url, title, visit_count, last_visit_time) VALUES (?, ?, ?, ?)",
+               (url, title, count, webkit + i * 60_000_000))
+    db.execute("INSERT INTO visits (url, visit_time, duration) VALUES (?, ?, ?)",
+               (i + 1, webkit + i * 60_000_000, 1.5 * i))
+db.execute("INSERT INTO messages VALUES (?, ?, ?, ?)", ("m1", "alice", "Réunion à 10h ✓", b"\x00\x01\x02"))
+db.execute("INSERT INTO messages VALUES (?, ?, ?, ?)", ("m2", "bob", "x" * 5000, None))
+db.execute("INSERT INTO messages VALUES (?, ?, ?, ?)", ("m3", "mallory", None, b"\xff" * 3000))
+for i in range(400):
+    db.execute("INSERT INTO cache VALUES (?, ?, ?)", (f"key-{i:03d}", "v" * 80, i))
+db.execute("INSERT INTO settings VALUES ('theme', 'dark')")
+db.commit()
+db.execute("DELETE FROM cache WHERE hits >= 20")
+db.commit()
+db.close()
@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimelineFile is the name of the timeline file inside OUTPUT_DIR, merged
+// by the platform into the case's super-timeline.
+const TimelineFile = "timeline.ndjson"
+
+// TimelineTimeFormat is the timestamp layout of timeline.ndjson: RFC 3339
+// in UTC with exactly nine fractional digits.
+const TimelineTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// ErrZeroTimelineTime is returned for a timeline event without a time.
+var ErrZeroTimelineTime = errors.New("sandbox: timeline event has a zero time")
+
+// TimelineEvent is one line of timeline.ndjson.
+type TimelineEvent struct {
+	Time        time.Time
+	EvidenceUID string
+	// Source names the artifact the event comes from, e.g. "prefetch".
+	Source  string
+	Message string
+	Fields  map[string]any
+}
+
+type timelineRecord struct {
+	Timestamp   string         `json:"timestamp"`
+	EvidenceUID string         `json:"evidence_uid"`
+	Source      string         `json:"source"`
+	Message     string         `json:"message"`
+	Fields      map[string]any `json:"fields,omitempty"`
+}
+
+// MarshalJSON writes the event with its time in TimelineTimeFormat.
+func (e TimelineEvent) MarshalJSON() ([]byte, error) {
+	rec, err := e.record()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rec)
+}
+
+// record is the line of timeline.ndjson for e.
+func (e TimelineEvent) record() (timelineRecord, error) {
+	if e.Time.IsZero() {
+		return timelineRecord{}, ErrZeroTimelineTime
+	}
+	return timelineRecord{
+		Timestamp:   e.Time.UTC().Format(TimelineTimeFormat),
+		EvidenceUID: e.EvidenceUID,
+		Source:      e.Source,
+		Message:     e.Message,
+		Fields:      e.Fields,
+	}, nil
+}
+
+// UnmarshalJSON reads an event, accepting any RFC 3339 timestamp.
+func (e *TimelineEvent) UnmarshalJSON(data []byte) error {
+	var rec timelineRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+	if err != nil {
+		return fmt.Errorf("sandbox: timeline timestamp: %w", err)
+	}
+	if t.IsZero() {
+		return ErrZeroTimelineTime
+	}
+	*e = TimelineEvent{
+		Time:        t.UTC(),
+		EvidenceUID: rec.EvidenceUID,
+		Source:      rec.Source,
+		Message:     rec.Message,
+		Fields:      rec.Fields,
+	}
+	return nil
+}
+
+// EmitTimelineEvent appends an event for the sandbox's evidence to
+// timeline.ndjson in OUTPUT_DIR. t is normalized to UTC; a zero t is
+// rejected with ErrZeroTimelineTime, and an event past the cap of
+// SANDBOX_MAX_TIMELINE_EVENTS with ErrRecordLimit. It is safe for
+// concurrent use.
+func EmitTimelineEvent(t time.Time, source, message string, fields map[string]any) error {
+	if t.IsZero() {
+		return ErrZeroTimelineTime
+	}
+	uid, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return err
+	}
+	return appendRecord(TimelineFile, TimelineEvent{
+		Time:        t,
+		EvidenceUID: uid,
+		Source:      source,
+		Message:     message,
+		Fields:      fields,
+	})
+}
+
+// ReadTimeline parses the timeline in dir; a missing file is an empty
+// timeline. Malformed lines and events that do not match the schema are
+// skipped and reported in err, after the events that could be read;
+// RecordErrors lists them.
+func ReadTimeline(dir string) ([]TimelineEvent, error) {
+	return readRecords[TimelineEvent](dir, TimelineFile, nil)
+}
+
+// ReadTimelineUpTo parses the timeline in dir as ReadTimeline does, up to
+// limit events when limit is positive. total counts the records of the
+// file, read or not, rejected ones included.
+func ReadTimelineUpTo(dir string, limit int) (events []TimelineEvent, total int, err error) {
+	return readRecordsUpTo[TimelineEvent](dir, TimelineFile, nil, limit)
+}
@@ -0,0 +1,69 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEmitTimelineEvent(t *testing.T) {
+	dir := setupEnv(t)
+	paris := time.FixedZone("CET", 3600)
+	ts := time.Date(2024, 3, 1, 10, 30, 0, 5000, paris)
+	if err := EmitTimelineEvent(ts, "prefetch", "CMD.EXE executed", map[string]any{"run_count": 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, filepath.Join(dir, TimelineFile))
+	want := `{"timestamp":"2024-03-01T09:30:00.000005000Z","evidence_uid":"ev-1","source":"prefetch","message":"CMD.EXE executed","fields":{"run_count":3}}`
+	if len(lines) != 1 || lines[0] != want {
+		t.Fatalf("timeline = %q, want %q", lines, want)
+	}
+
+	events, err := ReadTimeline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || !events[0].Time.Equal(ts) || events[0].Time.Location() != time.UTC || events[0].Source != "prefetch" {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestEmitTimelineEventRejectsZeroTime(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitTimelineEvent(time.Time{}, "mft", "no time", nil); !errors.Is(err, ErrZeroTimelineTime) {
+		t.Fatalf("EmitTimelineEvent() = %v, want ErrZeroTimelineTime", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, TimelineFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("timeline written for a rejected event: %v", err)
+	}
+}
+
+func TestReadTimelineSkipsMalformedLines(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"timestamp":"2024-03-01T09:30:00Z","source":"a","message":"ok"}
+not json
+{"timestamp":"0001-01-01T00:00:00Z","source":"b","message":"zero"}
+{"timestamp":"yesterday","source":"c","message":"bad"}
+{"timestamp":"2024-03-01T09:30:00Z","source":"d","message":"bad fields","fields":["pid"]}
+`
+	if err := os.WriteFile(filepath.Join(dir, TimelineFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadTimeline(dir)
+	if err == nil {
+		t.Error("expected an error for the malformed lines")
+	}
+	if len(events) != 1 || events[0].Source != "a" {
+		t.Errorf("events = %+v", events)
+	}
+	if rejected := RecordErrors(err); len(rejected) != 4 || rejected[3].Line != 5 || rejected[3].File != TimelineFile {
+		t.Errorf("rejected = %v, want lines 2 to 5", rejected)
+	}
+
+	if events, err := ReadTimeline(t.TempDir()); err != nil || events != nil {
+		t.Errorf("missing timeline = %v, %v", events, err)
+	}
+}
@@ -0,0 +1,226 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Defaults of NewTimelineWriter.
+const (
+	DefaultTimelineBufferSize    = 1 << 20
+	DefaultTimelineFlushInterval = time.Second
+)
+
+// ErrTimelineWriterClosed is returned for an event written after Close,
+// which the shutdown handler may have called.
+var ErrTimelineWriterClosed = errors.New("sandbox: timeline writer is closed")
+
+// TimelineOption configures NewTimelineWriter.
+type TimelineOption func(*TimelineWriter)
+
+// WithBufferSize sets how many bytes of events a TimelineWriter holds
+// before writing them out.
+func WithBufferSize(n int) TimelineOption {
+	return func(w *TimelineWriter) {
+		if n > 0 {
+			w.size = n
+		}
+	}
+}
+
+// WithFlushInterval sets how often a TimelineWriter writes out the events
+// it holds when its buffer does not fill up; zero only writes them when
+// the buffer is full, on Flush and on Close.
+func WithFlushInterval(d time.Duration) TimelineOption {
+	return func(w *TimelineWriter) { w.interval = d }
+}
+
+// TimelineWriter streams events to timeline.ndjson in OUTPUT_DIR for
+// scripts that produce millions of them. Events are encoded into a buffer
+// of bounded size, written out by whole lines when it is full and every
+// flush interval, so that a script emitting in a tight loop is held back
+// to the speed of the disk instead of growing its memory. Lines never
+// interleave with those of EmitTimelineEvent. A TimelineWriter is safe
+// for concurrent use.
+type TimelineWriter struct {
+	uid      string
+	dir      string
+	size     int
+	interval time.Duration
+
+	mu     sync.Mutex
+	f      *os.File
+	buf    []byte
+	line   bytes.Buffer
+	enc    *json.Encoder
+	err    error
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTimelineWriter opens timeline.ndjson in OUTPUT_DIR for appending, with
+// a buffer of DefaultTimelineBufferSize bytes flushed every
+// DefaultTimelineFlushInterval. The writer is closed by the OnShutdown
+// handlers, so that the events it holds are not lost on timeout or
+// cancellation; the script closes it when it is done.
+func NewTimelineWriter(opts ...TimelineOption) (*TimelineWriter, error) {
+	uid, err := MustGetEnv(EnvEvidenceUID)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := outputDir()
+	if err != nil {
+		return nil, err
+	}
+	w := &TimelineWriter{uid: uid, dir: dir, size: DefaultTimelineBufferSize, interval: DefaultTimelineFlushInterval}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.f, err = openOutput(filepath.Join(dir, TimelineFile), os.O_RDWR|os.O_CREATE|os.O_APPEND); err != nil {
+		return nil, err
+	}
+	w.buf = make([]byte, 0, w.size)
+	w.enc = json.NewEncoder(&w.line)
+	if w.interval > 0 {
+		w.done = make(chan struct{})
+		w.wg.Add(1)
+		go w.flushLoop()
+	}
+	OnShutdown(func() { w.Close() })
+	return w, nil
+}
+
+// Emit writes an event for the sandbox's evidence, as EmitTimelineEvent
+// does. It blocks while a full buffer is written out.
+func (w *TimelineWriter) Emit(t time.Time, source, message string, fields map[string]any) error {
+	return w.Write(TimelineEvent{Time: t, Source: source, Message: message, Fields: fields})
+}
+
+// Write writes e, for the sandbox's evidence when e.EvidenceUID is empty.
+// A zero time is rejected with ErrZeroTimelineTime, and an event past the
+// cap of SANDBOX_MAX_TIMELINE_EVENTS with ErrRecordLimit. Once writing to
+// the file failed, every call returns that error.
+func (w *TimelineWriter) Write(e TimelineEvent) error {
+	if e.EvidenceUID == "" {
+		e.EvidenceUID = w.uid
+	}
+	rec, err := e.record()
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrTimelineWriterClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.line.Reset()
+	if err := w.enc.Encode(rec); err != nil {
+		return fmt.Errorf("marshal %s record: %w", TimelineFile, err)
+	}
+	if w.line.Len() > MaxRecordSize {
+		return fmt.Errorf("%w: %s record of %d bytes", ErrRecordTooLarge, TimelineFile, w.line.Len())
+	}
+	if err := reserveRecord(w.dir, TimelineFile); err != nil {
+		return err
+	}
+	if len(w.buf)+w.line.Len() > w.size {
+		if err := w.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if w.line.Len() > w.size {
+		return w.writeLocked(w.line.Bytes())
+	}
+	w.buf = append(w.buf, w.line.Bytes()...)
+	return nil
+}
+
+// Flush writes out the events the writer holds.
+func (w *TimelineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrTimelineWriterClosed
+	}
+	return w.flushLocked()
+}
+
+// Close writes out the events the writer holds, syncs the file to disk and
+// closes it. Closing a closed writer does nothing.
+func (w *TimelineWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	err := w.flushLocked()
+	if serr := w.f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	// The shutdown handler keeps a reference to the writer.
+	w.buf = nil
+	w.mu.Unlock()
+	if w.done != nil {
+		close(w.done)
+		w.wg.Wait()
+	}
+	return err
+}
+
+func (w *TimelineWriter) flushLoop() {
+	defer w.wg.Done()
+	tick := time.NewTicker(w.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-tick.C:
+			w.mu.Lock()
+			if !w.closed {
+				w.flushLocked()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *TimelineWriter) flushLocked() error {
+	if w.err != nil || len(w.buf) == 0 {
+		return w.err
+	}
+	err := w.writeLocked(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// writeLocked appends whole lines to the file, in one write, as
+// appendRecord does. A failed write is kept as the writer's error: the
+// lines after it would leave a gap.
+func (w *TimelineWriter) writeLocked(p []byte) error {
+	appendMu.Lock()
+	p, err := prepareAppend(w.f, p)
+	if err == nil {
+		_, err = w.f.Write(p)
+	}
+	appendMu.Unlock()
+	if err != nil {
+		w.err = fmt.Errorf("sandbox: write %s: %w", TimelineFile, err)
+	}
+	return w.err
+}
@@ -0,0 +1,150 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimelineWriter(t *testing.T) {
+	dir := setupEnv(t)
+	w, err := NewTimelineWriter(WithBufferSize(512), WithFlushInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		if err := w.Emit(at.Add(time.Duration(i)*time.Second), "mft", fmt.Sprintf("entry %d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+		if i == 10 {
+			// Lines of EmitTimelineEvent go between whole buffers.
+			if err := EmitTimelineEvent(at, "prefetch", "direct", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Write(TimelineEvent{Time: at, EvidenceUID: "ev-2", Source: "evtx", Message: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Emit(time.Time{}, "mft", "no time", nil); !errors.Is(err, ErrZeroTimelineTime) {
+		t.Errorf("zero time: err = %v", err)
+	}
+	if err := w.Emit(at, "mft", strings.Repeat("x", MaxRecordSize), nil); !errors.Is(err, ErrRecordTooLarge) {
+		t.Errorf("oversized event: err = %v", err)
+	}
+
+	// Full buffers were written out, by whole lines.
+	flushed, err := ReadTimeline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flushed) == 0 || len(flushed) >= 22 {
+		t.Errorf("%d events written before Close", len(flushed))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	events, err := ReadTimeline(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 22 || events[21].EvidenceUID != "ev-2" {
+		t.Fatalf("%d events, want 22", len(events))
+	}
+	n := 0
+	for _, e := range events {
+		if e.Source == "mft" {
+			if want := fmt.Sprintf("entry %d", n); e.Message != want || e.EvidenceUID != "ev-1" {
+				t.Errorf("event %+v, want %s", e, want)
+			}
+			n++
+		}
+	}
+	if err := w.Emit(at, "mft", "late", nil); !errors.Is(err, ErrTimelineWriterClosed) {
+		t.Errorf("after Close: err = %v", err)
+	}
+}
+
+func TestTimelineWriterFlushesPeriodically(t *testing.T) {
+	dir := setupEnv(t)
+	w, err := NewTimelineWriter(WithFlushInterval(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Emit(time.Now(), "mft", "entry", nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if events, _ := ReadTimeline(dir); len(events) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("event not flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTimelineWriterClosedOnShutdown(t *testing.T) {
+	dir := setupEnv(t)
+	w, err := NewTimelineWriter(WithFlushInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Emit(time.Now(), "mft", "buffered", nil); err != nil {
+		t.Fatal(err)
+	}
+	shutdownMu.Lock()
+	handler := shutdownHandlers[len(shutdownHandlers)-1]
+	shutdownMu.Unlock()
+	handler()
+	if events, err := ReadTimeline(dir); err != nil || len(events) != 1 {
+		t.Errorf("events = %+v, %v, want the buffered event", events, err)
+	}
+}
+
+// BenchmarkTimelineWriter reports the peak heap in use while emitting b.N
+// events, which stays flat whatever their number; emit 10M events with
+// -benchtime 10000000x. The timeline goes to the null device.
+func BenchmarkTimelineWriter(b *testing.B) {
+	dir := b.TempDir()
+	b.Setenv(EnvOutputDir, dir)
+	b.Setenv(EnvEvidenceUID, "ev-1")
+	if err := os.Symlink(os.DevNull, filepath.Join(dir, TimelineFile)); err != nil {
+		b.Skip(err)
+	}
+	w, err := NewTimelineWriter()
+	if err != nil {
+		b.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	fields := map[string]any{"inode": 42, "path": `C:\Windows\System32\cmd.exe`}
+	var m runtime.MemStats
+	var peak uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.Emit(at.Add(time.Duration(i)), "mft", "file created", fields); err != nil {
+			b.Fatal(err)
+		}
+		if i%(1<<18) == 0 {
+			runtime.ReadMemStats(&m)
+			peak = max(peak, m.HeapInuse)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MiB")
+}
@@ -0,0 +1,103 @@
+package sandbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sdkVersion is the version of this SDK. Its major number is the version
+// of the output contract, OutputVersion.
+const sdkVersion = "2.0.0"
+
+// OutputVersion is the version of the output contract this SDK writes and
+// the latest one it reads. It is raised only by incompatible changes to
+// the records of the NDJSON files: new optional fields do not change it.
+// Files without a header were written by SDKs before version 2, or by
+// scripts that write the files themselves, and are read as version 1.
+const OutputVersion = 2
+
+// ErrUnsupportedOutputVersion is returned when reading an NDJSON file
+// written by an SDK whose output version is newer than OutputVersion:
+// its records are not read rather than misparsed.
+var ErrUnsupportedOutputVersion = errors.New("sandbox: output written by a newer SDK")
+
+// Version returns the version of the SDK, e.g. "2.0.0", which it stamps in
+// the header line of every NDJSON file it writes.
+func Version() string { return sdkVersion }
+
+// outputHeader is the first line of every NDJSON file the SDK writes.
+type outputHeader struct {
+	SDKVersion string `json:"sdk_version"`
+}
+
+// headerLine is the header of the files this SDK writes.
+var headerLine = func() []byte {
+	line, _ := json.Marshal(outputHeader{SDKVersion: sdkVersion})
+	return append(line, '\n')
+}()
+
+// ParseHeader reports whether line, read from an NDJSON output file, is
+// its header line and returns the SDK version it carries. Records never
+// have a top-level sdk_version field.
+func ParseHeader(line []byte) (string, bool) {
+	if !bytes.Contains(line, []byte(`"sdk_version"`)) {
+		return "", false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(line, &fields) != nil || len(fields) != 1 {
+		return "", false
+	}
+	var h outputHeader
+	if json.Unmarshal(line, &h) != nil {
+		return "", false
+	}
+	return h.SDKVersion, true
+}
+
+// outputVersion returns the output version of the SDK version sdk, its
+// major number.
+func outputVersion(sdk string) (int, error) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(sdk, "v"), ".")
+	v, err := strconv.Atoi(major)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid sdk_version %q", sdk)
+	}
+	return v, nil
+}
+
+// migrations upgrade the records of an output version to the next one:
+// migrations[v][file] rewrites, in place, a record of file written with
+// version v into its version v+1 form. A file without an entry needs no
+// change between the two versions.
+var migrations = map[int]map[string]func(map[string]any) error{
+	// Version 2 only adds the header line: records are unchanged.
+	1: nil,
+}
+
+// migrateRecord upgrades line, a record of file written with output
+// version from, to OutputVersion.
+func migrateRecord(file string, from int, line []byte) ([]byte, error) {
+	var steps []func(map[string]any) error
+	for v := from; v < OutputVersion; v++ {
+		if m := migrations[v][file]; m != nil {
+			steps = append(steps, m)
+		}
+	}
+	if len(steps) == 0 {
+		return line, nil
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, err
+	}
+	for _, m := range steps {
+		if err := m(rec); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(rec)
+}
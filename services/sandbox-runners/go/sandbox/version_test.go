@@ -0,0 +1,101 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputHeader(t *testing.T) {
+	dir := setupEnv(t)
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmitResult(Result{EvidenceUID: "ev-1", Severity: SeverityLow, Title: "two"}); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewTimelineWriter(WithFlushInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Emit(time.Unix(0, 0), "mft", "created", nil)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// readLines checks the header, which is written once per file.
+	if lines := readLines(t, filepath.Join(dir, ResultsFile)); len(lines) != 2 {
+		t.Errorf("results = %q", lines)
+	}
+	if lines := readLines(t, filepath.Join(dir, TimelineFile)); len(lines) != 1 {
+		t.Errorf("timeline = %q", lines)
+	}
+	if results, err := ReadResults(dir); err != nil || len(results) != 2 {
+		t.Errorf("ReadResults = %+v, %v", results, err)
+	}
+	if v, ok := ParseHeader([]byte(`{"sdk_version":"` + Version() + `"}`)); !ok || v != Version() {
+		t.Errorf("ParseHeader = %q, %v", v, ok)
+	}
+	if _, ok := ParseHeader([]byte(`{"sdk_version":"2.0.0","title":"x"}`)); ok {
+		t.Error("record with an sdk_version field taken for a header")
+	}
+}
+
+func TestReadOutputWithoutHeader(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, IOCsFile), []byte(`{"evidence_uid":"ev-1","kind":"domain","value":"evil.example"}`+"\n"), 0o644)
+	iocs, err := ReadIOCs(dir)
+	if err != nil || len(iocs) != 1 || iocs[0].Value != "evil.example" {
+		t.Errorf("ReadIOCs = %+v, %v, want the version 1 record read", iocs, err)
+	}
+}
+
+func TestReadOutputMigratesRecords(t *testing.T) {
+	saved := migrations[1]
+	defer func() { migrations[1] = saved }()
+	migrations[1] = map[string]func(map[string]any) error{
+		ResultsFile: func(rec map[string]any) error {
+			rec["title"] = rec["name"]
+			delete(rec, "name")
+			return nil
+		},
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ResultsFile), []byte(
+		`{"evidence_uid":"ev-1","severity":"low","name":"legacy"}`+"\n"+
+			`{"sdk_version":"2.1.0"}`+"\n"+
+			`{"evidence_uid":"ev-1","severity":"low","title":"current"}`+"\n"), 0o644)
+	results, err := ReadResults(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Title != "legacy" || results[1].Title != "current" {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestReadOutputRejectsNewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, FactsFile), []byte(
+		`{"evidence_uid":"ev-1","key":"host.name","value":"WS-042","confidence":1}`+"\n"+
+			`{"sdk_version":"3.0.0"}`+"\n"+
+			`{"evidence_uid":"ev-1","key":"os.version","value":"Windows 11","confidence":1}`+"\n"), 0o644)
+	facts, err := ReadFacts(dir)
+	if !errors.Is(err, ErrUnsupportedOutputVersion) || !strings.Contains(err.Error(), "SDK 3.0.0") {
+		t.Errorf("err = %v, want ErrUnsupportedOutputVersion naming the SDK", err)
+	}
+	if len(facts) != 1 || facts[0].Key != "host.name" {
+		t.Errorf("facts = %+v, want only the record before the header", facts)
+	}
+	if len(RecordErrors(err)) != 0 {
+		t.Errorf("records past the header quarantined: %v", RecordErrors(err))
+	}
+
+	os.WriteFile(filepath.Join(dir, FactsFile), []byte(`{"sdk_version":"next"}`+"\n"), 0o644)
+	if recs := RecordErrors(func() error { _, err := ReadFacts(dir); return err }()); len(recs) != 1 || recs[0].Line != 1 {
+		t.Errorf("invalid header = %v, want line 1 rejected", recs)
+	}
+}
@@ -0,0 +1,83 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// WarningsFile is the name of the warnings file inside OUTPUT_DIR, shown by
+// the platform with the job result.
+const WarningsFile = "warnings.ndjson"
+
+// ErrInvalidWarning is returned for a warning with a malformed code or an
+// empty message.
+var ErrInvalidWarning = errors.New("sandbox: invalid warning")
+
+// warningCode is the format of Warning.Code: lowercase dotted words.
+var warningCode = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+
+// Warning is one line of warnings.ndjson: a recoverable issue that limits
+// the completeness of the parse, such as a truncated record. It is neither
+// a finding nor a failure of the job.
+type Warning struct {
+	// EvidenceUID is EVIDENCE_UID when it is set.
+	EvidenceUID string `json:"evidence_uid,omitempty"`
+	// Code identifies the kind of issue across runs, for aggregation, in
+	// lowercase dotted words, e.g. "mft.truncated_record".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields carries the details of the issue, e.g. the record number.
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+func (w Warning) validate() error {
+	switch {
+	case len(w.Code) > 128 || !warningCode.MatchString(w.Code):
+		return fmt.Errorf("%w: code %q, want lowercase dotted words", ErrInvalidWarning, w.Code)
+	case strings.TrimSpace(w.Message) == "":
+		return fmt.Errorf("%w: %s has an empty message", ErrInvalidWarning, w.Code)
+	}
+	return nil
+}
+
+// ValidateWarning checks warning against the schema of warnings.ndjson,
+// which the orchestrator enforces on every line after the run. Warn calls
+// it.
+func ValidateWarning(warning Warning) error {
+	if err := warning.validate(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(warning)
+	if err != nil {
+		return fmt.Errorf("sandbox: invalid warning: %w", err)
+	}
+	if err := validateRecord(WarningsFile, line); err != nil {
+		return fmt.Errorf("sandbox: invalid warning: %w", err)
+	}
+	return nil
+}
+
+// Warn appends a warning to warnings.ndjson in OUTPUT_DIR, e.g.
+//
+//	sandbox.Warn("mft.truncated_record", "record cut by the end of the image", map[string]any{"record": 4711})
+//
+// fields may be nil. It is safe for concurrent use.
+func Warn(code, message string, fields map[string]any) error {
+	warning := Warning{EvidenceUID: os.Getenv(EnvEvidenceUID), Code: code, Message: message, Fields: fields}
+	if err := ValidateWarning(warning); err != nil {
+		return err
+	}
+	return appendRecord(WarningsFile, warning)
+}
+
+// ReadWarnings parses the warnings in dir; a missing file means none.
+// Malformed lines and warnings that do not match the schema are skipped
+// and reported in err, after the warnings that could be read;
+// RecordErrors lists them.
+func ReadWarnings(dir string) ([]Warning, error) {
+	return readRecords(dir, WarningsFile, Warning.validate)
+}
@@ -0,0 +1,47 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarn(t *testing.T) {
+	dir := setupEnv(t)
+	if err := Warn("mft.truncated_record", "record cut by the end of the image", map[string]any{"record": 4711}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Warn("registry.unknown_version", "hive version 1.7", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"", "MFT.Truncated", "mft..record"} {
+		if err := Warn(code, "x", nil); !errors.Is(err, ErrInvalidWarning) {
+			t.Errorf("code %q: err = %v, want ErrInvalidWarning", code, err)
+		}
+	}
+	if err := Warn("mft.truncated_record", " ", nil); !errors.Is(err, ErrInvalidWarning) {
+		t.Errorf("empty message: err = %v, want ErrInvalidWarning", err)
+	}
+	// Lines written by scripts in other languages are checked on reading.
+	f, err := os.OpenFile(filepath.Join(dir, WarningsFile), os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"code":"mft.truncated_record","message":"x","fields":[1]}` + "\n")
+	f.Close()
+
+	warnings, err := ReadWarnings(dir)
+	if len(warnings) != 2 {
+		t.Fatalf("warnings = %+v", warnings)
+	}
+	if w := warnings[0]; w.EvidenceUID != "ev-1" || w.Code != "mft.truncated_record" || w.Fields["record"] != float64(4711) {
+		t.Errorf("warning = %+v", w)
+	}
+	if w := warnings[1]; w.Code != "registry.unknown_version" || w.Fields != nil {
+		t.Errorf("warning = %+v", w)
+	}
+	if recs := RecordErrors(err); len(recs) != 1 || recs[0].Line != 4 {
+		t.Errorf("err = %v, want line 4 rejected", err)
+	}
+}
@@ -0,0 +1,217 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// YaraBinary is the command run by YaraScan; the Go runner image installs
+// yara on PATH.
+var YaraBinary = "yara"
+
+// yaraCompiledMagic starts the files written by yarac.
+const yaraCompiledMagic = "YARA"
+
+// YaraMatch is a rule that matched a scanned file.
+type YaraMatch struct {
+	Rule string `json:"rule"`
+	// File is the matching file, under the scanned path when a directory
+	// was scanned.
+	File string `json:"file"`
+	// Meta holds the rule's metadata, string values unquoted.
+	Meta    map[string]string `json:"meta,omitempty"`
+	Strings []YaraString      `json:"strings,omitempty"`
+}
+
+// YaraString is one occurrence of a rule string in the file.
+type YaraString struct {
+	Offset     int64  `json:"offset"`
+	Identifier string `json:"identifier"`
+	// Data is the matched data as printed by yara, with non-printable
+	// bytes escaped.
+	Data string `json:"data"`
+}
+
+// YaraScan scans path, a file or a directory scanned recursively, with
+// the rules at rulesPath, in source or yarac-compiled form. An empty
+// rulesPath uses YARA_RULES_PATH, where the orchestrator mounts the job's
+// ruleset.
+func YaraScan(path, rulesPath string) ([]YaraMatch, error) {
+	if rulesPath == "" {
+		var err error
+		if rulesPath, err = MustGetEnv(EnvYaraRulesPath); err != nil {
+			return nil, err
+		}
+	}
+	compiled, err := isCompiledRules(rulesPath)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: yara rules: %w", err)
+	}
+	args := []string{"-m", "-s", "-w"}
+	if compiled {
+		args = append(args, "-C")
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		args = append(args, "-r")
+	}
+	args = append(args, rulesPath, path)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(YaraBinary, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("sandbox: yara: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("sandbox: yara: %w", err)
+	}
+	return parseYaraOutput(stdout.String())
+}
+
+func isCompiledRules(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	magic := make([]byte, len(yaraCompiledMagic))
+	n, _ := f.Read(magic)
+	return string(magic[:n]) == yaraCompiledMagic, nil
+}
+
+// parseYaraOutput parses the output of `yara -m -s`: a line per match,
+// `rule [meta] file`, followed by a line per string, `0xoffset:$id: data`.
+func parseYaraOutput(out string) ([]YaraMatch, error) {
+	var matches []YaraMatch
+	sc := bufio.NewScanner(strings.NewReader(out))
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "0x") {
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("sandbox: yara: string before any rule: %q", line)
+			}
+			s, err := parseYaraString(line)
+			if err != nil {
+				return nil, err
+			}
+			m := &matches[len(matches)-1]
+			m.Strings = append(m.Strings, s)
+			continue
+		}
+		m, err := parseYaraRule(line)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, sc.Err()
+}
+
+func parseYaraRule(line string) (YaraMatch, error) {
+	rule, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return YaraMatch{}, fmt.Errorf("sandbox: yara: malformed match %q", line)
+	}
+	m := YaraMatch{Rule: rule}
+	if strings.HasPrefix(rest, "[") {
+		meta, n, err := parseYaraMeta(rest)
+		if err != nil {
+			return YaraMatch{}, fmt.Errorf("sandbox: yara: %w in %q", err, line)
+		}
+		if len(meta) > 0 {
+			m.Meta = meta
+		}
+		rest = strings.TrimPrefix(rest[n:], " ")
+	}
+	m.File = rest
+	return m, nil
+}
+
+// parseYaraMeta parses `[key="value",key=1]` at the start of s and returns
+// the length it spans.
+func parseYaraMeta(s string) (map[string]string, int, error) {
+	meta := map[string]string{}
+	i := 1
+	for i < len(s) && s[i] != ']' {
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, 0, errors.New("unterminated metadata")
+		}
+		key := s[i : i+eq]
+		i += eq + 1
+		var value strings.Builder
+		if i < len(s) && s[i] == '"' {
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			i++
+		} else {
+			for ; i < len(s) && s[i] != ',' && s[i] != ']'; i++ {
+				value.WriteByte(s[i])
+			}
+		}
+		meta[key] = value.String()
+		if i < len(s) && s[i] == ',' {
+			i++
+		}
+	}
+	if i >= len(s) {
+		return nil, 0, errors.New("unterminated metadata")
+	}
+	return meta, i + 1, nil
+}
+
+func parseYaraString(line string) (YaraString, error) {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 {
+		return YaraString{}, fmt.Errorf("sandbox: yara: malformed string match %q", line)
+	}
+	offset, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "0x"), 16, 64)
+	if err != nil {
+		return YaraString{}, fmt.Errorf("sandbox: yara: malformed string match %q", line)
+	}
+	return YaraString{
+		Offset:     offset,
+		Identifier: parts[1],
+		Data:       strings.TrimPrefix(parts[2], " "),
+	}, nil
+}
+
+// Result turns m into a finding on evidenceUID, ready for EmitResult. The
+// severity is the rule's "severity" metadata, SeverityMedium when it has
+// none or an unknown one. Matches of the same rule on the same evidence
+// share a FindingKey.
+func (m YaraMatch) Result(evidenceUID string) Result {
+	severity, err := ParseSeverity(m.Meta["severity"])
+	if err != nil {
+		severity = SeverityMedium
+	}
+	data := map[string]any{"rule": m.Rule, "file": m.File}
+	if len(m.Meta) > 0 {
+		data["meta"] = m.Meta
+	}
+	if len(m.Strings) > 0 {
+		data["strings"] = m.Strings
+	}
+	return Result{
+		EvidenceUID: evidenceUID,
+		Severity:    severity,
+		Title:       "YARA rule " + m.Rule + " matched",
+		FindingKey:  "yara/" + m.Rule + "/" + evidenceUID,
+		Description: m.Meta["description"],
+		Data:        data,
+	}
+}
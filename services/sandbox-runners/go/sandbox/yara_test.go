@@ -0,0 +1,112 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const yaraOutput = `Suspicious_PE [author="ir team",severity="high",description="PE with \"packed\" sections",score=80] /evidence/mem.raw
+0x1000:$mz: MZ
+0x1f40:$upx: UPX!
+Empty_Meta [] /evidence/dir with spaces/b.bin
+`
+
+func TestParseYaraOutput(t *testing.T) {
+	matches, err := parseYaraOutput(yaraOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []YaraMatch{
+		{
+			Rule: "Suspicious_PE",
+			File: "/evidence/mem.raw",
+			Meta: map[string]string{
+				"author":      "ir team",
+				"severity":    "high",
+				"description": `PE with "packed" sections`,
+				"score":       "80",
+			},
+			Strings: []YaraString{
+				{Offset: 0x1000, Identifier: "$mz", Data: "MZ"},
+				{Offset: 0x1f40, Identifier: "$upx", Data: "UPX!"},
+			},
+		},
+		{Rule: "Empty_Meta", File: "/evidence/dir with spaces/b.bin"},
+	}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("matches = %+v\nwant %+v", matches, want)
+	}
+
+	for _, bad := range []string{"0x10:$a: x\n", "Rule [a=\"b\" /f\n", "Rule\n"} {
+		if _, err := parseYaraOutput(bad); err == nil {
+			t.Errorf("parseYaraOutput(%q) should fail", bad)
+		}
+	}
+}
+
+func TestYaraScan(t *testing.T) {
+	dir := t.TempDir()
+	// A stand-in for yara that records its arguments.
+	bin := filepath.Join(dir, "yara")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat <<'OUT'\n" + yaraOutput + "OUT\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { YaraBinary = old }(YaraBinary)
+	YaraBinary = bin
+
+	rules := filepath.Join(dir, "rules.yarc")
+	os.WriteFile(rules, []byte("YARA\x02\x00"), 0644)
+	t.Setenv(EnvYaraRulesPath, rules)
+
+	matches, err := YaraScan(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("matches = %+v", matches)
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if got, want := strings.TrimSpace(string(args)), "-m -s -w -C -r "+rules+" "+dir; got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+
+	os.WriteFile(bin, []byte("#!/bin/sh\necho 'error: could not open file' >&2\nexit 1\n"), 0755)
+	if _, err := YaraScan(rules, rules); err == nil || !strings.Contains(err.Error(), "could not open file") {
+		t.Errorf("err = %v, want yara's stderr", err)
+	}
+}
+
+func TestYaraMatchResult(t *testing.T) {
+	dir := setupEnv(t)
+	matches, _ := parseYaraOutput(yaraOutput)
+	for _, m := range matches {
+		if err := EmitResult(m.Result("ev-1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := readLines(t, filepath.Join(dir, ResultsFile))
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var r Result
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Severity != SeverityHigh || r.Title != "YARA rule Suspicious_PE matched" || r.Description != `PE with "packed" sections` {
+		t.Errorf("result = %+v", r)
+	}
+	if strs, _ := r.Data["strings"].([]any); len(strs) != 2 {
+		t.Errorf("strings = %v", r.Data["strings"])
+	}
+	if matches[1].Result("ev-1").Severity != SeverityMedium {
+		t.Error("default severity is not medium")
+	}
+	if r.FindingKey != "yara/Suspicious_PE/ev-1" {
+		t.Errorf("finding key = %q", r.FindingKey)
+	}
+}
@@ -0,0 +1,419 @@
+// Package sandboxtest runs sandbox scripts locally, without Docker, for
+// their unit tests: the script gets the environment contract of a sandbox
+// container, with fixture files as evidence and a temporary OUTPUT_DIR,
+// and the outputs it wrote are read back with the SDK.
+package sandboxtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+// Defaults of Config.
+const (
+	DefaultCaseID      = "case-test"
+	DefaultEvidenceUID = "ev-test"
+)
+
+// contractEnv lists the variables an outer environment could leak into a
+// local run; they are cleared before the configured ones are set.
+var contractEnv = []string{
+	sandbox.EnvCaseID,
+	sandbox.EnvEvidenceUID,
+	sandbox.EnvEvidencePath,
+	sandbox.EnvOutputDir,
+	sandbox.EnvEvidenceSHA256,
+	sandbox.EnvEvidenceHashAlgo,
+	sandbox.EnvEvidenceCompression,
+	sandbox.EnvEvidenceType,
+	sandbox.EnvEvidenceOffset,
+	sandbox.EnvEvidenceLength,
+	sandbox.EnvEvidenceMetaPath,
+	sandbox.EnvEvidenceBlockDev,
+	sandbox.EnvEvidenceCount,
+	sandbox.EnvEvidenceFetchSocket,
+	sandbox.EnvFindingQuerySocket,
+	sandbox.EnvYaraRulesPath,
+	sandbox.EnvSharedDir,
+	sandbox.EnvContextPath,
+	sandbox.EnvMemoryLimitBytes,
+	sandbox.EnvCPUCount,
+	sandbox.EnvScratchDir,
+	sandbox.EnvSecretsDir,
+	sandbox.EnvRunTime,
+	sandbox.EnvSeed,
+	sandbox.EnvOutputMode,
+	sandbox.EnvMaxFindings,
+	sandbox.EnvMaxArtifacts,
+	sandbox.EnvMaxTimelineEvents,
+}
+
+// Evidence is a fixture file standing for an evidence item.
+type Evidence struct {
+	// UID defaults to DefaultEvidenceUID for the first item and to
+	// "<DefaultEvidenceUID>-<n>" for the others.
+	UID  string
+	Path string
+	// Type is the evidence type of EVIDENCE_TYPE and the case context,
+	// e.g. sandbox.EvidenceTypePCAP; it is detected from the fixture when
+	// empty, as the orchestrator does.
+	Type string
+	// Compression is "gzip" or "zstd" for a compressed fixture.
+	Compression string
+	// Offset and Length limit the script to a byte range of the fixture,
+	// as Evidence.Offset and Evidence.Length in the orchestrator.
+	Offset int64
+	Length int64
+	// Meta is an evidence metadata sidecar fixture for EVIDENCE_META_PATH,
+	// validated as the orchestrator does.
+	Meta string
+}
+
+// Config describes the job a script is run for.
+type Config struct {
+	// CaseID defaults to DefaultCaseID.
+	CaseID string
+	// CaseName, CaseNumber and Examiner are given in the case context
+	// only.
+	CaseName   string
+	CaseNumber string
+	Examiner   string
+	// Evidence are the job's evidence items: the first one is
+	// EVIDENCE_PATH, with its SHA256 as EVIDENCE_SHA256, and several set
+	// EVIDENCE_COUNT and the EVIDENCE_*_<n> variables.
+	Evidence []Evidence
+	// Params are extra environment variables, as Job.Params.
+	Params map[string]string
+	// Secrets are the values of sandbox.Secret by name, as Job.Secrets.
+	Secrets map[string]string
+	// YaraRules is a ruleset file for YARA_RULES_PATH.
+	YaraRules string
+	// Limits set SANDBOX_MEMORY_LIMIT_BYTES and SANDBOX_CPU_COUNT.
+	Limits sandbox.ResourceLimits
+	// RunTime sets SANDBOX_RUN_TIME, the time of sandbox.Now, for tests
+	// that compare records stamped with it.
+	RunTime time.Time
+	// Seed sets SANDBOX_SEED, the seed of sandbox.Rand, when not zero.
+	Seed int64
+	// OutputMode sets SANDBOX_OUTPUT_MODE, e.g. "0640", the mode of the
+	// files sandbox.OutputMode reports.
+	OutputMode string
+	// SharedDir sets SHARED_DIR, the shared directory of the case, e.g. a
+	// temporary directory several runs of a test share.
+	SharedDir string
+}
+
+// Output is what a script left in its OUTPUT_DIR.
+type Output struct {
+	// Dir is the OUTPUT_DIR of the run, removed at the end of the test.
+	Dir       string
+	Results   []sandbox.Result
+	Timeline  []sandbox.TimelineEvent
+	IOCs      []sandbox.IOC
+	Facts     []sandbox.Fact
+	Warnings  []sandbox.Warning
+	Graph     []sandbox.Edge
+	Artifacts []sandbox.Artifact
+	// Panic is the panic sandbox.Recover caught, if any.
+	Panic *sandbox.PanicRecord
+	// NotApplicable is what sandbox.SkipNotApplicable recorded, if the
+	// script skipped its evidence.
+	NotApplicable *sandbox.NotApplicable
+	// Stdout and Stderr are set by GoRun.
+	Stdout, Stderr string
+}
+
+// env returns the contract variables of cfg, with OUTPUT_DIR set to dir and
+// SANDBOX_SCRATCH_DIR to scratch, and writes the case context of
+// sandbox.Context at contextPath and the secrets beside it.
+func (cfg Config) env(dir, scratch, contextPath string) (map[string]string, error) {
+	env := map[string]string{}
+	for k, v := range cfg.Params {
+		if sandbox.IsReservedParam(k) {
+			return nil, fmt.Errorf("sandboxtest: parameter %s uses a reserved prefix", k)
+		}
+		env[k] = v
+	}
+	env[sandbox.EnvCaseID] = cfg.CaseID
+	if env[sandbox.EnvCaseID] == "" {
+		env[sandbox.EnvCaseID] = DefaultCaseID
+	}
+	env[sandbox.EnvOutputDir] = dir
+	env[sandbox.EnvScratchDir] = scratch
+	caseCtx := sandbox.CaseContext{
+		Version:    sandbox.ContextVersion,
+		CaseID:     env[sandbox.EnvCaseID],
+		CaseName:   cfg.CaseName,
+		CaseNumber: cfg.CaseNumber,
+		Examiner:   cfg.Examiner,
+		Evidence:   []sandbox.ContextEvidence{},
+		Params:     cfg.Params,
+	}
+	for i, ev := range cfg.Evidence {
+		uid := ev.UID
+		if uid == "" {
+			uid = DefaultEvidenceUID
+			if i > 0 {
+				uid += "-" + strconv.Itoa(i)
+			}
+		}
+		if ev.Type == "" {
+			ev.Type = detectType(ev)
+		}
+		item := sandbox.ContextEvidence{UID: uid, Path: ev.Path, Type: ev.Type, Compression: ev.Compression, Offset: ev.Offset, Length: ev.Length}
+		if i == 0 {
+			sum, err := fileSHA256(ev.Path)
+			if err != nil {
+				return nil, err
+			}
+			env[sandbox.EnvEvidenceSHA256] = sum
+			item.SHA256 = sum
+		}
+		caseCtx.Evidence = append(caseCtx.Evidence, item)
+		vars := map[string]string{
+			sandbox.EnvEvidenceUID:  uid,
+			sandbox.EnvEvidencePath: ev.Path,
+		}
+		if ev.Compression != "" {
+			vars[sandbox.EnvEvidenceCompression] = ev.Compression
+		}
+		if ev.Type != "" {
+			vars[sandbox.EnvEvidenceType] = ev.Type
+		}
+		if ev.Offset > 0 {
+			vars[sandbox.EnvEvidenceOffset] = strconv.FormatInt(ev.Offset, 10)
+		}
+		if ev.Length > 0 {
+			vars[sandbox.EnvEvidenceLength] = strconv.FormatInt(ev.Length, 10)
+		}
+		if ev.Meta != "" {
+			if _, err := sandbox.ReadEvidenceMeta(ev.Meta); err != nil {
+				return nil, err
+			}
+			vars[sandbox.EnvEvidenceMetaPath] = ev.Meta
+		}
+		for k, v := range vars {
+			if i == 0 {
+				env[k] = v
+			}
+			if len(cfg.Evidence) > 1 {
+				env[sandbox.IndexedEnv(k, i)] = v
+			}
+		}
+	}
+	if len(cfg.Evidence) > 1 {
+		env[sandbox.EnvEvidenceCount] = strconv.Itoa(len(cfg.Evidence))
+	}
+	if cfg.YaraRules != "" {
+		env[sandbox.EnvYaraRulesPath] = cfg.YaraRules
+	}
+	if cfg.Limits.MemoryBytes > 0 {
+		env[sandbox.EnvMemoryLimitBytes] = strconv.FormatInt(cfg.Limits.MemoryBytes, 10)
+	}
+	if cfg.Limits.CPUs > 0 {
+		env[sandbox.EnvCPUCount] = strconv.FormatFloat(cfg.Limits.CPUs, 'g', -1, 64)
+	}
+	if !cfg.RunTime.IsZero() {
+		env[sandbox.EnvRunTime] = cfg.RunTime.UTC().Format(time.RFC3339Nano)
+	}
+	if cfg.Seed != 0 {
+		env[sandbox.EnvSeed] = strconv.FormatInt(cfg.Seed, 10)
+	}
+	if cfg.SharedDir != "" {
+		env[sandbox.EnvSharedDir] = cfg.SharedDir
+	}
+	if cfg.OutputMode != "" {
+		if _, err := sandbox.ParseOutputMode(cfg.OutputMode); err != nil {
+			return nil, err
+		}
+		env[sandbox.EnvOutputMode] = cfg.OutputMode
+	}
+	if len(cfg.Secrets) > 0 {
+		secrets := filepath.Join(filepath.Dir(contextPath), "secrets")
+		if err := os.Mkdir(secrets, 0o700); err != nil {
+			return nil, err
+		}
+		for name, value := range cfg.Secrets {
+			if err := sandbox.ValidateSecretName(name); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(secrets, name), []byte(value), 0o400); err != nil {
+				return nil, err
+			}
+		}
+		env[sandbox.EnvSecretsDir] = secrets
+	}
+	data, err := json.Marshal(caseCtx)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(contextPath, data, 0o644); err != nil {
+		return nil, err
+	}
+	env[sandbox.EnvContextPath] = contextPath
+	return env, nil
+}
+
+// detectType returns the type of the fixture ev, "" when it is not
+// recognized or cannot be read.
+func detectType(ev Evidence) string {
+	f, err := os.Open(ev.Path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	r, err := sandbox.Decompress(f, ev.Compression)
+	if err != nil {
+		return ""
+	}
+	defer r.Close()
+	if _, err := io.CopyN(io.Discard, r, ev.Offset); err != nil {
+		return ""
+	}
+	header := make([]byte, sandbox.EvidenceTypeHeaderSize)
+	n, _ := io.ReadFull(r, header)
+	return sandbox.DetectEvidenceType(header[:n], strings.TrimSuffix(ev.Path, compressedExt(ev.Compression)))
+}
+
+// compressedExt is the usual extension of a file compressed with c.
+func compressedExt(c string) string {
+	switch strings.ToLower(c) {
+	case sandbox.CompressionGzip:
+		return ".gz"
+	case sandbox.CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("sandboxtest: evidence fixture: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("sandboxtest: evidence fixture: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LocalRun runs script in-process with the environment of cfg and returns
+// its outputs. The environment is restored at the end of the test, so
+// LocalRun cannot be used in parallel tests. The error joins the one
+// script returned with those reading its outputs, among which the
+// rejected records of sandbox.RecordErrors.
+func LocalRun(t testing.TB, cfg Config, script func() error) (*Output, error) {
+	t.Helper()
+	dir := t.TempDir()
+	env, err := cfg.env(dir, t.TempDir(), filepath.Join(t.TempDir(), sandbox.ContextFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range contractEnv {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	runErr := script()
+	out, err := readOutput(dir)
+	return out, errors.Join(runErr, err)
+}
+
+// GoRun runs the script package pkg, e.g. "." or "./cmd/parser", with
+// `go run` and the environment of cfg, and returns its outputs. The error
+// includes the script's stderr when it exits with a non-zero code.
+func GoRun(t testing.TB, pkg string, cfg Config) (*Output, error) {
+	t.Helper()
+	dir := t.TempDir()
+	env, err := cfg.env(dir, t.TempDir(), filepath.Join(t.TempDir(), sandbox.ContextFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", pkg)
+	for _, kv := range os.Environ() {
+		if k, _, _ := strings.Cut(kv, "="); !isContractEnv(k) {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	if runErr != nil {
+		runErr = fmt.Errorf("sandboxtest: go run %s: %w\n%s", pkg, runErr, stderr.String())
+	}
+	out, err := readOutput(dir)
+	if out != nil {
+		out.Stdout, out.Stderr = stdout.String(), stderr.String()
+	}
+	return out, errors.Join(runErr, err)
+}
+
+func isContractEnv(key string) bool {
+	for _, k := range contractEnv {
+		if key == k || strings.HasPrefix(key, k+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+// readOutput reads the results, timeline, IOCs, facts, warnings, graph
+// edges, artifact manifest and recovered panic in dir.
+// Records that could be read are returned along with the errors.
+func readOutput(dir string) (*Output, error) {
+	out := &Output{Dir: dir}
+	var errs []error
+	var err error
+	if out.Results, err = sandbox.ReadResults(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.Timeline, err = sandbox.ReadTimeline(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.IOCs, err = sandbox.ReadIOCs(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.Facts, err = sandbox.ReadFacts(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.Warnings, err = sandbox.ReadWarnings(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.Graph, err = sandbox.ReadGraph(dir); err != nil {
+		errs = append(errs, err)
+	}
+	manifest, err := sandbox.ReadManifest(dir)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		out.Artifacts = manifest.Artifacts
+	}
+	if out.Panic, err = sandbox.ReadPanic(dir); err != nil {
+		errs = append(errs, err)
+	}
+	if out.NotApplicable, err = sandbox.ReadNotApplicable(dir); err != nil {
+		errs = append(errs, err)
+	}
+	return out, errors.Join(errs...)
+}
@@ -0,0 +1,158 @@
+package sandboxtest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLocalRun(t *testing.T) {
+	t.Setenv(sandbox.EnvEvidenceCount, "3")
+	fixture := writeFixture(t, "MZ fixture")
+	cfg := Config{
+		CaseName: "Exfiltration ACME",
+		Evidence: []Evidence{{Path: fixture, Type: "disk_image"}},
+		Params:   map[string]string{"PARAM_FROM": "2024-01-01"},
+		Secrets:  map[string]string{"vt_api_key": "k3y"},
+	}
+
+	out, err := LocalRun(t, cfg, func() error {
+		if err := sandbox.RequireEnv(); err != nil {
+			return err
+		}
+		if err := sandbox.VerifyEvidence(); err != nil {
+			return err
+		}
+		refs, err := sandbox.Evidence()
+		if err != nil {
+			return err
+		}
+		caseCtx, err := sandbox.Context()
+		if err != nil {
+			return err
+		}
+		from, _ := sandbox.GetParam("PARAM_FROM")
+		if key, err := sandbox.Secret("vt_api_key"); err != nil || key != "k3y" {
+			return fmt.Errorf("secret = %q, %v", key, err)
+		}
+		if err := sandbox.EmitResult(sandbox.Result{
+			EvidenceUID: refs[0].UID,
+			Severity:    sandbox.SeverityLow,
+			Title:       caseCtx.CaseName + " (" + caseCtx.Evidence[0].Type + ") from " + from,
+		}); err != nil {
+			return err
+		}
+		at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+		if err := sandbox.EmitTimelineEvent(at, "mft", "created", nil); err != nil {
+			return err
+		}
+		if err := sandbox.EmitIOC(sandbox.IOC{Kind: sandbox.IOCDomain, Value: "evil.example"}); err != nil {
+			return err
+		}
+		if err := sandbox.EmitFact("host.name", "WS-042", 1); err != nil {
+			return err
+		}
+		if err := sandbox.Warn("mft.truncated_record", "record 7 truncated", nil); err != nil {
+			return err
+		}
+		scratch, err := sandbox.TempFile("mft-*.tmp")
+		if err != nil {
+			return err
+		}
+		scratch.Close()
+		dir, _ := sandbox.MustGetEnv(sandbox.EnvOutputDir)
+		report := filepath.Join(dir, "report.txt")
+		if err := os.WriteFile(report, []byte("ok"), 0o644); err != nil {
+			return err
+		}
+		return sandbox.RegisterArtifact(report, sandbox.ArtifactReport, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Results) != 1 || out.Results[0].EvidenceUID != DefaultEvidenceUID || out.Results[0].Title != "Exfiltration ACME (disk_image) from 2024-01-01" {
+		t.Errorf("results = %+v", out.Results)
+	}
+	if len(out.Timeline) != 1 || out.Timeline[0].Source != "mft" {
+		t.Errorf("timeline = %+v", out.Timeline)
+	}
+	if len(out.IOCs) != 1 || out.IOCs[0].EvidenceUID != DefaultEvidenceUID {
+		t.Errorf("iocs = %+v", out.IOCs)
+	}
+	if len(out.Facts) != 1 || out.Facts[0].EvidenceUID != DefaultEvidenceUID || out.Facts[0].Value != "WS-042" {
+		t.Errorf("facts = %+v", out.Facts)
+	}
+	if len(out.Warnings) != 1 || out.Warnings[0].Code != "mft.truncated_record" {
+		t.Errorf("warnings = %+v", out.Warnings)
+	}
+	if len(out.Artifacts) != 1 || out.Artifacts[0].Path != "report.txt" {
+		t.Errorf("artifacts = %+v", out.Artifacts)
+	}
+}
+
+func TestLocalRunReportsRejectedRecords(t *testing.T) {
+	cfg := Config{Evidence: []Evidence{{Path: writeFixture(t, "x")}}}
+	out, err := LocalRun(t, cfg, func() error {
+		dir, _ := sandbox.MustGetEnv(sandbox.EnvOutputDir)
+		line := `{"evidence_uid":"ev-test","severity":"info","title":""}` + "\n"
+		return os.WriteFile(filepath.Join(dir, sandbox.ResultsFile), []byte(line), 0o644)
+	})
+	if len(sandbox.RecordErrors(err)) != 1 || len(out.Results) != 0 {
+		t.Errorf("results = %+v, err = %v", out.Results, err)
+	}
+}
+
+func TestLocalRunMultipleEvidence(t *testing.T) {
+	cfg := Config{CaseID: "case-7", Evidence: []Evidence{
+		{Path: writeFixture(t, "a")},
+		{UID: "hive", Path: writeFixture(t, "regf\x00\x00\x00\x00"), Meta: writeFixture(t, `{"acquired_at":"2024-03-01T12:00:00Z","tool":"KAPE"}`)},
+	}}
+	var refs []sandbox.EvidenceRef
+	_, err := LocalRun(t, cfg, func() error {
+		var err error
+		refs, err = sandbox.Evidence()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].UID != DefaultEvidenceUID || refs[1].UID != "hive" || refs[1].Type != sandbox.EvidenceTypeRegistryHive {
+		t.Errorf("evidence = %+v", refs)
+	}
+	if meta, err := refs[1].Meta(); err != nil || meta.Tool != "KAPE" || refs[0].MetaPath != "" {
+		t.Errorf("evidence metadata = %+v, %v", meta, err)
+	}
+}
+
+func TestGoRun(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not in PATH")
+	}
+	out, err := GoRun(t, "./testdata/script", Config{Evidence: []Evidence{{Path: writeFixture(t, "0123456789")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Results) != 1 || out.Results[0].Title != "10 bytes" {
+		t.Errorf("results = %+v, stderr = %s", out.Results, out.Stderr)
+	}
+
+	// Without evidence, the script fails on OpenEvidence.
+	if _, err := GoRun(t, "./testdata/script", Config{}); err == nil || !strings.Contains(err.Error(), "EVIDENCE_PATH") {
+		t.Errorf("err = %v, want the script's stderr", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Command script is the fixture script of the GoRun test: it reports the
+// size of its evidence.
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
+)
+
+func main() {
+	if err := sandbox.Init(); err != nil {
+		log.Fatal(err)
+	}
+	ev, err := sandbox.OpenEvidence()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer ev.Close()
+	uid, _ := sandbox.MustGetEnv(sandbox.EnvEvidenceUID)
+	err = sandbox.EmitResult(sandbox.Result{
+		EvidenceUID: uid,
+		Severity:    sandbox.SeverityInfo,
+		Title:       fmt.Sprintf("%d bytes", ev.Size()),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
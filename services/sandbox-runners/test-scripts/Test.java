@@ -0,0 +1,75 @@
+// Test program for Java sandbox
+// Verifies environment variables, pre-fetched dependencies and output writing
+
+import java.io.IOException;
+import java.nio.charset.StandardCharsets;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.time.Instant;
+import java.util.ArrayList;
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+
+public class Test {
+    public static void main(String[] args) {
+        System.out.println("=== Java Sandbox Test ===");
+        System.out.println("Java version: " + System.getProperty("java.version"));
+        System.out.println("Max heap: " + Runtime.getRuntime().maxMemory() / (1 << 20) + " MiB");
+        System.out.println();
+
+        // Test environment variables
+        System.out.println("=== Environment Variables ===");
+        Map<String, String> vars = new LinkedHashMap<>();
+        List<String> missing = new ArrayList<>();
+        for (String name : new String[] {"CASE_ID", "EVIDENCE_UID", "EVIDENCE_PATH", "OUTPUT_DIR"}) {
+            String value = System.getenv(name);
+            if (value == null || value.isEmpty()) {
+                value = "NOT_SET";
+                missing.add(name);
+            }
+            vars.put(name, value);
+            System.out.println(name + ": " + value);
+        }
+        System.out.println();
+
+        if (!missing.isEmpty()) {
+            System.out.println("✗ Missing required environment variables: " + String.join(", ", missing));
+            System.exit(1);
+        }
+
+        // Test pre-fetched dependencies
+        for (String cls : new String[] {
+                "com.fasterxml.jackson.databind.ObjectMapper",
+                "org.apache.commons.csv.CSVFormat",
+                "org.apache.commons.compress.archivers.ArchiveStreamFactory",
+                "org.apache.commons.io.FileUtils"}) {
+            try {
+                Class.forName(cls);
+                System.out.println("✓ " + cls + " loaded successfully");
+            } catch (ClassNotFoundException e) {
+                System.out.println("✗ " + cls + " not found on the classpath");
+            }
+        }
+
+        // Test output directory write
+        Path outputFile = Path.of(vars.get("OUTPUT_DIR"), "test_output_java.txt");
+        String content = String.join("\n",
+                "Test output from Java sandbox",
+                "Case ID: " + vars.get("CASE_ID"),
+                "Evidence UID: " + vars.get("EVIDENCE_UID"),
+                "Timestamp: " + Instant.now(),
+                "");
+        try {
+            Files.writeString(outputFile, content, StandardCharsets.UTF_8);
+            System.out.println("✓ Output file written: " + outputFile);
+        } catch (IOException e) {
+            System.out.println("✗ Output write failed: " + e.getMessage());
+            System.exit(1);
+        }
+
+        System.out.println();
+        System.out.println("=== Test Complete ===");
+        System.out.println("Exit code: 0");
+    }
+}
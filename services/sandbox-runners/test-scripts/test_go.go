@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+
+	"github.com/St0n14/datamortem/services/sandbox-runners/go/sandbox"
 )
 
 func main() {
@@ -16,6 +18,14 @@ func main() {
 
 	// Test environment variables
 	fmt.Println("=== Environment Variables ===")
+	if err := sandbox.RequireEnv(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	if err := sandbox.Init(); err != nil {
+		fmt.Printf("✗ %v\n", err)
+		os.Exit(1)
+	}
 	caseID := getEnv("CASE_ID", "NOT_SET")
 	evidenceUID := getEnv("EVIDENCE_UID", "NOT_SET")
 	evidencePath := getEnv("EVIDENCE_PATH", "NOT_SET")
@@ -27,26 +37,65 @@ func main() {
 	fmt.Printf("OUTPUT_DIR: %s\n", outputDir)
 	fmt.Println()
 
-	// Test output directory write
-	if outputDir != "NOT_SET" {
-		outputPath := filepath.Join(outputDir, "test_output_go.txt")
-		content := fmt.Sprintf("Test output from Go sandbox\nCase ID: %s\nEvidence UID: %s\n", caseID, evidenceUID)
-
-		err := os.WriteFile(outputPath, []byte(content), 0644)
-		if err != nil {
-			fmt.Printf("✗ Output write failed: %v\n", err)
-		} else {
-			fmt.Printf("✓ Output file written: %s\n", outputPath)
+	// Test evidence integrity
+	if os.Getenv(sandbox.EnvEvidenceSHA256) != "" {
+		if err := sandbox.VerifyEvidence(); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Println("✓ Evidence integrity verified")
+		fmt.Println()
+	}
+
+	// Test output directory write
+	reportProgress(0, "writing test output")
+	outputPath, err := sandbox.SafeJoin(outputDir, "test_output_go.txt")
+	if err != nil {
+		fmt.Printf("✗ Output path rejected: %v\n", err)
+		os.Exit(1)
+	}
+	content := fmt.Sprintf("Test output from Go sandbox\nCase ID: %s\nEvidence UID: %s\n", caseID, evidenceUID)
+
+	err = sandbox.WriteOutput(outputPath, []byte(content))
+	if err != nil {
+		fmt.Printf("✗ Output write failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✓ Output file written: %s\n", outputPath)
+	if err := sandbox.RegisterArtifact(outputPath, sandbox.ArtifactReport, "Go sandbox test output"); err != nil {
+		fmt.Printf("✗ Artifact registration failed: %v\n", err)
+	} else {
+		fmt.Println("✓ Artifact registered in manifest")
+	}
+
+	reportProgress(0.5, "emitting result")
+	err = sandbox.EmitResult(sandbox.Result{
+		EvidenceUID: evidenceUID,
+		Severity:    "info",
+		Title:       "Go sandbox test",
+		Description: "Environment contract verified",
+		Data:        map[string]any{"go_version": runtime.Version()},
+	})
+	if err != nil {
+		fmt.Printf("✗ Result emit failed: %v\n", err)
 	} else {
-		fmt.Println("⚠ OUTPUT_DIR not set, skipping file write test")
+		fmt.Printf("✓ Result emitted: %s\n", filepath.Join(outputDir, sandbox.ResultsFile))
 	}
+	reportProgress(1, "done")
 
 	fmt.Println()
 	fmt.Println("=== Test Complete ===")
 	fmt.Println("Exit code: 0")
 }
 
+func reportProgress(fraction float64, message string) {
+	if err := sandbox.Progress(fraction, message); err != nil {
+		fmt.Printf("✗ Progress report failed: %v\n", err)
+	} else {
+		fmt.Printf("✓ Progress %.0f%%: %s\n", fraction*100, message)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
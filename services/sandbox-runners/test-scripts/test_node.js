@@ -0,0 +1,64 @@
+#!/usr/bin/env node
+/**
+ * Test script for Node.js sandbox.
+ * Verifies environment variables, pre-installed modules and output writing.
+ */
+"use strict";
+
+const fs = require("fs");
+const path = require("path");
+
+function main() {
+  console.log("=== Node.js Sandbox Test ===");
+  console.log(`Node version: ${process.version}`);
+  console.log(`Timestamp: ${new Date().toISOString()}`);
+  console.log();
+
+  // Test environment variables
+  console.log("=== Environment Variables ===");
+  const env = {};
+  for (const name of ["CASE_ID", "EVIDENCE_UID", "EVIDENCE_PATH", "OUTPUT_DIR"]) {
+    env[name] = process.env[name] || "NOT_SET";
+    console.log(`${name}: ${env[name]}`);
+  }
+  console.log();
+
+  const missing = Object.keys(env).filter((name) => env[name] === "NOT_SET");
+  if (missing.length > 0) {
+    console.log(`✗ Missing required environment variables: ${missing.join(", ")}`);
+    return 1;
+  }
+
+  // Test pre-installed modules
+  for (const module of ["@electron/asar", "sql.js", "csv-stringify"]) {
+    try {
+      require(module);
+      console.log(`✓ ${module} imported successfully`);
+    } catch (e) {
+      console.log(`✗ ${module} import failed: ${e.message}`);
+    }
+  }
+
+  // Test output directory write
+  try {
+    const outputFile = path.join(env.OUTPUT_DIR, "test_output_node.txt");
+    fs.writeFileSync(
+      outputFile,
+      "Test output from Node.js sandbox\n" +
+        `Case ID: ${env.CASE_ID}\n` +
+        `Evidence UID: ${env.EVIDENCE_UID}\n` +
+        `Timestamp: ${new Date().toISOString()}\n`
+    );
+    console.log(`✓ Output file written: ${outputFile}`);
+  } catch (e) {
+    console.log(`✗ Output write failed: ${e.message}`);
+    return 1;
+  }
+
+  console.log();
+  console.log("=== Test Complete ===");
+  console.log("Exit code: 0");
+  return 0;
+}
+
+process.exit(main());
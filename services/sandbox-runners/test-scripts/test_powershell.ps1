@@ -0,0 +1,57 @@
+# Test script for PowerShell sandbox
+# Verifies environment variables, pre-installed modules and output writing
+
+$ErrorActionPreference = "Stop"
+
+Write-Output "=== PowerShell Sandbox Test ==="
+Write-Output "PowerShell version: $($PSVersionTable.PSVersion)"
+Write-Output "Execution policy: $(Get-ExecutionPolicy)"
+Write-Output ""
+
+# Test environment variables
+Write-Output "=== Environment Variables ==="
+$vars = [ordered]@{}
+foreach ($name in "CASE_ID", "EVIDENCE_UID", "EVIDENCE_PATH", "OUTPUT_DIR") {
+    $value = [Environment]::GetEnvironmentVariable($name)
+    if ([string]::IsNullOrEmpty($value)) { $value = "NOT_SET" }
+    $vars[$name] = $value
+    Write-Output "${name}: $value"
+}
+Write-Output ""
+
+$missing = @($vars.Keys | Where-Object { $vars[$_] -eq "NOT_SET" })
+if ($missing.Count -gt 0) {
+    Write-Output "✗ Missing required environment variables: $($missing -join ', ')"
+    exit 1
+}
+
+# Test pre-installed modules
+foreach ($module in "PowerForensics") {
+    try {
+        Import-Module $module
+        Write-Output "✓ $module imported successfully"
+    } catch {
+        Write-Output "✗ $module import failed: $($_.Exception.Message)"
+    }
+}
+
+# Test output directory write
+try {
+    $outputFile = Join-Path $vars["OUTPUT_DIR"] "test_output_powershell.txt"
+    $content = @(
+        "Test output from PowerShell sandbox"
+        "Case ID: $($vars['CASE_ID'])"
+        "Evidence UID: $($vars['EVIDENCE_UID'])"
+        "Timestamp: $((Get-Date).ToUniversalTime().ToString('o'))"
+    )
+    Set-Content -Path $outputFile -Value $content
+    Write-Output "✓ Output file written: $outputFile"
+} catch {
+    Write-Output "✗ Output write failed: $($_.Exception.Message)"
+    exit 1
+}
+
+Write-Output ""
+Write-Output "=== Test Complete ==="
+Write-Output "Exit code: 0"
+exit 0
@@ -26,6 +26,20 @@ def main():
     print(f"OUTPUT_DIR: {output_dir}")
     print()
 
+    missing = [
+        name
+        for name, value in (
+            ("CASE_ID", case_id),
+            ("EVIDENCE_UID", evidence_uid),
+            ("EVIDENCE_PATH", evidence_path),
+            ("OUTPUT_DIR", output_dir),
+        )
+        if value == "NOT_SET"
+    ]
+    if missing:
+        print(f"✗ Missing required environment variables: {', '.join(missing)}")
+        return 1
+
     # Test pandas import (pre-installed library)
     try:
         import pandas as pd
@@ -40,6 +54,14 @@ def main():
     except ImportError as e:
         print(f"✗ dissect import failed: {e}")
 
+    # Test construct and pefile imports (binary format parsing)
+    for module in ("construct", "pefile"):
+        try:
+            __import__(module)
+            print(f"✓ {module} imported successfully")
+        except ImportError as e:
+            print(f"✗ {module} import failed: {e}")
+
     # Test output directory write
     if output_dir and output_dir != "NOT_SET":
         try:
@@ -8,7 +8,7 @@ use std::path::Path;
 
 fn main() {
     println!("=== Rust Sandbox Test ===");
-    println!("Rust version: {}", env!("CARGO_PKG_RUST_VERSION", "unknown"));
+    println!("Package: {} {}", env!("CARGO_PKG_NAME"), env!("CARGO_PKG_VERSION"));
     println!();
 
     // Test environment variables
@@ -24,8 +24,23 @@ fn main() {
     println!("OUTPUT_DIR: {}", output_dir);
     println!();
 
+    let missing: Vec<&str> = [
+        ("CASE_ID", &case_id),
+        ("EVIDENCE_UID", &evidence_uid),
+        ("EVIDENCE_PATH", &evidence_path),
+        ("OUTPUT_DIR", &output_dir),
+    ]
+    .iter()
+    .filter(|(_, value)| value.as_str() == "NOT_SET")
+    .map(|(name, _)| *name)
+    .collect();
+    if !missing.is_empty() {
+        eprintln!("✗ Missing environment variables: {}", missing.join(", "));
+        std::process::exit(1);
+    }
+
     // Test output directory write
-    if output_dir != "NOT_SET" {
+    {
         let output_path = Path::new(&output_dir).join("test_output_rust.txt");
         match File::create(&output_path) {
             Ok(mut file) => {
@@ -35,13 +50,17 @@ fn main() {
                 );
                 match file.write_all(content.as_bytes()) {
                     Ok(_) => println!("✓ Output file written: {:?}", output_path),
-                    Err(e) => println!("✗ Output write failed: {}", e),
+                    Err(e) => {
+                        eprintln!("✗ Output write failed: {}", e);
+                        std::process::exit(1);
+                    }
                 }
             }
-            Err(e) => println!("✗ Output file creation failed: {}", e),
+            Err(e) => {
+                eprintln!("✗ Output file creation failed: {}", e);
+                std::process::exit(1);
+            }
         }
-    } else {
-        println!("⚠ OUTPUT_DIR not set, skipping file write test");
     }
 
     println!();
@@ -0,0 +1,50 @@
+#!/usr/bin/env bash
+# Test script for the shell sandbox
+# Verifies environment variables, pre-installed tools and output writing
+
+set -euo pipefail
+
+echo "=== Shell Sandbox Test ==="
+echo "Bash version: ${BASH_VERSION}"
+echo ""
+
+# Test environment variables
+echo "=== Environment Variables ==="
+missing=()
+for name in CASE_ID EVIDENCE_UID EVIDENCE_PATH OUTPUT_DIR; do
+    value="${!name:-NOT_SET}"
+    echo "${name}: ${value}"
+    if [ "${value}" = "NOT_SET" ]; then
+        missing+=("${name}")
+    fi
+done
+echo ""
+
+if [ "${#missing[@]}" -gt 0 ]; then
+    echo "✗ Missing required environment variables: ${missing[*]}"
+    exit 1
+fi
+
+# Test pre-installed tools
+for tool in tshark bulk_extractor strings fls yara exiftool sqlite3 7z jq; do
+    if command -v "${tool}" >/dev/null; then
+        echo "✓ ${tool} found"
+    else
+        echo "✗ ${tool} not found"
+    fi
+done
+
+# Test output directory write
+output_file="${OUTPUT_DIR}/test_output_shell.txt"
+{
+    echo "Test output from shell sandbox"
+    echo "Case ID: ${CASE_ID}"
+    echo "Evidence UID: ${EVIDENCE_UID}"
+    echo "Timestamp: $(date -u +%Y-%m-%dT%H:%M:%SZ)"
+} > "${output_file}"
+echo "✓ Output file written: ${output_file}"
+
+echo ""
+echo "=== Test Complete ==="
+echo "Exit code: 0"
+exit 0